@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/opensourceghana/securechat/pkg/network"
@@ -12,16 +13,48 @@ import (
 
 func main() {
 	var (
-		addr = flag.String("addr", "0.0.0.0", "Server address")
-		port = flag.Int("port", 8080, "Server port")
+		addr       = flag.String("addr", "0.0.0.0", "Server address")
+		port       = flag.Int("port", 8080, "Server port")
+		adminAddr  = flag.String("admin-addr", "", "Admin API listen address, e.g. 127.0.0.1:9090 (disabled if empty)")
+		adminToken = flag.String("admin-token", os.Getenv("SECURECHAT_ADMIN_TOKEN"), "Admin API bearer token")
+		rateLimit  = flag.Float64("rate-limit", 0, "Messages per second allowed per client (0 = unlimited)")
+		rateBurst  = flag.Int("rate-burst", 20, "Message burst allowed per client")
+		identity   = flag.String("identity", "relay-identity.pem", "Relay identity key file, generated on first run (unsigned hello if empty)")
+		delivery   = flag.String("delivery-mode", string(network.DeliveryStoreAndForward), "Messages for offline users: store-and-forward keeps them, immediate drops them")
+		peers      = flag.String("peers", "", "Comma-separated URLs of peer relays offered messages for users not connected here, e.g. https://relay2.example.org:8080")
+		fedToken   = flag.String("federation-token", os.Getenv("SECURECHAT_FEDERATION_TOKEN"), "Token shared with peer relays (federation disabled if empty)")
 	)
 	flag.Parse()
 
+	var forwarder network.Forwarder
+	if *peers != "" {
+		if *fedToken == "" {
+			log.Fatalf("-peers requires a federation token")
+		}
+		forwarder = network.NewRelayForwarder(strings.Split(*peers, ","), *fedToken)
+	}
+
 	// Create server
-	server := network.NewServer(network.ServerOptions{
-		Addr: *addr,
-		Port: *port,
+	server, err := network.NewServer(network.ServerOptions{
+		Addr:       *addr,
+		Port:       *port,
+		AdminAddr:  *adminAddr,
+		AdminToken: *adminToken,
+		RateLimit: network.RateLimit{
+			MessagesPerSecond: *rateLimit,
+			Burst:             *rateBurst,
+		},
+		IdentityPath:    *identity,
+		DeliveryMode:    network.DeliveryMode(*delivery),
+		Forwarder:       forwarder,
+		FederationToken: *fedToken,
 	})
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	if fingerprint := server.Fingerprint(); fingerprint != "" {
+		log.Printf("Relay identity fingerprint: %s", fingerprint)
+	}
 
 	// Handle shutdown gracefully
 	sigChan := make(chan os.Signal, 1)
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"time"
+	// Named timezones work on systems without a zone database
+	_ "time/tzdata"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/internal/redact"
 	"github.com/opensourceghana/securechat/pkg/core"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+	"github.com/opensourceghana/securechat/pkg/network"
 	"github.com/opensourceghana/securechat/pkg/ui"
 )
 
@@ -25,6 +32,10 @@ func main() {
 		configPath = flag.String("config", "", "Path to configuration file")
 		showVersion = flag.Bool("version", false, "Show version information")
 		debug      = flag.Bool("debug", false, "Enable debug mode")
+		logPlaintext = flag.Bool("log-plaintext", false, "Include message content in logs (never use with real conversations)")
+		selfTest   = flag.Bool("selftest", false, "Check that this build's cryptography works, then exit")
+		repair     = flag.Bool("repair", false, "Check stored records for corruption and list them, then exit")
+		quarantine = flag.Bool("quarantine", false, "With -repair, move corrupt records aside so they are no longer read")
 	)
 	flag.Parse()
 
@@ -34,9 +45,12 @@ func main() {
 		fmt.Printf("Built: %s\n", date)
 		os.Exit(0)
 	}
+	if *selfTest {
+		os.Exit(runSelfTest())
+	}
 
 	// Load configuration
-	cfg, err := loadConfig(*configPath)
+	cfg, cfgPath, err := loadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -44,11 +58,34 @@ func main() {
 	if *debug {
 		cfg.Debug = true
 	}
+	if *logPlaintext {
+		redact.SetPlaintext(true)
+		log.Printf("Warning: message content will be written to the log")
+	}
 
 	// Generate user ID if not set
 	if cfg.User.ID == "" {
 		cfg.User.ID = fmt.Sprintf("user_%d", time.Now().Unix())
 	}
+	if cfg.User.DeviceID == "" {
+		initDevice(cfg, cfgPath)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if *repair {
+		os.Exit(runRepair(cfg, *quarantine))
+	}
+	if err := core.CheckConfigPermissions(cfgPath, cfg.Security.InsecurePermissions); err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	// Initialize the TUI application first, so invalid key bindings are
+	// reported before any storage is opened
+	uiApp, err := ui.NewApp(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize user interface: %v", err)
+	}
 
 	// Initialize the core application
 	coreApp, err := core.NewApp(cfg)
@@ -57,8 +94,43 @@ func main() {
 	}
 	defer coreApp.Close()
 
-	// Initialize the TUI application
-	uiApp := ui.NewApp(cfg)
+	// Wire the TUI application to the core
+	uiApp.SetHistoryLoader(coreApp.GetMessagesBefore)
+	uiApp.SetContactLookup(coreApp.GetContact)
+	uiApp.SetMessageLookup(coreApp.GetMessage)
+	uiApp.SetAttachmentOpener(coreApp.OpenAttachment)
+	uiApp.SetContactLister(coreApp.GetContacts)
+	uiApp.SetMessageForwarder(coreApp.ForwardMessage)
+	uiApp.SetMessageSearcher(coreApp.SearchChat)
+	uiApp.SetActiveChatSetter(coreApp.SetActiveChat)
+	uiApp.SetMessageSender(coreApp.SendComposedMessage)
+	uiApp.SetKeyDecider(func(userID string, accept bool) error {
+		if accept {
+			return coreApp.AcceptPendingKey(userID)
+		}
+		return coreApp.RejectPendingKey(userID)
+	})
+	uiApp.SetFileOfferDecider(func(transferID string, accept bool) error {
+		if accept {
+			return coreApp.AcceptFileOffer(transferID)
+		}
+		return coreApp.DeclineFileOffer(transferID)
+	})
+	uiApp.SetEncryptionLookup(coreApp.EncryptionState)
+	uiApp.SetSecurityStatusLookup(coreApp.SecurityStatus)
+	uiApp.SetOutboxLister(coreApp.Outbox)
+	uiApp.SetQueuedMessageCanceler(coreApp.CancelQueuedMessage)
+	uiApp.SetVisibilitySetter(func(invisible bool) {
+		if err := coreApp.SetInvisible(invisible); err != nil {
+			log.Printf("Warning: failed to update presence: %v", err)
+		}
+		if err := saveInvisible(cfgPath, invisible); err != nil {
+			log.Printf("Warning: failed to save appear offline setting: %v", err)
+		}
+	})
+	if contacts := coreApp.GetContacts(); len(contacts) > 0 {
+		uiApp.SetContacts(contacts)
+	}
 	
 	// Connect core app to UI (simplified integration)
 	// In a full implementation, we'd have proper event channels
@@ -67,7 +139,14 @@ func main() {
 	if len(cfg.Network.RelayServers) > 0 {
 		log.Printf("Connecting to relay server...")
 		if err := coreApp.Connect(); err != nil {
-			log.Printf("Warning: Failed to connect to relay server: %v", err)
+			switch {
+			case errors.Is(err, network.ErrInvalidServerURL):
+				log.Printf("Warning: relay server URL in config is invalid: %v", err)
+			case errors.Is(err, network.ErrHandshakeTimeout):
+				log.Printf("Warning: relay server did not respond in time: %v", err)
+			default:
+				log.Printf("Warning: Failed to connect to relay server: %v", err)
+			}
 		}
 	}
 
@@ -77,6 +156,58 @@ func main() {
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
+	
+	// Keep the views in step with presence and key changes
+	coreApp.AddMessageHandler(func(msg *models.Message) error {
+		p.Send(ui.MessageUpdatedMsg{Message: *msg})
+		return nil
+	})
+	coreApp.AddContactEventHandler(func(event core.ContactEvent) {
+		switch event.Type {
+		case core.ContactAdded:
+			p.Send(ui.ContactAddedMsg{Contact: event.Contact})
+		case core.ContactRemoved:
+			p.Send(ui.ContactRemovedMsg{UserID: event.Contact.UserID})
+		default:
+			p.Send(ui.ContactUpdatedMsg{Contact: event.Contact})
+		}
+	})
+	coreApp.AddDowngradeHandler(func(downgrade core.EncryptionDowngrade) {
+		p.Send(ui.EncryptionDowngradeMsg{UserID: downgrade.UserID, Refused: downgrade.Refused})
+	})
+	coreApp.AddSessionStateHandler(func(userID string, state models.EncryptionState) {
+		p.Send(ui.EncryptionStateMsg{UserID: userID, State: state})
+	})
+	coreApp.AddMessageDropHandler(func(drop core.MessageDrop) {
+		p.Send(ui.MessagesDroppedMsg{UserID: drop.UserID, Count: drop.Count, Reason: drop.Reason.Error()})
+	})
+	coreApp.AddMessageGapHandler(func(gap core.MessageGap) {
+		p.Send(ui.MessagesMissingMsg{UserID: gap.UserID, Count: gap.Missing})
+	})
+	coreApp.AddFileOfferHandler(func(offer core.FileOffer) {
+		p.Send(ui.FileOfferMsg{TransferID: offer.TransferID, From: offer.From, Filename: offer.Filename, Size: offer.Size})
+	})
+	coreApp.AddAnnouncementHandler(func(announcement core.Announcement) {
+		p.Send(ui.AnnouncementMsg{Relay: announcement.Relay, Text: announcement.Text})
+	})
+	coreApp.AddSyncHandler(func(progress network.SyncProgress) {
+		p.Send(ui.SyncProgressMsg{Delivered: progress.Delivered, Total: progress.Total})
+	})
+	coreApp.AddOutboxHandler(func(queued []*models.Message) {
+		p.Send(ui.OutboxUpdatedMsg{Messages: queued})
+	})
+	coreApp.AddNotificationHandler(showNotification)
+	coreApp.AddConnectionStateHandler(func(state network.ConnectionState) {
+		msg := ui.ConnectionStateMsg{Connected: state.Connected()}
+		if state.Status == network.StatusReconnecting {
+			msg.ReconnectAttempt = state.ReconnectAttempt
+		}
+		p.Send(msg)
+	})
+	coreApp.AddConnectionQualityHandler(func(quality network.ConnectionQuality) {
+		p.Send(ui.ConnectionQualityMsg{RTT: quality.RTT, Level: string(quality.Level), Degraded: quality.Degraded})
+	})
+	uiApp.SetConnected(coreApp.IsConnected())
 
 	// Run the program
 	if _, err := p.Run(); err != nil {
@@ -84,12 +215,32 @@ func main() {
 	}
 }
 
-func loadConfig(configPath string) (*config.Config, error) {
+// runSelfTest prints the result of each cryptography self-test check and
+// returns the exit status: 0 if all passed, 1 otherwise
+func runSelfTest() int {
+	status := 0
+	for _, result := range crypto.SelfTest() {
+		if result.Err != nil {
+			fmt.Printf("FAIL  %s: %v\n", result.Name, result.Err)
+			status = 1
+			continue
+		}
+		fmt.Printf("PASS  %s\n", result.Name)
+	}
+	if status != 0 {
+		fmt.Println("Self-test failed: do not use this build")
+	}
+	return status
+}
+
+// loadConfig loads the configuration and returns the path settings are
+// saved to, which is the default location if no file exists yet
+func loadConfig(configPath string) (*config.Config, string, error) {
 	if configPath == "" {
 		// Try default locations
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get home directory: %w", err)
+			return nil, "", fmt.Errorf("failed to get home directory: %w", err)
 		}
 
 		candidates := []string{
@@ -108,8 +259,47 @@ func loadConfig(configPath string) (*config.Config, error) {
 
 	if configPath == "" {
 		// Use default configuration
-		return config.Default(), nil
+		cfg := config.Default()
+		return cfg, filepath.Join(cfg.GetConfigDir(), "config.yaml"), nil
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	return cfg, configPath, err
+}
+
+// initDevice gives this install a device ID and, unless one is set, its
+// host name as device name, and saves them so they stay the same
+func initDevice(cfg *config.Config, path string) {
+	cfg.User.DeviceID = models.NewDeviceID()
+	if cfg.User.DeviceName == "" {
+		cfg.User.DeviceName, _ = os.Hostname()
+	}
+
+	saved, err := config.LoadFromFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		saved, err = config.Default(), nil
+	}
+	if err == nil {
+		saved.User.DeviceID = cfg.User.DeviceID
+		saved.User.DeviceName = cfg.User.DeviceName
+		err = saved.SaveToFile(path)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save device ID: %v", err)
+	}
+}
+
+// saveInvisible persists the appear offline setting. The file is loaded
+// again so that flags and generated values of this run are not written.
+func saveInvisible(path string, invisible bool) error {
+	cfg, err := config.LoadFromFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		cfg, err = config.Default(), nil
+	}
+	if err != nil {
+		return err
 	}
 
-	return config.LoadFromFile(configPath)
+	cfg.Security.Invisible = invisible
+	return cfg.SaveToFile(path)
 }
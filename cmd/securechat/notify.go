@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/opensourceghana/securechat/pkg/core"
+)
+
+// notificationFailure makes sure a missing notification tool is only
+// reported once
+var notificationFailure sync.Once
+
+// showNotification shows a desktop notification with the system's own
+// tool: notify-send on Linux and the BSDs, osascript on macOS. Other
+// systems get no desktop notifications.
+func showNotification(notification core.Notification) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + strconv.Quote(notification.Title()) + ` with title "SecureChat"`
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		return
+	default:
+		cmd = exec.Command("notify-send", "--app-name=SecureChat", "SecureChat", notification.Title())
+	}
+
+	if err := cmd.Run(); err != nil {
+		notificationFailure.Do(func() {
+			log.Printf("Warning: failed to show desktop notification: %v", err)
+		})
+	}
+}
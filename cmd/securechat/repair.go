@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// runRepair checks every stored record and lists those that are corrupt,
+// moving them aside if quarantine is set. SecureChat must not be running.
+// It returns the exit status, which is 1 while corrupt records remain.
+func runRepair(cfg *config.Config, quarantine bool) int {
+	store, err := storage.Open(storage.StorageOptions{
+		DataDir: cfg.GetDataDir(),
+		UserID:  cfg.User.ID,
+		Backend: storage.Backend(cfg.Storage.Backend),
+	})
+	if err != nil {
+		fmt.Printf("Failed to open storage (is SecureChat running?): %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	report, err := store.Repair(quarantine)
+	if report != nil {
+		for _, record := range report.Corrupt {
+			fmt.Printf("CORRUPT  %s: %v\n", record.Key, record.Err)
+		}
+		fmt.Printf("Checked %d records: %d corrupt, %d quarantined\n", report.Checked, len(report.Corrupt), report.Quarantined)
+	}
+	if err != nil {
+		fmt.Printf("Repair failed: %v\n", err)
+		return 1
+	}
+	if len(report.Corrupt) > report.Quarantined {
+		fmt.Println("Run again with -quarantine to move the corrupt records aside")
+		return 1
+	}
+	return 0
+}
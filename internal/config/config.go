@@ -1,20 +1,31 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/opensourceghana/securechat/internal/models"
 	"gopkg.in/yaml.v3"
 )
 
+// maxReconnectDelay bounds the reconnect delay; the client never waits
+// longer than this between attempts anyway
+const maxReconnectDelay = time.Minute
+
 // Config represents the application configuration
 type Config struct {
 	User     UserConfig     `yaml:"user"`
 	Network  NetworkConfig  `yaml:"network"`
 	UI       UIConfig       `yaml:"ui"`
 	Security SecurityConfig `yaml:"security"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty"`
 	Debug    bool           `yaml:"debug"`
 }
 
@@ -23,15 +34,51 @@ type UserConfig struct {
 	ID            string `yaml:"id"`
 	DisplayName   string `yaml:"display_name"`
 	StatusMessage string `yaml:"status_message"`
+	
+	// Identify this install on the messages sent from it. The ID is
+	// generated on first run; the name defaults to the host name.
+	DeviceID   string `yaml:"device_id,omitempty"`
+	DeviceName string `yaml:"device_name,omitempty"`
 }
 
 // NetworkConfig contains network-related settings
 type NetworkConfig struct {
 	RelayServers      []string      `yaml:"relay_servers"`
+	// Pinned relay fingerprints keyed by relay address, as logged by the relay
+	RelayFingerprints map[string]string `yaml:"relay_fingerprints,omitempty"`
+	// Names the TLS certificates of wss relays must be valid for, keyed by
+	// relay address, when they differ from the host dialed
+	RelayServerNames map[string]string `yaml:"relay_server_names,omitempty"`
 	P2PEnabled        bool          `yaml:"p2p_enabled"`
 	ConnectionTimeout time.Duration `yaml:"connection_timeout"`
+	DeliveryTimeout   time.Duration `yaml:"delivery_timeout"` // 0 disables
+	HandshakeTimeout  time.Duration `yaml:"handshake_timeout"` // 0 retries forever
+	// Messages to one contact that may wait for a session, and how long
+	// each may wait; the oldest fail first. A TTL of 0 disables the limit.
+	SessionQueueLimit int           `yaml:"session_queue_limit"`
+	SessionQueueTTL   time.Duration `yaml:"session_queue_ttl"`
 	Port              int           `yaml:"port"`
 	BindAddress       string        `yaml:"bind_address"`
+
+	// Proxy relay connections go through: an http:// URL of an HTTP CONNECT
+	// proxy, a socks5:// URL or "direct". Empty uses $HTTPS_PROXY,
+	// $HTTP_PROXY and $ALL_PROXY.
+	Proxy string `yaml:"proxy,omitempty"`
+
+	// Reconnection after a lost connection. Attempt n waits n times the
+	// delay, up to a minute.
+	MaxReconnectAttempts int           `yaml:"max_reconnect_attempts"`
+	ReconnectDelay       time.Duration `yaml:"reconnect_delay"`
+	ReconnectUnlimited   bool          `yaml:"reconnect_unlimited"` // Ignores MaxReconnectAttempts
+
+	// Interval of the liveness line logged for unattended clients; 0 disables
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+
+	// Messages sent within BatchWindow of each other go to a relay in one
+	// frame, up to BatchSize at a time (0 uses the client's default), if
+	// the relay supports it. A window of 0 sends each message alone.
+	BatchWindow time.Duration `yaml:"batch_window"`
+	BatchSize   int           `yaml:"batch_size,omitempty"`
 }
 
 // UIConfig contains user interface settings
@@ -39,21 +86,347 @@ type UIConfig struct {
 	Theme           string `yaml:"theme"`
 	Notifications   bool   `yaml:"notifications"`
 	SoundEnabled    bool   `yaml:"sound_enabled"`
+	
+	// Silences notifications without turning them off
+	DoNotDisturb bool `yaml:"do_not_disturb"`
+	
+	// Messages from a contact within NotificationWindow of the first are
+	// grouped into one notification, and notifications are at least
+	// NotificationInterval apart
+	NotificationWindow   time.Duration `yaml:"notification_window"`
+	NotificationInterval time.Duration `yaml:"notification_interval"`
 	TimestampFormat string `yaml:"timestamp_format"`
+	
+	// Timezone times are shown in: "local", "UTC" or a zone name such as
+	// "Africa/Accra"
+	Timezone string `yaml:"timezone"`
+	
+	// Message times younger than this are shown as "2 minutes ago" instead
+	// of in TimestampFormat; 0 always uses TimestampFormat
+	RelativeTimestamps time.Duration `yaml:"relative_timestamps"`
 	ShowTyping      bool   `yaml:"show_typing"`
 	CompactMode     bool   `yaml:"compact_mode"`
+	MessageLayout   MessageLayout `yaml:"message_layout"`
+	HistoryPageSize int    `yaml:"history_page_size"`
+
+	// Characters of a message shown in one-line previews, such as reply
+	// quotes and the outbox, before it is cut off
+	PreviewLength int `yaml:"preview_length"`
+
+	// How identity fingerprints are shown: the number of bytes, 8, 16 or
+	// 32, and "hex" or "base32". Comparisons always use the full fingerprint.
+	FingerprintBytes  int               `yaml:"fingerprint_bytes"`
+	FingerprintFormat FingerprintFormat `yaml:"fingerprint_format"`
+
+	// Editor is the command messages are written in when opened from the
+	// chat view, such as "vim" or "code --wait"; empty uses $VISUAL, then
+	// $EDITOR
+	Editor string `yaml:"editor,omitempty"`
+
+	// KeyBindings rebinds actions to keys, overriding the defaults
+	KeyBindings map[string][]string `yaml:"key_bindings,omitempty"`
+}
+
+// FingerprintFormat is how fingerprints are written out for display
+type FingerprintFormat string
+
+const (
+	// FingerprintHex shows fingerprints as hex digits
+	FingerprintHex FingerprintFormat = "hex"
+	// FingerprintBase32 shows fingerprints in RFC 4648 base32, which is
+	// shorter and avoids characters that are easily confused
+	FingerprintBase32 FingerprintFormat = "base32"
+)
+
+// Valid reports whether f is a known format
+func (f FingerprintFormat) Valid() bool {
+	switch f {
+	case FingerprintHex, FingerprintBase32:
+		return true
+	}
+	return false
+}
+
+// MessageLayout is how messages are laid out in a chat
+type MessageLayout string
+
+const (
+	// LayoutFlat shows every message left-aligned under its sender and time
+	LayoutFlat MessageLayout = "flat"
+	// LayoutBubbles boxes each message, the user's own on the right and
+	// everyone else's on the left
+	LayoutBubbles MessageLayout = "bubbles"
+)
+
+// Valid reports whether l is a known layout
+func (l MessageLayout) Valid() bool {
+	switch l {
+	case LayoutFlat, LayoutBubbles:
+		return true
+	}
+	return false
 }
 
 // SecurityConfig contains security-related settings
 type SecurityConfig struct {
-	AutoAcceptKeys       bool   `yaml:"auto_accept_keys"`
+	AutoAcceptKeys       KeyAcceptance `yaml:"auto_accept_keys"`
 	MessageRetentionDays int    `yaml:"message_retention_days"`
 	ExportKeysPath       string `yaml:"export_keys_path"`
 	RequireVerification  bool   `yaml:"require_verification"`
+	HideLastSeen         bool   `yaml:"hide_last_seen"`
+	Invisible            bool   `yaml:"invisible"`
+	// Allow chats with users who are not contacts. Sending to one adds
+	// them as an unknown contact; when off, only contacts can be messaged.
+	MessageUnknownUsers bool `yaml:"message_unknown_users"`
+	// Preferred message cipher, "chacha20-poly1305" or "aes-256-gcm".
+	// New sessions use it if the contact supports it.
+	Cipher string `yaml:"cipher"`
+	// Sessions older than SessionMaxAge, or that have carried
+	// SessionMaxMessages messages, are replaced by a new key exchange the
+	// next time a message is sent; 0 disables either limit
+	SessionMaxAge      time.Duration `yaml:"session_max_age"`
+	SessionMaxMessages int           `yaml:"session_max_messages"`
+	// What happens at startup when other users can access the data or
+	// config directory
+	InsecurePermissions PermissionPolicy `yaml:"insecure_permissions"`
+	// Where the message search index is kept, "memory" or "blinded"
+	SearchIndex SearchIndexMode `yaml:"search_index"`
+}
+
+// StorageConfig contains local storage settings
+type StorageConfig struct {
+	// Where messages, contacts and keys are kept, "badger", "sqlite" or
+	// "memory"
+	Backend StorageBackend `yaml:"backend"`
+
+	// Limits on what is stored; 0 is unlimited. Adding a contact beyond
+	// MaxContacts fails, and once the database has grown to MaxSizeMB new
+	// messages are refused.
+	MaxContacts        int `yaml:"max_contacts,omitempty"`
+	MaxMessagesPerChat int `yaml:"max_messages_per_chat,omitempty"`
+	MaxSizeMB          int `yaml:"max_size_mb,omitempty"`
+
+	// What a new message does to a chat holding max_messages_per_chat
+	OnFullChat FullChatPolicy `yaml:"on_full_chat"`
+
+	// Badger tuning; zero values keep Badger's defaults. NoSyncWrites
+	// skips fsync on every write, which is faster but may lose the latest
+	// writes on a crash. A negative GCInterval turns value-log GC off.
+	NoSyncWrites   bool          `yaml:"no_sync_writes,omitempty"`
+	MemTableSizeMB int           `yaml:"memtable_size_mb,omitempty"`
+	GCInterval     time.Duration `yaml:"gc_interval,omitempty"`
+	GCDiscardRatio float64       `yaml:"gc_discard_ratio,omitempty"`
+}
+
+// FullChatPolicy is what happens to a message saved to a full chat
+type FullChatPolicy string
+
+const (
+	// FullChatEvict deletes the oldest messages of the chat to make room
+	FullChatEvict FullChatPolicy = "evict"
+	// FullChatReject refuses the new message
+	FullChatReject FullChatPolicy = "reject"
+)
+
+// Valid reports whether p is a known policy
+func (p FullChatPolicy) Valid() bool {
+	switch p {
+	case FullChatEvict, FullChatReject:
+		return true
+	}
+	return false
+}
+
+// StorageBackend names the store messages, contacts and keys are kept in
+type StorageBackend string
+
+const (
+	// StorageBadger keeps them in a database in the data directory
+	StorageBadger StorageBackend = "badger"
+	// StorageMemory keeps them in memory only, so they are lost on exit,
+	// the identity included
+	StorageMemory StorageBackend = "memory"
+	// StorageSQLite keeps them in a SQLite database file in the data
+	// directory
+	StorageSQLite StorageBackend = "sqlite"
+)
+
+// Valid reports whether b is a known backend
+func (b StorageBackend) Valid() bool {
+	switch b {
+	case StorageBadger, StorageMemory, StorageSQLite:
+		return true
+	}
+	return false
+}
+
+// WebhookConfig is a URL that receives a signed JSON POST on events
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Events to send; empty sends all of them
+	Events []WebhookEvent `yaml:"events,omitempty"`
+	// Key of the HMAC-SHA256 signature in the X-SecureChat-Signature
+	// header; empty sends payloads unsigned
+	Secret string `yaml:"secret,omitempty"`
+	// Include message content in payloads. Off by default, as the content
+	// leaves the end-to-end encryption.
+	IncludeContent bool `yaml:"include_content,omitempty"`
+}
+
+// WebhookEvent names an event webhooks can be sent for
+type WebhookEvent string
+
+const (
+	// WebhookMessage is sent for every message received from a contact
+	// who is not blocked
+	WebhookMessage WebhookEvent = "message"
+	// WebhookKeyChange is sent when a contact's identity key changes
+	WebhookKeyChange WebhookEvent = "key_change"
+	// WebhookConnectionLost is sent when the last connected relay drops
+	WebhookConnectionLost WebhookEvent = "connection_lost"
+)
+
+// Valid reports whether e is a known event
+func (e WebhookEvent) Valid() bool {
+	switch e {
+	case WebhookMessage, WebhookKeyChange, WebhookConnectionLost:
+		return true
+	}
+	return false
+}
+
+// Wants reports whether the webhook is sent for an event
+func (w WebhookConfig) Wants(event WebhookEvent) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// validate checks the URL and events of a webhook
+func (w WebhookConfig) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q: use an http:// or https:// URL", w.URL)
+	}
+	for _, event := range w.Events {
+		if !event.Valid() {
+			return fmt.Errorf("invalid webhook event %q: use \"message\", \"key_change\" or \"connection_lost\"", event)
+		}
+	}
+	return nil
+}
+
+// SearchIndexMode says where the message search index is kept
+type SearchIndexMode string
+
+const (
+	// SearchIndexMemory keeps the index in memory only, rebuilt from the
+	// stored messages at startup
+	SearchIndexMemory SearchIndexMode = "memory"
+	// SearchIndexBlinded persists the index with every word replaced by a
+	// keyed hash, so large histories need not be read at startup
+	SearchIndexBlinded SearchIndexMode = "blinded"
+)
+
+// Valid reports whether m is a known mode
+func (m SearchIndexMode) Valid() bool {
+	switch m {
+	case SearchIndexMemory, SearchIndexBlinded:
+		return true
+	}
+	return false
+}
+
+// PermissionPolicy says how loose permissions on the data and config
+// directories are handled
+type PermissionPolicy string
+
+const (
+	// PermissionsWarn logs a warning and carries on
+	PermissionsWarn PermissionPolicy = "warn"
+	// PermissionsRefuse does not start until the permissions are fixed
+	PermissionsRefuse PermissionPolicy = "refuse"
+	// PermissionsFix removes the group and other permissions
+	PermissionsFix PermissionPolicy = "fix"
+)
+
+// Valid reports whether p is a known policy
+func (p PermissionPolicy) Valid() bool {
+	switch p {
+	case PermissionsWarn, PermissionsRefuse, PermissionsFix:
+		return true
+	}
+	return false
+}
+
+// KeyAcceptance says what happens when a contact presents a new or changed
+// identity key
+type KeyAcceptance string
+
+const (
+	// KeyAcceptNever records new keys unverified and blocks sending to a
+	// contact whose key changed until it is verified
+	KeyAcceptNever KeyAcceptance = "never"
+	// KeyAcceptAsk holds new and changed keys until the user accepts or
+	// rejects them
+	KeyAcceptAsk KeyAcceptance = "ask"
+	// KeyAcceptAlways accepts new and changed keys without asking. Changes
+	// are still recorded in the audit trail.
+	KeyAcceptAlways KeyAcceptance = "always"
+)
+
+// UnmarshalYAML reads a key acceptance mode. Older versions stored a
+// boolean, which maps to always (true) and never (false).
+func (k *KeyAcceptance) UnmarshalYAML(value *yaml.Node) error {
+	if value.ShortTag() == "!!bool" {
+		var enabled bool
+		if err := value.Decode(&enabled); err != nil {
+			return err
+		}
+		*k = KeyAcceptNever
+		if enabled {
+			*k = KeyAcceptAlways
+		}
+		return nil
+	}
+
+	var mode string
+	if err := value.Decode(&mode); err != nil {
+		return err
+	}
+	*k = KeyAcceptance(mode)
+	return nil
+}
+
+// Valid reports whether k is a known mode
+func (k KeyAcceptance) Valid() bool {
+	switch k {
+	case KeyAcceptNever, KeyAcceptAsk, KeyAcceptAlways:
+		return true
+	}
+	return false
+}
+
+// cipherNames are the message ciphers Security.Cipher may name
+var cipherNames = []string{"chacha20-poly1305", "aes-256-gcm"}
+
+// validCipher reports whether name is a known message cipher. An empty
+// setting means the default.
+func validCipher(name string) bool {
+	return name == "" || slices.Contains(cipherNames, name)
+}
+
+// validProxy reports whether setting is a proxy relay connections can go
+// through: empty, "direct", or an http:// or socks5:// URL with a host
+func validProxy(setting string) bool {
+	if setting == "" || setting == "direct" {
+		return true
+	}
+	u, err := url.Parse(setting)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "socks5") && u.Host != ""
 }
 
-// Default returns a configuration with sensible defaults
-func Default() *Config {
+// builtinDefaults returns the defaults used where the embedded defaults set
+// nothing
+func builtinDefaults() *Config {
 	homeDir, _ := os.UserHomeDir()
 	
 	return &Config{
@@ -68,47 +441,122 @@ func Default() *Config {
 			},
 			P2PEnabled:        true,
 			ConnectionTimeout: 30 * time.Second,
+			DeliveryTimeout:   2 * time.Minute,
+			HandshakeTimeout:  time.Hour,
+			SessionQueueLimit: 100,
+			SessionQueueTTL:   24 * time.Hour,
 			Port:              8080,
 			BindAddress:       "0.0.0.0",
+
+			MaxReconnectAttempts: 10,
+			ReconnectDelay:       5 * time.Second,
+			ReconnectUnlimited:   false,
 		},
 		UI: UIConfig{
 			Theme:           "dark",
 			Notifications:   true,
 			SoundEnabled:    false,
 			TimestampFormat: "15:04",
+			Timezone:        "local",
 			ShowTyping:      true,
 			CompactMode:     false,
+			MessageLayout:   LayoutFlat,
+			HistoryPageSize: 50,
+			PreviewLength:   50,
+
+			NotificationWindow:   3 * time.Second,
+			NotificationInterval: 10 * time.Second,
+
+			FingerprintBytes:  16,
+			FingerprintFormat: FingerprintBase32,
 		},
 		Security: SecurityConfig{
-			AutoAcceptKeys:       false,
+			AutoAcceptKeys:       KeyAcceptNever,
 			MessageRetentionDays: 30,
 			ExportKeysPath:       filepath.Join(homeDir, ".config", "securechat", "keys"),
 			RequireVerification:  true,
+			HideLastSeen:         false,
+			Invisible:            false,
+			MessageUnknownUsers:  true,
+			Cipher:               "chacha20-poly1305",
+			SessionMaxAge:        7 * 24 * time.Hour,
+			SessionMaxMessages:   1000,
+			InsecurePermissions:  PermissionsWarn,
+			SearchIndex:          SearchIndexMemory,
+		},
+		Storage: StorageConfig{
+			Backend:    StorageBadger,
+			OnFullChat: FullChatEvict,
 		},
 		Debug: false,
 	}
 }
 
-// LoadFromFile loads configuration from a YAML file
+// LoadFromFile loads configuration from a YAML file. If the file is corrupt,
+// the backup kept by SaveToFile is loaded instead.
 func LoadFromFile(path string) (*Config, error) {
+	cfg, err := loadFile(path)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return cfg, err
+	}
+
+	backup, backupErr := loadFile(backupPath(path))
+	if backupErr != nil {
+		return nil, err
+	}
+
+	log.Printf("Warning: %v; using backup %s", err, backupPath(path))
+	return backup, nil
+}
+
+// loadFile reads and parses a single configuration file
+func loadFile(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	cfg := Default()
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	cfg, unknown, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Unknown keys are usually typos; they are reported rather than rejected
+	// so a config written for a newer version still loads
+	for _, key := range unknown {
+		log.Printf("Warning: ignoring unknown key %s in config file %s", key, path)
 	}
 
 	return cfg, nil
 }
 
-// SaveToFile saves the configuration to a YAML file
+// parse decodes YAML over the default configuration. It also returns the
+// keys that do not match any setting, which are otherwise ignored.
+func parse(data []byte) (*Config, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cfg.User.ID = models.NormalizeUserID(cfg.User.ID)
+
+	return cfg, unknownKeys(&doc), nil
+}
+
+// SaveToFile saves the configuration to a YAML file. The file is replaced
+// atomically, and the previous version is kept alongside it with a .bak
+// suffix so a bad save can be recovered.
 func (c *Config) SaveToFile(path string) error {
 	// Ensure directory exists
 	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
@@ -117,15 +565,66 @@ func (c *Config) SaveToFile(path string) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0600); err != nil {
+	// Only back up a previous version that is worth restoring, so a corrupt
+	// primary never replaces a good backup
+	if previous, err := os.ReadFile(path); err == nil {
+		if _, _, err := parse(previous); err == nil {
+			if err := writeFileAtomic(backupPath(path), previous, 0600); err != nil {
+				return fmt.Errorf("failed to back up config file: %w", err)
+			}
+		}
+	}
+
+	if err := writeFileAtomic(path, data, 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
 	return nil
 }
 
+// backupPath returns the path of the backup kept for a config file
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+// writeFileAtomic writes data to a temporary file in the target directory,
+// syncs it and renames it over path, so readers see either the old or the
+// new contents and never a partial write
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
+	if c.User.ID != "" {
+		if err := models.ValidateUserID(c.User.ID); err != nil {
+			return err
+		}
+	}
+
 	if c.User.DisplayName == "" {
 		return fmt.Errorf("user display name cannot be empty")
 	}
@@ -138,10 +637,112 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("connection timeout must be positive")
 	}
 
+	if c.Network.DeliveryTimeout < 0 {
+		return fmt.Errorf("delivery timeout cannot be negative")
+	}
+	if c.Network.HandshakeTimeout < 0 {
+		return fmt.Errorf("handshake timeout cannot be negative")
+	}
+	if c.Network.SessionQueueLimit <= 0 {
+		return fmt.Errorf("session queue limit must be positive")
+	}
+	if c.Network.SessionQueueTTL < 0 {
+		return fmt.Errorf("session queue TTL cannot be negative")
+	}
+
+	if !c.Network.ReconnectUnlimited && c.Network.MaxReconnectAttempts <= 0 {
+		return fmt.Errorf("max reconnect attempts must be positive unless reconnect_unlimited is set")
+	}
+
+	if c.Network.ReconnectDelay <= 0 || c.Network.ReconnectDelay > maxReconnectDelay {
+		return fmt.Errorf("reconnect delay must be positive and at most %s", maxReconnectDelay)
+	}
+
+	if c.Network.HeartbeatInterval < 0 {
+		return fmt.Errorf("heartbeat interval cannot be negative")
+	}
+	if c.Network.BatchWindow < 0 || c.Network.BatchSize < 0 {
+		return fmt.Errorf("batch window and batch size cannot be negative")
+	}
+
+	if !validProxy(c.Network.Proxy) {
+		return fmt.Errorf("invalid proxy %q: use \"direct\", an http:// or a socks5:// URL", c.Network.Proxy)
+	}
+
 	if c.Security.MessageRetentionDays < 0 {
 		return fmt.Errorf("message retention days cannot be negative")
 	}
 
+	if !c.Security.AutoAcceptKeys.Valid() {
+		return fmt.Errorf("invalid auto_accept_keys %q: use \"never\", \"ask\" or \"always\"", c.Security.AutoAcceptKeys)
+	}
+
+	if !validCipher(c.Security.Cipher) {
+		return fmt.Errorf("invalid cipher %q: use \"chacha20-poly1305\" or \"aes-256-gcm\"", c.Security.Cipher)
+	}
+	if c.Security.SessionMaxAge < 0 || c.Security.SessionMaxMessages < 0 {
+		return fmt.Errorf("session max age and max messages cannot be negative")
+	}
+
+	if !c.Security.InsecurePermissions.Valid() {
+		return fmt.Errorf("invalid insecure_permissions %q: use \"warn\", \"refuse\" or \"fix\"", c.Security.InsecurePermissions)
+	}
+	if !c.Security.SearchIndex.Valid() {
+		return fmt.Errorf("invalid search_index %q: use \"memory\" or \"blinded\"", c.Security.SearchIndex)
+	}
+	if !c.Storage.Backend.Valid() {
+		return fmt.Errorf("invalid storage backend %q: use \"badger\", \"sqlite\" or \"memory\"", c.Storage.Backend)
+	}
+	if c.Storage.MaxContacts < 0 || c.Storage.MaxMessagesPerChat < 0 || c.Storage.MaxSizeMB < 0 {
+		return fmt.Errorf("storage limits cannot be negative")
+	}
+	if !c.Storage.OnFullChat.Valid() {
+		return fmt.Errorf("invalid on_full_chat %q: use \"evict\" or \"reject\"", c.Storage.OnFullChat)
+	}
+	if c.Storage.MemTableSizeMB < 0 {
+		return fmt.Errorf("memtable_size_mb cannot be negative")
+	}
+	if c.Storage.GCDiscardRatio < 0 || c.Storage.GCDiscardRatio >= 1 {
+		return fmt.Errorf("gc_discard_ratio must be at least 0 and below 1")
+	}
+	for _, webhook := range c.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.UI.Location(); err != nil {
+		return err
+	}
+
+	if c.UI.RelativeTimestamps < 0 {
+		return fmt.Errorf("relative timestamps duration cannot be negative")
+	}
+
+	if c.UI.NotificationWindow < 0 || c.UI.NotificationInterval < 0 {
+		return fmt.Errorf("notification window and interval cannot be negative")
+	}
+
+	if c.UI.HistoryPageSize <= 0 {
+		return fmt.Errorf("history page size must be positive")
+	}
+
+	if c.UI.PreviewLength <= 0 {
+		return fmt.Errorf("preview length must be positive")
+	}
+
+	switch c.UI.FingerprintBytes {
+	case 8, 16, 32:
+	default:
+		return fmt.Errorf("invalid fingerprint_bytes %d: use 8, 16 or 32", c.UI.FingerprintBytes)
+	}
+	if !c.UI.MessageLayout.Valid() {
+		return fmt.Errorf("invalid message_layout %q: use \"flat\" or \"bubbles\"", c.UI.MessageLayout)
+	}
+	if !c.UI.FingerprintFormat.Valid() {
+		return fmt.Errorf("invalid fingerprint_format %q: use \"hex\" or \"base32\"", c.UI.FingerprintFormat)
+	}
+
 	validThemes := map[string]bool{
 		"dark":  true,
 		"light": true,
@@ -154,6 +755,20 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// Location returns the timezone times are displayed in
+func (u UIConfig) Location() (*time.Location, error) {
+	// time.LoadLocation treats "" as UTC
+	if u.Timezone == "" || u.Timezone == "local" {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: use \"local\", \"UTC\" or a zone name such as \"Africa/Accra\"", u.Timezone)
+	}
+	return location, nil
+}
+
 // GetDataDir returns the data directory for the application
 func (c *Config) GetDataDir() string {
 	homeDir, _ := os.UserHomeDir()
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// savedConfig saves a default config with the given display name to path
+func savedConfig(t *testing.T, path, displayName string) {
+	t.Helper()
+
+	cfg := Default()
+	cfg.User.DisplayName = displayName
+	if err := cfg.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+}
+
+// loadedName loads the config at path and returns its display name
+func loadedName(t *testing.T, path string) string {
+	t.Helper()
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	return cfg.User.DisplayName
+}
+
+func TestSaveToFileKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	savedConfig(t, path, "first")
+	savedConfig(t, path, "second")
+
+	if got := loadedName(t, path); got != "second" {
+		t.Errorf("loaded %q, want second", got)
+	}
+	if got := loadedName(t, backupPath(path)); got != "first" {
+		t.Errorf("backup holds %q, want first", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestInterruptedSaveLeavesOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	savedConfig(t, path, "original")
+
+	// A save killed before its rename leaves only a partial temporary file
+	partial := filepath.Join(dir, ".config.yaml.tmp-1234")
+	if err := os.WriteFile(partial, []byte("user:\n  display_name: \"half"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if got := loadedName(t, path); got != "original" {
+		t.Errorf("loaded %q, want original", got)
+	}
+
+	// A save that fails removes its temporary file and leaves the original
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "config.yaml"), []byte("x"), 0600); err == nil {
+		t.Fatal("writeFileAtomic into a missing directory succeeded")
+	}
+	if got := loadedName(t, path); got != "original" {
+		t.Errorf("after a failed save, loaded %q, want original", got)
+	}
+}
+
+func TestCorruptConfigFallsBackToBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	savedConfig(t, path, "first")
+	savedConfig(t, path, "second")
+
+	if err := os.WriteFile(path, []byte("user: [not: valid"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if got := loadedName(t, path); got != "first" {
+		t.Errorf("loaded %q from a corrupt config, want the backup's first", got)
+	}
+
+	// Saving over the corrupt config keeps the good backup
+	savedConfig(t, path, "third")
+	if got := loadedName(t, backupPath(path)); got != "first" {
+		t.Errorf("backup holds %q, want first", got)
+	}
+}
+
+func TestCorruptConfigWithoutBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("user: [not: valid"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadFromFile(path); err == nil {
+		t.Error("LoadFromFile accepted a corrupt config without a backup")
+	}
+}
+
+func TestReconnectSettingsAreValidated(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		delay     time.Duration
+		unlimited bool
+		wantErr   bool
+	}{
+		{"defaults", 10, 5 * time.Second, false, false},
+		{"no attempts", 0, 5 * time.Second, false, true},
+		{"no attempts but unlimited", 0, 5 * time.Second, true, false},
+		{"no delay", 10, 0, false, true},
+		{"delay of a minute", 10, time.Minute, false, false},
+		{"delay over a minute", 10, 2 * time.Minute, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.User.ID = "alice"
+			cfg.User.DisplayName = "Alice"
+			cfg.Network.MaxReconnectAttempts = tt.attempts
+			cfg.Network.ReconnectDelay = tt.delay
+			cfg.Network.ReconnectUnlimited = tt.unlimited
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate: %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAutoAcceptKeysReadsOlderBooleans(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		want    KeyAcceptance
+		wantErr bool
+	}{
+		{"auto_accept_keys: true", KeyAcceptAlways, false},
+		{"auto_accept_keys: false", KeyAcceptNever, false},
+		{"auto_accept_keys: ask", KeyAcceptAsk, false},
+		{"auto_accept_keys: always", KeyAcceptAlways, false},
+		{"auto_accept_keys: sometimes", "sometimes", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.yaml, func(t *testing.T) {
+			var security SecurityConfig
+			if err := yaml.Unmarshal([]byte(tt.yaml), &security); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if security.AutoAcceptKeys != tt.want {
+				t.Errorf("read %q, want %q", security.AutoAcceptKeys, tt.want)
+			}
+
+			cfg := Default()
+			cfg.User.ID = "alice"
+			cfg.User.DisplayName = "Alice"
+			cfg.Security.AutoAcceptKeys = security.AutoAcceptKeys
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate: %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A migrated config is saved as a mode
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := Default()
+	cfg.Security.AutoAcceptKeys = KeyAcceptAsk
+	if err := cfg.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(data), "auto_accept_keys: ask") {
+		t.Errorf("saved config does not hold the mode:\n%s", data)
+	}
+}
+
+func TestFingerprintSettingsAreValidated(t *testing.T) {
+	tests := []struct {
+		bytes   int
+		format  FingerprintFormat
+		wantErr bool
+	}{
+		{8, FingerprintHex, false},
+		{16, FingerprintBase32, false},
+		{32, FingerprintBase32, false},
+		{12, FingerprintHex, true},
+		{0, FingerprintBase32, true},
+		{16, "base64", true},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.User.ID = "alice"
+		cfg.User.DisplayName = "Alice"
+		cfg.UI.FingerprintBytes = tt.bytes
+		cfg.UI.FingerprintFormat = tt.format
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%d bytes in %q: Validate: %v, want error %v", tt.bytes, tt.format, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMessageLayoutIsValidated(t *testing.T) {
+	tests := []struct {
+		layout  MessageLayout
+		wantErr bool
+	}{
+		{LayoutFlat, false},
+		{LayoutBubbles, false},
+		{"", true},
+		{"cards", true},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.User.ID = "alice"
+		cfg.User.DisplayName = "Alice"
+		cfg.UI.MessageLayout = tt.layout
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("layout %q: Validate: %v, want error %v", tt.layout, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProxyIsValidated(t *testing.T) {
+	tests := []struct {
+		proxy   string
+		wantErr bool
+	}{
+		{"", false},
+		{"direct", false},
+		{"http://proxy.example:3128", false},
+		{"socks5://127.0.0.1:9050", false},
+		{"https://proxy.example", true},
+		{"socks5://", true},
+		{"proxy.example:3128", true},
+		{"http://%zz", true},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.User.ID = "alice"
+		cfg.User.DisplayName = "Alice"
+		cfg.Network.Proxy = tt.proxy
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("proxy %q: Validate: %v, want error %v", tt.proxy, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCipherIsValidated(t *testing.T) {
+	tests := []struct {
+		cipher  string
+		wantErr bool
+	}{
+		{"", false},
+		{"chacha20-poly1305", false},
+		{"aes-256-gcm", false},
+		{"AES-256-GCM", true},
+		{"rot13", true},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.User.ID = "alice"
+		cfg.User.DisplayName = "Alice"
+		cfg.Security.Cipher = tt.cipher
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("cipher %q: Validate: %v, want error %v", tt.cipher, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	_ "embed"
+	"log"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// embeddedDefaults is defaults.yaml, which distributors edit before building
+// to ship their own defaults
+//
+//go:embed defaults.yaml
+var embeddedDefaults []byte
+
+// embeddedDoc is embeddedDefaults parsed, or nil if it sets nothing or does
+// not parse
+var (
+	embeddedDoc  *yaml.Node
+	embeddedOnce sync.Once
+)
+
+// loadEmbeddedDefaults parses the embedded defaults once. Defaults that do
+// not parse are reported and ignored, leaving the built-in ones.
+func loadEmbeddedDefaults() *yaml.Node {
+	embeddedOnce.Do(func() {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(embeddedDefaults, &doc); err != nil {
+			log.Printf("Warning: ignoring embedded defaults: %v", err)
+			return
+		}
+		if len(doc.Content) == 0 {
+			return
+		}
+		if err := doc.Decode(builtinDefaults()); err != nil {
+			log.Printf("Warning: ignoring embedded defaults: %v", err)
+			return
+		}
+		for _, key := range unknownKeys(&doc) {
+			log.Printf("Warning: ignoring unknown key %s in embedded defaults", key)
+		}
+		embeddedDoc = &doc
+	})
+	return embeddedDoc
+}
+
+// Default returns a configuration with sensible defaults: the built-in ones
+// with the defaults embedded at build time applied over them
+func Default() *Config {
+	cfg := builtinDefaults()
+	if doc := loadEmbeddedDefaults(); doc != nil {
+		// Checked to decode when loaded
+		doc.Decode(cfg)
+	}
+	return cfg
+}
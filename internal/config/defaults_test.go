@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// withEmbeddedDefaults builds in data as the embedded defaults for the rest
+// of the test
+func withEmbeddedDefaults(t *testing.T, data string) {
+	t.Helper()
+
+	saved := embeddedDefaults
+	reset := func() {
+		embeddedDoc = nil
+		embeddedOnce = sync.Once{}
+	}
+	embeddedDefaults = []byte(data)
+	reset()
+	t.Cleanup(func() {
+		embeddedDefaults = saved
+		reset()
+	})
+}
+
+const distributorDefaults = `
+network:
+  relay_servers:
+    - relay.example.org:8080
+ui:
+  theme: light
+`
+
+func TestEmbeddedDefaultsOverrideBuiltinOnes(t *testing.T) {
+	withEmbeddedDefaults(t, distributorDefaults)
+
+	cfg := Default()
+	if cfg.UI.Theme != "light" {
+		t.Errorf("theme = %q, want the embedded light", cfg.UI.Theme)
+	}
+	if !reflect.DeepEqual(cfg.Network.RelayServers, []string{"relay.example.org:8080"}) {
+		t.Errorf("relay servers = %v, want the embedded relay only", cfg.Network.RelayServers)
+	}
+	if builtin := builtinDefaults(); cfg.UI.PreviewLength != builtin.UI.PreviewLength || cfg.Network.ReconnectDelay != builtin.Network.ReconnectDelay {
+		t.Error("settings the embedded defaults leave out lost their built-in defaults")
+	}
+}
+
+func TestConfigFileOverridesEmbeddedDefaults(t *testing.T) {
+	withEmbeddedDefaults(t, distributorDefaults)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("ui:\n  theme: dark\n  preview_length: 30\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	if cfg.UI.Theme != "dark" || cfg.UI.PreviewLength != 30 {
+		t.Errorf("theme %q and preview length %d, want the user's dark and 30", cfg.UI.Theme, cfg.UI.PreviewLength)
+	}
+	if !reflect.DeepEqual(cfg.Network.RelayServers, []string{"relay.example.org:8080"}) {
+		t.Errorf("relay servers = %v, want the embedded relay the file leaves alone", cfg.Network.RelayServers)
+	}
+}
+
+func TestInvalidEmbeddedDefaultsAreIgnored(t *testing.T) {
+	withEmbeddedDefaults(t, "ui:\n  theme: [light\n")
+
+	if cfg := Default(); !reflect.DeepEqual(cfg, builtinDefaults()) {
+		t.Errorf("Default with embedded defaults that do not parse = %+v, want the built-in defaults", cfg)
+	}
+}
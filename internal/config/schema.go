@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ignoredKeys lists keys that no field reads but that are accepted without a
+// warning, so files shared with other versions of SecureChat load quietly
+var ignoredKeys = map[string]bool{
+	// Reserved for settings of features that are still in development
+	"experimental": true,
+}
+
+// unknownKeys returns the keys of a parsed YAML document that do not match
+// any configuration field, each with its dotted path and line, such as
+// "ui.notifcations (line 12)"
+func unknownKeys(doc *yaml.Node) []string {
+	var unknown []string
+	walkKeys(doc, reflect.TypeOf(Config{}), "", &unknown)
+	return unknown
+}
+
+// walkKeys checks the keys of node against the fields of t and descends
+// into nested sections
+func walkKeys(node *yaml.Node, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.AliasNode:
+		for _, child := range node.Content {
+			walkKeys(child, t, path, unknown)
+		}
+		if node.Alias != nil {
+			walkKeys(node.Alias, t, path, unknown)
+		}
+
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, child := range node.Content {
+				walkKeys(child, t.Elem(), path, unknown)
+			}
+		}
+
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Map:
+			// Map keys are data, not settings; only their values have a schema
+			for i := 1; i < len(node.Content); i += 2 {
+				walkKeys(node.Content[i], t.Elem(), path+"."+node.Content[i-1].Value, unknown)
+			}
+
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				keyPath := key.Value
+				if path != "" {
+					keyPath = path + "." + key.Value
+				}
+
+				if key.Tag == "!!merge" {
+					walkKeys(value, t, path, unknown)
+					continue
+				}
+				if ignoredKeys[keyPath] {
+					continue
+				}
+				field, known := fields[key.Value]
+				if !known {
+					*unknown = append(*unknown, fmt.Sprintf("%s (line %d)", keyPath, key.Line))
+					continue
+				}
+				walkKeys(value, field.Type, keyPath, unknown)
+			}
+		}
+	}
+}
+
+// yamlFields returns the fields of a struct type by the keys yaml.v3 decodes
+// them from
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
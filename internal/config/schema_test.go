@@ -0,0 +1,73 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestUnknownKeysAreReported(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"typo in a section", "ui:\n  theme: dark\n  notifcations: false\n", "ui.notifcations (line 3)"},
+		{"unknown section", "user:\n  display_name: Alice\nnetwrok:\n  port: 1\n", "netwrok (line 3)"},
+		{"typo in a list entry", "webhooks:\n  - url: https://example.com\n    secrt: x\n", "webhooks.secrt (line 3)"},
+		{"typo through a merge", "base: &base\n  theme: dark\nui:\n  <<: *base\n", "base (line 1)"},
+		{"ignored section", "experimental:\n  anything: 1\n", ""},
+		{"map keys are data", "ui:\n  key_bindings:\n    not_an_action: [f9]\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, unknown, err := parse([]byte(tt.yaml))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := strings.Join(unknown, ", "); got != tt.want {
+				t.Errorf("unknown keys = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidConfigLoadsCleanly(t *testing.T) {
+	data, err := yaml.Marshal(Default())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if _, unknown, err := parse(data); err != nil || len(unknown) > 0 {
+		t.Errorf("parse of the default config = %v, %v, want no unknown keys", unknown, err)
+	}
+	if _, unknown, err := parse(embeddedDefaults); err != nil || len(unknown) > 0 {
+		t.Errorf("parse of the embedded defaults = %v, %v, want no unknown keys", unknown, err)
+	}
+}
+
+func TestLoadFromFileWarnsAboutUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("ui:\n  notifcations: false\n  theme: light\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	if cfg.UI.Theme != "light" || cfg.UI.Notifications != Default().UI.Notifications {
+		t.Errorf("loaded theme %q and notifications %v, want the known key applied and the typo ignored", cfg.UI.Theme, cfg.UI.Notifications)
+	}
+	if !strings.Contains(logged.String(), "ignoring unknown key ui.notifcations (line 2)") {
+		t.Errorf("no warning about the typo in the log:\n%s", logged.String())
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// AuditAction identifies a security-relevant event kept in the audit trail
+type AuditAction string
+
+const (
+	// AuditKeyChanged records that a contact's identity key changed
+	AuditKeyChanged AuditAction = "key_changed"
+	// AuditContactVerified records that the user verified a contact's key
+	AuditContactVerified AuditAction = "contact_verified"
+	// AuditKeyChangeOverride records a message sent to a contact whose
+	// changed key had not been verified
+	AuditKeyChangeOverride AuditAction = "key_change_override"
+	// AuditKeyAccepted records that the user accepted a key they were asked about
+	AuditKeyAccepted AuditAction = "key_accepted"
+	// AuditKeyRejected records that the user rejected a key they were asked about
+	AuditKeyRejected AuditAction = "key_rejected"
+	// AuditEncryptionDowngrade records a plaintext message in a conversation
+	// that has an encryption session
+	AuditEncryptionDowngrade AuditAction = "encryption_downgrade"
+	// AuditForgedSender records a message that was not signed by the
+	// identity key of the user it claimed to be from
+	AuditForgedSender AuditAction = "forged_sender"
+)
+
+// AuditEvent is an entry in the local audit trail
+type AuditEvent struct {
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	UserID string      `json:"user_id"`
+	Detail string      `json:"detail,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Conversation holds per-chat settings and summary information
+type Conversation struct {
+	ChatID string `json:"chat_id" db:"chat_id"`
+
+	// RetentionDays overrides the global message retention for this chat.
+	// Nil uses the global default; 0 keeps messages forever.
+	RetentionDays *int `json:"retention_days,omitempty" db:"retention_days"`
+
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Retention returns the number of days messages in the chat are kept, using
+// defaultDays when the chat has no override. 0 means forever.
+func (c *Conversation) Retention(defaultDays int) int {
+	if c == nil || c.RetentionDays == nil {
+		return defaultDays
+	}
+	return *c.RetentionDays
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message IDs are the UTC creation time to the nanosecond followed by 64
+// random bits, e.g. "20260116093005.123456789_9f86d081884c7d65". IDs, and
+// storage keys built from them, sort by creation time.
+const messageIDTimeLayout = "20060102150405.000000000"
+
+// Layout of the timestamp in IDs made before message IDs were unified
+const legacyMessageIDTimeLayout = "20060102150405"
+
+var (
+	lastIDTime time.Time
+	lastIDMux  sync.Mutex
+)
+
+// NewMessageID returns a new unique message ID. IDs made by one process
+// sort in the order they were made, even when the clock is too coarse to
+// tell them apart or steps backwards.
+func NewMessageID() string {
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+
+	lastIDMux.Lock()
+	now := time.Now().UTC()
+	if !now.After(lastIDTime) {
+		now = lastIDTime.Add(time.Nanosecond)
+	}
+	lastIDTime = now
+	lastIDMux.Unlock()
+
+	return now.Format(messageIDTimeLayout) + "_" + hex.EncodeToString(random[:])
+}
+
+// NewDeviceID returns a new random ID for an install of the application
+func NewDeviceID() string {
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(random[:])
+}
+
+// MessageIDTime returns the creation time embedded in a message ID. It
+// understands the IDs of older versions too, whose time is only accurate to
+// the second and, for IDs made by the models package, in the sender's
+// local time.
+func MessageIDTime(id string) (time.Time, error) {
+	stamp, _, found := strings.Cut(id, "_")
+	if !found {
+		return time.Time{}, fmt.Errorf("invalid message ID %q", id)
+	}
+
+	// Legacy network IDs: msg_<unix nanoseconds>_<n>
+	if stamp == "msg" {
+		nanos, _, _ := strings.Cut(strings.TrimPrefix(id, "msg_"), "_")
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid message ID %q", id)
+		}
+		return time.Unix(0, n).UTC(), nil
+	}
+
+	layout := messageIDTimeLayout
+	if len(stamp) == len(legacyMessageIDTimeLayout) {
+		layout = legacyMessageIDTimeLayout
+	}
+	t, err := time.Parse(layout, stamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid message ID %q", id)
+	}
+	return t, nil
+}
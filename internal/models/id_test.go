@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewMessageIDsSortInCreationOrder(t *testing.T) {
+	ids := make([]string, 10000)
+	for i := range ids {
+		ids[i] = NewMessageID()
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("IDs do not sort in the order they were made")
+	}
+
+	// Storage keys are the chat ID and message ID, so they sort the same
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf("messages/%s/%s", "alice:bob", id)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Error("storage keys do not sort in the order the IDs were made")
+	}
+}
+
+func TestNewMessageIDsAreUnique(t *testing.T) {
+	const goroutines, perGoroutine = 8, 2000
+	var mu sync.Mutex
+	seen := make(map[string]bool, goroutines*perGoroutine)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := make([]string, perGoroutine)
+			for i := range ids {
+				ids[i] = NewMessageID()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, id := range ids {
+				if seen[id] {
+					t.Errorf("duplicate ID %s", id)
+				}
+				seen[id] = true
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMessageIDTime(t *testing.T) {
+	before := time.Now()
+	id := NewMessageID()
+	after := time.Now()
+	got, err := MessageIDTime(id)
+	if err != nil {
+		t.Fatalf("MessageIDTime(%s): %v", id, err)
+	}
+	if got.Before(before.Add(-time.Millisecond)) || got.After(after.Add(time.Millisecond)) {
+		t.Errorf("MessageIDTime(%s) = %v, want between %v and %v", id, got, before, after)
+	}
+
+	tests := []struct {
+		id      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"20260116093005.123456789_9f86d081884c7d65", time.Date(2026, 1, 16, 9, 30, 5, 123456789, time.UTC), false},
+		{"20260116093005_9f86d081", time.Date(2026, 1, 16, 9, 30, 5, 0, time.UTC), false},
+		{"msg_1700000000123456789_42", time.Unix(0, 1700000000123456789).UTC(), false},
+		{"not an id", time.Time{}, true},
+		{"msg_soon_1", time.Time{}, true},
+		{"2026_9f86d081", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := MessageIDTime(tt.id)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("MessageIDTime(%q) = %v, %v, want %v (error %v)", tt.id, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"sort"
 	"time"
 )
 
@@ -25,14 +26,30 @@ type Message struct {
 	ChatID    string      `json:"chat_id" db:"chat_id"`
 	Content   string      `json:"content" db:"content"`
 	Timestamp time.Time   `json:"timestamp" db:"timestamp"`
+	Sequence  uint64      `json:"sequence,omitempty" db:"sequence"`
+	
+	// Number of the message among those its sender's install sent in the
+	// chat, without gaps, so a receiver can tell when one went missing
+	SenderSequence uint64 `json:"sender_sequence,omitempty" db:"sender_sequence"`
+	
+	// Install of the sender's account the message was sent from
+	DeviceID   string `json:"device_id,omitempty" db:"device_id"`
+	DeviceName string `json:"device_name,omitempty" db:"device_name"`
 	Encrypted bool        `json:"encrypted" db:"encrypted"`
 	Signature string      `json:"signature,omitempty" db:"signature"`
 	Metadata  *Metadata   `json:"metadata,omitempty" db:"metadata"`
 	
 	// Local fields (not transmitted)
-	Status    MessageStatus `json:"-" db:"status"`
+	Status    MessageStatus `json:"status,omitempty" db:"status"`
+	Via       string        `json:"via,omitempty" db:"via"` // Connection that sent or delivered the message
 	CreatedAt time.Time     `json:"-" db:"created_at"`
 	UpdatedAt time.Time     `json:"-" db:"updated_at"`
+	
+	// When a received message arrived, and whether the sender's clock
+	// was found to be off, in which case ReceivedAt is shown instead of
+	// Timestamp. Timestamp keeps what the sender claimed.
+	ReceivedAt  time.Time `json:"received_at,omitempty" db:"received_at"`
+	ClockSkewed bool      `json:"clock_skewed,omitempty" db:"clock_skewed"`
 }
 
 // MessageStatus represents the delivery status of a message
@@ -41,21 +58,39 @@ type MessageStatus string
 const (
 	MessageStatusPending   MessageStatus = "pending"
 	MessageStatusSent      MessageStatus = "sent"
+	MessageStatusQueued    MessageStatus = "queued"
 	MessageStatusDelivered MessageStatus = "delivered"
 	MessageStatusRead      MessageStatus = "read"
 	MessageStatusFailed    MessageStatus = "failed"
 )
 
+// statusRank orders delivery states so that late or duplicated
+// acknowledgements never move a message backwards
+var statusRank = map[MessageStatus]int{
+	MessageStatusFailed:    0,
+	MessageStatusPending:   0,
+	MessageStatusSent:      1,
+	MessageStatusQueued:    2,
+	MessageStatusDelivered: 3,
+	MessageStatusRead:      4,
+}
+
 // Metadata contains additional message information
 type Metadata struct {
 	ReplyTo   string    `json:"reply_to,omitempty"`
 	ThreadID  string    `json:"thread_id,omitempty"`
+	
+	// Original author of a forwarded message
+	ForwardedFrom string `json:"forwarded_from,omitempty"`
 	ExpiresAt time.Time `json:"expires_at,omitempty"`
 	EditedAt  time.Time `json:"edited_at,omitempty"`
 	
 	// File attachment metadata
 	Attachment *Attachment `json:"attachment,omitempty"`
 	
+	// Reactions in the order they were added
+	Reactions []Reaction `json:"reactions,omitempty"`
+	
 	// Rich content metadata
 	Formatting string                 `json:"formatting,omitempty"`
 	Entities   []Entity              `json:"entities,omitempty"`
@@ -72,6 +107,12 @@ type Attachment struct {
 	URL      string `json:"url,omitempty"`
 }
 
+// Reaction is an emoji a user reacted to a message with
+type Reaction struct {
+	From  string `json:"from"`
+	Emoji string `json:"emoji"`
+}
+
 // Entity represents a rich text entity (mention, link, etc.)
 type Entity struct {
 	Type   string `json:"type"`   // "mention", "link", "bold", "italic", "code"
@@ -89,11 +130,49 @@ type LinkPreview struct {
 	SiteName    string `json:"site_name,omitempty"`
 }
 
+// PendingDelivery records a sent message still waiting for a relay or
+// recipient ack, so its delivery timeout survives a restart
+type PendingDelivery struct {
+	ChatID    string    `json:"chat_id"`
+	MessageID string    `json:"message_id"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// MessageDebugInfo is the envelope metadata of a received message, kept in
+// debug mode to diagnose messages that cannot be read. It holds nothing
+// secret: the sender's ratchet key is public, and no message or chain keys
+// are recorded.
+type MessageDebugInfo struct {
+	ChatID     string    `json:"chat_id"`
+	MessageID  string    `json:"message_id"`
+	Via        string    `json:"via,omitempty"` // Connection the message arrived on
+	ReceivedAt time.Time `json:"received_at"`
+
+	// Ratchet header of an encrypted message
+	Encrypted        bool   `json:"encrypted"`
+	SenderRatchetKey []byte `json:"sender_ratchet_key,omitempty"`
+	MessageNumber    uint32 `json:"message_number"`
+	PreviousCounter  uint32 `json:"previous_counter"`
+
+	Error string `json:"error,omitempty"` // Why the message could not be read
+}
+
+// OutboxEntry records a message written while no relay was reachable. It
+// stays in the outbox until the message is sent or cancelled.
+type OutboxEntry struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+}
+
+// ClockSkewTolerance is how far a sender's clock may be off before its
+// timestamps are no longer trusted for display
+const ClockSkewTolerance = 5 * time.Minute
+
 // NewMessage creates a new message with default values
 func NewMessage(msgType MessageType, from, to, content string) *Message {
 	now := time.Now()
 	return &Message{
-		ID:        generateMessageID(),
+		ID:        NewMessageID(),
 		Type:      msgType,
 		From:      from,
 		To:        to,
@@ -110,6 +189,56 @@ func (m *Message) IsFromUser(userID string) bool {
 	return m.From == userID
 }
 
+// UpdateStatus advances the delivery status of the message. It returns false
+// if the new status would not move the message forward.
+func (m *Message) UpdateStatus(status MessageStatus) bool {
+	if status == m.Status {
+		return false
+	}
+
+	if status == MessageStatusFailed {
+		if statusRank[m.Status] >= statusRank[MessageStatusDelivered] {
+			return false
+		}
+	} else if statusRank[status] <= statusRank[m.Status] {
+		return false
+	}
+
+	m.Status = status
+	m.UpdatedAt = time.Now()
+	return true
+}
+
+// Before reports whether m should be displayed before other. Messages are
+// ordered by their per-chat sequence number, so clock skew between devices
+// cannot reorder a conversation; the display time breaks ties and orders
+// messages that predate sequence numbers.
+func (m *Message) Before(other *Message) bool {
+	if m.Sequence != other.Sequence {
+		return m.Sequence < other.Sequence
+	}
+	if mt, ot := m.DisplayTime(), other.DisplayTime(); !mt.Equal(ot) {
+		return mt.Before(ot)
+	}
+	return m.ID < other.ID
+}
+
+// DisplayTime returns the time the message is shown and ordered by: the
+// sender's timestamp, or when it arrived if the sender's clock is off
+func (m *Message) DisplayTime() time.Time {
+	if m.ClockSkewed && !m.ReceivedAt.IsZero() {
+		return m.ReceivedAt
+	}
+	return m.Timestamp
+}
+
+// SortMessages sorts messages into display order
+func SortMessages(messages []*Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Before(messages[j])
+	})
+}
+
 // IsExpired returns true if the message has expired
 func (m *Message) IsExpired() bool {
 	if m.Metadata == nil || m.Metadata.ExpiresAt.IsZero() {
@@ -123,24 +252,56 @@ func (m *Message) IsEdited() bool {
 	return m.Metadata != nil && !m.Metadata.EditedAt.IsZero()
 }
 
-// HasAttachment returns true if the message has a file attachment
-func (m *Message) HasAttachment() bool {
-	return m.Metadata != nil && m.Metadata.Attachment != nil
+// Reactions returns the reactions to the message
+func (m *Message) Reactions() []Reaction {
+	if m.Metadata == nil {
+		return nil
+	}
+	return m.Metadata.Reactions
 }
 
-// generateMessageID generates a unique message ID
-func generateMessageID() string {
-	// In a real implementation, this would generate a proper unique ID
-	// For now, using timestamp + random component
-	return time.Now().Format("20060102150405") + "_" + randomString(8)
+// SetReaction adds or removes a user's reaction. It returns false if the
+// reaction was already in that state. The metadata is copied before it is
+// changed, so other copies of the message are not affected.
+func (m *Message) SetReaction(from, emoji string, on bool) bool {
+	existing := m.Reactions()
+	reactions := make([]Reaction, 0, len(existing)+1)
+	found := false
+	for _, reaction := range existing {
+		if reaction.From == from && reaction.Emoji == emoji {
+			found = true
+			if !on {
+				continue
+			}
+		}
+		reactions = append(reactions, reaction)
+	}
+	if found == on {
+		return false
+	}
+	if on {
+		reactions = append(reactions, Reaction{From: from, Emoji: emoji})
+	}
+
+	metadata := Metadata{}
+	if m.Metadata != nil {
+		metadata = *m.Metadata
+	}
+	metadata.Reactions = reactions
+	m.Metadata = &metadata
+	return true
 }
 
-// randomString generates a random string of specified length
-func randomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+// Author returns the user who wrote the message: the original author of a
+// forwarded message, and otherwise its sender
+func (m *Message) Author() string {
+	if m.Metadata != nil && m.Metadata.ForwardedFrom != "" {
+		return m.Metadata.ForwardedFrom
 	}
-	return string(b)
+	return m.From
+}
+
+// HasAttachment returns true if the message has a file attachment
+func (m *Message) HasAttachment() bool {
+	return m.Metadata != nil && m.Metadata.Attachment != nil
 }
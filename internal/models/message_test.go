@@ -0,0 +1,94 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	tests := []struct {
+		from, to MessageStatus
+		moved    bool
+	}{
+		{MessageStatusPending, MessageStatusSent, true},
+		{MessageStatusSent, MessageStatusQueued, true},
+		{MessageStatusQueued, MessageStatusDelivered, true},
+		{MessageStatusSent, MessageStatusDelivered, true},
+		{MessageStatusDelivered, MessageStatusRead, true},
+		{MessageStatusQueued, MessageStatusFailed, true},
+
+		// Late or repeated acks never move a message back
+		{MessageStatusDelivered, MessageStatusQueued, false},
+		{MessageStatusRead, MessageStatusDelivered, false},
+		{MessageStatusQueued, MessageStatusQueued, false},
+		{MessageStatusDelivered, MessageStatusFailed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+" to "+string(tt.to), func(t *testing.T) {
+			msg := &Message{Status: tt.from}
+			if moved := msg.UpdateStatus(tt.to); moved != tt.moved {
+				t.Errorf("UpdateStatus = %v, want %v", moved, tt.moved)
+			}
+			want := tt.from
+			if tt.moved {
+				want = tt.to
+			}
+			if msg.Status != want {
+				t.Errorf("Status = %s, want %s", msg.Status, want)
+			}
+		})
+	}
+}
+
+func TestSortMessagesBySequence(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	messages := []*Message{
+		// The peer's clock is hours behind, yet its reply sorts after
+		{ID: "reply", Sequence: 2, Timestamp: base.Add(-3 * time.Hour)},
+		{ID: "question", Sequence: 1, Timestamp: base},
+		{ID: "later", Sequence: 3, Timestamp: base.Add(-time.Hour)},
+		// Same sequence, sent at once by both sides: time, then ID decide
+		{ID: "b", Sequence: 4, Timestamp: base},
+		{ID: "a", Sequence: 4, Timestamp: base},
+		{ID: "first", Sequence: 4, Timestamp: base.Add(-time.Minute)},
+	}
+	want := []string{"question", "reply", "later", "first", "a", "b"}
+
+	// Every starting order gives the same result
+	for shift := 0; shift < len(messages); shift++ {
+		shuffled := append(append([]*Message(nil), messages[shift:]...), messages[:shift]...)
+		SortMessages(shuffled)
+		for i, msg := range shuffled {
+			if msg.ID != want[i] {
+				t.Fatalf("shift %d: position %d is %s, want %s", shift, i, msg.ID, want[i])
+			}
+		}
+	}
+}
+
+func TestSetReaction(t *testing.T) {
+	msg := &Message{ID: "m1"}
+
+	if !msg.SetReaction("bob", "👍", true) || !msg.SetReaction("carol", "🎉", true) || !msg.SetReaction("alice", "👍", true) {
+		t.Fatal("adding new reactions reported no change")
+	}
+	if msg.SetReaction("bob", "👍", true) {
+		t.Error("adding a reaction twice reported a change")
+	}
+	copied := *msg
+	if !msg.SetReaction("bob", "👍", false) {
+		t.Error("removing a reaction reported no change")
+	}
+	if msg.SetReaction("bob", "👍", false) {
+		t.Error("removing a missing reaction reported a change")
+	}
+
+	want := []Reaction{{From: "carol", Emoji: "🎉"}, {From: "alice", Emoji: "👍"}}
+	if got := msg.Reactions(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("reactions = %v, want %v", got, want)
+	}
+	if len(copied.Reactions()) != 3 {
+		t.Error("changing the reactions changed another copy of the message")
+	}
+}
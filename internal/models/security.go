@@ -0,0 +1,28 @@
+package models
+
+// SecurityStatus is an overview of how well the user's conversations are
+// protected, as shown on the security dashboard. Counts leave out blocked
+// contacts and users who are not in the address book.
+type SecurityStatus struct {
+	Contacts   int // Contacts counted
+	Verified   int // Contacts whose current key the user verified
+	KeyChanges int // Contacts whose key changed since it was verified
+	// Contacts with a new key held until the user accepts or rejects it
+	PendingKeys int
+
+	Sessions   int // Established encryption sessions, with anyone
+	Downgraded int // Sessions that have seen a plaintext message
+
+	Relays       int // Configured relays
+	TLSRelays    int // Relays reached over TLS
+	PinnedRelays int // Relays whose identity is pinned
+
+	RetentionDays       int    // 0 keeps messages forever
+	AutoAcceptKeys      string // "never", "ask" or "always"
+	RequireVerification bool
+}
+
+// Unverified returns the number of contacts whose key was not verified
+func (s SecurityStatus) Unverified() int {
+	return s.Contacts - s.Verified
+}
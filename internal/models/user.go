@@ -1,9 +1,29 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// User IDs are case-insensitive. The canonical form is trimmed and lower-cased,
+// and every component stores and compares IDs in that form.
+const (
+	MinUserIDLength = 3
+	MaxUserIDLength = 50
+)
+
+// ErrInvalidUserID is returned when a user ID does not conform to the ID format
+var ErrInvalidUserID = errors.New("invalid user ID")
+
+// reservedUserIDs are the names relays send their own messages as, such as
+// acks, so no user may take them
+var reservedUserIDs = map[string]bool{
+	"server": true,
+	"relay":  true,
+}
+
 // UserStatus represents a user's online status
 type UserStatus string
 
@@ -39,14 +59,37 @@ type Contact struct {
 	PublicKey   []byte    `json:"public_key" db:"public_key"`
 	Fingerprint string    `json:"fingerprint" db:"fingerprint"`
 	Verified    bool      `json:"verified" db:"verified"`
+	KeyChanged  bool      `json:"key_changed,omitempty" db:"key_changed"`
 	Blocked     bool      `json:"blocked" db:"blocked"`
 	Favorite    bool      `json:"favorite" db:"favorite"`
+	Muted       bool      `json:"muted,omitempty" db:"muted"`
+	Archived    bool      `json:"archived,omitempty" db:"archived"`
 	Notes       string    `json:"notes" db:"notes"`
 	
+	// Avatar is an emoji shown in place of the contact's initials, chosen
+	// by the user
+	Avatar string `json:"avatar,omitempty" db:"avatar"`
+	
+	// ExchangeKey is the key agreement half of the identity key, which with
+	// PublicKey makes up the fingerprint. Contacts whose key was recorded by
+	// older versions lack it.
+	ExchangeKey []byte `json:"exchange_key,omitempty" db:"exchange_key"`
+	
+	// Unknown marks a contact created for an ad-hoc chat with a user the
+	// user never added. Adding the user makes it a regular contact.
+	Unknown bool `json:"unknown,omitempty" db:"unknown"`
+	
+	// A new or changed identity key held until the user accepts or rejects
+	// it, when keys are not accepted automatically
+	PendingKey         []byte `json:"pending_key,omitempty" db:"pending_key"`
+	PendingExchangeKey []byte `json:"pending_exchange_key,omitempty" db:"pending_exchange_key"`
+	PendingFingerprint string `json:"pending_fingerprint,omitempty" db:"pending_fingerprint"`
+	
 	// Cached status information
-	Status        UserStatus `json:"status" db:"status"`
-	StatusMessage string     `json:"status_message" db:"status_message"`
-	LastSeen      time.Time  `json:"last_seen" db:"last_seen"`
+	Status         UserStatus `json:"status" db:"status"`
+	StatusMessage  string     `json:"status_message" db:"status_message"`
+	LastSeen       time.Time  `json:"last_seen" db:"last_seen"`
+	LastSeenHidden bool       `json:"last_seen_hidden" db:"last_seen_hidden"`
 	
 	// Local fields
 	AddedAt   time.Time `json:"-" db:"added_at"`
@@ -57,15 +100,38 @@ type Contact struct {
 type Identity struct {
 	UserID         string    `json:"user_id" db:"user_id"`
 	IdentityKey    []byte    `json:"identity_key" db:"identity_key"`
+	// Private halves and the exchange key, absent from identities stored by
+	// older versions which only kept the fingerprint
+	SigningPrivateKey  []byte `json:"signing_private_key,omitempty" db:"signing_private_key"`
+	ExchangeKey        []byte `json:"exchange_key,omitempty" db:"exchange_key"`
+	ExchangePrivateKey []byte `json:"exchange_private_key,omitempty" db:"exchange_private_key"`
 	SignedPreKey   []byte    `json:"signed_pre_key" db:"signed_pre_key"`
 	PreKeyID       uint32    `json:"pre_key_id" db:"pre_key_id"`
 	PreKeySignature []byte   `json:"pre_key_signature" db:"pre_key_signature"`
+	PreKeyPrivateKey []byte  `json:"pre_key_private_key,omitempty" db:"pre_key_private_key"`
 	OneTimeKeys    [][]byte  `json:"one_time_keys" db:"one_time_keys"`
 	Fingerprint    string    `json:"fingerprint" db:"fingerprint"`
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 	ExpiresAt      time.Time `json:"expires_at" db:"expires_at"`
 }
 
+// EncryptionState tells whether a conversation is end-to-end encrypted
+type EncryptionState string
+
+const (
+	// EncryptionNone means no ratchet session was ever established, so
+	// messages are exchanged in plaintext
+	EncryptionNone EncryptionState = "none"
+	// EncryptionPending means a key exchange is under way, and messages
+	// wait for it to finish
+	EncryptionPending EncryptionState = "pending"
+	// EncryptionActive means a ratchet session is established
+	EncryptionActive EncryptionState = "active"
+	// EncryptionDowngraded means a plaintext message was sent or received
+	// although a ratchet session is established
+	EncryptionDowngraded EncryptionState = "downgraded"
+)
+
 // Session represents a cryptographic session with another user
 type Session struct {
 	ID              string    `json:"id" db:"id"`
@@ -83,6 +149,43 @@ type Session struct {
 	LastUsed  time.Time `json:"last_used" db:"last_used"`
 }
 
+// NormalizeUserID returns the canonical form of a user ID
+func NormalizeUserID(userID string) string {
+	return strings.ToLower(strings.TrimSpace(userID))
+}
+
+// ValidateUserID checks that a user ID has a valid length, only contains
+// letters, digits, underscores and hyphens, and is not reserved for relays
+func ValidateUserID(userID string) error {
+	if len(userID) < MinUserIDLength || len(userID) > MaxUserIDLength {
+		return fmt.Errorf("%w: %q must be between %d and %d characters",
+			ErrInvalidUserID, userID, MinUserIDLength, MaxUserIDLength)
+	}
+	if reservedUserIDs[strings.ToLower(userID)] {
+		return fmt.Errorf("%w: %q is reserved for relays", ErrInvalidUserID, userID)
+	}
+	
+	for _, char := range userID {
+		if !((char >= 'a' && char <= 'z') ||
+			(char >= 'A' && char <= 'Z') ||
+			(char >= '0' && char <= '9') ||
+			char == '_' || char == '-') {
+			return fmt.Errorf("%w: %q contains invalid character %q", ErrInvalidUserID, userID, char)
+		}
+	}
+	
+	return nil
+}
+
+// ParseUserID normalizes and validates a user ID from untrusted input
+func ParseUserID(userID string) (string, error) {
+	normalized := NormalizeUserID(userID)
+	if err := ValidateUserID(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
 // NewUser creates a new user with default values
 func NewUser(id, displayName string, publicKey []byte) *User {
 	now := time.Now()
@@ -142,6 +245,44 @@ func (c *Contact) IsActive() bool {
 	return time.Since(c.LastSeen) < 5*time.Minute
 }
 
+// ContactState is everything about a contact that changes how views show
+// the contact and their conversation. Views decide from it, rather than
+// from the contact's fields, so the flags interact the same way everywhere.
+type ContactState struct {
+	Verified bool `json:"verified"`
+	Blocked  bool `json:"blocked"`
+	Muted    bool `json:"muted"`
+	Archived bool `json:"archived"`
+	Favorite bool `json:"favorite"`
+	Online   bool `json:"online"`
+	Unknown  bool `json:"unknown"` // Not in the address book
+}
+
+// State returns the contact's consolidated state
+func (c *Contact) State() ContactState {
+	return ContactState{
+		Verified: c.Verified && !c.KeyChanged,
+		Blocked:  c.Blocked,
+		Muted:    c.Muted,
+		Archived: c.Archived,
+		Favorite: c.Favorite,
+		Online:   c.IsOnline(),
+		Unknown:  c.Unknown,
+	}
+}
+
+// Notifies reports whether new messages from the contact should alert the
+// user. Blocked contacts never do.
+func (s ContactState) Notifies() bool {
+	return !s.Blocked && !s.Muted
+}
+
+// Hidden reports whether the contact is left out of contact lists, forward
+// targets and search results. Blocked contacts always are.
+func (s ContactState) Hidden() bool {
+	return s.Blocked || s.Archived
+}
+
 // GetSafetyNumber generates a human-readable safety number for verification
 func (c *Contact) GetSafetyNumber() string {
 	// In a real implementation, this would generate a proper safety number
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateUserIDReservesRelayNames(t *testing.T) {
+	for _, userID := range []string{"server", "relay", "Server", "RELAY"} {
+		if err := ValidateUserID(userID); !errors.Is(err, ErrInvalidUserID) {
+			t.Errorf("ValidateUserID(%q) = %v, want ErrInvalidUserID", userID, err)
+		}
+	}
+	for _, userID := range []string{"servers", "relay_1", "my-server"} {
+		if err := ValidateUserID(userID); err != nil {
+			t.Errorf("ValidateUserID(%q) = %v, want nil", userID, err)
+		}
+	}
+}
+
+func TestValidateUserID(t *testing.T) {
+	tests := []struct {
+		userID string
+		valid  bool
+	}{
+		{"bob", true},
+		{"alice_smith-2", true},
+		{"Alice", true},
+		{"ab", false},
+		{strings.Repeat("a", MaxUserIDLength), true},
+		{strings.Repeat("a", MaxUserIDLength+1), false},
+		{"alice smith", false},
+		{"alice@relay", false},
+		{"ålice", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			err := ValidateUserID(tt.userID)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateUserID(%q) = %v, want nil", tt.userID, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidUserID) {
+				t.Errorf("ValidateUserID(%q) = %v, want ErrInvalidUserID", tt.userID, err)
+			}
+		})
+	}
+}
+
+func TestParseUserID(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"bob", "bob", true},
+		{"  Bob\n", "bob", true},
+		{"ALICE_1", "alice_1", true},
+		{" b ", "", false},
+		{"bob smith", "", false},
+		{"Relay", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseUserID(tt.input)
+			if tt.valid != (err == nil) {
+				t.Fatalf("ParseUserID(%q) error = %v, want valid %v", tt.input, err, tt.valid)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUserID(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContactState(t *testing.T) {
+	tests := []struct {
+		name     string
+		contact  Contact
+		notifies bool
+		hidden   bool
+	}{
+		{"plain", Contact{}, true, false},
+		{"muted", Contact{Muted: true}, false, false},
+		{"archived", Contact{Archived: true}, true, true},
+		{"blocked", Contact{Blocked: true}, false, true},
+		{"blocked favorite", Contact{Blocked: true, Favorite: true}, false, true},
+		{"archived and muted", Contact{Archived: true, Muted: true}, false, true},
+	}
+
+	for _, tt := range tests {
+		state := tt.contact.State()
+		if state.Notifies() != tt.notifies || state.Hidden() != tt.hidden {
+			t.Errorf("%s: notifies %v and hidden %v, want %v and %v", tt.name, state.Notifies(), state.Hidden(), tt.notifies, tt.hidden)
+		}
+	}
+
+	if state := (&Contact{Verified: true, KeyChanged: true}).State(); state.Verified {
+		t.Error("a contact whose key changed is still verified")
+	}
+	if state := (&Contact{Status: UserStatusOnline, Unknown: true}).State(); !state.Online || !state.Unknown {
+		t.Errorf("state = %+v, want online and unknown", state)
+	}
+}
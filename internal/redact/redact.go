@@ -0,0 +1,53 @@
+// Package redact keeps message content out of logs. Content is replaced by
+// its length unless plaintext logging was explicitly enabled, which is only
+// meant for debugging on a developer's own machine.
+package redact
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+var plaintext atomic.Bool
+
+// SetPlaintext enables or disables logging of message content
+func SetPlaintext(enabled bool) {
+	plaintext.Store(enabled)
+}
+
+// Plaintext reports whether message content may be logged
+func Plaintext() bool {
+	return plaintext.Load()
+}
+
+// Content returns s quoted if plaintext logging is enabled, and otherwise
+// only its length
+func Content(s string) string {
+	if Plaintext() {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("[redacted, %d bytes]", len(s))
+}
+
+// Payload describes a message payload for logs: field names with their
+// types, and with their values only if plaintext logging is enabled
+func Payload(payload map[string]interface{}) string {
+	if Plaintext() {
+		return fmt.Sprintf("%v", payload)
+	}
+
+	fields := make([]string, 0, len(payload))
+	for key, value := range payload {
+		switch v := value.(type) {
+		case string:
+			fields = append(fields, fmt.Sprintf("%s:string(%d)", key, len(v)))
+		default:
+			fields = append(fields, fmt.Sprintf("%s:%T", key, v))
+		}
+	}
+	sort.Strings(fields)
+
+	return "{" + strings.Join(fields, " ") + "}"
+}
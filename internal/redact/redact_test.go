@@ -0,0 +1,43 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+// withPlaintext sets plaintext logging for the rest of the test
+func withPlaintext(t *testing.T, enabled bool) {
+	previous := Plaintext()
+	SetPlaintext(enabled)
+	t.Cleanup(func() { SetPlaintext(previous) })
+}
+
+func TestContent(t *testing.T) {
+	withPlaintext(t, false)
+	if got, want := Content("meet at noon"), "[redacted, 12 bytes]"; got != want {
+		t.Errorf("Content = %s, want %s", got, want)
+	}
+
+	withPlaintext(t, true)
+	if got, want := Content("meet at noon"), `"meet at noon"`; got != want {
+		t.Errorf("Content with plaintext = %s, want %s", got, want)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	payload := map[string]interface{}{
+		"content":  "meet at noon",
+		"sequence": float64(7),
+		"reply_to": "m1",
+	}
+
+	withPlaintext(t, false)
+	if got, want := Payload(payload), "{content:string(12) reply_to:string(2) sequence:float64}"; got != want {
+		t.Errorf("Payload = %s, want %s", got, want)
+	}
+
+	withPlaintext(t, true)
+	if got := Payload(payload); !strings.Contains(got, "meet at noon") {
+		t.Errorf("Payload with plaintext = %s, want the content", got)
+	}
+}
@@ -0,0 +1,46 @@
+// Package textwidth measures and cuts text by the terminal cells it takes,
+// as the interface lays it out, so the interface and the listings core
+// produces for bots and dashboards cut text the same way.
+package textwidth
+
+import (
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rivo/uniseg"
+)
+
+// ellipsis ends text that was cut
+const ellipsis = "..."
+
+// Width returns how many terminal cells s takes. Wide CJK characters and
+// emoji count double, and combining marks count nothing.
+func Width(s string) int {
+	return lipgloss.Width(s)
+}
+
+// Truncate cuts s to at most width terminal cells, ending with an ellipsis
+// if anything was cut. Text is only cut between characters as the reader
+// sees them, so an emoji sequence is never split and the result is always
+// valid UTF-8.
+func Truncate(s string, width int) string {
+	if Width(s) <= width {
+		return s
+	}
+
+	tail := ellipsis
+	if width <= len(tail) {
+		tail = ""
+	}
+
+	used, end := 0, 0
+	graphemes := uniseg.NewGraphemes(s)
+	for graphemes.Next() {
+		w := Width(graphemes.Str())
+		if used+w > width-len(tail) {
+			break
+		}
+		used += w
+		_, end = graphemes.Positions()
+	}
+
+	return s[:end] + tail
+}
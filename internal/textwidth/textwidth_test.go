@@ -0,0 +1,25 @@
+package textwidth
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width int
+		want  string
+	}{
+		{"fits", "hello", 5, "hello"},
+		{"cut", "hello world", 8, "hello..."},
+		{"too narrow for the ellipsis", "hello", 3, "hel"},
+		{"wide characters", "你好世界", 7, "你好..."},
+		{"combining marks take no cells", "e\u0301e\u0301e\u0301", 3, "e\u0301e\u0301e\u0301"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Truncate(tt.s, tt.width); got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ErrContactBlocked is returned when sending to a blocked contact
+var ErrContactBlocked = errors.New("contact is blocked")
+
+// chatContact returns the contact a message to userID goes to. A user who
+// is not a contact is added as an unknown contact, unless messaging unknown
+// users is turned off. Blocked contacts cannot be messaged.
+func (a *App) chatContact(userID string) (*models.Contact, error) {
+	contact, exists := a.GetContact(userID)
+	if !exists {
+		if !a.config.Security.MessageUnknownUsers {
+			return nil, fmt.Errorf("%w: %s", errContactNotFound, userID)
+		}
+		var err error
+		if contact, err = a.addUnknownContact(userID); err != nil {
+			return nil, err
+		}
+	}
+	if contact.Blocked {
+		return nil, fmt.Errorf("%w: %s", ErrContactBlocked, contact.UserID)
+	}
+	return contact, nil
+}
+
+// addUnknownContact adds a user the user never added as an unknown contact,
+// so that their chat has a name, presence and key to check against. It
+// returns a copy of the contact, which may have been added meanwhile.
+func (a *App) addUnknownContact(userID string) (*models.Contact, error) {
+	userID, err := models.ParseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	a.contactsMux.Lock()
+	if existing, exists := a.contacts[userID]; exists {
+		contact := *existing
+		a.contactsMux.Unlock()
+		return &contact, nil
+	}
+	contact := &models.Contact{
+		UserID:      userID,
+		DisplayName: userID,
+		Status:      models.UserStatusOffline,
+		Unknown:     true,
+	}
+	if err := a.storage.SaveContact(contact); err != nil {
+		a.contactsMux.Unlock()
+		return nil, fmt.Errorf("failed to save contact: %w", err)
+	}
+	a.contacts[userID] = contact
+	added := *contact
+	a.contactsMux.Unlock()
+	a.syncPresenceSubscriptions()
+	a.notifyContactEvent(ContactAdded, &added)
+
+	log.Printf("Added unknown contact %s for an ad-hoc chat", userID)
+	return &added, nil
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestSendingToNonContactAddsUnknownContact(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	events := recordContactEvents(alice)
+
+	if err := alice.SendMessage(" Bob ", "hi, we met at the meetup"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	waitFor(t, "bob to receive the message", func() bool { return hasMessage(bob, "alice", "hi, we met at the meetup") })
+
+	contact, ok := alice.GetContact("bob")
+	if !ok {
+		t.Fatal("no contact was added for bob")
+	}
+	if !contact.Unknown || contact.DisplayName != "bob" || !alice.ContactState("bob").Unknown {
+		t.Errorf("contact = %+v, want an unknown contact named by the user ID", contact)
+	}
+	if got := events.take(); !strings.HasPrefix(got, "added bob") {
+		t.Errorf("events = %q, want bob added first", got)
+	}
+	waitFor(t, "bob's key", func() bool {
+		contact, _ := alice.GetContact("bob")
+		return contact.Fingerprint != ""
+	})
+	events.take()
+
+	// Adding the user keeps what the chat learned about them
+	contact, _ = alice.GetContact("bob")
+	fingerprint := contact.Fingerprint
+	if err := alice.AddContact("bob", "Bob Mensah"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	contact, _ = alice.GetContact("bob")
+	if contact.Unknown || contact.DisplayName != "Bob Mensah" || contact.Fingerprint != fingerprint {
+		t.Errorf("contact = %+v, want a regular contact with the key kept", contact)
+	}
+	if got := events.take(); got != "updated bob" {
+		t.Errorf("events = %q, want updated bob", got)
+	}
+}
+
+func TestSendingToNonContactIsRestricted(t *testing.T) {
+	t.Run("unknown users off", func(t *testing.T) {
+		alice := newTestApp(t, "alice", func(cfg *config.Config) { cfg.Security.MessageUnknownUsers = false })
+		if err := alice.SendMessage("bob", "hi"); !errors.Is(err, errContactNotFound) {
+			t.Errorf("SendMessage = %v, want errContactNotFound", err)
+		}
+		if _, ok := alice.GetContact("bob"); ok {
+			t.Error("a contact was added")
+		}
+	})
+
+	t.Run("invalid user ID", func(t *testing.T) {
+		alice := newTestApp(t, "alice")
+		if err := alice.SendMessage("b", "hi"); !errors.Is(err, models.ErrInvalidUserID) {
+			t.Errorf("SendMessage = %v, want ErrInvalidUserID", err)
+		}
+		if contacts := alice.GetContacts(); len(contacts) != 0 {
+			t.Errorf("contacts = %v, want none", contacts)
+		}
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		alice := newTestApp(t, "alice")
+		if err := alice.SendMessage("bob", "hi"); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		if err := alice.SetContactBlocked("bob", true); err != nil {
+			t.Fatalf("SetContactBlocked: %v", err)
+		}
+		if err := alice.SendMessage("bob", "again"); !errors.Is(err, ErrContactBlocked) {
+			t.Errorf("SendMessage = %v, want ErrContactBlocked", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// Announcement is a notice about a relay, sent by its operator to all its
+// clients or raised by the app, such as for a relay that does not store
+// messages
+type Announcement struct {
+	Relay string // Connection the announcement arrived on
+	Text  string
+	Time  time.Time
+}
+
+// immediateDeliveryWarning is announced for relays that drop messages for
+// users who are not connected
+const immediateDeliveryWarning = "This relay does not store messages; contacts only get them while online"
+
+// AnnouncementHandler is called when a relay makes an announcement
+type AnnouncementHandler func(Announcement)
+
+// AddAnnouncementHandler adds a relay announcement handler
+func (a *App) AddAnnouncementHandler(handler AnnouncementHandler) {
+	a.announcementHandlers = append(a.announcementHandlers, handler)
+}
+
+// handleAnnouncement passes an announcement to the announcement handlers.
+// Relays never route announcements between users, so one always comes from
+// the relay it arrived on.
+func (a *App) handleAnnouncement(via string, netMsg *network.Message) error {
+	var payload network.AnnouncementPayload
+	if err := netMsg.DecodePayload(&payload); err != nil {
+		return fmt.Errorf("dropping announcement %s: %w", netMsg.ID, err)
+	}
+
+	announcement := Announcement{
+		Relay: via,
+		Text:  payload.Text,
+		Time:  timeFromUnix(netMsg.Timestamp),
+	}
+	log.Printf("Announcement from relay %s: %s", via, payload.Text)
+	a.notifyAnnouncement(announcement)
+	return nil
+}
+
+// checkDeliveryMode warns, once per relay, when a relay answers the hello
+// saying it drops messages for users who are not connected, since messages
+// to offline contacts then fail
+func (a *App) checkDeliveryMode(via string) {
+	client, exists := a.connections.Client(via)
+	if !exists || client.DeliveryMode() != network.DeliveryImmediate {
+		return
+	}
+
+	a.syncMux.Lock()
+	warned := a.immediateRelays[via]
+	a.immediateRelays[via] = true
+	a.syncMux.Unlock()
+	if warned {
+		return
+	}
+
+	log.Printf("Warning: relay %s does not store messages for offline users", via)
+	a.notifyAnnouncement(Announcement{
+		Relay: via,
+		Text:  immediateDeliveryWarning,
+		Time:  time.Now(),
+	})
+}
+
+func (a *App) notifyAnnouncement(announcement Announcement) {
+	for _, handler := range a.announcementHandlers {
+		handler(announcement)
+	}
+}
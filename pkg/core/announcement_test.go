@@ -0,0 +1,80 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+func TestAnnouncementReachesConnectedApps(t *testing.T) {
+	server := newTestServer(t)
+	var mu sync.Mutex
+	got := make(map[string]Announcement)
+	for _, userID := range []string{"alice", "bob"} {
+		app := newTestApp(t, userID)
+		userID := userID
+		app.AddAnnouncementHandler(func(announcement Announcement) {
+			mu.Lock()
+			defer mu.Unlock()
+			got[userID] = announcement
+		})
+		connectApp(t, app, server)
+	}
+
+	if sent, err := server.Announce("Relay restarting in 5 minutes"); err != nil || sent != 2 {
+		t.Fatalf("Announce = %d, %v, want sent to both apps", sent, err)
+	}
+	waitFor(t, "both apps to get the announcement", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 2
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for userID, announcement := range got {
+		if announcement.Text != "Relay restarting in 5 minutes" || announcement.Relay != "memory" || announcement.Time.IsZero() {
+			t.Errorf("%s got %+v, want the announcement from the relay", userID, announcement)
+		}
+	}
+}
+
+func TestImmediateDeliveryRelayIsWarnedAbout(t *testing.T) {
+	for _, mode := range []network.DeliveryMode{network.DeliveryStoreAndForward, network.DeliveryImmediate} {
+		t.Run(string(mode), func(t *testing.T) {
+			server, err := network.NewServer(network.ServerOptions{DeliveryMode: mode})
+			if err != nil {
+				t.Fatalf("NewServer: %v", err)
+			}
+			t.Cleanup(func() { server.Stop() })
+			alice := newTestApp(t, "alice")
+			var mu sync.Mutex
+			var got []Announcement
+			alice.AddAnnouncementHandler(func(announcement Announcement) {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, announcement)
+			})
+
+			client := connectApp(t, alice, server)
+			if client.DeliveryMode() != mode {
+				t.Errorf("client reports %s, want %s", client.DeliveryMode(), mode)
+			}
+			// A second hello from the same relay does not warn again
+			alice.checkDeliveryMode("memory")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if mode == network.DeliveryStoreAndForward {
+				if len(got) != 0 {
+					t.Errorf("warned %+v about a relay that stores messages", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Relay != "memory" || got[0].Text != immediateDeliveryWarning {
+				t.Errorf("announcements = %+v, want one warning about the relay", got)
+			}
+		})
+	}
+}
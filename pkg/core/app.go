@@ -1,30 +1,132 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/opensourceghana/securechat/internal/config"
 	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/internal/redact"
 	"github.com/opensourceghana/securechat/pkg/crypto"
 	"github.com/opensourceghana/securechat/pkg/network"
 	"github.com/opensourceghana/securechat/pkg/storage"
+	"github.com/opensourceghana/securechat/pkg/transfer"
 )
 
 // App represents the core SecureChat application
 type App struct {
-	config   *config.Config
-	storage  *storage.Storage
-	client   *network.Client
-	identity *crypto.IdentityKeyPair
+	config      *config.Config
+	storage     storage.Store
+	connections *ConnectionManager
+	identity    *crypto.IdentityKeyPair
+	preKey      *crypto.PreKey // Signed prekey peers start sessions with
 	
-	// Message handlers
-	messageHandlers []MessageHandler
+	// File transfers, and the files offered that await the user's answer,
+	// by transfer ID
+	transfers     *transfer.Manager
+	fileOffers    map[string]*fileOffer
+	fileOffersMux sync.Mutex
 	
-	// State
-	contacts map[string]*models.Contact
-	sessions map[string]*crypto.DoubleRatchet
+	// Message, contact and typing handlers
+	messageHandlers         []registeredHandler
+	nextHandlerID           HandlerID
+	incomingHooks           []registeredHook[IncomingHook]
+	outgoingTransforms      []registeredHook[OutgoingTransform]
+	handlersMux             sync.RWMutex
+	contactHandlers         []ContactHandler
+	contactEventHandlers    []ContactEventHandler
+	typingHandlers          []TypingHandler
+	announcementHandlers    []AnnouncementHandler
+	downgradeHandlers       []DowngradeHandler
+	sessionStateHandlers    []SessionStateHandler
+	messageDropHandlers     []MessageDropHandler
+	messageGapHandlers      []MessageGapHandler
+	fileOfferHandlers       []FileOfferHandler
+	syncHandlers            []SyncHandler
+	outboxHandlers          []OutboxHandler
+	connectionStateHandlers []ConnectionStateHandler
+	connectionQualityHandlers []ConnectionQualityHandler
+	notificationHandlers    []NotificationHandler
+	
+	// Held while the stored status of a sent message is updated
+	statusMux sync.Mutex
+	
+	// State, read from network callbacks and UI calls alike
+	contacts    map[string]*models.Contact
+	contactsMux sync.RWMutex
+	sessions    map[string]*crypto.DoubleRatchet
+	downgraded  map[string]bool                  // Contacts whose session saw plaintext, by user ID
+	keyedAt     map[string]time.Time             // When each session was set up or last re-keyed
+	retired     map[string]*crypto.DoubleRatchet // Sessions replaced by a re-key, for messages in flight
+	sessionsMux sync.Mutex
+	
+	// Message cipher offered first in key exchanges
+	cipher crypto.CipherID
+	
+	// Recently received message IDs for deduplication
+	recentIDs *recentIDs
+	
+	// Per-chat message sequence numbers
+	sequences *chatSequences
+	
+	// Per-sender message numbers, and the checks on gaps in them by chat
+	// and sender
+	senders   *senderSequences
+	gapChecks *deliveryTimers
+	
+	// Received messages not read yet, by chat
+	unread *unreadCounts
+	
+	// Latest message of each chat, to spot senders whose clock is off
+	clocks *chatClocks
+	
+	// Delivery timeouts of sent messages awaiting an ack
+	deliveries *deliveryTimers
+	
+	// Deletion of disappearing messages when their time is up
+	expiries *deliveryTimers
+	
+	// Pending presence events of contacts, by user ID
+	presenceFlushes *deliveryTimers
+	
+	// Notifications being gathered, and when each is shown, by user ID
+	notifications       *notifications
+	notificationFlushes *deliveryTimers
+	
+	// Configured webhooks, each with its delivery queue
+	webhooks []*webhook
+	
+	// Key exchanges in progress and their retries, by user ID, and when
+	// this side last started a session with each user
+	handshakes       map[string]*handshake
+	initiatedAt      map[string]time.Time
+	handshakesMux    sync.Mutex
+	handshakeRetries *deliveryTimers
+	
+	// Held while the outbox is sent or a queued message cancelled
+	outboxMux sync.Mutex
+	
+	// Unix nanoseconds of the last message received on any connection
+	lastReceived atomic.Int64
+	
+	// Progress of fetching queued messages per relay, and the relays the
+	// user was warned do not queue them
+	syncProgress    map[string]network.SyncProgress
+	immediateRelays map[string]bool
+	syncMux         sync.Mutex
+	
+	// Closed to stop background work, which Close waits for. closeOnce
+	// lets Close be called more than once, such as by a signal handler and
+	// a deferred call.
+	done       chan struct{}
+	background sync.WaitGroup
+	closeOnce  sync.Once
 }
 
 // MessageHandler handles incoming messages
@@ -32,11 +134,39 @@ type MessageHandler func(*models.Message) error
 
 // NewApp creates a new SecureChat application
 func NewApp(cfg *config.Config) (*App, error) {
+	userID, err := models.ParseUserID(cfg.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	cfg.User.ID = userID
+	cipher, err := preferredCipher(cfg.Security.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	
 	app := &App{
 		config:          cfg,
+		cipher:          cipher,
 		contacts:        make(map[string]*models.Contact),
 		sessions:        make(map[string]*crypto.DoubleRatchet),
-		messageHandlers: make([]MessageHandler, 0),
+		downgraded:      make(map[string]bool),
+		keyedAt:         make(map[string]time.Time),
+		retired:         make(map[string]*crypto.DoubleRatchet),
+		recentIDs:       newRecentIDs(recentMessageIDs),
+		deliveries:      newDeliveryTimers(),
+		expiries:        newDeliveryTimers(),
+		presenceFlushes: newDeliveryTimers(),
+		gapChecks:       newDeliveryTimers(),
+		notifications:       newNotifications(),
+		notificationFlushes: newDeliveryTimers(),
+		handshakes:       make(map[string]*handshake),
+		fileOffers:       make(map[string]*fileOffer),
+		initiatedAt:      make(map[string]time.Time),
+		handshakeRetries: newDeliveryTimers(),
+		clocks:          newChatClocks(),
+		syncProgress:    make(map[string]network.SyncProgress),
+		immediateRelays: make(map[string]bool),
+		done:            make(chan struct{}),
 	}
 	
 	// Initialize storage
@@ -44,11 +174,22 @@ func NewApp(cfg *config.Config) (*App, error) {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
 	
+	// Initialize file transfers
+	app.transfers, err = transfer.NewManager(filepath.Join(cfg.GetDataDir(), "transfers"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize file transfers: %w", err)
+	}
+	
 	// Initialize or load identity
 	if err := app.initIdentity(); err != nil {
 		return nil, fmt.Errorf("failed to initialize identity: %w", err)
 	}
 	
+	// Initialize message search
+	if err := app.initSearchIndex(); err != nil {
+		return nil, fmt.Errorf("failed to initialize search index: %w", err)
+	}
+	
 	// Initialize network client
 	if err := app.initNetworkClient(); err != nil {
 		return nil, fmt.Errorf("failed to initialize network client: %w", err)
@@ -58,6 +199,28 @@ func NewApp(cfg *config.Config) (*App, error) {
 	if err := app.loadContacts(); err != nil {
 		log.Printf("Warning: failed to load contacts: %v", err)
 	}
+	app.syncPresenceSubscriptions()
+	
+	// Load encryption sessions
+	if err := app.loadSessions(); err != nil {
+		log.Printf("Warning: failed to load sessions: %v", err)
+	}
+	
+	app.restoreDeliveryTimeouts()
+	
+	if skipped := app.storage.SkippedRecords(); skipped > 0 {
+		log.Printf("Warning: skipped %d corrupt stored record(s); run securechat -repair to list them", skipped)
+	}
+	
+	app.background.Add(1)
+	go app.runRetentionSweeper()
+	
+	if cfg.Network.HeartbeatInterval > 0 {
+		app.background.Add(1)
+		go app.runHeartbeat(cfg.Network.HeartbeatInterval)
+	}
+	
+	app.startWebhooks()
 	
 	return app, nil
 }
@@ -65,32 +228,53 @@ func NewApp(cfg *config.Config) (*App, error) {
 // initStorage initializes the storage layer
 func (a *App) initStorage() error {
 	dataDir := a.config.GetDataDir()
+	if err := checkPermissions(dataDir, true, a.config.Security.InsecurePermissions); err != nil {
+		return err
+	}
 	
 	storageOpts := storage.StorageOptions{
 		DataDir: dataDir,
 		UserID:  a.config.User.ID,
+		Backend: storage.Backend(a.config.Storage.Backend),
+		Limits: storage.Limits{
+			MaxContacts:        a.config.Storage.MaxContacts,
+			MaxMessagesPerChat: a.config.Storage.MaxMessagesPerChat,
+			RejectFullChats:    a.config.Storage.OnFullChat == config.FullChatReject,
+			MaxSize:            int64(a.config.Storage.MaxSizeMB) << 20,
+		},
+		NoSyncWrites:   a.config.Storage.NoSyncWrites,
+		MemTableSize:   int64(a.config.Storage.MemTableSizeMB) << 20,
+		GCInterval:     a.config.Storage.GCInterval,
+		GCDiscardRatio: a.config.Storage.GCDiscardRatio,
 	}
 	
 	var err error
-	a.storage, err = storage.NewStorage(storageOpts)
+	a.storage, err = storage.Open(storageOpts)
 	if err != nil {
 		return err
 	}
+	a.sequences = newChatSequences(a.storage)
+	a.senders = newSenderSequences(a.storage)
+	a.unread = newUnreadCounts(a.storage)
 	
 	return nil
 }
 
+// StorageUsage returns how many contacts, chats and messages are stored and
+// how large the database is, with the configured limits
+func (a *App) StorageUsage() (*storage.Usage, error) {
+	return a.storage.Usage()
+}
+
 // initIdentity initializes or loads the user's identity
 func (a *App) initIdentity() error {
 	// Try to load existing identity from storage
 	if identity, err := a.storage.GetIdentity(a.config.User.ID); err == nil {
-		// Convert stored identity to crypto identity
-		// This is simplified - in a real implementation, we'd properly deserialize the keys
-		a.identity = &crypto.IdentityKeyPair{
-			Fingerprint: identity.Fingerprint,
-		}
+		a.identity = identityFromModel(identity)
+		a.preKey = preKeyFromModel(identity)
+		a.upgradeFingerprint()
 		log.Printf("Loaded existing identity for user %s", a.config.User.ID)
-		return nil
+		return a.ensurePreKey()
 	}
 	
 	// Generate new identity
@@ -100,15 +284,13 @@ func (a *App) initIdentity() error {
 	}
 	
 	a.identity = identity
-	
-	// Store identity
-	storedIdentity := &models.Identity{
-		UserID:      a.config.User.ID,
-		IdentityKey: identity.SigningKey.PublicKey,
-		Fingerprint: identity.Fingerprint,
+	a.preKey, err = crypto.GeneratePreKey(1, identity)
+	if err != nil {
+		return fmt.Errorf("failed to generate signed prekey: %w", err)
 	}
 	
-	if err := a.storage.SaveIdentity(storedIdentity); err != nil {
+	// Store identity
+	if err := a.storage.SaveIdentity(identityToModel(a.config.User.ID, identity, a.preKey)); err != nil {
 		log.Printf("Warning: failed to save identity: %v", err)
 	}
 	
@@ -116,27 +298,54 @@ func (a *App) initIdentity() error {
 	return nil
 }
 
-// initNetworkClient initializes the network client
+// initNetworkClient sets up a connection to every configured relay
 func (a *App) initNetworkClient() error {
 	if len(a.config.Network.RelayServers) == 0 {
 		return fmt.Errorf("no relay servers configured")
 	}
 	
-	// Use first relay server for now
-	serverURL := "ws://" + a.config.Network.RelayServers[0]
+	a.connections = NewConnectionManager(a.handleNetworkMessage, a.handleConnectionEvent, a.handleRelayPresence, a.handleSyncProgress)
+	a.connections.SetInvisible(a.config.Security.Invisible)
 	
-	clientOpts := network.ClientOptions{
-		ServerURL:         serverURL,
-		UserID:           a.config.User.ID,
-		MessageHandler:   a.handleNetworkMessage,
-		ConnectionHandler: a.handleConnectionEvent,
+	proxy, err := network.ParseProxy(a.config.Network.Proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy %q: %w", a.config.Network.Proxy, err)
 	}
 	
-	a.client = network.NewClient(clientOpts)
+	for _, relay := range a.config.Network.RelayServers {
+		clientOpts := network.ClientOptions{
+			ServerURL:         relayURL(relay),
+			UserID:            a.config.User.ID,
+			DeviceID:          a.config.User.DeviceID,
+			SigningKey:        a.identity.SigningKey.PrivateKey,
+			Proxy:             proxy,
+			ConnectionTimeout:    a.config.Network.ConnectionTimeout,
+			RelayFingerprint:     a.config.Network.RelayFingerprints[relay],
+			TLSServerName:        a.config.Network.RelayServerNames[relay],
+			MaxReconnectAttempts: a.config.Network.MaxReconnectAttempts,
+			UnlimitedReconnects:  a.config.Network.ReconnectUnlimited,
+			ReconnectDelay:       a.config.Network.ReconnectDelay,
+			HideLastSeen:         a.config.Security.HideLastSeen,
+			BatchWindow:          a.config.Network.BatchWindow,
+			BatchSize:            a.config.Network.BatchSize,
+		}
+		if _, err := a.connections.Add(relay, clientOpts); err != nil {
+			return err
+		}
+	}
 	
 	return nil
 }
 
+// relayURL returns the URL of a configured relay. Relays given as a bare
+// address are reached over plain WebSocket.
+func relayURL(relay string) string {
+	if strings.Contains(relay, "://") {
+		return relay
+	}
+	return "ws://" + relay
+}
+
 // loadContacts loads contacts from storage
 func (a *App) loadContacts() error {
 	contacts, err := a.storage.GetAllContacts()
@@ -144,60 +353,261 @@ func (a *App) loadContacts() error {
 		return err
 	}
 	
+	a.contactsMux.Lock()
 	for _, contact := range contacts {
 		a.contacts[contact.UserID] = contact
 	}
+	a.contactsMux.Unlock()
 	
-	log.Printf("Loaded %d contacts", len(a.contacts))
+	log.Printf("Loaded %d contacts", len(contacts))
 	return nil
 }
 
 // Connect connects to the network
 func (a *App) Connect() error {
-	return a.client.Connect()
+	return a.connections.Connect()
 }
 
 // Disconnect disconnects from the network
 func (a *App) Disconnect() error {
-	return a.client.Disconnect()
+	return a.connections.Disconnect()
+}
+
+// SetInvisible makes the user appear offline to everyone, or visible again.
+// Messages are still sent and received while invisible.
+func (a *App) SetInvisible(invisible bool) error {
+	a.config.Security.Invisible = invisible
+	return a.connections.SetInvisible(invisible)
 }
 
-// SendMessage sends a message to another user
+// SendMessage sends a message to another user. A user who is not a contact
+// is added as an unknown contact first. If verification is required and the
+// contact's key changed since it was verified, it fails with
+// ErrUnverifiedKeyChange; blocked contacts fail with ErrContactBlocked.
 func (a *App) SendMessage(to, content string) error {
-	// Check if we have this contact
-	contact, exists := a.contacts[to]
-	if !exists {
-		return fmt.Errorf("contact not found: %s", to)
-	}
+	return a.sendChat(to, content, "", false)
+}
+
+// SendMessageAnyway sends a message even if the contact's key changed and has
+// not been verified again. The override covers this message only, is
+// recorded in the audit trail, and leaves the key change warning in place.
+func (a *App) SendMessageAnyway(to, content string) error {
+	return a.sendChat(to, content, "", true)
+}
+
+// SendReply sends a message quoting an earlier message of the same chat
+func (a *App) SendReply(to, content, replyTo string) error {
+	return a.sendChat(to, content, replyTo, false)
+}
+
+// SendComposedMessage sends a chat message built by the caller, keeping its
+// ID so that a copy shown before sending can be matched with the status
+// updates passed to the message handlers. The sender, chat and sequence
+// number are filled in here, after the outgoing transforms ran. Unless
+// overrideKeyChange is set it fails with ErrUnverifiedKeyChange like
+// SendMessage.
+func (a *App) SendComposedMessage(msg *models.Message, overrideKeyChange bool) error {
+	to := models.NormalizeUserID(msg.To)
 	
-	// For now, send unencrypted message
-	// TODO: Implement proper encryption with Double Ratchet
-	err := a.client.SendMessage(to, content, "chat")
+	contact, err := a.chatContact(to)
 	if err != nil {
 		return err
 	}
+	if err := a.checkKeyChange(contact, overrideKeyChange); err != nil {
+		return err
+	}
+	id := msg.ID
+	address := func() {
+		msg.ID = id
+		msg.Type = models.MessageTypeChat
+		msg.From = a.config.User.ID
+		msg.To = to
+		msg.ChatID = a.getChatID(a.config.User.ID, to)
+	}
+	address()
+	if err := a.runOutgoingTransforms(msg); err != nil {
+		return err
+	}
+	// Transforms may rewrite the message but not which one it is or where
+	// it goes
+	address()
+	msg.Sequence = a.sequences.Next(msg.ChatID)
+	
+	if err := a.transmit(msg); err != nil {
+		return err
+	}
 	
-	// Save message to local storage
+	log.Printf("Sent message to %s (%s)", contact.GetDisplayName(), to)
+	return nil
+}
+
+// sendChat sends a chat message, optionally as a reply
+func (a *App) sendChat(to, content, replyTo string, overrideKeyChange bool) error {
 	msg := models.NewMessage(models.MessageTypeChat, a.config.User.ID, to, content)
-	msg.ChatID = a.getChatID(a.config.User.ID, to)
+	if replyTo != "" {
+		msg.Metadata = &models.Metadata{ReplyTo: replyTo}
+	}
+	return a.SendComposedMessage(msg, overrideKeyChange)
+}
+
+// transmit encrypts and sends a chat message, stores it with its new status
+// and starts its delivery timeout. A message to a user without an
+// encryption session waits for one to be set up instead. If it cannot be
+// sent, the stored message is left as it was.
+func (a *App) transmit(msg *models.Message) error {
+	if msg.DeviceID == "" {
+		msg.DeviceID = a.config.User.DeviceID
+		msg.DeviceName = a.config.User.DeviceName
+	}
+	if !a.hasSession(msg.To) || a.rekeyDue(msg.To) {
+		return a.awaitSession(msg)
+	}
+	
+	// A new message keeps its number only if it goes out or is queued to;
+	// otherwise the number is given back, so the recipient sees no gap. If
+	// a later message of the chat was numbered meanwhile the number stays
+	// used, and the recipient gives up on it once asking for it again
+	// brings nothing.
+	numbered := msg.SenderSequence == 0
+	if numbered {
+		msg.SenderSequence = a.senders.Next(msg.ChatID)
+	}
+	settleNumber := func(keep bool) {
+		if !numbered {
+			return
+		}
+		if !keep {
+			a.senders.Release(msg.ChatID, msg.SenderSequence)
+			msg.SenderSequence = 0
+		}
+		numbered = false
+	}
+	defer settleNumber(false)
+	
+	var replyTo, forwardedFrom string
+	if msg.Metadata != nil {
+		replyTo = msg.Metadata.ReplyTo
+		forwardedFrom = msg.Metadata.ForwardedFrom
+	}
+	
+	sealed, err := a.sealChatPayload(msg.To, network.ChatPayload{
+		Content:        msg.Content,
+		Sequence:       msg.Sequence,
+		SenderSequence: msg.SenderSequence,
+		ReplyTo:        replyTo,
+		ForwardedFrom:  forwardedFrom,
+		DeviceID:       msg.DeviceID,
+		DeviceName:     msg.DeviceName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %w", err)
+	}
+	payload, err := network.EncodePayload(sealed)
+	if err != nil {
+		return err
+	}
+	msg.Encrypted = true
+	netMsg := &network.Message{
+		ID:        msg.ID,
+		Type:      string(msg.Type),
+		From:      msg.From,
+		To:        msg.To,
+		Timestamp: msg.Timestamp.Unix(),
+		Payload:   payload,
+	}
+	if err := a.signMessage(netMsg); err != nil {
+		return err
+	}
 	
+	// Store the message as sent and arm its timeout before it goes out, so
+	// an ack that beats Send returning finds it and is not overwritten
+	a.statusMux.Lock()
+	previous, _ := a.storage.GetMessage(msg.ChatID, msg.ID)
+	msg.UpdateStatus(models.MessageStatusSent)
 	if err := a.storage.SaveMessage(msg); err != nil {
 		log.Printf("Warning: failed to save sent message: %v", err)
 	}
+	a.statusMux.Unlock()
+	a.armDeliveryTimeout(msg)
 	
-	// Notify handlers
-	for _, handler := range a.messageHandlers {
-		if err := handler(msg); err != nil {
-			log.Printf("Message handler error: %v", err)
+	via, sendErr := a.connections.Send(netMsg)
+	msg.Via = via
+	switch {
+	case sendErr == nil:
+		// Pick up any ack that arrived meanwhile
+		stored, err := a.updateStoredMessage(msg.ChatID, msg.ID, func(stored *models.Message) bool {
+			stored.Via = via
+			return true
+		})
+		if err == nil {
+			msg.Status = stored.Status
 		}
+	case errors.Is(sendErr, network.ErrNotConnected), errors.Is(sendErr, network.ErrOutboxFull):
+		// Keep the message pending in the outbox until a relay is reachable
+		a.settleDelivery(msg.ChatID, msg.ID)
+		msg.Status = models.MessageStatusPending
+		a.updateStoredMessage(msg.ChatID, msg.ID, func(stored *models.Message) bool {
+			stored.Status = models.MessageStatusPending
+			return true
+		})
+	default:
+		// Leave the message as it was before this attempt
+		a.settleDelivery(msg.ChatID, msg.ID)
+		a.statusMux.Lock()
+		var err error
+		if previous != nil {
+			err = a.storage.SaveMessage(previous)
+		} else {
+			err = a.storage.DeleteMessage(msg.ChatID, msg.ID)
+		}
+		a.statusMux.Unlock()
+		if err != nil {
+			log.Printf("Warning: failed to roll back message %s: %v", msg.ID, err)
+		}
+		return sendErr
 	}
+	settleNumber(true)
+	a.scheduleExpiry(msg)
+	a.clocks.observe(msg)
 	
-	log.Printf("Sent message to %s (%s)", contact.GetDisplayName(), to)
+	// Notify handlers
+	a.notifyMessageHandlers(msg)
+	
+	if sendErr != nil {
+		a.queueMessage(msg)
+		log.Printf("Message %s to %s queued until a relay is reachable: %v", msg.ID, msg.To, sendErr)
+	}
 	return nil
 }
 
-// AddContact adds a new contact
+// updateStoredMessage applies update to the stored copy of a message,
+// saving it if update reports a change, and returns it. Status changes of
+// messages go through it, so that an ack, a delivery timeout, transmit and
+// marking a conversation read do not overwrite each other's.
+func (a *App) updateStoredMessage(chatID, messageID string, update func(stored *models.Message) bool) (*models.Message, error) {
+	a.statusMux.Lock()
+	defer a.statusMux.Unlock()
+	
+	stored, err := a.storage.GetMessage(chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if update(stored) {
+		if err := a.storage.SaveMessage(stored); err != nil {
+			log.Printf("Warning: failed to save message status: %v", err)
+		}
+	}
+	return stored, nil
+}
+
+// AddContact adds a new contact. An unknown contact of an ad-hoc chat
+// becomes a regular one, keeping its key and presence.
 func (a *App) AddContact(userID, displayName string) error {
+	userID, err := models.ParseUserID(userID)
+	if err != nil {
+		return err
+	}
+	
 	// Create contact
 	contact := &models.Contact{
 		UserID:      userID,
@@ -205,41 +615,97 @@ func (a *App) AddContact(userID, displayName string) error {
 		Status:      models.UserStatusOffline,
 	}
 	
-	// Save to storage
+	// Save to storage and memory together, so a concurrent add of the same
+	// contact cannot leave the two disagreeing
+	a.contactsMux.Lock()
+	event := ContactAdded
+	if existing, exists := a.contacts[userID]; exists && existing.Unknown {
+		promoted := *existing
+		promoted.DisplayName = displayName
+		promoted.Unknown = false
+		contact = &promoted
+		event = ContactUpdated
+	}
 	if err := a.storage.SaveContact(contact); err != nil {
+		a.contactsMux.Unlock()
 		return fmt.Errorf("failed to save contact: %w", err)
 	}
-	
-	// Add to memory
 	a.contacts[userID] = contact
+	added := *contact
+	a.contactsMux.Unlock()
+	a.syncPresenceSubscriptions()
+	a.notifyContactEvent(event, &added)
 	
 	log.Printf("Added contact: %s (%s)", displayName, userID)
 	return nil
 }
 
-// GetContacts returns all contacts
+// RemoveContact deletes a contact and stops following their presence
+func (a *App) RemoveContact(userID string) error {
+	userID = models.NormalizeUserID(userID)
+	
+	a.contactsMux.Lock()
+	contact, exists := a.contacts[userID]
+	if !exists {
+		a.contactsMux.Unlock()
+		return fmt.Errorf("%w: %s", errContactNotFound, userID)
+	}
+	if err := a.storage.DeleteContact(userID); err != nil {
+		a.contactsMux.Unlock()
+		return fmt.Errorf("failed to delete contact: %w", err)
+	}
+	delete(a.contacts, userID)
+	removed := *contact
+	a.contactsMux.Unlock()
+	a.syncPresenceSubscriptions()
+	a.presenceFlushes.cancel(userID)
+	a.notifyContactEvent(ContactRemoved, &removed)
+	
+	log.Printf("Removed contact: %s", userID)
+	return nil
+}
+
+// GetContacts returns copies of all contacts
 func (a *App) GetContacts() []*models.Contact {
+	a.contactsMux.RLock()
+	defer a.contactsMux.RUnlock()
+	
 	contacts := make([]*models.Contact, 0, len(a.contacts))
 	for _, contact := range a.contacts {
-		contacts = append(contacts, contact)
+		copied := *contact
+		contacts = append(contacts, &copied)
 	}
 	return contacts
 }
 
+// GetMessage returns a message of a chat with another user by ID. It
+// returns nil without an error if the message does not exist, for example
+// because it was deleted or expired.
+func (a *App) GetMessage(otherUserID, messageID string) (*models.Message, error) {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+	msg, err := a.storage.GetMessage(chatID, messageID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	return msg, err
+}
+
+// GetMessagesBefore returns up to limit messages of a chat that precede
+// before, oldest first. A nil before returns the most recent messages.
+func (a *App) GetMessagesBefore(otherUserID string, before *models.Message, limit int) ([]*models.Message, error) {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+	return a.storage.GetMessagesBefore(chatID, before, limit)
+}
+
 // GetMessages returns messages for a chat
 func (a *App) GetMessages(otherUserID string, limit int) ([]*models.Message, error) {
-	chatID := a.getChatID(a.config.User.ID, otherUserID)
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
 	return a.storage.GetMessages(chatID, limit, 0)
 }
 
-// AddMessageHandler adds a message handler
-func (a *App) AddMessageHandler(handler MessageHandler) {
-	a.messageHandlers = append(a.messageHandlers, handler)
-}
-
 // IsConnected returns true if connected to the network
 func (a *App) IsConnected() bool {
-	return a.client.IsConnected()
+	return a.connections.IsConnected()
 }
 
 // GetUserID returns the current user's ID
@@ -260,66 +726,337 @@ func (a *App) GetFingerprint() string {
 	return a.identity.Fingerprint
 }
 
-// Close closes the application and cleans up resources
+// Close closes the application and cleans up resources. Calls after the
+// first do nothing.
 func (a *App) Close() error {
-	if a.client != nil {
-		a.client.Close()
+	a.closeOnce.Do(a.close)
+	return nil
+}
+
+// close stops background work and closes the connections and storage
+func (a *App) close() {
+	close(a.done)
+	a.background.Wait()
+	a.deliveries.stopAll()
+	a.expiries.stopAll()
+	a.presenceFlushes.stopAll()
+	a.notificationFlushes.stopAll()
+	a.handshakeRetries.stopAll()
+	a.gapChecks.stopAll()
+	
+	if a.connections != nil {
+		a.connections.Close()
 	}
 	
 	if a.storage != nil {
 		a.storage.Close()
 	}
-	
-	return nil
 }
 
-// handleNetworkMessage handles incoming network messages
-func (a *App) handleNetworkMessage(netMsg *network.Message) error {
+// handleNetworkMessage handles messages received on any connection
+func (a *App) handleNetworkMessage(via string, netMsg *network.Message) error {
+	a.markReceived()
+	
+	if a.config.Debug {
+		log.Printf("Debug: %s message %s from %s via %s, payload %s",
+			netMsg.Type, netMsg.ID, netMsg.From, via, redact.Payload(netMsg.Payload))
+	}
+	
+	// Only chat messages belong in the chat history; control messages go to
+	// their handlers and are never stored
+	switch netMsg.Type {
+	case string(models.MessageTypeChat):
+	case string(models.MessageTypeSystem):
+		// System notices are only ever made by this client, so one from a
+		// peer would pass off its text as the client's own
+		return fmt.Errorf("dropping message %s: system notice from %s", netMsg.ID, netMsg.From)
+	case string(models.MessageTypeAck):
+		return a.handleAck(netMsg)
+	case messageTypeFileOffer, messageTypeFileRequest, messageTypeFileChunk, messageTypeFileComplete:
+		return a.handleTransferMessage(netMsg)
+	case string(models.MessageTypeTyping):
+		return a.handleTyping(netMsg)
+	case string(models.MessageTypePresence):
+		return a.handlePeerPresence(netMsg)
+	case network.MessageTypeAnnouncement:
+		return a.handleAnnouncement(via, netMsg)
+	case network.MessageTypeKeyRequest:
+		return a.handleKeyRequest(netMsg)
+	case network.MessageTypeKeyBundle:
+		return a.handleKeyBundle(netMsg)
+	case network.MessageTypeSessionInit:
+		return a.handleSessionInit(netMsg)
+	case network.MessageTypeResendRequest:
+		return a.handleResendRequest(netMsg)
+	case string(models.MessageTypeError):
+		var relayErr network.ErrorPayload
+		if err := netMsg.DecodePayload(&relayErr); err != nil {
+			return err
+		}
+		log.Printf("Relay error %s: %s", relayErr.Code, relayErr.Message)
+		return nil
+	default:
+		// server_hello and anything this client does not understand
+		return nil
+	}
+	
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping message %s: %w", netMsg.ID, err)
+	}
+	to := models.NormalizeUserID(netMsg.To)
+	
+	var payload network.ChatPayload
+	if err := netMsg.DecodePayload(&payload); err != nil {
+		a.recordDebugInfo(via, netMsg, a.getChatID(from, to), nil, err)
+		return fmt.Errorf("dropping message %s: %w", netMsg.ID, err)
+	}
+	if err := a.checkSender(from, netMsg); err != nil {
+		a.recordDebugInfo(via, netMsg, a.getChatID(from, to), &payload, err)
+		return fmt.Errorf("dropping message %s: %w", netMsg.ID, err)
+	}
+	if payload.Header != nil {
+		if err := a.openChatPayload(from, &payload); err != nil {
+			a.recordDebugInfo(via, netMsg, a.getChatID(from, to), &payload, err)
+			return fmt.Errorf("dropping message %s: %w", netMsg.ID, err)
+		}
+	}
+	
 	// Convert network message to internal message
 	msg := &models.Message{
-		ID:        netMsg.ID,
-		Type:      models.MessageType(netMsg.Type),
-		From:      netMsg.From,
-		To:        netMsg.To,
-		ChatID:    a.getChatID(netMsg.From, netMsg.To),
-		Timestamp: timeFromUnix(netMsg.Timestamp),
+		ID:             netMsg.ID,
+		Type:           models.MessageType(netMsg.Type),
+		From:           from,
+		To:             to,
+		ChatID:         a.getChatID(from, to),
+		Timestamp:      timeFromUnix(netMsg.Timestamp),
+		Content:        payload.Content,
+		Sequence:       payload.Sequence,
+		SenderSequence: payload.SenderSequence,
+		Via:            via,
+		
+		DeviceID:   payload.DeviceID,
+		DeviceName: payload.DeviceName,
+	}
+	if payload.ReplyTo != "" || payload.ForwardedFrom != "" {
+		msg.Metadata = &models.Metadata{
+			ReplyTo:       payload.ReplyTo,
+			ForwardedFrom: models.NormalizeUserID(payload.ForwardedFrom),
+		}
 	}
 	
-	// Extract content from payload
-	if content, ok := netMsg.Payload["content"].(string); ok {
-		msg.Content = content
+	msg.Encrypted = payload.Header != nil
+	
+	// A plaintext message in an encrypted conversation is refused before it
+	// is acknowledged, so the sender does not take it as delivered
+	if msg.Type == models.MessageTypeChat && !msg.Encrypted {
+		if err := a.checkDowngrade(from, msg.ID); err != nil {
+			return fmt.Errorf("dropping message %s: %w", msg.ID, err)
+		}
+	}
+	
+	// Acknowledge every copy so the sender stops waiting, but only store
+	// and surface the first one
+	if msg.Type == models.MessageTypeChat {
+		a.sendReceipt(msg)
+	}
+	if a.isDuplicate(msg) {
+		log.Printf("Ignoring duplicate message %s from %s", msg.ID, msg.From)
+		return nil
+	}
+	a.recentIDs.Add(msg.ID)
+	a.checkClockSkew(msg, time.Now())
+	a.recordDebugInfo(via, netMsg, msg.ChatID, &payload, nil)
+	
+	// A jump in sequence means earlier messages from the peer are still in
+	// flight or were lost; they sort into place when they arrive
+	if msg.Sequence > 0 {
+		var missing uint64
+		msg.Sequence, missing = a.sequences.Observe(msg.ChatID, msg.Sequence)
+		if missing > 0 {
+			log.Printf("Message %s from %s arrived ahead of %d earlier message(s)", msg.ID, msg.From, missing)
+		}
+	}
+	a.observeSenderSequence(msg)
+	
+	// Bots and integrations may consume or rewrite the message first
+	if a.runIncomingHooks(msg) {
+		return nil
 	}
 	
 	// Save message to storage
+	unread := a.arrivedUnread(msg)
 	if err := a.storage.SaveMessage(msg); err != nil {
 		log.Printf("Warning: failed to save received message: %v", err)
+	} else if unread {
+		a.unread.Add(msg.ChatID)
 	}
+	a.scheduleExpiry(msg)
 	
 	// Notify handlers
-	for _, handler := range a.messageHandlers {
-		if err := handler(msg); err != nil {
-			log.Printf("Message handler error: %v", err)
+	a.notifyMessageHandlers(msg)
+	a.notifyNewMessage(msg)
+	a.webhookNewMessage(msg)
+	
+	log.Printf("Received message %s from %s: %s", msg.ID, msg.From, redact.Content(msg.Content))
+	return nil
+}
+
+// isDuplicate reports whether a received message has already been stored
+func (a *App) isDuplicate(msg *models.Message) bool {
+	if a.recentIDs.Seen(msg.ID) {
+		return true
+	}
+	
+	exists, err := a.storage.HasMessage(msg.ChatID, msg.ID)
+	if err != nil {
+		log.Printf("Warning: failed to check for duplicate message: %v", err)
+		return false
+	}
+	
+	return exists
+}
+
+// sendReceipt tells the sender that a message reached this client
+func (a *App) sendReceipt(msg *models.Message) {
+	receipt := network.AckPayload{
+		MessageID: msg.ID,
+		Recipient: a.config.User.ID,
+		Status:    string(models.MessageStatusDelivered),
+	}
+	
+	if err := a.sendPayload(msg.From, string(models.MessageTypeAck), receipt); err != nil {
+		log.Printf("Warning: failed to acknowledge message %s: %v", msg.ID, err)
+	}
+}
+
+// handleAck applies a delivery status from the relay or the recipient to a
+// previously sent message
+func (a *App) handleAck(netMsg *network.Message) error {
+	var ack network.AckPayload
+	if err := netMsg.DecodePayload(&ack); err != nil {
+		return fmt.Errorf("malformed ack %s: %w", netMsg.ID, err)
+	}
+	messageID, recipient, status := ack.MessageID, models.NormalizeUserID(ack.Recipient), models.MessageStatus(ack.Status)
+	
+	// The relay reports how far it got the message, and only the recipient
+	// that it arrived or was read
+	switch from := models.NormalizeUserID(netMsg.From); {
+	case from == "server":
+		if status == models.MessageStatusRead {
+			return fmt.Errorf("dropping ack %s: the relay cannot report a message read", netMsg.ID)
 		}
+	case from != recipient:
+		return fmt.Errorf("dropping ack %s: sent by %s, not the recipient %s", netMsg.ID, from, recipient)
+	case status != models.MessageStatusDelivered && status != models.MessageStatusRead:
+		return fmt.Errorf("dropping ack %s: %s cannot report a message %s", netMsg.ID, from, status)
 	}
 	
-	log.Printf("Received message from %s: %s", msg.From, msg.Content)
+	chatID := a.getChatID(a.config.User.ID, recipient)
+	changed := false
+	msg, err := a.updateStoredMessage(chatID, messageID, func(stored *models.Message) bool {
+		changed = stored.UpdateStatus(status)
+		return changed
+	})
+	if err != nil {
+		return fmt.Errorf("ack for unknown message %s: %w", messageID, err)
+	}
+	
+	// The relay or the recipient has the message, so it can no longer time out
+	a.settleDelivery(chatID, messageID)
+	
+	if !changed {
+		return nil
+	}
+	
+	// Notify handlers so views can re-render the status
+	a.notifyMessageHandlers(msg)
+	
 	return nil
 }
 
-// handleConnectionEvent handles network connection events
-func (a *App) handleConnectionEvent(event network.ConnectionEvent) {
+// syncPresenceSubscriptions subscribes every relay connection to the
+// presence of the current contacts. Connections send the relay only what
+// changed, and the whole list again after reconnecting.
+func (a *App) syncPresenceSubscriptions() {
+	a.contactsMux.RLock()
+	userIDs := make([]string, 0, len(a.contacts))
+	for userID := range a.contacts {
+		userIDs = append(userIDs, userID)
+	}
+	a.contactsMux.RUnlock()
+	
+	if err := a.connections.SetPresenceSubscriptions(userIDs); err != nil {
+		log.Printf("Failed to update presence subscriptions: %v", err)
+	}
+}
+
+// handlePresence updates cached contact status and last-seen time from the relay
+func (a *App) handlePresence(presence map[string]network.Presence) {
+	for userID, p := range presence {
+		err := a.changeContact(userID, ContactPresence, func(contact *models.Contact) bool {
+			wasOnline := contact.Status != "" && contact.Status != models.UserStatusOffline
+			contact.Status = models.UserStatus(p.Status)
+			contact.LastSeenHidden = p.LastSeenHidden
+			
+			switch {
+			case p.LastSeenHidden:
+				contact.LastSeen = time.Time{}
+			case contact.Status != models.UserStatusOffline:
+				contact.LastSeen = time.Now()
+			case !p.LastSeen.IsZero():
+				contact.LastSeen = p.LastSeen
+			case wasOnline:
+				// We saw them go offline but the relay had no time for it
+				contact.LastSeen = time.Now()
+			}
+			return true
+		})
+		if err != nil && !errors.Is(err, errContactNotFound) {
+			log.Printf("Warning: failed to save contact presence: %v", err)
+		}
+	}
+}
+
+// handleConnectionEvent handles state changes of each connection
+func (a *App) handleConnectionEvent(via string, event network.ConnectionEvent) {
 	switch event.Type {
 	case network.ConnectionEventConnected:
-		log.Printf("Connected to relay server")
+		log.Printf("Connected to relay server %s", via)
+		a.notifyConnectionStateHandlers()
+		a.notifyConnectionQualityHandlers()
+		go a.drainOutbox()
+		go a.retryUnsentKeyRequests()
+		go a.resumeTransfers()
 	case network.ConnectionEventDisconnected:
-		log.Printf("Disconnected from relay server")
+		log.Printf("Disconnected from relay server %s", via)
+		a.notifyConnectionStateHandlers()
+		a.notifyConnectionQualityHandlers()
+	case network.ConnectionEventHello:
+		a.checkDeliveryMode(via)
+	case network.ConnectionEventQuality:
+		if event.Quality.Degraded {
+			log.Printf("Connection to relay server %s is slow: %s round trips", via, event.Quality.RTT.Round(time.Millisecond))
+		}
+		a.notifyConnectionQualityHandlers()
 	case network.ConnectionEventReconnecting:
-		log.Printf("Reconnecting to relay server...")
+		log.Printf("Reconnecting to relay server %s...", via)
+		a.notifyConnectionStateHandlers()
 	case network.ConnectionEventError:
-		log.Printf("Connection error: %v", event.Error)
+		log.Printf("Connection error on %s: %v", via, event.Error)
+		a.notifyConnectionStateHandlers()
+		if !a.IsConnected() {
+			a.sendWebhooks(WebhookPayload{Event: config.WebhookConnectionLost, Relay: via, Error: fmt.Sprint(event.Error)})
+		}
 	}
 }
 
+// handleRelayPresence applies presence reported by a relay
+func (a *App) handleRelayPresence(via string, presence map[string]network.Presence) {
+	a.markReceived()
+	a.handlePresence(presence)
+}
+
 // getChatID generates a consistent chat ID for two users
 func (a *App) getChatID(user1, user2 string) string {
 	if user1 < user2 {
@@ -0,0 +1,304 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/internal/redact"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+func TestNewAppNormalizesUserID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := config.Default()
+	cfg.Storage.Backend = config.StorageMemory
+
+	cfg.User.ID = "a b"
+	if _, err := NewApp(cfg); err == nil {
+		t.Fatal("NewApp accepted an invalid user ID")
+	}
+
+	cfg.User.ID = " Alice "
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	defer app.Close()
+	if app.config.User.ID != "alice" {
+		t.Errorf("User.ID = %q, want alice", app.config.User.ID)
+	}
+}
+
+// sentMessage stores a chat message from app's user to "bob", as sent
+func sentMessage(t *testing.T, app *App) *models.Message {
+	t.Helper()
+
+	msg := models.NewMessage(models.MessageTypeChat, app.config.User.ID, "bob", "hi")
+	msg.ChatID = app.getChatID(app.config.User.ID, "bob")
+	msg.Status = models.MessageStatusSent
+	if err := app.storage.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	return msg
+}
+
+// ackMessage returns an ack from from reporting status for messageID
+func ackMessage(t *testing.T, from, messageID, status string) *network.Message {
+	t.Helper()
+
+	payload, err := network.EncodePayload(network.AckPayload{MessageID: messageID, Recipient: "bob", Status: status})
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	return &network.Message{ID: "ack-" + status, Type: network.MessageTypeAck, From: from, To: "alice", Payload: payload}
+}
+
+func TestRelayAcksMoveStatusForward(t *testing.T) {
+	app := newTestApp(t, "alice")
+	msg := sentMessage(t, app)
+
+	steps := []struct {
+		from, status string
+		want         models.MessageStatus
+	}{
+		{"server", "queued", models.MessageStatusQueued},
+		{"server", "delivered", models.MessageStatusDelivered},
+		// A queued status arriving late does not undo the delivery
+		{"server", "queued", models.MessageStatusDelivered},
+		{"bob", "read", models.MessageStatusRead},
+	}
+	for _, step := range steps {
+		app.handleNetworkMessage(testRelay, ackMessage(t, step.from, msg.ID, step.status))
+
+		stored, err := app.storage.GetMessage(msg.ChatID, msg.ID)
+		if err != nil {
+			t.Fatalf("GetMessage: %v", err)
+		}
+		if stored.Status != step.want {
+			t.Fatalf("after %s ack from %s, Status = %s, want %s", step.status, step.from, stored.Status, step.want)
+		}
+	}
+}
+
+func TestAckStatusesAreCheckedAgainstTheSender(t *testing.T) {
+	tests := []struct {
+		name, from, status string
+	}{
+		{"relay claims read", "server", "read"},
+		{"recipient claims queued", "bob", "queued"},
+		{"other user", "carol", "delivered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, "alice")
+			msg := sentMessage(t, app)
+
+			if err := app.handleAck(ackMessage(t, tt.from, msg.ID, tt.status)); err == nil {
+				t.Error("handleAck accepted the ack")
+			}
+			stored, err := app.storage.GetMessage(msg.ChatID, msg.ID)
+			if err != nil {
+				t.Fatalf("GetMessage: %v", err)
+			}
+			if stored.Status != models.MessageStatusSent {
+				t.Errorf("Status = %s, want sent", stored.Status)
+			}
+		})
+	}
+}
+
+func TestPresenceUpdatesLastSeen(t *testing.T) {
+	app := newTestApp(t, "alice")
+	if err := app.AddContact("bob", "Bob"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+
+	app.handlePresence(map[string]network.Presence{"bob": {Status: "online"}})
+	before := time.Now()
+	app.handlePresence(map[string]network.Presence{"bob": {Status: "offline"}})
+
+	contact, _ := app.GetContact("bob")
+	if contact.Status != models.UserStatusOffline {
+		t.Errorf("Status = %s, want offline", contact.Status)
+	}
+	if contact.LastSeen.Before(before) {
+		t.Errorf("LastSeen = %v, want the time bob went offline", contact.LastSeen)
+	}
+	stored, err := app.storage.GetContact("bob")
+	if err != nil {
+		t.Fatalf("GetContact: %v", err)
+	}
+	if !stored.LastSeen.Equal(contact.LastSeen) {
+		t.Errorf("stored LastSeen = %v, want %v", stored.LastSeen, contact.LastSeen)
+	}
+
+	// The relay's time wins over when the update arrived
+	seen := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	app.handlePresence(map[string]network.Presence{"bob": {Status: "offline", LastSeen: seen}})
+	if contact, _ := app.GetContact("bob"); !contact.LastSeen.Equal(seen) {
+		t.Errorf("LastSeen = %v, want the relay's %v", contact.LastSeen, seen)
+	}
+}
+
+func TestHiddenLastSeenIsNotKept(t *testing.T) {
+	app := newTestApp(t, "alice")
+	if err := app.AddContact("bob", "Bob"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	app.handlePresence(map[string]network.Presence{"bob": {Status: "offline", LastSeen: time.Now()}})
+
+	app.handlePresence(map[string]network.Presence{"bob": {Status: "offline", LastSeenHidden: true}})
+	stored, err := app.storage.GetContact("bob")
+	if err != nil {
+		t.Fatalf("GetContact: %v", err)
+	}
+	if !stored.LastSeenHidden || !stored.LastSeen.IsZero() {
+		t.Errorf("LastSeenHidden = %v, LastSeen = %v, want hidden and no time", stored.LastSeenHidden, stored.LastSeen)
+	}
+}
+
+// logCapture collects what is written to the standard logger
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *logCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+// captureLog sends the standard logger's output to a capture for the rest
+// of the test
+func captureLog(t *testing.T) *logCapture {
+	capture := &logCapture{}
+	log.SetOutput(capture)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return capture
+}
+
+func TestMessageContentIsRedactedInLogs(t *testing.T) {
+	const secret = "the launch code is 0000"
+	tests := []struct {
+		name      string
+		debug     bool
+		plaintext bool
+	}{
+		{"default", false, false},
+		{"debug", true, false},
+		{"plaintext opt-in", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, "alice")
+			app.config.Debug = tt.debug
+			redact.SetPlaintext(tt.plaintext)
+			t.Cleanup(func() { redact.SetPlaintext(false) })
+			logged := captureLog(t)
+
+			if err := app.handleNetworkMessage(testRelay, incomingChat(t, app, "bob", "m1", secret)); err != nil {
+				t.Fatalf("handleNetworkMessage: %v", err)
+			}
+			if got := strings.Contains(logged.String(), secret); got != tt.plaintext {
+				t.Errorf("content in the log = %v, want %v:\n%s", got, tt.plaintext, logged)
+			}
+			if tt.debug && !strings.Contains(logged.String(), "Debug: chat message m1") {
+				t.Errorf("debug log does not describe the message:\n%s", logged)
+			}
+		})
+	}
+}
+
+func TestRelayedContentIsRedactedInLogs(t *testing.T) {
+	const secret = "the launch code is 0000"
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	alice.config.Debug, bob.config.Debug = true, true
+	logged := captureLog(t)
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	if err := alice.AddContact("bob", ""); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+
+	exchange(t, alice, bob, secret)
+	if strings.Contains(logged.String(), secret) {
+		t.Errorf("content reached the client, app or relay log:\n%s", logged)
+	}
+}
+
+func TestContactsAndSessionsAreSafeForConcurrentUse(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	for _, pair := range [][2]*App{{alice, bob}, {bob, alice}} {
+		if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	exchange(t, alice, bob, "hello")
+
+	// Meant for -race: messages arrive on the network goroutines while
+	// alice edits her contacts and sends from others
+	const count = 20
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			if err := bob.SendMessage("alice", fmt.Sprintf("from bob %d", i)); err != nil {
+				t.Errorf("SendMessage: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			userID := fmt.Sprintf("user%d", i)
+			if err := alice.AddContact(userID, ""); err != nil {
+				t.Errorf("AddContact: %v", err)
+			}
+			alice.GetContacts()
+			alice.GetContact("bob")
+			if i%2 == 0 {
+				if err := alice.RemoveContact(userID); err != nil {
+					t.Errorf("RemoveContact: %v", err)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			if err := alice.SendMessage("bob", fmt.Sprintf("from alice %d", i)); err != nil {
+				t.Errorf("SendMessage: %v", err)
+			}
+			alice.SessionKeyedAt("bob")
+		}
+	}()
+	wg.Wait()
+
+	waitFor(t, "bob's messages", func() bool { return hasMessage(alice, "bob", fmt.Sprintf("from bob %d", count-1)) })
+	waitFor(t, "alice's messages", func() bool { return hasMessage(bob, "alice", fmt.Sprintf("from alice %d", count-1)) })
+	if got, want := len(alice.GetContacts()), 1+count/2; got != want {
+		t.Errorf("alice has %d contacts, want %d", got, want)
+	}
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/rivo/uniseg"
+)
+
+// ErrInvalidAvatar is returned for an avatar that is not a single emoji or
+// character
+var ErrInvalidAvatar = errors.New("avatar must be a single emoji or character")
+
+// SetContactAvatar sets the emoji shown for a contact in place of their
+// initials. An empty avatar goes back to the initials.
+func (a *App) SetContactAvatar(userID, avatar string) error {
+	avatar = strings.TrimSpace(avatar)
+	if avatar != "" && uniseg.GraphemeClusterCount(avatar) != 1 {
+		return fmt.Errorf("%w: %q", ErrInvalidAvatar, avatar)
+	}
+	return a.updateContact(userID, func(contact *models.Contact) bool {
+		if contact.Avatar == avatar {
+			return false
+		}
+		contact.Avatar = avatar
+		return true
+	})
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// chatClocks remembers the latest message of each chat seen since startup,
+// by sequence number, to catch replies claiming to predate what they follow
+type chatClocks struct {
+	mu     sync.Mutex
+	latest map[string]clockMark
+}
+
+// clockMark is the sequence number and display time of a message
+type clockMark struct {
+	sequence uint64
+	time     time.Time
+}
+
+func newChatClocks() *chatClocks {
+	return &chatClocks{latest: make(map[string]clockMark)}
+}
+
+// observe records a message if it is the latest of its chat so far
+func (c *chatClocks) observe(msg *models.Message) {
+	if msg.Sequence == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mark, ok := c.latest[msg.ChatID]; !ok || msg.Sequence > mark.sequence {
+		c.latest[msg.ChatID] = clockMark{sequence: msg.Sequence, time: msg.DisplayTime()}
+	}
+}
+
+// precedes returns the time of the latest message a received message
+// follows in its chat, or the zero time if none is known
+func (c *chatClocks) precedes(msg *models.Message) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mark, ok := c.latest[msg.ChatID]
+	if !ok || msg.Sequence == 0 || msg.Sequence <= mark.sequence {
+		return time.Time{}
+	}
+	return mark.time
+}
+
+// checkClockSkew records when a message arrived and flags it if the
+// sender's timestamp cannot be right: later than its arrival, or earlier
+// than the message it follows in the chat, by more than
+// models.ClockSkewTolerance. A message may legitimately be much older than
+// its arrival when a relay held it, so that alone is not flagged.
+func (a *App) checkClockSkew(msg *models.Message, receivedAt time.Time) {
+	msg.ReceivedAt = receivedAt
+
+	ahead := msg.Timestamp.Sub(receivedAt)
+	var behind time.Duration
+	if previous := a.clocks.precedes(msg); !previous.IsZero() {
+		behind = previous.Sub(msg.Timestamp)
+	}
+
+	if ahead > models.ClockSkewTolerance || behind > models.ClockSkewTolerance {
+		msg.ClockSkewed = true
+		log.Printf("Clock of %s appears to be off: message %s is stamped %s, received %s",
+			msg.From, msg.ID, msg.Timestamp.Format(time.RFC3339), receivedAt.Format(time.RFC3339))
+	}
+	a.clocks.observe(msg)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	receivedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		previous time.Duration // Time of the message followed, from receipt; 0 for none
+		sent     time.Duration // Sender's timestamp, from receipt
+		want     bool
+	}{
+		{"on time", 0, -time.Second, false},
+		{"hours ahead", 0, 3 * time.Hour, true},
+		{"ahead within tolerance", 0, models.ClockSkewTolerance - time.Second, false},
+		{"held by the relay", 0, -48 * time.Hour, false},
+		{"hours before the message followed", -time.Minute, -3 * time.Hour, true},
+		{"just before the message followed", -time.Minute, -time.Minute - time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, "alice")
+			if tt.previous != 0 {
+				app.clocks.observe(&models.Message{ChatID: "alice:bob", Sequence: 1, Timestamp: receivedAt.Add(tt.previous)})
+			}
+			sent := receivedAt.Add(tt.sent)
+			msg := &models.Message{ID: "m2", From: "bob", ChatID: "alice:bob", Sequence: 2, Timestamp: sent}
+			app.checkClockSkew(msg, receivedAt)
+
+			if msg.ClockSkewed != tt.want {
+				t.Errorf("ClockSkewed = %v, want %v", msg.ClockSkewed, tt.want)
+			}
+			if !msg.Timestamp.Equal(sent) || !msg.ReceivedAt.Equal(receivedAt) {
+				t.Errorf("stamped %s, received %s, want both kept", msg.Timestamp, msg.ReceivedAt)
+			}
+			wantDisplay := sent
+			if tt.want {
+				wantDisplay = receivedAt
+			}
+			if got := msg.DisplayTime(); !got.Equal(wantDisplay) {
+				t.Errorf("DisplayTime = %s, want %s", got, wantDisplay)
+			}
+		})
+	}
+}
+
+func TestSkewedMessagesAreOrderedByArrival(t *testing.T) {
+	app := newTestApp(t, "alice")
+	now := time.Now()
+	send := func(id string, sequence uint64, sent time.Time) {
+		t.Helper()
+		payload, err := network.EncodePayload(network.ChatPayload{Content: id, Sequence: sequence})
+		if err != nil {
+			t.Fatalf("EncodePayload: %v", err)
+		}
+		msg := incomingChat(t, app, "bob", id, id)
+		msg.Payload = payload
+		msg.Timestamp = sent.Unix()
+		if err := app.handleNetworkMessage(testRelay, msg); err != nil {
+			t.Fatalf("handleNetworkMessage: %v", err)
+		}
+	}
+
+	// bob's clock runs three hours fast, then is put right
+	send("first", 1, now.Add(-time.Minute))
+	send("fast", 2, now.Add(3*time.Hour))
+	send("fixed", 3, now)
+
+	messages, err := app.GetMessages("bob", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	models.SortMessages(messages)
+	if got := contents(messages); got != "first,fast,fixed" {
+		t.Fatalf("messages = %s, want them in the order sent", got)
+	}
+
+	for _, msg := range messages {
+		if msg.ClockSkewed != (msg.Content == "fast") {
+			t.Errorf("%s: ClockSkewed = %v", msg.Content, msg.ClockSkewed)
+		}
+	}
+	fast := messages[1]
+	if fast.Timestamp.Unix() != now.Add(3*time.Hour).Unix() {
+		t.Errorf("the sender's timestamp was not kept: %s", fast.Timestamp)
+	}
+	if shown := fast.DisplayTime(); shown.Before(now.Add(-time.Minute)) || shown.After(time.Now()) {
+		t.Errorf("the skewed message is shown at %s, want its arrival", shown)
+	}
+	for i := 1; i < len(messages); i++ {
+		if messages[i].DisplayTime().Before(messages[i-1].DisplayTime().Truncate(time.Second)) {
+			t.Errorf("%s is shown before %s", messages[i].Content, messages[i-1].Content)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// ConnectionMessageHandler handles a message received on a named connection
+type ConnectionMessageHandler func(via string, msg *network.Message) error
+
+// ConnectionEventHandler handles a state change of a named connection
+type ConnectionEventHandler func(via string, event network.ConnectionEvent)
+
+// ConnectionPresenceHandler handles presence reported by a named connection
+type ConnectionPresenceHandler func(via string, presence map[string]network.Presence)
+
+// ConnectionSyncHandler handles the progress of fetching the messages a
+// named connection queued while we were offline
+type ConnectionSyncHandler func(via string, progress network.SyncProgress)
+
+// ConnectionManager holds clients for several relays or peers. Each
+// connection reconnects on its own; outgoing messages are routed to the
+// connection a recipient was last reachable on, and incoming messages from
+// all connections feed one handler pipeline.
+type ConnectionManager struct {
+	mu     sync.RWMutex
+	conns  map[string]*network.Client
+	order  []string          // Connection names in the order they were added
+	routes map[string]string // Recipient user ID to connection name
+
+	// Users whose presence every connection subscribes to
+	presenceSubs []string
+
+	// Whether every connection appears offline
+	invisible bool
+
+	// Users whose queued messages every connection fetches first
+	syncPriority []string
+
+	messageHandler  ConnectionMessageHandler
+	eventHandler    ConnectionEventHandler
+	presenceHandler ConnectionPresenceHandler
+	syncHandler     ConnectionSyncHandler
+}
+
+// NewConnectionManager creates a connection manager feeding the given handlers
+func NewConnectionManager(messages ConnectionMessageHandler, events ConnectionEventHandler, presence ConnectionPresenceHandler, sync ConnectionSyncHandler) *ConnectionManager {
+	return &ConnectionManager{
+		conns:           make(map[string]*network.Client),
+		routes:          make(map[string]string),
+		messageHandler:  messages,
+		eventHandler:    events,
+		presenceHandler: presence,
+		syncHandler:     sync,
+	}
+}
+
+// Add creates a client for a connection. The handlers, visibility and sync
+// priority in opts are replaced by the manager's.
+func (m *ConnectionManager) Add(name string, opts network.ClientOptions) (*network.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.conns[name]; exists {
+		return nil, fmt.Errorf("connection %s already exists", name)
+	}
+
+	opts.MessageHandler = func(msg *network.Message) error {
+		m.learnRoute(models.NormalizeUserID(msg.From), name)
+		if m.messageHandler == nil {
+			return nil
+		}
+		return m.messageHandler(name, msg)
+	}
+	opts.ConnectionHandler = func(event network.ConnectionEvent) {
+		if m.eventHandler != nil {
+			m.eventHandler(name, event)
+		}
+	}
+	opts.PresenceHandler = func(presence map[string]network.Presence) {
+		m.learnPresenceRoutes(name, presence)
+		if m.presenceHandler != nil {
+			m.presenceHandler(name, presence)
+		}
+	}
+
+	opts.SyncProgressHandler = func(progress network.SyncProgress) {
+		if m.syncHandler != nil {
+			m.syncHandler(name, progress)
+		}
+	}
+	opts.SyncPriority = m.SyncPriority
+
+	opts.Invisible = m.invisible
+
+	client := network.NewClient(opts)
+	client.SetPresenceSubscriptions(m.presenceSubs)
+	m.conns[name] = client
+	m.order = append(m.order, name)
+	return client, nil
+}
+
+// SetPresenceSubscriptions sets the users whose presence all connections,
+// including ones added later, subscribe to
+func (m *ConnectionManager) SetPresenceSubscriptions(userIDs []string) error {
+	m.mu.Lock()
+	m.presenceSubs = append([]string(nil), userIDs...)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, name := range m.names() {
+		client, exists := m.Client(name)
+		if !exists {
+			continue
+		}
+		if err := client.SetPresenceSubscriptions(userIDs); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetInvisible makes all connections, including ones added later, appear
+// offline or visible again
+func (m *ConnectionManager) SetInvisible(invisible bool) error {
+	m.mu.Lock()
+	m.invisible = invisible
+	m.mu.Unlock()
+
+	var errs []error
+	for _, name := range m.names() {
+		client, exists := m.Client(name)
+		if !exists {
+			continue
+		}
+		if err := client.SetInvisible(invisible); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetSyncPriority sets the users, typically those of the open chats, whose
+// messages queued while we were offline are fetched before the rest
+func (m *ConnectionManager) SetSyncPriority(userIDs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncPriority = append([]string(nil), userIDs...)
+}
+
+// SyncPriority returns the users whose queued messages are fetched first
+func (m *ConnectionManager) SyncPriority() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.syncPriority...)
+}
+
+// Remove closes and forgets a connection
+func (m *ConnectionManager) Remove(name string) {
+	m.mu.Lock()
+	client, exists := m.conns[name]
+	if exists {
+		delete(m.conns, name)
+		for i, n := range m.order {
+			if n == name {
+				m.order = append(m.order[:i:i], m.order[i+1:]...)
+				break
+			}
+		}
+		for userID, via := range m.routes {
+			if via == name {
+				delete(m.routes, userID)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if exists {
+		client.Close()
+	}
+}
+
+// Client returns the client of a named connection
+func (m *ConnectionManager) Client(name string) (*network.Client, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	client, exists := m.conns[name]
+	return client, exists
+}
+
+// Connect connects every connection. It fails only if none could connect.
+func (m *ConnectionManager) Connect() error {
+	var errs []error
+	connected := 0
+
+	for _, name := range m.names() {
+		client, exists := m.Client(name)
+		if !exists {
+			continue
+		}
+		err := client.Connect()
+		if err == nil || errors.Is(err, network.ErrAlreadyConnected) {
+			connected++
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+
+	if connected == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Disconnect disconnects every connection
+func (m *ConnectionManager) Disconnect() error {
+	var errs []error
+	for _, name := range m.names() {
+		if client, exists := m.Client(name); exists {
+			if err := client.Disconnect(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every connection
+func (m *ConnectionManager) Close() {
+	for _, name := range m.names() {
+		if client, exists := m.Client(name); exists {
+			client.Close()
+		}
+	}
+}
+
+// IsConnected reports whether any connection is up
+func (m *ConnectionManager) IsConnected() bool {
+	return m.Route("") != ""
+}
+
+// Quality returns the quality of the connection messages are sent on by
+// default, which is unknown while no connection is up
+func (m *ConnectionManager) Quality() network.ConnectionQuality {
+	via := m.Route("")
+	if via == "" {
+		return network.ConnectionQuality{Level: network.QualityUnknown}
+	}
+	client, exists := m.Client(via)
+	if !exists {
+		return network.ConnectionQuality{Level: network.QualityUnknown}
+	}
+	return client.Quality()
+}
+
+// State returns the state of the connection messages are sent on by
+// default. While no connection is up it is that of the first connection
+// reconnecting, or else of the first connection.
+func (m *ConnectionManager) State() network.ConnectionState {
+	if via := m.Route(""); via != "" {
+		if client, exists := m.Client(via); exists {
+			return client.State()
+		}
+	}
+
+	var first *network.ConnectionState
+	for _, name := range m.names() {
+		client, exists := m.Client(name)
+		if !exists {
+			continue
+		}
+		state := client.State()
+		if state.Status == network.StatusReconnecting {
+			return state
+		}
+		if first == nil {
+			first = &state
+		}
+	}
+	if first == nil {
+		return network.ConnectionState{Status: network.StatusDisconnected}
+	}
+	return *first
+}
+
+// Route returns the connection a message to userID would be sent on, or ""
+// if no connection is up. A connected route learned from the recipient's
+// messages or presence wins; otherwise the first connected connection is used.
+func (m *ConnectionManager) Route(userID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if via, exists := m.routes[userID]; exists {
+		if client := m.conns[via]; client != nil && client.IsConnected() {
+			return via
+		}
+	}
+
+	for _, name := range m.order {
+		if m.conns[name].IsConnected() {
+			return name
+		}
+	}
+	return ""
+}
+
+// Send sends a message on the recipient's route and returns the name of the
+// connection used
+func (m *ConnectionManager) Send(msg *network.Message) (string, error) {
+	via := m.Route(models.NormalizeUserID(msg.To))
+	if via == "" {
+		return "", network.ErrNotConnected
+	}
+
+	client, exists := m.Client(via)
+	if !exists {
+		return "", network.ErrNotConnected
+	}
+	return via, client.Send(msg)
+}
+
+// SendOn sends a message on a specific connection
+func (m *ConnectionManager) SendOn(via string, msg *network.Message) error {
+	client, exists := m.Client(via)
+	if !exists {
+		return fmt.Errorf("unknown connection %s", via)
+	}
+	return client.Send(msg)
+}
+
+// names returns a snapshot of the connection names
+func (m *ConnectionManager) names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, len(m.order))
+	copy(names, m.order)
+	return names
+}
+
+// learnRoute records that userID is reachable on a connection
+func (m *ConnectionManager) learnRoute(userID, via string) {
+	if userID == "" || userID == "server" {
+		return
+	}
+
+	m.mu.Lock()
+	m.routes[userID] = via
+	m.mu.Unlock()
+}
+
+// learnPresenceRoutes updates routes from a connection's presence report
+func (m *ConnectionManager) learnPresenceRoutes(via string, presence map[string]network.Presence) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for userID, p := range presence {
+		switch {
+		case p.Status != string(models.UserStatusOffline):
+			m.routes[userID] = via
+		case m.routes[userID] == via:
+			delete(m.routes, userID)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// testTimeout bounds waits for messages to pass through in-process relays
+const testTimeout = 5 * time.Second
+
+// waitFor polls cond until it holds, failing the test after testTimeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newTestServer starts an in-process relay, stopped with the test
+func newTestServer(t *testing.T) *network.Server {
+	t.Helper()
+
+	server, err := network.NewServer(network.ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+// memoryClientOptions returns options connecting userID to server in
+// process. A dropped connection stays down for the rest of the test.
+func memoryClientOptions(server *network.Server, userID string) network.ClientOptions {
+	return network.ClientOptions{
+		ServerURL:      "memory://relay",
+		UserID:         userID,
+		DeviceID:       userID + "-device",
+		Transport:      &network.MemoryTransport{Server: server},
+		ReconnectDelay: time.Hour,
+	}
+}
+
+// routedMessage is a chat message and the connection it arrived on
+type routedMessage struct {
+	via string
+	msg *network.Message
+}
+
+// chatTo returns a chat message from one user to another
+func chatTo(t *testing.T, id, from, to string) *network.Message {
+	t.Helper()
+
+	payload, err := network.EncodePayload(network.ChatPayload{Content: "hello"})
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	return &network.Message{
+		ID:        id,
+		Type:      network.MessageTypeChat,
+		From:      from,
+		To:        to,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+}
+
+// receiveChat waits for a chat message on received
+func receiveChat(t *testing.T, received <-chan routedMessage) routedMessage {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case routed := <-received:
+			if routed.msg.Type == network.MessageTypeChat {
+				return routed
+			}
+		case <-deadline:
+			t.Fatal("no chat message arrived")
+		}
+	}
+}
+
+// connectedUser connects userID to server alone, passing its messages to received
+func connectedUser(t *testing.T, server *network.Server, userID string, received chan<- routedMessage) *network.Client {
+	t.Helper()
+
+	opts := memoryClientOptions(server, userID)
+	opts.MessageHandler = func(msg *network.Message) error {
+		received <- routedMessage{via: userID, msg: msg}
+		return nil
+	}
+	client := network.NewClient(opts)
+	t.Cleanup(func() { client.Close() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, userID+"'s connection", func() bool { return client.ProtocolVersion() != 0 })
+	return client
+}
+
+// twoRelayManager returns a manager for alice connected to relays a and b,
+// in that order, and where the messages it receives go
+func twoRelayManager(t *testing.T, a, b *network.Server) (*ConnectionManager, <-chan routedMessage) {
+	t.Helper()
+
+	received := make(chan routedMessage, 16)
+	m := NewConnectionManager(func(via string, msg *network.Message) error {
+		received <- routedMessage{via: via, msg: msg}
+		return nil
+	}, nil, nil, nil)
+	t.Cleanup(m.Close)
+
+	relays := []struct {
+		name   string
+		server *network.Server
+	}{{"a", a}, {"b", b}}
+	for _, relay := range relays {
+		if _, err := m.Add(relay.name, memoryClientOptions(relay.server, "alice")); err != nil {
+			t.Fatalf("Add(%s): %v", relay.name, err)
+		}
+	}
+	if err := m.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		client, _ := m.Client(name)
+		waitFor(t, "connection "+name, func() bool { return client.ProtocolVersion() != 0 })
+	}
+	return m, received
+}
+
+func TestConnectionManagerRoutesToTheRecipientsConnection(t *testing.T) {
+	relayA, relayB := newTestServer(t), newTestServer(t)
+	m, received := twoRelayManager(t, relayA, relayB)
+	bobReceived := make(chan routedMessage, 16)
+	bob := connectedUser(t, relayB, "bob", bobReceived)
+
+	if via := m.Route("bob"); via != "a" {
+		t.Errorf("Route(bob) before hearing from bob = %q, want the first connection", via)
+	}
+
+	if err := bob.Send(chatTo(t, "m1", "bob", "alice")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := receiveChat(t, received); got.via != "b" {
+		t.Errorf("bob's message arrived via %q, want b", got.via)
+	}
+	if via := m.Route("bob"); via != "b" {
+		t.Errorf("Route(bob) = %q, want b", via)
+	}
+
+	via, err := m.Send(chatTo(t, "m2", "alice", "bob"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if via != "b" {
+		t.Errorf("sent to bob via %q, want b", via)
+	}
+	if got := receiveChat(t, bobReceived); got.msg.ID != "m2" {
+		t.Errorf("bob received %s, want m2", got.msg.ID)
+	}
+}
+
+func TestConnectionDroppingLeavesOthersUp(t *testing.T) {
+	relayA, relayB := newTestServer(t), newTestServer(t)
+	m, received := twoRelayManager(t, relayA, relayB)
+	carol := connectedUser(t, relayA, "carol", make(chan routedMessage, 16))
+
+	relayB.Stop()
+	b, _ := m.Client("b")
+	waitFor(t, "connection b to drop", func() bool { return !b.IsConnected() })
+
+	a, _ := m.Client("a")
+	if !a.IsConnected() || !m.IsConnected() {
+		t.Fatal("connection a dropped with b")
+	}
+	if via := m.Route("bob"); via != "a" {
+		t.Errorf("Route(bob) = %q, want a", via)
+	}
+	if err := carol.Send(chatTo(t, "m1", "carol", "alice")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := receiveChat(t, received); got.via != "a" {
+		t.Errorf("carol's message arrived via %q, want a", got.via)
+	}
+}
+
+// connectApp replaces app's configured relay with server and connects to it
+func connectApp(t *testing.T, app *App, server *network.Server) *network.Client {
+	t.Helper()
+
+	app.connections.Remove(testRelay)
+	client, err := app.connections.Add("memory", memoryClientOptions(server, app.config.User.ID))
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, app.config.User.ID+"'s connection", func() bool { return client.ProtocolVersion() != 0 })
+	return client
+}
+
+// droppingRelay starts a relay that closes every connection before
+// answering the hello, and returns its address and how many times it was
+// connected to
+func droppingRelay(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+
+	var connections atomic.Int32
+	var upgrader websocket.Upgrader
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		connections.Add(1)
+		conn.Close()
+	}))
+	t.Cleanup(relay.Close)
+	return "ws" + strings.TrimPrefix(relay.URL, "http") + "/ws", &connections
+}
+
+func TestReconnectSettingsReachTheClient(t *testing.T) {
+	t.Run("limited", func(t *testing.T) {
+		relay, connections := droppingRelay(t)
+		app := newTestApp(t, "alice", func(cfg *config.Config) {
+			cfg.Network.RelayServers = []string{relay}
+			cfg.Network.MaxReconnectAttempts = 3
+			cfg.Network.ReconnectDelay = 10 * time.Millisecond
+		})
+		if err := app.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+
+		// The first connection and three attempts after it
+		waitFor(t, "the client to give up", func() bool {
+			state := app.ConnectionState()
+			return state.Status == network.StatusDisconnected && state.ReconnectAttempt == 3
+		})
+		time.Sleep(100 * time.Millisecond)
+		if got := connections.Load(); got != 4 {
+			t.Errorf("relay connected to %d times, want 4", got)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		relay, connections := droppingRelay(t)
+		app := newTestApp(t, "alice", func(cfg *config.Config) {
+			cfg.Network.RelayServers = []string{relay}
+			cfg.Network.MaxReconnectAttempts = 1
+			cfg.Network.ReconnectUnlimited = true
+			cfg.Network.ReconnectDelay = 5 * time.Millisecond
+		})
+		if err := app.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+
+		// Well past the limit of one attempt
+		waitFor(t, "attempts past the limit", func() bool { return connections.Load() > 4 })
+	})
+}
+
+func TestRelayURL(t *testing.T) {
+	tests := []struct {
+		relay string
+		want  string
+	}{
+		{"localhost:8080", "ws://localhost:8080"},
+		{"ws://relay.example.com", "ws://relay.example.com"},
+		{"wss://10.0.0.5:443", "wss://10.0.0.5:443"},
+	}
+
+	for _, tt := range tests {
+		if got := relayURL(tt.relay); got != tt.want {
+			t.Errorf("relayURL(%q) = %q, want %q", tt.relay, got, tt.want)
+		}
+	}
+}
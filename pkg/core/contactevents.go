@@ -0,0 +1,92 @@
+package core
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// presenceCoalesceDelay is how long presence changes of a contact are
+// gathered before one event reports the latest of them
+const presenceCoalesceDelay = 250 * time.Millisecond
+
+// ContactEventType tells what happened to a contact
+type ContactEventType string
+
+const (
+	// ContactAdded reports a new contact
+	ContactAdded ContactEventType = "added"
+	// ContactUpdated reports changed details or verification state
+	ContactUpdated ContactEventType = "updated"
+	// ContactRemoved reports a deleted contact, as it was before removal
+	ContactRemoved ContactEventType = "removed"
+	// ContactPresence reports changed status or last-seen time
+	ContactPresence ContactEventType = "presence"
+)
+
+// ContactEvent is a change to one contact, with a copy of the contact as it
+// is after the change
+type ContactEvent struct {
+	Type    ContactEventType
+	Contact models.Contact
+}
+
+// ContactEventHandler is called for every contact event
+type ContactEventHandler func(ContactEvent)
+
+// AddContactEventHandler adds a contact event handler
+func (a *App) AddContactEventHandler(handler ContactEventHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.contactEventHandlers = append(a.contactEventHandlers, handler)
+}
+
+// notifyContactEvent passes a contact event to every contact event handler,
+// and the changed contact to every contact handler unless it was removed
+func (a *App) notifyContactEvent(eventType ContactEventType, contact *models.Contact) {
+	a.handlersMux.RLock()
+	handlers := make([]ContactEventHandler, len(a.contactEventHandlers))
+	copy(handlers, a.contactEventHandlers)
+	a.handlersMux.RUnlock()
+
+	event := ContactEvent{Type: eventType, Contact: *contact}
+	for _, handler := range handlers {
+		callContactEventHandler(handler, event)
+	}
+	if eventType != ContactRemoved {
+		a.notifyContactHandlers(contact)
+	}
+}
+
+// callContactEventHandler runs one handler, isolating its panics from the
+// code that changed the contact
+func callContactEventHandler(handler ContactEventHandler, event ContactEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Contact event handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	handler(event)
+}
+
+// notifyPresence reports a presence change of a contact after
+// presenceCoalesceDelay, so a contact flapping between online and offline
+// produces one event per delay rather than one per change
+func (a *App) notifyPresence(userID string) {
+	a.presenceFlushes.scheduleIfIdle(userID, presenceCoalesceDelay, func() {
+		a.flushPresence(userID)
+	})
+}
+
+// flushPresence sends the presence event of a contact with its current
+// state, which includes every change since the event was scheduled
+func (a *App) flushPresence(userID string) {
+	a.presenceFlushes.cancel(userID)
+	if contact, exists := a.GetContact(userID); exists {
+		a.notifyContactEvent(ContactPresence, contact)
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// contactEvents records the contact events of an app as "type user"
+type contactEvents struct {
+	mu     sync.Mutex
+	events []string
+	last   ContactEvent
+}
+
+func recordContactEvents(app *App) *contactEvents {
+	recorded := &contactEvents{}
+	app.AddContactEventHandler(func(event ContactEvent) {
+		recorded.mu.Lock()
+		defer recorded.mu.Unlock()
+		recorded.events = append(recorded.events, fmt.Sprintf("%s %s", event.Type, event.Contact.UserID))
+		recorded.last = event
+	})
+	return recorded
+}
+
+// take returns the events recorded since the last call
+func (r *contactEvents) take() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	got := strings.Join(r.events, ",")
+	r.events = nil
+	return got
+}
+
+func TestContactMutationsEmitEvents(t *testing.T) {
+	app := newTestApp(t, "alice")
+	events := recordContactEvents(app)
+
+	if err := app.AddContact("bob", "Bob"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	if got := events.take(); got != "added bob" {
+		t.Errorf("adding emitted %q, want added bob", got)
+	}
+	if err := app.SetContactFavorite("bob", true); err != nil {
+		t.Fatalf("SetContactFavorite: %v", err)
+	}
+	if got := events.take(); got != "updated bob" || !events.last.Contact.Favorite {
+		t.Errorf("favoriting emitted %q with %+v, want updated bob", got, events.last.Contact)
+	}
+	if err := app.VerifyContact("bob"); err != nil {
+		t.Fatalf("VerifyContact: %v", err)
+	}
+	if got := events.take(); got != "updated bob" || !events.last.Contact.Verified {
+		t.Errorf("verifying emitted %q, want updated bob", got)
+	}
+	if err := app.RemoveContact("bob"); err != nil {
+		t.Fatalf("RemoveContact: %v", err)
+	}
+	if got := events.take(); got != "removed bob" || events.last.Contact.DisplayName != "Bob" {
+		t.Errorf("removing emitted %q with %+v, want removed bob as it was", got, events.last.Contact)
+	}
+}
+
+func TestPresenceEventsAreCoalesced(t *testing.T) {
+	app := newTestApp(t, "alice")
+	for _, userID := range []string{"bob", "carol"} {
+		if err := app.AddContact(userID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	events := recordContactEvents(app)
+	var contactHandlerCalls int
+	var mu sync.Mutex
+	app.AddContactHandler(func(*models.Contact) {
+		mu.Lock()
+		defer mu.Unlock()
+		contactHandlerCalls++
+	})
+
+	// bob flaps between online and offline, ending online
+	for i := 0; i < 10; i++ {
+		status := string(models.UserStatusOffline)
+		if i%2 == 1 {
+			status = string(models.UserStatusOnline)
+		}
+		app.handlePresence(map[string]network.Presence{"bob": {Status: status}})
+	}
+	app.handlePresence(map[string]network.Presence{"carol": {Status: string(models.UserStatusAway)}})
+	if got := events.take(); got != "" {
+		t.Errorf("presence reported before the delay: %s", got)
+	}
+
+	waitFor(t, "the presence events", func() bool {
+		events.mu.Lock()
+		defer events.mu.Unlock()
+		return len(events.events) >= 2
+	})
+	got := events.take()
+	if got != "presence bob,presence carol" && got != "presence carol,presence bob" {
+		t.Errorf("events = %s, want one presence event each", got)
+	}
+	if bob, _ := app.GetContact("bob"); bob.Status != models.UserStatusOnline {
+		t.Errorf("bob is %s, want the latest status", bob.Status)
+	}
+	mu.Lock()
+	if contactHandlerCalls != 2 {
+		t.Errorf("contact handlers called %d times, want once per event", contactHandlerCalls)
+	}
+	mu.Unlock()
+
+	// A later change is reported again
+	app.handlePresence(map[string]network.Presence{"bob": {Status: string(models.UserStatusOffline)}})
+	waitFor(t, "the next presence event", func() bool {
+		events.mu.Lock()
+		defer events.mu.Unlock()
+		return len(events.events) == 1 && events.last.Contact.Status == models.UserStatusOffline
+	})
+}
@@ -0,0 +1,57 @@
+package core
+
+import "github.com/opensourceghana/securechat/internal/models"
+
+// ContactState returns the consolidated state of a contact, which views
+// render from. Users who are not contacts have the zero state.
+func (a *App) ContactState(userID string) models.ContactState {
+	contact, exists := a.GetContact(userID)
+	if !exists {
+		return models.ContactState{}
+	}
+	return contact.State()
+}
+
+// SetContactBlocked blocks or unblocks a contact. Blocked contacts are
+// hidden and never notify, whatever their other flags.
+func (a *App) SetContactBlocked(userID string, blocked bool) error {
+	return a.setContactFlag(userID, blocked, func(contact *models.Contact) *bool { return &contact.Blocked })
+}
+
+// SetContactMuted mutes or unmutes notifications from a contact
+func (a *App) SetContactMuted(userID string, muted bool) error {
+	return a.setContactFlag(userID, muted, func(contact *models.Contact) *bool { return &contact.Muted })
+}
+
+// SetContactArchived archives a contact's conversation, hiding it, or
+// brings it back
+func (a *App) SetContactArchived(userID string, archived bool) error {
+	return a.setContactFlag(userID, archived, func(contact *models.Contact) *bool { return &contact.Archived })
+}
+
+// SetContactFavorite marks a contact as a favorite, listed first, or not
+func (a *App) SetContactFavorite(userID string, favorite bool) error {
+	return a.setContactFlag(userID, favorite, func(contact *models.Contact) *bool { return &contact.Favorite })
+}
+
+// setContactFlag sets one flag of a contact, reporting the contact as
+// updated if it changed
+func (a *App) setContactFlag(userID string, value bool, flag func(contact *models.Contact) *bool) error {
+	return a.updateContact(userID, func(contact *models.Contact) bool {
+		field := flag(contact)
+		if *field == value {
+			return false
+		}
+		*field = value
+		return true
+	})
+}
+
+// hiddenChat reports whether a chat is with a contact whose state hides it
+func (a *App) hiddenChat(msg *models.Message) bool {
+	otherUserID := msg.From
+	if otherUserID == a.config.User.ID {
+		otherUserID = msg.To
+	}
+	return a.ContactState(otherUserID).Hidden()
+}
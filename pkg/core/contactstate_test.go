@@ -0,0 +1,114 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestContactStateFollowsMutations(t *testing.T) {
+	alice := newTestApp(t, "alice")
+	if err := alice.saveContact(&models.Contact{UserID: "bob", Verified: true}); err != nil {
+		t.Fatalf("saveContact: %v", err)
+	}
+
+	steps := []struct {
+		name   string
+		mutate func() error
+		want   models.ContactState
+	}{
+		{"favorite", func() error { return alice.SetContactFavorite("bob", true) }, models.ContactState{Verified: true, Favorite: true}},
+		{"muted", func() error { return alice.SetContactMuted("bob", true) }, models.ContactState{Verified: true, Favorite: true, Muted: true}},
+		{"archived", func() error { return alice.SetContactArchived("bob", true) }, models.ContactState{Verified: true, Favorite: true, Muted: true, Archived: true}},
+		{"blocked", func() error { return alice.SetContactBlocked("bob", true) }, models.ContactState{Verified: true, Favorite: true, Muted: true, Archived: true, Blocked: true}},
+		{"unarchived", func() error { return alice.SetContactArchived("bob", false) }, models.ContactState{Verified: true, Favorite: true, Muted: true, Blocked: true}},
+		{"unmuted", func() error { return alice.SetContactMuted("bob", false) }, models.ContactState{Verified: true, Favorite: true, Blocked: true}},
+		{"unblocked", func() error { return alice.SetContactBlocked("bob", false) }, models.ContactState{Verified: true, Favorite: true}},
+	}
+	for _, step := range steps {
+		if err := step.mutate(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := alice.ContactState("bob"); got != step.want {
+			t.Errorf("%s: state = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+
+	if got := alice.ContactState("nobody"); got != (models.ContactState{}) {
+		t.Errorf("state of a stranger = %+v, want the zero state", got)
+	}
+	if err := alice.SetContactMuted("nobody", true); err == nil {
+		t.Error("muted a user who is not a contact")
+	}
+}
+
+func TestHiddenChatsAreLeftOutOfSearch(t *testing.T) {
+	alice := newTestApp(t, "alice")
+	for _, userID := range []string{"bob", "carol", "dave"} {
+		if err := alice.saveContact(&models.Contact{UserID: userID}); err != nil {
+			t.Fatalf("saveContact: %v", err)
+		}
+		if err := alice.handleNetworkMessage(testRelay, incomingChat(t, alice, userID, userID+"-1", "lunch at noon")); err != nil {
+			t.Fatalf("handleNetworkMessage: %v", err)
+		}
+	}
+	if err := alice.SetContactBlocked("bob", true); err != nil {
+		t.Fatalf("SetContactBlocked: %v", err)
+	}
+	if err := alice.SetContactArchived("carol", true); err != nil {
+		t.Fatalf("SetContactArchived: %v", err)
+	}
+
+	found, err := alice.SearchMessages("lunch", 1)
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if len(found) != 1 || found[0].From != "dave" {
+		t.Errorf("found %+v, want only dave's message", found)
+	}
+}
+
+func TestMutedAndBlockedContactsDoNotNotify(t *testing.T) {
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.UI.Notifications = true
+		cfg.UI.NotificationWindow = 10 * time.Millisecond
+		cfg.UI.NotificationInterval = 0
+	})
+	var mu sync.Mutex
+	var notified []string
+	alice.AddNotificationHandler(func(notification Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, notification.From)
+	})
+
+	for _, userID := range []string{"bob", "carol", "dave"} {
+		if err := alice.saveContact(&models.Contact{UserID: userID}); err != nil {
+			t.Fatalf("saveContact: %v", err)
+		}
+	}
+	if err := alice.SetContactMuted("bob", true); err != nil {
+		t.Fatalf("SetContactMuted: %v", err)
+	}
+	if err := alice.SetContactBlocked("carol", true); err != nil {
+		t.Fatalf("SetContactBlocked: %v", err)
+	}
+	for _, userID := range []string{"bob", "carol", "dave"} {
+		alice.handleNetworkMessage(testRelay, incomingChat(t, alice, userID, userID+"-1", "hello"))
+	}
+
+	waitFor(t, "dave's notification", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(notified) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 1 || notified[0] != "dave" {
+		t.Errorf("notified for %v, want only dave", notified)
+	}
+}
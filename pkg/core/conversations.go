@@ -0,0 +1,302 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/internal/textwidth"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// unreadConfig prefixes the stored count of unread messages of each chat
+const unreadConfig = "unread/"
+
+// markReadPage is how many messages are loaded at a time when a
+// conversation is marked read
+const markReadPage = 100
+
+// ConversationSummary describes the conversation with a contact, for bots
+// and dashboards listing conversations. Counts and the latest message are
+// read without going through the conversation.
+type ConversationSummary struct {
+	ChatID      string              `json:"chat_id"`
+	UserID      string              `json:"user_id"`
+	DisplayName string              `json:"display_name"`
+	State       models.ContactState `json:"state"`
+	Messages    int                 `json:"messages"` // Stored, sent and received
+	Unread      int                 `json:"unread"`
+	LastMessage *MessagePreview     `json:"last_message,omitempty"`
+}
+
+// MessagePreview is the start of a message, for listings
+type MessagePreview struct {
+	ID        string               `json:"id"`
+	From      string               `json:"from"`
+	Preview   string               `json:"preview"`
+	Timestamp time.Time            `json:"timestamp"`
+	Status    models.MessageStatus `json:"status,omitempty"`
+}
+
+// MessageCounts are how many messages are stored across conversations,
+// and how many of those received are unread
+type MessageCounts struct {
+	Total  int `json:"total"`
+	Unread int `json:"unread"`
+}
+
+// unreadCounts counts the received messages of each chat that are not read
+// yet. The counts are stored, so they survive restarts without going
+// through the messages.
+type unreadCounts struct {
+	mu      sync.Mutex
+	storage storage.Store
+	counts  map[string]int // By chat, loaded as chats are used
+	open    string         // User ID of the open chat, whose messages are read as they arrive
+}
+
+// newUnreadCounts creates an unread message counter backed by storage
+func newUnreadCounts(s storage.Store) *unreadCounts {
+	return &unreadCounts{
+		storage: s,
+		counts:  make(map[string]int),
+	}
+}
+
+// Get returns the unread messages of a chat
+func (u *unreadCounts) Get(chatID string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.load(chatID)
+}
+
+// Add counts another unread message in a chat
+func (u *unreadCounts) Add(chatID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.counts[chatID] = u.load(chatID) + 1
+	u.save(chatID)
+}
+
+// Reset counts no unread messages in a chat
+func (u *unreadCounts) Reset(chatID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.load(chatID) == 0 {
+		return
+	}
+	u.counts[chatID] = 0
+	u.save(chatID)
+}
+
+// SetOpen records which chat is open, by the user ID of the contact, or
+// that none is if userID is empty
+func (u *unreadCounts) SetOpen(userID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.open = userID
+}
+
+// IsOpen reports whether the chat with a user is open
+func (u *unreadCounts) IsOpen(userID string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return userID != "" && u.open == userID
+}
+
+// load returns the count of a chat, reading it from storage the first time
+// the chat is used. Callers must hold u.mu.
+func (u *unreadCounts) load(chatID string) int {
+	if count, ok := u.counts[chatID]; ok {
+		return count
+	}
+
+	var count int
+	err := u.storage.GetConfig(unreadConfig+chatID, &count)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Warning: failed to load unread count for chat %s: %v", chatID, err)
+	}
+	u.counts[chatID] = count
+	return count
+}
+
+// save stores the count of a chat. Callers must hold u.mu.
+func (u *unreadCounts) save(chatID string) {
+	if err := u.storage.SaveConfig(unreadConfig+chatID, u.counts[chatID]); err != nil {
+		log.Printf("Warning: failed to save unread count for chat %s: %v", chatID, err)
+	}
+}
+
+// arrivedUnread reports whether a received message is to be counted as
+// unread once it is stored. A message in the open chat is marked read
+// instead, since the user sees it arrive.
+func (a *App) arrivedUnread(msg *models.Message) bool {
+	if msg.Type != models.MessageTypeChat || msg.IsFromUser(a.config.User.ID) {
+		return false
+	}
+	if a.unread.IsOpen(msg.From) {
+		msg.Status = models.MessageStatusRead
+		return false
+	}
+	return true
+}
+
+// Conversations lists the conversations with every contact, the most
+// recently active first. Contacts that were never written to come last,
+// by name.
+func (a *App) Conversations() ([]ConversationSummary, error) {
+	contacts := a.GetContacts()
+	summaries := make([]ConversationSummary, 0, len(contacts))
+	for _, contact := range contacts {
+		summary, err := a.conversationSummary(contact)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		si, sj := summaries[i], summaries[j]
+		switch {
+		case si.LastMessage == nil || sj.LastMessage == nil:
+			if (si.LastMessage == nil) != (sj.LastMessage == nil) {
+				return si.LastMessage != nil
+			}
+		case !si.LastMessage.Timestamp.Equal(sj.LastMessage.Timestamp):
+			return si.LastMessage.Timestamp.After(sj.LastMessage.Timestamp)
+		}
+		if si.DisplayName != sj.DisplayName {
+			return si.DisplayName < sj.DisplayName
+		}
+		return si.UserID < sj.UserID
+	})
+	return summaries, nil
+}
+
+// Conversation returns the summary of the conversation with a contact
+func (a *App) Conversation(userID string) (ConversationSummary, error) {
+	contact, exists := a.GetContact(models.NormalizeUserID(userID))
+	if !exists {
+		return ConversationSummary{}, fmt.Errorf("%s is not a contact", userID)
+	}
+	return a.conversationSummary(contact)
+}
+
+// conversationSummary summarizes the conversation with a contact
+func (a *App) conversationSummary(contact *models.Contact) (ConversationSummary, error) {
+	chatID := a.getChatID(a.config.User.ID, contact.UserID)
+	summary := ConversationSummary{
+		ChatID:      chatID,
+		UserID:      contact.UserID,
+		DisplayName: contact.GetDisplayName(),
+		State:       contact.State(),
+	}
+
+	total, err := a.storage.MessageCount(chatID)
+	if err != nil {
+		return summary, fmt.Errorf("failed to count messages with %s: %w", contact.UserID, err)
+	}
+	summary.Messages = total
+	summary.Unread = min(a.unread.Get(chatID), total)
+
+	latest, err := a.storage.GetMessagesBefore(chatID, nil, 1)
+	if err != nil {
+		return summary, fmt.Errorf("failed to load the latest message with %s: %w", contact.UserID, err)
+	}
+	if len(latest) > 0 {
+		summary.LastMessage = previewMessage(latest[0], a.config.UI.PreviewLength)
+	}
+	return summary, nil
+}
+
+// previewMessage returns the preview of a message: its content on one line,
+// cut to width terminal cells as the interface cuts its previews
+func previewMessage(msg *models.Message, width int) *MessagePreview {
+	return &MessagePreview{
+		ID:        msg.ID,
+		From:      msg.From,
+		Preview:   textwidth.Truncate(strings.Join(strings.Fields(msg.Content), " "), width),
+		Timestamp: msg.DisplayTime(),
+		Status:    msg.Status,
+	}
+}
+
+// UnreadCount returns how many received messages of the conversation with
+// a user are unread
+func (a *App) UnreadCount(userID string) int {
+	return a.unread.Get(a.getChatID(a.config.User.ID, models.NormalizeUserID(userID)))
+}
+
+// MessageCounts returns how many messages the conversations with contacts
+// hold, and how many of them are unread
+func (a *App) MessageCounts() (MessageCounts, error) {
+	var counts MessageCounts
+	for _, contact := range a.GetContacts() {
+		chatID := a.getChatID(a.config.User.ID, contact.UserID)
+		total, err := a.storage.MessageCount(chatID)
+		if err != nil {
+			return counts, fmt.Errorf("failed to count messages with %s: %w", contact.UserID, err)
+		}
+		counts.Total += total
+		counts.Unread += min(a.unread.Get(chatID), total)
+	}
+	return counts, nil
+}
+
+// MarkConversationRead marks the received messages of the conversation with
+// a user read. This is local only: no read receipt is sent.
+func (a *App) MarkConversationRead(userID string) error {
+	userID = models.NormalizeUserID(userID)
+	chatID := a.getChatID(a.config.User.ID, userID)
+	unread := a.unread.Get(chatID)
+	if unread == 0 {
+		return nil
+	}
+
+	// Unread messages are the latest received, apart from any that arrived
+	// late and sorted in before read ones, so the search goes back from the
+	// latest until all are found
+	marked := 0
+	var before *models.Message
+	for marked < unread {
+		page, err := a.storage.GetMessagesBefore(chatID, before, markReadPage)
+		if err != nil {
+			return fmt.Errorf("failed to load messages to mark read: %w", err)
+		}
+		for i := len(page) - 1; i >= 0 && marked < unread; i-- {
+			msg := page[i]
+			if msg.Type != models.MessageTypeChat || msg.IsFromUser(a.config.User.ID) || msg.Status == models.MessageStatusRead {
+				continue
+			}
+			updated, err := a.updateStoredMessage(chatID, msg.ID, func(stored *models.Message) bool {
+				if stored.Status == models.MessageStatusRead {
+					return false
+				}
+				stored.Status = models.MessageStatusRead
+				return true
+			})
+			if err != nil {
+				return fmt.Errorf("failed to mark message %s read: %w", msg.ID, err)
+			}
+			a.notifyMessageHandlers(updated)
+			marked++
+		}
+		if len(page) < markReadPage {
+			break
+		}
+		before = page[0]
+	}
+
+	a.unread.Reset(chatID)
+	return nil
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+func TestConversationSummaryJSON(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	summary := ConversationSummary{
+		ChatID:      "alice:bob",
+		UserID:      "bob",
+		DisplayName: "Bob",
+		State:       models.ContactState{Verified: true, Muted: true},
+		Messages:    12,
+		Unread:      3,
+		LastMessage: &MessagePreview{
+			ID:        "m1",
+			From:      "bob",
+			Preview:   "see you",
+			Timestamp: at,
+			Status:    models.MessageStatusDelivered,
+		},
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `{"chat_id":"alice:bob","user_id":"bob","display_name":"Bob",` +
+		`"state":{"verified":true,"blocked":false,"muted":true,"archived":false,"favorite":false,"online":false,"unknown":false},` +
+		`"messages":12,"unread":3,` +
+		`"last_message":{"id":"m1","from":"bob","preview":"see you","timestamp":"2026-03-01T12:30:00Z","status":"delivered"}}`
+	if string(data) != want {
+		t.Errorf("Marshal =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestConversationSummaryJSONOmitsMissingMessage(t *testing.T) {
+	data, err := json.Marshal(ConversationSummary{ChatID: "alice:carol", UserID: "carol"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Contains(string(data), "last_message") {
+		t.Errorf("summary without messages has last_message: %s", data)
+	}
+}
+
+func TestMessageCountsJSON(t *testing.T) {
+	data, err := json.Marshal(MessageCounts{Total: 7, Unread: 2})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := `{"total":7,"unread":2}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestPreviewMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		width   int
+		want    string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"whitespace", "  hello\n\tworld  ", 20, "hello world"},
+		{"long", strings.Repeat("é", 15), 10, strings.Repeat("é", 7) + "..."},
+		{"exact", strings.Repeat("x", 10), 10, strings.Repeat("x", 10)},
+		{"wide characters", "你好世界，再见", 10, "你好世..."},
+		{"emoji sequence", "hi 👩‍👩‍👧 there", 6, "hi ..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview := previewMessage(&models.Message{ID: "m", From: "bob", Content: tt.content}, tt.width)
+			if preview.Preview != tt.want {
+				t.Errorf("Preview = %q, want %q", preview.Preview, tt.want)
+			}
+		})
+	}
+}
+
+func TestConversationPreviewFollowsConfig(t *testing.T) {
+	alice := newTestApp(t, "alice", func(cfg *config.Config) { cfg.UI.PreviewLength = 12 })
+	if err := alice.AddContact("bob", ""); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	msg := &models.Message{
+		ID:        "m1",
+		Type:      models.MessageTypeChat,
+		From:      "bob",
+		To:        "alice",
+		ChatID:    alice.getChatID("alice", "bob"),
+		Content:   "a message longer than the preview",
+		Timestamp: time.Now(),
+	}
+	if err := alice.storage.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	summaries, err := alice.Conversations()
+	if err != nil {
+		t.Fatalf("Conversations: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].LastMessage == nil || summaries[0].LastMessage.Preview != "a message..." {
+		t.Errorf("Conversations = %+v, want bob's message cut to 12 cells", summaries)
+	}
+}
+
+func TestUnreadCountsPersist(t *testing.T) {
+	store, err := storage.NewMemoryStore(storage.StorageOptions{})
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	defer store.Close()
+
+	counts := newUnreadCounts(store)
+	counts.Add("alice:bob")
+	counts.Add("alice:bob")
+	counts.Add("alice:carol")
+	counts.Reset("alice:carol")
+
+	reloaded := newUnreadCounts(store)
+	if got := reloaded.Get("alice:bob"); got != 2 {
+		t.Errorf("Get(alice:bob) = %d, want 2", got)
+	}
+	if got := reloaded.Get("alice:carol"); got != 0 {
+		t.Errorf("Get(alice:carol) = %d, want 0", got)
+	}
+}
+
+func TestUnreadCountsOpenChat(t *testing.T) {
+	store, err := storage.NewMemoryStore(storage.StorageOptions{})
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	defer store.Close()
+
+	counts := newUnreadCounts(store)
+	if counts.IsOpen("") {
+		t.Error("IsOpen(\"\") with no open chat = true")
+	}
+	counts.SetOpen("bob")
+	if !counts.IsOpen("bob") || counts.IsOpen("carol") {
+		t.Error("only bob's chat should be open")
+	}
+	counts.SetOpen("")
+	if counts.IsOpen("bob") {
+		t.Error("bob's chat is still open after closing it")
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// MessageDebugInfo returns the envelope metadata recorded for a received
+// message, or nil if none was. It is only recorded in debug mode.
+func (a *App) MessageDebugInfo(chatID, msgID string) (*models.MessageDebugInfo, error) {
+	info, err := a.storage.GetMessageDebugInfo(chatID, msgID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load message debug info: %w", err)
+	}
+	return info, nil
+}
+
+// recordDebugInfo stores the envelope metadata of a received chat message
+// in debug mode. payload is nil if it could not be decoded, and readErr
+// tells why.
+func (a *App) recordDebugInfo(via string, netMsg *network.Message, chatID string, payload *network.ChatPayload, readErr error) {
+	if !a.config.Debug {
+		return
+	}
+
+	info := &models.MessageDebugInfo{
+		ChatID:     chatID,
+		MessageID:  netMsg.ID,
+		Via:        via,
+		ReceivedAt: time.Now(),
+	}
+	if payload != nil && payload.Header != nil {
+		info.Encrypted = true
+		info.SenderRatchetKey = payload.Header.RatchetKey
+		info.MessageNumber = payload.Header.MessageNumber
+		info.PreviousCounter = payload.Header.PreviousCounter
+	}
+	if readErr != nil {
+		info.Error = readErr.Error()
+	}
+
+	if err := a.storage.SaveMessageDebugInfo(info); err != nil {
+		log.Printf("Warning: failed to save debug info of message %s: %v", netMsg.ID, err)
+	}
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func TestDebugInfoIsRecordedOnlyInDebugMode(t *testing.T) {
+	server := newTestServer(t)
+	alice := newTestApp(t, "alice", func(cfg *config.Config) { cfg.Debug = true })
+	bob := newTestApp(t, "bob")
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	for _, pair := range [][2]*App{{alice, bob}, {bob, alice}} {
+		if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	exchange(t, bob, alice, "first")
+	exchange(t, bob, alice, "second")
+	bob.sessionsMux.Lock()
+	ratchetKey := bob.sessions["alice"].DHSelf
+	bob.sessionsMux.Unlock()
+	exchange(t, alice, bob, "reply")
+
+	received, err := alice.GetMessages("bob", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	var numbers []uint32
+	for _, msg := range received {
+		if msg.From != "bob" {
+			continue
+		}
+		info, err := alice.MessageDebugInfo(msg.ChatID, msg.ID)
+		if err != nil || info == nil {
+			t.Fatalf("MessageDebugInfo(%s) = %v, %v, want the envelope recorded", msg.Content, info, err)
+		}
+		if !info.Encrypted || !bytes.Equal(info.SenderRatchetKey, ratchetKey.PublicKey) || info.Error != "" {
+			t.Errorf("%s recorded as %+v, want the ratchet header", msg.Content, info)
+		}
+		if encoded, _ := json.Marshal(info); bytes.Contains(encoded, []byte(base64.StdEncoding.EncodeToString(ratchetKey.PrivateKey))) {
+			t.Errorf("%s recorded the private ratchet key", msg.Content)
+		}
+		if info.Via == "" || info.ReceivedAt.IsZero() {
+			t.Errorf("%s recorded without its connection or arrival", msg.Content)
+		}
+		numbers = append(numbers, info.MessageNumber)
+	}
+	if len(numbers) != 2 || numbers[1] != numbers[0]+1 {
+		t.Errorf("message numbers = %v, want two in a row", numbers)
+	}
+
+	// bob is not in debug mode
+	sent, _ := bob.GetMessages("alice", 10)
+	for _, msg := range sent {
+		if info, err := bob.MessageDebugInfo(msg.ChatID, msg.ID); info != nil || err != nil {
+			t.Errorf("bob recorded %+v, %v outside debug mode", info, err)
+		}
+	}
+}
+
+func TestDebugInfoRecordsUnreadableMessages(t *testing.T) {
+	app := newTestApp(t, "alice", func(cfg *config.Config) { cfg.Debug = true })
+	msg := incomingChat(t, app, "bob", "garbled", "")
+	msg.Payload = map[string]interface{}{"content": 42}
+	if err := app.handleNetworkMessage(testRelay, msg); err == nil {
+		t.Fatal("an unreadable message was accepted")
+	}
+
+	info, err := app.MessageDebugInfo(app.getChatID("bob", "alice"), "garbled")
+	if err != nil || info == nil {
+		t.Fatalf("MessageDebugInfo = %v, %v, want the failure recorded", info, err)
+	}
+	if info.Error == "" || info.Encrypted || info.Via != testRelay {
+		t.Errorf("recorded %+v, want the error of an unencrypted message via %s", info, testRelay)
+	}
+}
@@ -0,0 +1,49 @@
+package core
+
+import "sync"
+
+// recentMessageIDs is the number of received message IDs remembered in memory
+const recentMessageIDs = 512
+
+// recentIDs is a bounded set of recently seen message IDs. It short-circuits
+// the storage lookup when the same message is delivered again shortly after.
+type recentIDs struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	next  int
+}
+
+// newRecentIDs creates a set remembering up to size IDs
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{
+		ids:   make(map[string]struct{}, size),
+		order: make([]string, size),
+	}
+}
+
+// Seen reports whether id was added recently
+func (r *recentIDs) Seen(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.ids[id]
+	return ok
+}
+
+// Add remembers id, evicting the oldest entry when full
+func (r *recentIDs) Add(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ids[id]; ok {
+		return
+	}
+
+	if old := r.order[r.next]; old != "" {
+		delete(r.ids, old)
+	}
+	r.order[r.next] = id
+	r.ids[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.order)
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// incomingChat returns a plaintext chat message from a peer to app's user
+func incomingChat(t *testing.T, app *App, from, id, content string) *network.Message {
+	t.Helper()
+
+	payload, err := network.EncodePayload(network.ChatPayload{Content: content})
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	return &network.Message{
+		ID:      id,
+		Type:    network.MessageTypeChat,
+		From:    from,
+		To:      app.config.User.ID,
+		Payload: payload,
+	}
+}
+
+func TestDuplicateDeliveryIsStoredAndShownOnce(t *testing.T) {
+	app := newTestApp(t, "alice")
+	var shown []string
+	app.AddMessageHandler(func(msg *models.Message) error {
+		shown = append(shown, msg.ID)
+		return nil
+	})
+
+	msg := incomingChat(t, app, "bob", "m1", "hello")
+	for i := 0; i < 3; i++ {
+		if err := app.handleNetworkMessage(testRelay, msg); err != nil {
+			t.Fatalf("handleNetworkMessage: %v", err)
+		}
+	}
+
+	if len(shown) != 1 {
+		t.Errorf("handlers saw %d copies, want 1", len(shown))
+	}
+	if count, err := app.storage.MessageCount(app.getChatID("alice", "bob")); err != nil || count != 1 {
+		t.Errorf("MessageCount = %d, %v, want 1", count, err)
+	}
+}
+
+func TestDuplicateFoundInStorage(t *testing.T) {
+	app := newTestApp(t, "alice")
+
+	// Stored before a restart, so no longer in the recent IDs
+	stored := models.NewMessage(models.MessageTypeChat, "bob", "alice", "hello")
+	stored.ID = "m1"
+	stored.ChatID = app.getChatID("alice", "bob")
+	if err := app.storage.SaveMessage(stored); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	shown := 0
+	app.AddMessageHandler(func(*models.Message) error {
+		shown++
+		return nil
+	})
+	if err := app.handleNetworkMessage(testRelay, incomingChat(t, app, "bob", "m1", "hello")); err != nil {
+		t.Fatalf("handleNetworkMessage: %v", err)
+	}
+	if shown != 0 {
+		t.Errorf("handlers saw the stored message %d times", shown)
+	}
+}
+
+func TestRecentIDsForgetTheOldest(t *testing.T) {
+	recent := newRecentIDs(3)
+	for i := 1; i <= 4; i++ {
+		recent.Add(fmt.Sprintf("m%d", i))
+	}
+	recent.Add("m4")
+
+	for id, want := range map[string]bool{"m1": false, "m2": true, "m3": true, "m4": true} {
+		if got := recent.Seen(id); got != want {
+			t.Errorf("Seen(%s) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// DeleteMessages securely deletes messages from a chat with another user
+func (a *App) DeleteMessages(otherUserID string, messageIDs []string) error {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+	if err := a.storage.SecureDeleteMessages(chatID, messageIDs); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession discards the encryption session with a contact, zeroizing
+// its keys in memory and securely deleting the stored session state
+func (a *App) DeleteSession(userID string) error {
+	userID = models.NormalizeUserID(userID)
+
+	a.sessionsMux.Lock()
+	if session, exists := a.sessions[userID]; exists {
+		session.Wipe()
+		delete(a.sessions, userID)
+	}
+	if session, exists := a.retired[userID]; exists {
+		session.Wipe()
+		delete(a.retired, userID)
+	}
+	delete(a.keyedAt, userID)
+	delete(a.downgraded, userID)
+	a.sessionsMux.Unlock()
+
+	if err := a.storage.SecureDeleteSession(userID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	log.Printf("Deleted session with %s", userID)
+	return nil
+}
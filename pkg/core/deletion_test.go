@@ -0,0 +1,37 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+func TestDeleteSessionZeroizesKeys(t *testing.T) {
+	app := newTestApp(t, "alice")
+	remote, err := crypto.GenerateEphemeralKey()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKey: %v", err)
+	}
+	session, err := crypto.NewDoubleRatchet(bytes.Repeat([]byte{7}, 32), remote.PublicKey, crypto.DefaultCipher)
+	if err != nil {
+		t.Fatalf("NewDoubleRatchet: %v", err)
+	}
+	rootKey, privateKey := session.RootKey, session.DHSelf.PrivateKey
+	app.sessionsMux.Lock()
+	app.sessions["bob"] = session
+	app.sessionsMux.Unlock()
+
+	if err := app.DeleteSession("bob"); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if !bytes.Equal(rootKey, make([]byte, len(rootKey))) || !bytes.Equal(privateKey, make([]byte, len(privateKey))) {
+		t.Error("the deleted session's keys were not zeroized")
+	}
+	app.sessionsMux.Lock()
+	_, kept := app.sessions["bob"]
+	app.sessionsMux.Unlock()
+	if kept {
+		t.Error("the deleted session is still in use")
+	}
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// deliveryTimers holds the running delivery timeout of each sent message
+type deliveryTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newDeliveryTimers() *deliveryTimers {
+	return &deliveryTimers{timers: make(map[string]*time.Timer)}
+}
+
+// schedule runs fn after the given duration, replacing any timer for key.
+// The lock is held while the timer is created so a timer that fires at
+// once cannot remove its entry before it is added.
+func (d *deliveryTimers) schedule(key string, after time.Duration, fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, exists := d.timers[key]; exists {
+		timer.Stop()
+	}
+	d.timers[key] = time.AfterFunc(after, fn)
+}
+
+// scheduleIfIdle runs fn after the given duration unless a timer for key is
+// already pending, reporting whether it scheduled one. fn must cancel key
+// for later calls to schedule again.
+func (d *deliveryTimers) scheduleIfIdle(key string, after time.Duration, fn func()) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, exists := d.timers[key]; exists {
+		return false
+	}
+	d.timers[key] = time.AfterFunc(after, fn)
+	return true
+}
+
+// cancel stops the timer for key, reporting whether there was one
+func (d *deliveryTimers) cancel(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	timer, exists := d.timers[key]
+	if !exists {
+		return false
+	}
+	timer.Stop()
+	delete(d.timers, key)
+	return true
+}
+
+// stopAll stops every timer
+func (d *deliveryTimers) stopAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, timer := range d.timers {
+		timer.Stop()
+		delete(d.timers, key)
+	}
+}
+
+func deliveryKey(chatID, messageID string) string {
+	return chatID + "/" + messageID
+}
+
+// ResendMessage sends a failed message again. The message keeps its ID, so
+// a recipient that did get the first attempt drops the duplicate.
+func (a *App) ResendMessage(otherUserID, messageID string) error {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+	msg, err := a.storage.GetMessage(chatID, messageID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("message not found: %s", messageID)
+	} else if err != nil {
+		return fmt.Errorf("failed to load message: %w", err)
+	}
+
+	if msg.From != a.config.User.ID || msg.Status != models.MessageStatusFailed {
+		return fmt.Errorf("message %s has not failed", messageID)
+	}
+	if contact, exists := a.GetContact(msg.To); exists {
+		if err := a.checkKeyChange(contact, false); err != nil {
+			return err
+		}
+	}
+
+	return a.transmit(msg)
+}
+
+// armDeliveryTimeout starts the delivery timeout of a sent message and
+// records it so it survives a restart
+func (a *App) armDeliveryTimeout(msg *models.Message) {
+	timeout := a.config.Network.DeliveryTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	pending := &models.PendingDelivery{
+		ChatID:    msg.ChatID,
+		MessageID: msg.ID,
+		Deadline:  time.Now().Add(timeout),
+	}
+	if err := a.storage.SavePendingDelivery(pending); err != nil {
+		log.Printf("Warning: failed to save delivery timeout: %v", err)
+	}
+
+	a.scheduleDeliveryTimeout(pending)
+}
+
+// scheduleDeliveryTimeout starts the timer for a pending delivery. A
+// deadline that has already passed fires at once.
+func (a *App) scheduleDeliveryTimeout(pending *models.PendingDelivery) {
+	a.deliveries.schedule(deliveryKey(pending.ChatID, pending.MessageID), time.Until(pending.Deadline), func() {
+		a.expireDelivery(pending)
+	})
+}
+
+// settleDelivery cancels the delivery timeout of a message that was acked
+func (a *App) settleDelivery(chatID, messageID string) {
+	if !a.deliveries.cancel(deliveryKey(chatID, messageID)) {
+		return
+	}
+
+	if err := a.storage.DeletePendingDelivery(chatID, messageID); err != nil {
+		log.Printf("Warning: failed to clear delivery timeout: %v", err)
+	}
+}
+
+// expireDelivery marks a message failed if it is still waiting for an ack
+func (a *App) expireDelivery(pending *models.PendingDelivery) {
+	a.deliveries.cancel(deliveryKey(pending.ChatID, pending.MessageID))
+
+	select {
+	case <-a.done:
+		return
+	default:
+	}
+
+	if err := a.storage.DeletePendingDelivery(pending.ChatID, pending.MessageID); err != nil {
+		log.Printf("Warning: failed to clear delivery timeout: %v", err)
+	}
+
+	failed := false
+	msg, err := a.updateStoredMessage(pending.ChatID, pending.MessageID, func(stored *models.Message) bool {
+		// An ack may have raced the timer
+		failed = stored.Status == models.MessageStatusSent && stored.UpdateStatus(models.MessageStatusFailed)
+		return failed
+	})
+	if err != nil || !failed {
+		// The message was deleted while we waited, or was acked
+		return
+	}
+
+	log.Printf("No delivery confirmation for message %s, marked as failed", msg.ID)
+	a.notifyMessageHandlers(msg)
+}
+
+// restoreDeliveryTimeouts re-arms the delivery timeouts recorded before a
+// restart. Messages whose deadline passed while the app was closed fail at once.
+func (a *App) restoreDeliveryTimeouts() {
+	deliveries, err := a.storage.GetPendingDeliveries()
+	if err != nil {
+		log.Printf("Warning: failed to load delivery timeouts: %v", err)
+		return
+	}
+
+	for _, pending := range deliveries {
+		if a.config.Network.DeliveryTimeout <= 0 {
+			// Timeouts were turned off since the message was sent
+			if err := a.storage.DeletePendingDelivery(pending.ChatID, pending.MessageID); err != nil {
+				log.Printf("Warning: failed to clear delivery timeout: %v", err)
+			}
+			continue
+		}
+		a.scheduleDeliveryTimeout(pending)
+	}
+}
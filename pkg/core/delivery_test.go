@@ -0,0 +1,109 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// storedStatus returns the stored status of msg
+func storedStatus(t *testing.T, app *App, msg *models.Message) models.MessageStatus {
+	t.Helper()
+
+	stored, err := app.storage.GetMessage(msg.ChatID, msg.ID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	return stored.Status
+}
+
+// pendingDeliveries returns how many delivery timeouts are recorded
+func pendingDeliveries(t *testing.T, app *App) int {
+	t.Helper()
+
+	pending, err := app.storage.GetPendingDeliveries()
+	if err != nil {
+		t.Fatalf("GetPendingDeliveries: %v", err)
+	}
+	return len(pending)
+}
+
+func TestAckBeforeDeliveryTimeout(t *testing.T) {
+	app := newTestApp(t, "alice")
+	app.config.Network.DeliveryTimeout = 50 * time.Millisecond
+	msg := sentMessage(t, app)
+	app.armDeliveryTimeout(msg)
+
+	if err := app.handleAck(ackMessage(t, "server", msg.ID, "queued")); err != nil {
+		t.Fatalf("handleAck: %v", err)
+	}
+	if n := pendingDeliveries(t, app); n != 0 {
+		t.Errorf("%d delivery timeouts recorded after the ack, want 0", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if status := storedStatus(t, app, msg); status != models.MessageStatusQueued {
+		t.Errorf("Status = %s after the timeout passed, want queued", status)
+	}
+}
+
+func TestDeliveryTimeoutFailsMessage(t *testing.T) {
+	app := newTestApp(t, "alice")
+	app.config.Network.DeliveryTimeout = 20 * time.Millisecond
+	failed := make(chan *models.Message, 1)
+	app.AddMessageHandler(func(msg *models.Message) error {
+		failed <- msg
+		return nil
+	})
+	msg := sentMessage(t, app)
+	app.armDeliveryTimeout(msg)
+
+	select {
+	case notified := <-failed:
+		if notified.ID != msg.ID || notified.Status != models.MessageStatusFailed {
+			t.Errorf("handlers got %s with status %s, want %s failed", notified.ID, notified.Status, msg.ID)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the delivery timeout did not fire")
+	}
+	if status := storedStatus(t, app, msg); status != models.MessageStatusFailed {
+		t.Errorf("Status = %s, want failed", status)
+	}
+	if n := pendingDeliveries(t, app); n != 0 {
+		t.Errorf("%d delivery timeouts recorded after it fired, want 0", n)
+	}
+
+	// A late ack still counts, and the failure is not reported again
+	if err := app.handleAck(ackMessage(t, "bob", msg.ID, "delivered")); err != nil {
+		t.Fatalf("handleAck: %v", err)
+	}
+	if status := storedStatus(t, app, msg); status != models.MessageStatusDelivered {
+		t.Errorf("Status after a late ack = %s, want delivered", status)
+	}
+}
+
+func TestDeliveryTimeoutsAreRestored(t *testing.T) {
+	app := newTestApp(t, "alice")
+	app.config.Network.DeliveryTimeout = time.Minute
+	expired, waiting := sentMessage(t, app), sentMessage(t, app)
+	for _, pending := range []*models.PendingDelivery{
+		{ChatID: expired.ChatID, MessageID: expired.ID, Deadline: time.Now().Add(-time.Second)},
+		{ChatID: waiting.ChatID, MessageID: waiting.ID, Deadline: time.Now().Add(time.Hour)},
+	} {
+		if err := app.storage.SavePendingDelivery(pending); err != nil {
+			t.Fatalf("SavePendingDelivery: %v", err)
+		}
+	}
+
+	app.restoreDeliveryTimeouts()
+	waitFor(t, "the passed deadline to fail its message", func() bool {
+		return storedStatus(t, app, expired) == models.MessageStatusFailed
+	})
+	if status := storedStatus(t, app, waiting); status != models.MessageStatusSent {
+		t.Errorf("Status of the message still in time = %s, want sent", status)
+	}
+	if n := pendingDeliveries(t, app); n != 1 {
+		t.Errorf("%d delivery timeouts recorded, want 1", n)
+	}
+}
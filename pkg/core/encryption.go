@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ErrEncryptionDowngrade is returned for a plaintext message received in a
+// conversation that has an encryption session when verification is required
+var ErrEncryptionDowngrade = errors.New("conversation is encrypted but the message is not")
+
+// EncryptionDowngrade reports a plaintext message received in a
+// conversation that has an encryption session
+type EncryptionDowngrade struct {
+	UserID    string
+	MessageID string
+	Refused   bool // The message was dropped
+}
+
+// DowngradeHandler is called for every encryption downgrade
+type DowngradeHandler func(EncryptionDowngrade)
+
+// AddDowngradeHandler adds an encryption downgrade handler
+func (a *App) AddDowngradeHandler(handler DowngradeHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.downgradeHandlers = append(a.downgradeHandlers, handler)
+}
+
+// EncryptionState tells whether the conversation with a contact is
+// encrypted, is about to be, or was and has since seen a plaintext message
+func (a *App) EncryptionState(otherUserID string) models.EncryptionState {
+	userID := models.NormalizeUserID(otherUserID)
+
+	a.handshakesMux.Lock()
+	pending := a.handshakes[userID] != nil
+	a.handshakesMux.Unlock()
+
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	switch {
+	case a.sessions[userID] == nil && pending:
+		return models.EncryptionPending
+	case a.sessions[userID] == nil:
+		return models.EncryptionNone
+	case a.downgraded[userID]:
+		return models.EncryptionDowngraded
+	default:
+		return models.EncryptionActive
+	}
+}
+
+// SessionKeyedAt returns when the encryption session with a contact was set
+// up or last re-keyed, or false if there is none. Sessions stored by older
+// versions report the zero time until they are re-keyed.
+func (a *App) SessionKeyedAt(otherUserID string) (time.Time, bool) {
+	userID := models.NormalizeUserID(otherUserID)
+
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	if a.sessions[userID] == nil {
+		return time.Time{}, false
+	}
+	return a.keyedAt[userID], true
+}
+
+// checkDowngrade is called for every plaintext chat message received from a
+// contact. If the conversation has an encryption session it warns, records
+// the downgrade and tells the downgrade handlers, and when verification is
+// required it fails with ErrEncryptionDowngrade so the message is dropped.
+// Messages are never sent in plaintext once there is a session.
+func (a *App) checkDowngrade(userID, messageID string) error {
+	a.sessionsMux.Lock()
+	if a.sessions[userID] == nil {
+		a.sessionsMux.Unlock()
+		return nil
+	}
+	a.downgraded[userID] = true
+	a.sessionsMux.Unlock()
+
+	refused := a.config.Security.RequireVerification
+	detail := fmt.Sprintf("plaintext message %s from %s in an encrypted conversation", messageID, userID)
+	if refused {
+		detail += ", refused"
+	}
+	log.Printf("WARNING: encryption downgrade: %s", detail)
+	a.audit(models.AuditEncryptionDowngrade, userID, detail)
+
+	a.handlersMux.RLock()
+	handlers := make([]DowngradeHandler, len(a.downgradeHandlers))
+	copy(handlers, a.downgradeHandlers)
+	a.handlersMux.RUnlock()
+
+	downgrade := EncryptionDowngrade{
+		UserID:    userID,
+		MessageID: messageID,
+		Refused:   refused,
+	}
+	for _, handler := range handlers {
+		handler(downgrade)
+	}
+
+	if refused {
+		return fmt.Errorf("%w: %s", ErrEncryptionDowngrade, userID)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestEncryptionStateAndDowngrades(t *testing.T) {
+	for _, requireVerification := range []bool{false, true} {
+		name := "warned"
+		if requireVerification {
+			name = "refused"
+		}
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t)
+			alice := newTestApp(t, "alice", func(cfg *config.Config) {
+				cfg.Security.RequireVerification = requireVerification
+			})
+			bob := newTestApp(t, "bob")
+			connectApp(t, alice, server)
+			connectApp(t, bob, server)
+			var mu sync.Mutex
+			var downgrades []EncryptionDowngrade
+			alice.AddDowngradeHandler(func(downgrade EncryptionDowngrade) {
+				mu.Lock()
+				defer mu.Unlock()
+				downgrades = append(downgrades, downgrade)
+			})
+
+			// A plaintext message before any session is no downgrade
+			if err := alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "carol", "c1", "hi, no keys yet")); err != nil {
+				t.Fatalf("handleNetworkMessage: %v", err)
+			}
+			if state := alice.EncryptionState("carol"); state != models.EncryptionNone {
+				t.Errorf("state with carol = %s, want none", state)
+			}
+
+			if err := bob.AddContact("alice", ""); err != nil {
+				t.Fatalf("AddContact: %v", err)
+			}
+			exchange(t, bob, alice, "hello")
+			exchange(t, alice, bob, "hi bob")
+			if state := alice.EncryptionState("bob"); state != models.EncryptionActive {
+				t.Fatalf("state with bob = %s, want active", state)
+			}
+
+			// Someone on the path strips the encryption
+			err := alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "bob", "b9", "send the money here"))
+			if requireVerification != errors.Is(err, ErrEncryptionDowngrade) {
+				t.Errorf("handleNetworkMessage = %v, want ErrEncryptionDowngrade %v", err, requireVerification)
+			}
+			if hasMessage(alice, "bob", "send the money here") == requireVerification {
+				t.Errorf("plaintext message stored %v, want %v", !requireVerification, !requireVerification)
+			}
+			if state := alice.EncryptionState("bob"); state != models.EncryptionDowngraded {
+				t.Errorf("state with bob = %s, want downgraded", state)
+			}
+
+			mu.Lock()
+			want := EncryptionDowngrade{UserID: "bob", MessageID: "b9", Refused: requireVerification}
+			if len(downgrades) != 1 || downgrades[0] != want {
+				t.Errorf("downgrades = %+v, want %+v", downgrades, want)
+			}
+			mu.Unlock()
+
+			events, err := alice.AuditLog()
+			if err != nil {
+				t.Fatalf("AuditLog: %v", err)
+			}
+			audited := 0
+			for _, event := range events {
+				if event.Action == models.AuditEncryptionDowngrade && event.UserID == "bob" && strings.Contains(event.Detail, "b9") {
+					audited++
+				}
+			}
+			if audited != 1 {
+				t.Errorf("%d downgrades audited, want 1", audited)
+			}
+		})
+	}
+}
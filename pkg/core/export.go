@@ -0,0 +1,128 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// HistoryExportOptions controls what a message history export contains
+type HistoryExportOptions struct {
+	// IncludeContent writes message text, link previews and text entities.
+	// Without it only metadata such as timestamps, status and attachment
+	// details is exported.
+	IncludeContent bool
+
+	// Passphrase encrypts the whole export if set. ReadHistoryJSONL
+	// decrypts it again.
+	Passphrase string
+}
+
+// ExportHistoryJSONL writes the message history with another user as JSON
+// Lines, one models.Message per line, oldest first
+func (a *App) ExportHistoryJSONL(otherUserID string, w io.Writer, opts HistoryExportOptions) error {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+	return a.exportHistory([]string{chatID}, w, opts)
+}
+
+// ExportAllHistoryJSONL is ExportHistoryJSONL for the chats with every
+// contact, in chat ID order so the same history always exports the same
+func (a *App) ExportAllHistoryJSONL(w io.Writer, opts HistoryExportOptions) error {
+	var chatIDs []string
+	for _, contact := range a.GetContacts() {
+		chatIDs = append(chatIDs, a.getChatID(a.config.User.ID, contact.UserID))
+	}
+	sort.Strings(chatIDs)
+	return a.exportHistory(chatIDs, w, opts)
+}
+
+func (a *App) exportHistory(chatIDs []string, w io.Writer, opts HistoryExportOptions) error {
+	// Encrypted exports are sealed as a whole, so collect them first
+	out := w
+	var buf bytes.Buffer
+	if opts.Passphrase != "" {
+		out = &buf
+		defer func() { crypto.Zeroize(buf.Bytes()) }()
+	}
+
+	encoder := json.NewEncoder(out)
+	for _, chatID := range chatIDs {
+		messages, err := a.storage.GetMessages(chatID, math.MaxInt, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read history of %s: %w", chatID, err)
+		}
+		for _, msg := range messages {
+			if !opts.IncludeContent {
+				msg = withoutContent(msg)
+			}
+			if err := encoder.Encode(msg); err != nil {
+				return fmt.Errorf("failed to write message %s: %w", msg.ID, err)
+			}
+		}
+	}
+
+	if opts.Passphrase == "" {
+		return nil
+	}
+	sealed, err := crypto.SealWithPassphrase(buf.Bytes(), opts.Passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// withoutContent returns a copy of a message with its text and anything
+// derived from it removed
+func withoutContent(msg *models.Message) *models.Message {
+	stripped := *msg
+	stripped.Content = ""
+	if msg.Metadata != nil {
+		metadata := *msg.Metadata
+		metadata.Entities = nil
+		metadata.Preview = nil
+		stripped.Metadata = &metadata
+	}
+	return &stripped
+}
+
+// ReadHistoryJSONL reads messages written by ExportHistoryJSONL or
+// ExportAllHistoryJSONL. The passphrase must match the one the export was
+// encrypted with, or be empty for an unencrypted export.
+func ReadHistoryJSONL(r io.Reader, passphrase string) ([]*models.Message, error) {
+	if passphrase != "" {
+		sealed, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		plaintext, err := crypto.OpenWithPassphrase(sealed, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		defer crypto.Zeroize(plaintext)
+		r = bytes.NewReader(plaintext)
+	}
+
+	var messages []*models.Message
+	decoder := json.NewDecoder(r)
+	for {
+		var msg models.Message
+		err := decoder.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode message %d: %w", len(messages)+1, err)
+		}
+		messages = append(messages, &msg)
+	}
+}
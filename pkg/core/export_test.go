@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// exportableHistory stores a few messages with bob and carol in app and
+// returns them as stored, oldest first
+func exportableHistory(t *testing.T, app *App) []*models.Message {
+	t.Helper()
+
+	// Recent enough that the retention sweep at startup keeps them
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second).UTC()
+	messages := []*models.Message{
+		{ID: "b1", Type: models.MessageTypeChat, From: "bob", To: "alice", Content: "see https://example.com", Status: models.MessageStatusRead, Timestamp: start, Sequence: 1,
+			Metadata: &models.Metadata{
+				Entities:  []models.Entity{{Type: "link", Offset: 4, Length: 19, Data: "https://example.com"}},
+				Preview:   &models.LinkPreview{URL: "https://example.com", Title: "Example"},
+				Reactions: []models.Reaction{{From: "alice", Emoji: "👍"}},
+			}},
+		{ID: "b2", Type: models.MessageTypeChat, From: "alice", To: "bob", Content: "thanks", Status: models.MessageStatusDelivered, Timestamp: start.Add(time.Minute), Sequence: 2,
+			Metadata: &models.Metadata{ReplyTo: "b1"}},
+		{ID: "c1", Type: models.MessageTypeChat, From: "carol", To: "alice", Content: "report.pdf", Status: models.MessageStatusRead, Timestamp: start.Add(time.Hour), Sequence: 1,
+			ReceivedAt: start.Add(time.Hour + time.Second),
+			Metadata:   &models.Metadata{Attachment: &models.Attachment{ID: "a1", Filename: "report.pdf", MimeType: "application/pdf", Size: 2048, Checksum: "abc"}}},
+	}
+	for _, contact := range []string{"bob", "carol"} {
+		if err := app.AddContact(contact, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+
+	var stored []*models.Message
+	for _, msg := range messages {
+		other := msg.From
+		if other == "alice" {
+			other = msg.To
+		}
+		msg.ChatID = app.getChatID("alice", other)
+		if err := app.storage.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		saved, err := app.storage.GetMessage(msg.ChatID, msg.ID)
+		if err != nil {
+			t.Fatalf("GetMessage: %v", err)
+		}
+		// Only what is written to JSON is exported
+		saved.CreatedAt, saved.UpdatedAt = time.Time{}, time.Time{}
+		stored = append(stored, saved)
+	}
+	return stored
+}
+
+func TestHistoryExportRoundTrips(t *testing.T) {
+	app := newTestApp(t, "alice")
+	stored := exportableHistory(t, app)
+
+	tests := []struct {
+		name   string
+		export func(w *bytes.Buffer, opts HistoryExportOptions) error
+		want   []*models.Message
+	}{
+		{"one chat", func(w *bytes.Buffer, opts HistoryExportOptions) error { return app.ExportHistoryJSONL("Bob", w, opts) }, stored[:2]},
+		{"all chats", func(w *bytes.Buffer, opts HistoryExportOptions) error { return app.ExportAllHistoryJSONL(w, opts) }, stored},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := tt.export(&out, HistoryExportOptions{IncludeContent: true}); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			if lines := strings.Count(out.String(), "\n"); lines != len(tt.want) {
+				t.Errorf("export has %d lines, want one per message: %d", lines, len(tt.want))
+			}
+
+			got, err := ReadHistoryJSONL(&out, "")
+			if err != nil {
+				t.Fatalf("ReadHistoryJSONL: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("read %d messages, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("message %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryExportLeavesOutContentByDefault(t *testing.T) {
+	app := newTestApp(t, "alice")
+	stored := exportableHistory(t, app)
+
+	var out bytes.Buffer
+	if err := app.ExportHistoryJSONL("bob", &out, HistoryExportOptions{}); err != nil {
+		t.Fatalf("ExportHistoryJSONL: %v", err)
+	}
+	for _, secret := range []string{"example.com", "thanks"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("export without content contains %q:\n%s", secret, out.String())
+		}
+	}
+
+	got, err := ReadHistoryJSONL(&out, "")
+	if err != nil {
+		t.Fatalf("ReadHistoryJSONL: %v", err)
+	}
+	for i, msg := range got {
+		want := withoutContent(stored[i])
+		if !reflect.DeepEqual(msg, want) {
+			t.Errorf("message %d = %+v, want %+v", i, msg, want)
+		}
+	}
+	if got[0].Metadata.Reactions == nil || got[1].Metadata.ReplyTo != "b1" {
+		t.Errorf("metadata was dropped with the content: %+v, %+v", got[0].Metadata, got[1].Metadata)
+	}
+}
+
+func TestEncryptedHistoryExport(t *testing.T) {
+	app := newTestApp(t, "alice")
+	stored := exportableHistory(t, app)
+
+	var out bytes.Buffer
+	opts := HistoryExportOptions{IncludeContent: true, Passphrase: "correct horse"}
+	if err := app.ExportAllHistoryJSONL(&out, opts); err != nil {
+		t.Fatalf("ExportAllHistoryJSONL: %v", err)
+	}
+	for _, plain := range []string{"thanks", "report.pdf", `"id"`} {
+		if strings.Contains(out.String(), plain) {
+			t.Errorf("encrypted export contains %q", plain)
+		}
+	}
+
+	if _, err := ReadHistoryJSONL(bytes.NewReader(out.Bytes()), "wrong"); err == nil {
+		t.Error("ReadHistoryJSONL opened the export with the wrong passphrase")
+	}
+	got, err := ReadHistoryJSONL(bytes.NewReader(out.Bytes()), opts.Passphrase)
+	if err != nil {
+		t.Fatalf("ReadHistoryJSONL: %v", err)
+	}
+	if !reflect.DeepEqual(got, stored) {
+		t.Errorf("decrypted export = %+v, want %+v", got, stored)
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ForwardMessage sends a copy of a stored message to another user,
+// attributed to the message's original author. The source chat is named by
+// the other user's ID, as for GetMessage. Attachments are forwarded by
+// reference: the stored file is offered to the new recipient rather than
+// copied.
+func (a *App) ForwardMessage(fromChatID, messageID, toUserID string) error {
+	original, err := a.GetMessage(fromChatID, messageID)
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+
+	to := models.NormalizeUserID(toUserID)
+	contact, err := a.chatContact(to)
+	if err != nil {
+		return err
+	}
+	if err := a.checkKeyChange(contact, false); err != nil {
+		return err
+	}
+
+	if original.HasAttachment() {
+		return a.forwardAttachment(original, to)
+	}
+
+	msg := models.NewMessage(models.MessageTypeChat, a.config.User.ID, to, original.Content)
+	msg.ChatID = a.getChatID(a.config.User.ID, to)
+	msg.Sequence = a.sequences.Next(msg.ChatID)
+	msg.Metadata = &models.Metadata{ForwardedFrom: original.Author()}
+
+	if err := a.transmit(msg); err != nil {
+		return err
+	}
+
+	log.Printf("Forwarded message %s to %s", messageID, to)
+	return nil
+}
+
+// forwardAttachment offers the stored file of an attachment message to a
+// contact and records the forward in the target chat
+func (a *App) forwardAttachment(original *models.Message, to string) error {
+	attachment := *original.Metadata.Attachment
+
+	// The stored file may have been replaced by a later one with the same name
+	file, err := a.storage.OpenAttachment(&attachment)
+	if err != nil {
+		return fmt.Errorf("attachment %s is no longer available: %w", attachment.Filename, err)
+	}
+	file.Close()
+
+	manifest, err := a.SendFile(to, a.storage.AttachmentPath(attachment.Filename))
+	if err != nil {
+		return err
+	}
+
+	msg := models.NewMessage(models.MessageTypeChat, a.config.User.ID, to, original.Content)
+	msg.ID = manifest.ID
+	msg.ChatID = a.getChatID(a.config.User.ID, to)
+	msg.DeviceID = a.config.User.DeviceID
+	msg.DeviceName = a.config.User.DeviceName
+	msg.Metadata = &models.Metadata{
+		ForwardedFrom: original.Author(),
+		Attachment:    &attachment,
+	}
+	msg.UpdateStatus(models.MessageStatusSent)
+
+	if err := a.storage.SaveMessage(msg); err != nil {
+		log.Printf("Warning: failed to save forwarded attachment: %v", err)
+	}
+	a.notifyMessageHandlers(msg)
+
+	log.Printf("Forwarded attachment %s to %s", original.ID, to)
+	return nil
+}
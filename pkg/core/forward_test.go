@@ -0,0 +1,132 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// findMessage returns the message with content in app's chat with another
+// user, or nil
+func findMessage(t *testing.T, app *App, otherUserID, content string) *models.Message {
+	t.Helper()
+
+	messages, err := app.GetMessages(otherUserID, 100)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	for _, msg := range messages {
+		if msg.Content == content {
+			return msg
+		}
+	}
+	return nil
+}
+
+func TestForwardMessage(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob, carol := newTestApp(t, "alice"), newTestApp(t, "bob"), newTestApp(t, "carol")
+	for _, app := range []*App{alice, bob, carol} {
+		connectApp(t, app, server)
+	}
+	for _, pair := range [][2]*App{{alice, bob}, {bob, alice}, {alice, carol}, {carol, alice}, {carol, bob}, {bob, carol}} {
+		if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	exchange(t, bob, alice, "lunch at one?")
+	exchange(t, alice, bob, "sounds good")
+
+	tests := []struct {
+		name       string
+		from, to   *App
+		chatWith   string
+		content    string
+		wantAuthor string
+	}{
+		{"a received message", alice, carol, "bob", "lunch at one?", "bob"},
+		{"our own message", alice, carol, "bob", "sounds good", "alice"},
+		{"a forwarded message keeps its author", carol, bob, "alice", "lunch at one?", "bob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := findMessage(t, tt.from, tt.chatWith, tt.content)
+			if original == nil {
+				t.Fatalf("%s has no message %q from %s", tt.from.config.User.ID, tt.content, tt.chatWith)
+			}
+			if err := tt.from.ForwardMessage(tt.chatWith, original.ID, tt.to.config.User.ID); err != nil {
+				t.Fatalf("ForwardMessage: %v", err)
+			}
+
+			// Both sides of the target chat show the original author
+			var received *models.Message
+			waitFor(t, "the forward to arrive", func() bool {
+				received = findMessage(t, tt.to, tt.from.config.User.ID, tt.content)
+				return received != nil
+			})
+			sent := findMessage(t, tt.from, tt.to.config.User.ID, tt.content)
+			for side, msg := range map[string]*models.Message{"sent": sent, "received": received} {
+				if msg == nil || msg.Metadata == nil || msg.Metadata.ForwardedFrom != tt.wantAuthor {
+					t.Errorf("%s forward = %+v, want forwarded from %s", side, msg, tt.wantAuthor)
+				}
+			}
+			if received.From != tt.from.config.User.ID {
+				t.Errorf("forward from %s, want %s", received.From, tt.from.config.User.ID)
+			}
+		})
+	}
+
+	if err := alice.ForwardMessage("bob", "no-such-message", "carol"); err == nil {
+		t.Error("ForwardMessage of a missing message succeeded")
+	}
+}
+
+func TestForwardAttachmentByReference(t *testing.T) {
+	server := newTestServer(t)
+	alice, carol := newTestApp(t, "alice"), newTestApp(t, "carol")
+	connectApp(t, alice, server)
+	connectApp(t, carol, server)
+	for _, pair := range [][2]*App{{alice, carol}, {carol, alice}} {
+		if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	exchange(t, alice, carol, "hi carol")
+
+	// A file bob sent alice earlier
+	path := alice.storage.AttachmentPath("notes.txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("notes"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	original := models.NewMessage(models.MessageTypeChat, "bob", "alice", "notes.txt")
+	original.ChatID = alice.getChatID("alice", "bob")
+	original.Metadata = &models.Metadata{Attachment: &models.Attachment{ID: "a1", Filename: "notes.txt", Size: 5}}
+	if err := alice.storage.SaveMessage(original); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	if err := alice.ForwardMessage("bob", original.ID, "carol"); err != nil {
+		t.Fatalf("ForwardMessage: %v", err)
+	}
+	forwarded := findMessage(t, alice, "carol", "notes.txt")
+	if forwarded == nil || forwarded.Metadata == nil {
+		t.Fatalf("forward in the chat with carol = %+v", forwarded)
+	}
+	if forwarded.Metadata.ForwardedFrom != "bob" || forwarded.Metadata.Attachment == nil || forwarded.Metadata.Attachment.Filename != "notes.txt" {
+		t.Errorf("forward metadata = %+v, want notes.txt forwarded from bob", forwarded.Metadata)
+	}
+
+	// A file replaced since is not forwarded in its place
+	if err := os.WriteFile(path, []byte("other notes"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := alice.ForwardMessage("bob", original.ID, "carol"); err == nil {
+		t.Error("ForwardMessage forwarded a replaced file")
+	}
+}
@@ -0,0 +1,390 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// gapGracePeriod is how long a gap in a sender's messages may stay open
+// before the messages in it are taken as missing. Messages overtaken on the
+// way, such as by the relay's priorities or another relay, arrive well
+// within it.
+const gapGracePeriod = 30 * time.Second
+
+// maxAheadMessages is how many of a sender's messages past a gap are kept
+// track of; beyond that the gap is given up on
+const maxAheadMessages = 1000
+
+// resendLookback is how many of the latest messages of a chat are searched
+// for the ones a resend request asks for
+const resendLookback = 1000
+
+// senderCountersConfig prefixes the stored counters of each chat
+const senderCountersConfig = "sender_sequences/"
+
+// MessageGap reports messages from a user that are missing in their chat.
+// A gap that was reported is reported again with no messages missing once
+// they arrive.
+type MessageGap struct {
+	UserID  string
+	Missing int
+}
+
+// MessageGapHandler is called when messages from a user are found missing,
+// or arrive after all
+type MessageGapHandler func(gap MessageGap)
+
+// AddMessageGapHandler adds a message gap handler
+func (a *App) AddMessageGapHandler(handler MessageGapHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.messageGapHandlers = append(a.messageGapHandlers, handler)
+}
+
+// notifyMessageGap passes a gap to the message gap handlers
+func (a *App) notifyMessageGap(gap MessageGap) {
+	a.handlersMux.RLock()
+	handlers := make([]MessageGapHandler, len(a.messageGapHandlers))
+	copy(handlers, a.messageGapHandlers)
+	a.handlersMux.RUnlock()
+
+	for _, handler := range handlers {
+		handler(gap)
+	}
+}
+
+// senderSequences numbers the messages this install sends in each chat 1,
+// 2, 3 and so on, apart from the chat sequence, and tracks how far each
+// sender's messages have arrived, so that a message lost on the way shows
+// as a gap. The counters are stored apart from the messages, since those
+// expire but the counters must never go back past a message that was sent.
+// Each chat's counters are locked on their own, so numbering a message in
+// one chat never waits for another.
+type senderSequences struct {
+	mu      sync.Mutex // Guards chats
+	storage storage.Store
+	chats   map[string]*senderCounters
+}
+
+// senderCounters are the counters of one chat
+type senderCounters struct {
+	mu sync.Mutex
+
+	Sent     uint64                    `json:"sent"`               // Messages this install numbered
+	Received map[string]*receiveWindow `json:"received,omitempty"` // By sender and install, see senderKey
+}
+
+// receiveWindow is how far the messages of one sender's install in a chat
+// have arrived
+type receiveWindow struct {
+	Through  uint64   `json:"through"`            // Every message up to this one arrived
+	Ahead    []uint64 `json:"ahead,omitempty"`    // Arrived past a gap, ascending
+	Reported bool     `json:"reported,omitempty"` // The gap was reported and asked to be resent
+}
+
+// newSenderSequences creates a sender sequence tracker backed by storage
+func newSenderSequences(s storage.Store) *senderSequences {
+	return &senderSequences{
+		storage: s,
+		chats:   make(map[string]*senderCounters),
+	}
+}
+
+// senderKey names the install of a sender, which numbers its messages on
+// its own
+func senderKey(userID, deviceID string) string {
+	return userID + "/" + deviceID
+}
+
+// Next returns the sender sequence of a new outgoing message in a chat
+func (s *senderSequences) Next(chatID string) uint64 {
+	counters := s.lock(chatID)
+	defer counters.mu.Unlock()
+
+	counters.Sent++
+	s.save(chatID, counters)
+	return counters.Sent
+}
+
+// Release gives back the sender sequence of an outgoing message that was
+// not sent, if no later message was numbered, so that the next message
+// takes it and the recipient sees no gap
+func (s *senderSequences) Release(chatID string, seq uint64) {
+	counters := s.lock(chatID)
+	defer counters.mu.Unlock()
+
+	if seq == 0 || counters.Sent != seq {
+		return
+	}
+	counters.Sent--
+	s.save(chatID, counters)
+}
+
+// Observe records that a sender's message arrived. It returns the sender
+// sequences still missing before the latest that arrived, at most
+// network.MaxResendSequences of them, and whether this message closed a gap
+// that had been reported.
+func (s *senderSequences) Observe(chatID, sender string, seq uint64) (missing []uint64, closedReported bool) {
+	counters := s.lock(chatID)
+	defer counters.mu.Unlock()
+
+	window := counters.Received[sender]
+	if window == nil {
+		// Counting starts with the first message seen from an install, which
+		// may have numbered many before this one was, or was upgraded to
+		window = &receiveWindow{Through: seq - 1}
+		counters.Received[sender] = window
+	}
+
+	i, found := slices.BinarySearch(window.Ahead, seq)
+	if seq <= window.Through || found {
+		return window.missing(), false
+	}
+
+	hadGap := len(window.Ahead) > 0
+	if seq == window.Through+1 {
+		window.Through = seq
+		window.absorb()
+	} else {
+		window.Ahead = slices.Insert(window.Ahead, i, seq)
+		if len(window.Ahead) > maxAheadMessages {
+			log.Printf("Warning: too many messages from %s in %s arrived past a gap, no longer waiting for it", sender, chatID)
+			window.skip()
+		}
+	}
+
+	if hadGap && len(window.Ahead) == 0 {
+		closedReported = window.Reported
+		window.Reported = false
+	}
+	s.save(chatID, counters)
+	return window.missing(), closedReported
+}
+
+// Missing returns the sender sequences still missing from a sender in a
+// chat, at most network.MaxResendSequences of them
+func (s *senderSequences) Missing(chatID, sender string) []uint64 {
+	counters := s.lock(chatID)
+	defer counters.mu.Unlock()
+
+	if window := counters.Received[sender]; window != nil {
+		return window.missing()
+	}
+	return nil
+}
+
+// Report marks the gap in a sender's messages as reported, returning false
+// if it already was or has closed
+func (s *senderSequences) Report(chatID, sender string) bool {
+	counters := s.lock(chatID)
+	defer counters.mu.Unlock()
+
+	window := counters.Received[sender]
+	if window == nil || window.Reported || len(window.Ahead) == 0 {
+		return false
+	}
+	window.Reported = true
+	s.save(chatID, counters)
+	return true
+}
+
+// Skip stops waiting for the messages missing from a sender, returning how
+// many that were
+func (s *senderSequences) Skip(chatID, sender string) int {
+	counters := s.lock(chatID)
+	defer counters.mu.Unlock()
+
+	window := counters.Received[sender]
+	if window == nil {
+		return 0
+	}
+	skipped := window.skip()
+	s.save(chatID, counters)
+	return skipped
+}
+
+// missing returns the sequences missing before the latest that arrived,
+// oldest first and at most network.MaxResendSequences of them
+func (w *receiveWindow) missing() []uint64 {
+	var missing []uint64
+	next := w.Through + 1
+	for _, seq := range w.Ahead {
+		for ; next < seq && len(missing) < network.MaxResendSequences; next++ {
+			missing = append(missing, next)
+		}
+		next = seq + 1
+	}
+	return missing
+}
+
+// absorb moves messages that arrived ahead into the contiguous run once
+// the gap before them is filled
+func (w *receiveWindow) absorb() {
+	for len(w.Ahead) > 0 && w.Ahead[0] == w.Through+1 {
+		w.Through = w.Ahead[0]
+		w.Ahead = w.Ahead[1:]
+	}
+}
+
+// skip takes every gap as closed, returning how many messages were missing
+func (w *receiveWindow) skip() int {
+	if len(w.Ahead) == 0 {
+		return 0
+	}
+	latest := w.Ahead[len(w.Ahead)-1]
+	skipped := int(latest-w.Through) - len(w.Ahead)
+	w.Through = latest
+	w.Ahead = nil
+	w.Reported = false
+	return skipped
+}
+
+// lock returns the counters of a chat locked, reading them from storage
+// the first time the chat is used. Callers unlock counters.mu.
+func (s *senderSequences) lock(chatID string) *senderCounters {
+	s.mu.Lock()
+	counters := s.load(chatID)
+	s.mu.Unlock()
+
+	counters.mu.Lock()
+	return counters
+}
+
+// load returns the counters of a chat, reading them from storage the first
+// time the chat is used. Callers must hold s.mu.
+func (s *senderSequences) load(chatID string) *senderCounters {
+	if counters, ok := s.chats[chatID]; ok {
+		return counters
+	}
+
+	counters := &senderCounters{}
+	err := s.storage.GetConfig(senderCountersConfig+chatID, counters)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Warning: failed to load sender sequences for chat %s: %v", chatID, err)
+	}
+	if counters.Received == nil {
+		counters.Received = make(map[string]*receiveWindow)
+	}
+	s.chats[chatID] = counters
+	return counters
+}
+
+// save stores the counters of a chat. Callers must hold counters.mu.
+func (s *senderSequences) save(chatID string, counters *senderCounters) {
+	if err := s.storage.SaveConfig(senderCountersConfig+chatID, counters); err != nil {
+		log.Printf("Warning: failed to save sender sequences for chat %s: %v", chatID, err)
+	}
+}
+
+// observeSenderSequence tracks a received message by its sender sequence.
+// A gap that stays open for gapGracePeriod is checked on by checkGap; one
+// that fills in meanwhile was only messages overtaking each other.
+func (a *App) observeSenderSequence(msg *models.Message) {
+	if msg.SenderSequence == 0 || msg.From == a.config.User.ID {
+		return
+	}
+
+	sender := senderKey(msg.From, msg.DeviceID)
+	missing, closedReported := a.senders.Observe(msg.ChatID, sender, msg.SenderSequence)
+	key := deliveryKey(msg.ChatID, sender)
+	if len(missing) == 0 {
+		a.gapChecks.cancel(key)
+		if closedReported {
+			log.Printf("Missing messages from %s arrived", msg.From)
+			a.notifyMessageGap(MessageGap{UserID: msg.From})
+		}
+		return
+	}
+
+	a.gapChecks.scheduleIfIdle(key, gapGracePeriod, func() {
+		a.checkGap(msg.ChatID, msg.From, msg.DeviceID)
+	})
+}
+
+// checkGap handles a gap in a sender's messages that stayed open for
+// gapGracePeriod. The first time, the user is told and the sender asked to
+// send the messages again; if they still have not arrived another period
+// later, they are given up on.
+func (a *App) checkGap(chatID, from, deviceID string) {
+	sender := senderKey(from, deviceID)
+	key := deliveryKey(chatID, sender)
+	a.gapChecks.cancel(key)
+
+	missing := a.senders.Missing(chatID, sender)
+	if len(missing) == 0 {
+		return
+	}
+
+	if !a.senders.Report(chatID, sender) {
+		if skipped := a.senders.Skip(chatID, sender); skipped > 0 {
+			log.Printf("Giving up on %d message(s) from %s that never arrived", skipped, from)
+		}
+		return
+	}
+
+	log.Printf("%d message(s) from %s are missing, asking for them again", len(missing), from)
+	a.notifyMessageGap(MessageGap{UserID: from, Missing: len(missing)})
+	request := network.ResendRequestPayload{DeviceID: deviceID, Sequences: missing}
+	if err := a.sendPayload(from, network.MessageTypeResendRequest, request); err != nil {
+		log.Printf("Warning: failed to ask %s to resend messages: %v", from, err)
+	}
+
+	a.gapChecks.scheduleIfIdle(key, gapGracePeriod, func() {
+		a.checkGap(chatID, from, deviceID)
+	})
+}
+
+// handleResendRequest sends again the messages a contact asked for, which
+// keep their IDs, so any that did arrive meanwhile are dropped as
+// duplicates
+func (a *App) handleResendRequest(netMsg *network.Message) error {
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping resend request: %w", err)
+	}
+	var request network.ResendRequestPayload
+	if err := netMsg.DecodePayload(&request); err != nil {
+		return fmt.Errorf("dropping resend request from %s: %w", from, err)
+	}
+	if request.DeviceID != "" && request.DeviceID != a.config.User.DeviceID {
+		return nil
+	}
+
+	contact, exists := a.GetContact(from)
+	if !exists || contact.Blocked {
+		return fmt.Errorf("dropping resend request from %s: not a contact", from)
+	}
+	if err := a.checkKeyChange(contact, false); err != nil {
+		return fmt.Errorf("not resending messages to %s: %w", from, err)
+	}
+
+	chatID := a.getChatID(a.config.User.ID, from)
+	recent, err := a.storage.GetMessagesBefore(chatID, nil, resendLookback)
+	if err != nil {
+		return fmt.Errorf("failed to load messages to resend: %w", err)
+	}
+
+	resent := 0
+	for _, msg := range recent {
+		if msg.From != a.config.User.ID || !slices.Contains(request.Sequences, msg.SenderSequence) {
+			continue
+		}
+		if err := a.transmit(msg); err != nil {
+			log.Printf("Warning: failed to resend message %s to %s: %v", msg.ID, from, err)
+			continue
+		}
+		resent++
+	}
+
+	log.Printf("Resent %d of %d message(s) %s asked for", resent, len(request.Sequences), from)
+	return nil
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// newTestSenders returns sender sequences backed by an in-memory store
+func newTestSenders(t *testing.T) (*senderSequences, storage.Store) {
+	t.Helper()
+
+	store, err := storage.NewMemoryStore(storage.StorageOptions{})
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return newSenderSequences(store), store
+}
+
+func TestSenderSequencesReleaseGivesBackTheLatest(t *testing.T) {
+	senders, store := newTestSenders(t)
+
+	if got := senders.Next("chat"); got != 1 {
+		t.Fatalf("Next = %d, want 1", got)
+	}
+	second := senders.Next("chat")
+	senders.Release("chat", second)
+	if got := senders.Next("chat"); got != second {
+		t.Errorf("Next after Release = %d, want %d again", got, second)
+	}
+
+	// The number given back stays given back after a restart
+	senders.Release("chat", second)
+	if got := newSenderSequences(store).Next("chat"); got != second {
+		t.Errorf("Next after reload = %d, want %d", got, second)
+	}
+}
+
+func TestSenderSequencesReleaseKeepsOlderNumbers(t *testing.T) {
+	senders, _ := newTestSenders(t)
+
+	first := senders.Next("chat")
+	senders.Next("chat")
+
+	// A later message was numbered, so giving back the first would reuse
+	// the second's number
+	senders.Release("chat", first)
+	senders.Release("chat", 0)
+	if got := senders.Next("chat"); got != 3 {
+		t.Errorf("Next = %d, want 3", got)
+	}
+}
+
+// numberChat numbers and stores a chat message from bob to alice as
+// transmit does, without sending it
+func numberChat(t *testing.T, bob *App, content string) *models.Message {
+	t.Helper()
+
+	msg := models.NewMessage(models.MessageTypeChat, "bob", "alice", content)
+	msg.ChatID = bob.getChatID("bob", "alice")
+	msg.Sequence = bob.sequences.Next(msg.ChatID)
+	msg.SenderSequence = bob.senders.Next(msg.ChatID)
+	msg.DeviceID = bob.config.User.DeviceID
+	msg.Encrypted = true
+	msg.UpdateStatus(models.MessageStatusSent)
+	if err := bob.storage.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	return msg
+}
+
+// sealChat encrypts a message numbered by numberChat, returning it as it
+// would go to the relay. Messages numbered in one order may be sealed in
+// another, as when sent at the same time.
+func sealChat(t *testing.T, bob *App, msg *models.Message) *network.Message {
+	t.Helper()
+
+	sealed, err := bob.sealChatPayload("alice", network.ChatPayload{
+		Content:        msg.Content,
+		Sequence:       msg.Sequence,
+		SenderSequence: msg.SenderSequence,
+		DeviceID:       msg.DeviceID,
+	})
+	if err != nil {
+		t.Fatalf("sealChatPayload: %v", err)
+	}
+	payload, err := network.EncodePayload(sealed)
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	netMsg := &network.Message{ID: msg.ID, Type: network.MessageTypeChat, From: "bob", To: "alice", Timestamp: msg.Timestamp.Unix(), Payload: payload}
+	if err := bob.signMessage(netMsg); err != nil {
+		t.Fatalf("signMessage: %v", err)
+	}
+	return netMsg
+}
+
+// recordGaps records the message gaps app reports
+func recordGaps(app *App) func() []MessageGap {
+	var mu sync.Mutex
+	var gaps []MessageGap
+	app.AddMessageGapHandler(func(gap MessageGap) {
+		mu.Lock()
+		defer mu.Unlock()
+		gaps = append(gaps, gap)
+	})
+	return func() []MessageGap {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(gaps)
+	}
+}
+
+// deliver hands messages to app as if they came from the relay
+func deliver(t *testing.T, app *App, msgs ...*network.Message) {
+	t.Helper()
+
+	for _, msg := range msgs {
+		if err := app.handleNetworkMessage("memory", msg); err != nil {
+			t.Fatalf("handling %s: %v", msg.ID, err)
+		}
+	}
+}
+
+// gapCheckPending reports whether a check on a gap in bob's messages to
+// alice is waiting for the grace period to pass
+func gapCheckPending(alice, bob *App) bool {
+	key := deliveryKey(alice.getChatID("alice", "bob"), senderKey("bob", bob.config.User.DeviceID))
+	alice.gapChecks.mu.Lock()
+	defer alice.gapChecks.mu.Unlock()
+	_, pending := alice.gapChecks.timers[key]
+	return pending
+}
+
+func TestLateMessageFillsGap(t *testing.T) {
+	alice, bob := sessionPair(t, func(*config.Config) {})
+	gaps := recordGaps(alice)
+	chatID, sender := alice.getChatID("alice", "bob"), senderKey("bob", bob.config.User.DeviceID)
+
+	// The second message is overtaken by the third
+	first, second, third := numberChat(t, bob, "one"), numberChat(t, bob, "two"), numberChat(t, bob, "three")
+	deliver(t, alice, sealChat(t, bob, first), sealChat(t, bob, third))
+
+	if missing := alice.senders.Missing(chatID, sender); !slices.Equal(missing, []uint64{second.SenderSequence}) {
+		t.Fatalf("missing after one was overtaken = %v, want [%d]", missing, second.SenderSequence)
+	}
+	if !gapCheckPending(alice, bob) {
+		t.Error("no check on the gap was scheduled")
+	}
+
+	deliver(t, alice, sealChat(t, bob, second))
+	if missing := alice.senders.Missing(chatID, sender); len(missing) != 0 {
+		t.Errorf("missing after the late message = %v, want none", missing)
+	}
+	if gapCheckPending(alice, bob) {
+		t.Error("the check on the gap is still scheduled after it filled")
+	}
+	if got := gaps(); len(got) != 0 {
+		t.Errorf("gaps reported = %v, want none for a message only overtaken", got)
+	}
+	for _, content := range []string{"one", "two", "three"} {
+		if !hasMessage(alice, "bob", content) {
+			t.Errorf("alice does not have %q", content)
+		}
+	}
+}
+
+func TestOpenGapAsksForResend(t *testing.T) {
+	alice, bob := sessionPair(t, func(*config.Config) {})
+	gaps := recordGaps(alice)
+	chatID, sender := alice.getChatID("alice", "bob"), senderKey("bob", bob.config.User.DeviceID)
+
+	first := numberChat(t, bob, "one")
+	numberChat(t, bob, "lost on the way")
+	third := numberChat(t, bob, "three")
+	deliver(t, alice, sealChat(t, bob, first), sealChat(t, bob, third))
+
+	// The grace period passes with the gap still open: alice is told and
+	// bob asked for the message, which he sends again from his history
+	alice.checkGap(chatID, "bob", bob.config.User.DeviceID)
+	if got := gaps(); len(got) != 1 || got[0] != (MessageGap{UserID: "bob", Missing: 1}) {
+		t.Fatalf("gaps reported = %v, want one message missing from bob", got)
+	}
+	waitFor(t, "the lost message to be sent again", func() bool {
+		return hasMessage(alice, "bob", "lost on the way")
+	})
+
+	waitFor(t, "the gap to be reported closed", func() bool { return len(gaps()) == 2 })
+	if got := gaps()[1]; got != (MessageGap{UserID: "bob"}) {
+		t.Errorf("second gap report = %+v, want bob with nothing missing", got)
+	}
+	if missing := alice.senders.Missing(chatID, sender); len(missing) != 0 {
+		t.Errorf("missing after the resend = %v, want none", missing)
+	}
+}
+
+func TestConcurrentSendsAreNumberedOnce(t *testing.T) {
+	alice, _ := sessionPair(t, func(*config.Config) {})
+
+	// Numbering does not wait for another message to go out, yet no two
+	// messages get the same number
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := alice.SendMessage("bob", fmt.Sprintf("message %d", i)); err != nil {
+				t.Errorf("SendMessage: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	messages, err := alice.GetMessages("bob", 100)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	var numbers []uint64
+	for _, msg := range messages {
+		if msg.From == "alice" {
+			numbers = append(numbers, msg.SenderSequence)
+		}
+	}
+	slices.Sort(numbers)
+	for i, number := range numbers {
+		if number != uint64(i+1) {
+			t.Fatalf("alice's messages are numbered %v, want 1 to %d once each", numbers, len(numbers))
+		}
+	}
+}
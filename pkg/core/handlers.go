@@ -0,0 +1,194 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// HandlerID identifies a registered message handler, hook or transform
+type HandlerID uint64
+
+// registeredHandler is a message handler and the ID it was registered under
+type registeredHandler struct {
+	id      HandlerID
+	handler MessageHandler
+}
+
+// AddMessageHandler registers a message handler and returns an ID that
+// removes it again via RemoveMessageHandler
+func (a *App) AddMessageHandler(handler MessageHandler) HandlerID {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.nextHandlerID++
+	id := a.nextHandlerID
+	a.messageHandlers = append(a.messageHandlers, registeredHandler{id: id, handler: handler})
+	return id
+}
+
+// RemoveMessageHandler unregisters a message handler. It reports whether
+// the handler was registered.
+func (a *App) RemoveMessageHandler(id HandlerID) bool {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	for i, registered := range a.messageHandlers {
+		if registered.id == id {
+			a.messageHandlers = append(a.messageHandlers[:i:i], a.messageHandlers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// notifyMessageHandlers passes a message to every registered handler. The
+// handler list is copied first, so handlers may add or remove handlers.
+func (a *App) notifyMessageHandlers(msg *models.Message) {
+	a.handlersMux.RLock()
+	handlers := make([]registeredHandler, len(a.messageHandlers))
+	copy(handlers, a.messageHandlers)
+	a.handlersMux.RUnlock()
+
+	for _, registered := range handlers {
+		callMessageHandler(registered, msg)
+	}
+}
+
+// callMessageHandler runs one handler, isolating its errors and panics from
+// the rest of message processing
+func callMessageHandler(registered registeredHandler, msg *models.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Message handler %d panicked: %v\n%s", registered.id, r, debug.Stack())
+		}
+	}()
+
+	if err := registered.handler(msg); err != nil {
+		log.Printf("Message handler error: %v", err)
+	}
+}
+
+// IncomingHook sees each received chat or system message before it is
+// stored, in registration order, and may change it. Returning true means
+// the hook handled the message, which is then dropped: it is neither stored
+// nor passed to later hooks or the message handlers. Its receipt has been
+// sent already.
+type IncomingHook func(*models.Message) (handled bool)
+
+// OutgoingTransform sees each message sent with SendMessage and its
+// variants before it is sent, in registration order, and may change its
+// content and metadata. Returning an error cancels the send, and the error
+// is returned to the caller. Forwarded messages and resends from the outbox
+// are not transformed again.
+type OutgoingTransform func(*models.Message) error
+
+// registeredHook is a hook and the ID it was registered under
+type registeredHook[T any] struct {
+	id   HandlerID
+	hook T
+}
+
+// RegisterIncomingHook registers a hook for received messages and returns
+// an ID that removes it again via RemoveHook
+func (a *App) RegisterIncomingHook(hook IncomingHook) HandlerID {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.nextHandlerID++
+	a.incomingHooks = append(a.incomingHooks, registeredHook[IncomingHook]{id: a.nextHandlerID, hook: hook})
+	return a.nextHandlerID
+}
+
+// RegisterOutgoingTransform registers a transform for sent messages and
+// returns an ID that removes it again via RemoveHook
+func (a *App) RegisterOutgoingTransform(transform OutgoingTransform) HandlerID {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.nextHandlerID++
+	a.outgoingTransforms = append(a.outgoingTransforms, registeredHook[OutgoingTransform]{id: a.nextHandlerID, hook: transform})
+	return a.nextHandlerID
+}
+
+// RemoveHook unregisters an incoming hook or outgoing transform. It reports
+// whether one was registered under id.
+func (a *App) RemoveHook(id HandlerID) bool {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	for i, registered := range a.incomingHooks {
+		if registered.id == id {
+			a.incomingHooks = append(a.incomingHooks[:i:i], a.incomingHooks[i+1:]...)
+			return true
+		}
+	}
+	for i, registered := range a.outgoingTransforms {
+		if registered.id == id {
+			a.outgoingTransforms = append(a.outgoingTransforms[:i:i], a.outgoingTransforms[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// runIncomingHooks passes a received message through the incoming hooks
+// and reports whether one of them handled it
+func (a *App) runIncomingHooks(msg *models.Message) bool {
+	a.handlersMux.RLock()
+	hooks := make([]registeredHook[IncomingHook], len(a.incomingHooks))
+	copy(hooks, a.incomingHooks)
+	a.handlersMux.RUnlock()
+
+	for _, registered := range hooks {
+		if callIncomingHook(registered, msg) {
+			log.Printf("Message %s from %s handled by hook %d", msg.ID, msg.From, registered.id)
+			return true
+		}
+	}
+	return false
+}
+
+// callIncomingHook runs one hook. A hook that panics has not handled the
+// message.
+func callIncomingHook(registered registeredHook[IncomingHook], msg *models.Message) (handled bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Incoming hook %d panicked: %v\n%s", registered.id, r, debug.Stack())
+			handled = false
+		}
+	}()
+
+	return registered.hook(msg)
+}
+
+// runOutgoingTransforms passes a message about to be sent through the
+// outgoing transforms, stopping at the first that fails
+func (a *App) runOutgoingTransforms(msg *models.Message) error {
+	a.handlersMux.RLock()
+	transforms := make([]registeredHook[OutgoingTransform], len(a.outgoingTransforms))
+	copy(transforms, a.outgoingTransforms)
+	a.handlersMux.RUnlock()
+
+	for _, registered := range transforms {
+		if err := callOutgoingTransform(registered, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callOutgoingTransform runs one transform. A transform that panics fails
+// the send, as it may have left the message half changed.
+func callOutgoingTransform(registered registeredHook[OutgoingTransform], msg *models.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Outgoing transform %d panicked: %v\n%s", registered.id, r, debug.Stack())
+			err = fmt.Errorf("outgoing transform %d failed: %v", registered.id, r)
+		}
+	}()
+
+	return registered.hook(msg)
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestRemoveMessageHandler(t *testing.T) {
+	app := newTestApp(t, "alice")
+	var first, second int
+	id := app.AddMessageHandler(func(*models.Message) error { first++; return nil })
+	app.AddMessageHandler(func(*models.Message) error { second++; return nil })
+
+	app.notifyMessageHandlers(&models.Message{ID: "m1"})
+	if !app.RemoveMessageHandler(id) {
+		t.Fatal("RemoveMessageHandler did not find the handler")
+	}
+	if app.RemoveMessageHandler(id) {
+		t.Error("RemoveMessageHandler removed the handler twice")
+	}
+	app.notifyMessageHandlers(&models.Message{ID: "m2"})
+
+	if first != 1 || second != 2 {
+		t.Errorf("handlers called %d and %d times, want 1 and 2", first, second)
+	}
+}
+
+func TestPanickingMessageHandlerIsIsolated(t *testing.T) {
+	app := newTestApp(t, "alice")
+	app.AddMessageHandler(func(*models.Message) error { panic("bad handler") })
+	var seen []string
+	app.AddMessageHandler(func(msg *models.Message) error {
+		seen = append(seen, msg.ID)
+		return nil
+	})
+
+	app.notifyMessageHandlers(&models.Message{ID: "m1"})
+	app.notifyMessageHandlers(&models.Message{ID: "m2"})
+	if len(seen) != 2 {
+		t.Errorf("handler after the panicking one saw %v, want m1 and m2", seen)
+	}
+}
+
+func TestConcurrentMessageHandlerRegistration(t *testing.T) {
+	app := newTestApp(t, "alice")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := app.AddMessageHandler(func(*models.Message) error { return nil })
+				app.RemoveMessageHandler(id)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				app.notifyMessageHandlers(&models.Message{ID: "m1"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Handlers that remove themselves while being notified
+	var calls int
+	var id HandlerID
+	id = app.AddMessageHandler(func(*models.Message) error {
+		calls++
+		app.RemoveMessageHandler(id)
+		return nil
+	})
+	app.notifyMessageHandlers(&models.Message{ID: "m1"})
+	app.notifyMessageHandlers(&models.Message{ID: "m2"})
+	if calls != 1 {
+		t.Errorf("self-removing handler called %d times, want 1", calls)
+	}
+}
+
+func TestIncomingHookDropsMessage(t *testing.T) {
+	app := newTestApp(t, "alice")
+	var shown []string
+	app.AddMessageHandler(func(msg *models.Message) error {
+		shown = append(shown, msg.Content)
+		return nil
+	})
+
+	var order []string
+	app.RegisterIncomingHook(func(*models.Message) bool {
+		order = append(order, "panics")
+		panic("bad hook")
+	})
+	app.RegisterIncomingHook(func(msg *models.Message) bool {
+		order = append(order, "rewrites")
+		msg.Content = strings.ReplaceAll(msg.Content, "darn", "****")
+		return false
+	})
+	bot := app.RegisterIncomingHook(func(msg *models.Message) bool {
+		order = append(order, "bot")
+		return strings.HasPrefix(msg.Content, "/")
+	})
+
+	for _, msg := range []struct{ id, content string }{{"m1", "/ping"}, {"m2", "darn it"}} {
+		if err := app.handleNetworkMessage(testRelay, incomingChat(t, app, "bob", msg.id, msg.content)); err != nil {
+			t.Fatalf("handleNetworkMessage: %v", err)
+		}
+	}
+
+	if got := strings.Join(order, ","); got != "panics,rewrites,bot,panics,rewrites,bot" {
+		t.Errorf("hooks ran as %s, want each in registration order past the panic", got)
+	}
+	if got := strings.Join(shown, ","); got != "**** it" {
+		t.Errorf("handlers saw %q, want only the rewritten message", got)
+	}
+	if hasMessage(app, "bob", "/ping") {
+		t.Error("the handled message was stored")
+	}
+	if !hasMessage(app, "bob", "**** it") {
+		t.Error("the rewritten message was not stored")
+	}
+
+	// Once removed, the hook no longer sees messages
+	if !app.RemoveHook(bot) || app.RemoveHook(bot) {
+		t.Error("RemoveHook did not remove the hook exactly once")
+	}
+	if err := app.handleNetworkMessage(testRelay, incomingChat(t, app, "bob", "m3", "/pong")); err != nil {
+		t.Fatalf("handleNetworkMessage: %v", err)
+	}
+	if !hasMessage(app, "bob", "/pong") {
+		t.Error("a message was dropped by a removed hook")
+	}
+}
+
+func TestOutgoingTransformRewritesContent(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+
+	alice.RegisterOutgoingTransform(func(msg *models.Message) error {
+		msg.Content += " (sent from my bot)"
+		msg.To = "mallory"
+		return nil
+	})
+	alice.RegisterOutgoingTransform(func(msg *models.Message) error {
+		if strings.Contains(msg.Content, "password") {
+			return errors.New("message looks like it holds a password")
+		}
+		msg.Content = strings.ToUpper(msg.Content[:1]) + msg.Content[1:]
+		return nil
+	})
+
+	if err := alice.SendMessage("bob", "hello"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	waitFor(t, "bob to receive the transformed message", func() bool {
+		return hasMessage(bob, "alice", "Hello (sent from my bot)")
+	})
+	if hasMessage(bob, "alice", "hello") || !hasMessage(alice, "bob", "Hello (sent from my bot)") {
+		t.Error("the untransformed message was kept")
+	}
+	if hasMessage(alice, "mallory", "Hello (sent from my bot)") {
+		t.Error("a transform changed where the message goes")
+	}
+
+	// A transform's error cancels the send
+	err := alice.SendMessage("bob", "my password is hunter2")
+	if err == nil || !strings.Contains(err.Error(), "holds a password") {
+		t.Errorf("SendMessage = %v, want the transform's error", err)
+	}
+	if hasMessage(alice, "bob", "My password is hunter2 (sent from my bot)") {
+		t.Error("a cancelled message was stored")
+	}
+
+	// So does a panic
+	alice.RegisterOutgoingTransform(func(*models.Message) error { panic("bad transform") })
+	if err := alice.SendMessage("bob", "anyone there?"); err == nil {
+		t.Error("a message was sent past a panicking transform")
+	}
+}
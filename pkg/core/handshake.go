@@ -0,0 +1,725 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+	"github.com/opensourceghana/securechat/pkg/network"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// Key requests that get no bundle are sent again after handshakeRetryDelay,
+// then after twice as long each time, up to handshakeMaxRetryDelay
+const (
+	handshakeRetryDelay    = 5 * time.Second
+	handshakeMaxRetryDelay = 5 * time.Minute
+)
+
+// handshakeCollisionWindow is how long after starting a session with a user
+// a session init from them counts as a collision. When both users start a
+// session with each other at once, the one started by the lower user ID is
+// kept on both sides.
+const handshakeCollisionWindow = 2 * time.Minute
+
+// usedEphemeralConfig prefixes the hashes of the ephemeral keys of session
+// inits already accepted. The signed prekey is long-lived, so an init
+// recorded by a relay would otherwise start the same session again.
+const usedEphemeralConfig = "handshake/ephemeral/"
+
+var (
+	// ErrHandshakeTimeout is the reason messages fail when a contact does
+	// not answer a key exchange within the handshake timeout
+	ErrHandshakeTimeout = errors.New("contact did not answer the key exchange")
+	// ErrPendingKey is the reason messages fail when a contact presents an
+	// identity key that awaits the user's acceptance
+	ErrPendingKey = errors.New("contact's new identity key has not been accepted")
+	// ErrSessionQueueFull is the reason the oldest messages waiting for a
+	// session fail when more than the session queue limit are sent
+	ErrSessionQueueFull = errors.New("too many messages waiting for the encrypted session")
+	// ErrSessionQueueExpired is the reason messages fail when they have
+	// waited for a session for longer than the session queue TTL
+	ErrSessionQueueExpired = errors.New("waited too long for the encrypted session")
+
+	errNoSession = errors.New("no encryption session")
+)
+
+// handshake is a key exchange in progress with a user, started by this side
+type handshake struct {
+	ephemeral crypto.KeyPair
+	started   time.Time
+	attempts  int
+	unsent    bool              // The last key request could not be sent
+	queued    []*models.Message // Waiting for the session, in the order sent
+}
+
+// evict takes the messages that have waited longer than ttl out of the
+// queue, and then the oldest beyond limit
+func (hs *handshake) evict(limit int, ttl time.Duration, now time.Time) (expired, overflow []*models.Message) {
+	if ttl > 0 {
+		kept := hs.queued[:0]
+		for _, msg := range hs.queued {
+			if now.Sub(msg.Timestamp) > ttl {
+				expired = append(expired, msg)
+			} else {
+				kept = append(kept, msg)
+			}
+		}
+		hs.queued = kept
+	}
+	if limit > 0 && len(hs.queued) > limit {
+		excess := len(hs.queued) - limit
+		overflow = slices.Clone(hs.queued[:excess])
+		hs.queued = slices.Delete(hs.queued, 0, excess)
+	}
+	return expired, overflow
+}
+
+// nextExpiry returns when the oldest queued message outlives ttl, or the
+// zero time if none will
+func (hs *handshake) nextExpiry(ttl time.Duration) time.Time {
+	if ttl <= 0 || len(hs.queued) == 0 {
+		return time.Time{}
+	}
+	oldest := hs.queued[0].Timestamp
+	for _, msg := range hs.queued[1:] {
+		if msg.Timestamp.Before(oldest) {
+			oldest = msg.Timestamp
+		}
+	}
+	return oldest.Add(ttl)
+}
+
+// MessageDrop reports messages to a user that failed while waiting for an
+// encryption session
+type MessageDrop struct {
+	UserID string
+	Count  int
+	Reason error
+}
+
+// MessageDropHandler is called when messages waiting for a session fail
+type MessageDropHandler func(drop MessageDrop)
+
+// AddMessageDropHandler adds a message drop handler
+func (a *App) AddMessageDropHandler(handler MessageDropHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.messageDropHandlers = append(a.messageDropHandlers, handler)
+}
+
+// notifyMessageDrop passes a drop to the message drop handlers
+func (a *App) notifyMessageDrop(drop MessageDrop) {
+	a.handlersMux.RLock()
+	handlers := make([]MessageDropHandler, len(a.messageDropHandlers))
+	copy(handlers, a.messageDropHandlers)
+	a.handlersMux.RUnlock()
+
+	for _, handler := range handlers {
+		handler(drop)
+	}
+}
+
+// SessionStateHandler is called when a session with a user starts being set
+// up, is established, or could not be set up
+type SessionStateHandler func(userID string, state models.EncryptionState)
+
+// AddSessionStateHandler adds a session state handler
+func (a *App) AddSessionStateHandler(handler SessionStateHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.sessionStateHandlers = append(a.sessionStateHandlers, handler)
+}
+
+// notifySessionState passes the encryption state of a conversation to the
+// session state handlers
+func (a *App) notifySessionState(userID string, state models.EncryptionState) {
+	a.handlersMux.RLock()
+	handlers := make([]SessionStateHandler, len(a.sessionStateHandlers))
+	copy(handlers, a.sessionStateHandlers)
+	a.handlersMux.RUnlock()
+
+	for _, handler := range handlers {
+		handler(userID, state)
+	}
+}
+
+// hasSession reports whether there is an encryption session with a user
+func (a *App) hasSession(userID string) bool {
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	return a.sessions[userID] != nil
+}
+
+// awaitSession holds a message to a user without an encryption session
+// until one is set up, starting a key exchange unless one is under way. The
+// message is stored as pending and kept in the outbox, so it is sent again
+// after a restart. Messages beyond the session queue limit or older than
+// its TTL fail, oldest first.
+func (a *App) awaitSession(msg *models.Message) error {
+	a.handshakesMux.Lock()
+	hs, exists := a.handshakes[msg.To]
+	if !exists {
+		ephemeral, err := crypto.GenerateEphemeralKey()
+		if err != nil {
+			a.handshakesMux.Unlock()
+			return err
+		}
+		hs = &handshake{ephemeral: ephemeral, started: time.Now()}
+		a.handshakes[msg.To] = hs
+	}
+	if !slices.ContainsFunc(hs.queued, func(queued *models.Message) bool { return queued.ID == msg.ID }) {
+		hs.queued = append(hs.queued, msg)
+	}
+	expired, overflow := hs.evict(a.config.Network.SessionQueueLimit, a.config.Network.SessionQueueTTL, time.Now())
+	a.handshakesMux.Unlock()
+
+	msg.Status = models.MessageStatusPending
+	if err := a.storage.SaveMessage(msg); err != nil {
+		log.Printf("Warning: failed to save message awaiting a session: %v", err)
+	}
+	a.scheduleExpiry(msg)
+	a.clocks.observe(msg)
+	a.notifyMessageHandlers(msg)
+	a.queueMessage(msg)
+	a.failQueued(msg.To, expired, ErrSessionQueueExpired)
+	a.failQueued(msg.To, overflow, ErrSessionQueueFull)
+
+	switch {
+	case exists:
+	case a.hasSession(msg.To):
+		// The conversation stays encrypted while the session is re-keyed
+		log.Printf("Re-keying the encrypted session with %s", msg.To)
+		a.requestKeys(msg.To)
+	default:
+		log.Printf("Setting up an encrypted session with %s", msg.To)
+		a.notifySessionState(msg.To, models.EncryptionPending)
+		a.requestKeys(msg.To)
+	}
+	return nil
+}
+
+// awaitingSession reports whether a message is held until a session with
+// its recipient is set up
+func (a *App) awaitingSession(msg *models.Message) bool {
+	a.handshakesMux.Lock()
+	defer a.handshakesMux.Unlock()
+
+	hs, exists := a.handshakes[msg.To]
+	return exists && slices.ContainsFunc(hs.queued, func(queued *models.Message) bool { return queued.ID == msg.ID })
+}
+
+// requestKeys asks a user for their key bundle and schedules the next
+// attempt, backing off each time, or gives up once the handshake timeout
+// has passed. Queued messages that outlived the session queue TTL fail on
+// the way. A request that cannot be sent, because no relay is reachable,
+// is simply retried.
+func (a *App) requestKeys(userID string) {
+	a.handshakesMux.Lock()
+	hs, exists := a.handshakes[userID]
+	if !exists {
+		a.handshakesMux.Unlock()
+		return
+	}
+	hs.attempts++
+	attempts, started := hs.attempts, hs.started
+	ttl := a.config.Network.SessionQueueTTL
+	expired, _ := hs.evict(0, ttl, time.Now())
+	nextExpiry := hs.nextExpiry(ttl)
+	a.handshakesMux.Unlock()
+	a.failQueued(userID, expired, ErrSessionQueueExpired)
+
+	delay := handshakeMaxRetryDelay
+	if attempts <= 10 {
+		delay = min(handshakeRetryDelay<<(attempts-1), handshakeMaxRetryDelay)
+	}
+	if timeout := a.config.Network.HandshakeTimeout; timeout > 0 {
+		remaining := time.Until(started.Add(timeout))
+		if remaining <= 0 {
+			a.failHandshake(userID, ErrHandshakeTimeout)
+			return
+		}
+		delay = min(delay, remaining)
+	}
+	if !nextExpiry.IsZero() {
+		// Check again when the oldest message expires
+		delay = max(min(delay, time.Until(nextExpiry)), time.Second)
+	}
+	a.handshakeRetries.schedule(userID, delay, func() {
+		select {
+		case <-a.done:
+		default:
+			a.requestKeys(userID)
+		}
+	})
+
+	request := network.KeyRequestPayload{Ciphers: cipherNumbers(a.CipherPreferences())}
+	err := a.sendPayload(userID, network.MessageTypeKeyRequest, request)
+	if err != nil {
+		log.Printf("Key request to %s failed, retrying in %s: %v", userID, delay.Round(time.Second), err)
+	}
+
+	a.handshakesMux.Lock()
+	if hs, exists := a.handshakes[userID]; exists {
+		hs.unsent = err != nil
+	}
+	a.handshakesMux.Unlock()
+}
+
+// retryUnsentKeyRequests sends the key requests that could not be sent
+// while no relay was reachable, rather than leaving the messages waiting
+// for them until the next scheduled attempt
+func (a *App) retryUnsentKeyRequests() {
+	a.handshakesMux.Lock()
+	var userIDs []string
+	for userID, hs := range a.handshakes {
+		if hs.unsent {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	a.handshakesMux.Unlock()
+
+	for _, userID := range userIDs {
+		a.requestKeys(userID)
+	}
+}
+
+// failHandshake gives up a key exchange and marks the messages waiting for
+// it as failed; they can be sent again, which starts a new exchange
+func (a *App) failHandshake(userID string, cause error) {
+	a.handshakesMux.Lock()
+	hs, exists := a.handshakes[userID]
+	delete(a.handshakes, userID)
+	a.handshakesMux.Unlock()
+	a.handshakeRetries.cancel(userID)
+	if !exists {
+		return
+	}
+
+	log.Printf("Could not set up an encrypted session with %s: %v", userID, cause)
+	a.failQueued(userID, hs.queued, cause)
+	a.notifySessionState(userID, a.EncryptionState(userID))
+}
+
+// failQueued marks messages that waited for a session with a user as
+// failed, takes them out of the outbox and reports the drop. Messages
+// cancelled while they waited are left alone.
+func (a *App) failQueued(userID string, msgs []*models.Message, cause error) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	failed := 0
+	for _, queued := range msgs {
+		if err := a.storage.DeleteOutboxEntry(queued.ChatID, queued.ID); err != nil {
+			log.Printf("Warning: failed to remove message from outbox: %v", err)
+		}
+		msg, err := a.storage.GetMessage(queued.ChatID, queued.ID)
+		if err != nil || !msg.UpdateStatus(models.MessageStatusFailed) {
+			// Cancelled while it waited
+			continue
+		}
+		if err := a.storage.SaveMessage(msg); err != nil {
+			log.Printf("Warning: failed to save message status: %v", err)
+		}
+		a.notifyMessageHandlers(msg)
+		failed++
+	}
+	a.notifyOutboxHandlers()
+	if failed == 0 {
+		return
+	}
+
+	log.Printf("%d message(s) to %s failed: %v", failed, userID, cause)
+	a.notifyMessageDrop(MessageDrop{UserID: userID, Count: failed, Reason: cause})
+}
+
+// completeHandshake sends the messages that waited for the session with a
+// user now that it is set up
+func (a *App) completeHandshake(userID string) {
+	a.handshakesMux.Lock()
+	hs, exists := a.handshakes[userID]
+	delete(a.handshakes, userID)
+	a.handshakesMux.Unlock()
+	a.handshakeRetries.cancel(userID)
+
+	log.Printf("Established an encrypted session with %s", userID)
+	a.notifySessionState(userID, models.EncryptionActive)
+	if !exists || len(hs.queued) == 0 {
+		return
+	}
+
+	// Keep a drain of the outbox from sending the same messages
+	a.outboxMux.Lock()
+	defer a.outboxMux.Unlock()
+
+	for _, queued := range hs.queued {
+		msg, err := a.storage.GetMessage(queued.ChatID, queued.ID)
+		if err != nil || msg.Status != models.MessageStatusPending {
+			// Cancelled while it waited
+			continue
+		}
+		if err := a.transmit(msg); err != nil {
+			log.Printf("Failed to send message %s after setting up the session: %v", msg.ID, err)
+			continue
+		}
+		if msg.Status == models.MessageStatusPending {
+			// Queued until a relay is reachable
+			continue
+		}
+		if err := a.storage.DeleteOutboxEntry(msg.ChatID, msg.ID); err != nil {
+			log.Printf("Warning: failed to remove message from outbox: %v", err)
+		}
+	}
+	a.notifyOutboxHandlers()
+}
+
+// handleKeyRequest answers a key request with the key bundle
+func (a *App) handleKeyRequest(netMsg *network.Message) error {
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping key request: %w", err)
+	}
+	if a.preKey == nil {
+		log.Printf("Cannot answer key request from %s: this identity has no signed prekey", from)
+		return nil
+	}
+
+	bundle := network.KeyBundlePayload{
+		IdentityKey:     a.identity.SigningKey.PublicKey,
+		ExchangeKey:     a.identity.ExchangeKey.PublicKey,
+		PreKeyID:        a.preKey.ID,
+		SignedPreKey:    a.preKey.KeyPair.PublicKey,
+		PreKeySignature: a.preKey.Signature,
+		Ciphers:         cipherNumbers(a.CipherPreferences()),
+	}
+	if err := a.sendPayload(from, network.MessageTypeKeyBundle, bundle); err != nil {
+		return fmt.Errorf("failed to send key bundle to %s: %w", from, err)
+	}
+	return nil
+}
+
+// handleKeyBundle starts the session a key request was sent for: it checks
+// the bundle, derives the shared secret, sends the session init and then
+// the messages that waited for the session
+func (a *App) handleKeyBundle(netMsg *network.Message) error {
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping key bundle: %w", err)
+	}
+	var bundle network.KeyBundlePayload
+	if err := netMsg.DecodePayload(&bundle); err != nil {
+		return fmt.Errorf("dropping key bundle from %s: %w", from, err)
+	}
+
+	a.handshakesMux.Lock()
+	hs, exists := a.handshakes[from]
+	var ephemeral crypto.KeyPair
+	if exists {
+		ephemeral = hs.ephemeral
+	}
+	a.handshakesMux.Unlock()
+	if !exists {
+		// An answer to a retried request, or a bundle nobody asked for
+		return nil
+	}
+
+	if !crypto.VerifySignature(bundle.IdentityKey, bundle.SignedPreKey, bundle.PreKeySignature) {
+		return fmt.Errorf("dropping key bundle from %s: prekey signature does not match the identity key", from)
+	}
+	if err := a.checkPeerIdentity(from, bundle.IdentityKey, bundle.ExchangeKey); err != nil {
+		a.failHandshake(from, err)
+		return nil
+	}
+
+	sharedSecret, err := crypto.AgreeInitiator(a.identity, ephemeral, bundle.ExchangeKey, bundle.SignedPreKey)
+	if err != nil {
+		return fmt.Errorf("key agreement with %s failed: %w", from, err)
+	}
+	defer crypto.Zeroize(sharedSecret)
+	ratchet, err := a.newSession(sharedSecret, nil, bundle.SignedPreKey, cipherIDs(bundle.Ciphers))
+	if err != nil {
+		a.failHandshake(from, err)
+		return nil
+	}
+
+	init := network.SessionInitPayload{
+		IdentityKey:  a.identity.SigningKey.PublicKey,
+		ExchangeKey:  a.identity.ExchangeKey.PublicKey,
+		EphemeralKey: ephemeral.PublicKey,
+		PreKeyID:     bundle.PreKeyID,
+		RatchetKey:   ratchet.DHSelf.PublicKey,
+		Ciphers:      cipherNumbers(a.CipherPreferences()),
+	}
+	init.Signature = a.identity.Sign(sessionInitSignedData(&init))
+	if err := a.sendPayload(from, network.MessageTypeSessionInit, init); err != nil {
+		// The peer cannot use the session without the init; the pending
+		// retry asks for a new bundle
+		ratchet.Wipe()
+		log.Printf("Failed to send session init to %s: %v", from, err)
+		return nil
+	}
+
+	if err := a.startSession(from, ratchet); err != nil {
+		a.failHandshake(from, err)
+		return nil
+	}
+	a.handshakesMux.Lock()
+	a.initiatedAt[from] = time.Now()
+	a.handshakesMux.Unlock()
+
+	a.completeHandshake(from)
+	return nil
+}
+
+// handleSessionInit starts the session a user set up with the key bundle
+func (a *App) handleSessionInit(netMsg *network.Message) error {
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping session init: %w", err)
+	}
+	var init network.SessionInitPayload
+	if err := netMsg.DecodePayload(&init); err != nil {
+		return fmt.Errorf("dropping session init from %s: %w", from, err)
+	}
+
+	if !crypto.VerifySignature(init.IdentityKey, sessionInitSignedData(&init), init.Signature) {
+		return fmt.Errorf("dropping session init from %s: signature does not match the identity key", from)
+	}
+	if a.preKey == nil || init.PreKeyID != a.preKey.ID {
+		return fmt.Errorf("dropping session init from %s: unknown prekey %d", from, init.PreKeyID)
+	}
+	if err := a.consumeEphemeralKey(init.EphemeralKey); err != nil {
+		return fmt.Errorf("dropping session init from %s: %w", from, err)
+	}
+	if err := a.checkPeerIdentity(from, init.IdentityKey, init.ExchangeKey); err != nil {
+		log.Printf("Ignoring session init from %s: %v", from, err)
+		return nil
+	}
+
+	a.handshakesMux.Lock()
+	initiated, exists := a.initiatedAt[from]
+	collision := exists && time.Since(initiated) < handshakeCollisionWindow && a.config.User.ID < from
+	if !collision {
+		delete(a.initiatedAt, from)
+	}
+	a.handshakesMux.Unlock()
+	if collision {
+		log.Printf("Keeping the session started with %s rather than theirs", from)
+		return nil
+	}
+
+	sharedSecret, err := crypto.AgreeResponder(a.identity, a.preKey.KeyPair, init.ExchangeKey, init.EphemeralKey)
+	if err != nil {
+		return fmt.Errorf("key agreement with %s failed: %w", from, err)
+	}
+	defer crypto.Zeroize(sharedSecret)
+	ratchet, err := a.newSession(sharedSecret, &a.preKey.KeyPair, init.RatchetKey, cipherIDs(init.Ciphers))
+	if err != nil {
+		return fmt.Errorf("failed to start session with %s: %w", from, err)
+	}
+	if err := a.startSession(from, ratchet); err != nil {
+		return err
+	}
+
+	// A key exchange of our own with them is no longer needed
+	a.completeHandshake(from)
+	return nil
+}
+
+// checkPeerIdentity records the identity key a contact presented in a key
+// exchange, as UpdateContactKey does. It fails if the key is held for the
+// user to accept, or if it replaced the contact's key and verification is
+// required. Sessions with users who are not contacts are not checked.
+func (a *App) checkPeerIdentity(userID string, signingKey, exchangeKey []byte) error {
+	before, exists := a.GetContact(userID)
+	if !exists {
+		return nil
+	}
+
+	fingerprint := crypto.Fingerprint(signingKey, exchangeKey)
+	if err := a.UpdateContactKey(userID, signingKey, exchangeKey); err != nil {
+		return err
+	}
+	after, exists := a.GetContact(userID)
+	switch {
+	case !exists:
+		return fmt.Errorf("%w: %s", errContactNotFound, userID)
+	case !crypto.FingerprintsMatch(after.Fingerprint, fingerprint):
+		return fmt.Errorf("%w: %s", ErrPendingKey, userID)
+	case after.KeyChanged && !before.KeyChanged && a.config.Security.RequireVerification:
+		return fmt.Errorf("%w: %s", ErrUnverifiedKeyChange, userID)
+	}
+	return nil
+}
+
+// sealChatPayload encrypts a chat payload with the session with a user,
+// returning the payload that carries it
+func (a *App) sealChatPayload(userID string, inner network.ChatPayload) (network.ChatPayload, error) {
+	header, ciphertext, err := a.sealPayload(userID, inner)
+	if err != nil {
+		return network.ChatPayload{}, err
+	}
+	return network.ChatPayload{Header: header, Ciphertext: ciphertext}, nil
+}
+
+// sealPayload encrypts any payload with the session with a user, returning
+// the ratchet header and ciphertext that carry it
+func (a *App) sealPayload(userID string, inner interface{}) (*network.RatchetHeader, []byte, error) {
+	plaintext, err := json.Marshal(inner)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer crypto.Zeroize(plaintext)
+
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	ratchet := a.sessions[userID]
+	if ratchet == nil {
+		return nil, nil, errNoSession
+	}
+	header := &network.RatchetHeader{
+		RatchetKey:      ratchet.DHSelf.PublicKey,
+		PreviousCounter: ratchet.PreviousCounter,
+		MessageNumber:   ratchet.SendingChain.MessageNumber,
+	}
+	sealed, err := ratchet.Encrypt(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := a.saveSessionLocked(userID, ratchet); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	ciphertext, err := sealed.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, ciphertext, nil
+}
+
+// openChatPayload decrypts a chat payload received from a user in place,
+// keeping its header so the message is known to have been encrypted
+func (a *App) openChatPayload(userID string, payload *network.ChatPayload) error {
+	var inner network.ChatPayload
+	if err := a.openPayload(userID, payload.Header, payload.Ciphertext, &inner); err != nil {
+		return err
+	}
+	inner.Header = payload.Header
+	*payload = inner
+	return nil
+}
+
+// openPayload decrypts a payload sealed by sealPayload into dest
+func (a *App) openPayload(userID string, header *network.RatchetHeader, ciphertext []byte, dest interface{}) error {
+	var sealed crypto.EncryptedMessage
+	if err := sealed.UnmarshalBinary(ciphertext); err != nil {
+		return fmt.Errorf("unreadable ciphertext: %w", err)
+	}
+
+	a.sessionsMux.Lock()
+	ratchet := a.sessions[userID]
+	if ratchet == nil {
+		a.sessionsMux.Unlock()
+		return errNoSession
+	}
+	plaintext, err := ratchet.Decrypt(&sealed, header.MessageNumber)
+	if err == nil {
+		if err := a.saveSessionLocked(userID, ratchet); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		// The peer uses the new session, so nothing more is sent under a
+		// retired one
+		if retired := a.retired[userID]; retired != nil {
+			retired.Wipe()
+			delete(a.retired, userID)
+		}
+	} else if retired := a.retired[userID]; retired != nil {
+		// Sent under the old session before the peer learned of a re-key
+		if earlier, retiredErr := retired.Decrypt(&sealed, header.MessageNumber); retiredErr == nil {
+			plaintext, err = earlier, nil
+		}
+	}
+	a.sessionsMux.Unlock()
+	if err != nil {
+		return err
+	}
+	defer crypto.Zeroize(plaintext)
+
+	if err := json.Unmarshal(plaintext, dest); err != nil {
+		return fmt.Errorf("unreadable payload: %w", err)
+	}
+	return nil
+}
+
+// consumeEphemeralKey records the ephemeral key of a session init, failing
+// if an init with the same key was accepted before
+func (a *App) consumeEphemeralKey(key []byte) error {
+	hash := sha256.Sum256(key)
+	name := usedEphemeralConfig + hex.EncodeToString(hash[:])
+
+	a.handshakesMux.Lock()
+	defer a.handshakesMux.Unlock()
+
+	var usedAt time.Time
+	err := a.storage.GetConfig(name, &usedAt)
+	if err == nil {
+		return fmt.Errorf("ephemeral key was already used on %s", usedAt.Format(time.RFC3339))
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to check the ephemeral key: %w", err)
+	}
+	if err := a.storage.SaveConfig(name, time.Now()); err != nil {
+		return fmt.Errorf("failed to record the ephemeral key: %w", err)
+	}
+	return nil
+}
+
+// sessionInitSignedData returns what the signature of a session init
+// covers: every key in it, the prekey it answers and the ciphers offered,
+// so none can be swapped. Keys are prefixed with their length.
+func sessionInitSignedData(init *network.SessionInitPayload) []byte {
+	data := []byte("securechat session init")
+	for _, key := range [][]byte{init.IdentityKey, init.ExchangeKey, init.EphemeralKey, init.RatchetKey} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(key)))
+		data = append(data, key...)
+	}
+	data = binary.BigEndian.AppendUint32(data, init.PreKeyID)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(init.Ciphers)))
+	for _, cipher := range init.Ciphers {
+		data = binary.BigEndian.AppendUint32(data, uint32(cipher))
+	}
+	return data
+}
+
+// cipherNumbers converts cipher IDs to their form in key exchange payloads
+func cipherNumbers(ids []crypto.CipherID) []int {
+	numbers := make([]int, len(ids))
+	for i, id := range ids {
+		numbers[i] = int(id)
+	}
+	return numbers
+}
+
+// cipherIDs converts the ciphers offered in a key exchange payload to
+// cipher IDs, skipping numbers no cipher could have
+func cipherIDs(numbers []int) []crypto.CipherID {
+	ids := make([]crypto.CipherID, 0, len(numbers))
+	for _, number := range numbers {
+		if number > 0 && number < 256 {
+			ids = append(ids, crypto.CipherID(number))
+		}
+	}
+	return ids
+}
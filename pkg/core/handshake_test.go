@@ -0,0 +1,442 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// testRelay is the relay test apps are configured with; nothing listens on it
+const testRelay = "ws://127.0.0.1:1/ws"
+
+// newTestApp creates an app for userID with its data in a temporary home
+// directory and an in-memory store, with configure applied to its config
+// before it starts. It is not connected to any relay.
+func newTestApp(t *testing.T, userID string, configure ...func(*config.Config)) *App {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+	cfg := config.Default()
+	cfg.User.ID = userID
+	cfg.User.DeviceID = userID + "-device"
+	cfg.Storage.Backend = config.StorageMemory
+	cfg.Network.RelayServers = []string{testRelay}
+	for _, f := range configure {
+		f(cfg)
+	}
+
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() { app.Close() })
+	return app
+}
+
+// sessionInitFor builds the session init a new user would send to start a
+// session with app
+func sessionInitFor(t *testing.T, app *App, from string) *network.Message {
+	t.Helper()
+
+	identity, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	ephemeral, err := crypto.GenerateEphemeralKey()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKey: %v", err)
+	}
+	ratchetKey, err := crypto.GenerateEphemeralKey()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKey: %v", err)
+	}
+
+	init := network.SessionInitPayload{
+		IdentityKey:  identity.SigningKey.PublicKey,
+		ExchangeKey:  identity.ExchangeKey.PublicKey,
+		EphemeralKey: ephemeral.PublicKey,
+		PreKeyID:     app.preKey.ID,
+		RatchetKey:   ratchetKey.PublicKey,
+		Ciphers:      cipherNumbers(app.CipherPreferences()),
+	}
+	init.Signature = identity.Sign(sessionInitSignedData(&init))
+
+	fields, err := network.EncodePayload(init)
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	return &network.Message{ID: "init-1", Type: network.MessageTypeSessionInit, From: from, To: app.config.User.ID, Payload: fields}
+}
+
+func TestSessionInitSignedDataCoversEveryField(t *testing.T) {
+	base := network.SessionInitPayload{
+		IdentityKey:  bytes.Repeat([]byte{1}, 32),
+		ExchangeKey:  bytes.Repeat([]byte{2}, 32),
+		EphemeralKey: bytes.Repeat([]byte{3}, 32),
+		PreKeyID:     1,
+		RatchetKey:   bytes.Repeat([]byte{4}, 32),
+		Ciphers:      []int{1, 2},
+	}
+	signed := sessionInitSignedData(&base)
+
+	changes := map[string]func(init *network.SessionInitPayload){
+		"identity key":  func(init *network.SessionInitPayload) { init.IdentityKey = bytes.Repeat([]byte{9}, 32) },
+		"exchange key":  func(init *network.SessionInitPayload) { init.ExchangeKey = bytes.Repeat([]byte{9}, 32) },
+		"ephemeral key": func(init *network.SessionInitPayload) { init.EphemeralKey = bytes.Repeat([]byte{9}, 32) },
+		"ratchet key":   func(init *network.SessionInitPayload) { init.RatchetKey = bytes.Repeat([]byte{9}, 32) },
+		"prekey ID":     func(init *network.SessionInitPayload) { init.PreKeyID = 2 },
+		"ciphers":       func(init *network.SessionInitPayload) { init.Ciphers = []int{2, 1} },
+		"fewer ciphers": func(init *network.SessionInitPayload) { init.Ciphers = []int{1} },
+		"key boundary": func(init *network.SessionInitPayload) {
+			// Moving a byte from one key to the next must still change the data
+			init.IdentityKey = bytes.Repeat([]byte{1}, 31)
+			init.ExchangeKey = append([]byte{1}, bytes.Repeat([]byte{2}, 32)...)
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			init := base
+			change(&init)
+			if bytes.Equal(sessionInitSignedData(&init), signed) {
+				t.Errorf("changing the %s leaves the signed data the same", name)
+			}
+		})
+	}
+}
+
+func TestReplayedSessionInitIsDropped(t *testing.T) {
+	bob := newTestApp(t, "bob")
+	init := sessionInitFor(t, bob, "carol")
+
+	if err := bob.handleSessionInit(init); err != nil {
+		t.Fatalf("handleSessionInit: %v", err)
+	}
+	if !bob.hasSession("carol") {
+		t.Fatal("no session with carol after her session init")
+	}
+
+	err := bob.handleSessionInit(init)
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("replayed session init: got %v, want an already used error", err)
+	}
+}
+
+func TestSessionInitWithSwappedKeyIsDropped(t *testing.T) {
+	bob := newTestApp(t, "bob")
+	init := sessionInitFor(t, bob, "carol")
+
+	// A relay swapping in its own exchange key breaks the signature
+	other, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	init.Payload["exchange_key"] = other.ExchangeKey.PublicKey
+	fields, _ := network.EncodePayload(init.Payload)
+	init.Payload = fields
+
+	if err := bob.handleSessionInit(init); err == nil {
+		t.Fatal("session init with a swapped exchange key was accepted")
+	}
+	if bob.hasSession("carol") {
+		t.Fatal("session started from a session init with a swapped key")
+	}
+}
+
+func TestHandshakeNegotiatesCipher(t *testing.T) {
+	withCipher := func(name string) func(*config.Config) {
+		return func(cfg *config.Config) { cfg.Security.Cipher = name }
+	}
+	tests := []struct {
+		name              string
+		initiator, second string
+		want              crypto.CipherID
+	}{
+		{"both prefer AES", "aes-256-gcm", "aes-256-gcm", crypto.CipherAES256GCM},
+		{"initiator prefers AES", "aes-256-gcm", "chacha20-poly1305", crypto.CipherAES256GCM},
+		{"initiator prefers ChaCha", "chacha20-poly1305", "aes-256-gcm", crypto.CipherChaCha20Poly1305},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			alice := newTestApp(t, "alice", withCipher(tt.initiator))
+			bob := newTestApp(t, "bob", withCipher(tt.second))
+			connectApp(t, alice, server)
+			connectApp(t, bob, server)
+			for _, pair := range [][2]*App{{alice, bob}, {bob, alice}} {
+				if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+					t.Fatalf("AddContact: %v", err)
+				}
+			}
+			exchange(t, alice, bob, "hello bob")
+			exchange(t, bob, alice, "hello alice")
+
+			for _, end := range []struct {
+				app  *App
+				peer string
+			}{{alice, "bob"}, {bob, "alice"}} {
+				end.app.sessionsMux.Lock()
+				got := end.app.sessions[end.peer].Cipher
+				end.app.sessionsMux.Unlock()
+				if got != tt.want {
+					t.Errorf("%s's session uses cipher %d, want %d", end.app.config.User.ID, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownCipherIsRefused(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := config.Default()
+	cfg.User.ID = "alice"
+	cfg.Storage.Backend = config.StorageMemory
+	cfg.Network.RelayServers = []string{testRelay}
+	cfg.Security.Cipher = "rot13"
+
+	if app, err := NewApp(cfg); err == nil {
+		app.Close()
+		t.Fatal("NewApp accepted an unknown cipher")
+	}
+}
+
+// sessionStates records the session states app reports for userID
+func sessionStates(app *App, userID string) func() string {
+	var mu sync.Mutex
+	var states []string
+	app.AddSessionStateHandler(func(user string, state models.EncryptionState) {
+		if user != userID {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, string(state))
+	})
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(states, ",")
+	}
+}
+
+// messageStatuses returns the status of each message app holds with
+// otherUserID, in order
+func messageStatuses(t *testing.T, app *App, otherUserID string) string {
+	t.Helper()
+
+	messages, err := app.GetMessages(otherUserID, 100)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	var statuses []string
+	for _, msg := range messages {
+		statuses = append(statuses, msg.Content+"="+string(msg.Status))
+	}
+	return strings.Join(statuses, ",")
+}
+
+func TestMessagesQueuedBeforeHandshakeAreSentOnceSessionIsUp(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	connectApp(t, alice, server)
+	states := sessionStates(alice, "bob")
+
+	// bob is offline, so the key exchange cannot finish yet
+	for _, content := range []string{"one", "two", "three"} {
+		if err := alice.SendMessage("bob", content); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	if state := alice.EncryptionState("bob"); state != models.EncryptionPending {
+		t.Errorf("state with bob = %s, want pending", state)
+	}
+	if got := messageStatuses(t, alice, "bob"); got != "one=pending,two=pending,three=pending" {
+		t.Errorf("messages = %s, want all pending", got)
+	}
+
+	connectApp(t, bob, server)
+	waitFor(t, "bob to receive the queued messages", func() bool {
+		return hasMessage(bob, "alice", "one") && hasMessage(bob, "alice", "two") && hasMessage(bob, "alice", "three")
+	})
+	received, err := bob.GetMessages("alice", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := contents(received); got != "one,two,three" {
+		t.Errorf("bob received %s, want them in the order sent", got)
+	}
+	for _, msg := range received {
+		if !msg.Encrypted {
+			t.Errorf("%s arrived unencrypted", msg.Content)
+		}
+	}
+	if state := alice.EncryptionState("bob"); state != models.EncryptionActive {
+		t.Errorf("state with bob = %s, want active", state)
+	}
+	if got := states(); got != "pending,active" {
+		t.Errorf("session states = %s, want pending,active", got)
+	}
+	waitFor(t, "the outbox to drain", func() bool {
+		queued, _ := alice.Outbox()
+		return len(queued) == 0
+	})
+}
+
+func TestHandshakeGivesUpOnPeerThatNeverAnswers(t *testing.T) {
+	server := newTestServer(t)
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Network.HandshakeTimeout = 200 * time.Millisecond
+		cfg.Network.SessionQueueLimit = 2
+	})
+	connectApp(t, alice, server)
+	states := sessionStates(alice, "bob")
+	var mu sync.Mutex
+	var drops []MessageDrop
+	alice.AddMessageDropHandler(func(drop MessageDrop) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops = append(drops, drop)
+	})
+	takeDrops := func() []MessageDrop {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := drops
+		drops = nil
+		return taken
+	}
+
+	// Past the queue limit the oldest message fails
+	for _, content := range []string{"one", "two", "three"} {
+		if err := alice.SendMessage("bob", content); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	if got := takeDrops(); len(got) != 1 || got[0].Count != 1 || !errors.Is(got[0].Reason, ErrSessionQueueFull) {
+		t.Errorf("drops = %+v, want one message dropped for the full queue", got)
+	}
+
+	// bob never comes online
+	waitFor(t, "the key exchange to time out", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(drops) > 0
+	})
+	if got := takeDrops(); len(got) != 1 || got[0].Count != 2 || !errors.Is(got[0].Reason, ErrHandshakeTimeout) {
+		t.Errorf("drops = %+v, want the two queued messages failed by the timeout", got)
+	}
+	if got := messageStatuses(t, alice, "bob"); got != "one=failed,two=failed,three=failed" {
+		t.Errorf("messages = %s, want all failed", got)
+	}
+	if got := states(); got != "pending,none" {
+		t.Errorf("session states = %s, want pending,none", got)
+	}
+	if queued, _ := alice.Outbox(); len(queued) != 0 {
+		t.Errorf("outbox holds %s after the failure", contents(queued))
+	}
+}
+
+func TestHandshakeQueueEvictsExpiredThenOldest(t *testing.T) {
+	now := time.Now()
+	queued := func(ages ...time.Duration) *handshake {
+		hs := &handshake{}
+		for i, age := range ages {
+			hs.queued = append(hs.queued, &models.Message{ID: fmt.Sprintf("m%d", i+1), Timestamp: now.Add(-age)})
+		}
+		return hs
+	}
+	ids := func(msgs []*models.Message) string {
+		var got []string
+		for _, msg := range msgs {
+			got = append(got, msg.ID)
+		}
+		return strings.Join(got, ",")
+	}
+	tests := []struct {
+		name         string
+		ages         []time.Duration
+		limit        int
+		ttl          time.Duration
+		wantExpired  string
+		wantOverflow string
+		wantKept     string
+	}{
+		{"within bounds", []time.Duration{3, 2, 1}, 3, time.Hour, "", "", "m1,m2,m3"},
+		{"over the limit", []time.Duration{3, 2, 1}, 2, time.Hour, "", "m1", "m2,m3"},
+		{"expired", []time.Duration{2 * time.Hour, time.Minute, 0}, 3, time.Hour, "m1", "", "m2,m3"},
+		{"expired before counting", []time.Duration{2 * time.Hour, time.Minute, 0}, 2, time.Hour, "m1", "", "m2,m3"},
+		{"both", []time.Duration{2 * time.Hour, 3, 2, 1}, 2, time.Hour, "m1", "m2", "m3,m4"},
+		{"no TTL", []time.Duration{48 * time.Hour, 0}, 2, 0, "", "", "m1,m2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := queued(tt.ages...)
+			expired, overflow := hs.evict(tt.limit, tt.ttl, now)
+			if ids(expired) != tt.wantExpired || ids(overflow) != tt.wantOverflow || ids(hs.queued) != tt.wantKept {
+				t.Errorf("evict = expired %q, overflow %q, kept %q; want %q, %q, %q",
+					ids(expired), ids(overflow), ids(hs.queued), tt.wantExpired, tt.wantOverflow, tt.wantKept)
+			}
+		})
+	}
+
+	if got := queued(time.Minute, 2*time.Minute).nextExpiry(time.Hour); !got.Equal(now.Add(-2 * time.Minute).Add(time.Hour)) {
+		t.Errorf("nextExpiry = %v, want when the oldest message expires", got)
+	}
+	if got := queued(time.Minute).nextExpiry(0); !got.IsZero() {
+		t.Errorf("nextExpiry without a TTL = %v, want none", got)
+	}
+}
+
+func TestMessagesWaitingPastTheTTLFail(t *testing.T) {
+	server := newTestServer(t)
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Network.SessionQueueTTL = 100 * time.Millisecond
+	})
+	connectApp(t, alice, server)
+	var mu sync.Mutex
+	var drops []MessageDrop
+	alice.AddMessageDropHandler(func(drop MessageDrop) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops = append(drops, drop)
+	})
+	dropped := func() []MessageDrop {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]MessageDrop(nil), drops...)
+	}
+
+	// bob is offline; a message sent after the first outlived the TTL
+	// fails it
+	if err := alice.SendMessage("bob", "one"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := alice.SendMessage("bob", "two"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if got := messageStatuses(t, alice, "bob"); got != "one=failed,two=pending" {
+		t.Errorf("messages = %s, want one failed and two pending", got)
+	}
+	if got := dropped(); len(got) != 1 || got[0].UserID != "bob" || got[0].Count != 1 || !errors.Is(got[0].Reason, ErrSessionQueueExpired) {
+		t.Errorf("drops = %+v, want one expired message to bob", got)
+	}
+
+	// The last one expires on its own while the key exchange retries
+	waitFor(t, "the second message to expire", func() bool { return len(dropped()) == 2 })
+	if got := messageStatuses(t, alice, "bob"); got != "one=failed,two=failed" {
+		t.Errorf("messages = %s, want both failed", got)
+	}
+	if queued, _ := alice.Outbox(); len(queued) != 0 {
+		t.Errorf("outbox holds %s after the messages expired", contents(queued))
+	}
+}
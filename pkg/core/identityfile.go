@@ -0,0 +1,178 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// identityFileFormat and identityFileVersion identify identity files. An
+// identity file is a JSON document holding a user's public keys:
+//
+//	{
+//	  "format": "securechat-identity",
+//	  "version": 1,
+//	  "user_id": "alice",
+//	  "display_name": "Alice",
+//	  "signing_key": "<base64 Ed25519 public key>",
+//	  "exchange_key": "<base64 X25519 public key>",
+//	  "fingerprint": "<hex SHA-256 of both keys>",
+//	  "signature": "<base64 Ed25519 signature>"
+//	}
+//
+// The signature is made with the signing key over the format, version, user
+// ID and both keys, so the file cannot be altered to pair the keys with
+// another user ID. It proves the keys belong together, not who sent the
+// file; contacts added from one are not verified.
+const (
+	identityFileFormat  = "securechat-identity"
+	identityFileVersion = 1
+)
+
+// errInvalidIdentityFile is wrapped by errors about malformed identity files
+var errInvalidIdentityFile = errors.New("invalid identity file")
+
+// identityFile is the content of an identity file
+type identityFile struct {
+	Format      string `json:"format"`
+	Version     int    `json:"version"`
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name,omitempty"`
+	SigningKey  []byte `json:"signing_key"`
+	ExchangeKey []byte `json:"exchange_key"`
+	Fingerprint string `json:"fingerprint"`
+	Signature   []byte `json:"signature,omitempty"`
+}
+
+// signedData returns what the signature of an identity file covers
+func (f *identityFile) signedData() []byte {
+	data := []byte(f.Format + "\n" + strconv.Itoa(f.Version) + "\n" + f.UserID + "\n")
+	data = append(data, f.SigningKey...)
+	return append(data, f.ExchangeKey...)
+}
+
+// check rejects an identity file that is not well-formed, whose fingerprint
+// does not belong to its keys, or whose signature does not verify
+func (f *identityFile) check() error {
+	if f.Format != identityFileFormat {
+		return fmt.Errorf("%w: not a SecureChat identity file", errInvalidIdentityFile)
+	}
+	if f.Version != identityFileVersion {
+		return fmt.Errorf("%w: unsupported version %d", errInvalidIdentityFile, f.Version)
+	}
+	userID, err := models.ParseUserID(f.UserID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidIdentityFile, err)
+	}
+	if userID != f.UserID {
+		return fmt.Errorf("%w: user ID %q is not in canonical form", errInvalidIdentityFile, f.UserID)
+	}
+	if len(f.SigningKey) != ed25519.PublicKeySize || len(f.ExchangeKey) != 32 {
+		return fmt.Errorf("%w: keys have the wrong length", errInvalidIdentityFile)
+	}
+	if f.Fingerprint != crypto.Fingerprint(f.SigningKey, f.ExchangeKey) {
+		return fmt.Errorf("%w: fingerprint does not match the keys", errInvalidIdentityFile)
+	}
+	if len(f.Signature) > 0 && !crypto.VerifySignature(f.SigningKey, f.signedData(), f.Signature) {
+		return fmt.Errorf("%w: signature does not match the signing key", errInvalidIdentityFile)
+	}
+	return nil
+}
+
+// WriteIdentityFile writes the user's public keys and fingerprint to an
+// identity file, for contacts to add with AddContactFromIdentityFile. No
+// private key is written.
+func (a *App) WriteIdentityFile(path string) error {
+	if a.identity == nil {
+		return errors.New("no identity to write")
+	}
+
+	file := &identityFile{
+		Format:      identityFileFormat,
+		Version:     identityFileVersion,
+		UserID:      a.config.User.ID,
+		DisplayName: a.config.User.DisplayName,
+		SigningKey:  a.identity.SigningKey.PublicKey,
+		ExchangeKey: a.identity.ExchangeKey.PublicKey,
+		Fingerprint: a.identity.CanonicalFingerprint(),
+	}
+	file.Signature = a.identity.Sign(file.signedData())
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write identity file: %w", err)
+	}
+	return nil
+}
+
+// readIdentityFile reads and checks an identity file
+func readIdentityFile(path string) (*identityFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
+	}
+
+	var file identityFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, errInvalidIdentityFile, err)
+	}
+	if err := file.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// AddContactFromIdentityFile adds the user an identity file describes as a
+// contact with its identity key. For an existing contact the name is kept,
+// and a key other than the one on record goes through the same acceptance
+// as a key presented in a key exchange.
+func (a *App) AddContactFromIdentityFile(path string) error {
+	file, err := readIdentityFile(path)
+	if err != nil {
+		return err
+	}
+	if file.UserID == a.config.User.ID {
+		return fmt.Errorf("%s is your own identity file", path)
+	}
+
+	existing, exists := a.GetContact(file.UserID)
+	if exists && existing.Unknown {
+		name := file.DisplayName
+		if name == "" {
+			name = existing.DisplayName
+		}
+		if err := a.AddContact(file.UserID, name); err != nil {
+			return err
+		}
+	}
+	if exists && existing.Fingerprint != "" {
+		if err := a.UpdateContactKey(file.UserID, file.SigningKey, file.ExchangeKey); err != nil {
+			return err
+		}
+	} else {
+		err := a.importContact(&models.Contact{
+			UserID:      file.UserID,
+			DisplayName: file.DisplayName,
+			PublicKey:   file.SigningKey,
+			ExchangeKey: file.ExchangeKey,
+			Fingerprint: file.Fingerprint,
+		})
+		if err != nil {
+			return err
+		}
+		a.syncPresenceSubscriptions()
+	}
+
+	log.Printf("Added contact %s from identity file %s", file.UserID, path)
+	return nil
+}
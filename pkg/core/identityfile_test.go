@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func TestIdentityFileRoundTrips(t *testing.T) {
+	alice := newTestApp(t, "alice", func(cfg *config.Config) { cfg.User.DisplayName = "Alice Owusu" })
+	bob := newTestApp(t, "bob")
+	path := filepath.Join(t.TempDir(), "alice.identity")
+	if err := alice.WriteIdentityFile(path); err != nil {
+		t.Fatalf("WriteIdentityFile: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(alice.identity.SigningKey.PrivateKey))) ||
+		bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(alice.identity.ExchangeKey.PrivateKey))) {
+		t.Fatal("the identity file holds a private key")
+	}
+
+	if err := bob.AddContactFromIdentityFile(path); err != nil {
+		t.Fatalf("AddContactFromIdentityFile: %v", err)
+	}
+	contact, ok := bob.GetContact("alice")
+	if !ok {
+		t.Fatal("alice was not added")
+	}
+	if contact.DisplayName != "Alice Owusu" || contact.Fingerprint != alice.identity.CanonicalFingerprint() {
+		t.Errorf("added %q with fingerprint %s, want %q with %s", contact.DisplayName, contact.Fingerprint, "Alice Owusu", alice.identity.CanonicalFingerprint())
+	}
+	if !bytes.Equal(contact.PublicKey, alice.identity.SigningKey.PublicKey) || !bytes.Equal(contact.ExchangeKey, alice.identity.ExchangeKey.PublicKey) {
+		t.Error("the keys did not round-trip")
+	}
+	if contact.Verified {
+		t.Error("a contact added from a file is marked verified")
+	}
+
+	// Reading the same file again changes nothing
+	if err := bob.AddContactFromIdentityFile(path); err != nil {
+		t.Fatalf("AddContactFromIdentityFile again: %v", err)
+	}
+	if again, _ := bob.GetContact("alice"); again.KeyChanged || again.PendingFingerprint != "" {
+		t.Error("reading the same key again was taken as a key change")
+	}
+	if err := alice.AddContactFromIdentityFile(path); err == nil {
+		t.Error("an identity file was added to its own user's contacts")
+	}
+}
+
+func TestMalformedIdentityFilesAreRejected(t *testing.T) {
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "alice.identity")
+	if err := alice.WriteIdentityFile(path); err != nil {
+		t.Fatalf("WriteIdentityFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// edited returns the file with one field changed
+	edited := func(field string, value interface{}) string {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if value == nil {
+			delete(fields, field)
+		} else {
+			fields[field] = value
+		}
+		out, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return string(out)
+	}
+	otherKey := base64.StdEncoding.EncodeToString(keyedContact(t, "alice").PublicKey)
+	tests := []struct {
+		name    string
+		content string
+		want    string // In the error
+	}{
+		{"not JSON", "alice's keys", "invalid identity file"},
+		{"truncated", string(data[:len(data)/2]), "invalid identity file"},
+		{"another format", edited("format", "pgp-key"), "not a SecureChat identity file"},
+		{"newer version", edited("version", 2), "unsupported version 2"},
+		{"invalid user ID", edited("user_id", "al ice"), "invalid identity file"},
+		{"user ID not canonical", edited("user_id", "Alice"), "not in canonical form"},
+		{"short key", edited("signing_key", "c2hvcnQ="), "wrong length"},
+		{"fingerprint of other keys", edited("signing_key", otherKey), "fingerprint does not match"},
+		{"keys moved to another user", edited("user_id", "mallory"), "signature does not match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-"))
+			if err := os.WriteFile(bad, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			err := bob.AddContactFromIdentityFile(bad)
+			if err == nil || !errors.Is(err, errInvalidIdentityFile) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("AddContactFromIdentityFile = %v, want an invalid file error about %q", err, tt.want)
+			}
+			if contacts := bob.GetContacts(); len(contacts) != 0 {
+				t.Errorf("%d contacts added from a malformed file", len(contacts))
+			}
+		})
+	}
+
+	if err := bob.AddContactFromIdentityFile(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reading a missing file = %v, want it not to exist", err)
+	}
+	if err := alice.WriteIdentityFile(filepath.Join(dir, "missing", "alice.identity")); err == nil {
+		t.Error("writing into a missing directory succeeded")
+	}
+
+	// The signature is optional, but checked when present
+	unsigned := filepath.Join(dir, "unsigned")
+	if err := os.WriteFile(unsigned, []byte(edited("signature", nil)), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := bob.AddContactFromIdentityFile(unsigned); err != nil {
+		t.Errorf("AddContactFromIdentityFile(unsigned) = %v, want it added", err)
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// Liveness tells whether the app is connected and when it last heard from
+// a relay
+type Liveness struct {
+	Connected    bool
+	LastReceived time.Time // Zero if nothing was received yet
+}
+
+// Liveness returns the current connection state and the time the last
+// message of any kind arrived. It is cheap enough to poll.
+func (a *App) Liveness() Liveness {
+	liveness := Liveness{Connected: a.IsConnected()}
+	if nanos := a.lastReceived.Load(); nanos != 0 {
+		liveness.LastReceived = time.Unix(0, nanos)
+	}
+	return liveness
+}
+
+// markReceived records that a message arrived
+func (a *App) markReceived() {
+	a.lastReceived.Store(time.Now().UnixNano())
+}
+
+// runHeartbeat logs the liveness of the app at the configured interval
+// until the app is closed
+func (a *App) runHeartbeat(interval time.Duration) {
+	defer a.background.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			a.logHeartbeat()
+		}
+	}
+}
+
+// logHeartbeat logs a single liveness line
+func (a *App) logHeartbeat() {
+	liveness := a.Liveness()
+
+	state := "disconnected"
+	if liveness.Connected {
+		state = "connected"
+	}
+	if liveness.LastReceived.IsZero() {
+		log.Printf("Heartbeat: %s, nothing received yet", state)
+		return
+	}
+	log.Printf("Heartbeat: %s, last message received %s ago",
+		state, time.Since(liveness.LastReceived).Round(time.Second))
+}
+
+// ConnectionStateHandler is called whenever a relay connects, drops or is
+// being reconnected, with the state ConnectionState returns
+type ConnectionStateHandler func(state network.ConnectionState)
+
+// AddConnectionStateHandler adds a connection state handler
+func (a *App) AddConnectionStateHandler(handler ConnectionStateHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+	a.connectionStateHandlers = append(a.connectionStateHandlers, handler)
+}
+
+// ConnectionState returns the state of the connection messages are sent on
+// by default, or of the connection being reconnected while none is up
+func (a *App) ConnectionState() network.ConnectionState {
+	return a.connections.State()
+}
+
+// notifyConnectionStateHandlers passes the connection state to the
+// connection state handlers
+func (a *App) notifyConnectionStateHandlers() {
+	a.handlersMux.RLock()
+	handlers := a.connectionStateHandlers
+	a.handlersMux.RUnlock()
+
+	state := a.ConnectionState()
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+// ConnectionQualityHandler is called whenever the quality of the relay
+// connection changes
+type ConnectionQualityHandler func(quality network.ConnectionQuality)
+
+// AddConnectionQualityHandler adds a connection quality handler
+func (a *App) AddConnectionQualityHandler(handler ConnectionQualityHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+	a.connectionQualityHandlers = append(a.connectionQualityHandlers, handler)
+}
+
+// ConnectionQuality returns the quality of the connection messages are sent
+// on by default, graded by the round-trip time of its pings
+func (a *App) ConnectionQuality() network.ConnectionQuality {
+	return a.connections.Quality()
+}
+
+// notifyConnectionQualityHandlers passes the current connection quality to
+// the connection quality handlers
+func (a *App) notifyConnectionQualityHandlers() {
+	a.handlersMux.RLock()
+	handlers := a.connectionQualityHandlers
+	a.handlersMux.RUnlock()
+
+	quality := a.ConnectionQuality()
+	for _, handler := range handlers {
+		handler(quality)
+	}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+func TestLivenessFollowsConnection(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	var mu sync.Mutex
+	var statuses []network.ConnectionStatus
+	alice.AddConnectionStateHandler(func(state network.ConnectionState) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses = append(statuses, state.Status)
+	})
+
+	if liveness := alice.Liveness(); liveness.Connected || !liveness.LastReceived.IsZero() {
+		t.Fatalf("liveness before connecting = %+v", liveness)
+	}
+
+	client := connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	if !alice.Liveness().Connected {
+		t.Error("not live after connecting")
+	}
+	if err := bob.AddContact("alice", ""); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	before := time.Now()
+	exchange(t, bob, alice, "ping")
+	received := alice.Liveness().LastReceived
+	if received.Before(before) || time.Since(received) > time.Minute {
+		t.Errorf("last received %v, want the time of bob's message", received)
+	}
+
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	waitFor(t, "the disconnect", func() bool { return !alice.Liveness().Connected })
+	if got := alice.Liveness().LastReceived; !got.Equal(received) {
+		t.Errorf("last received after disconnecting = %v, want %v kept", got, received)
+	}
+
+	waitFor(t, "the state handlers", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(statuses) > 0 && statuses[len(statuses)-1] == network.StatusDisconnected
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if statuses[0] != network.StatusConnected {
+		t.Errorf("states = %v, want connected first", statuses)
+	}
+}
+
+func TestHeartbeatLogsLiveness(t *testing.T) {
+	logs := captureLog(t)
+	newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Network.HeartbeatInterval = 10 * time.Millisecond
+	})
+
+	waitFor(t, "a heartbeat", func() bool {
+		return strings.Contains(logs.String(), "Heartbeat: disconnected, nothing received yet")
+	})
+}
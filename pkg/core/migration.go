@@ -0,0 +1,166 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// deviceBundleVersion is the format version of device migration bundles
+const deviceBundleVersion = 1
+
+// deviceBundle holds everything a new device needs to take over an account
+type deviceBundle struct {
+	Version       int                              `json:"version"`
+	UserID        string                           `json:"user_id"`
+	CreatedAt     time.Time                        `json:"created_at"`
+	Identity      *models.Identity                 `json:"identity"`
+	Contacts      []*models.Contact                `json:"contacts"`
+	Conversations []*models.Conversation           `json:"conversations,omitempty"`
+	Sessions      map[string]*crypto.DoubleRatchet `json:"sessions,omitempty"`
+	KeyedAt       map[string]time.Time             `json:"keyed_at,omitempty"`
+	Messages      []*models.Message                `json:"messages,omitempty"`
+}
+
+// ExportDeviceBundle exports the identity keys, contacts with their
+// verification state, conversation settings and ratchet sessions, encrypted
+// with the passphrase. Unlike a backup, the sessions are exported live: once
+// the bundle is imported on the new device this device should stop sending,
+// or the two copies of each session will diverge.
+func (a *App) ExportDeviceBundle(passphrase string) ([]byte, error) {
+	return a.exportDeviceBundle(passphrase, false)
+}
+
+// ExportDeviceBundleWithHistory is ExportDeviceBundle including the message
+// history of every contact
+func (a *App) ExportDeviceBundleWithHistory(passphrase string) ([]byte, error) {
+	return a.exportDeviceBundle(passphrase, true)
+}
+
+func (a *App) exportDeviceBundle(passphrase string, withHistory bool) ([]byte, error) {
+	if a.identity == nil || len(a.identity.SigningKey.PrivateKey) == 0 {
+		return nil, fmt.Errorf("identity keys are not available to export; identities created by older versions cannot be migrated")
+	}
+
+	sessions, err := a.copySessions()
+	if err != nil {
+		return nil, err
+	}
+	defer wipeSessions(sessions)
+
+	bundle := deviceBundle{
+		Version:   deviceBundleVersion,
+		UserID:    a.config.User.ID,
+		CreatedAt: time.Now(),
+		Identity:  identityToModel(a.config.User.ID, a.identity, a.preKey),
+		Contacts:  a.GetContacts(),
+		Sessions:  sessions,
+		KeyedAt:   make(map[string]time.Time, len(sessions)),
+	}
+	for remoteUserID := range sessions {
+		bundle.KeyedAt[remoteUserID], _ = a.SessionKeyedAt(remoteUserID)
+	}
+
+	conversations, err := a.storage.GetAllConversations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+	for _, conversation := range conversations {
+		bundle.Conversations = append(bundle.Conversations, conversation)
+	}
+
+	if withHistory {
+		for _, contact := range bundle.Contacts {
+			chatID := a.getChatID(a.config.User.ID, contact.UserID)
+			messages, err := a.storage.GetMessages(chatID, math.MaxInt, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read history with %s: %w", contact.UserID, err)
+			}
+			bundle.Messages = append(bundle.Messages, messages...)
+		}
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode device bundle: %w", err)
+	}
+	defer crypto.Zeroize(data)
+
+	return crypto.SealWithPassphrase(data, passphrase)
+}
+
+// ImportDeviceBundle restores a bundle exported on another device of the
+// same user. The imported identity replaces the local one, and imported
+// contacts, sessions and messages replace local ones with the same IDs.
+func (a *App) ImportDeviceBundle(data []byte, passphrase string) error {
+	plaintext, err := crypto.OpenWithPassphrase(data, passphrase)
+	if err != nil {
+		return err
+	}
+	defer crypto.Zeroize(plaintext)
+
+	var bundle deviceBundle
+	if err := json.Unmarshal(plaintext, &bundle); err != nil {
+		return fmt.Errorf("failed to decode device bundle: %w", err)
+	}
+	if bundle.Version != deviceBundleVersion {
+		return fmt.Errorf("unsupported device bundle version %d", bundle.Version)
+	}
+	if bundle.UserID != a.config.User.ID {
+		return fmt.Errorf("device bundle belongs to %s, not %s", bundle.UserID, a.config.User.ID)
+	}
+	if bundle.Identity == nil || len(bundle.Identity.SigningPrivateKey) == 0 {
+		return fmt.Errorf("device bundle has no identity keys")
+	}
+
+	if err := a.storage.SaveIdentity(bundle.Identity); err != nil {
+		return fmt.Errorf("failed to save identity: %w", err)
+	}
+	if a.identity != nil {
+		a.identity.SigningKey.Wipe()
+		a.identity.ExchangeKey.Wipe()
+	}
+	a.identity = identityFromModel(bundle.Identity)
+	a.preKey = preKeyFromModel(bundle.Identity)
+	if err := a.ensurePreKey(); err != nil {
+		return err
+	}
+	if err := a.initSearchIndex(); err != nil {
+		return err
+	}
+
+	for _, contact := range bundle.Contacts {
+		if err := a.saveContact(contact); err != nil {
+			return err
+		}
+	}
+	a.syncPresenceSubscriptions()
+
+	for _, conversation := range bundle.Conversations {
+		if err := a.storage.SaveConversation(conversation); err != nil {
+			return fmt.Errorf("failed to save conversation: %w", err)
+		}
+	}
+
+	for remoteUserID, ratchet := range bundle.Sessions {
+		if err := a.restoreSession(remoteUserID, ratchet, bundle.KeyedAt[remoteUserID]); err != nil {
+			return err
+		}
+	}
+
+	for _, msg := range bundle.Messages {
+		if err := a.storage.SaveMessage(msg); err != nil {
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+		a.sequences.Observe(msg.ChatID, msg.Sequence)
+	}
+
+	log.Printf("Imported device bundle from %s: %d contacts, %d sessions, %d messages",
+		bundle.CreatedAt.Format(time.RFC3339), len(bundle.Contacts), len(bundle.Sessions), len(bundle.Messages))
+	return nil
+}
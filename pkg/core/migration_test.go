@@ -0,0 +1,86 @@
+package core
+
+import (
+	"testing"
+)
+
+// hasMessage reports whether app stored a message with content in its chat
+// with otherUserID
+func hasMessage(app *App, otherUserID, content string) bool {
+	messages, err := app.GetMessages(otherUserID, 100)
+	if err != nil {
+		return false
+	}
+	for _, msg := range messages {
+		if msg.Content == content {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange sends content from one app to another and waits for it to arrive
+func exchange(t *testing.T, from, to *App, content string) {
+	t.Helper()
+
+	if err := from.SendMessage(to.config.User.ID, content); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	waitFor(t, to.config.User.ID+" to receive "+content, func() bool {
+		return hasMessage(to, from.config.User.ID, content)
+	})
+}
+
+func TestDeviceMigrationContinuesSessions(t *testing.T) {
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	for _, pair := range [][2]*App{{alice, bob}, {bob, alice}} {
+		if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	exchange(t, alice, bob, "hello from the old laptop")
+	exchange(t, bob, alice, "hi alice")
+	if err := alice.VerifyContact("bob"); err != nil {
+		t.Fatalf("VerifyContact: %v", err)
+	}
+
+	keyedAt, _ := bob.SessionKeyedAt("alice")
+
+	bundle, err := alice.ExportDeviceBundleWithHistory("correct horse")
+	if err != nil {
+		t.Fatalf("ExportDeviceBundleWithHistory: %v", err)
+	}
+	alice.connections.Remove("memory")
+
+	newAlice := newTestApp(t, "alice")
+	if err := newAlice.ImportDeviceBundle(bundle, "wrong passphrase"); err == nil {
+		t.Fatal("ImportDeviceBundle accepted the wrong passphrase")
+	}
+	if err := newTestApp(t, "carol").ImportDeviceBundle(bundle, "correct horse"); err == nil {
+		t.Fatal("ImportDeviceBundle accepted another user's bundle")
+	}
+	if err := newAlice.ImportDeviceBundle(bundle, "correct horse"); err != nil {
+		t.Fatalf("ImportDeviceBundle: %v", err)
+	}
+
+	if newAlice.GetFingerprint() != alice.GetFingerprint() {
+		t.Error("the identity changed in the migration")
+	}
+	if contact, ok := newAlice.GetContact("bob"); !ok || !contact.Verified {
+		t.Errorf("bob after the migration = %+v, want a verified contact", contact)
+	}
+	if !hasMessage(newAlice, "bob", "hi alice") {
+		t.Error("history was not imported")
+	}
+
+	// The ratchet sessions carry on without a new handshake
+	connectApp(t, newAlice, server)
+	exchange(t, newAlice, bob, "hello from the new laptop")
+	exchange(t, bob, newAlice, "welcome back")
+	if now, _ := bob.SessionKeyedAt("alice"); !now.Equal(keyedAt) {
+		t.Errorf("bob's session was keyed again at %v, want the one from %v kept", now, keyedAt)
+	}
+}
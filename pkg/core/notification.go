@@ -0,0 +1,188 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// Notification announces new messages from a contact. Messages that arrive
+// within the notification window of each other are grouped into one. It
+// never carries message content, which would be shown outside the app.
+type Notification struct {
+	From        string // User ID of the contact
+	DisplayName string
+	Count       int // Messages since the last notification
+}
+
+// Title returns the headline of the notification, such as
+// "3 new messages from Bob"
+func (n Notification) Title() string {
+	name := n.DisplayName
+	if name == "" {
+		name = n.From
+	}
+	if n.Count == 1 {
+		return "New message from " + name
+	}
+	return fmt.Sprintf("%d new messages from %s", n.Count, name)
+}
+
+// NotificationHandler is called for every notification
+type NotificationHandler func(Notification)
+
+// AddNotificationHandler adds a notification handler
+func (a *App) AddNotificationHandler(handler NotificationHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.notificationHandlers = append(a.notificationHandlers, handler)
+}
+
+// notifications gathers received messages into notifications
+type notifications struct {
+	mu         sync.Mutex
+	pending    map[string]*Notification // By contact user ID
+	notified   *recentIDs               // Messages already counted
+	activeChat string
+	lastSent   time.Time
+}
+
+func newNotifications() *notifications {
+	return &notifications{
+		pending:  make(map[string]*Notification),
+		notified: newRecentIDs(recentMessageIDs),
+	}
+}
+
+// acknowledge marks a chat as open, or no chat if userID is empty. Messages
+// of the open chat are read as they arrive, so nothing pending for it is
+// shown and none of its messages notify until another chat is opened.
+func (n *notifications) acknowledge(userID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.activeChat = userID
+	delete(n.pending, userID)
+}
+
+// notifyNewMessage counts a received message towards the next notification
+// from its sender. Nothing is counted while notifications are off or do not
+// disturb is on, for muted or blocked contacts, for the open chat, or for
+// messages that were counted already.
+func (a *App) notifyNewMessage(msg *models.Message) {
+	if msg.Type != models.MessageTypeChat || msg.IsFromUser(a.config.User.ID) || !a.notificationsWanted() {
+		return
+	}
+	from := msg.From
+	displayName := from
+	if contact, exists := a.GetContact(from); exists {
+		if !contact.State().Notifies() {
+			return
+		}
+		displayName = contact.DisplayName
+	}
+
+	n := a.notifications
+	n.mu.Lock()
+	if from == n.activeChat || n.notified.Seen(msg.ID) {
+		n.mu.Unlock()
+		return
+	}
+	n.notified.Add(msg.ID)
+
+	pending, exists := n.pending[from]
+	if !exists {
+		pending = &Notification{From: from}
+		n.pending[from] = pending
+	}
+	pending.DisplayName = displayName
+	pending.Count++
+	delay := a.notificationDelay(time.Now())
+	n.mu.Unlock()
+
+	a.notificationFlushes.scheduleIfIdle(from, delay, func() {
+		a.flushNotification(from)
+	})
+}
+
+// notificationsWanted reports whether notifications are on and do not
+// disturb is off
+func (a *App) notificationsWanted() bool {
+	return a.config.UI.Notifications && !a.config.UI.DoNotDisturb
+}
+
+// notificationDelay returns how long to wait before showing a notification
+// gathered from now on: the notification window, or longer if the previous
+// notification was shown less than the notification interval ago. The
+// caller holds the notifications lock.
+func (a *App) notificationDelay(now time.Time) time.Duration {
+	delay := a.config.UI.NotificationWindow
+	if wait := a.notifications.lastSent.Add(a.config.UI.NotificationInterval).Sub(now); wait > delay {
+		delay = wait
+	}
+	return delay
+}
+
+// flushNotification shows the notification gathered for a contact. If
+// another notification was shown too recently, it waits longer and keeps
+// gathering messages.
+func (a *App) flushNotification(from string) {
+	a.notificationFlushes.cancel(from)
+
+	n := a.notifications
+	n.mu.Lock()
+	pending, exists := n.pending[from]
+	if !exists {
+		n.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if wait := n.lastSent.Add(a.config.UI.NotificationInterval).Sub(now); wait > 0 {
+		n.mu.Unlock()
+		a.notificationFlushes.scheduleIfIdle(from, wait, func() {
+			a.flushNotification(from)
+		})
+		return
+	}
+	delete(n.pending, from)
+	notification := *pending
+
+	// The contact may have been muted, or do not disturb turned on, while
+	// the messages were gathered
+	if !a.notificationsWanted() {
+		n.mu.Unlock()
+		return
+	}
+	if contact, exists := a.GetContact(from); exists && !contact.State().Notifies() {
+		n.mu.Unlock()
+		return
+	}
+	n.lastSent = now
+	n.mu.Unlock()
+
+	a.handlersMux.RLock()
+	handlers := make([]NotificationHandler, len(a.notificationHandlers))
+	copy(handlers, a.notificationHandlers)
+	a.handlersMux.RUnlock()
+
+	for _, handler := range handlers {
+		callNotificationHandler(handler, notification)
+	}
+}
+
+// callNotificationHandler runs one handler, isolating its panics from
+// message processing
+func callNotificationHandler(handler NotificationHandler, notification Notification) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Notification handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	handler(notification)
+}
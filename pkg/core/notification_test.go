@@ -0,0 +1,154 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestNotificationTitle(t *testing.T) {
+	tests := []struct {
+		notification Notification
+		want         string
+	}{
+		{Notification{From: "bob", DisplayName: "Bob", Count: 1}, "New message from Bob"},
+		{Notification{From: "bob", DisplayName: "Bob", Count: 3}, "3 new messages from Bob"},
+		{Notification{From: "carol", Count: 2}, "2 new messages from carol"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.notification.Title(); got != tt.want {
+			t.Errorf("Title() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// sentNotification is a notification and when it was shown
+type sentNotification struct {
+	Notification
+	at time.Time
+}
+
+// notifyingApp returns an app for alice with notifications on, gathering
+// messages for window and showing them at least interval apart, and a
+// function returning the notifications shown so far
+func notifyingApp(t *testing.T, window, interval time.Duration, configure ...func(cfg *config.Config)) (*App, func() []sentNotification) {
+	t.Helper()
+
+	app := newTestApp(t, "alice", append([]func(cfg *config.Config){func(cfg *config.Config) {
+		cfg.UI.Notifications = true
+		cfg.UI.NotificationWindow = window
+		cfg.UI.NotificationInterval = interval
+	}}, configure...)...)
+	var mu sync.Mutex
+	var shown []sentNotification
+	app.AddNotificationHandler(func(notification Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		shown = append(shown, sentNotification{notification, time.Now()})
+	})
+	return app, func() []sentNotification {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sentNotification(nil), shown...)
+	}
+}
+
+// receive hands app a chat message from from
+func receive(t *testing.T, app *App, from, id string) {
+	t.Helper()
+
+	app.handleNetworkMessage(testRelay, incomingChat(t, app, from, id, "hello"))
+}
+
+func TestBurstIsGroupedIntoOneNotification(t *testing.T) {
+	alice, shown := notifyingApp(t, 50*time.Millisecond, 0)
+	if err := alice.saveContact(&models.Contact{UserID: "bob", DisplayName: "Bob"}); err != nil {
+		t.Fatalf("saveContact: %v", err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		receive(t, alice, "bob", fmt.Sprintf("m%d", i))
+	}
+	// The same message from another device or relay counts once
+	receive(t, alice, "bob", "m1")
+	receive(t, alice, "carol", "c1")
+
+	waitFor(t, "the notifications", func() bool { return len(shown()) == 2 })
+	time.Sleep(100 * time.Millisecond)
+	got := make(map[string]Notification)
+	for _, notification := range shown() {
+		got[notification.From] = notification.Notification
+	}
+	if len(got) != 2 || len(shown()) != 2 {
+		t.Fatalf("shown %+v, want one notification for each sender", shown())
+	}
+	if bob := got["bob"]; bob.Count != 5 || bob.Title() != "5 new messages from Bob" {
+		t.Errorf("bob's notification = %+v, want the five messages grouped", bob)
+	}
+	if carol := got["carol"]; carol.Count != 1 {
+		t.Errorf("carol's notification = %+v, want one message", carol)
+	}
+}
+
+func TestNotificationsAreSpacedByInterval(t *testing.T) {
+	const interval = 300 * time.Millisecond
+	alice, shown := notifyingApp(t, 10*time.Millisecond, interval)
+
+	receive(t, alice, "bob", "b1")
+	waitFor(t, "bob's notification", func() bool { return len(shown()) == 1 })
+	for i := 1; i <= 3; i++ {
+		receive(t, alice, "carol", fmt.Sprintf("c%d", i))
+	}
+	waitFor(t, "carol's notification", func() bool { return len(shown()) == 2 })
+
+	got := shown()
+	if gap := got[1].at.Sub(got[0].at); gap < interval-20*time.Millisecond {
+		t.Errorf("notifications %s apart, want at least %s", gap, interval)
+	}
+	if got[1].From != "carol" || got[1].Count != 3 {
+		t.Errorf("second notification = %+v, want carol's three messages grouped while waiting", got[1].Notification)
+	}
+}
+
+func TestNotificationsAreSuppressed(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *config.Config)
+		setup     func(t *testing.T, app *App)
+	}{
+		{"do not disturb", func(cfg *config.Config) { cfg.UI.DoNotDisturb = true }, nil},
+		{"notifications off", func(cfg *config.Config) { cfg.UI.Notifications = false }, nil},
+		{"open chat", nil, func(t *testing.T, app *App) { app.SetActiveChat("bob") }},
+		{"muted contact", nil, func(t *testing.T, app *App) {
+			if err := app.saveContact(&models.Contact{UserID: "bob", Muted: true}); err != nil {
+				t.Fatalf("saveContact: %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *config.Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			alice, shown := notifyingApp(t, 10*time.Millisecond, 0, configure...)
+			if tt.setup != nil {
+				tt.setup(t, alice)
+			}
+
+			for i := 1; i <= 3; i++ {
+				receive(t, alice, "bob", fmt.Sprintf("m%d", i))
+			}
+			time.Sleep(100 * time.Millisecond)
+			if got := shown(); len(got) != 0 {
+				t.Errorf("shown %+v, want nothing", got)
+			}
+		})
+	}
+}
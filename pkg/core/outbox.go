@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// OutboxHandler is called with the messages waiting in the outbox whenever
+// one is added, sent or cancelled
+type OutboxHandler func(queued []*models.Message)
+
+// AddOutboxHandler adds an outbox change handler
+func (a *App) AddOutboxHandler(handler OutboxHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+	a.outboxHandlers = append(a.outboxHandlers, handler)
+}
+
+// Outbox returns the messages written while no relay was reachable, or
+// while an encryption session was being set up, that have not been sent
+// yet, oldest first
+func (a *App) Outbox() ([]*models.Message, error) {
+	entries, err := a.storage.GetOutboxEntries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	queued := make([]*models.Message, 0, len(entries))
+	for _, entry := range entries {
+		msg, err := a.storage.GetMessage(entry.ChatID, entry.MessageID)
+		if err != nil {
+			// Deleted from the history; drainOutbox drops the entry
+			continue
+		}
+		queued = append(queued, msg)
+	}
+	sort.SliceStable(queued, func(i, j int) bool {
+		return queued[i].Timestamp.Before(queued[j].Timestamp)
+	})
+	return queued, nil
+}
+
+// CancelQueuedMessage removes a message from the outbox before it is sent
+// and deletes it from the history. It fails if the message is not in the
+// outbox, for example because it went out in the meantime.
+func (a *App) CancelQueuedMessage(otherUserID, messageID string) error {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+
+	// Keep a drain from sending the message while it is cancelled
+	a.outboxMux.Lock()
+	defer a.outboxMux.Unlock()
+
+	entries, err := a.storage.GetOutboxEntries()
+	if err != nil {
+		return fmt.Errorf("failed to read outbox: %w", err)
+	}
+	found := false
+	for _, entry := range entries {
+		if entry.ChatID == chatID && entry.MessageID == messageID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("message %s is not queued", messageID)
+	}
+
+	if err := a.storage.DeleteOutboxEntry(chatID, messageID); err != nil {
+		return fmt.Errorf("failed to remove message from outbox: %w", err)
+	}
+	if err := a.storage.SecureDeleteMessages(chatID, []string{messageID}); err != nil {
+		log.Printf("Warning: failed to delete cancelled message %s: %v", messageID, err)
+	}
+
+	a.notifyOutboxHandlers()
+	return nil
+}
+
+// queueMessage puts a message that could not be sent into the outbox
+func (a *App) queueMessage(msg *models.Message) {
+	entry := &models.OutboxEntry{ChatID: msg.ChatID, MessageID: msg.ID}
+	if err := a.storage.SaveOutboxEntry(entry); err != nil {
+		log.Printf("Warning: failed to queue message %s: %v", msg.ID, err)
+		return
+	}
+	a.notifyOutboxHandlers()
+}
+
+// drainOutbox sends the queued messages in the order they were written. It
+// stops when a message is queued again because the connection dropped.
+func (a *App) drainOutbox() {
+	a.outboxMux.Lock()
+	defer a.outboxMux.Unlock()
+
+	entries, err := a.storage.GetOutboxEntries()
+	if err != nil {
+		log.Printf("Warning: failed to read outbox: %v", err)
+		return
+	}
+
+	var queued []*models.Message
+	for _, entry := range entries {
+		msg, err := a.storage.GetMessage(entry.ChatID, entry.MessageID)
+		if errors.Is(err, storage.ErrNotFound) {
+			// The message was deleted from the history before it went out
+			if err := a.storage.DeleteOutboxEntry(entry.ChatID, entry.MessageID); err != nil {
+				log.Printf("Warning: failed to remove message from outbox: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			// Kept for the next drain, which may read it
+			log.Printf("Warning: failed to read queued message %s: %v", entry.MessageID, err)
+			continue
+		}
+		queued = append(queued, msg)
+	}
+	sort.SliceStable(queued, func(i, j int) bool {
+		return queued[i].Timestamp.Before(queued[j].Timestamp)
+	})
+
+	for _, msg := range queued {
+		select {
+		case <-a.done:
+			return
+		default:
+		}
+
+		if err := a.transmit(msg); err != nil {
+			log.Printf("Failed to send queued message %s: %v", msg.ID, err)
+			return
+		}
+		if msg.Status == models.MessageStatusPending {
+			if a.awaitingSession(msg) {
+				// Sent once the session is set up
+				continue
+			}
+			// Queued again; the next connection picks it up
+			return
+		}
+
+		if err := a.storage.DeleteOutboxEntry(msg.ChatID, msg.ID); err != nil {
+			log.Printf("Warning: failed to remove message from outbox: %v", err)
+		}
+		a.notifyOutboxHandlers()
+	}
+
+	if len(queued) > 0 {
+		log.Printf("Sent %d queued messages", len(queued))
+	}
+}
+
+// notifyOutboxHandlers passes the current outbox to the outbox handlers
+func (a *App) notifyOutboxHandlers() {
+	a.handlersMux.RLock()
+	handlers := a.outboxHandlers
+	a.handlersMux.RUnlock()
+	if len(handlers) == 0 {
+		return
+	}
+
+	queued, err := a.Outbox()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	for _, handler := range handlers {
+		handler(queued)
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// contents returns the content of each message in order
+func contents(messages []*models.Message) string {
+	var got []string
+	for _, msg := range messages {
+		got = append(got, msg.Content)
+	}
+	return strings.Join(got, ",")
+}
+
+func TestOutboxListsAndCancelsQueuedMessages(t *testing.T) {
+	alice, bob := newTestApp(t, "alice"), newTestApp(t, "bob")
+	var mu sync.Mutex
+	var updates []string
+	alice.AddOutboxHandler(func(queued []*models.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, contents(queued))
+	})
+	lastUpdate := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(updates) == 0 {
+			return "none"
+		}
+		return updates[len(updates)-1]
+	}
+
+	// Written while alice has no relay
+	for _, content := range []string{"first", "second", "third"} {
+		if err := alice.SendMessage("bob", content); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	queued, err := alice.Outbox()
+	if err != nil {
+		t.Fatalf("Outbox: %v", err)
+	}
+	if got := contents(queued); got != "first,second,third" {
+		t.Fatalf("outbox = %s, want the three messages oldest first", got)
+	}
+	for _, msg := range queued {
+		if msg.To != "bob" || msg.Status != models.MessageStatusPending {
+			t.Errorf("queued message to %s is %s, want pending to bob", msg.To, msg.Status)
+		}
+	}
+	if got := lastUpdate(); got != "first,second,third" {
+		t.Errorf("last outbox update = %s, want all three queued", got)
+	}
+
+	// Cancelling takes the message out of the outbox and the history
+	cancelled := queued[1]
+	if err := alice.CancelQueuedMessage("bob", cancelled.ID); err != nil {
+		t.Fatalf("CancelQueuedMessage: %v", err)
+	}
+	if queued, _ := alice.Outbox(); contents(queued) != "first,third" {
+		t.Errorf("outbox after cancelling = %s, want first,third", contents(queued))
+	}
+	if got := lastUpdate(); got != "first,third" {
+		t.Errorf("last outbox update = %s, want first,third", got)
+	}
+	if hasMessage(alice, "bob", "second") {
+		t.Error("the cancelled message is still in the history")
+	}
+	if err := alice.CancelQueuedMessage("bob", cancelled.ID); err == nil {
+		t.Error("cancelling a message that is not queued succeeded")
+	}
+
+	// The rest drain once alice connects, and the list empties
+	server := newTestServer(t)
+	connectApp(t, bob, server)
+	connectApp(t, alice, server)
+	waitFor(t, "bob to receive the queued messages", func() bool {
+		return hasMessage(bob, "alice", "first") && hasMessage(bob, "alice", "third")
+	})
+	waitFor(t, "the outbox to drain", func() bool { return lastUpdate() == "" })
+	if queued, _ := alice.Outbox(); len(queued) != 0 {
+		t.Errorf("outbox after draining = %s, want it empty", contents(queued))
+	}
+	if hasMessage(bob, "alice", "second") {
+		t.Error("the cancelled message was sent")
+	}
+}
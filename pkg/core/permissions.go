@@ -0,0 +1,53 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// ErrInsecurePermissions is returned when other users can access the data
+// or config directory and the policy is to refuse to start
+var ErrInsecurePermissions = errors.New("other users can access SecureChat files")
+
+// CheckConfigPermissions applies the permission policy to the config file
+// and the directory it is in
+func CheckConfigPermissions(configPath string, policy config.PermissionPolicy) error {
+	return checkPermissions(filepath.Dir(configPath), false, policy)
+}
+
+// checkPermissions looks for entries under dir that the group or others can
+// access, and warns about them, refuses to go on or tightens them per the
+// policy. A directory that does not exist yet passes.
+func checkPermissions(dir string, recursive bool, policy config.PermissionPolicy) error {
+	loose, err := storage.LoosePermissions(dir, recursive)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check permissions of %s: %w", dir, err)
+	}
+	if len(loose) == 0 {
+		return nil
+	}
+
+	switch policy {
+	case config.PermissionsFix:
+		if err := storage.TightenPermissions(loose); err != nil {
+			return err
+		}
+		log.Printf("Removed access for other users from %d paths in %s", len(loose), dir)
+		return nil
+	case config.PermissionsRefuse:
+		return fmt.Errorf("%w: %s and %d other paths; run chmod -R go-rwx %s or set insecure_permissions to fix",
+			ErrInsecurePermissions, loose[0], len(loose)-1, dir)
+	default:
+		log.Printf("Warning: other users can access %s and %d other paths in %s; run chmod -R go-rwx %s or set insecure_permissions to fix",
+			loose[0], len(loose)-1, dir, dir)
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func TestInsecurePermissionsPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits do not control access on Windows")
+	}
+
+	tests := []struct {
+		policy    config.PermissionPolicy
+		wantErr   bool
+		wantLog   string
+		wantFixed bool
+	}{
+		{config.PermissionsWarn, false, "Warning: other users can access", false},
+		{config.PermissionsRefuse, true, "", false},
+		{config.PermissionsFix, false, "Removed access for other users from 2 paths", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// A data directory copied over with the umask of another system
+			t.Setenv("HOME", t.TempDir())
+			cfg := config.Default()
+			cfg.User.ID = "alice"
+			cfg.Storage.Backend = config.StorageMemory
+			cfg.Security.InsecurePermissions = tt.policy
+			dataDir := cfg.GetDataDir()
+			shared := filepath.Join(dataDir, "securechat.db", "000001.vlog")
+			if err := os.MkdirAll(filepath.Dir(shared), 0700); err != nil {
+				t.Fatalf("MkdirAll: %v", err)
+			}
+			if err := os.WriteFile(shared, nil, 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			for _, path := range []string{dataDir, shared} {
+				if err := os.Chmod(path, 0755); err != nil {
+					t.Fatalf("Chmod: %v", err)
+				}
+			}
+
+			logs := captureLog(t)
+			app, err := NewApp(cfg)
+			if err == nil {
+				defer app.Close()
+			}
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrInsecurePermissions) {
+					t.Fatalf("NewApp = %v, want ErrInsecurePermissions", err)
+				}
+			} else if err != nil {
+				t.Fatalf("NewApp: %v", err)
+			}
+			if tt.wantLog != "" && !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log does not say %q:\n%s", tt.wantLog, logs.String())
+			}
+			for _, path := range []string{dataDir, shared} {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatalf("Stat: %v", err)
+				}
+				if fixed := info.Mode().Perm()&0077 == 0; fixed != tt.wantFixed {
+					t.Errorf("%s has mode %o, want it fixed %v", path, info.Mode().Perm(), tt.wantFixed)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckConfigPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits do not control access on Windows")
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("user: {}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Chmod(configPath, 0644); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+
+	if err := CheckConfigPermissions(filepath.Join(t.TempDir(), "missing", "config.yaml"), config.PermissionsRefuse); err != nil {
+		t.Errorf("a config directory that does not exist yet was refused: %v", err)
+	}
+	if err := CheckConfigPermissions(configPath, config.PermissionsRefuse); !errors.Is(err, ErrInsecurePermissions) {
+		t.Errorf("CheckConfigPermissions = %v, want ErrInsecurePermissions for a readable config", err)
+	}
+	if err := CheckConfigPermissions(configPath, config.PermissionsFix); err != nil {
+		t.Fatalf("CheckConfigPermissions: %v", err)
+	}
+	if err := CheckConfigPermissions(configPath, config.PermissionsRefuse); err != nil {
+		t.Errorf("still refused after fixing: %v", err)
+	}
+}
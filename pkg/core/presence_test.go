@@ -0,0 +1,47 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// contactStatus returns the presence app knows for a contact
+func contactStatus(app *App, userID string) models.UserStatus {
+	contact, ok := app.GetContact(userID)
+	if !ok {
+		return ""
+	}
+	return contact.Status
+}
+
+func TestAddingContactSubscribesToPresence(t *testing.T) {
+	server := newTestServer(t)
+	connectedUser(t, server, "bob", make(chan routedMessage, 16))
+	app := newTestApp(t, "alice")
+	client := connectApp(t, app, server)
+
+	if err := app.AddContact("bob", "Bob"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	if got := strings.Join(client.PresenceSubscriptions(), ","); got != "bob" {
+		t.Errorf("subscribed to %s, want bob", got)
+	}
+	waitFor(t, "bob's presence", func() bool { return contactStatus(app, "bob") == models.UserStatusOnline })
+
+	// The subscription follows carol from offline to online
+	if err := app.AddContact("carol", "Carol"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	waitFor(t, "carol's presence", func() bool { return contactStatus(app, "carol") == models.UserStatusOffline })
+	connectedUser(t, server, "carol", make(chan routedMessage, 16))
+	waitFor(t, "carol coming online", func() bool { return contactStatus(app, "carol") == models.UserStatusOnline })
+
+	if err := app.RemoveContact("bob"); err != nil {
+		t.Fatalf("RemoveContact: %v", err)
+	}
+	if got := strings.Join(client.PresenceSubscriptions(), ","); got != "carol" {
+		t.Errorf("subscribed to %s after removing bob, want carol", got)
+	}
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// sessionPair connects alice and bob to one relay as each other's contacts,
+// with configure applied to both, and sets up their session
+func sessionPair(t *testing.T, configure func(*config.Config)) (*App, *App) {
+	t.Helper()
+
+	server := newTestServer(t)
+	alice, bob := newTestApp(t, "alice", configure), newTestApp(t, "bob", configure)
+	connectApp(t, alice, server)
+	connectApp(t, bob, server)
+	for _, pair := range [][2]*App{{alice, bob}, {bob, alice}} {
+		if err := pair[0].AddContact(pair[1].config.User.ID, ""); err != nil {
+			t.Fatalf("AddContact: %v", err)
+		}
+	}
+	exchange(t, alice, bob, "hello bob")
+	exchange(t, bob, alice, "hello alice")
+	return alice, bob
+}
+
+// currentSession returns app's session with userID
+func currentSession(app *App, userID string) *crypto.DoubleRatchet {
+	app.sessionsMux.Lock()
+	defer app.sessionsMux.Unlock()
+	return app.sessions[userID]
+}
+
+func TestSessionIsRekeyedAfterMaxMessages(t *testing.T) {
+	alice, bob := sessionPair(t, func(cfg *config.Config) {
+		cfg.Security.SessionMaxAge = 0
+		cfg.Security.SessionMaxMessages = 3
+	})
+	before := currentSession(alice, "bob")
+	states := sessionStates(alice, "bob")
+
+	// alice keeps sending until the session has carried three messages,
+	// counting receipts and the messages of the handshake
+	for i := 1; currentSession(alice, "bob") == before; i++ {
+		if i > 10 {
+			t.Fatal("the session was not re-keyed after exceeding the message limit")
+		}
+		alice.sessionsMux.Lock()
+		carried := int(before.SendingChain.MessageNumber) + int(before.ReceivingChain.MessageNumber)
+		alice.sessionsMux.Unlock()
+		exchange(t, alice, bob, fmt.Sprintf("message %d", i))
+		if currentSession(alice, "bob") != before && carried < 3 {
+			t.Fatalf("the session was re-keyed after carrying %d messages, want 3", carried)
+		}
+	}
+
+	// Both ends carry on under the new session
+	exchange(t, bob, alice, "five")
+	exchange(t, alice, bob, "six")
+	if got := alice.EncryptionState("bob"); got != models.EncryptionActive {
+		t.Errorf("encryption state after the re-key = %s, want active", got)
+	}
+	if got := states(); strings.Contains(got, string(models.EncryptionPending)) || strings.Contains(got, string(models.EncryptionNone)) {
+		t.Errorf("session states during the re-key = %q, want it to stay encrypted", got)
+	}
+}
+
+func TestExpiredSessionIsReestablishedOnNextUse(t *testing.T) {
+	alice, bob := sessionPair(t, func(cfg *config.Config) {
+		cfg.Security.SessionMaxAge = time.Hour
+		cfg.Security.SessionMaxMessages = 0
+	})
+	before := currentSession(alice, "bob")
+
+	// Nothing happens while the session is idle, however old it gets
+	alice.sessionsMux.Lock()
+	alice.keyedAt["bob"] = time.Now().Add(-2 * time.Hour)
+	alice.sessionsMux.Unlock()
+	if currentSession(alice, "bob") != before {
+		t.Fatal("an idle expired session was replaced")
+	}
+
+	exchange(t, alice, bob, "after a while")
+	if currentSession(alice, "bob") == before {
+		t.Fatal("the expired session was used instead of re-established")
+	}
+	keyedAt, ok := alice.SessionKeyedAt("bob")
+	if !ok || time.Since(keyedAt) > time.Minute {
+		t.Errorf("SessionKeyedAt after re-keying = %v, %v; want just now", keyedAt, ok)
+	}
+	exchange(t, bob, alice, "still encrypted")
+}
+
+func TestRetiredSessionDecryptsMessagesInFlight(t *testing.T) {
+	alice, bob := sessionPair(t, func(cfg *config.Config) {
+		cfg.Security.SessionMaxAge = time.Hour
+	})
+
+	// bob seals a message under the current session that is held up on
+	// its way to alice
+	sealed, err := bob.sealChatPayload("alice", network.ChatPayload{Content: "sent before the re-key", Sequence: 100})
+	if err != nil {
+		t.Fatalf("sealChatPayload: %v", err)
+	}
+	payload, err := network.EncodePayload(sealed)
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	late := &network.Message{ID: "late-1", Type: network.MessageTypeChat, From: "bob", To: "alice", Timestamp: time.Now().Unix(), Payload: payload}
+	if err := bob.signMessage(late); err != nil {
+		t.Fatalf("signMessage: %v", err)
+	}
+
+	// Meanwhile alice's session expires and she re-keys it
+	alice.sessionsMux.Lock()
+	alice.keyedAt["bob"] = time.Now().Add(-2 * time.Hour)
+	alice.sessionsMux.Unlock()
+	before := currentSession(alice, "bob")
+	exchange(t, alice, bob, "after the re-key")
+	if currentSession(alice, "bob") == before {
+		t.Fatal("the session was not re-keyed")
+	}
+
+	if err := alice.handleNetworkMessage("memory", late); err != nil {
+		t.Fatalf("handling the message sent under the retired session: %v", err)
+	}
+	if !hasMessage(alice, "bob", "sent before the re-key") {
+		t.Error("the message sent under the retired session was not read")
+	}
+
+	// Once bob is heard from under the new session the old one is gone
+	exchange(t, bob, alice, "under the new session")
+	alice.sessionsMux.Lock()
+	retired := alice.retired["bob"]
+	alice.sessionsMux.Unlock()
+	if retired != nil {
+		t.Error("the retired session was kept after the peer used the new one")
+	}
+}
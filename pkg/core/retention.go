@@ -0,0 +1,90 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// retentionInterval is how often expired messages are swept
+const retentionInterval = time.Hour
+
+// SetConversationRetention overrides how many days messages in a chat are
+// kept. 0 keeps them forever; a negative value restores the global default.
+func (a *App) SetConversationRetention(chatID string, days int) error {
+	conversation, err := a.storage.GetConversation(chatID)
+	if errors.Is(err, storage.ErrNotFound) {
+		conversation = &models.Conversation{ChatID: chatID}
+	} else if err != nil {
+		return fmt.Errorf("failed to load conversation: %w", err)
+	}
+
+	if days < 0 {
+		conversation.RetentionDays = nil
+	} else {
+		conversation.RetentionDays = &days
+	}
+
+	if err := a.storage.SaveConversation(conversation); err != nil {
+		return fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	log.Printf("Set retention for %s to %d days", chatID, conversation.Retention(a.config.Security.MessageRetentionDays))
+	return nil
+}
+
+// runRetentionSweeper deletes expired messages at startup and then
+// periodically until the app is closed
+func (a *App) runRetentionSweeper() {
+	defer a.background.Done()
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		a.sweepExpiredMessages()
+
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleExpiry deletes a disappearing message the moment its time is up,
+// when the chat view drops it too. Messages that expire while the app is
+// closed are deleted by the sweeper and hidden until then.
+func (a *App) scheduleExpiry(msg *models.Message) {
+	if msg.Metadata == nil || msg.Metadata.ExpiresAt.IsZero() {
+		return
+	}
+
+	chatID, messageID := msg.ChatID, msg.ID
+	key := deliveryKey(chatID, messageID)
+	a.expiries.schedule(key, time.Until(msg.Metadata.ExpiresAt), func() {
+		a.expiries.cancel(key)
+
+		select {
+		case <-a.done:
+			return
+		default:
+		}
+
+		if err := a.storage.SecureDeleteMessages(chatID, []string{messageID}); err != nil {
+			log.Printf("Warning: failed to delete expired message %s: %v", messageID, err)
+		}
+	})
+}
+
+// sweepExpiredMessages deletes disappearing messages whose time is up and
+// applies the global and per-chat retention settings
+func (a *App) sweepExpiredMessages() {
+	if err := a.storage.CleanupExpiredMessages(a.config.Security.MessageRetentionDays); err != nil {
+		log.Printf("Warning: failed to clean up expired messages: %v", err)
+	}
+}
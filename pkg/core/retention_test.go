@@ -0,0 +1,85 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// storeOldMessage stores a message in chatID sent age ago
+func storeOldMessage(t *testing.T, app *App, chatID, id string, age time.Duration) {
+	t.Helper()
+
+	msg := &models.Message{
+		ID:        id,
+		Type:      models.MessageTypeChat,
+		From:      "alice",
+		ChatID:    chatID,
+		Content:   "hello",
+		Timestamp: time.Now().Add(-age),
+	}
+	if err := app.storage.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+}
+
+// weekOfRetention keeps messages for seven days unless a chat overrides it
+func weekOfRetention(cfg *config.Config) {
+	cfg.Security.MessageRetentionDays = 7
+}
+
+func TestRetentionSweeperRespectsConversationOverrides(t *testing.T) {
+	app := newTestApp(t, "alice", weekOfRetention)
+
+	tenDays := 10 * 24 * time.Hour
+	chats := map[string]int{
+		"alice:bob":   0,  // Forever
+		"alice:carol": 30, // Longer than the default
+		"alice:dave":  3,  // Shorter than the default
+		"alice:erin":  -1, // The default
+	}
+	for chatID, days := range chats {
+		if err := app.SetConversationRetention(chatID, days); err != nil {
+			t.Fatalf("SetConversationRetention(%s): %v", chatID, err)
+		}
+		storeOldMessage(t, app, chatID, "old", tenDays)
+		storeOldMessage(t, app, chatID, "recent", 2*24*time.Hour)
+	}
+	storeOldMessage(t, app, "alice:frank", "old", tenDays)
+
+	app.sweepExpiredMessages()
+
+	want := map[string]map[string]bool{
+		"alice:bob":   {"old": true, "recent": true},
+		"alice:carol": {"old": true, "recent": true},
+		"alice:dave":  {"old": false, "recent": true},
+		"alice:erin":  {"old": false, "recent": true},
+		"alice:frank": {"old": false},
+	}
+	for chatID, messages := range want {
+		for id, kept := range messages {
+			if has, _ := app.storage.HasMessage(chatID, id); has != kept {
+				t.Errorf("%s in %s kept = %v, want %v", id, chatID, has, kept)
+			}
+		}
+	}
+}
+
+func TestRetentionOverrideCanBeCleared(t *testing.T) {
+	app := newTestApp(t, "alice", weekOfRetention)
+
+	if err := app.SetConversationRetention("alice:bob", 0); err != nil {
+		t.Fatalf("SetConversationRetention: %v", err)
+	}
+	if err := app.SetConversationRetention("alice:bob", -1); err != nil {
+		t.Fatalf("SetConversationRetention: %v", err)
+	}
+	storeOldMessage(t, app, "alice:bob", "old", 10*24*time.Hour)
+
+	app.sweepExpiredMessages()
+	if has, _ := app.storage.HasMessage("alice:bob", "old"); has {
+		t.Error("the cleared override still kept the message forever")
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// searchKeyLabel names the key that blinds a persisted search index
+const searchKeyLabel = "SecureChat-SearchIndex"
+
+// SearchMessages returns the messages of all chats that contain every word
+// of query, most recent first, and at most limit of them if it is positive.
+// Chats with hidden contacts, such as blocked ones, are left out.
+func (a *App) SearchMessages(query string, limit int) ([]*models.Message, error) {
+	messages, err := a.storage.SearchMessages(query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := messages[:0]
+	for _, msg := range messages {
+		if !a.hiddenChat(msg) {
+			visible = append(visible, msg)
+		}
+	}
+	if limit > 0 && len(visible) > limit {
+		visible = visible[:limit]
+	}
+	return visible, nil
+}
+
+// SearchChat returns the messages of a chat with another user that contain
+// every word of query, oldest first
+func (a *App) SearchChat(otherUserID, query string) ([]*models.Message, error) {
+	chatID := a.getChatID(a.config.User.ID, models.NormalizeUserID(otherUserID))
+	messages, err := a.storage.SearchMessages(query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*models.Message
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].ChatID == chatID {
+			matches = append(matches, messages[i])
+		}
+	}
+	return matches, nil
+}
+
+// initSearchIndex opens the search index in the configured mode. A blinded
+// index is keyed to the identity, so it is rebuilt when the identity
+// changes.
+func (a *App) initSearchIndex() error {
+	var key []byte
+	if a.config.Security.SearchIndex == config.SearchIndexBlinded {
+		var err error
+		key, err = a.identity.DeriveLocalKey(searchKeyLabel, storage.SearchKeySize)
+		if err != nil {
+			return fmt.Errorf("failed to derive search index key: %w", err)
+		}
+	}
+	return a.storage.OpenSearchIndex(key)
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// SecurityStatus returns an overview of the security of the user's
+// conversations: verified contacts, outstanding key warnings, sessions,
+// how the relays are reached and the retention settings
+func (a *App) SecurityStatus() models.SecurityStatus {
+	status := models.SecurityStatus{
+		RetentionDays:       a.config.Security.MessageRetentionDays,
+		AutoAcceptKeys:      string(a.config.Security.AutoAcceptKeys),
+		RequireVerification: a.config.Security.RequireVerification,
+	}
+
+	for _, contact := range a.GetContacts() {
+		state := contact.State()
+		if state.Unknown || state.Blocked {
+			continue
+		}
+		status.Contacts++
+		if state.Verified {
+			status.Verified++
+		}
+		if contact.KeyChanged {
+			status.KeyChanges++
+		}
+		if len(contact.PendingKey) > 0 {
+			status.PendingKeys++
+		}
+	}
+
+	a.sessionsMux.Lock()
+	status.Sessions = len(a.sessions)
+	for userID := range a.sessions {
+		if a.downgraded[userID] {
+			status.Downgraded++
+		}
+	}
+	a.sessionsMux.Unlock()
+
+	for _, relay := range a.config.Network.RelayServers {
+		status.Relays++
+		if strings.HasPrefix(relayURL(relay), "wss://") {
+			status.TLSRelays++
+		}
+		if a.config.Network.RelayFingerprints[relay] != "" {
+			status.PinnedRelays++
+		}
+	}
+	return status
+}
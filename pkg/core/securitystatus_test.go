@@ -0,0 +1,77 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestSecurityStatusCountsContacts(t *testing.T) {
+	alice := newTestApp(t, "alice")
+	contacts := []*models.Contact{
+		{UserID: "bob", Verified: true},
+		{UserID: "carol"},
+		{UserID: "dave", Verified: true, KeyChanged: true},
+		{UserID: "erin", PendingKey: []byte("new key")},
+		{UserID: "frank", Verified: true, Blocked: true},
+		{UserID: "grace", Unknown: true},
+	}
+	for _, contact := range contacts {
+		if err := alice.saveContact(contact); err != nil {
+			t.Fatalf("saveContact(%s): %v", contact.UserID, err)
+		}
+	}
+
+	status := alice.SecurityStatus()
+	// Blocked and unknown users are left out; a changed key is no longer
+	// verified
+	if status.Contacts != 4 || status.Verified != 1 || status.Unverified() != 3 {
+		t.Errorf("contacts %d, verified %d, unverified %d; want 4, 1, 3", status.Contacts, status.Verified, status.Unverified())
+	}
+	if status.KeyChanges != 1 || status.PendingKeys != 1 {
+		t.Errorf("key changes %d, pending keys %d; want 1 and 1", status.KeyChanges, status.PendingKeys)
+	}
+}
+
+func TestSecurityStatusCountsSessions(t *testing.T) {
+	alice := newTestApp(t, "alice")
+	if status := alice.SecurityStatus(); status.Sessions != 0 || status.Downgraded != 0 {
+		t.Errorf("without sessions: %d sessions, %d downgraded; want none", status.Sessions, status.Downgraded)
+	}
+
+	for _, user := range []string{"bob", "carol"} {
+		if err := alice.handleSessionInit(sessionInitFor(t, alice, user)); err != nil {
+			t.Fatalf("handleSessionInit(%s): %v", user, err)
+		}
+	}
+	if status := alice.SecurityStatus(); status.Sessions != 2 || status.Downgraded != 0 {
+		t.Errorf("with two sessions: %d sessions, %d downgraded; want 2 and 0", status.Sessions, status.Downgraded)
+	}
+
+	alice.sessionsMux.Lock()
+	alice.downgraded["carol"] = true
+	alice.downgraded["dave"] = true // No session, so not counted
+	alice.sessionsMux.Unlock()
+	if status := alice.SecurityStatus(); status.Sessions != 2 || status.Downgraded != 1 {
+		t.Errorf("after a downgrade: %d sessions, %d downgraded; want 2 and 1", status.Sessions, status.Downgraded)
+	}
+}
+
+func TestSecurityStatusReportsRelaysAndSettings(t *testing.T) {
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Network.RelayServers = []string{"wss://one.example/ws", "wss://two.example/ws", "ws://three.example/ws"}
+		cfg.Network.RelayFingerprints = map[string]string{"wss://one.example/ws": "ab:cd"}
+		cfg.Security.MessageRetentionDays = 30
+		cfg.Security.AutoAcceptKeys = config.KeyAcceptNever
+		cfg.Security.RequireVerification = true
+	})
+
+	status := alice.SecurityStatus()
+	if status.Relays != 3 || status.TLSRelays != 2 || status.PinnedRelays != 1 {
+		t.Errorf("relays %d, over TLS %d, pinned %d; want 3, 2, 1", status.Relays, status.TLSRelays, status.PinnedRelays)
+	}
+	if status.RetentionDays != 30 || status.AutoAcceptKeys != "never" || !status.RequireVerification {
+		t.Errorf("settings = %d days, %q, %v; want 30 days, never, required", status.RetentionDays, status.AutoAcceptKeys, status.RequireVerification)
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"log"
+	"math"
+	"sync"
+
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// maxSequenceJump is how far past the highest sequence seen in a chat a
+// received message may be numbered. Peers number one past what they have
+// seen, so a larger jump is bogus and would push every later message
+// before it, or run the counter up to where it wraps.
+const maxSequenceJump = 1 << 20
+
+// chatSequences hands out per-chat sequence numbers. Both sides of a chat
+// number their messages one past the highest sequence they have seen, so
+// the sequence orders the conversation independently of device clocks.
+type chatSequences struct {
+	mu      sync.Mutex
+	storage storage.Store
+	last    map[string]uint64
+}
+
+// newChatSequences creates a sequence tracker backed by stored messages
+func newChatSequences(s storage.Store) *chatSequences {
+	return &chatSequences{
+		storage: s,
+		last:    make(map[string]uint64),
+	}
+}
+
+// Next returns the sequence number for a new outgoing message in a chat.
+// It stops at the largest sequence rather than wrapping around.
+func (c *chatSequences) Next(chatID string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seq := c.load(chatID)
+	if seq < math.MaxUint64 {
+		seq++
+	}
+	c.last[chatID] = seq
+	return seq
+}
+
+// Observe records the sequence number of a received message. It returns
+// the sequence to keep the message under, which is seq unless that is more
+// than maxSequenceJump past the highest seen, when the message is numbered
+// next instead. It also returns the number of messages that appear to be
+// missing before it, which is non-zero when the peer has sent messages that
+// have not arrived yet.
+func (c *chatSequences) Observe(chatID string, seq uint64) (accepted, missing uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := c.load(chatID)
+	if seq <= last {
+		return seq, 0
+	}
+	if seq-last > maxSequenceJump {
+		log.Printf("Warning: sequence %d in chat %s is too far past %d, numbering the message next", seq, chatID, last)
+		seq = last + 1
+	}
+
+	c.last[chatID] = seq
+	return seq, seq - last - 1
+}
+
+// load returns the highest known sequence for a chat, reading it from
+// storage the first time the chat is used. Callers must hold c.mu.
+func (c *chatSequences) load(chatID string) uint64 {
+	if last, ok := c.last[chatID]; ok {
+		return last
+	}
+
+	last, err := c.storage.MaxSequence(chatID)
+	if err != nil {
+		log.Printf("Warning: failed to load sequence for chat %s: %v", chatID, err)
+	}
+	c.last[chatID] = last
+	return last
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/storage"
+)
+
+// newTestSequences returns a sequence tracker over an empty memory store
+func newTestSequences(t *testing.T) (*chatSequences, storage.Store) {
+	t.Helper()
+
+	store, err := storage.NewMemoryStore(storage.StorageOptions{})
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return newChatSequences(store), store
+}
+
+func TestSequencesContinueFromStorage(t *testing.T) {
+	sequences, store := newTestSequences(t)
+	if err := store.SaveMessage(&models.Message{ID: "m1", ChatID: "alice:bob", Sequence: 41}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	if got := sequences.Next("alice:bob"); got != 42 {
+		t.Errorf("Next = %d, want 42", got)
+	}
+	if got := sequences.Next("alice:bob"); got != 43 {
+		t.Errorf("Next = %d, want 43", got)
+	}
+	if got := sequences.Next("alice:carol"); got != 1 {
+		t.Errorf("Next in a new chat = %d, want 1", got)
+	}
+}
+
+func TestSequencesObserve(t *testing.T) {
+	sequences, _ := newTestSequences(t)
+	sequences.Next("alice:bob") // 1
+
+	tests := []struct {
+		name          string
+		seq           uint64
+		accepted, gap uint64
+	}{
+		{"next", 2, 2, 0},
+		{"ahead", 5, 5, 2},
+		{"late arrival", 3, 3, 0},
+		{"too far ahead", 5 + maxSequenceJump + 1, 6, 0},
+	}
+	for _, tt := range tests {
+		accepted, missing := sequences.Observe("alice:bob", tt.seq)
+		if accepted != tt.accepted || missing != tt.gap {
+			t.Errorf("%s: Observe(%d) = %d, %d, want %d, %d", tt.name, tt.seq, accepted, missing, tt.accepted, tt.gap)
+		}
+	}
+
+	// Replies number past what was observed
+	if got := sequences.Next("alice:bob"); got != 7 {
+		t.Errorf("Next = %d, want 7", got)
+	}
+}
+
+func TestSequencesDoNotWrap(t *testing.T) {
+	sequences, _ := newTestSequences(t)
+	sequences.last["alice:bob"] = math.MaxUint64 - 1
+
+	for i := 0; i < 2; i++ {
+		if got := sequences.Next("alice:bob"); got != math.MaxUint64 {
+			t.Errorf("Next = %d, want the largest sequence", got)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// identityFromModel converts a stored identity to key pairs. Identities
+// stored by older versions only carry the fingerprint.
+func identityFromModel(identity *models.Identity) *crypto.IdentityKeyPair {
+	return &crypto.IdentityKeyPair{
+		SigningKey: crypto.KeyPair{
+			PublicKey:  identity.IdentityKey,
+			PrivateKey: identity.SigningPrivateKey,
+		},
+		ExchangeKey: crypto.KeyPair{
+			PublicKey:  identity.ExchangeKey,
+			PrivateKey: identity.ExchangePrivateKey,
+		},
+		Fingerprint: identity.Fingerprint,
+	}
+}
+
+// upgradeFingerprint replaces a legacy fingerprint of the loaded identity
+// with the full one and stores it. Fingerprints contacts stored for this
+// identity still match, see crypto.FingerprintsMatch.
+func (a *App) upgradeFingerprint() {
+	if len(a.identity.SigningKey.PublicKey) == 0 || len(a.identity.ExchangeKey.PublicKey) == 0 {
+		return // Older identities without keys only carry the fingerprint
+	}
+	canonical := a.identity.CanonicalFingerprint()
+	if a.identity.Fingerprint == canonical {
+		return
+	}
+	if !crypto.FingerprintsMatch(a.identity.Fingerprint, canonical) {
+		log.Printf("Warning: stored fingerprint %s does not match the identity keys", a.identity.Fingerprint)
+		return
+	}
+
+	a.identity.Fingerprint = canonical
+	if err := a.storage.SaveIdentity(identityToModel(a.config.User.ID, a.identity, a.preKey)); err != nil {
+		log.Printf("Warning: failed to save upgraded fingerprint: %v", err)
+		return
+	}
+	log.Printf("Upgraded identity fingerprint to its full length")
+}
+
+// identityToModel converts key pairs and the signed prekey, if there is
+// one, to their stored form
+func identityToModel(userID string, identity *crypto.IdentityKeyPair, preKey *crypto.PreKey) *models.Identity {
+	stored := &models.Identity{
+		UserID:             userID,
+		IdentityKey:        identity.SigningKey.PublicKey,
+		SigningPrivateKey:  identity.SigningKey.PrivateKey,
+		ExchangeKey:        identity.ExchangeKey.PublicKey,
+		ExchangePrivateKey: identity.ExchangeKey.PrivateKey,
+		Fingerprint:        identity.Fingerprint,
+	}
+	if preKey != nil {
+		stored.PreKeyID = preKey.ID
+		stored.SignedPreKey = preKey.KeyPair.PublicKey
+		stored.PreKeyPrivateKey = preKey.KeyPair.PrivateKey
+		stored.PreKeySignature = preKey.Signature
+	}
+	return stored
+}
+
+// preKeyFromModel returns the signed prekey of a stored identity, or nil if
+// it has none
+func preKeyFromModel(identity *models.Identity) *crypto.PreKey {
+	if len(identity.SignedPreKey) == 0 || len(identity.PreKeyPrivateKey) == 0 {
+		return nil
+	}
+	return &crypto.PreKey{
+		ID: identity.PreKeyID,
+		KeyPair: crypto.KeyPair{
+			PublicKey:  identity.SignedPreKey,
+			PrivateKey: identity.PreKeyPrivateKey,
+		},
+		Signature: identity.PreKeySignature,
+	}
+}
+
+// ensurePreKey gives an identity stored without a signed prekey one, which
+// peers need to start sessions with it. Identities without their private
+// keys cannot sign one.
+func (a *App) ensurePreKey() error {
+	if a.preKey != nil || len(a.identity.SigningKey.PrivateKey) == 0 {
+		return nil
+	}
+	preKey, err := crypto.GeneratePreKey(1, a.identity)
+	if err != nil {
+		return err
+	}
+	if err := a.storage.SaveIdentity(identityToModel(a.config.User.ID, a.identity, preKey)); err != nil {
+		return fmt.Errorf("failed to save signed prekey: %w", err)
+	}
+	a.preKey = preKey
+	return nil
+}
+
+// loadSessions restores the ratchet sessions kept in storage
+func (a *App) loadSessions() error {
+	sessions, err := a.storage.GetAllSessions()
+	if err != nil {
+		return err
+	}
+
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	for _, session := range sessions {
+		var ratchet crypto.DoubleRatchet
+		if err := json.Unmarshal(session.SessionState, &ratchet); err != nil {
+			log.Printf("Warning: skipping unreadable session with %s: %v", session.RemoteUserID, err)
+			continue
+		}
+		a.sessions[session.RemoteUserID] = &ratchet
+		// Zero for sessions stored by older versions, which are re-keyed
+		// on first use
+		a.keyedAt[session.RemoteUserID] = session.CreatedAt
+	}
+
+	return nil
+}
+
+// saveSession stores a ratchet session, replacing any session in memory
+func (a *App) saveSession(remoteUserID string, ratchet *crypto.DoubleRatchet) error {
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	return a.saveSessionLocked(remoteUserID, ratchet)
+}
+
+// saveSessionLocked is saveSession for callers holding sessionsMux, such as
+// those that just advanced the ratchet
+func (a *App) saveSessionLocked(remoteUserID string, ratchet *crypto.DoubleRatchet) error {
+	state, err := json.Marshal(ratchet)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	defer crypto.Zeroize(state)
+
+	now := time.Now()
+	session := &models.Session{
+		ID:              a.getChatID(a.config.User.ID, remoteUserID),
+		LocalUserID:     a.config.User.ID,
+		RemoteUserID:    remoteUserID,
+		SessionState:    state,
+		MessageNumber:   ratchet.MessageNumber,
+		PreviousCounter: ratchet.PreviousCounter,
+		CreatedAt:       a.keyedAt[remoteUserID],
+		UpdatedAt:       now,
+		LastUsed:        now,
+	}
+	if err := a.storage.SaveSession(session); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	if old, exists := a.sessions[remoteUserID]; exists && old != ratchet {
+		old.Wipe()
+	}
+	a.sessions[remoteUserID] = ratchet
+	return nil
+}
+
+// restoreSession stores a session carried over from another device. It
+// keeps when the session was keyed, so that it is not re-keyed early;
+// bundles from older versions carry no time and are re-keyed on first use.
+func (a *App) restoreSession(remoteUserID string, ratchet *crypto.DoubleRatchet, keyedAt time.Time) error {
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	a.keyedAt[remoteUserID] = keyedAt
+	return a.saveSessionLocked(remoteUserID, ratchet)
+}
+
+// copySessions returns a snapshot of the ratchet sessions in memory. Each
+// is a deep copy made under sessionsMux, so sending and receiving may go on
+// advancing the live sessions while the snapshot is encoded; callers wipe
+// the copies when done.
+func (a *App) copySessions() (map[string]*crypto.DoubleRatchet, error) {
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	sessions := make(map[string]*crypto.DoubleRatchet, len(a.sessions))
+	for remoteUserID, ratchet := range a.sessions {
+		state, err := json.Marshal(ratchet)
+		if err != nil {
+			wipeSessions(sessions)
+			return nil, fmt.Errorf("failed to encode session with %s: %w", remoteUserID, err)
+		}
+		var copied crypto.DoubleRatchet
+		err = json.Unmarshal(state, &copied)
+		crypto.Zeroize(state)
+		if err != nil {
+			wipeSessions(sessions)
+			return nil, fmt.Errorf("failed to copy session with %s: %w", remoteUserID, err)
+		}
+		sessions[remoteUserID] = &copied
+	}
+	return sessions, nil
+}
+
+// wipeSessions wipes the keys of copied sessions
+func wipeSessions(sessions map[string]*crypto.DoubleRatchet) {
+	for _, ratchet := range sessions {
+		ratchet.Wipe()
+	}
+}
+
+// preferredCipher resolves the name of the configured message cipher. An
+// empty setting means the default.
+func preferredCipher(name string) (crypto.CipherID, error) {
+	if name == "" {
+		return crypto.DefaultCipher, nil
+	}
+
+	c, err := crypto.CipherByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cipher %q: %w", name, err)
+	}
+	return c.ID(), nil
+}
+
+// CipherPreferences returns the ciphers to offer in a key exchange, the
+// configured one first
+func (a *App) CipherPreferences() []crypto.CipherID {
+	return crypto.CipherPreferences(a.cipher)
+}
+
+// newSession creates a ratchet session from the shared secret of a key
+// exchange. The initiator passes a nil localRatchetKey, and the responder
+// the prekey the initiator used. The cipher is negotiated from both peers'
+// offers with the initiator's preference first, so both ends pick the same
+// one.
+func (a *App) newSession(sharedSecret []byte, localRatchetKey *crypto.KeyPair, remotePublicKey []byte, remoteCiphers []crypto.CipherID) (*crypto.DoubleRatchet, error) {
+	local := a.CipherPreferences()
+	if localRatchetKey == nil {
+		cipherID, err := crypto.NegotiateCipher(local, remoteCiphers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to agree on a cipher: %w", err)
+		}
+		return crypto.NewDoubleRatchet(sharedSecret, remotePublicKey, cipherID)
+	}
+
+	cipherID, err := crypto.NegotiateCipher(remoteCiphers, local)
+	if err != nil {
+		return nil, fmt.Errorf("failed to agree on a cipher: %w", err)
+	}
+	return crypto.NewResponderRatchet(sharedSecret, *localRatchetKey, remotePublicKey, cipherID)
+}
+
+// startSession stores a new ratchet session, replacing any earlier one.
+// The earlier session is retired rather than wiped: messages the peer sent
+// under it before the key exchange reached them can still be read.
+func (a *App) startSession(remoteUserID string, ratchet *crypto.DoubleRatchet) error {
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	old, keyedAt := a.sessions[remoteUserID], a.keyedAt[remoteUserID]
+	delete(a.sessions, remoteUserID) // Keeps the save from wiping it
+	a.keyedAt[remoteUserID] = time.Now()
+	if err := a.saveSessionLocked(remoteUserID, ratchet); err != nil {
+		if old != nil {
+			a.sessions[remoteUserID] = old
+		}
+		a.keyedAt[remoteUserID] = keyedAt
+		return err
+	}
+	if old != nil && old != ratchet {
+		if previous := a.retired[remoteUserID]; previous != nil {
+			previous.Wipe()
+		}
+		a.retired[remoteUserID] = old
+	}
+	// A new key exchange ends a downgrade of the previous session
+	delete(a.downgraded, remoteUserID)
+	return nil
+}
+
+// rekeyDue reports whether the session with a user has outlived the
+// session max age or carried the session max messages, so the next message
+// waits for a new key exchange instead. The session stays in use for
+// receiving until the exchange completes.
+func (a *App) rekeyDue(userID string) bool {
+	maxAge, maxMessages := a.config.Security.SessionMaxAge, a.config.Security.SessionMaxMessages
+
+	a.sessionsMux.Lock()
+	defer a.sessionsMux.Unlock()
+
+	ratchet := a.sessions[userID]
+	if ratchet == nil {
+		return false
+	}
+	if maxAge > 0 && time.Since(a.keyedAt[userID]) > maxAge {
+		return true
+	}
+	messages := int(ratchet.SendingChain.MessageNumber) + int(ratchet.ReceivingChain.MessageNumber)
+	return maxMessages > 0 && messages >= maxMessages
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// ErrForgedSender is wrapped by errors about received messages that were
+// not signed by the identity key of the user they claim to be from
+var ErrForgedSender = errors.New("message was not signed by its sender")
+
+// messageSignatureContext separates message signatures from other data
+// signed with the identity key
+const messageSignatureContext = "securechat-message"
+
+// messageSignedData returns what the signature of a message covers: its
+// envelope and its payload as sent, which for an encrypted message is the
+// ciphertext. User IDs are normalized, as the relay normalizes them, and a
+// payload is encoded with sorted keys, so the receiver reproduces the
+// sender's encoding.
+func messageSignedData(netMsg *network.Message) ([]byte, error) {
+	payload, err := json.Marshal(netMsg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	from, to := models.NormalizeUserID(netMsg.From), models.NormalizeUserID(netMsg.To)
+	data := []byte(messageSignatureContext + "\n" + netMsg.Type + "\n" + netMsg.ID + "\n" +
+		from + "\n" + to + "\n" + strconv.FormatInt(netMsg.Timestamp, 10) + "\n")
+	return append(data, payload...), nil
+}
+
+// signMessage signs a message to be sent with the identity key
+func (a *App) signMessage(netMsg *network.Message) error {
+	data, err := messageSignedData(netMsg)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+	netMsg.Signature = base64.StdEncoding.EncodeToString(a.identity.Sign(data))
+	return nil
+}
+
+// checkSender verifies that a received message was signed by the identity
+// key on record for the user it claims to be from, so a relay cannot
+// attribute a message to someone else. A message from a user with no key
+// on record, such as the first from a stranger, cannot be checked and is
+// accepted; their key is recorded by the key exchange that follows, under
+// the key acceptance policy. Once a key is on record, every message must be
+// signed with it, encrypted or not, so stripping the signature does not get
+// a message past the check. Messages that fail are recorded in the audit
+// trail.
+func (a *App) checkSender(from string, netMsg *network.Message) error {
+	contact, exists := a.GetContact(from)
+	if !exists || len(contact.PublicKey) == 0 {
+		return nil
+	}
+
+	var reason string
+	switch {
+	case netMsg.Signature == "":
+		reason = "unsigned message"
+	default:
+		signature, err := base64.StdEncoding.DecodeString(netMsg.Signature)
+		data, dataErr := messageSignedData(netMsg)
+		switch {
+		case err != nil, dataErr != nil:
+			reason = "unreadable signature"
+		case !crypto.VerifySignature(contact.PublicKey, data, signature):
+			reason = "signature does not match the sender's identity key"
+		default:
+			return nil
+		}
+	}
+
+	a.audit(models.AuditForgedSender, from, fmt.Sprintf("message %s: %s", netMsg.ID, reason))
+	return fmt.Errorf("%w: %s", ErrForgedSender, reason)
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// signedChat builds a chat message from bob to alice signed with key, or
+// unsigned if key is nil
+func signedChat(t *testing.T, id string, payload network.ChatPayload, key *crypto.IdentityKeyPair) *network.Message {
+	t.Helper()
+
+	fields, err := network.EncodePayload(payload)
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	msg := &network.Message{ID: id, Type: network.MessageTypeChat, From: "bob", To: "alice", Timestamp: time.Now().Unix(), Payload: fields}
+	if key != nil {
+		data, err := messageSignedData(msg)
+		if err != nil {
+			t.Fatalf("messageSignedData: %v", err)
+		}
+		msg.Signature = base64.StdEncoding.EncodeToString(key.Sign(data))
+	}
+	return msg
+}
+
+// bobWithKey gives alice a contact bob with a fresh identity key, returning
+// the key
+func bobWithKey(t *testing.T, alice *App) *crypto.IdentityKeyPair {
+	t.Helper()
+
+	identity, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	bob := &models.Contact{
+		UserID:      "bob",
+		PublicKey:   identity.SigningKey.PublicKey,
+		ExchangeKey: identity.ExchangeKey.PublicKey,
+		Fingerprint: identity.Fingerprint,
+	}
+	if err := alice.saveContact(bob); err != nil {
+		t.Fatalf("saveContact: %v", err)
+	}
+	return identity
+}
+
+// forgedSenderEvents counts the forged sender entries in the audit trail
+func forgedSenderEvents(t *testing.T, app *App) int {
+	t.Helper()
+
+	events, err := app.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog: %v", err)
+	}
+	count := 0
+	for _, event := range events {
+		if event.Action == models.AuditForgedSender && event.UserID == "bob" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSignedMessageIsAttributedToItsSender(t *testing.T) {
+	alice := newTestApp(t, "alice")
+	key := bobWithKey(t, alice)
+
+	if err := alice.handleNetworkMessage("relay", signedChat(t, "m1", network.ChatPayload{Content: "hi"}, key)); err != nil {
+		t.Fatalf("handleNetworkMessage: %v", err)
+	}
+	messages, err := alice.GetMessages("bob", 10)
+	if err != nil || len(messages) != 1 || messages[0].From != "bob" || messages[0].Content != "hi" {
+		t.Fatalf("messages from bob = %v, %v, want the signed one", messages, err)
+	}
+	if forgedSenderEvents(t, alice) != 0 {
+		t.Error("a correctly signed message was audited as forged")
+	}
+}
+
+func TestMessagesNotSignedByTheKnownKeyAreDropped(t *testing.T) {
+	other, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	header := &network.RatchetHeader{RatchetKey: make([]byte, 32), MessageNumber: 1}
+
+	tests := []struct {
+		name    string
+		message func(key *crypto.IdentityKeyPair) *network.Message
+	}{
+		{"signed with another key", func(*crypto.IdentityKeyPair) *network.Message {
+			return signedChat(t, "m1", network.ChatPayload{Content: "hi"}, other)
+		}},
+		{"plaintext with its signature stripped", func(key *crypto.IdentityKeyPair) *network.Message {
+			msg := signedChat(t, "m1", network.ChatPayload{Content: "hi"}, key)
+			msg.Signature = ""
+			return msg
+		}},
+		{"encrypted with its signature stripped", func(key *crypto.IdentityKeyPair) *network.Message {
+			msg := signedChat(t, "m1", network.ChatPayload{Header: header, Ciphertext: []byte("sealed")}, key)
+			msg.Signature = ""
+			return msg
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := newTestApp(t, "alice")
+			key := bobWithKey(t, alice)
+
+			err := alice.handleNetworkMessage("relay", tt.message(key))
+			if !errors.Is(err, ErrForgedSender) {
+				t.Errorf("handleNetworkMessage = %v, want ErrForgedSender", err)
+			}
+			if messages, _ := alice.GetMessages("bob", 10); len(messages) != 0 {
+				t.Errorf("the message was kept as %+v", messages[0])
+			}
+			if got := forgedSenderEvents(t, alice); got != 1 {
+				t.Errorf("%d forged sender entries in the audit trail, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"log"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// SyncHandler is called as messages that relays queued while we were
+// offline arrive, with the progress across all relays
+type SyncHandler func(progress network.SyncProgress)
+
+// AddSyncHandler adds a queued message sync progress handler
+func (a *App) AddSyncHandler(handler SyncHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+	a.syncHandlers = append(a.syncHandlers, handler)
+}
+
+// SetActiveChat tells the app which chat is open, so that its queued
+// messages are fetched before those of other chats after a reconnect, and
+// its messages do not notify and are read. An empty user ID means no chat
+// is open.
+func (a *App) SetActiveChat(otherUserID string) {
+	var priority []string
+	if otherUserID != "" {
+		otherUserID = models.NormalizeUserID(otherUserID)
+		priority = []string{otherUserID}
+	}
+	a.connections.SetSyncPriority(priority)
+	a.notifications.acknowledge(otherUserID)
+	a.unread.SetOpen(otherUserID)
+	if otherUserID == "" {
+		return
+	}
+
+	// Views call this as the chat opens, so the messages are marked read
+	// in the background
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		if err := a.MarkConversationRead(otherUserID); err != nil {
+			log.Printf("Warning: failed to mark the chat with %s read: %v", otherUserID, err)
+		}
+	}()
+}
+
+// handleSyncProgress combines the sync progress of each relay and passes it
+// to the sync handlers
+func (a *App) handleSyncProgress(via string, progress network.SyncProgress) {
+	a.syncMux.Lock()
+	if progress.Done() {
+		delete(a.syncProgress, via)
+	} else {
+		a.syncProgress[via] = progress
+	}
+	var total network.SyncProgress
+	for _, p := range a.syncProgress {
+		total.Delivered += p.Delivered
+		total.Total += p.Total
+	}
+	a.syncMux.Unlock()
+
+	a.handlersMux.RLock()
+	handlers := a.syncHandlers
+	a.handlersMux.RUnlock()
+	for _, handler := range handlers {
+		handler(total)
+	}
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path/filepath"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/internal/redact"
+	"github.com/opensourceghana/securechat/pkg/network"
+	"github.com/opensourceghana/securechat/pkg/transfer"
+)
+
+// File transfer message types
+const (
+	messageTypeFileOffer    = "file_offer"
+	messageTypeFileRequest  = "file_request"
+	messageTypeFileChunk    = "file_chunk"
+	messageTypeFileComplete = "file_complete"
+)
+
+// transferWindow is the number of chunks requested at a time, which keeps
+// the sender's outgoing queue from overflowing on large files
+const transferWindow = 32
+
+// maxFileOffers is how many offered files may await the user's answer at
+// a time
+const maxFileOffers = 20
+
+// FileOffer is a file a contact offered to send, which is only received
+// once the user accepts it
+type FileOffer struct {
+	TransferID string
+	From       string
+	Filename   string
+	Size       int64
+}
+
+// FileOfferHandler is called when a contact offers a file
+type FileOfferHandler func(offer FileOffer)
+
+// fileOffer is an offered file awaiting the user's answer
+type fileOffer struct {
+	from     string
+	manifest transfer.Manifest
+}
+
+// sealedTransfer carries a file transfer payload encrypted with the session
+// with the peer, as chat messages are
+type sealedTransfer struct {
+	Header     *network.RatchetHeader `json:"header"`
+	Ciphertext []byte                 `json:"ciphertext"`
+}
+
+// SendFile offers a local file to a contact. Chunks are sent as the receiver
+// requests them, so an interrupted transfer resumes where it left off. The
+// file is encrypted with the session with the contact, so one must be set
+// up already.
+func (a *App) SendFile(to, path string) (*transfer.Manifest, error) {
+	to = models.NormalizeUserID(to)
+	contact, exists := a.GetContact(to)
+	if !exists || contact.Blocked {
+		return nil, fmt.Errorf("contact not found: %s", to)
+	}
+	if err := a.checkKeyChange(contact, false); err != nil {
+		return nil, err
+	}
+	if !a.hasSession(to) {
+		return nil, fmt.Errorf("%w with %s, send them a message first", errNoSession, to)
+	}
+
+	manifest, err := a.transfers.Offer(path, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.sendTransferPayload(to, messageTypeFileOffer, manifest); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Offered %s (%d bytes) to %s", redact.Content(manifest.Filename), manifest.Size, to)
+	return manifest, nil
+}
+
+// TransferProgress returns the channel of file transfer progress events
+func (a *App) TransferProgress() <-chan transfer.Progress {
+	return a.transfers.Progress()
+}
+
+// AddFileOfferHandler adds a file offer handler
+func (a *App) AddFileOfferHandler(handler FileOfferHandler) {
+	a.handlersMux.Lock()
+	defer a.handlersMux.Unlock()
+
+	a.fileOfferHandlers = append(a.fileOfferHandlers, handler)
+}
+
+// notifyFileOffer passes an offered file to the file offer handlers
+func (a *App) notifyFileOffer(offer FileOffer) {
+	a.handlersMux.RLock()
+	handlers := make([]FileOfferHandler, len(a.fileOfferHandlers))
+	copy(handlers, a.fileOfferHandlers)
+	a.handlersMux.RUnlock()
+
+	for _, handler := range handlers {
+		handler(offer)
+	}
+}
+
+// AcceptFileOffer starts receiving a file a contact offered
+func (a *App) AcceptFileOffer(transferID string) error {
+	offer, err := a.takeFileOffer(transferID)
+	if err != nil {
+		return err
+	}
+
+	destination := a.storage.AttachmentPath(offer.manifest.Filename)
+	if err := a.transfers.Accept(offer.manifest, destination, offer.from); err != nil {
+		return err
+	}
+	log.Printf("Accepted %s from %s", redact.Content(offer.manifest.Filename), offer.from)
+	return a.requestMissingChunks(transferID, offer.from)
+}
+
+// DeclineFileOffer turns down a file a contact offered
+func (a *App) DeclineFileOffer(transferID string) error {
+	offer, err := a.takeFileOffer(transferID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Declined %s from %s", redact.Content(offer.manifest.Filename), offer.from)
+	return nil
+}
+
+// takeFileOffer removes an offered file from those awaiting an answer
+func (a *App) takeFileOffer(transferID string) (*fileOffer, error) {
+	a.fileOffersMux.Lock()
+	defer a.fileOffersMux.Unlock()
+
+	offer, exists := a.fileOffers[transferID]
+	if !exists {
+		return nil, fmt.Errorf("unknown file offer: %s", transferID)
+	}
+	delete(a.fileOffers, transferID)
+	return offer, nil
+}
+
+// handleFileOffer holds a file a contact offered until the user accepts or
+// declines it. Offers from users who are not contacts, or are blocked, are
+// dropped.
+func (a *App) handleFileOffer(from string, manifest transfer.Manifest) error {
+	contact, exists := a.GetContact(from)
+	if !exists || contact.Unknown || contact.Blocked {
+		return fmt.Errorf("dropping file offer from %s: not a contact", from)
+	}
+	if err := manifest.Validate(); err != nil {
+		return fmt.Errorf("dropping file offer from %s: %w", from, err)
+	}
+
+	a.fileOffersMux.Lock()
+	existing, exists := a.fileOffers[manifest.ID]
+	switch {
+	case exists && existing.from != from:
+		a.fileOffersMux.Unlock()
+		return fmt.Errorf("dropping file offer from %s: transfer %s is offered by another user", from, manifest.ID)
+	case !exists && len(a.fileOffers) >= maxFileOffers:
+		a.fileOffersMux.Unlock()
+		return fmt.Errorf("dropping file offer from %s: too many offers awaiting an answer", from)
+	}
+	a.fileOffers[manifest.ID] = &fileOffer{from: from, manifest: manifest}
+	a.fileOffersMux.Unlock()
+
+	log.Printf("%s offered %s (%d bytes)", from, redact.Content(manifest.Filename), manifest.Size)
+	a.notifyFileOffer(FileOffer{
+		TransferID: manifest.ID,
+		From:       from,
+		Filename:   manifest.Filename,
+		Size:       manifest.Size,
+	})
+	return nil
+}
+
+// handleTransferMessage handles the file transfer message types
+func (a *App) handleTransferMessage(netMsg *network.Message) error {
+	from := models.NormalizeUserID(netMsg.From)
+
+	switch netMsg.Type {
+	case messageTypeFileOffer:
+		var manifest transfer.Manifest
+		if err := a.openTransferPayload(netMsg, from, &manifest); err != nil {
+			return err
+		}
+		return a.handleFileOffer(from, manifest)
+
+	case messageTypeFileRequest:
+		var request transfer.ChunkRequest
+		if err := a.openTransferPayload(netMsg, from, &request); err != nil {
+			return err
+		}
+		if len(request.Indices) > transferWindow {
+			request.Indices = request.Indices[:transferWindow]
+		}
+		for _, index := range request.Indices {
+			chunk, err := a.transfers.ReadChunk(request.TransferID, from, index)
+			if err != nil {
+				return err
+			}
+			if err := a.sendTransferPayload(from, messageTypeFileChunk, chunk); err != nil {
+				return err
+			}
+		}
+
+	case messageTypeFileChunk:
+		var chunk transfer.Chunk
+		if err := a.openTransferPayload(netMsg, from, &chunk); err != nil {
+			return err
+		}
+		if a.ContactState(from).Blocked {
+			return fmt.Errorf("dropping file chunk from blocked contact %s", from)
+		}
+		manifest, _ := a.transfers.IncomingManifest(chunk.TransferID)
+		destination, err := a.transfers.WriteChunk(&chunk, from)
+		if err != nil {
+			return err
+		}
+		if destination != "" {
+			log.Printf("File transfer %s from %s complete", chunk.TransferID, from)
+			if manifest != nil {
+				a.recordAttachment(from, manifest, destination)
+			}
+			return a.sendTransferPayload(from, messageTypeFileComplete, transfer.ChunkRequest{TransferID: chunk.TransferID})
+		}
+
+		// Ask for the next window once this one is through, and re-request
+		// anything lost when the final chunk arrives
+		if (chunk.Index+1)%transferWindow == 0 || (manifest != nil && chunk.Index == manifest.ChunkCount()-1) {
+			return a.requestMissingChunks(chunk.TransferID, from)
+		}
+
+	case messageTypeFileComplete:
+		var request transfer.ChunkRequest
+		if err := a.openTransferPayload(netMsg, from, &request); err != nil {
+			return err
+		}
+		return a.transfers.FinishOutgoing(request.TransferID, from)
+	}
+
+	return nil
+}
+
+// openTransferPayload decrypts the payload of a file transfer message from
+// a user into dest. Transfer messages that are not encrypted are refused.
+func (a *App) openTransferPayload(netMsg *network.Message, from string, dest interface{}) error {
+	var sealed sealedTransfer
+	if err := netMsg.DecodePayload(&sealed); err != nil {
+		return err
+	}
+	if sealed.Header == nil {
+		return fmt.Errorf("dropping %s from %s: not encrypted", netMsg.Type, from)
+	}
+	if err := a.openPayload(from, sealed.Header, sealed.Ciphertext, dest); err != nil {
+		return fmt.Errorf("dropping %s from %s: %w", netMsg.Type, from, err)
+	}
+	return nil
+}
+
+// sendTransferPayload encrypts a file transfer payload with the session with
+// a user and sends it
+func (a *App) sendTransferPayload(to, msgType string, payload interface{}) error {
+	header, ciphertext, err := a.sealPayload(to, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", msgType, err)
+	}
+	return a.sendPayload(to, msgType, sealedTransfer{Header: header, Ciphertext: ciphertext})
+}
+
+// recordAttachment adds a file received into path to the chat history with
+// the sender as an attachment message. The message gets an ID of its own,
+// since the transfer ID is the sender's choice and could name another
+// message.
+func (a *App) recordAttachment(from string, manifest *transfer.Manifest, path string) {
+	if a.ContactState(from).Blocked {
+		log.Printf("Not adding file %s from blocked contact %s to the chat", redact.Content(manifest.Filename), from)
+		return
+	}
+
+	msg := models.NewMessage(models.MessageTypeChat, from, a.config.User.ID, filepath.Base(path))
+	msg.ChatID = a.getChatID(from, a.config.User.ID)
+	msg.Metadata = &models.Metadata{
+		Attachment: &models.Attachment{
+			ID:       manifest.ID,
+			Filename: filepath.Base(path),
+			MimeType: mime.TypeByExtension(filepath.Ext(path)),
+			Size:     manifest.Size,
+			Checksum: manifest.Checksum,
+		},
+	}
+
+	unread := a.arrivedUnread(msg)
+	if err := a.storage.SaveMessage(msg); err != nil {
+		log.Printf("Warning: failed to save attachment message: %v", err)
+	} else if unread {
+		a.unread.Add(msg.ChatID)
+	}
+	a.notifyMessageHandlers(msg)
+}
+
+// OpenAttachment opens the local copy of a received attachment
+func (a *App) OpenAttachment(attachment *models.Attachment) (io.ReadCloser, error) {
+	return a.storage.OpenAttachment(attachment)
+}
+
+// resumeTransfers asks senders for chunks still missing from incoming transfers
+func (a *App) resumeTransfers() {
+	for id, peer := range a.transfers.Incomplete() {
+		if err := a.requestMissingChunks(id, peer); err != nil {
+			log.Printf("Failed to resume transfer %s: %v", id, err)
+		}
+	}
+}
+
+// requestMissingChunks asks the sender for the chunks we do not have yet
+func (a *App) requestMissingChunks(transferID, peer string) error {
+	missing, err := a.transfers.Missing(transferID)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(missing) > transferWindow {
+		missing = missing[:transferWindow]
+	}
+
+	return a.sendTransferPayload(peer, messageTypeFileRequest, transfer.ChunkRequest{
+		TransferID: transferID,
+		Indices:    missing,
+	})
+}
+
+// sendPayload sends a control message with a structured payload
+func (a *App) sendPayload(to, msgType string, payload interface{}) error {
+	fields, err := network.EncodePayload(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s payload: %w", msgType, err)
+	}
+
+	_, err = a.connections.Send(&network.Message{
+		Type:      msgType,
+		From:      a.config.User.ID,
+		To:        to,
+		Timestamp: time.Now().Unix(),
+		Payload:   fields,
+	})
+	return err
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// TypingHandler is called when a contact starts or stops typing
+type TypingHandler func(from string, typing bool)
+
+// AddTypingHandler adds a typing indicator handler
+func (a *App) AddTypingHandler(handler TypingHandler) {
+	a.typingHandlers = append(a.typingHandlers, handler)
+}
+
+// handleTyping passes a typing indicator to the typing handlers
+func (a *App) handleTyping(netMsg *network.Message) error {
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping typing indicator %s: %w", netMsg.ID, err)
+	}
+
+	var payload network.TypingPayload
+	if err := netMsg.DecodePayload(&payload); err != nil {
+		return fmt.Errorf("dropping typing indicator %s: %w", netMsg.ID, err)
+	}
+	for _, handler := range a.typingHandlers {
+		handler(from, payload.Typing)
+	}
+
+	return nil
+}
+
+// handlePeerPresence applies a status update sent directly by a contact
+func (a *App) handlePeerPresence(netMsg *network.Message) error {
+	from, err := models.ParseUserID(netMsg.From)
+	if err != nil {
+		return fmt.Errorf("dropping presence %s: %w", netMsg.ID, err)
+	}
+
+	var payload network.PresencePayload
+	if err := netMsg.DecodePayload(&payload); err != nil {
+		return fmt.Errorf("dropping presence %s from %s: %w", netMsg.ID, from, err)
+	}
+	status := payload.Status
+
+	// The peer's own update says nothing about the relay's last-seen
+	// privacy flag, so keep what we know
+	presence := network.Presence{Status: status}
+	if contact, exists := a.GetContact(from); exists {
+		presence.LastSeenHidden = contact.LastSeenHidden
+	}
+
+	a.handlePresence(map[string]network.Presence{from: presence})
+	return nil
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/network"
+)
+
+// controlMessage returns a message of msgType from a peer to app's user
+func controlMessage(t *testing.T, app *App, from, msgType string, payload interface{}) *network.Message {
+	t.Helper()
+
+	fields, err := network.EncodePayload(payload)
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	return &network.Message{
+		ID:      msgType + "-1",
+		Type:    msgType,
+		From:    from,
+		To:      app.config.User.ID,
+		Payload: fields,
+	}
+}
+
+func TestControlMessagesAreNotStored(t *testing.T) {
+	app := newTestApp(t, "alice")
+	if err := app.AddContact("bob", "Bob"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	var shown []*models.Message
+	app.AddMessageHandler(func(msg *models.Message) error {
+		shown = append(shown, msg)
+		return nil
+	})
+	var typing []bool
+	app.AddTypingHandler(func(userID string, isTyping bool) {
+		typing = append(typing, isTyping)
+	})
+
+	app.handleNetworkMessage(testRelay, controlMessage(t, app, "bob", network.MessageTypeTyping, network.TypingPayload{Typing: true}))
+	app.handleNetworkMessage(testRelay, controlMessage(t, app, "bob", network.MessageTypePresence, network.PresencePayload{Status: "away"}))
+	if err := app.handleNetworkMessage(testRelay, controlMessage(t, app, "bob", string(models.MessageTypeSystem), network.ChatPayload{Content: "Safety number verified"})); err == nil {
+		t.Error("a system notice from a peer was accepted")
+	}
+
+	if len(typing) != 1 || !typing[0] {
+		t.Errorf("typing handlers got %v, want [true]", typing)
+	}
+	if contact, _ := app.GetContact("bob"); contact.Status != models.UserStatusAway {
+		t.Errorf("bob's status = %s, want away", contact.Status)
+	}
+	if len(shown) != 0 {
+		t.Errorf("message handlers got %d control messages", len(shown))
+	}
+	stored, err := app.storage.GetMessages(app.getChatID("alice", "bob"), 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("GetMessages = %d messages, want none", len(stored))
+	}
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// vCard properties carrying a SecureChat identity. Cards without a user ID
+// cannot be messaged and are skipped on import.
+const (
+	vCardUserID      = "X-SECURECHAT-UID"
+	vCardKey         = "X-SECURECHAT-KEY"
+	vCardExchangeKey = "X-SECURECHAT-EXCHANGE-KEY"
+	vCardFingerprint = "X-SECURECHAT-FINGERPRINT"
+)
+
+// vCardLineLength is the length in octets lines are folded at, as RFC 6350
+// recommends
+const vCardLineLength = 75
+
+// ExportContactsVCard writes every contact as a vCard 4.0, with the display
+// name, nickname and notes in standard properties and the user ID and
+// identity key in X-SECURECHAT ones
+func (a *App) ExportContactsVCard(w io.Writer) error {
+	contacts := a.GetContacts()
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].UserID < contacts[j].UserID
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, contact := range contacts {
+		// vCard requires a formatted name
+		name := contact.DisplayName
+		if name == "" {
+			name = contact.UserID
+		}
+
+		writeVCardLine(bw, "BEGIN", "VCARD")
+		writeVCardLine(bw, "VERSION", "4.0")
+		writeVCardLine(bw, "FN", escapeVCard(name))
+		if contact.Nickname != "" {
+			writeVCardLine(bw, "NICKNAME", escapeVCard(contact.Nickname))
+		}
+		if contact.Notes != "" {
+			writeVCardLine(bw, "NOTE", escapeVCard(contact.Notes))
+		}
+		writeVCardLine(bw, vCardUserID, escapeVCard(contact.UserID))
+		if len(contact.PublicKey) > 0 {
+			writeVCardLine(bw, vCardKey, base64.StdEncoding.EncodeToString(contact.PublicKey))
+		}
+		if len(contact.ExchangeKey) > 0 {
+			writeVCardLine(bw, vCardExchangeKey, base64.StdEncoding.EncodeToString(contact.ExchangeKey))
+		}
+		if contact.Fingerprint != "" {
+			writeVCardLine(bw, vCardFingerprint, escapeVCard(contact.Fingerprint))
+		}
+		writeVCardLine(bw, "END", "VCARD")
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write contacts: %w", err)
+	}
+	return nil
+}
+
+// ImportContactsVCard adds the contacts of the vCards read from r and
+// returns how many were imported and how many cards were skipped. Cards
+// without a valid SecureChat user ID are skipped. For contacts that already
+// exist only empty fields are filled in; a known identity key is never
+// replaced, and imported keys are not verified. A key is imported only with
+// its exchange key, from which the fingerprint is computed; a card stating
+// another fingerprint is skipped.
+func (a *App) ImportContactsVCard(r io.Reader) (int, int, error) {
+	cards, err := parseVCards(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imported, skipped := 0, 0
+	for _, card := range cards {
+		contact, err := contactFromVCard(card)
+		if err != nil {
+			log.Printf("Skipping vCard %q: %v", card["FN"], err)
+			skipped++
+			continue
+		}
+		if err := a.importContact(contact); err != nil {
+			return imported, skipped, err
+		}
+		imported++
+	}
+
+	if imported > 0 {
+		a.syncPresenceSubscriptions()
+	}
+	log.Printf("Imported %d contacts from vCards, skipped %d", imported, skipped)
+	return imported, skipped, nil
+}
+
+// importContact adds an imported contact, or fills in the empty fields of
+// the existing one
+func (a *App) importContact(imported *models.Contact) error {
+	err := a.updateContact(imported.UserID, func(contact *models.Contact) bool {
+		changed := false
+		fill := func(field *string, value string) {
+			if *field == "" && value != "" {
+				*field = value
+				changed = true
+			}
+		}
+		fill(&contact.DisplayName, imported.DisplayName)
+		fill(&contact.Nickname, imported.Nickname)
+		fill(&contact.Notes, imported.Notes)
+		if len(contact.PublicKey) == 0 && len(imported.PublicKey) > 0 {
+			contact.PublicKey = imported.PublicKey
+			contact.ExchangeKey = imported.ExchangeKey
+			contact.Fingerprint = imported.Fingerprint
+			changed = true
+		}
+		return changed
+	})
+	if !errors.Is(err, errContactNotFound) {
+		return err
+	}
+
+	imported.Status = models.UserStatusOffline
+	return a.saveContact(imported)
+}
+
+// contactFromVCard converts a parsed vCard to a contact
+func contactFromVCard(card map[string]string) (*models.Contact, error) {
+	rawID, ok := card[vCardUserID]
+	if !ok {
+		return nil, errors.New("no SecureChat user ID")
+	}
+	userID, err := models.ParseUserID(rawID)
+	if err != nil {
+		return nil, err
+	}
+
+	contact := &models.Contact{
+		UserID:      userID,
+		DisplayName: card["FN"],
+		Nickname:    card["NICKNAME"],
+		Notes:       card["NOTE"],
+	}
+	if contact.DisplayName == userID {
+		// Exported for a contact without a display name
+		contact.DisplayName = ""
+	}
+	if card[vCardKey] == "" {
+		return contact, nil
+	}
+
+	signingKey, err := base64.StdEncoding.DecodeString(card[vCardKey])
+	if err != nil || len(signingKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid identity key")
+	}
+	if card[vCardExchangeKey] == "" {
+		// The fingerprint cannot be checked without the exchange key, so
+		// the key is left to the first key exchange
+		log.Printf("Not importing the identity key of %s: the vCard has no exchange key", userID)
+		return contact, nil
+	}
+	exchangeKey, err := base64.StdEncoding.DecodeString(card[vCardExchangeKey])
+	if err != nil || len(exchangeKey) != 32 {
+		return nil, errors.New("invalid exchange key")
+	}
+	fingerprint := crypto.Fingerprint(signingKey, exchangeKey)
+	if stated := card[vCardFingerprint]; stated != "" && stated != fingerprint {
+		return nil, errors.New("fingerprint does not match the keys")
+	}
+	contact.PublicKey = signingKey
+	contact.ExchangeKey = exchangeKey
+	contact.Fingerprint = fingerprint
+	return contact, nil
+}
+
+// parseVCards reads vCards, returning the unescaped value of the first
+// occurrence of each property by its upper-case name. Groups and parameters
+// are dropped; cards missing their END line are ignored.
+func parseVCards(r io.Reader) ([]map[string]string, error) {
+	lines, err := unfoldVCardLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []map[string]string
+	var card map[string]string
+	for _, line := range lines {
+		nameAndParams, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		name, _, _ := strings.Cut(nameAndParams, ";")
+		if _, after, grouped := strings.Cut(name, "."); grouped {
+			name = after
+		}
+		name = strings.ToUpper(strings.TrimSpace(name))
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			card = make(map[string]string)
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if card != nil {
+				cards = append(cards, card)
+			}
+			card = nil
+		case card != nil:
+			if _, seen := card[name]; !seen {
+				card[name] = unescapeVCard(value)
+			}
+		}
+	}
+	return cards, nil
+}
+
+// unfoldVCardLines reads the logical lines of a vCard file. A line starting
+// with a space or tab continues the previous one.
+func unfoldVCardLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vCards: %w", err)
+	}
+	return lines, nil
+}
+
+// writeVCardLine writes a property, folding it at vCardLineLength octets
+// without splitting a UTF-8 sequence
+func writeVCardLine(w *bufio.Writer, name, value string) {
+	line := name + ":" + value
+	limit := vCardLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The leading space of a continuation counts towards its length
+		limit = vCardLineLength - 1
+	}
+	w.WriteString(line + "\r\n")
+}
+
+var (
+	vCardEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`)
+	vCardUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";")
+)
+
+// escapeVCard escapes a text value
+func escapeVCard(value string) string {
+	return vCardEscaper.Replace(strings.ReplaceAll(value, "\r\n", "\n"))
+}
+
+// unescapeVCard reverses escapeVCard
+func unescapeVCard(value string) string {
+	return vCardUnescaper.Replace(value)
+}
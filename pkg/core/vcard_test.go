@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// keyedContact returns a contact with a fresh identity key
+func keyedContact(t *testing.T, userID string) *models.Contact {
+	t.Helper()
+
+	identity, err := crypto.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	return &models.Contact{
+		UserID:      userID,
+		PublicKey:   identity.SigningKey.PublicKey,
+		ExchangeKey: identity.ExchangeKey.PublicKey,
+		Fingerprint: identity.Fingerprint,
+	}
+}
+
+func TestVCardExportRoundTrips(t *testing.T) {
+	alice := newTestApp(t, "alice")
+	bob := keyedContact(t, "bob")
+	bob.DisplayName = "Bob Mensah, Jr."
+	bob.Nickname = "bobby; the builder"
+	bob.Notes = strings.Repeat("Met at the Accra meetup. ", 6) + "\nLikes jollof 🍚"
+	bob.Verified = true
+	for _, contact := range []*models.Contact{bob, {UserID: "carol"}} {
+		if err := alice.saveContact(contact); err != nil {
+			t.Fatalf("saveContact: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := alice.ExportContactsVCard(&buf); err != nil {
+		t.Fatalf("ExportContactsVCard: %v", err)
+	}
+	exported := buf.String()
+
+	// Valid cards: CRLF lines of at most 75 octets, each card complete
+	if !strings.HasSuffix(exported, "\r\n") {
+		t.Error("the export does not end in CRLF")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(exported, "\r\n"), "\r\n") {
+		if len(line) > vCardLineLength {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if strings.Contains(line, "\n") {
+			t.Errorf("line %q has a bare newline", line)
+		}
+	}
+	for _, want := range []string{"BEGIN:VCARD", "VERSION:4.0", "END:VCARD"} {
+		if got := strings.Count(exported, want+"\r\n"); got != 2 {
+			t.Errorf("%s appears %d times, want once per card", want, got)
+		}
+	}
+	if !strings.Contains(exported, `FN:Bob Mensah\, Jr.`) || !strings.Contains(exported, `NICKNAME:bobby\; the builder`) {
+		t.Error("text values are not escaped")
+	}
+	if !strings.Contains(exported, "FN:carol\r\n") {
+		t.Error("a contact without a display name is not named by its user ID")
+	}
+
+	charlie := newTestApp(t, "charlie")
+	imported, skipped, err := charlie.ImportContactsVCard(strings.NewReader(exported))
+	if err != nil || imported != 2 || skipped != 0 {
+		t.Fatalf("ImportContactsVCard = %d, %d, %v, want 2 imported", imported, skipped, err)
+	}
+
+	got, ok := charlie.GetContact("bob")
+	if !ok {
+		t.Fatal("bob was not imported")
+	}
+	if got.DisplayName != bob.DisplayName || got.Nickname != bob.Nickname || got.Notes != bob.Notes {
+		t.Errorf("imported %q, %q, %q, want %q, %q, %q", got.DisplayName, got.Nickname, got.Notes, bob.DisplayName, bob.Nickname, bob.Notes)
+	}
+	if !bytes.Equal(got.PublicKey, bob.PublicKey) || !bytes.Equal(got.ExchangeKey, bob.ExchangeKey) || got.Fingerprint != bob.Fingerprint {
+		t.Error("the identity key did not round-trip")
+	}
+	if got.Verified {
+		t.Error("an imported key is marked verified")
+	}
+	if carol, ok := charlie.GetContact("carol"); !ok || carol.DisplayName != "" {
+		t.Errorf("carol imported as %+v, want no display name", carol)
+	}
+}
+
+func TestVCardImport(t *testing.T) {
+	dave := keyedContact(t, "dave")
+	key := base64.StdEncoding.EncodeToString(dave.PublicKey)
+	exchangeKey := base64.StdEncoding.EncodeToString(dave.ExchangeKey)
+	cards := strings.Join([]string{
+		// From an address book, with no SecureChat identity
+		"BEGIN:VCARD", "VERSION:3.0", "N:Owusu;Ama;;;", "FN:Ama Owusu", "TEL;TYPE=CELL:+233 20 000 0000", "END:VCARD",
+		// Grouped, with parameters and a folded note
+		"BEGIN:VCARD", "VERSION:4.0", "FN:Erin", "item1.X-SECURECHAT-UID;TYPE=pref:erin",
+		"NOTE:first line\\nsecond", "  line", "END:VCARD",
+		// With keys and a matching fingerprint
+		"BEGIN:VCARD", "VERSION:4.0", "FN:Dave", "X-SECURECHAT-UID:dave", "X-SECURECHAT-KEY:" + key,
+		"X-SECURECHAT-EXCHANGE-KEY:" + exchangeKey, "X-SECURECHAT-FINGERPRINT:" + dave.Fingerprint, "END:VCARD",
+		// With a fingerprint that is not of its keys
+		"BEGIN:VCARD", "VERSION:4.0", "FN:Mallory", "X-SECURECHAT-UID:mallory", "X-SECURECHAT-KEY:" + key,
+		"X-SECURECHAT-EXCHANGE-KEY:" + exchangeKey, "X-SECURECHAT-FINGERPRINT:" + strings.Repeat("00", 32), "END:VCARD",
+		// Filling in an existing contact
+		"BEGIN:VCARD", "VERSION:4.0", "FN:Someone Else", "NICKNAME:frankie", "X-SECURECHAT-UID:frank", "END:VCARD",
+		// Cut short
+		"BEGIN:VCARD", "VERSION:4.0", "FN:Grace", "X-SECURECHAT-UID:grace",
+	}, "\r\n")
+
+	alice := newTestApp(t, "alice")
+	if err := alice.saveContact(&models.Contact{UserID: "frank", DisplayName: "Frank"}); err != nil {
+		t.Fatalf("saveContact: %v", err)
+	}
+	imported, skipped, err := alice.ImportContactsVCard(strings.NewReader(cards))
+	if err != nil || imported != 3 || skipped != 2 {
+		t.Fatalf("ImportContactsVCard = %d, %d, %v, want 3 imported and 2 skipped", imported, skipped, err)
+	}
+
+	if erin, ok := alice.GetContact("erin"); !ok || erin.Notes != "first line\nsecond line" {
+		t.Errorf("erin imported as %+v, want the unfolded note", erin)
+	}
+	if got, ok := alice.GetContact("dave"); !ok || got.Fingerprint != dave.Fingerprint {
+		t.Errorf("dave imported as %+v, want the card's key", got)
+	}
+	if frank, _ := alice.GetContact("frank"); frank.DisplayName != "Frank" || frank.Nickname != "frankie" {
+		t.Errorf("frank = %q (%q), want the name kept and the nickname filled in", frank.DisplayName, frank.Nickname)
+	}
+	for _, userID := range []string{"mallory", "grace"} {
+		if _, ok := alice.GetContact(userID); ok {
+			t.Errorf("%s was imported", userID)
+		}
+	}
+}
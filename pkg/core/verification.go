@@ -0,0 +1,250 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// ErrUnverifiedKeyChange is returned when sending to a contact whose identity
+// key changed and has not been verified again, while verification is required
+var ErrUnverifiedKeyChange = errors.New("contact's identity key changed and has not been verified")
+
+// errContactNotFound is wrapped by errors about unknown contacts
+var errContactNotFound = errors.New("contact not found")
+
+// ContactHandler is called when a contact is added or its details,
+// presence or verification state change. Contact event handlers also learn
+// of removals and what kind of change it was.
+type ContactHandler func(*models.Contact)
+
+// AddContactHandler adds a contact change handler
+func (a *App) AddContactHandler(handler ContactHandler) {
+	a.contactHandlers = append(a.contactHandlers, handler)
+}
+
+// GetContact returns a copy of a contact by user ID
+func (a *App) GetContact(userID string) (*models.Contact, bool) {
+	a.contactsMux.RLock()
+	defer a.contactsMux.RUnlock()
+
+	contact, exists := a.contacts[models.NormalizeUserID(userID)]
+	if !exists {
+		return nil, false
+	}
+	copied := *contact
+	return &copied, true
+}
+
+// UpdateContactKey records a contact's identity key as the auto-accept
+// setting says. With "never", a key replacing a different one costs the
+// contact its verified state and flags it as having changed keys until the
+// user verifies them again. With "ask", the key is held as pending until
+// AcceptPendingKey or RejectPendingKey is called. With "always", it is
+// accepted, though a changed key is no longer verified. The key is given as
+// the signing and exchange halves its fingerprint is computed from.
+func (a *App) UpdateContactKey(userID string, publicKey, exchangeKey []byte) error {
+	fingerprint := crypto.Fingerprint(publicKey, exchangeKey)
+	mode := a.config.Security.AutoAcceptKeys
+	return a.updateContact(userID, func(contact *models.Contact) bool {
+		if crypto.FingerprintsMatch(contact.Fingerprint, fingerprint) {
+			// The same key; keep the longer form of its fingerprint, and the
+			// exchange key if it was not recorded
+			if len(fingerprint) <= len(contact.Fingerprint) && len(contact.ExchangeKey) > 0 {
+				return false
+			}
+			if len(fingerprint) > len(contact.Fingerprint) {
+				log.Printf("Upgraded stored fingerprint of %s to its full length", contact.UserID)
+				contact.Fingerprint = fingerprint
+			}
+			contact.ExchangeKey = exchangeKey
+			return true
+		}
+
+		switch mode {
+		case config.KeyAcceptAsk:
+			if crypto.FingerprintsMatch(contact.PendingFingerprint, fingerprint) {
+				return false
+			}
+			log.Printf("Identity key for %s awaits acceptance", contact.UserID)
+			contact.PendingKey = publicKey
+			contact.PendingExchangeKey = exchangeKey
+			contact.PendingFingerprint = fingerprint
+		case config.KeyAcceptAlways:
+			a.applyContactKey(contact, publicKey, exchangeKey, fingerprint, "accepted automatically")
+		default:
+			a.applyContactKey(contact, publicKey, exchangeKey, fingerprint, "")
+		}
+		return true
+	})
+}
+
+// AcceptPendingKey accepts the identity key a contact presented while keys
+// are accepted only when asked
+func (a *App) AcceptPendingKey(userID string) error {
+	return a.updateContact(userID, func(contact *models.Contact) bool {
+		if contact.PendingFingerprint == "" {
+			return false
+		}
+		a.audit(models.AuditKeyAccepted, contact.UserID, "fingerprint "+contact.PendingFingerprint)
+		a.applyContactKey(contact, contact.PendingKey, contact.PendingExchangeKey, contact.PendingFingerprint, "accepted when asked")
+		return true
+	})
+}
+
+// RejectPendingKey discards the identity key a contact presented while keys
+// are accepted only when asked. The contact keeps its previous key, if any.
+func (a *App) RejectPendingKey(userID string) error {
+	return a.updateContact(userID, func(contact *models.Contact) bool {
+		if contact.PendingFingerprint == "" {
+			return false
+		}
+		a.audit(models.AuditKeyRejected, contact.UserID, "fingerprint "+contact.PendingFingerprint)
+		contact.PendingKey = nil
+		contact.PendingExchangeKey = nil
+		contact.PendingFingerprint = ""
+		return true
+	})
+}
+
+// applyContactKey makes a key the contact's identity key and clears any
+// pending one. A key replacing a different one is recorded in the audit
+// trail and is not verified; unless it was accepted, which the reason says,
+// the contact is also flagged until the user verifies them again.
+func (a *App) applyContactKey(contact *models.Contact, publicKey, exchangeKey []byte, fingerprint, accepted string) {
+	if contact.Fingerprint != "" && !crypto.FingerprintsMatch(contact.Fingerprint, fingerprint) {
+		log.Printf("Warning: identity key for %s changed", contact.UserID)
+		detail := fmt.Sprintf("fingerprint %s replaced by %s", contact.Fingerprint, fingerprint)
+		if accepted != "" {
+			detail += ", " + accepted
+		}
+		a.audit(models.AuditKeyChanged, contact.UserID, detail)
+		a.sendWebhooks(WebhookPayload{Event: config.WebhookKeyChange, UserID: contact.UserID, Fingerprint: fingerprint})
+		contact.Verified = false
+		contact.KeyChanged = accepted == ""
+	}
+	contact.PublicKey = publicKey
+	contact.ExchangeKey = exchangeKey
+	contact.Fingerprint = fingerprint
+	contact.PendingKey = nil
+	contact.PendingExchangeKey = nil
+	contact.PendingFingerprint = ""
+}
+
+// VerifyContact marks a contact's current identity key as verified
+func (a *App) VerifyContact(userID string) error {
+	return a.updateContact(userID, func(contact *models.Contact) bool {
+		contact.Verified = true
+		contact.KeyChanged = false
+		a.audit(models.AuditContactVerified, contact.UserID, "fingerprint "+contact.Fingerprint)
+		return true
+	})
+}
+
+// checkKeyChange refuses to send to a contact with an unverified key change
+// when verification is required, unless the user chose to send anyway
+func (a *App) checkKeyChange(contact *models.Contact, override bool) error {
+	if !a.config.Security.RequireVerification || !contact.KeyChanged {
+		return nil
+	}
+	if !override {
+		return fmt.Errorf("%w: %s", ErrUnverifiedKeyChange, contact.UserID)
+	}
+
+	// The warning stays until the contact is verified; only this message
+	// goes out
+	a.audit(models.AuditKeyChangeOverride, contact.UserID, "sent a message despite an unverified key change")
+	return nil
+}
+
+// AuditLog returns the audit trail of security-relevant events, oldest first
+func (a *App) AuditLog() ([]*models.AuditEvent, error) {
+	return a.storage.GetAuditEvents()
+}
+
+// audit records an event in the audit trail
+func (a *App) audit(action models.AuditAction, userID, detail string) {
+	log.Printf("Audit: %s %s: %s", action, userID, detail)
+
+	event := &models.AuditEvent{
+		Time:   time.Now(),
+		Action: action,
+		UserID: userID,
+		Detail: detail,
+	}
+	if err := a.storage.SaveAuditEvent(event); err != nil {
+		log.Printf("Warning: failed to save audit event: %v", err)
+	}
+}
+
+// updateContact changes a stored contact and reports it as updated
+func (a *App) updateContact(userID string, update func(contact *models.Contact) bool) error {
+	return a.changeContact(userID, ContactUpdated, update)
+}
+
+// changeContact changes a stored contact under the contacts lock and
+// persists it if update reports a change. Handlers are notified of an event
+// of the given type with a copy once the lock is released, so they may call
+// back into the app; presence events are coalesced.
+func (a *App) changeContact(userID string, eventType ContactEventType, update func(contact *models.Contact) bool) error {
+	userID = models.NormalizeUserID(userID)
+
+	a.contactsMux.Lock()
+	contact, exists := a.contacts[userID]
+	if !exists {
+		a.contactsMux.Unlock()
+		return fmt.Errorf("%w: %s", errContactNotFound, userID)
+	}
+	if !update(contact) {
+		a.contactsMux.Unlock()
+		return nil
+	}
+	updated := *contact
+	err := a.storage.SaveContact(&updated)
+	a.contactsMux.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to save contact: %w", err)
+	}
+	if eventType == ContactPresence {
+		a.notifyPresence(userID)
+	} else {
+		a.notifyContactEvent(eventType, &updated)
+	}
+	return nil
+}
+
+// saveContact stores a contact in memory and storage, replacing any contact
+// with the same user ID, and notifies handlers that it was added or updated
+func (a *App) saveContact(contact *models.Contact) error {
+	a.contactsMux.Lock()
+	_, replaced := a.contacts[contact.UserID]
+	err := a.storage.SaveContact(contact)
+	if err == nil {
+		a.contacts[contact.UserID] = contact
+	}
+	a.contactsMux.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to save contact: %w", err)
+	}
+	eventType := ContactAdded
+	if replaced {
+		eventType = ContactUpdated
+	}
+	copied := *contact
+	a.notifyContactEvent(eventType, &copied)
+	return nil
+}
+
+// notifyContactHandlers passes a changed contact to all contact handlers
+func (a *App) notifyContactHandlers(contact *models.Contact) {
+	for _, handler := range a.contactHandlers {
+		handler(contact)
+	}
+}
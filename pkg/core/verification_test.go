@@ -0,0 +1,244 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+// changedKeyContact adds bob to app as a contact whose key changed since
+// it was verified
+func changedKeyContact(t *testing.T, app *App) {
+	t.Helper()
+
+	if err := app.AddContact("bob", "Bob"); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	err := app.updateContact("bob", func(contact *models.Contact) bool {
+		contact.Verified = true
+		contact.KeyChanged = true
+		return true
+	})
+	if err != nil {
+		t.Fatalf("updateContact: %v", err)
+	}
+}
+
+// overrides counts the key change overrides in app's audit trail
+func overrides(t *testing.T, app *App) int {
+	t.Helper()
+
+	events, err := app.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog: %v", err)
+	}
+	count := 0
+	for _, event := range events {
+		if event.Action == models.AuditKeyChangeOverride && event.UserID == "bob" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestKeyChangeBlocksSending(t *testing.T) {
+	app := newTestApp(t, "alice")
+	changedKeyContact(t, app)
+
+	if err := app.SendMessage("bob", "hello"); !errors.Is(err, ErrUnverifiedKeyChange) {
+		t.Fatalf("SendMessage: %v, want ErrUnverifiedKeyChange", err)
+	}
+	if messages, _ := app.GetMessages("bob", 10); len(messages) != 0 {
+		t.Errorf("%d messages stored after a blocked send, want none", len(messages))
+	}
+	if overrides(t, app) != 0 {
+		t.Error("a blocked send was audited as an override")
+	}
+
+	// Once verified again, messages go out as usual
+	if err := app.VerifyContact("bob"); err != nil {
+		t.Fatalf("VerifyContact: %v", err)
+	}
+	if err := app.SendMessage("bob", "hello"); err != nil {
+		t.Errorf("SendMessage after verifying: %v", err)
+	}
+}
+
+func TestKeyChangeOverrideCoversOneMessage(t *testing.T) {
+	app := newTestApp(t, "alice")
+	changedKeyContact(t, app)
+
+	if err := app.SendMessageAnyway("bob", "it's really me"); err != nil {
+		t.Fatalf("SendMessageAnyway: %v", err)
+	}
+	if !hasMessage(app, "bob", "it's really me") {
+		t.Error("the overridden message was not sent")
+	}
+	if got := overrides(t, app); got != 1 {
+		t.Errorf("%d overrides audited, want 1", got)
+	}
+
+	// The warning stays, and the next message is blocked again
+	if contact, _ := app.GetContact("bob"); !contact.KeyChanged {
+		t.Error("the override cleared the key change warning")
+	}
+	if err := app.SendMessage("bob", "and again"); !errors.Is(err, ErrUnverifiedKeyChange) {
+		t.Errorf("SendMessage after an override: %v, want ErrUnverifiedKeyChange", err)
+	}
+}
+
+func TestKeyChangeWithoutRequiredVerification(t *testing.T) {
+	app := newTestApp(t, "alice")
+	app.config.Security.RequireVerification = false
+	changedKeyContact(t, app)
+
+	if err := app.SendMessage("bob", "hello"); err != nil {
+		t.Errorf("SendMessage: %v", err)
+	}
+	if overrides(t, app) != 0 {
+		t.Error("a send without required verification was audited as an override")
+	}
+}
+
+func TestKeyAcceptanceModes(t *testing.T) {
+	type keyState struct {
+		current  string // Which key is the contact's: "old", "new" or ""
+		pending  bool
+		verified bool
+		changed  bool
+	}
+	tests := []struct {
+		mode       config.KeyAcceptance
+		newKey     keyState
+		changedKey keyState
+	}{
+		{config.KeyAcceptNever, keyState{current: "new"}, keyState{current: "new", changed: true}},
+		{config.KeyAcceptAsk, keyState{pending: true}, keyState{current: "old", pending: true, verified: true}},
+		{config.KeyAcceptAlways, keyState{current: "new"}, keyState{current: "new"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			alice := newTestApp(t, "alice", func(cfg *config.Config) { cfg.Security.AutoAcceptKeys = tt.mode })
+			old, presented := keyedContact(t, "bob"), keyedContact(t, "bob")
+			check := func(name string, want keyState) {
+				t.Helper()
+
+				contact, _ := alice.GetContact("bob")
+				current := map[string]string{"": "", old.Fingerprint: "old", presented.Fingerprint: "new"}[contact.Fingerprint]
+				got := keyState{current, contact.PendingFingerprint != "", contact.Verified, contact.KeyChanged}
+				if got != want {
+					t.Errorf("%s: got %+v, want %+v", name, got, want)
+				}
+			}
+
+			if err := alice.AddContact("bob", ""); err != nil {
+				t.Fatalf("AddContact: %v", err)
+			}
+			if err := alice.UpdateContactKey("bob", presented.PublicKey, presented.ExchangeKey); err != nil {
+				t.Fatalf("UpdateContactKey: %v", err)
+			}
+			check("new key", tt.newKey)
+
+			err := alice.updateContact("bob", func(contact *models.Contact) bool {
+				*contact = models.Contact{UserID: "bob", PublicKey: old.PublicKey, ExchangeKey: old.ExchangeKey, Fingerprint: old.Fingerprint, Verified: true}
+				return true
+			})
+			if err != nil {
+				t.Fatalf("updateContact: %v", err)
+			}
+			if err := alice.UpdateContactKey("bob", presented.PublicKey, presented.ExchangeKey); err != nil {
+				t.Fatalf("UpdateContactKey: %v", err)
+			}
+			check("changed key", tt.changedKey)
+		})
+	}
+}
+
+func TestPendingKeyDecisions(t *testing.T) {
+	alice := newTestApp(t, "alice", func(cfg *config.Config) { cfg.Security.AutoAcceptKeys = config.KeyAcceptAsk })
+	if err := alice.AddContact("bob", ""); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	first, second := keyedContact(t, "bob"), keyedContact(t, "bob")
+
+	if err := alice.UpdateContactKey("bob", first.PublicKey, first.ExchangeKey); err != nil {
+		t.Fatalf("UpdateContactKey: %v", err)
+	}
+	if err := alice.AcceptPendingKey("bob"); err != nil {
+		t.Fatalf("AcceptPendingKey: %v", err)
+	}
+	if contact, _ := alice.GetContact("bob"); contact.Fingerprint != first.Fingerprint || contact.PendingFingerprint != "" {
+		t.Errorf("after accepting, key %s pending %q, want the accepted key", contact.Fingerprint, contact.PendingFingerprint)
+	}
+
+	if err := alice.UpdateContactKey("bob", second.PublicKey, second.ExchangeKey); err != nil {
+		t.Fatalf("UpdateContactKey: %v", err)
+	}
+	if err := alice.RejectPendingKey("bob"); err != nil {
+		t.Fatalf("RejectPendingKey: %v", err)
+	}
+	if contact, _ := alice.GetContact("bob"); contact.Fingerprint != first.Fingerprint || contact.PendingFingerprint != "" {
+		t.Errorf("after rejecting, key %s pending %q, want the earlier key kept", contact.Fingerprint, contact.PendingFingerprint)
+	}
+
+	events, err := alice.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog: %v", err)
+	}
+	var actions []models.AuditAction
+	for _, event := range events {
+		if event.Action == models.AuditKeyAccepted || event.Action == models.AuditKeyRejected {
+			actions = append(actions, event.Action)
+		}
+	}
+	if len(actions) != 2 || actions[0] != models.AuditKeyAccepted || actions[1] != models.AuditKeyRejected {
+		t.Errorf("audited %v, want the acceptance then the rejection", actions)
+	}
+}
+
+func TestLegacyFingerprintsAreUpgraded(t *testing.T) {
+	app := newTestApp(t, "alice")
+	full := app.identity.Fingerprint
+	if len(full) != 64 {
+		t.Fatalf("new identity has fingerprint %q, want the full length", full)
+	}
+
+	// An identity stored by an earlier version
+	legacy := identityToModel("alice", app.identity, app.preKey)
+	legacy.Fingerprint = full[:crypto.LegacyFingerprintLength]
+	if err := app.storage.SaveIdentity(legacy); err != nil {
+		t.Fatalf("SaveIdentity: %v", err)
+	}
+	if err := app.initIdentity(); err != nil {
+		t.Fatalf("initIdentity: %v", err)
+	}
+	stored, err := app.storage.GetIdentity("alice")
+	if err != nil {
+		t.Fatalf("GetIdentity: %v", err)
+	}
+	if app.identity.Fingerprint != full || stored.Fingerprint != full {
+		t.Errorf("fingerprint loaded as %s and stored as %s, want %s", app.identity.Fingerprint, stored.Fingerprint, full)
+	}
+
+	// A contact verified by an earlier version keeps its verification
+	bob := keyedContact(t, "bob")
+	bob.Fingerprint = bob.Fingerprint[:crypto.LegacyFingerprintLength]
+	bob.Verified = true
+	if err := app.saveContact(bob); err != nil {
+		t.Fatalf("saveContact: %v", err)
+	}
+	if err := app.UpdateContactKey("bob", bob.PublicKey, bob.ExchangeKey); err != nil {
+		t.Fatalf("UpdateContactKey: %v", err)
+	}
+	got, _ := app.GetContact("bob")
+	if got.KeyChanged || got.PendingFingerprint != "" || !got.Verified {
+		t.Errorf("bob = %+v, want the same key still verified", got)
+	}
+	if got.Fingerprint != crypto.Fingerprint(bob.PublicKey, bob.ExchangeKey) {
+		t.Errorf("bob's fingerprint = %s, want it upgraded to the full one", got.Fingerprint)
+	}
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+const (
+	// webhookQueueSize is how many events wait for a webhook before more
+	// are dropped
+	webhookQueueSize = 64
+	// webhookAttempts is how often delivery of one event is tried
+	webhookAttempts = 4
+	// webhookMaxFailures is how many events in a row may fail delivery
+	// before a webhook is disabled until restart
+	webhookMaxFailures = 5
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookRetryDelay is the wait before the first retry; it doubles with
+// every further one
+var webhookRetryDelay = time.Second
+
+// WebhookPayload is the JSON body sent to webhooks. Message content is only
+// included for webhooks configured with include_content.
+type WebhookPayload struct {
+	ID    string              `json:"id"` // Same for every attempt, to spot retries
+	Event config.WebhookEvent `json:"event"`
+	Time  time.Time           `json:"time"`
+
+	// User the event is about, for messages and key changes
+	UserID string `json:"user_id,omitempty"`
+
+	// Received message
+	MessageID     string `json:"message_id,omitempty"`
+	ContentLength int    `json:"content_length,omitempty"`
+	Content       string `json:"content,omitempty"`
+
+	// New fingerprint of a key change
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Relay of a lost connection, and why it was lost
+	Relay string `json:"relay,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// webhook delivers events to one configured URL, one at a time
+type webhook struct {
+	config   config.WebhookConfig
+	queue    chan WebhookPayload
+	disabled atomic.Bool
+	client   *http.Client
+}
+
+// startWebhooks starts delivering events to the configured webhooks
+func (a *App) startWebhooks() {
+	for _, cfg := range a.config.Webhooks {
+		hook := &webhook{
+			config: cfg,
+			queue:  make(chan WebhookPayload, webhookQueueSize),
+			client: &http.Client{Timeout: webhookTimeout},
+		}
+		a.webhooks = append(a.webhooks, hook)
+
+		a.background.Add(1)
+		go a.runWebhook(hook)
+	}
+}
+
+// sendWebhooks queues an event for every webhook that wants it. Content is
+// dropped from the payload of webhooks not configured to include it. It
+// never blocks: events for a webhook that is behind are dropped.
+func (a *App) sendWebhooks(payload WebhookPayload) {
+	payload.ID = models.NewMessageID()
+	payload.Time = time.Now().UTC()
+
+	for _, hook := range a.webhooks {
+		if hook.disabled.Load() || !hook.config.Wants(payload.Event) {
+			continue
+		}
+		event := payload
+		if !hook.config.IncludeContent {
+			event.Content = ""
+		}
+		select {
+		case hook.queue <- event:
+		default:
+			log.Printf("Warning: webhook %s is behind, dropped %s event", hook.config.URL, payload.Event)
+		}
+	}
+}
+
+// runWebhook delivers queued events until the app is closed or the webhook
+// is disabled after failing too often
+func (a *App) runWebhook(hook *webhook) {
+	defer a.background.Done()
+
+	// Abort a delivery in progress when the app closes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-hook.queue:
+			err := hook.deliver(ctx, payload)
+			if err == nil {
+				failures = 0
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Printf("Warning: webhook %s failed for %s event: %v", hook.config.URL, payload.Event, err)
+			if failures >= webhookMaxFailures {
+				hook.disabled.Store(true)
+				log.Printf("Warning: webhook %s disabled after %d failed events", hook.config.URL, failures)
+				return
+			}
+		}
+	}
+}
+
+// deliver posts an event, retrying with growing delays
+func (h *webhook) deliver(ctx context.Context, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = h.post(ctx, payload.Event, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt
+func (h *webhook) post(ctx context.Context, event config.WebhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SecureChat-Event", string(event))
+	if h.config.Secret != "" {
+		req.Header.Set("X-SecureChat-Signature", "sha256="+signWebhook(h.config.Secret, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of a payload, which receivers
+// recompute with the shared secret to check where it came from
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookNewMessage sends the message event for a received chat message,
+// unless it is from a blocked contact
+func (a *App) webhookNewMessage(msg *models.Message) {
+	if len(a.webhooks) == 0 || msg.Type != models.MessageTypeChat || msg.IsFromUser(a.config.User.ID) {
+		return
+	}
+	if a.ContactState(msg.From).Blocked {
+		return
+	}
+	a.sendWebhooks(WebhookPayload{
+		Event:         config.WebhookMessage,
+		UserID:        msg.From,
+		MessageID:     msg.ID,
+		ContentLength: len(msg.Content),
+		Content:       msg.Content,
+	})
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// webhookCall is one request a webhook receiver got
+type webhookCall struct {
+	event     string
+	signature string
+	body      []byte
+	payload   WebhookPayload
+	at        time.Time
+}
+
+// webhookReceiver records the webhooks posted to it, answering each with
+// the next of statuses, and 200 once they run out
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	calls    []webhookCall
+	statuses []int
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		call := webhookCall{
+			event:     req.Header.Get("X-SecureChat-Event"),
+			signature: req.Header.Get("X-SecureChat-Signature"),
+			body:      body,
+			at:        time.Now(),
+		}
+		json.Unmarshal(body, &call.payload)
+
+		r.mu.Lock()
+		r.calls = append(r.calls, call)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// received returns the calls made so far
+func (r *webhookReceiver) received() []webhookCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhookCall(nil), r.calls...)
+}
+
+// fastWebhookRetries shortens the retry delay for the test
+func fastWebhookRetries(t *testing.T) {
+	saved := webhookRetryDelay
+	webhookRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = saved })
+}
+
+func TestWebhooksFireWithRedactedSignedPayloads(t *testing.T) {
+	redacted, full := newWebhookReceiver(t), newWebhookReceiver(t)
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Webhooks = []config.WebhookConfig{
+			{URL: redacted.URL, Secret: "shared secret"},
+			{URL: full.URL, Events: []config.WebhookEvent{config.WebhookMessage}, IncludeContent: true},
+		}
+	})
+	if err := alice.saveContact(&models.Contact{UserID: "bob"}); err != nil {
+		t.Fatalf("saveContact: %v", err)
+	}
+
+	alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "bob", "m1", "the door code is 4321"))
+	waitFor(t, "both webhooks", func() bool { return len(redacted.received()) == 1 && len(full.received()) == 1 })
+
+	call := redacted.received()[0]
+	if call.event != "message" || call.payload.Event != config.WebhookMessage || call.payload.UserID != "bob" || call.payload.MessageID != "m1" {
+		t.Errorf("payload = %+v, want the message event from bob", call.payload)
+	}
+	if call.payload.Content != "" || call.payload.ContentLength != len("the door code is 4321") {
+		t.Errorf("payload = %+v, want the content left out and its length given", call.payload)
+	}
+	if want := "sha256=" + signWebhook("shared secret", call.body); call.signature != want {
+		t.Errorf("signature = %q, want %q", call.signature, want)
+	}
+	if got := full.received()[0]; got.payload.Content != "the door code is 4321" || got.signature != "" {
+		t.Errorf("payload = %+v signed %q, want the content included and no signature", got.payload, got.signature)
+	}
+
+	// Messages from blocked contacts fire nothing
+	if err := alice.SetContactBlocked("bob", true); err != nil {
+		t.Fatalf("SetContactBlocked: %v", err)
+	}
+	alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "bob", "m2", "again"))
+	time.Sleep(50 * time.Millisecond)
+	if len(redacted.received()) != 1 || len(full.received()) != 1 {
+		t.Error("a webhook fired for a blocked contact's message")
+	}
+	if err := alice.SetContactBlocked("bob", false); err != nil {
+		t.Fatalf("SetContactBlocked: %v", err)
+	}
+
+	// Learning bob's first key is not a change; replacing it goes only to
+	// the webhook that wants every event
+	for i := 0; i < 2; i++ {
+		err := alice.updateContact("bob", func(contact *models.Contact) bool {
+			key := keyedContact(t, "bob")
+			alice.applyContactKey(contact, key.PublicKey, key.ExchangeKey, key.Fingerprint, "")
+			return true
+		})
+		if err != nil {
+			t.Fatalf("updateContact: %v", err)
+		}
+	}
+	waitFor(t, "the key change webhook", func() bool { return len(redacted.received()) == 2 })
+	if got := redacted.received()[1].payload; got.Event != config.WebhookKeyChange || got.UserID != "bob" || got.Fingerprint == "" {
+		t.Errorf("payload = %+v, want bob's key change", got)
+	}
+}
+
+func TestWebhookFiresOnConnectionLoss(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Webhooks = []config.WebhookConfig{{URL: receiver.URL, Events: []config.WebhookEvent{config.WebhookConnectionLost}}}
+	})
+	server := newTestServer(t)
+	connectApp(t, alice, server)
+
+	// The first reconnection is immediate and drops too, so the loss may
+	// be reported more than once
+	server.Stop()
+	waitFor(t, "the connection loss webhook", func() bool { return len(receiver.received()) > 0 })
+	for _, call := range receiver.received() {
+		if got := call.payload; got.Event != config.WebhookConnectionLost || got.Relay != "memory" {
+			t.Errorf("payload = %+v, want the loss of the memory relay", got)
+		}
+	}
+}
+
+func TestWebhookFailuresBackOffThenDisable(t *testing.T) {
+	fastWebhookRetries(t)
+	receiver := newWebhookReceiver(t, 500, 503)
+	alice := newTestApp(t, "alice", func(cfg *config.Config) {
+		cfg.Webhooks = []config.WebhookConfig{{URL: receiver.URL}}
+	})
+
+	// Two failures, then the retry gets through with the same payload
+	alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "bob", "m1", "hi"))
+	waitFor(t, "the third attempt", func() bool { return len(receiver.received()) == 3 })
+	calls := receiver.received()
+	for _, call := range calls[1:] {
+		if call.payload.ID != calls[0].payload.ID {
+			t.Errorf("retry has ID %s, want %s", call.payload.ID, calls[0].payload.ID)
+		}
+	}
+	first, second := calls[1].at.Sub(calls[0].at), calls[2].at.Sub(calls[1].at)
+	if first < webhookRetryDelay || second < 2*webhookRetryDelay {
+		t.Errorf("retried after %s and %s, want the delay to start at %s and double", first, second, webhookRetryDelay)
+	}
+
+	// Events that fail every attempt disable the webhook
+	receiver.mu.Lock()
+	for i := 0; i < webhookMaxFailures*webhookAttempts; i++ {
+		receiver.statuses = append(receiver.statuses, http.StatusInternalServerError)
+	}
+	receiver.mu.Unlock()
+	for i := 0; i < webhookMaxFailures; i++ {
+		alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "bob", models.NewMessageID(), "hi"))
+	}
+	waitFor(t, "the webhook to be disabled", func() bool { return alice.webhooks[0].disabled.Load() })
+	if got := len(receiver.received()); got != 3+webhookMaxFailures*webhookAttempts {
+		t.Errorf("%d calls, want every attempt of every failed event", got)
+	}
+
+	alice.handleNetworkMessage(testRelay, incomingChat(t, alice, "bob", "late", "hi"))
+	time.Sleep(50 * time.Millisecond)
+	if got := len(receiver.received()); got != 3+webhookMaxFailures*webhookAttempts {
+		t.Error("a disabled webhook was called")
+	}
+}
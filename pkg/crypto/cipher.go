@@ -0,0 +1,146 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrNoCommonCipher is returned when two peers support no cipher in common
+var ErrNoCommonCipher = errors.New("no cipher in common")
+
+// CipherID identifies the AEAD a message was encrypted with. It is the
+// first byte of an encoded EncryptedMessage.
+type CipherID byte
+
+const (
+	// CipherChaCha20Poly1305 is the default. The zero value also means
+	// ChaCha20-Poly1305, which sessions and messages from before cipher
+	// selection use.
+	CipherChaCha20Poly1305 CipherID = 1
+	// CipherAES256GCM is faster on CPUs with AES instructions
+	CipherAES256GCM CipherID = 2
+)
+
+// DefaultCipher is used unless both peers prefer another
+const DefaultCipher = CipherChaCha20Poly1305
+
+// Cipher is an AEAD usable for messages
+type Cipher interface {
+	ID() CipherID
+	Name() string
+	KeySize() int
+	NonceSize() int
+	New(key []byte) (cipher.AEAD, error)
+}
+
+type chacha20Poly1305Cipher struct{}
+
+func (chacha20Poly1305Cipher) ID() CipherID   { return CipherChaCha20Poly1305 }
+func (chacha20Poly1305Cipher) Name() string   { return "chacha20-poly1305" }
+func (chacha20Poly1305Cipher) KeySize() int   { return chacha20poly1305.KeySize }
+func (chacha20Poly1305Cipher) NonceSize() int { return chacha20poly1305.NonceSize }
+
+func (chacha20Poly1305Cipher) New(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.New(key)
+}
+
+type aes256GCMCipher struct{}
+
+func (aes256GCMCipher) ID() CipherID   { return CipherAES256GCM }
+func (aes256GCMCipher) Name() string   { return "aes-256-gcm" }
+func (aes256GCMCipher) KeySize() int   { return 32 }
+func (aes256GCMCipher) NonceSize() int { return 12 }
+
+func (c aes256GCMCipher) New(key []byte) (cipher.AEAD, error) {
+	// aes.NewCipher would also accept AES-128 and AES-192 keys
+	if len(key) != c.KeySize() {
+		return nil, fmt.Errorf("aes-256-gcm: bad key length %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ciphers holds every supported cipher, in the default order of preference
+var ciphers = []Cipher{chacha20Poly1305Cipher{}, aes256GCMCipher{}}
+
+// SupportedCiphers returns the IDs of all supported ciphers, default first
+func SupportedCiphers() []CipherID {
+	ids := make([]CipherID, len(ciphers))
+	for i, c := range ciphers {
+		ids[i] = c.ID()
+	}
+	return ids
+}
+
+// CipherByID returns a supported cipher
+func CipherByID(id CipherID) (Cipher, error) {
+	id = normalizeCipherID(id)
+	for _, c := range ciphers {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported cipher %d", id)
+}
+
+// CipherByName returns a supported cipher by its name, such as "aes-256-gcm"
+func CipherByName(name string) (Cipher, error) {
+	for _, c := range ciphers {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported cipher %q", name)
+}
+
+// CipherPreferences returns the supported ciphers with preferred first
+func CipherPreferences(preferred CipherID) []CipherID {
+	prefs := []CipherID{preferred}
+	for _, id := range SupportedCiphers() {
+		if id != preferred {
+			prefs = append(prefs, id)
+		}
+	}
+	return prefs
+}
+
+// NegotiateCipher picks the session cipher from the ciphers each peer
+// offered in the key exchange, most preferred first. The initiator's
+// preference wins among the ciphers both support, so both peers arrive at
+// the same choice. A peer that offers nothing predates cipher selection
+// and only speaks ChaCha20-Poly1305.
+func NegotiateCipher(initiator, responder []CipherID) (CipherID, error) {
+	if len(initiator) == 0 {
+		initiator = []CipherID{CipherChaCha20Poly1305}
+	}
+	if len(responder) == 0 {
+		responder = []CipherID{CipherChaCha20Poly1305}
+	}
+
+	for _, id := range initiator {
+		if _, err := CipherByID(id); err != nil {
+			continue
+		}
+		for _, other := range responder {
+			if other == id {
+				return id, nil
+			}
+		}
+	}
+	return 0, ErrNoCommonCipher
+}
+
+// normalizeCipherID maps the zero value of older data to ChaCha20-Poly1305
+func normalizeCipherID(id CipherID) CipherID {
+	if id == 0 {
+		return CipherChaCha20Poly1305
+	}
+	return id
+}
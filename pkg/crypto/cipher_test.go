@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// ratchetPair returns an initiator and responder session using cipherID
+func ratchetPair(t *testing.T, cipherID CipherID) (*DoubleRatchet, *DoubleRatchet) {
+	t.Helper()
+
+	secret := bytes.Repeat([]byte{7}, 32)
+	prekey, err := GenerateEphemeralKey()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKey: %v", err)
+	}
+	initiator, err := NewDoubleRatchet(secret, prekey.PublicKey, cipherID)
+	if err != nil {
+		t.Fatalf("NewDoubleRatchet: %v", err)
+	}
+	responder, err := NewResponderRatchet(secret, prekey, initiator.DHSelf.PublicKey, cipherID)
+	if err != nil {
+		t.Fatalf("NewResponderRatchet: %v", err)
+	}
+	return initiator, responder
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	for _, id := range SupportedCiphers() {
+		c, err := CipherByID(id)
+		if err != nil {
+			t.Fatalf("CipherByID(%d): %v", id, err)
+		}
+
+		t.Run(c.Name(), func(t *testing.T) {
+			initiator, responder := ratchetPair(t, id)
+			plaintext := []byte("hello over " + c.Name())
+			encrypted, err := initiator.Encrypt(plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			if encrypted.Cipher != id || len(encrypted.Nonce) != c.NonceSize() {
+				t.Fatalf("encrypted with cipher %d and a %d byte nonce, want %d and %d", encrypted.Cipher, len(encrypted.Nonce), id, c.NonceSize())
+			}
+
+			// The encoding names its cipher, so it decodes without the session
+			data, err := encrypted.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary: %v", err)
+			}
+			if CipherID(data[0]) != id {
+				t.Errorf("encoding starts with cipher %d, want %d", data[0], id)
+			}
+			var decoded EncryptedMessage
+			if err := decoded.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary: %v", err)
+			}
+			got, err := responder.Decrypt(&decoded, 0)
+			if err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt = %q, want %q", got, plaintext)
+			}
+
+			key := bytes.Repeat([]byte{1}, c.KeySize())
+			simple, err := SimpleEncryptWith(id, plaintext, key)
+			if err != nil {
+				t.Fatalf("SimpleEncryptWith: %v", err)
+			}
+			if got, err := SimpleDecrypt(simple, key); err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("SimpleDecrypt = %q, %v, want %q", got, err, plaintext)
+			}
+			simple.Ciphertext[0] ^= 1
+			if _, err := SimpleDecrypt(simple, key); err == nil {
+				t.Error("SimpleDecrypt accepted a tampered ciphertext")
+			}
+			if _, err := SimpleEncryptWith(id, plaintext, key[:16]); err == nil {
+				t.Error("SimpleEncryptWith accepted a 16 byte key")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsAnotherCipher(t *testing.T) {
+	initiator, _ := ratchetPair(t, CipherAES256GCM)
+	_, responder := ratchetPair(t, CipherChaCha20Poly1305)
+	encrypted, err := initiator.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := responder.Decrypt(encrypted, 0); err == nil {
+		t.Error("a ChaCha20-Poly1305 session decrypted an AES-256-GCM message")
+	}
+
+	var decoded EncryptedMessage
+	if err := decoded.UnmarshalBinary([]byte{9, 1, 2, 3}); err == nil {
+		t.Error("UnmarshalBinary accepted an unknown cipher")
+	}
+	if err := decoded.UnmarshalBinary([]byte{byte(CipherAES256GCM), 1, 2}); err == nil {
+		t.Error("UnmarshalBinary accepted a message shorter than its nonce")
+	}
+}
+
+func TestNegotiateCipher(t *testing.T) {
+	chacha, aes := CipherChaCha20Poly1305, CipherAES256GCM
+	tests := []struct {
+		name                 string
+		initiator, responder []CipherID
+		want                 CipherID
+		wantErr              error
+	}{
+		{"both prefer AES", []CipherID{aes, chacha}, []CipherID{aes, chacha}, aes, nil},
+		{"initiator preference wins", []CipherID{aes, chacha}, []CipherID{chacha, aes}, aes, nil},
+		{"responder without AES", []CipherID{aes, chacha}, []CipherID{chacha}, chacha, nil},
+		{"peer from before cipher selection", []CipherID{aes, chacha}, nil, chacha, nil},
+		{"unknown cipher skipped", []CipherID{9, aes}, []CipherID{9, aes}, aes, nil},
+		{"nothing in common", []CipherID{aes}, []CipherID{chacha}, 0, ErrNoCommonCipher},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NegotiateCipher(tt.initiator, tt.responder)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("NegotiateCipher(%v, %v) = %d, %v, want %d, %v", tt.initiator, tt.responder, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
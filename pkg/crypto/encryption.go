@@ -1,10 +1,11 @@
 package crypto
 
 import (
+	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"fmt"
 
-	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/hkdf"
 	"crypto/sha256"
@@ -13,11 +14,50 @@ import (
 
 // EncryptedMessage represents an encrypted message
 type EncryptedMessage struct {
+	Cipher     CipherID
 	Ciphertext []byte
 	Nonce      []byte
 	Tag        []byte
 }
 
+// MarshalBinary encodes the message as the cipher ID byte, then the nonce,
+// then the ciphertext. The cipher ID tells the nonce size, so the encoding
+// describes itself.
+func (m *EncryptedMessage) MarshalBinary() ([]byte, error) {
+	c, err := CipherByID(m.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Nonce) != c.NonceSize() {
+		return nil, fmt.Errorf("%s: bad nonce length %d", c.Name(), len(m.Nonce))
+	}
+
+	data := make([]byte, 0, 1+len(m.Nonce)+len(m.Ciphertext))
+	data = append(data, byte(c.ID()))
+	data = append(data, m.Nonce...)
+	return append(data, m.Ciphertext...), nil
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *EncryptedMessage) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty encrypted message")
+	}
+	c, err := CipherByID(CipherID(data[0]))
+	if err != nil {
+		return err
+	}
+	if len(data) < 1+c.NonceSize() {
+		return fmt.Errorf("%s: encrypted message too short", c.Name())
+	}
+
+	m.Cipher = c.ID()
+	m.Nonce = append([]byte(nil), data[1:1+c.NonceSize()]...)
+	m.Ciphertext = append([]byte(nil), data[1+c.NonceSize():]...)
+	m.Tag = nil
+	return nil
+}
+
 // SessionKeys represents the keys for a messaging session
 type SessionKeys struct {
 	RootKey     []byte
@@ -35,6 +75,11 @@ type DoubleRatchet struct {
 	DHRemote        []byte
 	MessageNumber   uint32
 	PreviousCounter uint32
+
+	// Cipher is the AEAD agreed for the session in the key exchange.
+	// Sessions from before cipher selection leave it zero, which means
+	// ChaCha20-Poly1305.
+	Cipher CipherID `json:",omitempty"`
 }
 
 // ChainState represents the state of a message chain
@@ -43,8 +88,13 @@ type ChainState struct {
 	MessageNumber uint32
 }
 
-// NewDoubleRatchet initializes a new Double Ratchet session
-func NewDoubleRatchet(sharedSecret []byte, remotePublicKey []byte) (*DoubleRatchet, error) {
+// NewDoubleRatchet initializes a new Double Ratchet session using the
+// cipher negotiated with NegotiateCipher
+func NewDoubleRatchet(sharedSecret []byte, remotePublicKey []byte, cipherID CipherID) (*DoubleRatchet, error) {
+	if _, err := CipherByID(cipherID); err != nil {
+		return nil, err
+	}
+
 	// Generate initial DH key pair
 	dhPrivate := make([]byte, 32)
 	if _, err := rand.Read(dhPrivate); err != nil {
@@ -64,11 +114,36 @@ func NewDoubleRatchet(sharedSecret []byte, remotePublicKey []byte) (*DoubleRatch
 	// Initialize root key from shared secret
 	rootKey := deriveRootKey(sharedSecret)
 
-	// Initialize sending chain
+	return newRatchet(rootKey, dhSelf, remotePublicKey, cipherID)
+}
+
+// NewResponderRatchet initializes the peer of a session started with
+// NewDoubleRatchet, given the key pair whose public key the initiator used
+// as remotePublicKey and the initiator's initial ratchet public key. The
+// sending chain of each peer is the receiving chain of the other.
+func NewResponderRatchet(sharedSecret []byte, localKey KeyPair, remotePublicKey []byte, cipherID CipherID) (*DoubleRatchet, error) {
+	if _, err := CipherByID(cipherID); err != nil {
+		return nil, err
+	}
+
+	dhSelf := KeyPair{
+		PublicKey:  append([]byte(nil), localKey.PublicKey...),
+		PrivateKey: append([]byte(nil), localKey.PrivateKey...),
+	}
+	return newRatchet(deriveRootKey(sharedSecret), dhSelf, remotePublicKey, cipherID)
+}
+
+// newRatchet derives the sending chain from the local ratchet key followed
+// by the remote one, and the receiving chain from the two the other way round
+func newRatchet(rootKey []byte, dhSelf KeyPair, remotePublicKey []byte, cipherID CipherID) (*DoubleRatchet, error) {
 	sendingChainKey, err := deriveChainKey(rootKey, dhSelf.PublicKey, remotePublicKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to derive sending chain key: %w", err)
 	}
+	receivingChainKey, err := deriveChainKey(rootKey, remotePublicKey, dhSelf.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive receiving chain key: %w", err)
+	}
 
 	return &DoubleRatchet{
 		RootKey:      rootKey,
@@ -79,11 +154,12 @@ func NewDoubleRatchet(sharedSecret []byte, remotePublicKey []byte) (*DoubleRatch
 			MessageNumber: 0,
 		},
 		ReceivingChain: &ChainState{
-			ChainKey:      make([]byte, 32), // Will be derived when receiving
+			ChainKey:      receivingChainKey,
 			MessageNumber: 0,
 		},
 		MessageNumber:   0,
 		PreviousCounter: 0,
+		Cipher:          cipherID,
 	}, nil
 }
 
@@ -91,30 +167,61 @@ func NewDoubleRatchet(sharedSecret []byte, remotePublicKey []byte) (*DoubleRatch
 func (dr *DoubleRatchet) Encrypt(plaintext []byte) (*EncryptedMessage, error) {
 	// Derive message key from chain key
 	messageKey := deriveMessageKey(dr.SendingChain.ChainKey, dr.SendingChain.MessageNumber)
+	defer Zeroize(messageKey)
 	
-	// Advance chain key
-	dr.SendingChain.ChainKey = advanceChainKey(dr.SendingChain.ChainKey)
+	// Advance chain key, discarding the old one
+	oldChainKey := dr.SendingChain.ChainKey
+	dr.SendingChain.ChainKey = advanceChainKey(oldChainKey)
 	dr.SendingChain.MessageNumber++
+	Zeroize(oldChainKey)
 
 	// Encrypt the message
-	return encryptWithKey(plaintext, messageKey)
+	return encryptWithKey(dr.Cipher, plaintext, messageKey)
 }
 
-// Decrypt decrypts a message using the current session state
+// MaxSkip is how far ahead of the receiving chain a message may be. The
+// keys of skipped messages are not kept, so those messages cannot be
+// decrypted if they arrive later.
+const MaxSkip = 1000
+
+// Decrypt decrypts message number messageNumber of the receiving chain. A
+// message ahead of the chain skips the messages before it; the chain only
+// moves on if the message decrypts.
 func (dr *DoubleRatchet) Decrypt(encrypted *EncryptedMessage, messageNumber uint32) ([]byte, error) {
-	// For simplicity, we'll use the current receiving chain key
-	// In a full implementation, we'd need to handle out-of-order messages
-	messageKey := deriveMessageKey(dr.ReceivingChain.ChainKey, messageNumber)
-	
+	current := dr.ReceivingChain.MessageNumber
+	if messageNumber < current {
+		return nil, fmt.Errorf("message %d was skipped or already received, the chain is at %d", messageNumber, current)
+	}
+	if messageNumber-current > MaxSkip {
+		return nil, fmt.Errorf("message %d is more than %d ahead of the chain at %d", messageNumber, MaxSkip, current)
+	}
+
+	// Both peers agreed on the cipher, so anything else was tampered with
+	if normalizeCipherID(encrypted.Cipher) != normalizeCipherID(dr.Cipher) {
+		return nil, fmt.Errorf("message cipher %d does not match session cipher %d", encrypted.Cipher, dr.Cipher)
+	}
+
+	chainKey := append([]byte(nil), dr.ReceivingChain.ChainKey...)
+	for n := current; n < messageNumber; n++ {
+		next := advanceChainKey(chainKey)
+		Zeroize(chainKey)
+		chainKey = next
+	}
+	defer Zeroize(chainKey)
+
+	messageKey := deriveMessageKey(chainKey, messageNumber)
+	defer Zeroize(messageKey)
+
 	// Decrypt the message
 	plaintext, err := decryptWithKey(encrypted, messageKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt message: %w", err)
 	}
 
-	// Advance receiving chain
-	dr.ReceivingChain.ChainKey = advanceChainKey(dr.ReceivingChain.ChainKey)
-	dr.ReceivingChain.MessageNumber++
+	// Advance receiving chain past the message, discarding the old key
+	Zeroize(dr.ReceivingChain.ChainKey)
+	dr.ReceivingChain.ChainKey = advanceChainKey(chainKey)
+	dr.ReceivingChain.MessageNumber = messageNumber + 1
 
 	return plaintext, nil
 }
@@ -140,6 +247,14 @@ func (dr *DoubleRatchet) PerformDHRatchet(remotePublicKey []byte) error {
 
 	// Derive new root key and chain key
 	newRootKey, newChainKey := deriveRootAndChainKeys(dr.RootKey, sharedSecret)
+	Zeroize(sharedSecret)
+
+	// Discard the keys being replaced
+	Zeroize(dr.RootKey)
+	dr.DHSelf.Wipe()
+	if dr.SendingChain != nil {
+		Zeroize(dr.SendingChain.ChainKey)
+	}
 
 	// Update state
 	dr.RootKey = newRootKey
@@ -158,15 +273,19 @@ func (dr *DoubleRatchet) PerformDHRatchet(remotePublicKey []byte) error {
 	return nil
 }
 
-// encryptWithKey encrypts data using ChaCha20-Poly1305
-func encryptWithKey(plaintext, key []byte) (*EncryptedMessage, error) {
-	aead, err := chacha20poly1305.New(key)
+// encryptWithKey encrypts data with the given cipher
+func encryptWithKey(cipherID CipherID, plaintext, key []byte) (*EncryptedMessage, error) {
+	c, err := CipherByID(cipherID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AEAD cipher: %w", err)
+		return nil, err
+	}
+	aead, err := newAEAD(c, key)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate random nonce
-	nonce := make([]byte, aead.NonceSize())
+	nonce := make([]byte, c.NonceSize())
 	if _, err := rand.Read(nonce); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
@@ -175,16 +294,24 @@ func encryptWithKey(plaintext, key []byte) (*EncryptedMessage, error) {
 	ciphertext := aead.Seal(nil, nonce, plaintext, nil)
 
 	return &EncryptedMessage{
+		Cipher:     c.ID(),
 		Ciphertext: ciphertext,
 		Nonce:      nonce,
 	}, nil
 }
 
-// decryptWithKey decrypts data using ChaCha20-Poly1305
+// decryptWithKey decrypts data with the cipher the message names
 func decryptWithKey(encrypted *EncryptedMessage, key []byte) ([]byte, error) {
-	aead, err := chacha20poly1305.New(key)
+	c, err := CipherByID(encrypted.Cipher)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AEAD cipher: %w", err)
+		return nil, err
+	}
+	if len(encrypted.Nonce) != c.NonceSize() {
+		return nil, fmt.Errorf("%s: bad nonce length %d", c.Name(), len(encrypted.Nonce))
+	}
+	aead, err := newAEAD(c, key)
+	if err != nil {
+		return nil, err
 	}
 
 	// Decrypt
@@ -196,6 +323,18 @@ func decryptWithKey(encrypted *EncryptedMessage, key []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
+// newAEAD creates an AEAD after checking the key suits the cipher
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	if len(key) != c.KeySize() {
+		return nil, fmt.Errorf("%s: bad key length %d", c.Name(), len(key))
+	}
+	aead, err := c.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AEAD cipher: %w", err)
+	}
+	return aead, nil
+}
+
 // deriveRootKey derives the initial root key from shared secret
 func deriveRootKey(sharedSecret []byte) []byte {
 	hash := sha256.Sum256(sharedSecret)
@@ -204,8 +343,8 @@ func deriveRootKey(sharedSecret []byte) []byte {
 
 // deriveChainKey derives a chain key using HKDF
 func deriveChainKey(rootKey, localPublic, remotePublic []byte) ([]byte, error) {
-	// Combine public keys
-	combined := append(localPublic, remotePublic...)
+	// Combine public keys, without appending into localPublic's array
+	combined := append(append([]byte(nil), localPublic...), remotePublic...)
 	
 	// Use HKDF to derive chain key
 	hkdf := hkdf.New(sha256.New, rootKey, nil, combined)
@@ -224,7 +363,7 @@ func deriveMessageKey(chainKey []byte, messageNumber uint32) []byte {
 	h.Write(chainKey)
 	h.Write([]byte{byte(messageNumber), byte(messageNumber >> 8), byte(messageNumber >> 16), byte(messageNumber >> 24)})
 	hash := h.Sum(nil)
-	return hash[:32] // Use first 32 bytes for ChaCha20 key
+	return hash[:32] // Both ciphers take 32-byte keys
 }
 
 // advanceChainKey advances the chain key using a hash function
@@ -251,7 +390,12 @@ func deriveRootAndChainKeys(rootKey, dhOutput []byte) ([]byte, []byte) {
 
 // SimpleEncrypt provides a simple encryption interface for basic use cases
 func SimpleEncrypt(plaintext []byte, key []byte) (*EncryptedMessage, error) {
-	return encryptWithKey(plaintext, key)
+	return encryptWithKey(DefaultCipher, plaintext, key)
+}
+
+// SimpleEncryptWith is SimpleEncrypt with a chosen cipher
+func SimpleEncryptWith(cipherID CipherID, plaintext []byte, key []byte) (*EncryptedMessage, error) {
+	return encryptWithKey(cipherID, plaintext, key)
 }
 
 // SimpleDecrypt provides a simple decryption interface for basic use cases
@@ -3,10 +3,14 @@ package crypto
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"strings"
 
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
 )
 
 // KeyPair represents a cryptographic key pair
@@ -131,16 +135,60 @@ func VerifyPreKey(prekey *PreKey, identityPublicKey []byte) bool {
 	return ed25519.Verify(identityPublicKey, prekey.KeyPair.PublicKey, prekey.Signature)
 }
 
-// generateFingerprint generates a human-readable fingerprint for an identity
+// DeriveLocalKey derives a key of size bytes for local use, such as
+// blinding the search index, from the private signing key. Each purpose
+// passes its own label, so the keys are independent of one another.
+func (k *IdentityKeyPair) DeriveLocalKey(label string, size int) ([]byte, error) {
+	if len(k.SigningKey.PrivateKey) == 0 {
+		return nil, fmt.Errorf("identity has no private key")
+	}
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k.SigningKey.PrivateKey, nil, []byte(label)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+// LegacyFingerprintLength is the length, in hex digits, of the fingerprints
+// earlier versions made: the first 8 bytes of the full fingerprint
+const LegacyFingerprintLength = 16
+
+// generateFingerprint returns the canonical fingerprint of an identity: the
+// SHA-256 of both public keys in hex. It is compared in full; displays
+// shorten it.
 func generateFingerprint(identity *IdentityKeyPair) string {
+	return Fingerprint(identity.SigningKey.PublicKey, identity.ExchangeKey.PublicKey)
+}
+
+// Fingerprint returns the canonical fingerprint of the identity with the
+// given public keys, such as one a peer presented in a key exchange
+func Fingerprint(signingPublicKey, exchangePublicKey []byte) string {
 	// Combine both public keys
-	combined := append(identity.SigningKey.PublicKey, identity.ExchangeKey.PublicKey...)
+	combined := append(append([]byte(nil), signingPublicKey...), exchangePublicKey...)
 	
-	// Hash the combined keys
 	hash := sha256.Sum256(combined)
-	
-	// Convert to hex string
-	return fmt.Sprintf("%x", hash[:8]) // Use first 8 bytes for shorter fingerprint
+	return hex.EncodeToString(hash[:])
+}
+
+// CanonicalFingerprint recomputes the fingerprint of an identity from its
+// public keys, for identities stored with a legacy fingerprint
+func (k *IdentityKeyPair) CanonicalFingerprint() string {
+	return generateFingerprint(k)
+}
+
+// FingerprintsMatch reports whether two fingerprints belong to the same
+// identity. A legacy fingerprint matches the full one it is the start of,
+// so stored fingerprints keep matching until they are upgraded; such a match
+// only has the collision resistance of the 64-bit legacy form.
+func FingerprintsMatch(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if a == "" || (len(a) != len(b) && len(a) != LegacyFingerprintLength) {
+		return false
+	}
+	return SecureCompare([]byte(a), []byte(b[:len(a)]))
 }
 
 // GetSafetyNumber generates a 60-digit safety number for identity verification
@@ -148,8 +196,8 @@ func GetSafetyNumber(localIdentity, remoteIdentity *IdentityKeyPair) string {
 	// Combine identity keys in a deterministic order
 	var combined []byte
 	
-	localCombined := append(localIdentity.SigningKey.PublicKey, localIdentity.ExchangeKey.PublicKey...)
-	remoteCombined := append(remoteIdentity.SigningKey.PublicKey, remoteIdentity.ExchangeKey.PublicKey...)
+	localCombined := append(append([]byte(nil), localIdentity.SigningKey.PublicKey...), localIdentity.ExchangeKey.PublicKey...)
+	remoteCombined := append(append([]byte(nil), remoteIdentity.SigningKey.PublicKey...), remoteIdentity.ExchangeKey.PublicKey...)
 	
 	// Ensure consistent ordering
 	if string(localCombined) < string(remoteCombined) {
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	identity, err := GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	fingerprint := identity.Fingerprint
+	if len(fingerprint) != 64 || strings.Trim(fingerprint, "0123456789abcdef") != "" {
+		t.Fatalf("fingerprint %q is not the full SHA-256 in hex", fingerprint)
+	}
+	if got := Fingerprint(identity.SigningKey.PublicKey, identity.ExchangeKey.PublicKey); got != fingerprint {
+		t.Errorf("Fingerprint = %s, want %s", got, fingerprint)
+	}
+	if got := identity.CanonicalFingerprint(); got != fingerprint {
+		t.Errorf("CanonicalFingerprint = %s, want %s", got, fingerprint)
+	}
+	if Fingerprint(identity.ExchangeKey.PublicKey, identity.SigningKey.PublicKey) == fingerprint {
+		t.Error("swapping the keys gives the same fingerprint")
+	}
+
+	other, err := GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateIdentityKeyPair: %v", err)
+	}
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"same", fingerprint, fingerprint, true},
+		{"different", fingerprint, other.Fingerprint, false},
+		{"legacy of the same", fingerprint[:LegacyFingerprintLength], fingerprint, true},
+		{"legacy of the same, either way round", fingerprint, fingerprint[:LegacyFingerprintLength], true},
+		{"legacy of another", other.Fingerprint[:LegacyFingerprintLength], fingerprint, false},
+		{"upper case", strings.ToUpper(fingerprint), fingerprint, true},
+		{"other prefix length", fingerprint[:32], fingerprint, false},
+		{"empty", "", fingerprint, false},
+		{"both empty", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := FingerprintsMatch(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: FingerprintsMatch = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrWrongPassphrase is returned when sealed data cannot be opened, either
+// because the passphrase is wrong or the data was tampered with
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted data")
+
+// scrypt parameters for passphrase-derived keys
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// Bounds on the scrypt parameters read from sealed data, which may come
+// from anyone. They admit every parameter this package has sealed with
+// while keeping a crafted bundle from demanding more than 1 GiB of memory
+// or minutes of work before the passphrase is even checked.
+const (
+	maxScryptN      = 1 << 20
+	maxScryptR      = 16
+	maxScryptP      = 4
+	maxScryptMemory = 1 << 30
+)
+
+// checkScryptParams fails unless sealed data asks for scrypt parameters
+// within the bounds above and no weaker than the built-in ones
+func checkScryptParams(n, r, p int) error {
+	if n < scryptN || n > maxScryptN || n&(n-1) != 0 ||
+		r < scryptR || r > maxScryptR ||
+		p < scryptP || p > maxScryptP ||
+		128*n*r > maxScryptMemory {
+		return fmt.Errorf("unsupported key derivation parameters N=%d r=%d p=%d", n, r, p)
+	}
+	return nil
+}
+
+// passphraseBox is the encoding of data sealed with a passphrase. The KDF
+// parameters are stored so they can be raised without breaking old data.
+type passphraseBox struct {
+	Version    int    `json:"version"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealWithPassphrase encrypts plaintext with XChaCha20-Poly1305 under a key
+// derived from the passphrase with scrypt
+func SealWithPassphrase(plaintext []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	box := passphraseBox{
+		Version: 1,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, scryptSaltLen),
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(box.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := rand.Read(box.Nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), box.Salt, box.N, box.R, box.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer Zeroize(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	box.Ciphertext = aead.Seal(nil, box.Nonce, plaintext, nil)
+
+	return json.Marshal(box)
+}
+
+// OpenWithPassphrase decrypts data sealed by SealWithPassphrase
+func OpenWithPassphrase(sealed []byte, passphrase string) ([]byte, error) {
+	var box passphraseBox
+	if err := json.Unmarshal(sealed, &box); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWrongPassphrase, err)
+	}
+	if box.Version != 1 {
+		return nil, fmt.Errorf("unsupported sealed data version %d", box.Version)
+	}
+	if len(box.Nonce) != chacha20poly1305.NonceSizeX || len(box.Salt) != scryptSaltLen {
+		return nil, ErrWrongPassphrase
+	}
+	if err := checkScryptParams(box.N, box.R, box.P); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), box.Salt, box.N, box.R, box.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer Zeroize(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	plaintext, err := aead.Open(nil, box.Nonce, box.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
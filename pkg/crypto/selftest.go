@@ -0,0 +1,305 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SelfTestResult is the outcome of one self-test check. Err is nil if the
+// check passed.
+type SelfTestResult struct {
+	Name string
+	Err  error
+}
+
+// selfTestChecks are the checks SelfTest runs, in order
+var selfTestChecks = []struct {
+	name string
+	run  func() error
+}{
+	{"identity vectors", checkIdentityVectors},
+	{"safety number vectors", checkSafetyNumberVectors},
+	{"safety numbers of new identities", checkNewSafetyNumbers},
+	{"key agreement vectors", checkKeyAgreementVectors},
+	{"signature vectors", checkSignatureVectors},
+	{"signatures", checkSignatures},
+	{"cipher vectors", checkCipherVectors},
+	{"encryption round trips", checkEncryption},
+	{"session round trip", checkSession},
+}
+
+// SelfTest checks that the primitives of this build produce the known test
+// vectors, and that new identities, signatures, encryption and sessions
+// work end to end. It returns the result of every check.
+func SelfTest() []SelfTestResult {
+	results := make([]SelfTestResult, 0, len(selfTestChecks))
+	for _, check := range selfTestChecks {
+		results = append(results, SelfTestResult{Name: check.name, Err: check.run()})
+	}
+	return results
+}
+
+// identityFromPrivateKeys rebuilds an identity from an Ed25519 seed and an
+// X25519 private key
+func identityFromPrivateKeys(signingSeed, exchangePrivate []byte) (*IdentityKeyPair, error) {
+	if len(signingSeed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing seed is %d bytes, want %d", len(signingSeed), ed25519.SeedSize)
+	}
+	signingPrivate := ed25519.NewKeyFromSeed(signingSeed)
+	signingPublic := signingPrivate.Public().(ed25519.PublicKey)
+
+	exchangePublic, err := curve25519.X25519(exchangePrivate, curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive exchange public key: %w", err)
+	}
+
+	identity := &IdentityKeyPair{
+		SigningKey: KeyPair{
+			PublicKey:  []byte(signingPublic),
+			PrivateKey: []byte(signingPrivate),
+		},
+		ExchangeKey: KeyPair{
+			PublicKey:  exchangePublic,
+			PrivateKey: append([]byte(nil), exchangePrivate...),
+		},
+	}
+	identity.Fingerprint = generateFingerprint(identity)
+	return identity, nil
+}
+
+func checkIdentityVectors() error {
+	for i, vector := range IdentityVectors {
+		identity, err := vector.Identity()
+		if err != nil {
+			return fmt.Errorf("vector %d: %w", i, err)
+		}
+		if !bytes.Equal(identity.SigningKey.PublicKey, vector.SigningPublic) {
+			return fmt.Errorf("vector %d: wrong signing public key %x", i, identity.SigningKey.PublicKey)
+		}
+		if !bytes.Equal(identity.ExchangeKey.PublicKey, vector.ExchangePublic) {
+			return fmt.Errorf("vector %d: wrong exchange public key %x", i, identity.ExchangeKey.PublicKey)
+		}
+		if identity.Fingerprint != vector.Fingerprint {
+			return fmt.Errorf("vector %d: fingerprint %s, want %s", i, identity.Fingerprint, vector.Fingerprint)
+		}
+	}
+	return nil
+}
+
+func checkSafetyNumberVectors() error {
+	for i, vector := range SafetyNumberVectors {
+		a, err := vector.A.Identity()
+		if err != nil {
+			return fmt.Errorf("vector %d: %w", i, err)
+		}
+		b, err := vector.B.Identity()
+		if err != nil {
+			return fmt.Errorf("vector %d: %w", i, err)
+		}
+		if got := GetSafetyNumber(a, b); got != vector.SafetyNumber {
+			return fmt.Errorf("vector %d: safety number %s, want %s", i, got, vector.SafetyNumber)
+		}
+		if got := GetSafetyNumber(b, a); got != vector.SafetyNumber {
+			return fmt.Errorf("vector %d: reverse safety number %s, want %s", i, got, vector.SafetyNumber)
+		}
+	}
+	return nil
+}
+
+func checkNewSafetyNumbers() error {
+	a, err := GenerateIdentityKeyPair()
+	if err != nil {
+		return err
+	}
+	b, err := GenerateIdentityKeyPair()
+	if err != nil {
+		return err
+	}
+
+	ab, ba := GetSafetyNumber(a, b), GetSafetyNumber(b, a)
+	if ab != ba {
+		return fmt.Errorf("safety numbers differ by side: %s and %s", ab, ba)
+	}
+	if len(ab) != 71 { // 12 groups of 5 digits and the spaces between
+		return fmt.Errorf("safety number %q is not 60 digits", ab)
+	}
+	if a.Fingerprint == b.Fingerprint {
+		return fmt.Errorf("two new identities share fingerprint %s", a.Fingerprint)
+	}
+	if a.Fingerprint != Fingerprint(a.SigningKey.PublicKey, a.ExchangeKey.PublicKey) {
+		return fmt.Errorf("fingerprint %s does not match the public keys", a.Fingerprint)
+	}
+	return nil
+}
+
+func checkKeyAgreementVectors() error {
+	for i, vector := range KeyAgreementVectors {
+		shared, err := GenerateSharedSecret(vector.Private, vector.Public)
+		if err != nil {
+			return fmt.Errorf("vector %d: %w", i, err)
+		}
+		if !bytes.Equal(shared, vector.Shared) {
+			return fmt.Errorf("vector %d: shared secret %x, want %x", i, shared, vector.Shared)
+		}
+	}
+	return nil
+}
+
+func checkSignatureVectors() error {
+	for i, vector := range SignatureVectors {
+		signature := ed25519.Sign(ed25519.NewKeyFromSeed(vector.Seed), vector.Message)
+		if !bytes.Equal(signature, vector.Signature) {
+			return fmt.Errorf("vector %d: signature %x, want %x", i, signature, vector.Signature)
+		}
+		if !VerifySignature(vector.PublicKey, vector.Message, vector.Signature) {
+			return fmt.Errorf("vector %d: signature does not verify", i)
+		}
+	}
+	return nil
+}
+
+func checkSignatures() error {
+	identity, err := GenerateIdentityKeyPair()
+	if err != nil {
+		return err
+	}
+
+	data := []byte("SecureChat self-test")
+	signature := identity.Sign(data)
+	if !VerifySignature(identity.SigningKey.PublicKey, data, signature) {
+		return fmt.Errorf("signature does not verify")
+	}
+	if VerifySignature(identity.SigningKey.PublicKey, []byte("SecureChat self-tesT"), signature) {
+		return fmt.Errorf("signature verifies for altered data")
+	}
+
+	preKey, err := GeneratePreKey(1, identity)
+	if err != nil {
+		return err
+	}
+	if !VerifyPreKey(preKey, identity.SigningKey.PublicKey) {
+		return fmt.Errorf("prekey signature does not verify")
+	}
+	return nil
+}
+
+func checkCipherVectors() error {
+	for _, vector := range CipherVectors {
+		c, err := CipherByID(vector.Cipher)
+		if err != nil {
+			return err
+		}
+		aead, err := c.New(vector.Key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Name(), err)
+		}
+		ciphertext := aead.Seal(nil, vector.Nonce, vector.Plaintext, nil)
+		if !bytes.Equal(ciphertext, vector.Ciphertext) {
+			return fmt.Errorf("%s: ciphertext %x, want %x", c.Name(), ciphertext, vector.Ciphertext)
+		}
+		plaintext, err := aead.Open(nil, vector.Nonce, vector.Ciphertext, nil)
+		if err != nil || !bytes.Equal(plaintext, vector.Plaintext) {
+			return fmt.Errorf("%s: known ciphertext does not decrypt", c.Name())
+		}
+	}
+	return nil
+}
+
+func checkEncryption() error {
+	plaintext := []byte("SecureChat self-test")
+	for _, id := range SupportedCiphers() {
+		key, err := GenerateEphemeralKey()
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := SimpleEncryptWith(id, plaintext, key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("cipher %d: %w", id, err)
+		}
+		decrypted, err := SimpleDecrypt(encrypted, key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("cipher %d: %w", id, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			return fmt.Errorf("cipher %d: decrypted %q, want %q", id, decrypted, plaintext)
+		}
+
+		encrypted.Ciphertext[0] ^= 1
+		if _, err := SimpleDecrypt(encrypted, key.PrivateKey); err == nil {
+			return fmt.Errorf("cipher %d: altered ciphertext decrypts", id)
+		}
+	}
+	return nil
+}
+
+// checkSession runs a key agreement between two new identities as the
+// handshake does, and sends a message each way
+func checkSession() error {
+	alice, err := GenerateIdentityKeyPair()
+	if err != nil {
+		return err
+	}
+	bob, err := GenerateIdentityKeyPair()
+	if err != nil {
+		return err
+	}
+	bobPreKey, err := GeneratePreKey(1, bob)
+	if err != nil {
+		return err
+	}
+	ephemeral, err := GenerateEphemeralKey()
+	if err != nil {
+		return err
+	}
+
+	aliceSecret, err := AgreeInitiator(alice, ephemeral, bob.ExchangeKey.PublicKey, bobPreKey.KeyPair.PublicKey)
+	if err != nil {
+		return err
+	}
+	bobSecret, err := AgreeResponder(bob, bobPreKey.KeyPair, alice.ExchangeKey.PublicKey, ephemeral.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(aliceSecret, bobSecret) {
+		return fmt.Errorf("key agreement gave different secrets")
+	}
+
+	aliceRatchet, err := NewDoubleRatchet(aliceSecret, bobPreKey.KeyPair.PublicKey, DefaultCipher)
+	if err != nil {
+		return err
+	}
+	bobRatchet, err := NewResponderRatchet(bobSecret, bobPreKey.KeyPair, aliceRatchet.DHSelf.PublicKey, DefaultCipher)
+	if err != nil {
+		return err
+	}
+
+	if err := sendOver(aliceRatchet, bobRatchet, "hello bob"); err != nil {
+		return fmt.Errorf("initiator to responder: %w", err)
+	}
+	if err := sendOver(bobRatchet, aliceRatchet, "hello alice"); err != nil {
+		return fmt.Errorf("responder to initiator: %w", err)
+	}
+	return nil
+}
+
+// sendOver encrypts a message with one side of a session and decrypts it
+// with the other
+func sendOver(from, to *DoubleRatchet, text string) error {
+	number := from.SendingChain.MessageNumber
+	encrypted, err := from.Encrypt([]byte(text))
+	if err != nil {
+		return err
+	}
+	decrypted, err := to.Decrypt(encrypted, number)
+	if err != nil {
+		return err
+	}
+	if string(decrypted) != text {
+		return fmt.Errorf("decrypted %q, want %q", decrypted, text)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"testing"
+)
+
+// failedChecks returns the names of the self-test checks that failed
+func failedChecks() []string {
+	var failed []string
+	for _, result := range SelfTest() {
+		if result.Err != nil {
+			failed = append(failed, result.Name)
+		}
+	}
+	return failed
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	results := SelfTest()
+	if len(results) != len(selfTestChecks) {
+		t.Fatalf("%d results, want one per check", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: %v", result.Name, result.Err)
+		}
+	}
+}
+
+func TestSafetyNumberVectors(t *testing.T) {
+	for i, vector := range SafetyNumberVectors {
+		a, err := vector.A.Identity()
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		b, err := vector.B.Identity()
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if ab, ba := GetSafetyNumber(a, b), GetSafetyNumber(b, a); ab != vector.SafetyNumber || ba != vector.SafetyNumber {
+			t.Errorf("vector %d: safety numbers %s and %s, want %s from both sides", i, ab, ba, vector.SafetyNumber)
+		}
+		if a.Fingerprint != vector.A.Fingerprint || b.Fingerprint != vector.B.Fingerprint {
+			t.Errorf("vector %d: fingerprints %s and %s, want %s and %s", i, a.Fingerprint, b.Fingerprint, vector.A.Fingerprint, vector.B.Fingerprint)
+		}
+	}
+}
+
+// brokenCipher is ChaCha20-Poly1305 with a flipped ciphertext bit, as a
+// miscompiled or tampered primitive might produce
+type brokenCipher struct{ chacha20Poly1305Cipher }
+
+type brokenAEAD struct{ cipher.AEAD }
+
+func (brokenCipher) New(key []byte) (cipher.AEAD, error) {
+	aead, err := chacha20Poly1305Cipher{}.New(key)
+	return brokenAEAD{aead}, err
+}
+
+func (a brokenAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	sealed := a.AEAD.Seal(dst, nonce, plaintext, additionalData)
+	sealed[len(dst)] ^= 1
+	return sealed
+}
+
+func TestSelfTestFailsWithBrokenPrimitive(t *testing.T) {
+	t.Run("cipher", func(t *testing.T) {
+		saved := ciphers
+		ciphers = []Cipher{brokenCipher{}, aes256GCMCipher{}}
+		defer func() { ciphers = saved }()
+
+		failed := failedChecks()
+		want := map[string]bool{"cipher vectors": true, "encryption round trips": true, "session round trip": true}
+		if len(failed) != len(want) {
+			t.Errorf("failed checks = %v, want %v", failed, want)
+		}
+		for _, name := range failed {
+			if !want[name] {
+				t.Errorf("%s failed with only the cipher broken", name)
+			}
+		}
+	})
+
+	t.Run("fingerprint", func(t *testing.T) {
+		saved := IdentityVectors[0].Fingerprint
+		IdentityVectors[0].Fingerprint = SafetyNumberVectors[0].B.Fingerprint
+		defer func() { IdentityVectors[0].Fingerprint = saved }()
+
+		if failed := failedChecks(); len(failed) != 1 || failed[0] != "identity vectors" {
+			t.Errorf("failed checks = %v, want identity vectors", failed)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package crypto
+
+import "encoding/hex"
+
+// IdentityVector is an identity made from fixed private keys, with the
+// public keys and fingerprint it must have
+type IdentityVector struct {
+	SigningSeed     []byte // Ed25519 seed
+	ExchangePrivate []byte // X25519 private key
+	SigningPublic   []byte
+	ExchangePublic  []byte
+	Fingerprint     string
+}
+
+// Identity returns the identity the vector describes
+func (v IdentityVector) Identity() (*IdentityKeyPair, error) {
+	return identityFromPrivateKeys(v.SigningSeed, v.ExchangePrivate)
+}
+
+// SafetyNumberVector is the safety number two identities must have, from
+// either side
+type SafetyNumberVector struct {
+	A, B         IdentityVector
+	SafetyNumber string
+}
+
+// KeyAgreementVector is an X25519 exchange and the secret it must produce
+type KeyAgreementVector struct {
+	Private []byte
+	Public  []byte
+	Shared  []byte
+}
+
+// SignatureVector is an Ed25519 signature a seed must produce for a message
+type SignatureVector struct {
+	Seed      []byte
+	PublicKey []byte
+	Message   []byte
+	Signature []byte
+}
+
+// CipherVector is the ciphertext, tag included, a message cipher must
+// produce for a key, nonce and plaintext
+type CipherVector struct {
+	Cipher     CipherID
+	Key        []byte
+	Nonce      []byte
+	Plaintext  []byte
+	Ciphertext []byte
+}
+
+// The identities combine the Ed25519 keys of RFC 8032 section 7.1 with the
+// X25519 keys of RFC 7748 section 6.1. Fingerprints, safety numbers and
+// ciphertexts are pinned from a build whose primitives match the RFCs, so
+// any change to how they are made shows up.
+var (
+	aliceVector = IdentityVector{
+		SigningSeed:     mustHex("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"),
+		ExchangePrivate: mustHex("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"),
+		SigningPublic:   mustHex("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"),
+		ExchangePublic:  mustHex("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"),
+		Fingerprint:     "422e8dd4b8aed6b9cf40567efd79e9a4d5fb85412857331a2a9764e54add39f4",
+	}
+	bobVector = IdentityVector{
+		SigningSeed:     mustHex("4ccd089b28ff96da9db6c346ec114e0f5b8a319f35aba624da8cf6ed4fb8a6fb"),
+		ExchangePrivate: mustHex("5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"),
+		SigningPublic:   mustHex("3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c"),
+		ExchangePublic:  mustHex("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"),
+		Fingerprint:     "71fae9b9815f9f89c502d7a20c47c1924a3609843df23c36740b5bbe62ea2002",
+	}
+
+	// IdentityVectors are identities with known public keys and fingerprints
+	IdentityVectors = []IdentityVector{aliceVector, bobVector}
+
+	// SafetyNumberVectors are pairs of identities with known safety numbers
+	SafetyNumberVectors = []SafetyNumberVector{
+		{A: aliceVector, B: bobVector, SafetyNumber: "48351 06354 95928 31884 49542 33614 94483 51063 54959 28318 84495 42336"},
+	}
+
+	// KeyAgreementVectors are the X25519 exchanges of RFC 7748 section 6.1
+	KeyAgreementVectors = []KeyAgreementVector{
+		{
+			Private: aliceVector.ExchangePrivate,
+			Public:  bobVector.ExchangePublic,
+			Shared:  mustHex("4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"),
+		},
+		{
+			Private: bobVector.ExchangePrivate,
+			Public:  aliceVector.ExchangePublic,
+			Shared:  mustHex("4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"),
+		},
+	}
+
+	// SignatureVectors are the first two Ed25519 tests of RFC 8032 section 7.1
+	SignatureVectors = []SignatureVector{
+		{
+			Seed:      aliceVector.SigningSeed,
+			PublicKey: aliceVector.SigningPublic,
+			Message:   nil,
+			Signature: mustHex("e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b"),
+		},
+		{
+			Seed:      bobVector.SigningSeed,
+			PublicKey: bobVector.SigningPublic,
+			Message:   mustHex("72"),
+			Signature: mustHex("92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00"),
+		},
+	}
+
+	// CipherVectors are known ciphertexts of every message cipher
+	CipherVectors = []CipherVector{
+		{
+			Cipher:     CipherChaCha20Poly1305,
+			Key:        mustHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			Nonce:      mustHex("000000000000004a00000000"),
+			Plaintext:  []byte("SecureChat self-test"),
+			Ciphertext: mustHex("712a3286327e9a894eaa071cdd0f7bc0f8766cf651d7ec32890a405b49314f4e8ad7d907"),
+		},
+		{
+			Cipher:     CipherAES256GCM,
+			Key:        mustHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),
+			Nonce:      mustHex("000000000000004a00000000"),
+			Plaintext:  []byte("SecureChat self-test"),
+			Ciphertext: mustHex("26fdb144fe1a184632c41e645bd7edc0e448a74d29cca8ee96c7c7470bc68cd4f82546b7"),
+		},
+	}
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
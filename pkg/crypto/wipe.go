@@ -0,0 +1,34 @@
+package crypto
+
+// Zeroize overwrites b with zeros. Go may have copied the bytes elsewhere
+// (for example when a slice grew), so this is best effort: it removes the
+// copy we hold, not every copy the runtime ever made.
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Wipe zeroizes the private half of the key pair
+func (kp *KeyPair) Wipe() {
+	Zeroize(kp.PrivateKey)
+}
+
+// Wipe zeroizes the session keys
+func (k *SessionKeys) Wipe() {
+	Zeroize(k.RootKey)
+	Zeroize(k.ChainKey)
+	Zeroize(k.MessageKey)
+}
+
+// Wipe zeroizes all secret ratchet state. The ratchet cannot be used afterwards.
+func (dr *DoubleRatchet) Wipe() {
+	Zeroize(dr.RootKey)
+	dr.DHSelf.Wipe()
+	if dr.SendingChain != nil {
+		Zeroize(dr.SendingChain.ChainKey)
+	}
+	if dr.ReceivingChain != nil {
+		Zeroize(dr.ReceivingChain.ChainKey)
+	}
+}
@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testRatchet returns a fresh ratchet with a random remote key
+func testRatchet(t *testing.T) *DoubleRatchet {
+	t.Helper()
+
+	remote, err := GenerateEphemeralKey()
+	if err != nil {
+		t.Fatalf("GenerateEphemeralKey: %v", err)
+	}
+	dr, err := NewDoubleRatchet(bytes.Repeat([]byte{7}, 32), remote.PublicKey, DefaultCipher)
+	if err != nil {
+		t.Fatalf("NewDoubleRatchet: %v", err)
+	}
+	return dr
+}
+
+// isZero reports whether b is non-empty and all zeros
+func isZero(b []byte) bool {
+	return len(b) > 0 && bytes.Count(b, []byte{0}) == len(b)
+}
+
+func TestRatchetWipe(t *testing.T) {
+	dr := testRatchet(t)
+	secrets := map[string][]byte{
+		"root key":            dr.RootKey,
+		"ratchet key":         dr.DHSelf.PrivateKey,
+		"sending chain key":   dr.SendingChain.ChainKey,
+		"receiving chain key": dr.ReceivingChain.ChainKey,
+	}
+
+	dr.Wipe()
+	for name, secret := range secrets {
+		if !isZero(secret) {
+			t.Errorf("%s not zeroized", name)
+		}
+	}
+	if isZero(dr.DHSelf.PublicKey) {
+		t.Error("public ratchet key was zeroized")
+	}
+}
+
+func TestEncryptZeroizesTheOldChainKey(t *testing.T) {
+	dr := testRatchet(t)
+	old := dr.SendingChain.ChainKey
+	if _, err := dr.Encrypt([]byte("hello")); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !isZero(old) {
+		t.Error("the chain key Encrypt moved past was not zeroized")
+	}
+	if isZero(dr.SendingChain.ChainKey) {
+		t.Error("the current chain key was zeroized")
+	}
+}
+
+func TestSessionKeysWipe(t *testing.T) {
+	keys := &SessionKeys{
+		RootKey:    bytes.Repeat([]byte{1}, 32),
+		ChainKey:   bytes.Repeat([]byte{2}, 32),
+		MessageKey: bytes.Repeat([]byte{3}, 32),
+	}
+	keys.Wipe()
+	if !isZero(keys.RootKey) || !isZero(keys.ChainKey) || !isZero(keys.MessageKey) {
+		t.Errorf("session keys not zeroized: %+v", keys)
+	}
+}
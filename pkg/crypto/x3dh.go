@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// x3dhInfo binds the shared secret of a key agreement to this protocol
+const x3dhInfo = "SecureChat-X3DH"
+
+// GenerateEphemeralKey generates an X25519 key pair for a single key agreement
+func GenerateEphemeralKey() (KeyPair, error) {
+	private := make([]byte, 32)
+	if _, err := rand.Read(private); err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate ephemeral private key: %w", err)
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate ephemeral public key: %w", err)
+	}
+	return KeyPair{PublicKey: public, PrivateKey: private}, nil
+}
+
+// AgreeInitiator computes the shared secret of a session the local identity
+// starts with a peer, from the peer's exchange key and signed prekey:
+//
+//	DH1 = DH(IK_A, SPK_B), DH2 = DH(EK_A, IK_B), DH3 = DH(EK_A, SPK_B)
+//	SK = HKDF(DH1 || DH2 || DH3)
+//
+// The peer's prekey signature must have been checked with VerifyPreKey.
+func AgreeInitiator(identity *IdentityKeyPair, ephemeral KeyPair, remoteExchangeKey, remotePreKey []byte) ([]byte, error) {
+	return agree(
+		[2][]byte{identity.ExchangeKey.PrivateKey, remotePreKey},
+		[2][]byte{ephemeral.PrivateKey, remoteExchangeKey},
+		[2][]byte{ephemeral.PrivateKey, remotePreKey},
+	)
+}
+
+// AgreeResponder computes the same shared secret as AgreeInitiator on the
+// side of the peer whose signed prekey was used
+func AgreeResponder(identity *IdentityKeyPair, preKey KeyPair, remoteExchangeKey, remoteEphemeralKey []byte) ([]byte, error) {
+	return agree(
+		[2][]byte{preKey.PrivateKey, remoteExchangeKey},
+		[2][]byte{identity.ExchangeKey.PrivateKey, remoteEphemeralKey},
+		[2][]byte{preKey.PrivateKey, remoteEphemeralKey},
+	)
+}
+
+// agree runs the three Diffie-Hellman exchanges, each a private and a public
+// key, and derives the shared secret from their outputs
+func agree(exchanges ...[2][]byte) ([]byte, error) {
+	var material []byte
+	defer func() { Zeroize(material) }()
+
+	for i, exchange := range exchanges {
+		shared, err := curve25519.X25519(exchange[0], exchange[1])
+		if err != nil {
+			return nil, fmt.Errorf("key agreement step %d failed: %w", i+1, err)
+		}
+		material = append(material, shared...)
+		Zeroize(shared)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, material, nil, []byte(x3dhInfo)), secret); err != nil {
+		return nil, fmt.Errorf("failed to derive shared secret: %w", err)
+	}
+	return secret, nil
+}
+
+// Sign signs data with the identity's signing key
+func (k *IdentityKeyPair) Sign(data []byte) []byte {
+	return ed25519.Sign(k.SigningKey.PrivateKey, data)
+}
+
+// VerifySignature reports whether signature is a valid signature of data by
+// the identity with the given signing public key
+func VerifySignature(signingPublicKey, data, signature []byte) bool {
+	if len(signingPublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(signingPublicKey, data, signature)
+}
@@ -0,0 +1,318 @@
+package network
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// RateLimit limits how many messages each client may send to the relay
+type RateLimit struct {
+	MessagesPerSecond float64 `json:"messages_per_second"` // 0 disables limiting
+	Burst             int     `json:"burst"`
+}
+
+// AdminClientInfo describes a connected client in the admin API
+type AdminClientInfo struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"user_id"`
+	LastSeen time.Time `json:"last_seen"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+}
+
+// startAdmin starts the admin API listener if it is configured
+func (s *Server) startAdmin() {
+	if s.adminAddr == "" {
+		return
+	}
+	if s.adminToken == "" {
+		log.Printf("Admin API disabled: an admin token is required")
+		return
+	}
+
+	s.adminServer = &http.Server{
+		Addr:    s.adminAddr,
+		Handler: s.AdminHandler(),
+	}
+
+	go func() {
+		log.Printf("Starting relay admin API on %s", s.adminAddr)
+		if err := s.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin API failed: %v", err)
+		}
+	}()
+}
+
+// AdminHandler returns the token-protected admin API handler
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/clients", s.handleAdminClients)
+	mux.HandleFunc("/admin/clients/", s.handleAdminKick)
+	mux.HandleFunc("/admin/queues", s.handleAdminQueues)
+	mux.HandleFunc("/admin/ratelimit", s.handleAdminRateLimit)
+	mux.HandleFunc("/admin/announce", s.handleAdminAnnounce)
+
+	return s.requireAdminToken(mux)
+}
+
+// requireAdminToken rejects requests without the admin bearer token
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, s.adminToken) {
+			writeAdminError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasBearerToken reports whether r is authorized with "Bearer " and token.
+// Any other scheme, or a bare token, is refused, as is every request when no
+// token is configured.
+func hasBearerToken(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Page sizes of the admin client listing
+const (
+	defaultAdminClientLimit = 100
+	maxAdminClientLimit     = 1000
+)
+
+// adminClientFilter selects the clients an admin listing returns
+type adminClientFilter struct {
+	after      string        // Only clients whose ID sorts after this cursor
+	userPrefix string        // Only users whose ID starts with this
+	minIdle    time.Duration // Only clients idle for at least this long
+	limit      int
+}
+
+// parseAdminClientFilter reads the query of a client listing:
+// cursor, user_prefix, min_idle (a duration such as 5m) and limit
+func parseAdminClientFilter(r *http.Request) (adminClientFilter, error) {
+	query := r.URL.Query()
+	filter := adminClientFilter{
+		after:      query.Get("cursor"),
+		userPrefix: models.NormalizeUserID(query.Get("user_prefix")),
+		limit:      defaultAdminClientLimit,
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAdminClientLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxAdminClientLimit)
+		}
+		filter.limit = limit
+	}
+	if value := query.Get("min_idle"); value != "" {
+		idle, err := time.ParseDuration(value)
+		if err != nil || idle < 0 {
+			return filter, fmt.Errorf("invalid min_idle: %q", value)
+		}
+		filter.minIdle = idle
+	}
+	return filter, nil
+}
+
+// matches reports whether a client passes the user and idle filters
+func (f adminClientFilter) matches(info AdminClientInfo, now time.Time) bool {
+	if f.userPrefix != "" && !strings.HasPrefix(info.UserID, f.userPrefix) {
+		return false
+	}
+	return now.Sub(info.LastSeen) >= f.minIdle
+}
+
+// handleAdminClients lists connected clients in pages ordered by client ID.
+// A response with next_cursor set has more clients; pass it back as cursor
+// to get them. Clients that connect or leave between pages do not shift the
+// pages, since the cursor is the last ID returned.
+func (s *Server) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	filter, err := parseAdminClientFilter(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	clients, next := s.listClients(filter, time.Now())
+
+	response := map[string]interface{}{
+		"clients": clients,
+	}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+	writeAdminJSON(w, http.StatusOK, response)
+}
+
+// listClients returns a page of clients and the cursor of the next page,
+// empty on the last one. Only the client pointers are copied under the
+// clients lock; each client is then read under its own lock.
+func (s *Server) listClients(filter adminClientFilter, now time.Time) ([]AdminClientInfo, string) {
+	s.clientsMux.RLock()
+	candidates := make([]*ServerClient, 0, len(s.clients))
+	for id, client := range s.clients {
+		if id > filter.after {
+			candidates = append(candidates, client)
+		}
+	}
+	s.clientsMux.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	clients := make([]AdminClientInfo, 0, min(filter.limit, len(candidates)))
+	for _, client := range candidates {
+		info := client.info()
+		if !filter.matches(info, now) {
+			continue
+		}
+		if len(clients) == filter.limit {
+			// One more match exists; the page ends at the last one returned
+			return clients, clients[len(clients)-1].ID
+		}
+		clients = append(clients, info)
+	}
+	return clients, ""
+}
+
+// handleAdminKick disconnects a client: POST /admin/clients/{id}/kick. The
+// client is told why, and whatever was queued for it is written first.
+func (s *Server) handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/admin/clients/")
+	clientID, action, found := strings.Cut(path, "/")
+	if !found || action != "kick" || clientID == "" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.clientsMux.RLock()
+	client, exists := s.clients[clientID]
+	s.clientsMux.RUnlock()
+	if !exists {
+		writeAdminError(w, http.StatusNotFound, "client not found")
+		return
+	}
+
+	log.Printf("Admin kicked client %s (user %s)", client.ID, client.user())
+	client.sendError("KICKED", "disconnected by the relay administrator", "")
+	client.closeAfterFlush()
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"kicked": client.ID,
+	})
+}
+
+// handleAdminQueues reports the offline queue depth per user
+func (s *Server) handleAdminQueues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"queues": s.offline.depths(),
+	})
+}
+
+// handleAdminRateLimit reads or replaces the per-client rate limit
+func (s *Server) handleAdminRateLimit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, s.RateLimit())
+
+	case http.MethodPut:
+		var limit RateLimit
+		if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid rate limit: "+err.Error())
+			return
+		}
+		if limit.MessagesPerSecond < 0 || limit.Burst < 0 {
+			writeAdminError(w, http.StatusBadRequest, "rate limit values cannot be negative")
+			return
+		}
+		s.SetRateLimit(limit)
+		log.Printf("Admin set rate limit to %.2f msg/s (burst %d)", limit.MessagesPerSecond, limit.Burst)
+		writeAdminJSON(w, http.StatusOK, s.RateLimit())
+
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAdminAnnounce broadcasts an announcement to connected clients:
+// POST /admin/announce {"text": "..."}
+func (s *Server) handleAdminAnnounce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var request AnnouncementPayload
+	body := http.MaxBytesReader(w, r.Body, 4*MaxAnnouncementLength+1024)
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid announcement: "+err.Error())
+		return
+	}
+
+	sent, err := s.Announce(request.Text)
+	switch {
+	case errors.Is(err, ErrAnnouncementTooSoon):
+		writeAdminError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"sent": sent,
+	})
+}
+
+// RateLimit returns the current per-client rate limit
+func (s *Server) RateLimit() RateLimit {
+	s.rateLimitMux.RLock()
+	defer s.rateLimitMux.RUnlock()
+	return s.rateLimit
+}
+
+// SetRateLimit replaces the per-client rate limit
+func (s *Server) SetRateLimit(limit RateLimit) {
+	s.rateLimitMux.Lock()
+	s.rateLimit = limit
+	s.rateLimitMux.Unlock()
+}
+
+// writeAdminJSON writes a JSON response
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAdminError writes a JSON error response
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{
+		"error": message,
+	})
+}
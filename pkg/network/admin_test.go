@@ -0,0 +1,394 @@
+package network
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAdminToken is the admin token of relays in admin tests
+const testAdminToken = "admin-secret"
+
+// adminRequest calls the admin API of server with token, decoding the
+// JSON response into dest unless it is nil, and returns the status code
+func adminRequest(t *testing.T, server *Server, method, path, body, token string, dest interface{}) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	server.AdminHandler().ServeHTTP(rec, req)
+
+	if dest != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), dest); err != nil {
+			t.Fatalf("%s %s answered %s: %v", method, path, rec.Body, err)
+		}
+	}
+	return rec.Code
+}
+
+// clientIDOf returns the relay's ID for the session of userID
+func clientIDOf(t *testing.T, server *Server, userID string) string {
+	t.Helper()
+
+	var listing struct {
+		Clients []AdminClientInfo `json:"clients"`
+	}
+	adminRequest(t, server, http.MethodGet, "/admin/clients", "", testAdminToken, &listing)
+	for _, client := range listing.Clients {
+		if client.UserID == userID {
+			return client.ID
+		}
+	}
+	t.Fatalf("%s is not in the client listing", userID)
+	return ""
+}
+
+func TestAdminAPIRequiresToken(t *testing.T) {
+	paths := []string{"/admin/clients", "/admin/queues", "/admin/ratelimit"}
+	tests := []struct {
+		name       string
+		serverKey  string
+		requestKey string
+	}{
+		{"missing", testAdminToken, ""},
+		{"wrong", testAdminToken, "guess"},
+		{"not configured", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestRelay(t, ServerOptions{AdminToken: tt.serverKey})
+			for _, path := range paths {
+				if code := adminRequest(t, server, http.MethodGet, path, "", tt.requestKey, nil); code != http.StatusUnauthorized {
+					t.Errorf("GET %s = %d, want 401", path, code)
+				}
+			}
+		})
+	}
+}
+
+func TestTokensNeedBearerScheme(t *testing.T) {
+	const token = "shared-secret"
+	server := newTestRelay(t, ServerOptions{AdminToken: token, FederationToken: token})
+	handlers := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		path    string
+	}{
+		{"admin", server.AdminHandler(), http.MethodGet, "/admin/clients"},
+		{"federation", server.FederationHandler(), http.MethodPost, federationPath},
+	}
+	headers := []string{token, "Basic " + token, "bearer " + token, "Bearer" + token, "Bearer  " + token}
+
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			for _, header := range headers {
+				req := httptest.NewRequest(h.method, h.path, strings.NewReader("{}"))
+				req.Header.Set("Authorization", header)
+				rec := httptest.NewRecorder()
+				h.handler.ServeHTTP(rec, req)
+				if rec.Code != http.StatusUnauthorized {
+					t.Errorf("Authorization %q: %d, want 401", header, rec.Code)
+				}
+			}
+
+			req := httptest.NewRequest(h.method, h.path, strings.NewReader("{}"))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			h.handler.ServeHTTP(rec, req)
+			if rec.Code == http.StatusUnauthorized {
+				t.Errorf("Authorization \"Bearer <token>\" was refused")
+			}
+		})
+	}
+}
+
+func TestAdminListsClients(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+	alice := connectAs(t, server, "alice", "laptop", nil)
+	connectAs(t, server, "bob", "phone", nil)
+	alice.send(newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "hi"}))
+	waitFor(t, "the message to be routed", func() bool { return server.Stats().MessagesRouted == 1 })
+
+	var listing struct {
+		Clients []AdminClientInfo `json:"clients"`
+	}
+	if code := adminRequest(t, server, http.MethodGet, "/admin/clients", "", testAdminToken, &listing); code != http.StatusOK {
+		t.Fatalf("GET /admin/clients = %d", code)
+	}
+	users := map[string]AdminClientInfo{}
+	for _, client := range listing.Clients {
+		users[client.UserID] = client
+	}
+	if len(users) != 2 {
+		t.Fatalf("listed %v, want alice and bob", listing.Clients)
+	}
+	if users["alice"].BytesIn == 0 || users["bob"].BytesOut == 0 {
+		t.Errorf("traffic not counted: %+v", listing.Clients)
+	}
+}
+
+// clientPage is one page of the admin client listing
+type clientPage struct {
+	Clients    []AdminClientInfo `json:"clients"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+// users returns the user IDs of the page's clients in order
+func (p clientPage) users() string {
+	var users []string
+	for _, client := range p.Clients {
+		users = append(users, client.UserID)
+	}
+	return strings.Join(users, ",")
+}
+
+// listPages follows the cursor of the client listing from query to the last
+// page, returning each page's users
+func listPages(t *testing.T, server *Server, query string) []string {
+	t.Helper()
+
+	var pages []string
+	cursor := ""
+	for {
+		var page clientPage
+		path := "/admin/clients?" + query + "&cursor=" + url.QueryEscape(cursor)
+		if code := adminRequest(t, server, http.MethodGet, path, "", testAdminToken, &page); code != http.StatusOK {
+			t.Fatalf("GET %s = %d", path, code)
+		}
+		pages = append(pages, page.users())
+		if page.NextCursor == "" {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatal("the listing never ends")
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestAdminClientListingPages(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+	users := []string{"alice", "ann", "bob", "carol", "dave"}
+	for _, userID := range users {
+		connectAs(t, server, userID, "laptop", nil)
+	}
+	// Pages are ordered by client ID, so map the users onto that order
+	var all clientPage
+	adminRequest(t, server, http.MethodGet, "/admin/clients", "", testAdminToken, &all)
+	byID := all.users()
+	ordered := strings.Split(byID, ",")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"limit=2", []string{strings.Join(ordered[:2], ","), strings.Join(ordered[2:4], ","), ordered[4]}},
+		{"limit=5", []string{byID}},
+		{"limit=4", []string{strings.Join(ordered[:4], ","), ordered[4]}},
+		{"limit=1000", []string{byID}},
+	}
+	for _, tt := range tests {
+		if got := listPages(t, server, tt.query); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: pages %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// A client leaving between pages does not shift the next page
+	var first clientPage
+	adminRequest(t, server, http.MethodGet, "/admin/clients?limit=2", "", testAdminToken, &first)
+	adminRequest(t, server, http.MethodPost, "/admin/clients/"+first.Clients[0].ID+"/kick", "", testAdminToken, nil)
+	waitFor(t, "the client to leave", func() bool { return server.findClientByUserID(first.Clients[0].UserID) == nil })
+	var second clientPage
+	adminRequest(t, server, http.MethodGet, "/admin/clients?limit=2&cursor="+url.QueryEscape(first.NextCursor), "", testAdminToken, &second)
+	if got := second.users(); got != strings.Join(ordered[2:4], ",") {
+		t.Errorf("second page = %s, want %s", got, strings.Join(ordered[2:4], ","))
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=many", "min_idle=soon", "min_idle=-1m"} {
+		if code := adminRequest(t, server, http.MethodGet, "/admin/clients?"+query, "", testAdminToken, nil); code != http.StatusBadRequest {
+			t.Errorf("GET with %s = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestAdminClientListingFilters(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+	for _, userID := range []string{"alice", "ann", "bob", "carol"} {
+		connectAs(t, server, userID, "laptop", nil)
+	}
+	for _, userID := range []string{"ann", "carol"} {
+		client := server.findClientByUserID(userID)
+		client.mu.Lock()
+		client.LastSeen = time.Now().Add(-time.Hour)
+		client.mu.Unlock()
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"user_prefix=a", "alice,ann"},
+		{"user_prefix=A", "alice,ann"},
+		{"user_prefix=zed", ""},
+		{"min_idle=30m", "ann,carol"},
+		{"min_idle=30m&user_prefix=a", "ann"},
+	}
+	for _, tt := range tests {
+		var page clientPage
+		adminRequest(t, server, http.MethodGet, "/admin/clients?"+tt.query, "", testAdminToken, &page)
+		users := strings.Split(page.users(), ",")
+		sort.Strings(users)
+		if got := strings.Join(users, ","); got != tt.want {
+			t.Errorf("%s: listed %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	// Filtered pages still end where the matches do
+	if got := listPages(t, server, "user_prefix=a&limit=1"); len(got) != 2 {
+		t.Errorf("pages = %q, want one per matching user", got)
+	}
+}
+
+func TestAdminQueues(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+	alice := connectAs(t, server, "alice", "laptop", nil)
+	for i := 0; i < 2; i++ {
+		msg := newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "hi"})
+		alice.send(msg)
+		alice.expectStatus(msg.ID)
+	}
+
+	var queues struct {
+		Queues map[string]int `json:"queues"`
+	}
+	if code := adminRequest(t, server, http.MethodGet, "/admin/queues", "", testAdminToken, &queues); code != http.StatusOK {
+		t.Fatalf("GET /admin/queues = %d", code)
+	}
+	if queues.Queues["bob"] != 2 {
+		t.Errorf("queues = %v, want 2 for bob", queues.Queues)
+	}
+}
+
+func TestAdminRateLimit(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+
+	var limit RateLimit
+	if code := adminRequest(t, server, http.MethodPut, "/admin/ratelimit", `{"messages_per_second":0.5,"burst":1}`, testAdminToken, &limit); code != http.StatusOK {
+		t.Fatalf("PUT /admin/ratelimit = %d", code)
+	}
+	if limit.MessagesPerSecond != 0.5 || limit.Burst != 1 {
+		t.Errorf("rate limit = %+v, want 0.5/s with a burst of 1", limit)
+	}
+	if code := adminRequest(t, server, http.MethodPut, "/admin/ratelimit", `{"burst":-1}`, testAdminToken, nil); code != http.StatusBadRequest {
+		t.Errorf("PUT of a negative burst = %d, want 400", code)
+	}
+
+	// The second message of a burst of one is refused
+	alice := connectAs(t, server, "alice", "laptop", nil)
+	alice.send(newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "one"}))
+	alice.send(newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "two"}))
+	alice.expectError("RATE_LIMITED")
+}
+
+func TestAdminKickTellsClientWhy(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+	alice := connectAs(t, server, "alice", "laptop", nil)
+	bob := connectAs(t, server, "bob", "phone", nil)
+
+	// A message queued before the kick is still written
+	msg := newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "hi"})
+	bob.send(msg)
+	waitFor(t, "the message to be routed", func() bool { return server.Stats().MessagesRouted == 1 })
+
+	path := "/admin/clients/" + clientIDOf(t, server, "alice") + "/kick"
+	if code := adminRequest(t, server, http.MethodPost, path, "", testAdminToken, nil); code != http.StatusOK {
+		t.Fatalf("POST %s = %d", path, code)
+	}
+
+	if got := alice.expect(MessageTypeChat); got.ID != msg.ID {
+		t.Errorf("alice got message %s, want %s", got.ID, msg.ID)
+	}
+	alice.expectError("KICKED")
+	alice.expectClosed()
+
+	if code := adminRequest(t, server, http.MethodPost, "/admin/clients/nobody/kick", "", testAdminToken, nil); code != http.StatusNotFound {
+		t.Errorf("kicking an unknown client = %d, want 404", code)
+	}
+}
+
+func TestAdminAnnouncementReachesConnectedClients(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{AdminToken: testAdminToken})
+	alice := connectAs(t, server, "alice", "laptop", nil)
+	bob := connectAs(t, server, "bob", "phone", nil)
+
+	// A client from before announcements is left out
+	carol := dialRelay(t, server)
+	hello := helloMessage("carol", "tablet", nil)
+	hello.Payload["max_version"] = ProtocolVersion3
+	carol.send(hello)
+	carol.expect(MessageTypeServerHello)
+
+	for _, body := range []string{`{"text": "  "}`, `{"text": "` + strings.Repeat("é", MaxAnnouncementLength+1) + `"}`, `not json`} {
+		if code := adminRequest(t, server, http.MethodPost, "/admin/announce", body, testAdminToken, nil); code != http.StatusBadRequest {
+			t.Errorf("announcing %.20s… = %d, want 400", body, code)
+		}
+	}
+
+	var result struct {
+		Sent int `json:"sent"`
+	}
+	body := `{"text": "Relay restarting in 5 minutes"}`
+	if code := adminRequest(t, server, http.MethodPost, "/admin/announce", body, testAdminToken, &result); code != http.StatusOK {
+		t.Fatalf("POST /admin/announce = %d", code)
+	}
+	if result.Sent != 2 {
+		t.Errorf("announcement sent to %d clients, want alice and bob", result.Sent)
+	}
+	for name, session := range map[string]*rawSession{"alice": alice, "bob": bob} {
+		msg := session.expect(MessageTypeAnnouncement)
+		var payload AnnouncementPayload
+		if err := msg.DecodePayload(&payload); err != nil {
+			t.Fatalf("DecodePayload: %v", err)
+		}
+		if msg.From != "server" || payload.Text != "Relay restarting in 5 minutes" {
+			t.Errorf("%s got %q from %s", name, payload.Text, msg.From)
+		}
+	}
+
+	// Only one announcement per interval
+	if code := adminRequest(t, server, http.MethodPost, "/admin/announce", body, testAdminToken, nil); code != http.StatusTooManyRequests {
+		t.Errorf("announcing again right away = %d, want 429", code)
+	}
+
+	// Announcements from clients are not routed, and routing carries on
+	alice.send(newMessage(MessageTypeAnnouncement, "alice", "carol", AnnouncementPayload{Text: "free crypto"}))
+	chat := newMessage(MessageTypeChat, "bob", "carol", ChatPayload{Content: "hi carol"})
+	bob.send(chat)
+	for {
+		msg := carol.next()
+		if msg.Type == MessageTypeAnnouncement {
+			t.Fatalf("carol got an announcement: %+v", msg)
+		}
+		if msg.Type == MessageTypeChat {
+			if msg.ID != chat.ID {
+				t.Errorf("carol got message %s, want %s", msg.ID, chat.ID)
+			}
+			break
+		}
+	}
+}
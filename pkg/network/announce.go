@@ -0,0 +1,85 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageTypeAnnouncement is a notice from the relay operator, broadcast to
+// every connected client
+const MessageTypeAnnouncement = "announcement"
+
+// Limits on announcements, so the admin API cannot be used to flood clients
+const (
+	// MaxAnnouncementLength is the longest announcement text in characters
+	MaxAnnouncementLength = 280
+	// AnnouncementInterval is the shortest time between two announcements
+	AnnouncementInterval = 30 * time.Second
+)
+
+var (
+	// ErrAnnouncementInvalid is returned for an empty or too long announcement
+	ErrAnnouncementInvalid = errors.New("invalid announcement")
+	// ErrAnnouncementTooSoon is returned when the previous announcement was
+	// made less than AnnouncementInterval ago
+	ErrAnnouncementTooSoon = errors.New("announcement sent too soon after the previous one")
+)
+
+// AnnouncementPayload is the payload of an announcement
+type AnnouncementPayload struct {
+	Text string `json:"text"`
+}
+
+func (p *AnnouncementPayload) validate() error {
+	if strings.TrimSpace(p.Text) == "" {
+		return fmt.Errorf("%w: announcement has no text", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// Announce sends an announcement to every connected client that speaks a
+// protocol version with announcements, and returns how many it was sent to.
+// Announcements bypass the message queue and offline storage: clients that
+// are not connected never see them.
+func (s *Server) Announce(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, fmt.Errorf("%w: text is empty", ErrAnnouncementInvalid)
+	}
+	if n := utf8.RuneCountInString(text); n > MaxAnnouncementLength {
+		return 0, fmt.Errorf("%w: text is %d characters, at most %d are allowed", ErrAnnouncementInvalid, n, MaxAnnouncementLength)
+	}
+
+	s.announceMux.Lock()
+	if wait := AnnouncementInterval - time.Since(s.lastAnnouncement); !s.lastAnnouncement.IsZero() && wait > 0 {
+		s.announceMux.Unlock()
+		return 0, fmt.Errorf("%w: wait %s", ErrAnnouncementTooSoon, wait.Round(time.Second))
+	}
+	s.lastAnnouncement = time.Now()
+	s.announceMux.Unlock()
+
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+
+	sent := 0
+	for _, client := range s.clients {
+		userID := client.user()
+		if userID == "" || !supportsMessage(client.version(), MessageTypeAnnouncement) {
+			continue
+		}
+		msg := newMessage(MessageTypeAnnouncement, "server", userID, AnnouncementPayload{Text: text})
+		select {
+		case client.Send <- msg:
+			sent++
+		default:
+			log.Printf("Failed to send announcement to client %s: send buffer full", client.ID)
+		}
+	}
+
+	log.Printf("Announcement sent to %d clients", sent)
+	return sent, nil
+}
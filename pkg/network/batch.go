@@ -0,0 +1,160 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// messageTypeBatch carries several messages from a client in one frame,
+// which the relay splits and handles one by one
+const messageTypeBatch = "batch"
+
+// capabilityBatch is announced in the hellos of clients and relays that
+// can send and split batches
+const capabilityBatch = "message_batch"
+
+// maxBatchMessages is the most messages a relay accepts in one batch, and
+// the most a client puts in one
+const maxBatchMessages = 50
+
+// defaultBatchMessages is how many messages a client batches by default
+const defaultBatchMessages = 20
+
+// maxBatchBytes bounds the encoded messages of one batch, leaving room for
+// the envelope under the relay's message size limit
+const maxBatchBytes = maxMessageSize - 1024
+
+// BatchPayload holds the messages of a batch in the order they were sent
+type BatchPayload struct {
+	Messages []*Message `json:"messages"`
+}
+
+func (p *BatchPayload) validate() error {
+	if len(p.Messages) == 0 {
+		return fmt.Errorf("%w: batch has no messages", ErrInvalidPayload)
+	}
+	if len(p.Messages) > maxBatchMessages {
+		return fmt.Errorf("%w: batch has %d messages, at most %d are allowed", ErrInvalidPayload, len(p.Messages), maxBatchMessages)
+	}
+	for _, msg := range p.Messages {
+		switch {
+		case msg == nil:
+			return fmt.Errorf("%w: batch has an empty message", ErrInvalidPayload)
+		case !batchable(msg.Type):
+			return fmt.Errorf("%w: %s messages cannot be batched", ErrInvalidPayload, msg.Type)
+		}
+	}
+	return nil
+}
+
+// batchable reports whether messages of a type may be sent in a batch. Only
+// messages routed to another user are: hellos, presence and sync requests
+// change the session and go on their own, and batches do not nest.
+func batchable(msgType string) bool {
+	return routable(msgType)
+}
+
+// batching reports whether messages are batched on the current connection:
+// the client was configured to and the relay announced it can split them
+func (c *Client) batching() bool {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.batchWindow > 0 && c.relayBatches
+}
+
+// writeBatched writes first and the messages queued after it within the
+// batch window to conn, as few batches as the size limits allow. Messages
+// that cannot be batched are written on their own.
+func (c *Client) writeBatched(conn Conn, first *Message) error {
+	for next := first; next != nil; {
+		if !batchable(next.Type) {
+			return c.writeMessage(conn, next)
+		}
+		var batch []*Message
+		batch, next = c.collectBatch(next)
+		if err := c.writeBatch(conn, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectBatch gathers first and the messages queued within the batch
+// window after it, until the batch is full. A message that would take the
+// batch over maxBatchBytes, or cannot be batched, is returned to go next.
+func (c *Client) collectBatch(first *Message) (batch []*Message, next *Message) {
+	batch = []*Message{first}
+	size := encodedSize(first)
+	ctx := c.clientContext()
+
+	timer := time.NewTimer(c.batchWindow)
+	defer timer.Stop()
+	for len(batch) < c.batchSize {
+		select {
+		case msg := <-c.outgoingMessages:
+			if !batchable(msg.Type) {
+				return batch, msg
+			}
+			msgSize := encodedSize(msg)
+			if size+msgSize > maxBatchBytes {
+				return batch, msg
+			}
+			batch = append(batch, msg)
+			size += msgSize
+		case <-timer.C:
+			return batch, nil
+		case <-ctx.Done():
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+// writeBatch writes a batch of messages to conn in one frame, or a lone
+// message on its own
+func (c *Client) writeBatch(conn Conn, batch []*Message) error {
+	if len(batch) == 1 {
+		return c.writeMessage(conn, batch[0])
+	}
+	return c.writeMessage(conn, newMessage(messageTypeBatch, c.userID, "", BatchPayload{Messages: batch}))
+}
+
+// encodedSize returns how many bytes a message takes on the wire
+func encodedSize(msg *Message) int {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// hasCapability reports whether a hello announced a capability
+func hasCapability(capabilities []string, capability string) bool {
+	return slices.Contains(capabilities, capability)
+}
+
+// handleBatch splits a batch and handles its messages in order as if each
+// had been sent on its own, each counting against the rate limit. A batch
+// that is rejected counts as one message, so invalid batches cannot be
+// sent without limit.
+func (c *ServerClient) handleBatch(msg *Message) {
+	var batch BatchPayload
+	if err := msg.DecodePayload(&batch); err != nil {
+		if !c.allow(c.Server.RateLimit()) {
+			c.sendError("RATE_LIMITED", "too many messages, slow down", msg.ID)
+			return
+		}
+		c.sendError("INVALID_BATCH", err.Error(), msg.ID)
+		return
+	}
+
+	for _, inner := range batch.Messages {
+		if !c.allow(c.Server.RateLimit()) {
+			c.sendError("RATE_LIMITED", "too many messages, slow down", inner.ID)
+			continue
+		}
+		c.handleMessage(inner)
+	}
+}
@@ -0,0 +1,220 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// frameRecorder is a transport that records the frames written on the
+// connections it dials: the type of each, and how many messages it carries
+type frameRecorder struct {
+	Transport
+
+	mu     sync.Mutex
+	frames []string
+	sizes  []int
+}
+
+func (r *frameRecorder) Dial(ctx context.Context, serverURL string) (Conn, error) {
+	conn, err := r.Transport.Dial(ctx, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, recorder: r}, nil
+}
+
+// written returns the types of the frames written so far and the number of
+// messages in each
+func (r *frameRecorder) written() ([]string, []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.frames...), append([]int(nil), r.sizes...)
+}
+
+type recordingConn struct {
+	Conn
+	recorder *frameRecorder
+}
+
+func (c *recordingConn) WriteMessage(data []byte) error {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err == nil {
+		size := 1
+		var batch BatchPayload
+		if msg.Type == messageTypeBatch && msg.DecodePayload(&batch) == nil {
+			size = len(batch.Messages)
+		}
+		c.recorder.mu.Lock()
+		c.recorder.frames = append(c.recorder.frames, msg.Type)
+		c.recorder.sizes = append(c.recorder.sizes, size)
+		c.recorder.mu.Unlock()
+	}
+	return c.Conn.WriteMessage(data)
+}
+
+// batchChat returns a chat message from alice to a user
+func batchChat(id, to string) *Message {
+	msg := newMessage(MessageTypeChat, "alice", to, ChatPayload{Content: id})
+	msg.ID = id
+	return msg
+}
+
+func TestQueuedMessagesAreBatched(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	bobGot := make(chan *Message, 128)
+	newTestClient(t, server, "bob", bobGot)
+
+	recorder := &frameRecorder{Transport: &MemoryTransport{Server: server}}
+	alice := NewClient(ClientOptions{
+		ServerURL:   "memory://relay",
+		UserID:      "alice",
+		DeviceID:    "alice-device",
+		Transport:   recorder,
+		BatchWindow: 200 * time.Millisecond,
+		BatchSize:   500, // More than a batch may hold
+	})
+	t.Cleanup(func() { alice.Close() })
+	if err := alice.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, "alice's server hello", func() bool { return alice.ProtocolVersion() != 0 })
+	helloFrames, _ := recorder.written()
+
+	const count = 99
+	for i := 0; i < count; i++ {
+		if err := alice.Send(batchChat(fmt.Sprintf("m%d", i), "bob")); err != nil {
+			t.Fatalf("Send(%d): %v", i, err)
+		}
+	}
+
+	// Every message arrives, in the order it was sent
+	for i := 0; i < count; i++ {
+		if msg := receive(t, bobGot, MessageTypeChat); msg.ID != fmt.Sprintf("m%d", i) {
+			t.Fatalf("message %d to arrive is %s", i, msg.ID)
+		}
+	}
+
+	frames, sizes := recorder.written()
+	frames, sizes = frames[len(helloFrames):], sizes[len(helloFrames):]
+	sent, chatFrames := 0, 0
+	for i, size := range sizes {
+		if frames[i] != MessageTypeChat && frames[i] != messageTypeBatch {
+			continue
+		}
+		if size > maxBatchMessages {
+			t.Errorf("frame %d carries %d messages, want at most %d", i, size, maxBatchMessages)
+		}
+		sent += size
+		chatFrames++
+	}
+	if sent != count {
+		t.Errorf("frames carried %d chat messages, want %d", sent, count)
+	}
+	if chatFrames > 3 {
+		t.Errorf("%d messages took %d frames %v, want them batched into at most 3", count, chatFrames, frames)
+	}
+}
+
+func TestClientDoesNotBatchForRelaysThatCannotSplit(t *testing.T) {
+	conn, far := NewPipe()
+	defer far.Close()
+	recorder := &frameRecorder{Transport: pipeTransport{conn: conn}}
+	alice := NewClient(ClientOptions{
+		ServerURL:   "memory://relay",
+		UserID:      "alice",
+		Transport:   recorder,
+		BatchWindow: time.Second,
+	})
+	defer alice.Close()
+	relay := &rawSession{t: t, conn: far}
+
+	if err := alice.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	relay.expect(MessageTypeClientHello)
+	relay.send(newMessage(MessageTypeServerHello, "relay", "alice", ServerHelloPayload{Version: SupportedVersions.Max}))
+	waitFor(t, "alice's server hello", func() bool { return alice.ProtocolVersion() != 0 })
+
+	for i := 0; i < 3; i++ {
+		if err := alice.Send(batchChat(fmt.Sprintf("m%d", i), "bob")); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		relay.expect(MessageTypeChat)
+	}
+	if frames, _ := recorder.written(); len(frames) != 4 {
+		t.Errorf("frames written = %v, want the hello and three chat messages", frames)
+	}
+}
+
+func TestRelayFansOutBatch(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	alice := connectAs(t, server, "alice", "", nil)
+	bob := connectAs(t, server, "bob", "", nil)
+	carol := connectAs(t, server, "carol", "", nil)
+
+	var messages []*Message
+	for i, to := range []string{"bob", "carol", "bob", "carol"} {
+		messages = append(messages, batchChat(fmt.Sprintf("m%d", i), to))
+	}
+	alice.send(newMessage(messageTypeBatch, "alice", "", BatchPayload{Messages: messages}))
+
+	for _, want := range []struct {
+		session *rawSession
+		ids     []string
+	}{{bob, []string{"m0", "m2"}}, {carol, []string{"m1", "m3"}}} {
+		for _, id := range want.ids {
+			msg := want.session.expect(MessageTypeChat)
+			if msg.ID != id || msg.From != "alice" {
+				t.Errorf("got %s from %s, want %s from alice", msg.ID, msg.From, id)
+			}
+		}
+	}
+	// Each message is acknowledged as if it had been sent alone
+	for _, msg := range messages {
+		if status := alice.expectStatus(msg.ID); status == "" {
+			t.Errorf("no status for %s", msg.ID)
+		}
+	}
+}
+
+func TestBatchMessagesAreRateLimitedOneByOne(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{RateLimit: RateLimit{MessagesPerSecond: 0.01, Burst: 3}})
+	alice := connectAs(t, server, "alice", "", nil)
+	bob := connectAs(t, server, "bob", "", nil)
+
+	// The hello took one token, so two of the batch get through
+	var messages []*Message
+	for i := 0; i < 5; i++ {
+		messages = append(messages, batchChat(fmt.Sprintf("m%d", i), "bob"))
+	}
+	alice.send(newMessage(messageTypeBatch, "alice", "", BatchPayload{Messages: messages}))
+
+	for _, id := range []string{"m0", "m1"} {
+		if msg := bob.expect(MessageTypeChat); msg.ID != id {
+			t.Errorf("bob got %s, want %s", msg.ID, id)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		alice.expectError("RATE_LIMITED")
+	}
+	bob.expectNoChat()
+}
+
+func TestInvalidBatchesCountAgainstRateLimit(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{RateLimit: RateLimit{MessagesPerSecond: 0.01, Burst: 3}})
+	alice := connectAs(t, server, "alice", "", nil)
+
+	empty := newMessage(messageTypeBatch, "alice", "", BatchPayload{})
+	alice.send(empty)
+	alice.expectError("INVALID_BATCH")
+	alice.send(empty)
+	alice.expectError("INVALID_BATCH")
+	alice.send(empty)
+	alice.expectError("RATE_LIMITED")
+}
@@ -2,44 +2,108 @@ package network
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/url"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/opensourceghana/securechat/internal/models"
 )
 
 // Client represents a network client for SecureChat
 type Client struct {
 	// Connection management
-	conn        *websocket.Conn
+	conn        Conn
 	connMutex   sync.RWMutex
 	isConnected bool
 	
 	// Configuration
-	serverURL   string
-	userID      string
+	serverURL         string
+	userID            string
+	transport         Transport
+	connectionTimeout time.Duration
 	
 	// Channels
 	incomingMessages chan *Message
 	outgoingMessages chan *Message
 	connectionEvents chan ConnectionEvent
 	
-	// Context for cancellation
-	ctx    context.Context
-	cancel context.CancelFunc
+	// Context for cancellation of the client's goroutines, renewed by the
+	// first Connect after a Disconnect, and the cancel of the current
+	// connection's reader and writer. Guarded by connMutex.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	connCancel context.CancelFunc
 	
 	// Callbacks
 	messageHandler     MessageHandler
 	connectionHandler  ConnectionHandler
+	presenceHandler    PresenceHandler
+	
+	// Privacy
+	hideLastSeen bool
+	
+	// Install we connect from, so a reconnect replaces our stale session,
+	// and the key our hello is signed with to prove it is ours
+	deviceID   string
+	signingKey ed25519.PrivateKey
+	
+	// Our own status and whether we appear offline, guarded by connMutex
+	status    string
+	invisible bool
+	
+	// Protocol versions offered, and the one the relay chose (0 until its
+	// hello arrives) with its delivery mode, guarded by connMutex
+	versions        VersionRange
+	protocolVersion int
+	deliveryMode    DeliveryMode
+	
+	// Fingerprint the relay's identity must match, if pinned, and the nonce
+	// of the current hello, guarded by connMutex
+	relayPin   string
+	helloNonce string
+	
+	// Users whose presence we subscribe to on every connection
+	presenceSubs map[string]bool
+	subsMutex    sync.Mutex
+	
+	// Selective sync of messages queued while offline. syncDelivered counts
+	// the queued messages received this connection, guarded by connMutex.
+	syncPriority  func() []string
+	syncHandler   SyncProgressHandler
+	syncDelivered int
+	
+	// Messages queued within batchWindow of each other are sent together,
+	// up to batchSize at a time, if the relay can split batches
+	// (relayBatches, guarded by connMutex)
+	batchWindow  time.Duration
+	batchSize    int
+	relayBatches bool
+	
+	// Round trips of pings on the current connection
+	rtt rttTracker
+	
+	// Connection state reported by State and SubscribeState
+	state stateTracker
 	
 	// Reconnection
 	reconnectAttempts int
 	maxReconnectAttempts int
+	unlimitedReconnects bool
 	reconnectDelay    time.Duration
+	
+	// Set by Disconnect, so neither the connection it closes nor a
+	// reconnect underway reconnects, until the next Connect. Guarded by
+	// connMutex, like reconnectAttempts.
+	closing bool
+	
+	// Set when the relay replaced our session, so the reconnect waits
+	// first. Guarded by connMutex.
+	sessionReplaced bool
 }
 
 // Message represents a network message
@@ -57,6 +121,7 @@ type Message struct {
 type ConnectionEvent struct {
 	Type      ConnectionEventType
 	Error     error
+	Quality   ConnectionQuality // Set for ConnectionEventQuality
 	Timestamp time.Time
 }
 
@@ -68,8 +133,18 @@ const (
 	ConnectionEventDisconnected
 	ConnectionEventReconnecting
 	ConnectionEventError
+	// ConnectionEventHello follows the relay's hello, once the protocol
+	// version and delivery mode are known
+	ConnectionEventHello
+	// ConnectionEventQuality follows a change of the connection quality
+	// level, or of whether it is degraded
+	ConnectionEventQuality
 )
 
+// pingInterval is how often the client pings the relay, which both keeps
+// the connection alive and measures its round-trip time
+const pingInterval = 15 * time.Second
+
 // MessageHandler is called when a message is received
 type MessageHandler func(*Message) error
 
@@ -80,10 +155,26 @@ type ConnectionHandler func(ConnectionEvent)
 type ClientOptions struct {
 	ServerURL            string
 	UserID               string
+	DeviceID             string // Install connecting, so a reconnect replaces the stale session
+	SigningKey           []byte // Ed25519 identity key the hello is signed with; stale sessions are only replaced for signed hellos
+	Transport            Transport // Defaults to WebSocketTransport
+	Proxy                ProxyFunc // Proxy of the default transport, defaults to ProxyFromEnvironment
+	TLSServerName        string // Name the relay's certificate is checked against by the default transport, defaults to the dialed host
+	ConnectionTimeout    time.Duration
 	MaxReconnectAttempts int
+	UnlimitedReconnects  bool // Keep trying to reconnect, ignoring MaxReconnectAttempts
 	ReconnectDelay       time.Duration
 	MessageHandler       MessageHandler
 	ConnectionHandler    ConnectionHandler
+	PresenceHandler      PresenceHandler
+	HideLastSeen         bool // Ask the relay not to share our last-seen time
+	Invisible            bool // Appear offline to everyone while connected
+	Versions             VersionRange // Protocol versions to offer, defaults to SupportedVersions
+	RelayFingerprint     string // Pinned relay identity; relays that do not prove it are rejected
+	SyncPriority         func() []string // Users whose queued messages are fetched first, such as open chats
+	SyncProgressHandler  SyncProgressHandler
+	BatchWindow          time.Duration // Send messages queued this close together in one frame; 0 sends each alone
+	BatchSize            int // Most messages per batch, defaults to 20 and is at most 50
 }
 
 // NewClient creates a new network client
@@ -96,10 +187,26 @@ func NewClient(opts ClientOptions) *Client {
 	if opts.ReconnectDelay == 0 {
 		opts.ReconnectDelay = 5 * time.Second
 	}
+	if opts.ConnectionTimeout == 0 {
+		opts.ConnectionTimeout = 10 * time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchMessages
+	}
+	opts.BatchSize = min(opts.BatchSize, maxBatchMessages)
+	if opts.Transport == nil {
+		opts.Transport = &WebSocketTransport{
+			HandshakeTimeout: opts.ConnectionTimeout,
+			Proxy:            opts.Proxy,
+			TLSServerName:    opts.TLSServerName,
+		}
+	}
 	
-	return &Client{
+	c := &Client{
 		serverURL:            opts.ServerURL,
 		userID:               opts.UserID,
+		transport:            opts.Transport,
+		connectionTimeout:    opts.ConnectionTimeout,
 		incomingMessages:     make(chan *Message, 100),
 		outgoingMessages:     make(chan *Message, 100),
 		connectionEvents:     make(chan ConnectionEvent, 10),
@@ -107,55 +214,125 @@ func NewClient(opts ClientOptions) *Client {
 		cancel:               cancel,
 		messageHandler:       opts.MessageHandler,
 		connectionHandler:    opts.ConnectionHandler,
+		presenceHandler:      opts.PresenceHandler,
+		hideLastSeen:         opts.HideLastSeen,
+		deviceID:             opts.DeviceID,
+		signingKey:           signingKey(opts.SigningKey),
+		status:               string(models.UserStatusOnline),
+		invisible:            opts.Invisible,
+		versions:             opts.Versions.orDefault(),
+		relayPin:             strings.ToLower(opts.RelayFingerprint),
+		presenceSubs:         make(map[string]bool),
+		syncPriority:         opts.SyncPriority,
+		syncHandler:          opts.SyncProgressHandler,
+		batchWindow:          opts.BatchWindow,
+		batchSize:            opts.BatchSize,
 		maxReconnectAttempts: opts.MaxReconnectAttempts,
+		unlimitedReconnects:  opts.UnlimitedReconnects,
 		reconnectDelay:       opts.ReconnectDelay,
 	}
+	c.state.state = ConnectionState{Status: StatusDisconnected, Server: opts.ServerURL}
+	go c.handleEvents(ctx)
+	return c
 }
 
 // Connect establishes a connection to the server
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext establishes a connection to the server, giving up when ctx
+// is done or the configured connection timeout elapses. After a Disconnect,
+// it lets the client reconnect on its own again.
+func (c *Client) ConnectContext(ctx context.Context) error {
 	c.connMutex.Lock()
-	defer c.connMutex.Unlock()
+	c.closing = false
+	if c.ctx.Err() != nil {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		go c.handleEvents(c.ctx)
+	}
+	c.connMutex.Unlock()
 	
+	return c.connect(ctx)
+}
+
+// connect establishes a connection to the server, unless the client was
+// disconnected on purpose meanwhile. The dial runs without connMutex held,
+// so Disconnect and Close never wait for it; they cancel it instead.
+func (c *Client) connect(ctx context.Context) error {
+	c.connMutex.Lock()
+	
+	if c.closing {
+		c.connMutex.Unlock()
+		return ErrClientClosed
+	}
 	if c.isConnected {
-		return fmt.Errorf("already connected")
+		c.connMutex.Unlock()
+		return ErrAlreadyConnected
 	}
+	clientCtx := c.ctx
+	c.connMutex.Unlock()
 	
-	// Parse server URL
-	u, err := url.Parse(c.serverURL)
-	if err != nil {
-		return fmt.Errorf("invalid server URL: %w", err)
-	}
+	dialCtx, cancel := context.WithTimeout(ctx, c.connectionTimeout)
+	defer cancel()
 	
-	// Convert HTTP(S) to WS(S)
-	switch u.Scheme {
-	case "http":
-		u.Scheme = "ws"
-	case "https":
-		u.Scheme = "wss"
-	case "ws", "wss":
-		// Already correct
-	default:
-		return fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
-	}
+	// Abort the dial if the client is disconnected or closed meanwhile
+	stop := context.AfterFunc(clientCtx, cancel)
+	defer stop()
 	
-	// Establish WebSocket connection
-	dialer := websocket.DefaultDialer
-	dialer.HandshakeTimeout = 10 * time.Second
+	// Establish connection
+	conn, err := c.transport.Dial(dialCtx, c.serverURL)
 	
-	conn, _, err := dialer.Dial(u.String(), nil)
-	if err != nil {
-		return fmt.Errorf("failed to connect to server: %w", err)
+	c.connMutex.Lock()
+	// A Disconnect while dialing wins, even over a dial that succeeded,
+	// and so does a connection another connect set up first
+	disconnected := c.closing || c.ctx != clientCtx
+	switch {
+	case disconnected || c.isConnected:
+		c.connMutex.Unlock()
+		if err == nil {
+			conn.Close()
+		}
+		if disconnected {
+			return ErrClientClosed
+		}
+		return ErrAlreadyConnected
+	case err != nil:
+		c.connMutex.Unlock()
+		connectErr := &ConnectError{ServerURL: c.serverURL, Err: err}
+		c.state.update(func(state *ConnectionState) {
+			state.LastError = connectErr
+		})
+		return connectErr
 	}
 	
+	connCtx, connCancel := context.WithCancel(clientCtx)
 	c.conn = conn
+	c.connCancel = connCancel
 	c.isConnected = true
-	c.reconnectAttempts = 0
+	c.protocolVersion = 0
+	// Under connMutex, so a Disconnect that follows is not overwritten
+	c.state.update(func(state *ConnectionState) {
+		state.Status = StatusConnected
+		state.ReconnectAttempt = 0
+		state.ConnectedSince = time.Now()
+	})
+	c.connMutex.Unlock()
+	
+	c.rtt.reset()
+	if notifier, ok := conn.(PongNotifier); ok {
+		notifier.SetPongHandler(c.handlePong)
+	}
+	
+	// Send client hello before the writer starts so it is always the first
+	// message and never written concurrently
+	if err := c.sendClientHello(conn); err != nil {
+		log.Printf("Failed to send client hello: %v", err)
+	}
 	
 	// Start message handling goroutines
-	go c.readMessages()
-	go c.writeMessages()
-	go c.handleEvents()
+	go c.readMessages(connCtx, conn)
+	go c.writeMessages(connCtx, conn)
 	
 	// Send connection event
 	c.sendConnectionEvent(ConnectionEvent{
@@ -163,30 +340,37 @@ func (c *Client) Connect() error {
 		Timestamp: time.Now(),
 	})
 	
-	// Send client hello
-	if err := c.sendClientHello(); err != nil {
-		log.Printf("Failed to send client hello: %v", err)
-	}
-	
 	return nil
 }
 
-// Disconnect closes the connection
+// Disconnect closes the connection, or stops reconnecting. The client does
+// not reconnect on its own until Connect is called again.
 func (c *Client) Disconnect() error {
 	c.connMutex.Lock()
 	defer c.connMutex.Unlock()
 	
+	c.closing = true
+	c.cancel() // Cancel context to stop goroutines
+	
 	if !c.isConnected {
+		c.state.update(func(state *ConnectionState) {
+			if state.Status == StatusReconnecting {
+				state.Status = StatusDisconnected
+			}
+		})
 		return nil
 	}
 	
 	c.isConnected = false
-	c.cancel() // Cancel context to stop goroutines
 	
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
+	c.state.update(func(state *ConnectionState) {
+		state.Status = StatusDisconnected
+		state.ConnectedSince = time.Time{}
+	})
 	
 	c.sendConnectionEvent(ConnectionEvent{
 		Type:      ConnectionEventDisconnected,
@@ -199,30 +383,50 @@ func (c *Client) Disconnect() error {
 // SendMessage sends a message to another user
 func (c *Client) SendMessage(to string, content string, msgType string) error {
 	if !c.IsConnected() {
-		return fmt.Errorf("not connected")
+		return ErrNotConnected
+	}
+	
+	return c.Send(newMessage(msgType, c.userID, to, ChatPayload{Content: content}))
+}
+
+// Send queues a fully formed message for delivery. The caller may set the
+// message ID so that relay acknowledgements can be matched to local state.
+func (c *Client) Send(msg *Message) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	if !supportsMessage(c.ProtocolVersion(), msg.Type) {
+		return fmt.Errorf("%w: %s", ErrNotSupported, msg.Type)
+	}
+	if c.relayPin != "" && c.ProtocolVersion() == 0 {
+		// Nothing goes to a pinned relay before it has proven its identity
+		return ErrNotConnected
 	}
 	
-	msg := &Message{
-		ID:        generateMessageID(),
-		Type:      msgType,
-		From:      c.userID,
-		To:        to,
-		Timestamp: time.Now().Unix(),
-		Payload: map[string]interface{}{
-			"content": content,
-		},
+	if msg.ID == "" {
+		msg.ID = models.NewMessageID()
+	}
+	if msg.From == "" {
+		msg.From = c.userID
 	}
 	
 	select {
 	case c.outgoingMessages <- msg:
 		return nil
-	case <-c.ctx.Done():
-		return fmt.Errorf("client is shutting down")
+	case <-c.clientContext().Done():
+		return ErrClientClosed
 	default:
-		return fmt.Errorf("outgoing message queue is full")
+		return ErrOutboxFull
 	}
 }
 
+// clientContext returns the context of the current connection's goroutines
+func (c *Client) clientContext() context.Context {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.ctx
+}
+
 // IsConnected returns true if the client is connected
 func (c *Client) IsConnected() bool {
 	c.connMutex.RLock()
@@ -230,13 +434,45 @@ func (c *Client) IsConnected() bool {
 	return c.isConnected
 }
 
-// Close shuts down the client
+// ProtocolVersion returns the protocol version negotiated with the relay,
+// or 0 if the relay has not answered the hello yet
+func (c *Client) ProtocolVersion() int {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.protocolVersion
+}
+
+// DeliveryMode returns whether the relay stores messages for users who are
+// not connected, or an empty mode if the relay has not answered the hello yet
+func (c *Client) DeliveryMode() DeliveryMode {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.deliveryMode
+}
+
+// Close shuts down the client and closes the channels of state subscribers
 func (c *Client) Close() error {
-	return c.Disconnect()
+	err := c.Disconnect()
+	c.state.close()
+	return err
 }
 
-// readMessages reads messages from the WebSocket connection
-func (c *Client) readMessages() {
+// State returns the current connection state
+func (c *Client) State() ConnectionState {
+	return c.state.get()
+}
+
+// SubscribeState returns a channel that receives the current connection
+// state and then every change to it, until the client is closed. Only the
+// latest state is held for a reader that falls behind. The connection
+// handler still receives every event.
+func (c *Client) SubscribeState() <-chan ConnectionState {
+	return c.state.subscribe()
+}
+
+// readMessages reads messages from conn until ctx is done or the
+// connection is lost
+func (c *Client) readMessages(ctx context.Context, conn Conn) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Panic in readMessages: %v", r)
@@ -245,31 +481,21 @@ func (c *Client) readMessages() {
 	
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
 		default:
 		}
 		
-		c.connMutex.RLock()
-		conn := c.conn
-		connected := c.isConnected
-		c.connMutex.RUnlock()
-		
-		if !connected || conn == nil {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		
 		// Set read deadline
 		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		
 		// Read message
-		_, data, err := conn.ReadMessage()
+		data, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+			if isUnexpectedClose(err) {
+				log.Printf("Connection error: %v", err)
 			}
-			c.handleConnectionError(err)
+			c.handleConnectionError(conn, err)
 			return
 		}
 		
@@ -280,6 +506,26 @@ func (c *Client) readMessages() {
 			continue
 		}
 		
+		switch msg.Type {
+		case MessageTypeServerHello:
+			c.handleServerHello(&msg)
+			continue
+		case MessageTypeError:
+			if c.handleVersionMismatch(&msg) {
+				return
+			}
+			if c.handleSessionReplaced(&msg) {
+				continue
+			}
+		case messageTypePresenceResponse, messageTypePresenceUpdate:
+			// Presence answers go to their own handler
+			c.handlePresenceMessage(&msg)
+			continue
+		case messageTypeSyncStatus:
+			c.handleSyncStatus(&msg)
+			continue
+		}
+		
 		// Handle message
 		if c.messageHandler != nil {
 			if err := c.messageHandler(&msg); err != nil {
@@ -289,79 +535,118 @@ func (c *Client) readMessages() {
 	}
 }
 
-// writeMessages writes messages to the WebSocket connection
-func (c *Client) writeMessages() {
+// writeMessages writes messages to conn until ctx is done or the
+// connection is lost
+func (c *Client) writeMessages(ctx context.Context, conn Conn) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Panic in writeMessages: %v", r)
 		}
 	}()
 	
-	ticker := time.NewTicker(54 * time.Second) // Ping every 54 seconds
+	ticker := time.NewTicker(pingInterval)
 	defer ticker.Stop()
 	
+	// Measure the round trip right away rather than a ping interval later
+	if err := c.writePing(conn); err != nil {
+		log.Printf("Failed to write ping: %v", err)
+		c.handleConnectionError(conn, err)
+		return
+	}
+	
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
 			
 		case msg := <-c.outgoingMessages:
-			if err := c.writeMessage(msg); err != nil {
+			write := c.writeMessage
+			if c.batching() {
+				write = c.writeBatched
+			}
+			if err := write(conn, msg); err != nil {
 				log.Printf("Failed to write message: %v", err)
-				c.handleConnectionError(err)
+				c.handleConnectionError(conn, err)
 				return
 			}
 			
 		case <-ticker.C:
-			if err := c.writePing(); err != nil {
+			if err := c.writePing(conn); err != nil {
 				log.Printf("Failed to write ping: %v", err)
-				c.handleConnectionError(err)
+				c.handleConnectionError(conn, err)
 				return
 			}
 		}
 	}
 }
 
-// writeMessage writes a single message to the connection
-func (c *Client) writeMessage(msg *Message) error {
-	c.connMutex.RLock()
-	conn := c.conn
-	connected := c.isConnected
-	c.connMutex.RUnlock()
-	
-	if !connected || conn == nil {
-		return fmt.Errorf("not connected")
-	}
-	
+// writeMessage writes a single message to conn. Only the goroutine that
+// owns conn writes to it, never to a connection that replaced it.
+func (c *Client) writeMessage(conn Conn, msg *Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 	
 	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return conn.WriteMessage(websocket.TextMessage, data)
+	return conn.WriteMessage(data)
 }
 
-// writePing writes a ping message
-func (c *Client) writePing() error {
-	c.connMutex.RLock()
-	conn := c.conn
-	connected := c.isConnected
-	c.connMutex.RUnlock()
-	
-	if !connected || conn == nil {
-		return fmt.Errorf("not connected")
+// writePing writes a ping message to conn
+func (c *Client) writePing(conn Conn) error {
+	// Recorded first, as the pong may be handled before WritePing returns
+	c.rtt.pingSent(time.Now())
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.WritePing(); err != nil {
+		return err
 	}
 	
-	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return conn.WriteMessage(websocket.PingMessage, nil)
+	// A ping still unanswered from last time may have made the connection
+	// slower
+	c.reportQuality()
+	return nil
+}
+
+// handlePong measures the round trip of the ping a pong answers
+func (c *Client) handlePong() {
+	c.rtt.pongReceived(time.Now())
+	c.reportQuality()
+}
+
+// reportQuality sends a quality event if the connection quality changed
+func (c *Client) reportQuality() {
+	quality, changed := c.rtt.changed(time.Now())
+	if !changed {
+		return
+	}
+	c.sendConnectionEvent(ConnectionEvent{
+		Type:      ConnectionEventQuality,
+		Quality:   quality,
+		Timestamp: time.Now(),
+	})
+}
+
+// RTT returns the smoothed round-trip time of pings on the current
+// connection, or 0 if none was answered yet
+func (c *Client) RTT() time.Duration {
+	return c.rtt.quality(time.Now()).RTT
+}
+
+// Quality returns the quality of the current connection, graded by the
+// round-trip time of its pings
+func (c *Client) Quality() ConnectionQuality {
+	if !c.IsConnected() {
+		return ConnectionQuality{Level: QualityUnknown}
+	}
+	return c.rtt.quality(time.Now())
 }
 
-// handleEvents processes connection events
-func (c *Client) handleEvents() {
+// handleEvents processes connection events until ctx is done
+func (c *Client) handleEvents(ctx context.Context) {
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
+			c.drainEvents()
 			return
 		case event := <-c.connectionEvents:
 			if c.connectionHandler != nil {
@@ -371,15 +656,45 @@ func (c *Client) handleEvents() {
 	}
 }
 
-// handleConnectionError handles connection errors and attempts reconnection
-func (c *Client) handleConnectionError(err error) {
+// drainEvents delivers events sent while the client was shutting down, such
+// as the error explaining why
+func (c *Client) drainEvents() {
+	for {
+		select {
+		case event := <-c.connectionEvents:
+			if c.connectionHandler != nil {
+				c.connectionHandler(event)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// handleConnectionError handles the loss of conn and attempts reconnection,
+// unless the connection was lost because Disconnect closed it. The reader
+// and writer both report a lost connection; only the first report of the
+// current connection counts, so a late one cannot drop a newer connection.
+func (c *Client) handleConnectionError(conn Conn, err error) {
 	c.connMutex.Lock()
-	c.isConnected = false
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
+	if c.conn != conn {
+		c.connMutex.Unlock()
+		return
 	}
+	closing, ctx := c.closing, c.ctx
+	c.isConnected = false
+	c.conn.Close()
+	c.conn = nil
+	c.connCancel()
 	c.connMutex.Unlock()
+	if closing {
+		return
+	}
+	c.state.update(func(state *ConnectionState) {
+		state.Status = StatusDisconnected
+		state.LastError = err
+		state.ConnectedSince = time.Time{}
+	})
 	
 	c.sendConnectionEvent(ConnectionEvent{
 		Type:      ConnectionEventError,
@@ -388,49 +703,97 @@ func (c *Client) handleConnectionError(err error) {
 	})
 	
 	// Attempt reconnection
-	go c.attemptReconnection()
+	go c.attemptReconnection(ctx)
 }
 
-// attemptReconnection attempts to reconnect to the server
-func (c *Client) attemptReconnection() {
-	for c.reconnectAttempts < c.maxReconnectAttempts {
+// attemptReconnection attempts to reconnect to the server, until it
+// succeeds, the client is disconnected on purpose or, unless reconnects are
+// unlimited, it runs out of attempts
+func (c *Client) attemptReconnection(ctx context.Context) {
+	c.connMutex.Lock()
+	replaced := c.sessionReplaced
+	c.sessionReplaced = false
+	c.connMutex.Unlock()
+	if replaced {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
+			return
+		case <-time.After(c.reconnectDelay):
+		}
+	}
+	
+	for {
+		attempt, ok := c.nextReconnectAttempt()
+		if !ok {
 			return
-		default:
 		}
 		
-		c.reconnectAttempts++
-		
-		c.sendConnectionEvent(ConnectionEvent{
-			Type:      ConnectionEventReconnecting,
-			Timestamp: time.Now(),
-		})
-		
-		log.Printf("Attempting reconnection %d/%d", c.reconnectAttempts, c.maxReconnectAttempts)
-		
-		if err := c.Connect(); err != nil {
-			log.Printf("Reconnection attempt %d failed: %v", c.reconnectAttempts, err)
-			
-			// Wait before next attempt with exponential backoff
-			delay := c.reconnectDelay * time.Duration(c.reconnectAttempts)
+		// Wait longer before each attempt after the first, whether the last
+		// one failed to connect or lost the connection before the hello
+		if attempt > 1 {
+			delay := c.reconnectDelay * time.Duration(attempt-1)
 			if delay > 60*time.Second {
 				delay = 60 * time.Second
 			}
 			
 			select {
-			case <-c.ctx.Done():
+			case <-ctx.Done():
 				return
 			case <-time.After(delay):
-				continue
 			}
+		}
+		
+		c.sendConnectionEvent(ConnectionEvent{
+			Type:      ConnectionEventReconnecting,
+			Timestamp: time.Now(),
+		})
+		
+		if c.unlimitedReconnects {
+			log.Printf("Attempting reconnection %d", attempt)
 		} else {
+			log.Printf("Attempting reconnection %d/%d", attempt, c.maxReconnectAttempts)
+		}
+		
+		err := c.connect(context.Background())
+		if err == nil {
 			log.Printf("Reconnection successful")
 			return
 		}
+		if errors.Is(err, ErrClientClosed) {
+			return
+		}
+		log.Printf("Reconnection attempt %d failed: %v", attempt, err)
+	}
+}
+
+// nextReconnectAttempt counts another reconnection attempt and returns its
+// number, or false if the client was disconnected on purpose or has run out
+// of attempts. The state is updated under connMutex, so a Disconnect that
+// follows always sees the client reconnecting.
+func (c *Client) nextReconnectAttempt() (int, bool) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	
+	if c.closing {
+		return 0, false
+	}
+	if !c.unlimitedReconnects && c.reconnectAttempts >= c.maxReconnectAttempts {
+		log.Printf("Max reconnection attempts reached, giving up")
+		attempts := c.reconnectAttempts
+		c.state.update(func(state *ConnectionState) {
+			state.Status = StatusDisconnected
+			state.ReconnectAttempt = attempts
+		})
+		return 0, false
 	}
 	
-	log.Printf("Max reconnection attempts reached, giving up")
+	c.reconnectAttempts++
+	attempt := c.reconnectAttempts
+	c.state.update(func(state *ConnectionState) {
+		state.Status = StatusReconnecting
+		state.ReconnectAttempt = attempt
+	})
+	return attempt, true
 }
 
 // sendConnectionEvent sends a connection event
@@ -442,23 +805,165 @@ func (c *Client) sendConnectionEvent(event ConnectionEvent) {
 	}
 }
 
-// sendClientHello sends the initial client hello message
-func (c *Client) sendClientHello() error {
-	msg := &Message{
-		ID:        generateMessageID(),
-		Type:      "client_hello",
-		From:      c.userID,
-		Timestamp: time.Now().Unix(),
-		Payload: map[string]interface{}{
-			"version":      "1.0",
-			"capabilities": []string{"e2e_encryption", "file_transfer"},
-		},
+// sendClientHello sends the initial client hello message on conn
+func (c *Client) sendClientHello(conn Conn) error {
+	nonce := newHelloNonce()
+	c.connMutex.Lock()
+	c.helloNonce = nonce
+	c.connMutex.Unlock()
+	
+	capabilities := []string{"e2e_encryption", "file_transfer"}
+	if c.batchWindow > 0 {
+		capabilities = append(capabilities, capabilityBatch)
+	}
+	hello := ClientHelloPayload{
+		MinVersion:    c.versions.Min,
+		MaxVersion:    c.versions.Max,
+		Capabilities:  capabilities,
+		HideLastSeen:  c.hideLastSeen,
+		Invisible:     c.Invisible(),
+		Nonce:         nonce,
+		SelectiveSync: c.versions.Contains(ProtocolVersion3),
+		DeviceID:      c.deviceID,
+	}
+	timestamp := time.Now().Unix()
+	if c.signingKey != nil {
+		signClientHello(c.signingKey, &hello, c.userID, timestamp)
 	}
+	msg := newMessage(MessageTypeClientHello, c.userID, "", hello)
+	msg.Timestamp = timestamp
 	
-	return c.writeMessage(msg)
+	return c.writeMessage(conn, msg)
 }
 
-// generateMessageID generates a unique message ID
-func generateMessageID() string {
-	return fmt.Sprintf("msg_%d_%d", time.Now().UnixNano(), time.Now().Unix()%1000)
+// handleServerHello records the protocol version chosen by the relay
+func (c *Client) handleServerHello(msg *Message) {
+	var hello ServerHelloPayload
+	if err := msg.DecodePayload(&hello); err != nil {
+		// Relays that predate negotiation send a version string and speak version 1
+		hello.Version = ProtocolVersion1
+	}
+	
+	if !c.versions.Contains(hello.Version) {
+		c.rejectRelay(fmt.Errorf("%w: relay chose v%d, client speaks %s", ErrVersionMismatch, hello.Version, c.versions))
+		return
+	}
+	if err := c.checkRelayIdentity(&hello); err != nil {
+		c.rejectRelay(err)
+		return
+	}
+	
+	if hello.DeliveryMode == "" {
+		hello.DeliveryMode = DeliveryStoreAndForward
+	}
+	
+	c.connMutex.Lock()
+	c.protocolVersion = hello.Version
+	// Only a relay that answered the hello ends the reconnection attempts,
+	// so one that drops every connection before answering is backed off from
+	c.reconnectAttempts = 0
+	c.deliveryMode = hello.DeliveryMode
+	c.relayBatches = hasCapability(hello.Capabilities, capabilityBatch)
+	c.syncDelivered = 0
+	c.connMutex.Unlock()
+	
+	log.Printf("Connected to %s using protocol v%d", c.serverURL, hello.Version)
+	c.resubscribePresence()
+	c.restoreStatus()
+	c.sendConnectionEvent(ConnectionEvent{
+		Type:      ConnectionEventHello,
+		Timestamp: time.Now(),
+	})
+}
+
+// handleVersionMismatch disconnects if msg is the relay rejecting our
+// protocol versions, reporting whether it was
+func (c *Client) handleVersionMismatch(msg *Message) bool {
+	var relayErr ErrorPayload
+	if err := msg.DecodePayload(&relayErr); err != nil || relayErr.Code != errorCodeVersionMismatch {
+		return false
+	}
+	
+	c.rejectRelay(fmt.Errorf("%w: %s", ErrVersionMismatch, relayErr.Message))
+	return true
+}
+
+// handleSessionReplaced reports whether msg is the relay closing this
+// session because a newer one from the same install connected, recording
+// it as the last error. The relay closes the connection next, and the
+// client reconnects as after any drop. Only a session signed with our key
+// can replace ours, so the other one is a copy of this install; waiting
+// the reconnect delay first keeps the two from trading the session as fast
+// as they can connect.
+func (c *Client) handleSessionReplaced(msg *Message) bool {
+	var relayErr ErrorPayload
+	if err := msg.DecodePayload(&relayErr); err != nil || relayErr.Code != errorCodeSessionReplaced {
+		return false
+	}
+	
+	err := fmt.Errorf("%w: %s", ErrSessionReplaced, relayErr.Message)
+	log.Printf("Session on %s replaced: %v", c.serverURL, err)
+	c.connMutex.Lock()
+	c.sessionReplaced = true
+	c.connMutex.Unlock()
+	c.state.update(func(state *ConnectionState) {
+		state.LastError = err
+	})
+	c.sendConnectionEvent(ConnectionEvent{
+		Type:      ConnectionEventError,
+		Error:     err,
+		Timestamp: time.Now(),
+	})
+	return true
+}
+
+// signingKey returns a copy of key as an Ed25519 private key, so the
+// caller may wipe its own, or nil if it is not one
+func signingKey(key []byte) ed25519.PrivateKey {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil
+	}
+	return ed25519.PrivateKey(append([]byte(nil), key...))
+}
+
+// checkRelayIdentity verifies the signature of a signed server hello, and
+// that the relay proves the pinned identity if there is one. Unsigned
+// hellos are accepted from relays that are not pinned.
+func (c *Client) checkRelayIdentity(hello *ServerHelloPayload) error {
+	c.connMutex.RLock()
+	pin, nonce := c.relayPin, c.helloNonce
+	c.connMutex.RUnlock()
+	
+	if hello.IdentityKey == "" && hello.Signature == "" {
+		if pin != "" {
+			return fmt.Errorf("%w: relay has no identity", ErrRelayIdentityMismatch)
+		}
+		return nil
+	}
+	
+	fingerprint, err := verifyServerHello(hello, nonce, c.userID)
+	if err != nil {
+		return err
+	}
+	if pin != "" && fingerprint != pin {
+		return fmt.Errorf("%w: relay is %s, expected %s", ErrRelayIdentityMismatch, fingerprint, pin)
+	}
+	return nil
+}
+
+// rejectRelay reports a relay the client cannot talk to, such as one with
+// no protocol version in common or the wrong identity, and disconnects for
+// good, since reconnecting would be rejected again
+func (c *Client) rejectRelay(err error) {
+	log.Printf("Disconnecting from %s: %v", c.serverURL, err)
+	c.state.update(func(state *ConnectionState) {
+		state.LastError = err
+	})
+	
+	c.sendConnectionEvent(ConnectionEvent{
+		Type:      ConnectionEventError,
+		Error:     err,
+		Timestamp: time.Now(),
+	})
+	c.Disconnect()
 }
@@ -0,0 +1,402 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestClient connects a client for userID to server over an in-memory
+// transport, passing the messages it receives to received
+func newTestClient(t *testing.T, server *Server, userID string, received chan<- *Message) *Client {
+	t.Helper()
+
+	client := NewClient(ClientOptions{
+		ServerURL:      "memory://relay",
+		UserID:         userID,
+		DeviceID:       userID + "-device",
+		Transport:      &MemoryTransport{Server: server},
+		ReconnectDelay: 10 * time.Millisecond,
+		MessageHandler: func(msg *Message) error {
+			if received != nil {
+				received <- msg
+			}
+			return nil
+		},
+	})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, userID+"'s server hello", func() bool { return client.ProtocolVersion() != 0 })
+	return client
+}
+
+// receive waits for a message of msgType on received
+func receive(t *testing.T, received <-chan *Message, msgType string) *Message {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-received:
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("no %s message arrived", msgType)
+		}
+	}
+}
+
+func TestClientsTalkOverMemoryTransport(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	aliceGot := make(chan *Message, 16)
+	bobGot := make(chan *Message, 16)
+	alice := newTestClient(t, server, "alice", aliceGot)
+	newTestClient(t, server, "bob", bobGot)
+
+	if err := alice.SendMessage("bob", "hi bob", MessageTypeChat); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	msg := receive(t, bobGot, MessageTypeChat)
+	var chat ChatPayload
+	if err := msg.DecodePayload(&chat); err != nil {
+		t.Fatalf("DecodePayload: %v", err)
+	}
+	if msg.From != "alice" || chat.Content != "hi bob" {
+		t.Errorf("bob got %q from %s, want %q from alice", chat.Content, msg.From, "hi bob")
+	}
+
+	var ack AckPayload
+	if err := receive(t, aliceGot, MessageTypeAck).DecodePayload(&ack); err != nil {
+		t.Fatalf("DecodePayload: %v", err)
+	}
+	if ack.MessageID != msg.ID || ack.Status != "delivered" {
+		t.Errorf("alice got ack %+v, want delivered for %s", ack, msg.ID)
+	}
+}
+
+func TestConnectTimesOutOnUnresponsiveRelay(t *testing.T) {
+	// Accepts connections but never answers the WebSocket handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		var held []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			held = append(held, conn)
+		}
+		for _, conn := range held {
+			conn.Close()
+		}
+	}()
+
+	const timeout = 100 * time.Millisecond
+	client := NewClient(ClientOptions{
+		ServerURL:         "ws://" + listener.Addr().String() + "/ws",
+		UserID:            "alice",
+		ConnectionTimeout: timeout,
+	})
+	defer client.Close()
+
+	start := time.Now()
+	if err := client.Connect(); !errors.Is(err, ErrHandshakeTimeout) {
+		t.Fatalf("Connect = %v, want ErrHandshakeTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+time.Second {
+		t.Errorf("Connect gave up after %v, want about %v", elapsed, timeout)
+	}
+}
+
+func TestConnectContextStopsWithContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	client := NewClient(ClientOptions{
+		ServerURL:         "ws://" + listener.Addr().String() + "/ws",
+		UserID:            "alice",
+		ConnectionTimeout: time.Minute,
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.ConnectContext(ctx); err == nil {
+		t.Fatal("ConnectContext succeeded against a relay that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("ConnectContext took %v after its context ended", elapsed)
+	}
+}
+
+// pipeTransport hands out one end of a pipe whose other end the test holds
+type pipeTransport struct {
+	conn Conn
+}
+
+func (p pipeTransport) Dial(ctx context.Context, serverURL string) (Conn, error) {
+	return p.conn, nil
+}
+
+func TestClientErrors(t *testing.T) {
+	t.Run("not connected", func(t *testing.T) {
+		client := NewClient(ClientOptions{ServerURL: "memory://relay", UserID: "alice"})
+		defer client.Close()
+		if err := client.SendMessage("bob", "hi", MessageTypeChat); !errors.Is(err, ErrNotConnected) {
+			t.Errorf("SendMessage = %v, want ErrNotConnected", err)
+		}
+	})
+
+	t.Run("already connected", func(t *testing.T) {
+		client := newTestClient(t, newTestRelay(t, ServerOptions{}), "alice", nil)
+		if err := client.Connect(); !errors.Is(err, ErrAlreadyConnected) {
+			t.Errorf("Connect = %v, want ErrAlreadyConnected", err)
+		}
+	})
+
+	t.Run("invalid server URL", func(t *testing.T) {
+		client := NewClient(ClientOptions{ServerURL: "ftp://relay.example.org", UserID: "alice"})
+		defer client.Close()
+		err := client.Connect()
+		if !errors.Is(err, ErrInvalidServerURL) {
+			t.Errorf("Connect = %v, want ErrInvalidServerURL", err)
+		}
+		var connectErr *ConnectError
+		if !errors.As(err, &connectErr) || connectErr.ServerURL != "ftp://relay.example.org" {
+			t.Errorf("Connect = %v, want a ConnectError for the URL", err)
+		}
+	})
+
+	t.Run("outbox full", func(t *testing.T) {
+		// Nothing reads the far end, so writes back up
+		conn, far := NewPipe()
+		defer far.Close()
+		client := NewClient(ClientOptions{ServerURL: "memory://relay", UserID: "alice", Transport: pipeTransport{conn}})
+		defer client.Close()
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+
+		var err error
+		for i := 0; i < 1000 && err == nil; i++ {
+			err = client.SendMessage("bob", "hi", MessageTypeChat)
+		}
+		if !errors.Is(err, ErrOutboxFull) {
+			t.Errorf("SendMessage = %v, want ErrOutboxFull", err)
+		}
+	})
+}
+
+func TestReconnectBacksOffFromRelayDroppingConnections(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	attempts := make(chan time.Time, 16)
+	client := NewClient(ClientOptions{
+		ServerURL:            "memory://relay",
+		UserID:               "alice",
+		Transport:            &MemoryTransport{Server: server},
+		ReconnectDelay:       20 * time.Millisecond,
+		MaxReconnectAttempts: 3,
+		ConnectionHandler: func(event ConnectionEvent) {
+			if event.Type == ConnectionEventReconnecting {
+				attempts <- event.Timestamp
+			}
+		},
+	})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, "the server hello", func() bool { return client.ProtocolVersion() != 0 })
+
+	// The stopped relay still takes in-memory connections, then closes
+	// them before answering the hello
+	server.Stop()
+	var times []time.Time
+	deadline := time.After(testTimeout)
+	for len(times) < 3 {
+		select {
+		case at := <-attempts:
+			times = append(times, at)
+		case <-deadline:
+			t.Fatalf("%d reconnection attempts, want 3", len(times))
+		}
+	}
+	for i := 1; i < len(times); i++ {
+		if gap, want := times[i].Sub(times[i-1]), time.Duration(i)*20*time.Millisecond; gap < want {
+			t.Errorf("attempt %d came %v after the one before, want at least %v", i+1, gap, want)
+		}
+	}
+	select {
+	case <-attempts:
+		t.Error("the client kept reconnecting past its limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if state := client.State(); state.Status != StatusDisconnected || state.ReconnectAttempt != 3 {
+		t.Errorf("state after giving up = %s at attempt %d, want disconnected at attempt 3", state.Status, state.ReconnectAttempt)
+	}
+}
+
+// heldDial is a transport whose dials report on dialing that they started
+// and then wait for the test to hand them a connection. Unless stubborn,
+// they give up when their context ends.
+type heldDial struct {
+	dialing  chan struct{}
+	conns    chan Conn
+	stubborn bool
+}
+
+func newHeldDial() *heldDial {
+	return &heldDial{dialing: make(chan struct{}, 16), conns: make(chan Conn, 1)}
+}
+
+func (h *heldDial) Dial(ctx context.Context, serverURL string) (Conn, error) {
+	h.dialing <- struct{}{}
+	if h.stubborn {
+		return <-h.conns, nil
+	}
+	select {
+	case conn := <-h.conns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// started waits for a dial to start
+func (h *heldDial) started(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-h.dialing:
+	case <-time.After(testTimeout):
+		t.Fatal("no dial started")
+	}
+}
+
+func TestDisconnectDoesNotWaitForDial(t *testing.T) {
+	dials := newHeldDial()
+	client := NewClient(ClientOptions{
+		ServerURL:         "memory://relay",
+		UserID:            "alice",
+		Transport:         dials,
+		ConnectionTimeout: time.Minute,
+	})
+	defer client.Close()
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect() }()
+	dials.started(t)
+
+	disconnected := make(chan struct{})
+	go func() {
+		client.Disconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(testTimeout):
+		t.Fatal("Disconnect waited for the dial")
+	}
+
+	select {
+	case err := <-connected:
+		if !errors.Is(err, ErrClientClosed) {
+			t.Errorf("Connect = %v, want ErrClientClosed", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the dial went on after Disconnect")
+	}
+	if client.IsConnected() {
+		t.Error("connected after Disconnect")
+	}
+}
+
+func TestNoReconnectionAfterDisconnect(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		// Every other dial only returns once it has a connection, like one
+		// stuck in the network
+		dials := newHeldDial()
+		dials.stubborn = i%2 == 1
+		client := NewClient(ClientOptions{
+			ServerURL:         "memory://relay",
+			UserID:            "alice",
+			Transport:         dials,
+			ReconnectDelay:    time.Millisecond,
+			ConnectionTimeout: time.Minute,
+		})
+
+		conn, far := NewPipe()
+		dials.conns <- conn
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		dials.started(t)
+
+		// The connection drops and a reconnect dials; its connection comes
+		// through as the client is disconnected, or after
+		far.Close()
+		dials.started(t)
+		late, lateFar := NewPipe()
+		handed := make(chan struct{})
+		handOver := func() {
+			dials.conns <- late
+			close(handed)
+		}
+		if !dials.stubborn {
+			go handOver()
+		}
+		disconnected := make(chan struct{})
+		go func() {
+			client.Disconnect()
+			close(disconnected)
+		}()
+		select {
+		case <-disconnected:
+		case <-time.After(testTimeout):
+			t.Fatal("Disconnect waited for the dial")
+		}
+		if dials.stubborn {
+			handOver()
+		}
+		<-handed
+
+		// A connection the dial got is closed, by Disconnect if it came
+		// first or by the dial finding the client disconnected
+		if len(dials.conns) == 0 {
+			lateFar.SetReadDeadline(time.Now().Add(testTimeout))
+			for {
+				_, err := lateFar.ReadMessage()
+				if errors.Is(err, ErrPipeClosed) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("the connection made as the client disconnected was left open: %v", err)
+				}
+			}
+		}
+		select {
+		case <-dials.dialing:
+			t.Fatal("the client dialed again after Disconnect")
+		case <-time.After(10 * time.Millisecond):
+		}
+		if client.IsConnected() || client.State().Status != StatusDisconnected {
+			t.Fatalf("after Disconnect: connected %v, state %s; want disconnected", client.IsConnected(), client.State().Status)
+		}
+		client.Close()
+		lateFar.Close()
+	}
+}
@@ -0,0 +1,38 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the network package. Callers should test for them with
+// errors.Is, since most are wrapped with additional context.
+var (
+	ErrNotConnected     = errors.New("not connected")
+	ErrAlreadyConnected = errors.New("already connected")
+	ErrOutboxFull       = errors.New("outgoing message queue is full")
+	ErrClientClosed     = errors.New("client is shutting down")
+	ErrInvalidServerURL = errors.New("invalid server URL")
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+	ErrVersionMismatch  = errors.New("no protocol version in common with the relay")
+	ErrNotSupported     = errors.New("message type not supported by the negotiated protocol version")
+	ErrSessionReplaced  = errors.New("a newer session from this device connected to the relay")
+
+	ErrInvalidRelaySignature = errors.New("relay hello has an invalid signature")
+	ErrRelayIdentityMismatch = errors.New("relay identity does not match the pinned fingerprint")
+	ErrInvalidHelloSignature = errors.New("client hello has an invalid signature")
+)
+
+// ConnectError describes a failed attempt to connect to a relay
+type ConnectError struct {
+	ServerURL string
+	Err       error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("failed to connect to %s: %v", e.ServerURL, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
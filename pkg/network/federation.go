@@ -0,0 +1,329 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ErrRecipientUnknown is returned by a Forwarder when no relay it knows
+// serves the recipient
+var ErrRecipientUnknown = errors.New("no peer relay serves the recipient")
+
+// federationPath is where a relay accepts messages forwarded by its peers
+const federationPath = "/federation/messages"
+
+// forwardTimeout bounds how long a peer may take to accept a message
+const forwardTimeout = 10 * time.Second
+
+// forwardWorkers is how many messages are offered to peer relays at once,
+// and forwardQueueSize how many more may wait their turn. Messages beyond
+// that are kept or dropped as if no peer had their recipient.
+const (
+	forwardWorkers   = 8
+	forwardQueueSize = 1024
+)
+
+// Forwarder hands a message for a user who is not connected to this relay
+// to a relay the user is connected to. The message is passed on as the
+// sender's client sealed it, so relays only ever route ciphertext.
+type Forwarder interface {
+	// Forward passes a message to a relay serving its recipient. It
+	// returns ErrRecipientUnknown if none does; the relay then queues or
+	// drops the message as it would without federation.
+	Forward(ctx context.Context, msg *Message) error
+}
+
+// NopForwarder forwards nothing, so messages for users who are not
+// connected are queued or dropped. It is the default.
+type NopForwarder struct{}
+
+// Forward implements Forwarder
+func (NopForwarder) Forward(ctx context.Context, msg *Message) error {
+	return ErrRecipientUnknown
+}
+
+// RelayForwarder forwards messages to peer relays over HTTP, offering each
+// to the peers in turn until one has the recipient connected. Peers
+// authenticate the relay with a shared token.
+type RelayForwarder struct {
+	peers  []string
+	token  string
+	client *http.Client
+}
+
+// NewRelayForwarder creates a forwarder to the relays at the given base
+// URLs, such as "https://relay.example.org:8080"
+func NewRelayForwarder(peers []string, token string) *RelayForwarder {
+	trimmed := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer = strings.TrimRight(strings.TrimSpace(peer), "/"); peer != "" {
+			trimmed = append(trimmed, peer)
+		}
+	}
+	return &RelayForwarder{
+		peers:  trimmed,
+		token:  token,
+		client: &http.Client{Timeout: forwardTimeout},
+	}
+}
+
+// Forward implements Forwarder. A peer that cannot be reached is skipped
+// like one that does not have the recipient connected.
+func (f *RelayForwarder) Forward(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range f.peers {
+		err := f.offer(ctx, peer, body)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrRecipientUnknown) {
+			log.Printf("Failed to forward message %s to relay %s: %v", msg.ID, peer, err)
+		}
+	}
+	return ErrRecipientUnknown
+}
+
+// offer posts a message to one peer
+func (f *RelayForwarder) offer(ctx context.Context, peer string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+federationPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+f.token)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return ErrRecipientUnknown
+	default:
+		return fmt.Errorf("relay answered %s", resp.Status)
+	}
+}
+
+// forwardMessage offers a message for a user who is not connected to the
+// peer relays, falling back to queueing or dropping it. It runs outside
+// the router on a forwarding worker, so a slow peer does not hold up other
+// messages. A peer accepting a chat message has only queued it for its
+// recipient, so the sender is told it is queued; the peer passes back the
+// delivered status once it is handed over.
+//
+// The peer may pass the delivered status back before the forward returns,
+// so statuses for the message are held until the sender has been told it
+// is queued.
+func (s *Server) forwardMessage(routedMsg *RoutedMessage) {
+	ctx, cancel := context.WithTimeout(s.ctx, forwardTimeout)
+	defer cancel()
+
+	messageID := routedMsg.Message.ID
+	isChat := routedMsg.Message.Type == MessageTypeChat
+	if isChat {
+		s.forwarding.hold(messageID)
+	}
+
+	err := s.forwarder.Forward(ctx, routedMsg.Message)
+	if err == nil {
+		s.messagesRouted.Add(1)
+		log.Printf("Message forwarded from %s to %s on a peer relay", routedMsg.From, routedMsg.To)
+		if isChat {
+			s.sendDeliveryStatus(routedMsg, "queued")
+		}
+	} else {
+		s.keepUnforwarded(routedMsg)
+	}
+
+	if isChat {
+		for _, status := range s.forwarding.release(messageID) {
+			s.passStatus(status)
+		}
+	}
+}
+
+// passStatus hands a delivery status a peer relay passed back to the
+// sender it is for, if the sender is still connected
+func (s *Server) passStatus(status *Message) {
+	if sender := s.findClientByUserID(status.To); sender != nil {
+		sender.enqueue(status)
+	}
+}
+
+// heldStatuses keeps the delivery statuses peer relays pass back for chat
+// messages that are still being forwarded
+type heldStatuses struct {
+	mu   sync.Mutex
+	held map[string][]*Message
+}
+
+func newHeldStatuses() *heldStatuses {
+	return &heldStatuses{held: make(map[string][]*Message)}
+}
+
+// hold starts holding statuses for a message about to be forwarded
+func (h *heldStatuses) hold(messageID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.held[messageID] = nil
+}
+
+// add holds status if its message is still being forwarded, and reports
+// whether it did
+func (h *heldStatuses) add(status *Message) bool {
+	var ack AckPayload
+	if status.Type != MessageTypeAck || status.DecodePayload(&ack) != nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses, ok := h.held[ack.MessageID]
+	if ok {
+		h.held[ack.MessageID] = append(statuses, status)
+	}
+	return ok
+}
+
+// release stops holding statuses for a message and returns those held
+func (h *heldStatuses) release(messageID string) []*Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses := h.held[messageID]
+	delete(h.held, messageID)
+	return statuses
+}
+
+// queueForward hands a message to the forwarding workers, keeping it as
+// undeliverable at once if too many are waiting already
+func (s *Server) queueForward(routedMsg *RoutedMessage) {
+	select {
+	case s.forwardQueue <- routedMsg:
+	default:
+		log.Printf("Warning: forwarding queue full, not forwarding message %s", routedMsg.Message.ID)
+		s.keepUnforwarded(routedMsg)
+	}
+}
+
+// forwardWorker forwards queued messages until the relay stops
+func (s *Server) forwardWorker() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case routedMsg := <-s.forwardQueue:
+			s.forwardMessage(routedMsg)
+		}
+	}
+}
+
+// keepUnforwarded queues or drops a message no peer relay took, as the
+// delivery mode says
+func (s *Server) keepUnforwarded(routedMsg *RoutedMessage) {
+	if s.deliveryMode == DeliveryImmediate {
+		s.dropOfflineMessage(routedMsg)
+	} else {
+		s.queueOfflineMessage(routedMsg)
+	}
+}
+
+// FederationHandler returns the handler through which peer relays forward
+// messages for users connected to this relay. It rejects every request
+// unless a federation token is set.
+func (s *Server) FederationHandler() http.Handler {
+	return http.HandlerFunc(s.handleFederatedMessage)
+}
+
+// handleFederatedMessage delivers a message forwarded by a peer relay to
+// its recipient. A recipient who is not connected is reported as not found
+// rather than queued, since the user may belong to another relay; the
+// forwarding relay keeps the message instead. Forwarded messages are never
+// forwarded again, so peers cannot pass one around in circles.
+func (s *Server) handleFederatedMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !hasBearerToken(r, s.federationToken) {
+		writeAdminError(w, http.StatusUnauthorized, "invalid federation token")
+		return
+	}
+
+	var msg Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageSize)).Decode(&msg); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid message: "+err.Error())
+		return
+	}
+	to, err := models.ParseUserID(msg.To)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msg.To = to
+
+	client := s.findClientByUserID(to)
+	if client == nil {
+		writeAdminError(w, http.StatusNotFound, "recipient is not connected")
+		return
+	}
+	if !supportsMessage(client.version(), msg.Type) {
+		writeAdminError(w, http.StatusUnprocessableEntity,
+			fmt.Sprintf("recipient cannot receive %s messages", msg.Type))
+		return
+	}
+
+	// A status for a message this relay is still forwarding waits until
+	// the sender has been told it is queued
+	if s.forwarding.add(&msg) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if !client.enqueue(&msg) {
+		writeAdminError(w, http.StatusServiceUnavailable, "recipient queue full")
+		return
+	}
+	s.messagesRouted.Add(1)
+	log.Printf("Forwarded message routed from %s to %s", msg.From, to)
+	w.WriteHeader(http.StatusAccepted)
+
+	if msg.Type == MessageTypeChat {
+		go s.returnDeliveryStatus(msg.ID, msg.From, to)
+	}
+}
+
+// returnDeliveryStatus tells the sender of a forwarded chat message, through
+// the peer relays, that it was handed to its recipient. The status is
+// dropped if no peer has the sender connected; the sender then keeps the
+// queued status its relay gave.
+func (s *Server) returnDeliveryStatus(messageID, from, to string) {
+	ctx, cancel := context.WithTimeout(s.ctx, forwardTimeout)
+	defer cancel()
+
+	ack := newMessage(MessageTypeAck, "server", from, AckPayload{
+		MessageID: messageID,
+		Recipient: to,
+		Status:    "delivered",
+	})
+	if err := s.forwarder.Forward(ctx, ack); err != nil {
+		log.Printf("Could not pass the delivered status of message %s back to %s: %v", messageID, from, err)
+	}
+}
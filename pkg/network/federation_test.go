@@ -0,0 +1,112 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// federatedRelays starts two relays that list each other as peers
+func federatedRelays(t *testing.T) (*Server, *Server) {
+	t.Helper()
+
+	const token = "federation-secret"
+	var a, b *Server
+	peerA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.FederationHandler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(peerA.Close)
+	peerB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.FederationHandler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(peerB.Close)
+
+	a = newTestRelay(t, ServerOptions{
+		Forwarder:       NewRelayForwarder([]string{peerB.URL}, token),
+		FederationToken: token,
+	})
+	b = newTestRelay(t, ServerOptions{
+		Forwarder:       NewRelayForwarder([]string{peerA.URL}, token),
+		FederationToken: token,
+	})
+	return a, b
+}
+
+func TestFederatedMessageIsQueuedThenDelivered(t *testing.T) {
+	a, b := federatedRelays(t)
+	alice := connectAs(t, a, "alice", "laptop", nil)
+	bob := connectAs(t, b, "bob", "phone", nil)
+
+	msg := newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "hi"})
+	alice.send(msg)
+
+	if got := bob.expect(MessageTypeChat); got.ID != msg.ID {
+		t.Fatalf("bob got message %s, want %s", got.ID, msg.ID)
+	}
+	if got := alice.expectStatus(msg.ID); got != "queued" {
+		t.Errorf("first status = %s, want queued", got)
+	}
+	if got := alice.expectStatus(msg.ID); got != "delivered" {
+		t.Errorf("second status = %s, want delivered", got)
+	}
+}
+
+func TestFederatedMessageForUnknownUserStaysQueued(t *testing.T) {
+	a, _ := federatedRelays(t)
+	alice := connectAs(t, a, "alice", "laptop", nil)
+
+	msg := newMessage(MessageTypeChat, "alice", "carol", ChatPayload{Content: "hi"})
+	alice.send(msg)
+
+	if got := alice.expectStatus(msg.ID); got != "queued" {
+		t.Errorf("status = %s, want queued", got)
+	}
+	carol := connectAs(t, a, "carol", "phone", nil)
+	if got := carol.expect(MessageTypeChat); got.ID != msg.ID {
+		t.Errorf("carol got message %s, want %s", got.ID, msg.ID)
+	}
+}
+
+// forwardFunc is a Forwarder calling itself
+type forwardFunc func(ctx context.Context, msg *Message) error
+
+func (f forwardFunc) Forward(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+func TestDeliveredStatusWaitsForTheForwardToReturn(t *testing.T) {
+	const token = "federation-secret"
+	var a *Server
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.FederationHandler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(peer.Close)
+
+	// The peer hands the message over and passes the delivered status back
+	// before answering the forward
+	back := NewRelayForwarder([]string{peer.URL}, token)
+	a = newTestRelay(t, ServerOptions{
+		FederationToken: token,
+		Forwarder: forwardFunc(func(ctx context.Context, msg *Message) error {
+			if msg.Type != MessageTypeChat {
+				return nil
+			}
+			return back.Forward(ctx, newMessage(MessageTypeAck, "server", msg.From, AckPayload{
+				MessageID: msg.ID,
+				Recipient: msg.To,
+				Status:    "delivered",
+			}))
+		}),
+	})
+	alice := connectAs(t, a, "alice", "laptop", nil)
+
+	msg := newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "hi"})
+	alice.send(msg)
+	if got := alice.expectStatus(msg.ID); got != "queued" {
+		t.Errorf("first status = %s, want queued", got)
+	}
+	if got := alice.expectStatus(msg.ID); got != "delivered" {
+		t.Errorf("second status = %s, want delivered", got)
+	}
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RelayIdentity is the long-term Ed25519 key a relay signs its hello with,
+// so clients can pin a relay by its fingerprint
+type RelayIdentity struct {
+	privateKey ed25519.PrivateKey
+}
+
+// LoadRelayIdentity reads a relay identity from a PEM file, generating and
+// saving a new one if the file does not exist
+func LoadRelayIdentity(path string) (*RelayIdentity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createRelayIdentity(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relay identity: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("relay identity %s is not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse relay identity %s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("relay identity %s is not an Ed25519 key", path)
+	}
+
+	return &RelayIdentity{privateKey: privateKey}, nil
+}
+
+// createRelayIdentity generates a relay identity and saves it to path,
+// readable only by its owner
+func createRelayIdentity(path string) (*RelayIdentity, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate relay identity: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode relay identity: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create relay identity directory: %w", err)
+	}
+
+	// O_EXCL keeps a concurrently created identity from being overwritten
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save relay identity: %w", err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, fmt.Errorf("failed to save relay identity: %w", err)
+	}
+
+	return &RelayIdentity{privateKey: privateKey}, nil
+}
+
+// PublicKey returns the relay's public key
+func (r *RelayIdentity) PublicKey() ed25519.PublicKey {
+	return r.privateKey.Public().(ed25519.PublicKey)
+}
+
+// Fingerprint returns the fingerprint clients pin the relay by
+func (r *RelayIdentity) Fingerprint() string {
+	return RelayFingerprint(r.PublicKey())
+}
+
+// RelayFingerprint returns the fingerprint of a relay public key: the first
+// 16 bytes of its SHA-256 hash in hex
+func RelayFingerprint(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hash[:16])
+}
+
+// signHello fills in the identity and signature of a server hello answering
+// a client's hello
+func (r *RelayIdentity) signHello(hello *ServerHelloPayload, nonce, userID string) {
+	hello.IdentityKey = base64.StdEncoding.EncodeToString(r.PublicKey())
+	signature := ed25519.Sign(r.privateKey, helloSigningInput(hello, nonce, userID))
+	hello.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+// verifyServerHello checks the signature of a server hello against the
+// identity key it carries and returns the relay's fingerprint. The
+// signature covers the client's nonce, so a hello recorded from another
+// session cannot be replayed.
+func verifyServerHello(hello *ServerHelloPayload, nonce, userID string) (string, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(hello.IdentityKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("%w: malformed identity key", ErrInvalidRelaySignature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(hello.Signature)
+	if err != nil || !ed25519.Verify(publicKey, helloSigningInput(hello, nonce, userID), signature) {
+		return "", ErrInvalidRelaySignature
+	}
+	return RelayFingerprint(publicKey), nil
+}
+
+// helloSigningInput returns the bytes a server hello signature covers
+func helloSigningInput(hello *ServerHelloPayload, nonce, userID string) []byte {
+	return []byte(fmt.Sprintf("securechat relay hello\x00%s\x00%s\x00%d\x00%s\x00%s",
+		hello.IdentityKey, nonce, hello.Version, hello.SessionID, userID))
+}
+
+// newHelloNonce returns a random nonce for a client hello
+func newHelloNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// helloMaxAge is how far the time a client hello was signed at may be from
+// the relay's clock. Nonces are remembered this long, so a recorded hello
+// cannot be replayed.
+const helloMaxAge = 5 * time.Minute
+
+// signClientHello fills in the identity and signature of a client hello.
+// The signature proves to the relay that the session belongs to whoever
+// holds the key, rather than anyone who knows the user and device IDs.
+func signClientHello(key ed25519.PrivateKey, hello *ClientHelloPayload, userID string, timestamp int64) {
+	hello.IdentityKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	signature := ed25519.Sign(key, clientHelloSigningInput(hello, userID, timestamp))
+	hello.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+// verifyClientHello checks the signature of a client hello against the
+// identity key it carries
+func verifyClientHello(hello *ClientHelloPayload, userID string, timestamp int64) error {
+	publicKey, err := base64.StdEncoding.DecodeString(hello.IdentityKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: malformed identity key", ErrInvalidHelloSignature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(hello.Signature)
+	if err != nil || !ed25519.Verify(publicKey, clientHelloSigningInput(hello, userID, timestamp), signature) {
+		return ErrInvalidHelloSignature
+	}
+	return nil
+}
+
+// clientHelloSigningInput returns the bytes a client hello signature covers
+func clientHelloSigningInput(hello *ClientHelloPayload, userID string, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("securechat client hello\x00%s\x00%s\x00%s\x00%s\x00%d",
+		hello.IdentityKey, userID, hello.DeviceID, hello.Nonce, timestamp))
+}
@@ -0,0 +1,130 @@
+package network
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelayIdentityPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay", "identity.pem")
+	first, err := LoadRelayIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadRelayIdentity: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("identity file = %v, %v, want mode 0600", info, err)
+	}
+
+	again, err := LoadRelayIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadRelayIdentity: %v", err)
+	}
+	if again.Fingerprint() != first.Fingerprint() {
+		t.Errorf("fingerprint after reloading = %s, want %s", again.Fingerprint(), first.Fingerprint())
+	}
+
+	corrupt := filepath.Join(t.TempDir(), "identity.pem")
+	if err := os.WriteFile(corrupt, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadRelayIdentity(corrupt); err == nil {
+		t.Error("LoadRelayIdentity accepted a file that is not a key")
+	}
+}
+
+func TestServerHelloSignature(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{IdentityPath: filepath.Join(t.TempDir(), "identity.pem")})
+	session := dialRelay(t, server)
+	nonce := newHelloNonce()
+	session.send(newMessage(MessageTypeClientHello, "alice", "", ClientHelloPayload{
+		MinVersion: SupportedVersions.Min,
+		MaxVersion: SupportedVersions.Max,
+		Nonce:      nonce,
+	}))
+	var hello ServerHelloPayload
+	if err := session.expect(MessageTypeServerHello).DecodePayload(&hello); err != nil {
+		t.Fatalf("DecodePayload: %v", err)
+	}
+
+	fingerprint, err := verifyServerHello(&hello, nonce, "alice")
+	if err != nil {
+		t.Fatalf("verifyServerHello: %v", err)
+	}
+	if fingerprint != server.Fingerprint() {
+		t.Errorf("fingerprint = %s, want the relay's %s", fingerprint, server.Fingerprint())
+	}
+
+	other, err := LoadRelayIdentity(filepath.Join(t.TempDir(), "other.pem"))
+	if err != nil {
+		t.Fatalf("LoadRelayIdentity: %v", err)
+	}
+	tests := []struct {
+		name   string
+		change func(hello *ServerHelloPayload, nonce, userID *string)
+	}{
+		{"another nonce", func(hello *ServerHelloPayload, nonce, userID *string) { *nonce = newHelloNonce() }},
+		{"another user", func(hello *ServerHelloPayload, nonce, userID *string) { *userID = "mallory" }},
+		{"another version", func(hello *ServerHelloPayload, nonce, userID *string) { hello.Version-- }},
+		{"another session", func(hello *ServerHelloPayload, nonce, userID *string) { hello.SessionID = "s2" }},
+		{"another key", func(hello *ServerHelloPayload, nonce, userID *string) {
+			hello.IdentityKey = base64.StdEncoding.EncodeToString(other.PublicKey())
+		}},
+		{"a malformed key", func(hello *ServerHelloPayload, nonce, userID *string) { hello.IdentityKey = "!!" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, changedNonce, userID := hello, nonce, "alice"
+			tt.change(&changed, &changedNonce, &userID)
+			if _, err := verifyServerHello(&changed, changedNonce, userID); !errors.Is(err, ErrInvalidRelaySignature) {
+				t.Errorf("verifyServerHello: %v, want ErrInvalidRelaySignature", err)
+			}
+		})
+	}
+}
+
+func TestClientPinsRelayIdentity(t *testing.T) {
+	withIdentity := newTestRelay(t, ServerOptions{IdentityPath: filepath.Join(t.TempDir(), "identity.pem")})
+	withoutIdentity := newTestRelay(t, ServerOptions{})
+	tests := []struct {
+		name    string
+		server  *Server
+		pin     string
+		wantErr error
+	}{
+		{"pinned relay", withIdentity, withIdentity.Fingerprint(), nil},
+		{"pin in upper case", withIdentity, strings.ToUpper(withIdentity.Fingerprint()), nil},
+		{"unpinned relay", withIdentity, "", nil},
+		{"unpinned relay without identity", withoutIdentity, "", nil},
+		{"another relay", withIdentity, strings.Repeat("ab", 16), ErrRelayIdentityMismatch},
+		{"pinned relay without identity", withoutIdentity, withIdentity.Fingerprint(), ErrRelayIdentityMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(ClientOptions{
+				ServerURL:        "memory://relay",
+				UserID:           "alice",
+				Transport:        &MemoryTransport{Server: tt.server},
+				RelayFingerprint: tt.pin,
+			})
+			t.Cleanup(func() { client.Close() })
+			if err := client.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+
+			if tt.wantErr == nil {
+				waitFor(t, "the server hello", func() bool { return client.ProtocolVersion() != 0 })
+				return
+			}
+			waitFor(t, "the relay to be rejected", func() bool { return client.State().LastError != nil })
+			if err := client.State().LastError; !errors.Is(err, tt.wantErr) {
+				t.Errorf("LastError = %v, want %v", err, tt.wantErr)
+			}
+			waitFor(t, "the client to disconnect", func() bool { return !client.IsConnected() })
+		})
+	}
+}
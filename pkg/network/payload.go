@@ -0,0 +1,347 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ErrInvalidPayload is returned when a message payload is missing required
+// fields or has fields of the wrong type
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// Message types with a typed payload
+const (
+	MessageTypeChat        = "chat"
+	MessageTypeAck         = "ack"
+	MessageTypeError       = "error"
+	MessageTypeClientHello = "client_hello"
+	MessageTypeServerHello = "server_hello"
+	MessageTypePresence    = "presence"
+	MessageTypeTyping      = "typing"
+
+	// Key exchange between two users, which the relay passes on like chat
+	// messages: the initiator asks for the peer's keys, gets them in a
+	// bundle and starts the session with a session init
+	MessageTypeKeyRequest  = "key_request"
+	MessageTypeKeyBundle   = "key_bundle"
+	MessageTypeSessionInit = "session_init"
+
+	// A user asking the sender of a chat to send again messages that never
+	// arrived, which the relay passes on like chat messages
+	MessageTypeResendRequest = "resend_request"
+)
+
+// MaxResendSequences is the most messages one resend request may ask for
+const MaxResendSequences = 100
+
+// ChatPayload is the payload of a chat message
+type ChatPayload struct {
+	Content  string `json:"content"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+
+	// Number of the message among those the sending install sent in the
+	// chat, counting from 1 without gaps
+	SenderSequence uint64 `json:"sender_sequence,omitempty"`
+
+	// Original author of a forwarded message
+	ForwardedFrom string `json:"forwarded_from,omitempty"`
+
+	// Install the message was sent from, sealed with the content
+	DeviceID   string `json:"device_id,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+
+	// Set on messages encrypted with a ratchet session, whose other fields
+	// are sealed in Ciphertext
+	Header     *RatchetHeader `json:"header,omitempty"`
+	Ciphertext []byte         `json:"ciphertext,omitempty"`
+}
+
+// RatchetHeader tells the recipient which ratchet step decrypts a message
+type RatchetHeader struct {
+	RatchetKey      []byte `json:"dh"` // Sender's current ratchet public key
+	PreviousCounter uint32 `json:"pn"` // Messages sent in the previous sending chain
+	MessageNumber   uint32 `json:"n"`  // Number of the message in the current chain
+}
+
+// KeyRequestPayload asks a user for their key bundle
+type KeyRequestPayload struct {
+	Ciphers []int `json:"ciphers,omitempty"` // Ciphers the requester offers, preferred first
+}
+
+// KeyBundlePayload carries the keys a session with a user is started from:
+// their identity keys and a prekey signed with the identity signing key
+type KeyBundlePayload struct {
+	IdentityKey     []byte `json:"identity_key"` // Ed25519 signing key
+	ExchangeKey     []byte `json:"exchange_key"` // X25519 identity key
+	PreKeyID        uint32 `json:"pre_key_id"`
+	SignedPreKey    []byte `json:"signed_pre_key"`
+	PreKeySignature []byte `json:"pre_key_signature"`
+	Ciphers         []int  `json:"ciphers,omitempty"`
+}
+
+// SessionInitPayload tells a user that a session was started with their key
+// bundle. Signature is made with the identity signing key over
+// EphemeralKey followed by RatchetKey.
+type SessionInitPayload struct {
+	IdentityKey  []byte `json:"identity_key"`
+	ExchangeKey  []byte `json:"exchange_key"`
+	EphemeralKey []byte `json:"ephemeral_key"`
+	PreKeyID     uint32 `json:"pre_key_id"`
+	RatchetKey   []byte `json:"ratchet_key"` // Initiator's initial ratchet public key
+	Ciphers      []int  `json:"ciphers,omitempty"`
+	Signature    []byte `json:"signature"`
+}
+
+// ClientHelloPayload is the payload a client introduces itself with
+type ClientHelloPayload struct {
+	MinVersion   int      `json:"min_version,omitempty"`
+	MaxVersion   int      `json:"max_version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	HideLastSeen bool     `json:"hide_last_seen,omitempty"`
+	Invisible    bool     `json:"invisible,omitempty"`
+
+	// Leave queued messages on the relay until they are requested with
+	// sync requests, rather than receiving them all at once
+	SelectiveSync bool `json:"selective_sync,omitempty"`
+
+	// Random value the relay signs its hello over, proving the hello is fresh
+	Nonce string `json:"nonce,omitempty"`
+
+	// Install the client runs on. A new session from the same install
+	// replaces the user's old one, which the relay may not have noticed
+	// dropping yet.
+	DeviceID string `json:"device_id,omitempty"`
+
+	// User's Ed25519 identity key and its signature over the hello. Only a
+	// session signed with the same key can replace another.
+	IdentityKey string `json:"identity_key,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+}
+
+// ServerHelloPayload is the relay's answer to a client hello. Version is the
+// negotiated protocol version. Relays with an identity include their public
+// key and sign the hello with it. Relays that predate delivery modes leave
+// DeliveryMode empty; they store messages.
+type ServerHelloPayload struct {
+	Version      int          `json:"version"`
+	SessionID    string       `json:"session_id"`
+	Capabilities []string     `json:"capabilities,omitempty"`
+	DeliveryMode DeliveryMode `json:"delivery_mode,omitempty"`
+	IdentityKey  string       `json:"identity_key,omitempty"`
+	Signature    string       `json:"signature,omitempty"`
+}
+
+// AckPayload reports the delivery status of a message
+type AckPayload struct {
+	MessageID string `json:"message_id"`
+	Recipient string `json:"recipient"`
+	Status    string `json:"status"`
+}
+
+// ResendRequestPayload asks the sender of a chat for the messages with the
+// given sender sequences again. DeviceID names the install that numbered
+// them; other installs of the sender ignore the request.
+type ResendRequestPayload struct {
+	DeviceID  string   `json:"device_id,omitempty"`
+	Sequences []uint64 `json:"sequences"`
+}
+
+// ErrorPayload describes a protocol error reported by the relay
+type ErrorPayload struct {
+	Code        string `json:"error_code"`
+	Message     string `json:"error_message"`
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+// PresencePayload announces a user's own status
+type PresencePayload struct {
+	Status        string `json:"status"`
+	StatusMessage string `json:"status_message,omitempty"`
+}
+
+// TypingPayload signals that a user started or stopped typing
+type TypingPayload struct {
+	ChatID string `json:"chat_id,omitempty"`
+	Typing bool   `json:"typing"`
+}
+
+// PresenceQueryPayload asks the relay for the presence of users
+type PresenceQueryPayload struct {
+	UserIDs   []string `json:"user_ids"`
+	Subscribe bool     `json:"subscribe,omitempty"`
+}
+
+// PresenceReportPayload is the payload of presence responses and updates.
+// Last-seen times are Unix seconds.
+type PresenceReportPayload struct {
+	Statuses       map[string]string `json:"statuses"`
+	LastSeen       map[string]int64  `json:"last_seen,omitempty"`
+	LastSeenHidden map[string]bool   `json:"last_seen_hidden,omitempty"`
+}
+
+func (p *ChatPayload) validate() error {
+	if p.Header != nil {
+		if len(p.Ciphertext) == 0 {
+			return fmt.Errorf("%w: encrypted chat message has no ciphertext", ErrInvalidPayload)
+		}
+		return nil
+	}
+	if p.Content == "" {
+		return fmt.Errorf("%w: chat message has no content", ErrInvalidPayload)
+	}
+	return nil
+}
+
+func (p *KeyBundlePayload) validate() error {
+	if len(p.IdentityKey) == 0 || len(p.ExchangeKey) == 0 || len(p.SignedPreKey) == 0 || len(p.PreKeySignature) == 0 {
+		return fmt.Errorf("%w: key bundle requires identity_key, exchange_key, signed_pre_key and pre_key_signature", ErrInvalidPayload)
+	}
+	return nil
+}
+
+func (p *SessionInitPayload) validate() error {
+	if len(p.IdentityKey) == 0 || len(p.ExchangeKey) == 0 || len(p.EphemeralKey) == 0 || len(p.RatchetKey) == 0 || len(p.Signature) == 0 {
+		return fmt.Errorf("%w: session init requires identity_key, exchange_key, ephemeral_key, ratchet_key and signature", ErrInvalidPayload)
+	}
+	return nil
+}
+
+func (p *AckPayload) validate() error {
+	if p.MessageID == "" || p.Recipient == "" || p.Status == "" {
+		return fmt.Errorf("%w: ack requires message_id, recipient and status", ErrInvalidPayload)
+	}
+	return nil
+}
+
+func (p *ResendRequestPayload) validate() error {
+	if len(p.Sequences) == 0 {
+		return fmt.Errorf("%w: resend request has no sequences", ErrInvalidPayload)
+	}
+	if len(p.Sequences) > MaxResendSequences {
+		return fmt.Errorf("%w: resend request asks for %d messages, at most %d are allowed", ErrInvalidPayload, len(p.Sequences), MaxResendSequences)
+	}
+	return nil
+}
+
+func (p *ErrorPayload) validate() error {
+	if p.Code == "" {
+		return fmt.Errorf("%w: error has no error_code", ErrInvalidPayload)
+	}
+	return nil
+}
+
+func (p *PresencePayload) validate() error {
+	if p.Status == "" {
+		return fmt.Errorf("%w: presence has no status", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// payloadValidator is implemented by payloads with required fields
+type payloadValidator interface {
+	validate() error
+}
+
+// DecodePayload decodes the message payload into dest, a pointer to one of
+// the payload structs, and checks its required fields
+func (m *Message) DecodePayload(dest interface{}) error {
+	data, err := json.Marshal(m.Payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("%w: %s message: %v", ErrInvalidPayload, m.Type, err)
+	}
+
+	if v, ok := dest.(payloadValidator); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+// ParsePayload decodes the payload into the struct for the message type.
+// Types without a payload struct in this package return an error.
+func (m *Message) ParsePayload() (interface{}, error) {
+	var payload interface{}
+	switch m.Type {
+	case MessageTypeChat:
+		payload = &ChatPayload{}
+	case MessageTypeAck:
+		payload = &AckPayload{}
+	case MessageTypeError:
+		payload = &ErrorPayload{}
+	case MessageTypeClientHello:
+		payload = &ClientHelloPayload{}
+	case MessageTypeServerHello:
+		payload = &ServerHelloPayload{}
+	case MessageTypePresence:
+		payload = &PresencePayload{}
+	case MessageTypeTyping:
+		payload = &TypingPayload{}
+	case messageTypePresenceQuery, messageTypePresenceUnsubscribe:
+		payload = &PresenceQueryPayload{}
+	case messageTypePresenceResponse, messageTypePresenceUpdate:
+		payload = &PresenceReportPayload{}
+	case messageTypeSyncRequest:
+		payload = &SyncRequestPayload{}
+	case messageTypeSyncStatus:
+		payload = &SyncStatusPayload{}
+	case MessageTypeAnnouncement:
+		payload = &AnnouncementPayload{}
+	case MessageTypeKeyRequest:
+		payload = &KeyRequestPayload{}
+	case MessageTypeKeyBundle:
+		payload = &KeyBundlePayload{}
+	case MessageTypeSessionInit:
+		payload = &SessionInitPayload{}
+	case MessageTypeResendRequest:
+		payload = &ResendRequestPayload{}
+	case messageTypeBatch:
+		payload = &BatchPayload{}
+	default:
+		return nil, fmt.Errorf("%w: no payload type for %q messages", ErrInvalidPayload, m.Type)
+	}
+
+	if err := m.DecodePayload(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// EncodePayload converts a payload struct to the generic wire form
+func EncodePayload(payload interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	return fields, nil
+}
+
+// newMessage builds a message with a typed payload. The payload structs in
+// this package always encode, so a failure is logged rather than returned.
+func newMessage(msgType, from, to string, payload interface{}) *Message {
+	fields, err := EncodePayload(payload)
+	if err != nil {
+		log.Printf("Failed to encode %s payload: %v", msgType, err)
+	}
+
+	return &Message{
+		ID:        models.NewMessageID(),
+		Type:      msgType,
+		From:      from,
+		To:        to,
+		Timestamp: time.Now().Unix(),
+		Payload:   fields,
+	}
+}
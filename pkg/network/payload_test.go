@@ -0,0 +1,144 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// overTheWire returns msg as the peer reading it off a connection sees it
+func overTheWire(t *testing.T, msg *Message) *Message {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var received Message
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return &received
+}
+
+func TestParsePayloadDecodesEachType(t *testing.T) {
+	inner := overTheWire(t, newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "hi"}))
+	tests := []struct {
+		msgType string
+		payload interface{}
+	}{
+		{MessageTypeChat, &ChatPayload{Content: "hello", Sequence: 3, ReplyTo: "m1", SenderSequence: 2, ForwardedFrom: "carol", DeviceID: "laptop"}},
+		{MessageTypeChat, &ChatPayload{Header: &RatchetHeader{RatchetKey: []byte{1, 2}, PreviousCounter: 4, MessageNumber: 5}, Ciphertext: []byte{9, 8, 7}}},
+		{MessageTypeAck, &AckPayload{MessageID: "m1", Recipient: "bob", Status: "delivered"}},
+		{MessageTypeError, &ErrorPayload{Code: "RATE_LIMITED", Message: "slow down", ReferenceID: "m1"}},
+		{MessageTypeClientHello, &ClientHelloPayload{MinVersion: 1, MaxVersion: 2, Capabilities: []string{capabilityBatch}, HideLastSeen: true, Nonce: "n", DeviceID: "laptop"}},
+		{MessageTypeServerHello, &ServerHelloPayload{Version: 2, SessionID: "s1", DeliveryMode: DeliveryStoreAndForward}},
+		{MessageTypePresence, &PresencePayload{Status: "away", StatusMessage: "lunch"}},
+		{MessageTypeTyping, &TypingPayload{ChatID: "alice:bob", Typing: true}},
+		{messageTypePresenceQuery, &PresenceQueryPayload{UserIDs: []string{"bob"}, Subscribe: true}},
+		{messageTypePresenceUpdate, &PresenceReportPayload{Statuses: map[string]string{"bob": "offline"}, LastSeen: map[string]int64{"bob": 1700000000}}},
+		{messageTypeSyncRequest, &SyncRequestPayload{Prefer: []string{"bob"}, Limit: 50}},
+		{messageTypeSyncStatus, &SyncStatusPayload{Delivered: 50, Remaining: 3}},
+		{MessageTypeAnnouncement, &AnnouncementPayload{Text: "maintenance at noon"}},
+		{MessageTypeKeyRequest, &KeyRequestPayload{Ciphers: []int{2, 1}}},
+		{MessageTypeKeyBundle, &KeyBundlePayload{IdentityKey: []byte{1}, ExchangeKey: []byte{2}, PreKeyID: 7, SignedPreKey: []byte{3}, PreKeySignature: []byte{4}}},
+		{MessageTypeSessionInit, &SessionInitPayload{IdentityKey: []byte{1}, ExchangeKey: []byte{2}, EphemeralKey: []byte{3}, PreKeyID: 7, RatchetKey: []byte{4}, Signature: []byte{5}}},
+		{MessageTypeResendRequest, &ResendRequestPayload{DeviceID: "laptop", Sequences: []uint64{4, 5}}},
+		{messageTypeBatch, &BatchPayload{Messages: []*Message{inner}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msgType, func(t *testing.T) {
+			msg := overTheWire(t, newMessage(tt.msgType, "alice", "bob", tt.payload))
+			got, err := msg.ParsePayload()
+			if err != nil {
+				t.Fatalf("ParsePayload: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.payload) {
+				t.Errorf("ParsePayload = %+v, want %+v", got, tt.payload)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadKeepsWireNames(t *testing.T) {
+	// Messages as clients from before typed payloads wrote them
+	tests := []struct {
+		wire string
+		dest interface{}
+		want interface{}
+	}{
+		{`{"type":"chat","payload":{"content":"hello","reply_to":"m1"}}`, &ChatPayload{}, &ChatPayload{Content: "hello", ReplyTo: "m1"}},
+		{`{"type":"ack","payload":{"message_id":"m1","recipient":"bob","status":"read"}}`, &AckPayload{}, &AckPayload{MessageID: "m1", Recipient: "bob", Status: "read"}},
+		{`{"type":"error","payload":{"error_code":"KICKED","error_message":"bye"}}`, &ErrorPayload{}, &ErrorPayload{Code: "KICKED", Message: "bye"}},
+		{`{"type":"typing","payload":{"typing":false,"extra":1}}`, &TypingPayload{}, &TypingPayload{}},
+	}
+
+	for _, tt := range tests {
+		var msg Message
+		if err := json.Unmarshal([]byte(tt.wire), &msg); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", tt.wire, err)
+		}
+		if err := msg.DecodePayload(tt.dest); err != nil {
+			t.Errorf("DecodePayload(%s): %v", tt.wire, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.dest, tt.want) {
+			t.Errorf("DecodePayload(%s) = %+v, want %+v", tt.wire, tt.dest, tt.want)
+		}
+	}
+}
+
+func TestMalformedPayloadsAreRejected(t *testing.T) {
+	tooMany := make([]interface{}, MaxResendSequences+1)
+	for i := range tooMany {
+		tooMany[i] = float64(i + 1)
+	}
+	manyMessages := make([]interface{}, maxBatchMessages+1)
+	for i := range manyMessages {
+		manyMessages[i] = map[string]interface{}{"id": "m", "type": "chat"}
+	}
+
+	tests := []struct {
+		name    string
+		msgType string
+		payload map[string]interface{}
+	}{
+		{"chat content of the wrong type", MessageTypeChat, map[string]interface{}{"content": 42}},
+		{"chat without content", MessageTypeChat, map[string]interface{}{"reply_to": "m1"}},
+		{"encrypted chat without ciphertext", MessageTypeChat, map[string]interface{}{"header": map[string]interface{}{"n": 1}}},
+		{"ciphertext that is not base64", MessageTypeChat, map[string]interface{}{"header": map[string]interface{}{"n": 1}, "ciphertext": "!!"}},
+		{"ack without status", MessageTypeAck, map[string]interface{}{"message_id": "m1", "recipient": "bob"}},
+		{"error without code", MessageTypeError, map[string]interface{}{"error_message": "oops"}},
+		{"presence without status", MessageTypePresence, map[string]interface{}{}},
+		{"typing flag of the wrong type", MessageTypeTyping, map[string]interface{}{"typing": "yes"}},
+		{"key bundle without keys", MessageTypeKeyBundle, map[string]interface{}{"pre_key_id": 1}},
+		{"session init without signature", MessageTypeSessionInit, map[string]interface{}{"identity_key": "AQ==", "exchange_key": "AQ==", "ephemeral_key": "AQ==", "ratchet_key": "AQ=="}},
+		{"resend request without sequences", MessageTypeResendRequest, map[string]interface{}{}},
+		{"resend request for too many", MessageTypeResendRequest, map[string]interface{}{"sequences": tooMany}},
+		{"empty batch", messageTypeBatch, map[string]interface{}{"messages": []interface{}{}}},
+		{"oversized batch", messageTypeBatch, map[string]interface{}{"messages": manyMessages}},
+		{"blank announcement", MessageTypeAnnouncement, map[string]interface{}{"text": "  "}},
+		{"unknown type", "telepathy", map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{ID: "m1", Type: tt.msgType, From: "alice", Payload: tt.payload}
+			_, err := msg.ParsePayload()
+			if !errors.Is(err, ErrInvalidPayload) {
+				t.Errorf("ParsePayload: %v, want ErrInvalidPayload", err)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadNamesTheMessageType(t *testing.T) {
+	msg := &Message{Type: MessageTypeAck, Payload: map[string]interface{}{"message_id": []int{1}}}
+	err := msg.DecodePayload(&AckPayload{})
+	if err == nil || !strings.Contains(err.Error(), MessageTypeAck) {
+		t.Errorf("DecodePayload: %v, want an error naming the ack", err)
+	}
+}
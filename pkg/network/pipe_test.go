@@ -0,0 +1,78 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPipeCarriesMessagesBothWays(t *testing.T) {
+	a, b := NewPipe()
+	defer a.Close()
+
+	buf := []byte("hello")
+	if err := a.WriteMessage(buf); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	buf[0] = 'j' // The pipe keeps its own copy
+	if err := b.WriteMessage([]byte("back")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	if got, err := b.ReadMessage(); err != nil || string(got) != "hello" {
+		t.Errorf("b.ReadMessage = %q, %v, want hello", got, err)
+	}
+	if got, err := a.ReadMessage(); err != nil || string(got) != "back" {
+		t.Errorf("a.ReadMessage = %q, %v, want back", got, err)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	a, b := NewPipe()
+	if err := a.WriteMessage([]byte("last")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	a.Close()
+
+	// What was written before the close is still read, then the close
+	if got, err := b.ReadMessage(); err != nil || string(got) != "last" {
+		t.Errorf("ReadMessage = %q, %v, want last", got, err)
+	}
+	if _, err := b.ReadMessage(); !errors.Is(err, ErrPipeClosed) {
+		t.Errorf("ReadMessage after close: %v, want ErrPipeClosed", err)
+	}
+	if err := b.WriteMessage([]byte("late")); !errors.Is(err, ErrPipeClosed) {
+		t.Errorf("WriteMessage after close: %v, want ErrPipeClosed", err)
+	}
+}
+
+func TestPipeReadDeadline(t *testing.T) {
+	a, b := NewPipe()
+	defer a.Close()
+
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	start := time.Now()
+	if _, err := b.ReadMessage(); !errors.Is(err, errPipeTimeout) {
+		t.Fatalf("ReadMessage: %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("ReadMessage took %v to time out", elapsed)
+	}
+}
+
+func TestStoppedRelayRefusesMemoryConnections(t *testing.T) {
+	server, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Stop()
+
+	conn, err := (&MemoryTransport{Server: server}).Dial(context.Background(), "memory://relay")
+	if err != nil {
+		return
+	}
+	if _, err := conn.ReadMessage(); !errors.Is(err, ErrPipeClosed) {
+		t.Errorf("ReadMessage on a connection to a stopped relay: %v, want ErrPipeClosed", err)
+	}
+}
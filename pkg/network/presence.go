@@ -0,0 +1,430 @@
+package network
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// Presence message types
+const (
+	messageTypePresenceQuery       = "presence_query"
+	messageTypePresenceUnsubscribe = "presence_unsubscribe"
+	messageTypePresenceResponse    = "presence_response"
+	messageTypePresenceUpdate      = "presence_update"
+)
+
+// Presence is the relay's view of a user's status
+type Presence struct {
+	Status string
+	// LastSeen is when the user last went offline, zero if unknown or hidden
+	LastSeen time.Time
+	// LastSeenHidden is set when the user does not share their last-seen time
+	LastSeenHidden bool
+}
+
+// PresenceHandler is called with the current presence of users returned by a
+// presence query or pushed by a presence subscription
+type PresenceHandler func(presence map[string]Presence)
+
+// PresencePolicy decides whether requester may see the presence of target
+type PresencePolicy func(requester, target string) bool
+
+// SetStatus announces our own status, such as away or busy. While the
+// client is invisible the status is only recorded, and announced once it
+// becomes visible again.
+func (c *Client) SetStatus(status string) error {
+	c.connMutex.Lock()
+	c.status = status
+	invisible := c.invisible
+	c.connMutex.Unlock()
+
+	if invisible {
+		return nil
+	}
+	return c.sendStatus(status)
+}
+
+// SetInvisible makes the client appear offline to everyone, or visible
+// again with its last status. Messages are still sent and received while
+// invisible.
+func (c *Client) SetInvisible(invisible bool) error {
+	c.connMutex.Lock()
+	changed := c.invisible != invisible
+	c.invisible = invisible
+	status := c.status
+	c.connMutex.Unlock()
+
+	if !changed {
+		return nil
+	}
+	if invisible {
+		status = string(models.UserStatusOffline)
+	}
+	return c.sendStatus(status)
+}
+
+// Invisible reports whether the client appears offline
+func (c *Client) Invisible() bool {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.invisible
+}
+
+// sendStatus sends a presence message with our status. Nothing is sent
+// before the relay has answered the hello; the relay learns our visibility
+// from the hello and our status from restoreStatus.
+func (c *Client) sendStatus(status string) error {
+	if !c.IsConnected() || c.ProtocolVersion() == 0 {
+		return nil
+	}
+	return c.Send(newMessage(MessageTypePresence, c.userID, "server", PresencePayload{
+		Status: status,
+	}))
+}
+
+// restoreStatus announces a status other than online to a relay that has
+// just accepted our hello, since the relay starts every session online
+func (c *Client) restoreStatus() {
+	c.connMutex.RLock()
+	status, invisible := c.status, c.invisible
+	c.connMutex.RUnlock()
+
+	if invisible || status == string(models.UserStatusOnline) {
+		return
+	}
+	if err := c.sendStatus(status); err != nil {
+		log.Printf("Failed to restore status on %s: %v", c.serverURL, err)
+	}
+}
+
+// QueryPresence asks the relay for the current status of the given users.
+// The answer is delivered to the client's PresenceHandler.
+func (c *Client) QueryPresence(userIDs []string) error {
+	return c.sendPresenceQuery(userIDs, false)
+}
+
+// SubscribePresence adds users to the client's subscription set. The relay
+// answers with their current status and pushes subsequent changes to the
+// PresenceHandler. Users already in the set are not sent again; the whole
+// set is sent after every reconnect.
+func (c *Client) SubscribePresence(userIDs []string) error {
+	added, _ := c.updatePresenceSubs(userIDs, nil)
+	return c.sendPresenceChanges(added, nil)
+}
+
+// UnsubscribePresence stops status pushes for the given users
+func (c *Client) UnsubscribePresence(userIDs []string) error {
+	_, removed := c.updatePresenceSubs(nil, userIDs)
+	return c.sendPresenceChanges(nil, removed)
+}
+
+// SetPresenceSubscriptions replaces the subscription set, typically with
+// the contact list. Only the users added or removed since the last call are
+// sent to the relay. While disconnected the set is just recorded.
+func (c *Client) SetPresenceSubscriptions(userIDs []string) error {
+	wanted := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
+
+	c.subsMutex.Lock()
+	var removed []string
+	for id := range c.presenceSubs {
+		if !wanted[id] {
+			removed = append(removed, id)
+		}
+	}
+	c.subsMutex.Unlock()
+
+	added, removed := c.updatePresenceSubs(userIDs, removed)
+	return c.sendPresenceChanges(added, removed)
+}
+
+// PresenceSubscriptions returns the users in the subscription set
+func (c *Client) PresenceSubscriptions() []string {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+
+	userIDs := make([]string, 0, len(c.presenceSubs))
+	for id := range c.presenceSubs {
+		userIDs = append(userIDs, id)
+	}
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+// updatePresenceSubs applies additions and removals to the subscription
+// set, returning the users that actually changed
+func (c *Client) updatePresenceSubs(add, remove []string) (added, removed []string) {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+
+	for _, id := range add {
+		if !c.presenceSubs[id] {
+			c.presenceSubs[id] = true
+			added = append(added, id)
+		}
+	}
+	for _, id := range remove {
+		if c.presenceSubs[id] {
+			delete(c.presenceSubs, id)
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// sendPresenceChanges tells the relay about changes to the subscription
+// set. Nothing is sent before the relay has answered the hello, since the
+// whole set is sent then.
+func (c *Client) sendPresenceChanges(added, removed []string) error {
+	if !c.IsConnected() || c.ProtocolVersion() == 0 {
+		return nil
+	}
+
+	if len(added) > 0 {
+		if err := c.sendPresenceQuery(added, true); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		return c.Send(newMessage(messageTypePresenceUnsubscribe, c.userID, "server", PresenceQueryPayload{
+			UserIDs: removed,
+		}))
+	}
+	return nil
+}
+
+// resubscribePresence sends the whole subscription set to a relay that has
+// just accepted our hello
+func (c *Client) resubscribePresence() {
+	userIDs := c.PresenceSubscriptions()
+	if len(userIDs) == 0 || !supportsMessage(c.ProtocolVersion(), messageTypePresenceQuery) {
+		return
+	}
+
+	if err := c.sendPresenceQuery(userIDs, true); err != nil {
+		log.Printf("Failed to subscribe to presence on %s: %v", c.serverURL, err)
+	}
+}
+
+// sendPresenceQuery sends a presence_query message
+func (c *Client) sendPresenceQuery(userIDs []string, subscribe bool) error {
+	return c.Send(newMessage(messageTypePresenceQuery, c.userID, "server", PresenceQueryPayload{
+		UserIDs:   userIDs,
+		Subscribe: subscribe,
+	}))
+}
+
+// handlePresenceMessage passes presence responses and updates to the handler
+func (c *Client) handlePresenceMessage(msg *Message) {
+	if c.presenceHandler == nil {
+		return
+	}
+
+	var report PresenceReportPayload
+	if err := msg.DecodePayload(&report); err != nil {
+		log.Printf("Dropping %s: %v", msg.Type, err)
+		return
+	}
+
+	presence := make(map[string]Presence, len(report.Statuses))
+	for userID, status := range report.Statuses {
+		p := Presence{
+			Status:         status,
+			LastSeenHidden: report.LastSeenHidden[userID],
+		}
+		if seen := report.LastSeen[userID]; seen > 0 {
+			p.LastSeen = time.Unix(seen, 0)
+		}
+		presence[userID] = p
+	}
+
+	c.presenceHandler(presence)
+}
+
+// handlePresenceQuery answers a presence query and optionally subscribes the
+// client to later changes
+func (c *ServerClient) handlePresenceQuery(msg *Message) {
+	if c.UserID == "" {
+		c.sendError("NOT_IDENTIFIED", "client_hello required before querying presence", msg.ID)
+		return
+	}
+
+	var query PresenceQueryPayload
+	if err := msg.DecodePayload(&query); err != nil {
+		c.sendError("INVALID_PAYLOAD", err.Error(), msg.ID)
+		return
+	}
+
+	userIDs := make([]string, 0, len(query.UserIDs))
+	for _, id := range query.UserIDs {
+		userID, err := models.ParseUserID(id)
+		if err != nil || !c.Server.presenceAllowed(c.UserID, userID) {
+			continue
+		}
+
+		userIDs = append(userIDs, userID)
+		if query.Subscribe {
+			c.Server.subscribePresence(userID, c)
+		}
+	}
+
+	c.sendPresence(messageTypePresenceResponse, userIDs)
+}
+
+// handlePresenceUnsubscribe drops some of the client's presence subscriptions
+func (c *ServerClient) handlePresenceUnsubscribe(msg *Message) {
+	if c.UserID == "" {
+		c.sendError("NOT_IDENTIFIED", "client_hello required before managing presence subscriptions", msg.ID)
+		return
+	}
+
+	var query PresenceQueryPayload
+	if err := msg.DecodePayload(&query); err != nil {
+		c.sendError("INVALID_PAYLOAD", err.Error(), msg.ID)
+		return
+	}
+
+	for _, id := range query.UserIDs {
+		if userID, err := models.ParseUserID(id); err == nil {
+			c.Server.unsubscribePresenceOf(userID, c)
+		}
+	}
+}
+
+// sendPresence sends a presence response or update about the given users to
+// the client. Last-seen times are only included for offline users who share them.
+func (c *ServerClient) sendPresence(msgType string, userIDs []string) {
+	report := PresenceReportPayload{
+		Statuses:       make(map[string]string, len(userIDs)),
+		LastSeen:       make(map[string]int64),
+		LastSeenHidden: make(map[string]bool),
+	}
+
+	for _, userID := range userIDs {
+		status := c.Server.presenceStatus(userID)
+		report.Statuses[userID] = status
+
+		seen, hide := c.Server.lastSeenOf(userID)
+		switch {
+		case hide:
+			report.LastSeenHidden[userID] = true
+		case status == string(models.UserStatusOffline) && !seen.IsZero():
+			report.LastSeen[userID] = seen.Unix()
+		}
+	}
+
+	response := newMessage(msgType, "server", c.UserID, report)
+
+	select {
+	case c.Send <- response:
+	default:
+		log.Printf("Failed to send %s to client %s", msgType, c.ID)
+	}
+}
+
+// presenceAllowed applies the presence policy
+func (s *Server) presenceAllowed(requester, target string) bool {
+	if s.presencePolicy == nil {
+		return true
+	}
+	return s.presencePolicy(requester, target)
+}
+
+// presenceStatus returns the current status of a user based on connected sessions
+func (s *Server) presenceStatus(userID string) string {
+	client := s.findClientByUserID(userID)
+	if client == nil {
+		return string(models.UserStatusOffline)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.status
+}
+
+// lastSeenOf returns when a user last went offline and whether they hide it
+func (s *Server) lastSeenOf(userID string) (time.Time, bool) {
+	s.presenceMux.Lock()
+	defer s.presenceMux.Unlock()
+
+	if s.hideLastSeen[userID] {
+		return time.Time{}, true
+	}
+	return s.lastSeen[userID], false
+}
+
+// setHideLastSeen records a user's last-seen privacy preference
+func (s *Server) setHideLastSeen(userID string, hide bool) {
+	s.presenceMux.Lock()
+	defer s.presenceMux.Unlock()
+
+	if hide {
+		s.hideLastSeen[userID] = true
+	} else {
+		delete(s.hideLastSeen, userID)
+	}
+}
+
+// markLastSeen records that a user's last session has disconnected
+func (s *Server) markLastSeen(userID string) {
+	s.presenceMux.Lock()
+	s.lastSeen[userID] = time.Now()
+	s.presenceMux.Unlock()
+}
+
+// subscribePresence registers subscriber for changes to userID's presence
+func (s *Server) subscribePresence(userID string, subscriber *ServerClient) {
+	s.presenceMux.Lock()
+	defer s.presenceMux.Unlock()
+
+	if s.presenceSubs[userID] == nil {
+		s.presenceSubs[userID] = make(map[*ServerClient]bool)
+	}
+	s.presenceSubs[userID][subscriber] = true
+}
+
+// unsubscribePresenceOf drops subscriber's subscription to userID's presence
+func (s *Server) unsubscribePresenceOf(userID string, subscriber *ServerClient) {
+	s.presenceMux.Lock()
+	defer s.presenceMux.Unlock()
+
+	delete(s.presenceSubs[userID], subscriber)
+	if len(s.presenceSubs[userID]) == 0 {
+		delete(s.presenceSubs, userID)
+	}
+}
+
+// unsubscribePresence drops all subscriptions held by a disconnecting client
+func (s *Server) unsubscribePresence(subscriber *ServerClient) {
+	s.presenceMux.Lock()
+	defer s.presenceMux.Unlock()
+
+	for userID, subscribers := range s.presenceSubs {
+		delete(subscribers, subscriber)
+		if len(subscribers) == 0 {
+			delete(s.presenceSubs, userID)
+		}
+	}
+}
+
+// notifyPresence pushes a user's new status to subscribers allowed to see it
+func (s *Server) notifyPresence(userID string) {
+	s.presenceMux.Lock()
+	subscribers := make([]*ServerClient, 0, len(s.presenceSubs[userID]))
+	for subscriber := range s.presenceSubs[userID] {
+		subscribers = append(subscribers, subscriber)
+	}
+	s.presenceMux.Unlock()
+
+	for _, subscriber := range subscribers {
+		if !s.presenceAllowed(subscriber.user(), userID) {
+			continue
+		}
+		subscriber.sendPresence(messageTypePresenceUpdate, []string{userID})
+	}
+}
@@ -0,0 +1,299 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// expectPresence reads until a presence message of msgType arrives and
+// returns its report
+func (r *rawSession) expectPresence(msgType string) PresenceReportPayload {
+	r.t.Helper()
+
+	var report PresenceReportPayload
+	if err := r.expect(msgType).DecodePayload(&report); err != nil {
+		r.t.Fatalf("DecodePayload: %v", err)
+	}
+	return report
+}
+
+// queryPresence sends a presence query for userIDs
+func (r *rawSession) queryPresence(from string, subscribe bool, userIDs ...string) {
+	r.send(newMessage(messageTypePresenceQuery, from, "server", PresenceQueryPayload{
+		UserIDs:   userIDs,
+		Subscribe: subscribe,
+	}))
+}
+
+func TestPresenceQuery(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	connectAs(t, server, "alice", "laptop", nil)
+	bob := connectAs(t, server, "bob", "phone", nil)
+
+	bob.queryPresence("bob", false, "alice", "Carol", "not valid")
+	report := bob.expectPresence(messageTypePresenceResponse)
+
+	want := map[string]string{"alice": "online", "carol": "offline"}
+	if len(report.Statuses) != len(want) {
+		t.Fatalf("statuses = %v, want %v", report.Statuses, want)
+	}
+	for userID, status := range want {
+		if report.Statuses[userID] != status {
+			t.Errorf("status of %s = %q, want %q", userID, report.Statuses[userID], status)
+		}
+	}
+}
+
+func TestPresenceSubscription(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	bob := connectAs(t, server, "bob", "phone", nil)
+	bob.queryPresence("bob", true, "carol")
+	if got := bob.expectPresence(messageTypePresenceResponse).Statuses["carol"]; got != "offline" {
+		t.Fatalf("carol's status = %q, want offline", got)
+	}
+
+	carol := connectAs(t, server, "carol", "tablet", nil)
+	if got := bob.expectPresence(messageTypePresenceUpdate).Statuses["carol"]; got != "online" {
+		t.Errorf("after connecting, carol's status = %q, want online", got)
+	}
+
+	carol.send(newMessage(MessageTypePresence, "carol", "server", PresencePayload{Status: "away"}))
+	if got := bob.expectPresence(messageTypePresenceUpdate).Statuses["carol"]; got != "away" {
+		t.Errorf("after going away, carol's status = %q, want away", got)
+	}
+
+	carol.conn.Close()
+	report := bob.expectPresence(messageTypePresenceUpdate)
+	if got := report.Statuses["carol"]; got != "offline" {
+		t.Errorf("after disconnecting, carol's status = %q, want offline", got)
+	}
+	if seen := time.Unix(report.LastSeen["carol"], 0); time.Since(seen) > time.Minute {
+		t.Errorf("carol's last seen = %v, want just now", seen)
+	}
+}
+
+func TestPresenceUnsubscribe(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	bob := connectAs(t, server, "bob", "phone", nil)
+	bob.queryPresence("bob", true, "carol")
+	bob.expectPresence(messageTypePresenceResponse)
+	bob.send(newMessage(messageTypePresenceUnsubscribe, "bob", "server", PresenceQueryPayload{UserIDs: []string{"carol"}}))
+
+	// The query is answered after the unsubscribe is handled, and carol
+	// connecting after that is not pushed
+	bob.queryPresence("bob", false, "dave")
+	bob.expectPresence(messageTypePresenceResponse)
+	connectAs(t, server, "carol", "tablet", nil)
+	bob.queryPresence("bob", false, "carol")
+	for {
+		msg := bob.next()
+		if msg.Type == messageTypePresenceUpdate {
+			t.Fatal("presence update pushed after unsubscribing")
+		}
+		if msg.Type == messageTypePresenceResponse {
+			return
+		}
+	}
+}
+
+func TestPresencePolicy(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{
+		PresencePolicy: func(requester, target string) bool { return target != "alice" },
+	})
+	connectAs(t, server, "alice", "laptop", nil)
+	bob := connectAs(t, server, "bob", "phone", nil)
+
+	bob.queryPresence("bob", false, "alice", "carol")
+	report := bob.expectPresence(messageTypePresenceResponse)
+	if _, listed := report.Statuses["alice"]; listed {
+		t.Errorf("the policy did not hide alice: %v", report.Statuses)
+	}
+	if report.Statuses["carol"] != "offline" {
+		t.Errorf("statuses = %v, want carol offline", report.Statuses)
+	}
+}
+
+func TestClientPresenceHandler(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	connectAs(t, server, "alice", "laptop", nil)
+
+	reports := make(chan map[string]Presence, 4)
+	client := NewClient(ClientOptions{
+		ServerURL:       "memory://relay",
+		UserID:          "bob",
+		Transport:       &MemoryTransport{Server: server},
+		PresenceHandler: func(presence map[string]Presence) { reports <- presence },
+	})
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, "the server hello", func() bool { return client.ProtocolVersion() != 0 })
+
+	if err := client.QueryPresence([]string{"alice"}); err != nil {
+		t.Fatalf("QueryPresence: %v", err)
+	}
+	select {
+	case presence := <-reports:
+		if presence["alice"].Status != "online" {
+			t.Errorf("alice's presence = %+v, want online", presence["alice"])
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no presence reported")
+	}
+}
+
+func TestHiddenLastSeen(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	bob := connectAs(t, server, "bob", "phone", nil)
+	bob.queryPresence("bob", true, "carol")
+	bob.expectPresence(messageTypePresenceResponse)
+
+	carol := dialRelay(t, server)
+	carol.send(newMessage(MessageTypeClientHello, "carol", "", ClientHelloPayload{
+		MinVersion:   SupportedVersions.Min,
+		MaxVersion:   SupportedVersions.Max,
+		Nonce:        newHelloNonce(),
+		DeviceID:     "tablet",
+		HideLastSeen: true,
+	}))
+	carol.expect(MessageTypeServerHello)
+	bob.expectPresence(messageTypePresenceUpdate)
+
+	carol.conn.Close()
+	report := bob.expectPresence(messageTypePresenceUpdate)
+	if got := report.Statuses["carol"]; got != "offline" {
+		t.Errorf("after disconnecting, carol's status = %q, want offline", got)
+	}
+	if !report.LastSeenHidden["carol"] {
+		t.Error("carol's last seen is not marked hidden")
+	}
+	if seen, shared := report.LastSeen["carol"]; shared {
+		t.Errorf("carol's hidden last seen was shared: %d", seen)
+	}
+}
+
+func TestClientSendsOnlySubscriptionChanges(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	reports := make(chan map[string]Presence, 8)
+	client := NewClient(ClientOptions{
+		ServerURL:       "memory://relay",
+		UserID:          "bob",
+		Transport:       &MemoryTransport{Server: server},
+		PresenceHandler: func(presence map[string]Presence) { reports <- presence },
+	})
+	defer client.Close()
+
+	// Subscriptions made while disconnected are sent after the hello
+	client.SetPresenceSubscriptions([]string{"alice"})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	nextReport := func() map[string]Presence {
+		t.Helper()
+		select {
+		case presence := <-reports:
+			return presence
+		case <-time.After(testTimeout):
+			t.Fatal("no presence reported")
+			return nil
+		}
+	}
+	if presence := nextReport(); len(presence) != 1 || presence["alice"].Status != "offline" {
+		t.Fatalf("first report = %v, want alice offline", presence)
+	}
+
+	// Only the added contact is queried
+	if err := client.SetPresenceSubscriptions([]string{"alice", "carol"}); err != nil {
+		t.Fatalf("SetPresenceSubscriptions: %v", err)
+	}
+	if presence := nextReport(); len(presence) != 1 || presence["carol"].Status != "offline" {
+		t.Fatalf("report after adding carol = %v, want only carol", presence)
+	}
+
+	// Dropping alice stops her updates but not carol's
+	if err := client.SetPresenceSubscriptions([]string{"carol"}); err != nil {
+		t.Fatalf("SetPresenceSubscriptions: %v", err)
+	}
+	client.QueryPresence([]string{"dave"})
+	nextReport()
+	connectAs(t, server, "alice", "laptop", nil)
+	connectAs(t, server, "carol", "tablet", nil)
+	presence := nextReport()
+	if _, pushed := presence["alice"]; pushed {
+		t.Errorf("alice's presence pushed after unsubscribing: %v", presence)
+	}
+	if presence["carol"].Status != "online" {
+		t.Errorf("report = %v, want carol online", presence)
+	}
+}
+
+func TestInvisibleClientAppearsOffline(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	bob := connectAs(t, server, "bob", "phone", nil)
+	bob.queryPresence("bob", true, "alice")
+	bob.expectPresence(messageTypePresenceResponse)
+
+	received := make(chan *Message, 8)
+	alice := NewClient(ClientOptions{
+		ServerURL: "memory://relay",
+		UserID:    "alice",
+		Transport: &MemoryTransport{Server: server},
+		Invisible: true,
+		MessageHandler: func(msg *Message) error {
+			received <- msg
+			return nil
+		},
+	})
+	defer alice.Close()
+	if err := alice.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, "the server hello", func() bool { return alice.ProtocolVersion() != 0 })
+	if err := alice.SetStatus("away"); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+
+	// Neither connecting nor the status change is announced, and queries
+	// answer offline
+	bob.queryPresence("bob", false, "alice")
+	for {
+		msg := bob.next()
+		if msg.Type == messageTypePresenceUpdate {
+			t.Fatalf("presence of an invisible client pushed: %v", msg.Payload)
+		}
+		if msg.Type != messageTypePresenceResponse {
+			continue
+		}
+		var report PresenceReportPayload
+		if err := msg.DecodePayload(&report); err != nil {
+			t.Fatalf("DecodePayload: %v", err)
+		}
+		if got := report.Statuses["alice"]; got != "offline" {
+			t.Errorf("alice's status = %q while invisible, want offline", got)
+		}
+		break
+	}
+
+	// Messages still flow both ways
+	bob.send(newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "there?"}))
+	receive(t, received, MessageTypeChat)
+	if err := alice.Send(newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "yes"})); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	bob.expect(MessageTypeChat)
+
+	// Becoming visible announces the status set while invisible
+	if err := alice.SetInvisible(false); err != nil {
+		t.Fatalf("SetInvisible: %v", err)
+	}
+	if got := bob.expectPresence(messageTypePresenceUpdate).Statuses["alice"]; got != "away" {
+		t.Errorf("alice's status after becoming visible = %q, want away", got)
+	}
+	if err := alice.SetInvisible(true); err != nil {
+		t.Fatalf("SetInvisible: %v", err)
+	}
+	if got := bob.expectPresence(messageTypePresenceUpdate).Statuses["alice"]; got != "offline" {
+		t.Errorf("alice's status after going invisible = %q, want offline", got)
+	}
+}
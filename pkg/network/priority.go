@@ -0,0 +1,127 @@
+package network
+
+// Priority orders the messages waiting to be routed by the relay. It is
+// derived from the message type, so clients cannot raise their own.
+type Priority int
+
+// Priorities, most urgent first
+const (
+	// Acks, key exchanges and resend requests, small and needed before
+	// anything else moves
+	PriorityControl Priority = iota
+	// Chat messages and file transfer offers
+	PriorityChat
+	// File chunks, which come in floods
+	PriorityBulk
+
+	priorityLevels = iota
+)
+
+// routerBurst is how many messages of higher priority are routed in a row
+// while a lower priority waits; then the lower one gets a turn
+const routerBurst = 8
+
+// routeQueueSize is how many messages of each priority may wait to be
+// routed before more are dropped
+const routeQueueSize = 1000
+
+// String returns the priority's name
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "control"
+	case PriorityChat:
+		return "chat"
+	case PriorityBulk:
+		return "bulk"
+	default:
+		return "unknown"
+	}
+}
+
+// messagePriority returns the priority messages of a type are routed with.
+// A file transfer completes after its chunks, so it shares their priority.
+func messagePriority(msgType string) Priority {
+	switch msgType {
+	case MessageTypeAck, MessageTypeKeyRequest, MessageTypeKeyBundle, MessageTypeSessionInit, MessageTypeResendRequest:
+		return PriorityControl
+	case "file_chunk", "file_complete":
+		return PriorityBulk
+	default:
+		return PriorityChat
+	}
+}
+
+// routeQueues holds the messages waiting for the router, one queue per
+// priority. Messages of the same priority are routed in the order they
+// were queued; a higher priority may overtake a lower one.
+type routeQueues struct {
+	queues [priorityLevels]chan *RoutedMessage
+
+	// Turns each priority was passed over while it had messages waiting,
+	// only touched by the router
+	skipped [priorityLevels]int
+}
+
+// newRouteQueues creates empty route queues
+func newRouteQueues() *routeQueues {
+	q := &routeQueues{}
+	for i := range q.queues {
+		q.queues[i] = make(chan *RoutedMessage, routeQueueSize)
+	}
+	return q
+}
+
+// push queues a message by its priority, or returns false if that queue
+// is full
+func (q *routeQueues) push(routedMsg *RoutedMessage) bool {
+	select {
+	case q.queues[routedMsg.Priority] <- routedMsg:
+		return true
+	default:
+		return false
+	}
+}
+
+// next takes the message to route next without blocking, or returns nil
+// if none is waiting. The highest priority waiting goes first, unless a
+// lower one has been passed over routerBurst times, so a flood of urgent
+// messages slows others down without starving them.
+func (q *routeQueues) next() *RoutedMessage {
+	chosen := -1
+	for p := range q.queues {
+		if len(q.queues[p]) == 0 {
+			continue
+		}
+		if chosen < 0 || q.skipped[p] >= routerBurst && q.skipped[chosen] < routerBurst {
+			chosen = p
+		}
+	}
+	if chosen < 0 {
+		return nil
+	}
+
+	for p := range q.queues {
+		if p != chosen && len(q.queues[p]) > 0 {
+			q.skipped[p]++
+		}
+	}
+	q.skipped[chosen] = 0
+	// Only the router receives, so the message it saw waiting is still there
+	return <-q.queues[chosen]
+}
+
+// wait blocks until a message is queued, and returns it, or nil once done
+// is closed. It is called when next found nothing waiting.
+func (q *routeQueues) wait(done <-chan struct{}) *RoutedMessage {
+	select {
+	case <-done:
+		return nil
+	case routedMsg := <-q.queues[PriorityControl]:
+		return routedMsg
+	case routedMsg := <-q.queues[PriorityChat]:
+		return routedMsg
+	case routedMsg := <-q.queues[PriorityBulk]:
+		return routedMsg
+	}
+}
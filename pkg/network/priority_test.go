@@ -0,0 +1,134 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+)
+
+// queued returns a routed message of msgType with its priority, as the
+// relay queues it
+func queued(id, msgType string) *RoutedMessage {
+	return &RoutedMessage{
+		From:     "alice",
+		To:       "bob",
+		Message:  &Message{ID: id, Type: msgType},
+		Priority: messagePriority(msgType),
+	}
+}
+
+// drain takes every waiting message off q in routing order
+func drain(q *routeQueues) []*RoutedMessage {
+	var routed []*RoutedMessage
+	for routedMsg := q.next(); routedMsg != nil; routedMsg = q.next() {
+		routed = append(routed, routedMsg)
+	}
+	return routed
+}
+
+func TestControlMessagesOvertakeChatBacklog(t *testing.T) {
+	q := newRouteQueues()
+	for i := 0; i < 100; i++ {
+		if !q.push(queued(fmt.Sprintf("chat-%d", i), MessageTypeChat)) {
+			t.Fatalf("chat %d was not queued", i)
+		}
+	}
+	control := []string{MessageTypeAck, MessageTypeKeyRequest, MessageTypeKeyBundle, MessageTypeSessionInit, MessageTypeResendRequest}
+	for _, msgType := range control {
+		q.push(queued(msgType, msgType))
+	}
+
+	routed := drain(q)
+	if len(routed) != 100+len(control) {
+		t.Fatalf("routed %d messages, want %d", len(routed), 100+len(control))
+	}
+	for i, msgType := range control {
+		if got := routed[i].Message.ID; got != msgType {
+			t.Errorf("message %d routed is %s, want the %s ahead of the chat backlog", i, got, msgType)
+		}
+	}
+	// The backlog keeps its order behind them
+	for i, routedMsg := range routed[len(control):] {
+		if want := fmt.Sprintf("chat-%d", i); routedMsg.Message.ID != want {
+			t.Fatalf("chat message %d routed is %s, want %s", i, routedMsg.Message.ID, want)
+		}
+	}
+}
+
+func TestRouterBurstPreventsStarvation(t *testing.T) {
+	q := newRouteQueues()
+	for i := 0; i < 200; i++ {
+		q.push(queued(fmt.Sprintf("ack-%d", i), MessageTypeAck))
+	}
+	for i := 0; i < 10; i++ {
+		q.push(queued(fmt.Sprintf("chat-%d", i), MessageTypeChat))
+		q.push(queued(fmt.Sprintf("chunk-%d", i), "file_chunk"))
+	}
+
+	routed := drain(q)
+	if len(routed) != 220 {
+		t.Fatalf("routed %d messages, want 220", len(routed))
+	}
+
+	// While control messages keep coming, the lower priorities still get a
+	// turn every routerBurst messages or so, in their own order
+	next := map[Priority]int{}
+	waited := map[Priority]int{}
+	for i, routedMsg := range routed {
+		for _, p := range []Priority{PriorityChat, PriorityBulk} {
+			if p == routedMsg.Priority || next[p] == 10 {
+				continue
+			}
+			if waited[p]++; waited[p] > 2*routerBurst {
+				t.Fatalf("%s messages waited %d turns at message %d, want at most %d", p, waited[p], i, 2*routerBurst)
+			}
+		}
+		if routedMsg.Priority == PriorityControl {
+			continue
+		}
+		prefix := map[Priority]string{PriorityChat: "chat", PriorityBulk: "chunk"}[routedMsg.Priority]
+		if want := fmt.Sprintf("%s-%d", prefix, next[routedMsg.Priority]); routedMsg.Message.ID != want {
+			t.Fatalf("routed %s, want %s", routedMsg.Message.ID, want)
+		}
+		next[routedMsg.Priority]++
+		waited[routedMsg.Priority] = 0
+	}
+	if last := routed[len(routed)-1]; last.Priority != PriorityControl {
+		t.Errorf("last message routed is %s, want the control flood to finish last", last.Message.ID)
+	}
+}
+
+func TestRouteQueueIsCappedPerPriority(t *testing.T) {
+	q := newRouteQueues()
+	for i := 0; i < routeQueueSize; i++ {
+		if !q.push(queued(fmt.Sprintf("chat-%d", i), MessageTypeChat)) {
+			t.Fatalf("chat %d was dropped below the %d message cap", i, routeQueueSize)
+		}
+	}
+	if q.push(queued("one-too-many", MessageTypeChat)) {
+		t.Fatal("a chat message beyond the cap was queued")
+	}
+
+	// A full chat queue does not hold up control messages
+	if !q.push(queued("ack", MessageTypeAck)) {
+		t.Fatal("an ack was dropped because the chat queue is full")
+	}
+	if first := q.next(); first.Message.ID != "ack" {
+		t.Errorf("first routed is %s, want the ack", first.Message.ID)
+	}
+
+	// Routing one makes room for one more
+	if first := q.next(); first.Message.ID != "chat-0" {
+		t.Errorf("next routed is %s, want chat-0", first.Message.ID)
+	}
+	if !q.push(queued("late", MessageTypeChat)) {
+		t.Fatal("no room after a message was routed")
+	}
+	if q.push(queued("later", MessageTypeChat)) {
+		t.Fatal("a message beyond the cap was queued after room for one was made")
+	}
+
+	routed := drain(q)
+	if len(routed) != routeQueueSize || routed[len(routed)-1].Message.ID != "late" {
+		t.Errorf("routed %d more ending with %s, want %d ending with late", len(routed), routed[len(routed)-1].Message.ID, routeQueueSize)
+	}
+}
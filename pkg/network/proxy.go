@@ -0,0 +1,76 @@
+package network
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyDirect as a proxy setting connects to relays without a proxy, even
+// if the environment names one
+const ProxyDirect = "direct"
+
+// ProxyFunc returns the proxy to reach a relay through, or nil to connect
+// directly. The request URL has an http or https scheme for ws and wss
+// relays.
+type ProxyFunc func(req *http.Request) (*url.URL, error)
+
+// ParseProxy converts a proxy setting to a ProxyFunc. An empty setting uses
+// the proxy environment variables, ProxyDirect disables proxying, and
+// anything else must be an http:// URL of an HTTP CONNECT proxy or a
+// socks5:// URL.
+func ParseProxy(setting string) (ProxyFunc, error) {
+	switch setting {
+	case "":
+		return ProxyFromEnvironment, nil
+	case ProxyDirect:
+		return noProxy, nil
+	}
+
+	u, err := url.Parse(setting)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", setting)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// ProxyFromEnvironment picks the proxy for a relay from $HTTPS_PROXY for wss
+// relays and $HTTP_PROXY for ws ones, falling back to $ALL_PROXY, which is
+// how SOCKS proxies are usually given. Relays matched by $NO_PROXY and those
+// on the loopback interface are reached directly.
+func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+	if all := getEnvAny("ALL_PROXY", "all_proxy"); all != "" {
+		if cfg.HTTPSProxy == "" {
+			cfg.HTTPSProxy = all
+		}
+		if cfg.HTTPProxy == "" {
+			cfg.HTTPProxy = all
+		}
+	}
+	return cfg.ProxyFunc()(req.URL)
+}
+
+// noProxy connects directly
+func noProxy(*http.Request) (*url.URL, error) {
+	return nil, nil
+}
+
+// getEnvAny returns the first of the named environment variables that is set
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseProxy(t *testing.T) {
+	relay := &http.Request{URL: &url.URL{Scheme: "https", Host: "relay.example.com"}}
+	tests := []struct {
+		setting string
+		want    string // Proxy picked for relay, "" for none
+		wantErr bool
+	}{
+		{"direct", "", false},
+		{"http://proxy.local:3128", "http://proxy.local:3128", false},
+		{"socks5://127.0.0.1:1080", "socks5://127.0.0.1:1080", false},
+		{"https://proxy.local:3128", "", true},
+		{"socks5://", "", true},
+		{"::not a url", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.setting, func(t *testing.T) {
+			t.Setenv("HTTPS_PROXY", "http://from-env:8080")
+			proxy, err := ParseProxy(tt.setting)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProxy: %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			u, err := proxy(relay)
+			if err != nil {
+				t.Fatalf("proxy: %v", err)
+			}
+			if got := urlString(u); got != tt.want {
+				t.Errorf("proxy for the relay = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// urlString returns u as a string, or "" if it is nil
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		relay string
+		want  string
+	}{
+		{"https proxy for wss", map[string]string{"HTTPS_PROXY": "http://secure:1"}, "https://relay.example.com", "http://secure:1"},
+		{"http proxy for ws", map[string]string{"HTTP_PROXY": "http://plain:1", "HTTPS_PROXY": "http://secure:1"}, "http://relay.example.com", "http://plain:1"},
+		{"all proxy as fallback", map[string]string{"ALL_PROXY": "socks5://socks:1080"}, "https://relay.example.com", "socks5://socks:1080"},
+		{"https proxy over all proxy", map[string]string{"HTTPS_PROXY": "http://secure:1", "ALL_PROXY": "socks5://socks:1080"}, "https://relay.example.com", "http://secure:1"},
+		{"excluded by no proxy", map[string]string{"ALL_PROXY": "socks5://socks:1080", "NO_PROXY": ".example.com"}, "https://relay.example.com", ""},
+		{"loopback relay", map[string]string{"ALL_PROXY": "socks5://socks:1080"}, "https://127.0.0.1:8443", ""},
+		{"nothing set", nil, "https://relay.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy", "REQUEST_METHOD"} {
+				t.Setenv(name, tt.env[name])
+			}
+			relay, _ := url.Parse(tt.relay)
+			u, err := ProxyFromEnvironment(&http.Request{URL: relay})
+			if err != nil {
+				t.Fatalf("ProxyFromEnvironment: %v", err)
+			}
+			if got := urlString(u); got != tt.want {
+				t.Errorf("proxy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// proxyStub is a proxy that tunnels to whatever its clients ask for,
+// recording the targets
+type proxyStub struct {
+	mu      sync.Mutex
+	targets []string
+}
+
+// startProxy listens for proxy clients, reading each one's request with
+// handshake, which answers it and returns the address to tunnel to
+func startProxy(t *testing.T, handshake func(rw *bufio.ReadWriter) (string, error)) (*proxyStub, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	stub := &proxyStub{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.tunnel(conn, handshake)
+		}
+	}()
+	return stub, listener.Addr().String()
+}
+
+// tunnel relays a client connection to the target it asks for
+func (p *proxyStub) tunnel(conn net.Conn, handshake func(rw *bufio.ReadWriter) (string, error)) {
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	target, err := handshake(rw)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	go io.Copy(upstream, rw)
+	io.Copy(conn, upstream)
+}
+
+// tunnelled returns the targets clients asked for
+func (p *proxyStub) tunnelled() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+// httpConnect answers an HTTP CONNECT request
+func httpConnect(rw *bufio.ReadWriter) (string, error) {
+	req, err := http.ReadRequest(rw.Reader)
+	if err != nil {
+		return "", err
+	}
+	if req.Method != http.MethodConnect {
+		fmt.Fprint(rw, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		rw.Flush()
+		return "", errors.New("not a CONNECT request")
+	}
+	fmt.Fprint(rw, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return req.Host, nil
+}
+
+// socks5Connect answers a SOCKS5 connect request without authentication
+func socks5Connect(rw *bufio.ReadWriter) (string, error) {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(rw, greeting); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(rw, make([]byte, greeting[1])); err != nil {
+		return "", err
+	}
+	rw.Write([]byte{5, 0})
+	rw.Flush()
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(rw, request); err != nil {
+		return "", err
+	}
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make(net.IP, map[byte]int{1: 4, 4: 16}[request[3]])
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 3:
+		length, err := rw.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(rw, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return "", err
+	}
+
+	rw.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port))), nil
+}
+
+func TestClientConnectsThroughProxy(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{IdentityPath: filepath.Join(t.TempDir(), "identity.pem")})
+	plain := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	t.Cleanup(plain.Close)
+	secure := httptest.NewTLSServer(http.HandlerFunc(server.handleWebSocket))
+	t.Cleanup(secure.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(secure.Certificate())
+	trusted := &tls.Config{RootCAs: roots}
+
+	tests := []struct {
+		name      string
+		scheme    string
+		handshake func(rw *bufio.ReadWriter) (string, error)
+		relay     *httptest.Server
+		tlsConfig *tls.Config
+		pin       string
+	}{
+		{"http connect", "http", httpConnect, plain, nil, ""},
+		{"socks5", "socks5", socks5Connect, plain, nil, ""},
+		{"http connect to a pinned TLS relay", "http", httpConnect, secure, trusted, server.Fingerprint()},
+		{"socks5 to a pinned TLS relay", "socks5", socks5Connect, secure, trusted, server.Fingerprint()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, addr := startProxy(t, tt.handshake)
+			proxy, err := ParseProxy(tt.scheme + "://" + addr)
+			if err != nil {
+				t.Fatalf("ParseProxy: %v", err)
+			}
+
+			serverURL := strings.Replace(tt.relay.URL, "http", "ws", 1)
+			client := NewClient(ClientOptions{
+				ServerURL:        serverURL,
+				UserID:           "alice",
+				Transport:        &WebSocketTransport{Proxy: proxy, TLSConfig: tt.tlsConfig},
+				RelayFingerprint: tt.pin,
+			})
+			t.Cleanup(func() { client.Close() })
+			if err := client.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			waitFor(t, "the server hello", func() bool { return client.ProtocolVersion() != 0 })
+
+			if got := stub.tunnelled(); len(got) != 1 || got[0] != tt.relay.Listener.Addr().String() {
+				t.Errorf("proxy tunnelled to %v, want only %s", got, tt.relay.Listener.Addr())
+			}
+		})
+	}
+
+	// TLS still checks the relay's certificate inside the tunnel
+	t.Run("untrusted certificate", func(t *testing.T) {
+		stub, addr := startProxy(t, httpConnect)
+		proxy, _ := ParseProxy("http://" + addr)
+		transport := &WebSocketTransport{Proxy: proxy}
+		if _, err := transport.Dial(context.Background(), strings.Replace(secure.URL, "https", "wss", 1)); err == nil {
+			t.Error("dialed a relay with an untrusted certificate through the proxy")
+		}
+		if len(stub.tunnelled()) != 1 {
+			t.Error("the dial did not go through the proxy")
+		}
+	})
+}
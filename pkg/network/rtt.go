@@ -0,0 +1,156 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// QualityLevel is a coarse grade of a connection's round-trip time
+type QualityLevel string
+
+const (
+	// QualityUnknown means no ping has been answered yet
+	QualityUnknown QualityLevel = "unknown"
+	QualityGood    QualityLevel = "good"
+	QualityFair    QualityLevel = "fair"
+	QualityPoor    QualityLevel = "poor"
+)
+
+// Round trips below these are good and fair respectively; slower ones are
+// poor
+const (
+	goodRTT = 150 * time.Millisecond
+	fairRTT = 500 * time.Millisecond
+)
+
+// rttSmoothing is the weight of a new round trip in the smoothed RTT
+const rttSmoothing = 0.25
+
+// sustainedPoorPings is how many answered pings in a row must leave the
+// smoothed RTT poor before the connection is reported as degraded
+const sustainedPoorPings = 3
+
+// ConnectionQuality summarizes the round trips measured on a connection
+type ConnectionQuality struct {
+	// RTT is the smoothed round-trip time, 0 before the first pong
+	RTT   time.Duration
+	Level QualityLevel
+
+	// Degraded is set once the RTT has stayed poor for several pings, as
+	// opposed to a single slow one
+	Degraded bool
+}
+
+// levelOf grades a round-trip time
+func levelOf(rtt time.Duration) QualityLevel {
+	switch {
+	case rtt < goodRTT:
+		return QualityGood
+	case rtt < fairRTT:
+		return QualityFair
+	}
+	return QualityPoor
+}
+
+// PongNotifier is implemented by connections that report when the peer
+// answers a ping, which lets the client measure round trips
+type PongNotifier interface {
+	SetPongHandler(handler func())
+}
+
+// rttTracker measures round trips by pairing each ping with the next pong,
+// and smooths them with an exponentially weighted moving average
+type rttTracker struct {
+	mu         sync.Mutex
+	pingSentAt time.Time // Zero when no ping awaits its pong
+	smoothed   time.Duration
+	poorPings  int               // Answered pings in a row that left the RTT poor
+	reported   ConnectionQuality // Last quality returned by changed
+}
+
+// reset forgets every measurement, as on a new connection
+func (r *rttTracker) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pingSentAt = time.Time{}
+	r.smoothed = 0
+	r.poorPings = 0
+	r.reported = ConnectionQuality{Level: QualityUnknown}
+}
+
+// pingSent records that a ping was written at now. A ping still awaiting
+// its pong keeps its time, so a lost pong shows as a growing round trip.
+func (r *rttTracker) pingSent(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pingSentAt.IsZero() {
+		r.pingSentAt = now
+	}
+}
+
+// pongReceived records the pong answering the outstanding ping at now
+func (r *rttTracker) pongReceived(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pingSentAt.IsZero() {
+		return // Unsolicited pong
+	}
+
+	sample := now.Sub(r.pingSentAt)
+	r.pingSentAt = time.Time{}
+	if r.smoothed == 0 {
+		r.smoothed = sample
+	} else {
+		r.smoothed += time.Duration(rttSmoothing * float64(sample-r.smoothed))
+	}
+	if levelOf(r.smoothed) == QualityPoor {
+		r.poorPings++
+	} else {
+		r.poorPings = 0
+	}
+}
+
+// quality returns the connection quality at now
+func (r *rttTracker) quality(now time.Time) ConnectionQuality {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.qualityLocked(now)
+}
+
+// changed returns the quality at now and whether its level or degraded
+// state differs from the quality it last returned
+func (r *rttTracker) changed(now time.Time) (ConnectionQuality, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	quality := r.qualityLocked(now)
+	if quality.Level == r.reported.Level && quality.Degraded == r.reported.Degraded {
+		return quality, false
+	}
+	r.reported = quality
+	return quality, true
+}
+
+func (r *rttTracker) qualityLocked(now time.Time) ConnectionQuality {
+	if r.smoothed == 0 {
+		return ConnectionQuality{Level: QualityUnknown}
+	}
+
+	// A ping unanswered for longer than the usual round trip is graded by
+	// how long it has waited, so a stalled connection does not look good
+	current := r.smoothed
+	if !r.pingSentAt.IsZero() {
+		if waited := now.Sub(r.pingSentAt); waited > current {
+			current = waited
+		}
+	}
+	return ConnectionQuality{
+		RTT:      r.smoothed,
+		Level:    levelOf(current),
+		Degraded: r.poorPings >= sustainedPoorPings,
+	}
+}
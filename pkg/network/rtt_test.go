@@ -0,0 +1,157 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRTTTracker(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		pongs    []time.Duration // Delay of the pong answering each ping
+		rtt      time.Duration
+		level    QualityLevel
+		degraded bool
+	}{
+		{"no data yet", nil, 0, QualityUnknown, false},
+		{"first pong", []time.Duration{40 * ms}, 40 * ms, QualityGood, false},
+		{"smoothed", []time.Duration{100 * ms, 500 * ms}, 200 * ms, QualityFair, false},
+		{"one slow pong", []time.Duration{50 * ms, 50 * ms, 2000 * ms}, 537500 * time.Microsecond, QualityPoor, false},
+		{"sustained slow pongs", []time.Duration{800 * ms, 800 * ms, 800 * ms}, 800 * ms, QualityPoor, true},
+		{"recovered", []time.Duration{800 * ms, 800 * ms, 800 * ms, 10 * ms, 10 * ms, 10 * ms, 10 * ms, 10 * ms}, 0, QualityFair, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r rttTracker
+			r.reset()
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			for _, delay := range tt.pongs {
+				r.pingSent(now)
+				now = now.Add(delay)
+				r.pongReceived(now)
+				now = now.Add(pingInterval)
+			}
+
+			got := r.quality(now)
+			if tt.rtt != 0 && got.RTT != tt.rtt {
+				t.Errorf("RTT = %s, want %s", got.RTT, tt.rtt)
+			}
+			if got.Level != tt.level || got.Degraded != tt.degraded {
+				t.Errorf("quality = %s (degraded %v), want %s (degraded %v)", got.Level, got.Degraded, tt.level, tt.degraded)
+			}
+		})
+	}
+}
+
+func TestRTTTrackerGradesStalledPing(t *testing.T) {
+	var r rttTracker
+	r.reset()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r.pingSent(now)
+	r.pongReceived(now.Add(20 * time.Millisecond))
+
+	// An unsolicited pong measures nothing
+	r.pongReceived(now.Add(time.Second))
+	if got := r.quality(now.Add(time.Second)); got.RTT != 20*time.Millisecond {
+		t.Errorf("RTT after an unsolicited pong = %s, want 20ms", got.RTT)
+	}
+
+	// A ping that goes unanswered makes the connection look worse the
+	// longer it waits, and a second ping does not restart the wait
+	sent := now.Add(pingInterval)
+	r.pingSent(sent)
+	r.pingSent(sent.Add(pingInterval / 2))
+	if got := r.quality(sent.Add(300 * time.Millisecond)); got.Level != QualityFair {
+		t.Errorf("quality 300ms into the wait = %s, want fair", got.Level)
+	}
+	quality, changed := r.changed(sent.Add(time.Second))
+	if !changed || quality.Level != QualityPoor || quality.RTT != 20*time.Millisecond {
+		t.Errorf("changed = %+v, %v, want poor with the smoothed RTT kept", quality, changed)
+	}
+	if _, changed := r.changed(sent.Add(2 * time.Second)); changed {
+		t.Error("the same quality was reported as changed twice")
+	}
+}
+
+// slowPongTransport connects to a relay in memory, answering each ping
+// after a delay
+type slowPongTransport struct {
+	MemoryTransport
+	delay time.Duration
+}
+
+type slowPongConn struct {
+	Conn
+	delay time.Duration
+}
+
+func (t *slowPongTransport) Dial(ctx context.Context, serverURL string) (Conn, error) {
+	conn, err := t.MemoryTransport.Dial(ctx, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	return &slowPongConn{Conn: conn, delay: t.delay}, nil
+}
+
+func (c *slowPongConn) SetPongHandler(handler func()) {
+	c.Conn.(PongNotifier).SetPongHandler(func() {
+		time.AfterFunc(c.delay, handler)
+	})
+}
+
+func TestClientMeasuresPongDelay(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		level QualityLevel
+	}{
+		{0, QualityGood},
+		{200 * time.Millisecond, QualityFair},
+		{600 * time.Millisecond, QualityPoor},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.level), func(t *testing.T) {
+			server := newTestRelay(t, ServerOptions{})
+			var mu sync.Mutex
+			var events []ConnectionQuality
+			client := NewClient(ClientOptions{
+				ServerURL: "memory://relay",
+				UserID:    "alice",
+				Transport: &slowPongTransport{MemoryTransport{Server: server}, tt.delay},
+				ConnectionHandler: func(event ConnectionEvent) {
+					if event.Type == ConnectionEventQuality {
+						mu.Lock()
+						events = append(events, event.Quality)
+						mu.Unlock()
+					}
+				},
+			})
+			t.Cleanup(func() { client.Close() })
+			if err := client.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+
+			waitFor(t, "the first pong", func() bool { return client.RTT() != 0 || tt.delay == 0 && client.Quality().Level != QualityUnknown })
+			if rtt := client.RTT(); rtt < tt.delay || rtt > tt.delay+100*time.Millisecond {
+				t.Errorf("RTT = %s, want about %s", rtt, tt.delay)
+			}
+			if got := client.Quality(); got.Level != tt.level || got.Degraded {
+				t.Errorf("quality = %+v, want %s", got, tt.level)
+			}
+			waitFor(t, "the quality event", func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(events) > 0 && events[len(events)-1].Level == tt.level
+			})
+
+			client.Close()
+			if got := client.Quality(); got.Level != QualityUnknown {
+				t.Errorf("quality once closed = %s, want unknown", got.Level)
+			}
+		})
+	}
+}
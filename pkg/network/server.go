@@ -7,9 +7,11 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/opensourceghana/securechat/internal/models"
 )
 
 // Server represents a relay server for SecureChat
@@ -26,49 +28,200 @@ type Server struct {
 	clientsMux sync.RWMutex
 	
 	// Message routing
-	messageQueue chan *RoutedMessage
+	routeQueue *routeQueues
+	routerOnce sync.Once
+	
+	// Offline storage for recipients that are not connected, unused in
+	// immediate delivery mode
+	offline      *offlineStore
+	deliveryMode DeliveryMode
+	
+	// Federation: where messages for users who are not connected are
+	// offered first, and the token peers forward messages with
+	forwarder       Forwarder
+	federationToken string
+	forwardQueue    chan *RoutedMessage
+	forwarding      *heldStatuses
 	
 	// Server control
 	ctx    context.Context
 	cancel context.CancelFunc
 	server *http.Server
 	
-	// Statistics
-	stats ServerStats
+	// Admin API
+	adminAddr   string
+	adminToken  string
+	adminServer *http.Server
+	
+	// Per-client rate limiting
+	rateLimit    RateLimit
+	rateLimitMux sync.RWMutex
+	
+	// Presence subscriptions keyed by watched user ID
+	presenceSubs   map[string]map[*ServerClient]bool
+	presenceMux    sync.Mutex
+	presencePolicy PresencePolicy
+	
+	// Last-seen times and privacy preferences keyed by user ID, guarded by presenceMux
+	lastSeen     map[string]time.Time
+	hideLastSeen map[string]bool
+	
+	// Protocol versions accepted from clients
+	versions VersionRange
+	
+	// Key the hello is signed with; nil if the relay has no identity
+	identity *RelayIdentity
+	
+	// Nonces of signed client hellos, with the time they were signed at,
+	// so a recorded hello cannot be replayed
+	helloNonces    map[string]time.Time
+	helloNoncesMux sync.Mutex
+	
+	// When the last announcement was sent, to rate-limit them
+	lastAnnouncement time.Time
+	announceMux      sync.Mutex
+	
+	// Statistics, updated as clients come and go and messages are routed
+	startedAt        time.Time
+	connectedClients atomic.Int64
+	messagesRouted   atomic.Int64
+	sessionsReplaced atomic.Int64
 }
 
 // ServerClient represents a connected client
 type ServerClient struct {
-	ID       string
+	ID string
+	
+	// Set by the client's hello under mu. Goroutines other than the
+	// client's reader use session or user rather than reading them.
 	UserID   string
-	Conn     *websocket.Conn
+	DeviceID string
+	
+	Conn     Conn
 	Send     chan *Message
 	Server   *Server
 	LastSeen time.Time
+	
+	// done is closed to stop the writer once it has flushed what is queued.
+	// Send itself is never closed, so routing to a client that is going
+	// away cannot panic.
+	done      chan struct{}
+	closeOnce sync.Once
+	
+	// Presence, protocol version, traffic accounting and rate limiting, guarded by mu
+	mu              sync.Mutex
+	status          string
+	protocolVersion int
+	identityKey     string    // Key the hello was signed with; empty if unsigned
+	helloAt         time.Time // When the hello identified the session
+	bytesIn    int64
+	bytesOut   int64
+	tokens     float64
+	lastRefill time.Time
 }
 
 // RoutedMessage represents a message to be routed
 type RoutedMessage struct {
-	From    string
-	To      string
-	Message *Message
+	From     string
+	To       string
+	Message  *Message
+	Priority Priority
+	
+	seq uint64 // Order in the offline store
 }
 
-// ServerStats contains server statistics
+// ServerStats is a snapshot of server statistics
 type ServerStats struct {
 	ConnectedClients int
 	MessagesRouted   int64
-	Uptime          time.Time
+	SessionsReplaced int64 // Stale sessions closed when their device reconnected
+	Uptime           time.Duration
+}
+
+// MarshalJSON writes Uptime in seconds, as the health check does
+func (st ServerStats) MarshalJSON() ([]byte, error) {
+	type stats ServerStats
+	return json.Marshal(struct {
+		stats
+		Uptime float64
+	}{stats(st), st.Uptime.Seconds()})
 }
 
 // ServerOptions contains options for creating a server
 type ServerOptions struct {
 	Addr string
 	Port int
+	
+	// AdminAddr enables the admin API on a separate listener when set.
+	// AdminToken must also be set; requests authenticate with it as a bearer token.
+	AdminAddr  string
+	AdminToken string
+	
+	RateLimit RateLimit
+	
+	// PresencePolicy restricts who may see whose presence. Nil allows everyone.
+	PresencePolicy PresencePolicy
+	
+	// Versions is the range of protocol versions accepted from clients.
+	// Defaults to SupportedVersions.
+	Versions VersionRange
+	
+	// IdentityPath is the file holding the relay's identity key, which is
+	// generated on first run. Without it the hello is not signed and
+	// clients cannot pin the relay.
+	IdentityPath string
+	
+	// DeliveryMode decides whether messages for users who are not connected
+	// are stored. Defaults to DeliveryStoreAndForward.
+	DeliveryMode DeliveryMode
+	
+	// Forwarder is offered messages for users who are not connected before
+	// they are stored or dropped. Defaults to NopForwarder.
+	Forwarder Forwarder
+	
+	// FederationToken enables the endpoint peer relays forward messages
+	// to; they authenticate with it as a bearer token
+	FederationToken string
 }
 
-// NewServer creates a new relay server
-func NewServer(opts ServerOptions) *Server {
+// DeliveryMode is what the relay does with messages for users who are not
+// connected
+type DeliveryMode string
+
+const (
+	// DeliveryStoreAndForward keeps them until the recipient connects
+	DeliveryStoreAndForward DeliveryMode = "store-and-forward"
+	// DeliveryImmediate drops them, so the relay never stores a message
+	DeliveryImmediate DeliveryMode = "immediate"
+)
+
+// maxMessageSize is the largest message the relay accepts from a client,
+// large enough for a base64-encoded file chunk
+const maxMessageSize = 64 * 1024
+
+// NewServer creates a new relay server. It fails if the identity cannot be
+// loaded or created, or the delivery mode is unknown.
+func NewServer(opts ServerOptions) (*Server, error) {
+	switch opts.DeliveryMode {
+	case "":
+		opts.DeliveryMode = DeliveryStoreAndForward
+	case DeliveryStoreAndForward, DeliveryImmediate:
+	default:
+		return nil, fmt.Errorf("unknown delivery mode %q", opts.DeliveryMode)
+	}
+	
+	var identity *RelayIdentity
+	if opts.IdentityPath != "" {
+		var err error
+		if identity, err = LoadRelayIdentity(opts.IdentityPath); err != nil {
+			return nil, err
+		}
+	}
+	
+	if opts.Forwarder == nil {
+		opts.Forwarder = NopForwarder{}
+	}
+	
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	if opts.Addr == "" {
@@ -88,26 +241,53 @@ func NewServer(opts ServerOptions) *Server {
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
 		},
-		clients:      make(map[string]*ServerClient),
-		messageQueue: make(chan *RoutedMessage, 1000),
-		ctx:          ctx,
-		cancel:       cancel,
-		stats: ServerStats{
-			Uptime: time.Now(),
-		},
+		clients:         make(map[string]*ServerClient),
+		routeQueue:      newRouteQueues(),
+		offline:         newOfflineStore(),
+		deliveryMode:    opts.DeliveryMode,
+		forwarder:       opts.Forwarder,
+		federationToken: opts.FederationToken,
+		forwardQueue:    make(chan *RoutedMessage, forwardQueueSize),
+		forwarding:      newHeldStatuses(),
+		ctx:             ctx,
+		cancel:          cancel,
+		adminAddr:       opts.AdminAddr,
+		adminToken:      opts.AdminToken,
+		rateLimit:       opts.RateLimit,
+		presenceSubs:    make(map[string]map[*ServerClient]bool),
+		presencePolicy:  opts.PresencePolicy,
+		lastSeen:        make(map[string]time.Time),
+		hideLastSeen:    make(map[string]bool),
+		versions:        opts.Versions.orDefault(),
+		identity:        identity,
+		helloNonces:     make(map[string]time.Time),
+		startedAt:       time.Now(),
+	}, nil
+}
+
+// Fingerprint returns the fingerprint clients pin the relay by, or an empty
+// string if the relay has no identity
+func (s *Server) Fingerprint() string {
+	if s.identity == nil {
+		return ""
 	}
+	return s.identity.Fingerprint()
 }
 
 // Start starts the relay server
 func (s *Server) Start() error {
 	// Start message router
-	go s.messageRouter()
+	s.startRouter()
+	
+	// Start admin API if configured
+	s.startAdmin()
 	
 	// Setup HTTP routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", s.handleWebSocket)
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/stats", s.handleStats)
+	mux.Handle(federationPath, s.FederationHandler())
 	
 	// Create HTTP server
 	s.server = &http.Server{
@@ -138,10 +318,18 @@ func (s *Server) Stop() error {
 	}
 	s.clientsMux.Unlock()
 	
-	// Shutdown HTTP server
+	// Shutdown HTTP servers
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	
+	if s.adminServer != nil {
+		s.adminServer.Shutdown(ctx)
+	}
+	
+	if s.server == nil {
+		return nil
+	}
+	
 	return s.server.Shutdown(ctx)
 }
 
@@ -154,13 +342,35 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Set read limits and keep the connection alive while pongs arrive
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+	
+	s.ServeConn(newWebSocketConn(conn))
+}
+
+// ServeConn attaches an established connection from any transport to the relay
+func (s *Server) ServeConn(conn Conn) {
+	// A stopped relay takes no new connections
+	if s.ctx.Err() != nil {
+		conn.Close()
+		return
+	}
+	
+	s.startRouter()
+	
 	// Create client
 	client := &ServerClient{
 		ID:       generateClientID(),
 		Conn:     conn,
 		Send:     make(chan *Message, 256),
 		Server:   s,
+		done:     make(chan struct{}),
 		LastSeen: time.Now(),
+		status:   string(models.UserStatusOnline),
 	}
 	
 	log.Printf("New client connected: %s", client.ID)
@@ -174,6 +384,17 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	s.addClient(client)
 }
 
+// startRouter starts the message router and the forwarding workers if they
+// are not already running
+func (s *Server) startRouter() {
+	s.routerOnce.Do(func() {
+		go s.messageRouter()
+		for i := 0; i < forwardWorkers; i++ {
+			go s.forwardWorker()
+		}
+	})
+}
+
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -182,7 +403,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	health := map[string]interface{}{
 		"status":    "healthy",
 		"timestamp": time.Now().Unix(),
-		"uptime":    time.Since(s.stats.Uptime).Seconds(),
+		"uptime":    time.Since(s.startedAt).Seconds(),
 	}
 	
 	json.NewEncoder(w).Encode(health)
@@ -193,55 +414,175 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	
-	s.clientsMux.RLock()
-	s.stats.ConnectedClients = len(s.clients)
-	s.clientsMux.RUnlock()
-	
-	json.NewEncoder(w).Encode(s.stats)
+	json.NewEncoder(w).Encode(s.Stats())
+}
+
+// Stats returns the current server statistics
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		ConnectedClients: int(s.connectedClients.Load()),
+		MessagesRouted:   s.messagesRouted.Load(),
+		SessionsReplaced: s.sessionsReplaced.Load(),
+		Uptime:           time.Since(s.startedAt),
+	}
 }
 
 // addClient adds a client to the server
 func (s *Server) addClient(client *ServerClient) {
 	s.clientsMux.Lock()
 	s.clients[client.ID] = client
+	total := len(s.clients)
+	s.connectedClients.Store(int64(total))
 	s.clientsMux.Unlock()
 	
-	log.Printf("Client added: %s (total: %d)", client.ID, len(s.clients))
+	log.Printf("Client added: %s (total: %d)", client.ID, total)
 }
 
 // removeClient removes a client from the server
 func (s *Server) removeClient(client *ServerClient) {
 	s.clientsMux.Lock()
 	delete(s.clients, client.ID)
+	total := len(s.clients)
+	s.connectedClients.Store(int64(total))
+	s.clientsMux.Unlock()
+	
+	s.unsubscribePresence(client)
+	client.closeAfterFlush()
+	log.Printf("Client removed: %s (total: %d)", client.ID, total)
+	
+	// A session that appeared offline already announced when it went
+	// offline; its real disconnect time must not leak
+	client.mu.Lock()
+	visible := client.status != string(models.UserStatusOffline)
+	client.mu.Unlock()
+	
+	if userID := client.user(); userID != "" && visible && s.findClientByUserID(userID) == nil {
+		s.markLastSeen(userID)
+		s.notifyPresence(userID)
+	}
+}
+
+// errorCodeSessionReplaced is sent by the relay before it closes a session
+// replaced by a newer one from the same install
+const errorCodeSessionReplaced = "SESSION_REPLACED"
+
+// replaceStaleSessions closes the other sessions of client's user from the
+// same install. A client that reconnects before the relay notices its old
+// connection drop would otherwise leave a dead session that messages may
+// be routed to. Sessions from the user's other devices are kept. Only a
+// session whose hello was signed can replace another, and only one signed
+// with the same key, so knowing a user's device ID is not enough to cut
+// them off; clients that do not send a device ID cannot be told apart, so
+// none of their sessions are replaced.
+func (s *Server) replaceStaleSessions(client *ServerClient) {
+	userID, deviceID, identityKey := client.session()
+	if deviceID == "" || identityKey == "" {
+		return
+	}
+	
+	var stale []*ServerClient
+	
+	// Stale sessions leave the map at once, so nothing more is routed to
+	// them; removeClient finishes them off once their connection closes
+	s.clientsMux.Lock()
+	for id, other := range s.clients {
+		if other == client {
+			continue
+		}
+		otherUser, otherDevice, otherKey := other.session()
+		if otherUser == userID && otherDevice == deviceID && otherKey == identityKey {
+			stale = append(stale, other)
+			delete(s.clients, id)
+		}
+	}
+	s.connectedClients.Store(int64(len(s.clients)))
 	s.clientsMux.Unlock()
 	
-	close(client.Send)
-	log.Printf("Client removed: %s (total: %d)", client.ID, len(s.clients))
+	for _, old := range stale {
+		s.sessionsReplaced.Add(1)
+		log.Printf("Client %s replaces stale session %s of user %s", client.ID, old.ID, userID)
+		old.sendError(errorCodeSessionReplaced, "a newer session from this device connected", "")
+		old.closeAfterFlush()
+	}
 }
 
-// findClientByUserID finds a client by user ID
+// freshHello reports whether a signed client hello was signed recently and
+// its nonce was not seen before, recording the nonce
+func (s *Server) freshHello(nonce string, timestamp int64) bool {
+	signedAt := time.Unix(timestamp, 0)
+	now := time.Now()
+	if nonce == "" || signedAt.Before(now.Add(-helloMaxAge)) || signedAt.After(now.Add(helloMaxAge)) {
+		return false
+	}
+	
+	s.helloNoncesMux.Lock()
+	defer s.helloNoncesMux.Unlock()
+	
+	if _, seen := s.helloNonces[nonce]; seen {
+		return false
+	}
+	// Hellos signed before the window are rejected anyway, so their nonces
+	// need not be kept
+	for seenNonce, seenAt := range s.helloNonces {
+		if seenAt.Before(now.Add(-helloMaxAge)) {
+			delete(s.helloNonces, seenNonce)
+		}
+	}
+	s.helloNonces[nonce] = signedAt
+	return true
+}
+
+// findClientByUserID finds the session a user's messages go to. A session
+// whose hello was signed outranks any unsigned one, since anyone may send
+// an unsigned hello claiming the user's ID; among sessions alike in that,
+// the one that identified itself last wins, so messages go to a user's
+// newest session rather than whichever the map yields first. A reconnect
+// from the same install replaces the stale session, and otherwise the
+// newest session is the one most likely still in use. Sessions identified
+// at the same instant are told apart by their IDs.
 func (s *Server) findClientByUserID(userID string) *ServerClient {
 	s.clientsMux.RLock()
 	defer s.clientsMux.RUnlock()
 	
+	var best *ServerClient
+	var bestAt time.Time
+	bestSigned := false
 	for _, client := range s.clients {
-		if client.UserID == userID {
-			return client
+		user, signed, helloAt := client.identified()
+		if user != userID {
+			continue
+		}
+		if best != nil {
+			if signed != bestSigned {
+				if !signed {
+					continue
+				}
+			} else if !helloAt.After(bestAt) && !(helloAt.Equal(bestAt) && client.ID > best.ID) {
+				continue
+			}
 		}
+		best, bestAt, bestSigned = client, helloAt, signed
 	}
 	
-	return nil
+	return best
 }
 
-// messageRouter routes messages between clients
+// messageRouter routes messages between clients, most urgent first
 func (s *Server) messageRouter() {
 	for {
 		select {
 		case <-s.ctx.Done():
 			return
-		case routedMsg := <-s.messageQueue:
-			s.routeMessage(routedMsg)
+		default:
 		}
+
+		routedMsg := s.routeQueue.next()
+		if routedMsg == nil {
+			if routedMsg = s.routeQueue.wait(s.ctx.Done()); routedMsg == nil {
+				return
+			}
+		}
+		s.routeMessage(routedMsg)
 	}
 }
 
@@ -249,22 +590,141 @@ func (s *Server) messageRouter() {
 func (s *Server) routeMessage(routedMsg *RoutedMessage) {
 	// Find destination client
 	destClient := s.findClientByUserID(routedMsg.To)
+	if _, nop := s.forwarder.(NopForwarder); destClient == nil && !nop {
+		s.queueForward(routedMsg)
+		return
+	}
+	if destClient == nil && s.deliveryMode == DeliveryImmediate {
+		s.dropOfflineMessage(routedMsg)
+		return
+	}
 	if destClient == nil {
-		log.Printf("Destination client not found: %s", routedMsg.To)
-		// TODO: Store message for offline delivery
+		s.queueOfflineMessage(routedMsg)
+		return
+	}
+	
+	if !supportsMessage(destClient.version(), routedMsg.Message.Type) {
+		s.rejectUnsupported(routedMsg)
 		return
 	}
 	
 	// Send message to destination
 	select {
 	case destClient.Send <- routedMsg.Message:
-		s.stats.MessagesRouted++
+		s.messagesRouted.Add(1)
 		log.Printf("Message routed from %s to %s", routedMsg.From, routedMsg.To)
+		if routedMsg.Message.Type == MessageTypeChat {
+			s.sendDeliveryStatus(routedMsg, "delivered")
+		}
 	default:
 		log.Printf("Failed to route message: destination client queue full")
 	}
 }
 
+// queueOfflineMessage stores a message until its recipient connects and
+// tells the sender that the relay has accepted it
+func (s *Server) queueOfflineMessage(routedMsg *RoutedMessage) {
+	s.offline.push(routedMsg)
+	
+	log.Printf("Recipient %s offline, queued message %s", routedMsg.To, routedMsg.Message.ID)
+	
+	if routedMsg.Message.Type == MessageTypeChat {
+		s.sendDeliveryStatus(routedMsg, "queued")
+	}
+}
+
+// dropOfflineMessage discards a message for a recipient who is not
+// connected, in immediate delivery mode. The sender of a chat message is
+// told, so it need not wait for an ack that will never come.
+func (s *Server) dropOfflineMessage(routedMsg *RoutedMessage) {
+	log.Printf("Recipient %s offline, dropped message %s", routedMsg.To, routedMsg.Message.ID)
+	
+	if routedMsg.Message.Type != MessageTypeChat {
+		return
+	}
+	if sender := s.findClientByUserID(routedMsg.From); sender != nil {
+		sender.sendError("RECIPIENT_OFFLINE",
+			"recipient is offline and the relay does not store messages",
+			routedMsg.Message.ID)
+	}
+}
+
+// deliverOfflineMessages hands up to limit messages queued for a client to
+// it, all of them if limit is 0, those from the preferred senders first. It
+// returns how many were delivered.
+func (s *Server) deliverOfflineMessages(client *ServerClient, prefer []string, limit int) int {
+	pending := s.offline.take(client.UserID, prefer, limit)
+	
+	delivered := 0
+	for i, routedMsg := range pending {
+		if !supportsMessage(client.version(), routedMsg.Message.Type) {
+			s.rejectUnsupported(routedMsg)
+			continue
+		}
+		
+		select {
+		case client.Send <- routedMsg.Message:
+			s.messagesRouted.Add(1)
+			delivered++
+			if routedMsg.Message.Type == MessageTypeChat {
+				s.sendDeliveryStatus(routedMsg, "delivered")
+			}
+		default:
+			// Keep whatever did not fit for a later request or connection
+			s.offline.putBack(client.UserID, pending[i:])
+			log.Printf("Client %s queue full, deferred %d offline messages", client.ID, len(pending)-i)
+			return delivered
+		}
+	}
+	
+	if delivered > 0 {
+		log.Printf("Delivered %d offline messages to %s", delivered, client.UserID)
+	}
+	return delivered
+}
+
+// sendDeliveryStatus reports the relay-side status of a message back to its sender.
+// Acknowledgements for offline senders are queued like any other message,
+// or dropped in immediate delivery mode.
+func (s *Server) sendDeliveryStatus(routedMsg *RoutedMessage, status string) {
+	ack := newMessage(MessageTypeAck, "server", routedMsg.From, AckPayload{
+		MessageID: routedMsg.Message.ID,
+		Recipient: routedMsg.To,
+		Status:    status,
+	})
+	
+	sender := s.findClientByUserID(routedMsg.From)
+	if sender == nil && s.deliveryMode == DeliveryImmediate {
+		return
+	}
+	if sender == nil {
+		s.offline.push(&RoutedMessage{
+			From:    "server",
+			To:      routedMsg.From,
+			Message: ack,
+		})
+		return
+	}
+	
+	select {
+	case sender.Send <- ack:
+	default:
+		log.Printf("Failed to send %s status to %s: queue full", status, routedMsg.From)
+	}
+}
+
+// rejectUnsupported drops a message its recipient's protocol version
+// cannot handle and tells the sender
+func (s *Server) rejectUnsupported(routedMsg *RoutedMessage) {
+	log.Printf("Dropping %s message %s: %s does not support it", routedMsg.Message.Type, routedMsg.Message.ID, routedMsg.To)
+	
+	if sender := s.findClientByUserID(routedMsg.From); sender != nil {
+		sender.sendError("UNSUPPORTED_BY_RECIPIENT",
+			fmt.Sprintf("recipient cannot receive %s messages", routedMsg.Message.Type),
+			routedMsg.Message.ID)
+	}
+}
+
 // ServerClient methods
 
 // readMessages reads messages from the client connection
@@ -274,20 +734,15 @@ func (c *ServerClient) readMessages() {
 		c.Conn.Close()
 	}()
 	
-	// Set read limits and deadline
-	c.Conn.SetReadLimit(512)
+	// Set read deadline
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
 	
 	for {
 		// Read message
-		_, data, err := c.Conn.ReadMessage()
+		data, err := c.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error for client %s: %v", c.ID, err)
+			if isUnexpectedClose(err) {
+				log.Printf("Connection error for client %s: %v", c.ID, err)
 			}
 			break
 		}
@@ -299,8 +754,14 @@ func (c *ServerClient) readMessages() {
 			continue
 		}
 		
-		// Update last seen
-		c.LastSeen = time.Now()
+		// Update last seen and traffic counters
+		c.recordRead(len(data))
+		
+		// The messages of a batch are rate limited one by one
+		if msg.Type != messageTypeBatch && !c.allow(c.Server.RateLimit()) {
+			c.sendError("RATE_LIMITED", "too many messages, slow down", msg.ID)
+			continue
+		}
 		
 		// Handle message based on type
 		c.handleMessage(&msg)
@@ -317,43 +778,151 @@ func (c *ServerClient) writeMessages() {
 	
 	for {
 		select {
-		case msg, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case msg := <-c.Send:
+			if !c.write(msg) {
 				return
 			}
 			
-			// Marshal and send message
-			data, err := json.Marshal(msg)
-			if err != nil {
-				log.Printf("Failed to marshal message for client %s: %v", c.ID, err)
-				continue
-			}
-			
-			if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Printf("Failed to write message to client %s: %v", c.ID, err)
-				return
+		case <-c.done:
+			// Flush what is already queued, such as an error explaining
+			// the close; Close then sends the transport's close frame
+			for {
+				select {
+				case msg := <-c.Send:
+					if !c.write(msg) {
+						return
+					}
+				default:
+					return
+				}
 			}
 			
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.Conn.WritePing(); err != nil {
 				return
 			}
 		}
 	}
 }
 
+// write marshals and writes one message, returning false if the connection
+// failed
+func (c *ServerClient) write(msg *Message) bool {
+	c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message for client %s: %v", c.ID, err)
+		return true
+	}
+	
+	if err := c.Conn.WriteMessage(data); err != nil {
+		log.Printf("Failed to write message to client %s: %v", c.ID, err)
+		return false
+	}
+	c.recordWrite(len(data))
+	return true
+}
+
+// recordRead updates last-seen time and inbound byte count
+func (c *ServerClient) recordRead(n int) {
+	c.mu.Lock()
+	c.LastSeen = time.Now()
+	c.bytesIn += int64(n)
+	c.mu.Unlock()
+}
+
+// recordWrite updates the outbound byte count
+func (c *ServerClient) recordWrite(n int) {
+	c.mu.Lock()
+	c.bytesOut += int64(n)
+	c.mu.Unlock()
+}
+
+// info returns a snapshot of the client for the admin API
+func (c *ServerClient) info() AdminClientInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	return AdminClientInfo{
+		ID:       c.ID,
+		UserID:   c.UserID,
+		LastSeen: c.LastSeen,
+		BytesIn:  c.bytesIn,
+		BytesOut: c.bytesOut,
+	}
+}
+
+// allow reports whether the client may send another message under limit,
+// using a token bucket refilled at MessagesPerSecond
+func (c *ServerClient) allow(limit RateLimit) bool {
+	if limit.MessagesPerSecond <= 0 {
+		return true
+	}
+	
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	now := time.Now()
+	if c.lastRefill.IsZero() {
+		c.tokens = burst
+	} else {
+		c.tokens += now.Sub(c.lastRefill).Seconds() * limit.MessagesPerSecond
+		if c.tokens > burst {
+			c.tokens = burst
+		}
+	}
+	c.lastRefill = now
+	
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// routable reports whether messages of a type are routed to another user,
+// rather than handled by the relay
+func routable(msgType string) bool {
+	switch msgType {
+	case MessageTypeChat, MessageTypeAck, "file_offer", "file_request", "file_chunk", "file_complete",
+		MessageTypeKeyRequest, MessageTypeKeyBundle, MessageTypeSessionInit, MessageTypeResendRequest:
+		return true
+	}
+	return false
+}
+
 // handleMessage handles different types of messages from clients
 func (c *ServerClient) handleMessage(msg *Message) {
+	if !supportsMessage(c.version(), msg.Type) {
+		c.sendError("UNSUPPORTED_MESSAGE",
+			fmt.Sprintf("%s messages require protocol v%d", msg.Type, minVersionFor(msg.Type)),
+			msg.ID)
+		return
+	}
+	if routable(msg.Type) {
+		c.handleChatMessage(msg)
+		return
+	}
+	
 	switch msg.Type {
-	case "client_hello":
+	case MessageTypeClientHello:
 		c.handleClientHello(msg)
-	case "chat":
-		c.handleChatMessage(msg)
-	case "presence":
+	case MessageTypePresence:
 		c.handlePresenceMessage(msg)
+	case messageTypePresenceQuery:
+		c.handlePresenceQuery(msg)
+	case messageTypePresenceUnsubscribe:
+		c.handlePresenceUnsubscribe(msg)
+	case messageTypeSyncRequest:
+		c.handleSyncRequest(msg)
+	case messageTypeBatch:
+		c.handleBatch(msg)
 	default:
 		log.Printf("Unknown message type from client %s: %s", c.ID, msg.Type)
 	}
@@ -362,57 +931,250 @@ func (c *ServerClient) handleMessage(msg *Message) {
 // handleClientHello handles client hello messages
 func (c *ServerClient) handleClientHello(msg *Message) {
 	// Extract user ID from message
-	c.UserID = msg.From
+	userID, err := models.ParseUserID(msg.From)
+	if err != nil {
+		log.Printf("Client %s sent invalid user ID: %v", c.ID, err)
+		c.sendError("INVALID_USER_ID", err.Error(), msg.ID)
+		return
+	}
 	
-	log.Printf("Client %s identified as user %s", c.ID, c.UserID)
+	// Older clients may omit fields, so a malformed hello only loses the
+	// optional settings rather than the connection
+	var hello ClientHelloPayload
+	if err := msg.DecodePayload(&hello); err != nil {
+		log.Printf("Client %s sent a malformed hello: %v", c.ID, err)
+	}
+	
+	offered := hello.versionRange()
+	version, ok := c.Server.versions.Negotiate(offered)
+	if !ok {
+		log.Printf("Client %s speaks protocol %s, relay speaks %s; closing", c.ID, offered, c.Server.versions)
+		c.sendError(errorCodeVersionMismatch,
+			fmt.Sprintf("client speaks protocol %s, relay speaks %s", offered, c.Server.versions),
+			msg.ID)
+		c.closeAfterFlush()
+		return
+	}
+	
+	identityKey := ""
+	if hello.Signature != "" {
+		if err := verifyClientHello(&hello, userID, msg.Timestamp); err != nil {
+			log.Printf("Client %s sent a hello with a bad signature: %v", c.ID, err)
+			c.sendError("INVALID_SIGNATURE", err.Error(), msg.ID)
+			c.closeAfterFlush()
+			return
+		}
+		if !c.Server.freshHello(hello.Nonce, msg.Timestamp) {
+			log.Printf("Client %s sent a stale or replayed hello", c.ID)
+			c.sendError("STALE_HELLO", "hello is too old or was already used", msg.ID)
+			c.closeAfterFlush()
+			return
+		}
+		identityKey = hello.IdentityKey
+	}
+	
+	c.mu.Lock()
+	c.UserID = userID
+	c.DeviceID = hello.DeviceID
+	c.identityKey = identityKey
+	c.protocolVersion = version
+	c.helloAt = time.Now()
+	c.mu.Unlock()
+	c.Server.replaceStaleSessions(c)
+	c.Server.setHideLastSeen(userID, hello.HideLastSeen)
+	if hello.Invisible {
+		// Appear offline from the start, rather than briefly online
+		c.mu.Lock()
+		c.status = string(models.UserStatusOffline)
+		c.mu.Unlock()
+	}
+	
+	log.Printf("Client %s identified as user %s (protocol v%d)", c.ID, c.UserID, version)
 	
 	// Send server hello response
-	response := &Message{
-		ID:        generateMessageID(),
-		Type:      "server_hello",
-		From:      "server",
-		To:        c.UserID,
-		Timestamp: time.Now().Unix(),
-		Payload: map[string]interface{}{
-			"version":    "1.0",
-			"session_id": c.ID,
-			"capabilities": []string{"message_relay", "offline_storage"},
-		},
+	capabilities := []string{"message_relay", capabilityBatch}
+	if c.Server.deliveryMode == DeliveryStoreAndForward {
+		capabilities = append(capabilities, "offline_storage")
+	}
+	serverHello := ServerHelloPayload{
+		Version:      version,
+		SessionID:    c.ID,
+		Capabilities: capabilities,
+		DeliveryMode: c.Server.deliveryMode,
 	}
+	if c.Server.identity != nil {
+		c.Server.identity.signHello(&serverHello, hello.Nonce, c.UserID)
+	}
+	response := newMessage(MessageTypeServerHello, "server", c.UserID, serverHello)
 	
 	select {
 	case c.Send <- response:
 	default:
 		log.Printf("Failed to send server hello to client %s", c.ID)
 	}
+	
+	// Hand over anything stored while the user was offline, or let a client
+	// that asked for selective sync fetch it in batches
+	if hello.SelectiveSync && version >= ProtocolVersion3 {
+		c.sendSyncStatus(0)
+	} else {
+		c.Server.deliverOfflineMessages(c, nil, 0)
+	}
+	
+	if !hello.Invisible {
+		c.Server.notifyPresence(c.UserID)
+	}
 }
 
-// handleChatMessage handles chat messages
+// handleChatMessage routes chat, receipt and file transfer messages to their recipient
 func (c *ServerClient) handleChatMessage(msg *Message) {
 	// Validate message
-	if msg.To == "" {
-		log.Printf("Chat message from %s missing destination", c.UserID)
+	if c.UserID == "" {
+		c.sendError("NOT_IDENTIFIED", "client_hello required before sending messages", msg.ID)
+		return
+	}
+	
+	to, err := models.ParseUserID(msg.To)
+	if err != nil {
+		log.Printf("Chat message from %s has invalid destination: %v", c.UserID, err)
+		c.sendError("INVALID_USER_ID", err.Error(), msg.ID)
+		return
+	}
+	msg.To = to
+	// Recipients trust From to name the sender, so it is always the account
+	// the client identified as, whatever the message claimed
+	msg.From = c.UserID
+	
+	// Only the relay may report a message queued or delivered to the
+	// recipient's relay; a user can only ack that it arrived or was read
+	if msg.Type == MessageTypeAck && !userAckStatus(msg) {
+		log.Printf("Dropping ack %s from %s with a status only relays send", msg.ID, c.UserID)
+		c.sendError("INVALID_ACK", "acks from users must be delivered or read", msg.ID)
 		return
 	}
 	
 	// Queue message for routing
 	routedMsg := &RoutedMessage{
-		From:    c.UserID,
-		To:      msg.To,
-		Message: msg,
+		From:     c.UserID,
+		To:       to,
+		Message:  msg,
+		Priority: messagePriority(msg.Type),
+	}
+	
+	if !c.Server.routeQueue.push(routedMsg) {
+		log.Printf("Message queue full for %s messages, dropping message from %s to %s",
+			routedMsg.Priority, c.UserID, msg.To)
+	}
+}
+
+// userAckStatus reports whether an ack has a status a user may send
+func userAckStatus(msg *Message) bool {
+	var ack AckPayload
+	if err := msg.DecodePayload(&ack); err != nil {
+		return false
+	}
+	return ack.Status == string(models.MessageStatusDelivered) || ack.Status == string(models.MessageStatusRead)
+}
+
+// version returns the negotiated protocol version, 0 before the hello
+func (c *ServerClient) version() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocolVersion
+}
+
+// user returns the ID of the user the client identified as, or "" before
+// its hello
+func (c *ServerClient) user() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.UserID
+}
+
+// identified returns the user the client identified as, whether its hello
+// was signed, and when
+func (c *ServerClient) identified() (userID string, signed bool, helloAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.UserID, c.identityKey != "", c.helloAt
+}
+
+// session returns who the client identified as: its user, install and
+// the key its hello was signed with
+func (c *ServerClient) session() (userID, deviceID, identityKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.UserID, c.DeviceID, c.identityKey
+}
+
+// closeAfterFlush closes the connection once the messages already queued
+// for the client, such as an error explaining why, have been written. It
+// may be called more than once.
+func (c *ServerClient) closeAfterFlush() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// enqueue queues a message for the client without blocking. It returns
+// false if the queue is full or the client is closing, so nothing queued
+// would be written.
+func (c *ServerClient) enqueue(msg *Message) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
 	}
 	
 	select {
-	case c.Server.messageQueue <- routedMsg:
+	case c.Send <- msg:
+		return true
 	default:
-		log.Printf("Message queue full, dropping message from %s to %s", c.UserID, msg.To)
+		return false
+	}
+}
+
+// sendError reports a protocol error back to the client
+func (c *ServerClient) sendError(code, message, referenceID string) {
+	response := newMessage(MessageTypeError, "server", c.user(), ErrorPayload{
+		Code:        code,
+		Message:     message,
+		ReferenceID: referenceID,
+	})
+	
+	if !c.enqueue(response) {
+		log.Printf("Failed to send error to client %s", c.ID)
 	}
 }
 
-// handlePresenceMessage handles presence messages
+// handlePresenceMessage records a client's status change and pushes it to subscribers
 func (c *ServerClient) handlePresenceMessage(msg *Message) {
-	// TODO: Implement presence handling
-	log.Printf("Presence message from %s: %v", c.UserID, msg.Payload)
+	if c.UserID == "" {
+		return
+	}
+	
+	var presence PresencePayload
+	if err := msg.DecodePayload(&presence); err != nil {
+		c.sendError("INVALID_PAYLOAD", err.Error(), msg.ID)
+		return
+	}
+	
+	status := presence.Status
+	switch models.UserStatus(status) {
+	case models.UserStatusOnline, models.UserStatusAway, models.UserStatusBusy, models.UserStatusOffline:
+	default:
+		log.Printf("Invalid presence status from %s: %q", c.UserID, status)
+		return
+	}
+	
+	c.mu.Lock()
+	c.status = status
+	c.mu.Unlock()
+	
+	if status == string(models.UserStatusOffline) {
+		c.Server.markLastSeen(c.UserID)
+	}
+	c.Server.notifyPresence(c.UserID)
 }
 
 // generateClientID generates a unique client ID
@@ -0,0 +1,624 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds every wait on a relay in tests
+const testTimeout = 2 * time.Second
+
+// newTestRelay creates a relay that serves in-memory connections and stops
+// with the test
+func newTestRelay(t *testing.T, opts ServerOptions) *Server {
+	t.Helper()
+
+	server, err := NewServer(opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+// rawSession is a connection to a relay the test speaks the protocol on
+// directly
+type rawSession struct {
+	t    *testing.T
+	conn Conn
+}
+
+// dialRelay connects a raw session to server
+func dialRelay(t *testing.T, server *Server) *rawSession {
+	t.Helper()
+
+	conn, err := (&MemoryTransport{Server: server}).Dial(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rawSession{t: t, conn: conn}
+}
+
+// send writes msg to the relay
+func (r *rawSession) send(msg *Message) {
+	r.t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		r.t.Fatalf("Marshal: %v", err)
+	}
+	if err := r.conn.WriteMessage(data); err != nil {
+		r.t.Fatalf("WriteMessage: %v", err)
+	}
+}
+
+// next reads the next message from the relay, failing the test if none
+// arrives in time
+func (r *rawSession) next() *Message {
+	r.t.Helper()
+
+	msg, err := r.read()
+	if err != nil {
+		r.t.Fatalf("reading from relay: %v", err)
+	}
+	return msg
+}
+
+// read reads the next message from the relay
+func (r *rawSession) read() (*Message, error) {
+	r.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	data, err := r.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// expect reads until a message of msgType arrives, skipping others such as
+// presence updates
+func (r *rawSession) expect(msgType string) *Message {
+	r.t.Helper()
+
+	for {
+		msg := r.next()
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// expectError reads until an error arrives and checks its code
+func (r *rawSession) expectError(code string) {
+	r.t.Helper()
+
+	var payload ErrorPayload
+	if err := r.expect(MessageTypeError).DecodePayload(&payload); err != nil {
+		r.t.Fatalf("DecodePayload: %v", err)
+	}
+	if payload.Code != code {
+		r.t.Fatalf("error code = %s (%s), want %s", payload.Code, payload.Message, code)
+	}
+}
+
+// expectStatus reads until the relay reports the status of messageID
+func (r *rawSession) expectStatus(messageID string) string {
+	r.t.Helper()
+
+	for {
+		var ack AckPayload
+		if err := r.expect(MessageTypeAck).DecodePayload(&ack); err != nil {
+			r.t.Fatalf("DecodePayload: %v", err)
+		}
+		if ack.MessageID == messageID {
+			return ack.Status
+		}
+	}
+}
+
+// expectClosed reads until the relay closes the connection
+func (r *rawSession) expectClosed() {
+	r.t.Helper()
+
+	for {
+		_, err := r.read()
+		if errors.Is(err, ErrPipeClosed) {
+			return
+		}
+		if err != nil {
+			r.t.Fatalf("connection not closed: %v", err)
+		}
+	}
+}
+
+// helloMessage returns a client hello from userID, signed with key unless
+// it is nil
+func helloMessage(userID, deviceID string, key ed25519.PrivateKey) *Message {
+	hello := ClientHelloPayload{
+		MinVersion: SupportedVersions.Min,
+		MaxVersion: SupportedVersions.Max,
+		Nonce:      newHelloNonce(),
+		DeviceID:   deviceID,
+	}
+	msg := newMessage(MessageTypeClientHello, userID, "", hello)
+	if key != nil {
+		signClientHello(key, &hello, userID, msg.Timestamp)
+		msg.Payload, _ = EncodePayload(hello)
+	}
+	return msg
+}
+
+// connectAs connects a raw session and identifies it as userID
+func connectAs(t *testing.T, server *Server, userID, deviceID string, key ed25519.PrivateKey) *rawSession {
+	t.Helper()
+
+	session := dialRelay(t, server)
+	session.send(helloMessage(userID, deviceID, key))
+	session.expect(MessageTypeServerHello)
+	return session
+}
+
+// newSigningKey returns a fresh Ed25519 identity key
+func newSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestSignedHelloReplacesStaleSession(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	key := newSigningKey(t)
+
+	old := connectAs(t, server, "alice", "laptop", key)
+	connectAs(t, server, "alice", "laptop", key)
+
+	old.expectError(errorCodeSessionReplaced)
+	old.expectClosed()
+	if got := server.Stats().SessionsReplaced; got != 1 {
+		t.Errorf("SessionsReplaced = %d, want 1", got)
+	}
+}
+
+func TestHelloKeepsSessionsItCannotProve(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		key      func(first ed25519.PrivateKey) ed25519.PrivateKey
+	}{
+		{"unsigned", "laptop", func(ed25519.PrivateKey) ed25519.PrivateKey { return nil }},
+		{"other key", "laptop", func(ed25519.PrivateKey) ed25519.PrivateKey { return newSigningKey(t) }},
+		{"other device", "phone", func(first ed25519.PrivateKey) ed25519.PrivateKey { return first }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestRelay(t, ServerOptions{})
+			key := newSigningKey(t)
+
+			connectAs(t, server, "alice", "laptop", key)
+			connectAs(t, server, "alice", tt.deviceID, tt.key(key))
+
+			if got := server.Stats().SessionsReplaced; got != 0 {
+				t.Errorf("SessionsReplaced = %d, want 0", got)
+			}
+			if got := server.Stats().ConnectedClients; got != 2 {
+				t.Errorf("ConnectedClients = %d, want 2", got)
+			}
+		})
+	}
+}
+
+// expectNoChat fails the test if a chat message arrives before the relay
+// goes quiet
+func (r *rawSession) expectNoChat() {
+	r.t.Helper()
+
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		data, err := r.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg Message
+		if json.Unmarshal(data, &msg) == nil && msg.Type == MessageTypeChat {
+			r.t.Fatalf("chat message %s arrived on the wrong session", msg.ID)
+		}
+	}
+}
+
+func TestMessagesGoToTheNewestSession(t *testing.T) {
+	t.Run("reconnect from the same install", func(t *testing.T) {
+		server := newTestRelay(t, ServerOptions{})
+		key := newSigningKey(t)
+		bob := connectAs(t, server, "bob", "phone", nil)
+
+		old := connectAs(t, server, "alice", "laptop", key)
+		reconnected := connectAs(t, server, "alice", "laptop", key)
+		old.expectError(errorCodeSessionReplaced)
+		old.expectClosed()
+
+		msg := newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "hi"})
+		bob.send(msg)
+		if got := reconnected.expect(MessageTypeChat); got.ID != msg.ID {
+			t.Errorf("the new session got %s, want %s", got.ID, msg.ID)
+		}
+	})
+
+	t.Run("sessions that cannot be replaced", func(t *testing.T) {
+		server := newTestRelay(t, ServerOptions{})
+		bob := connectAs(t, server, "bob", "phone", nil)
+		older := connectAs(t, server, "alice", "laptop", nil)
+		newer := connectAs(t, server, "alice", "laptop", nil)
+
+		for i := 0; i < 10; i++ {
+			msg := newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "hi"})
+			bob.send(msg)
+			if got := newer.expect(MessageTypeChat); got.ID != msg.ID {
+				t.Fatalf("the newer session got %s, want %s", got.ID, msg.ID)
+			}
+		}
+		older.expectNoChat()
+	})
+}
+
+func TestUnsignedHelloDoesNotTakeOverSignedSession(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	bob := connectAs(t, server, "bob", "phone", nil)
+	alice := connectAs(t, server, "alice", "laptop", newSigningKey(t))
+	impostor := connectAs(t, server, "alice", "laptop", nil)
+
+	for i := 0; i < 10; i++ {
+		msg := newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "hi"})
+		bob.send(msg)
+		if got := alice.expect(MessageTypeChat); got.ID != msg.ID {
+			t.Fatalf("the signed session got %s, want %s", got.ID, msg.ID)
+		}
+	}
+	impostor.expectNoChat()
+	if got := server.Stats().ConnectedClients; got != 3 {
+		t.Errorf("ConnectedClients = %d, want 3", got)
+	}
+}
+
+func TestHelloWithBadSignatureIsRejected(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+
+	hello := helloMessage("alice", "laptop", newSigningKey(t))
+	hello.Payload["device_id"] = "phone"
+
+	session := dialRelay(t, server)
+	session.send(hello)
+	session.expectError("INVALID_SIGNATURE")
+	session.expectClosed()
+}
+
+func TestReplayedHelloIsRejected(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	hello := helloMessage("alice", "laptop", newSigningKey(t))
+
+	first := dialRelay(t, server)
+	first.send(hello)
+	first.expect(MessageTypeServerHello)
+
+	replay := dialRelay(t, server)
+	replay.send(hello)
+	replay.expectError("STALE_HELLO")
+	replay.expectClosed()
+
+	if got := server.Stats().SessionsReplaced; got != 0 {
+		t.Errorf("SessionsReplaced = %d, want 0", got)
+	}
+}
+
+func TestOldHelloIsRejected(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	key := newSigningKey(t)
+
+	hello := ClientHelloPayload{Nonce: newHelloNonce(), DeviceID: "laptop"}
+	msg := newMessage(MessageTypeClientHello, "alice", "", hello)
+	msg.Timestamp = time.Now().Add(-2 * helloMaxAge).Unix()
+	signClientHello(key, &hello, "alice", msg.Timestamp)
+	msg.Payload, _ = EncodePayload(hello)
+
+	session := dialRelay(t, server)
+	session.send(msg)
+	session.expectError("STALE_HELLO")
+}
+
+// waitFor polls until cond holds, failing the test if it does not in time
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplacedClientReconnects(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	key := newSigningKey(t)
+
+	replaced := make(chan struct{}, 1)
+	client := NewClient(ClientOptions{
+		ServerURL:      "memory://relay",
+		UserID:         "alice",
+		DeviceID:       "laptop",
+		SigningKey:     key,
+		Transport:      &MemoryTransport{Server: server},
+		ReconnectDelay: 10 * time.Millisecond,
+		ConnectionHandler: func(event ConnectionEvent) {
+			if errors.Is(event.Error, ErrSessionReplaced) {
+				select {
+				case replaced <- struct{}{}:
+				default:
+				}
+			}
+		},
+	})
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, "the server hello", func() bool { return client.ProtocolVersion() != 0 })
+
+	// A copy of the same install connecting replaces the client's session.
+	// The client must come back, and in turn replace the copy, rather than
+	// give up.
+	other := connectAs(t, server, "alice", "laptop", key)
+	select {
+	case <-replaced:
+	case <-time.After(testTimeout):
+		t.Fatal("client was not told its session was replaced")
+	}
+	other.expectError(errorCodeSessionReplaced)
+}
+
+func TestHelloClaimingRelayNameIsRefused(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+
+	session := dialRelay(t, server)
+	session.send(helloMessage("server", "laptop", nil))
+	session.expectError("INVALID_USER_ID")
+}
+
+func TestUserAcksCarryOnlyUserStatuses(t *testing.T) {
+	tests := []struct {
+		status    string
+		forwarded bool
+	}{
+		{"delivered", true},
+		{"read", true},
+		{"queued", false},
+		{"failed", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			server := newTestRelay(t, ServerOptions{})
+			alice := connectAs(t, server, "alice", "laptop", nil)
+			bob := connectAs(t, server, "bob", "phone", nil)
+
+			ack := newMessage(MessageTypeAck, "bob", "alice", AckPayload{
+				MessageID: "msg_1",
+				Recipient: "bob",
+				Status:    tt.status,
+			})
+			bob.send(ack)
+
+			if !tt.forwarded {
+				bob.expectError("INVALID_ACK")
+				// A chat sent after the ack arrives first if the ack was dropped
+				bob.send(newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "hi"}))
+				for {
+					switch alice.next().Type {
+					case MessageTypeAck:
+						t.Fatal("ack was forwarded")
+					case MessageTypeChat:
+						return
+					}
+				}
+			}
+			got := alice.expect(MessageTypeAck)
+			if got.ID != ack.ID || got.From != "bob" {
+				t.Errorf("got ack %s from %s, want %s from bob", got.ID, got.From, ack.ID)
+			}
+		})
+	}
+}
+
+func TestOfflineRecipientIsQueuedThenDelivered(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	alice := connectAs(t, server, "alice", "laptop", nil)
+
+	msg := newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "hi"})
+	alice.send(msg)
+	if got := alice.expectStatus(msg.ID); got != "queued" {
+		t.Fatalf("status while bob is offline = %s, want queued", got)
+	}
+
+	bob := connectAs(t, server, "bob", "phone", nil)
+	if got := bob.expect(MessageTypeChat); got.ID != msg.ID {
+		t.Fatalf("bob got message %s, want %s", got.ID, msg.ID)
+	}
+	if got := alice.expectStatus(msg.ID); got != "delivered" {
+		t.Errorf("status once bob connected = %s, want delivered", got)
+	}
+}
+
+func TestDeliveryModes(t *testing.T) {
+	for _, mode := range []DeliveryMode{DeliveryStoreAndForward, DeliveryImmediate} {
+		t.Run(string(mode), func(t *testing.T) {
+			server := newTestRelay(t, ServerOptions{DeliveryMode: mode})
+			alice := dialRelay(t, server)
+			alice.send(helloMessage("alice", "laptop", nil))
+			var hello ServerHelloPayload
+			if err := alice.expect(MessageTypeServerHello).DecodePayload(&hello); err != nil {
+				t.Fatalf("DecodePayload: %v", err)
+			}
+			if hello.DeliveryMode != mode {
+				t.Errorf("hello advertises %s, want %s", hello.DeliveryMode, mode)
+			}
+
+			offline := newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "while offline"})
+			alice.send(offline)
+			wantStored := 1
+			if mode == DeliveryImmediate {
+				alice.expectError("RECIPIENT_OFFLINE")
+				wantStored = 0
+			} else if got := alice.expectStatus(offline.ID); got != "queued" {
+				t.Fatalf("status while bob is offline = %s, want queued", got)
+			}
+			if stored := server.offline.count("bob"); stored != wantStored {
+				t.Errorf("relay stored %d messages for bob, want %d", stored, wantStored)
+			}
+
+			bob := connectAs(t, server, "bob", "phone", nil)
+			online := newMessage(MessageTypeChat, "alice", "bob", ChatPayload{Content: "once online"})
+			alice.send(online)
+			want := []string{offline.ID, online.ID}
+			if mode == DeliveryImmediate {
+				want = want[1:]
+			}
+			for _, id := range want {
+				if got := bob.expect(MessageTypeChat); got.ID != id {
+					t.Fatalf("bob got message %s, want %s", got.ID, id)
+				}
+			}
+		})
+	}
+
+	if _, err := NewServer(ServerOptions{DeliveryMode: "sometimes"}); err == nil {
+		t.Error("NewServer accepted an unknown delivery mode")
+	}
+}
+
+func TestUserIDsAreNormalized(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	alice := connectAs(t, server, " Alice ", "laptop", nil)
+	bob := connectAs(t, server, "bob", "phone", nil)
+
+	msg := newMessage(MessageTypeChat, "bob", "ALICE", ChatPayload{Content: "hi"})
+	bob.send(msg)
+	if got := alice.expect(MessageTypeChat); got.ID != msg.ID {
+		t.Errorf("alice got message %s, want %s", got.ID, msg.ID)
+	}
+}
+
+func TestInvalidUserIDsAreRejected(t *testing.T) {
+	t.Run("hello", func(t *testing.T) {
+		server := newTestRelay(t, ServerOptions{})
+		session := dialRelay(t, server)
+		session.send(helloMessage("bob smith", "laptop", nil))
+		session.expectError("INVALID_USER_ID")
+	})
+
+	t.Run("recipient", func(t *testing.T) {
+		server := newTestRelay(t, ServerOptions{})
+		bob := connectAs(t, server, "bob", "phone", nil)
+		bob.send(newMessage(MessageTypeChat, "bob", "a!", ChatPayload{Content: "hi"}))
+		bob.expectError("INVALID_USER_ID")
+	})
+
+	t.Run("before hello", func(t *testing.T) {
+		server := newTestRelay(t, ServerOptions{})
+		session := dialRelay(t, server)
+		session.send(newMessage(MessageTypeChat, "bob", "alice", ChatPayload{Content: "hi"}))
+		session.expectError("NOT_IDENTIFIED")
+	})
+}
+
+func TestStatsStayConsistentWhileRouting(t *testing.T) {
+	const senders, perSender = 4, 50
+	server := newTestRelay(t, ServerOptions{})
+	newTestClient(t, server, "bob", nil)
+
+	sessions := make([]*rawSession, senders)
+	for i := range sessions {
+		sessions[i] = connectAs(t, server, fmt.Sprintf("sender%d", i), "laptop", nil)
+	}
+	if got := server.Stats().ConnectedClients; got != senders+1 {
+		t.Fatalf("ConnectedClients = %d, want %d", got, senders+1)
+	}
+
+	// Read the stats, directly and over HTTP, while the senders route
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		var last int64
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			stats := server.Stats()
+			if stats.MessagesRouted < last {
+				t.Errorf("MessagesRouted went from %d back to %d", last, stats.MessagesRouted)
+			}
+			last = stats.MessagesRouted
+			if stats.ConnectedClients != senders+1 {
+				t.Errorf("ConnectedClients = %d while routing, want %d", stats.ConnectedClients, senders+1)
+			}
+
+			recorder := httptest.NewRecorder()
+			server.handleStats(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+			var served struct {
+				MessagesRouted int64
+				Uptime         float64
+			}
+			if err := json.NewDecoder(recorder.Body).Decode(&served); err != nil {
+				t.Errorf("decoding /stats: %v", err)
+			} else if served.MessagesRouted < last || served.Uptime <= 0 {
+				t.Errorf("/stats served %+v after %d routed", served, last)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(from string, conn Conn) {
+			defer wg.Done()
+			for n := 0; n < perSender; n++ {
+				data, _ := json.Marshal(newMessage(MessageTypeChat, from, "bob", ChatPayload{Content: "hi"}))
+				if err := conn.WriteMessage(data); err != nil {
+					t.Errorf("WriteMessage: %v", err)
+					return
+				}
+			}
+		}(fmt.Sprintf("sender%d", i), session.conn)
+	}
+	wg.Wait()
+	waitFor(t, "every message to be routed", func() bool { return server.Stats().MessagesRouted == senders*perSender })
+	close(done)
+	<-readerDone
+
+	// Disconnects are counted without reading /stats
+	for _, session := range sessions {
+		session.conn.Close()
+	}
+	waitFor(t, "the senders to be removed", func() bool { return server.Stats().ConnectedClients == 1 })
+}
@@ -0,0 +1,112 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// ConnectionStatus is whether a client is connected to its relay
+type ConnectionStatus string
+
+const (
+	StatusDisconnected ConnectionStatus = "disconnected"
+	StatusConnected    ConnectionStatus = "connected"
+	// StatusReconnecting means a lost connection is being retried
+	StatusReconnecting ConnectionStatus = "reconnecting"
+)
+
+// ConnectionState describes a client's connection in more detail than
+// IsConnected
+type ConnectionState struct {
+	Status ConnectionStatus
+	Server string // URL of the relay
+
+	// ReconnectAttempt is the attempt under way while reconnecting, or the
+	// last one made after giving up; 0 once connected
+	ReconnectAttempt int
+
+	// LastError is why the last connection was lost or attempt failed. It
+	// is kept after reconnecting.
+	LastError error
+
+	// ConnectedSince is when the current connection was made, zero unless
+	// connected
+	ConnectedSince time.Time
+}
+
+// Connected reports whether the state is connected
+func (s ConnectionState) Connected() bool {
+	return s.Status == StatusConnected
+}
+
+// stateTracker holds a client's connection state and pushes every change
+// to subscribers. Each subscriber channel holds only the latest state, so
+// a slow reader skips states rather than blocking the client.
+type stateTracker struct {
+	mu          sync.Mutex
+	state       ConnectionState
+	subscribers []chan ConnectionState
+	closed      bool
+}
+
+// get returns the current state
+func (t *stateTracker) get() ConnectionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state
+}
+
+// update changes the state and pushes it to subscribers
+func (t *stateTracker) update(change func(state *ConnectionState)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	change(&t.state)
+	for _, ch := range t.subscribers {
+		push(ch, t.state)
+	}
+}
+
+// subscribe returns a channel receiving the current state and every change.
+// The channel is closed when the tracker is.
+func (t *stateTracker) subscribe() <-chan ConnectionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan ConnectionState, 1)
+	ch <- t.state
+	if t.closed {
+		close(ch)
+		return ch
+	}
+	t.subscribers = append(t.subscribers, ch)
+	return ch
+}
+
+// close closes every subscriber channel; later changes are ignored
+func (t *stateTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for _, ch := range t.subscribers {
+		close(ch)
+	}
+	t.subscribers = nil
+}
+
+// push replaces a state the subscriber has not read yet with state
+func push(ch chan ConnectionState, state ConnectionState) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- state
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// dialQueue hands out the connections the test queues, one per dial,
+// blocking each dial until one is queued
+type dialQueue chan Conn
+
+func (q dialQueue) Dial(ctx context.Context, serverURL string) (Conn, error) {
+	select {
+	case conn := <-q:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// nextState waits for a state on states that satisfies cond, skipping
+// states pushed before it
+func nextState(t *testing.T, states <-chan ConnectionState, what string, cond func(ConnectionState) bool) ConnectionState {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case state, ok := <-states:
+			if !ok {
+				t.Fatalf("state subscription closed before %s", what)
+			}
+			if cond(state) {
+				return state
+			}
+		case <-deadline:
+			t.Fatalf("no state %s was pushed", what)
+		}
+	}
+}
+
+func TestStateFollowsReconnection(t *testing.T) {
+	dials := make(dialQueue, 1)
+	client := NewClient(ClientOptions{
+		ServerURL:         "memory://relay",
+		UserID:            "alice",
+		Transport:         dials,
+		ReconnectDelay:    10 * time.Millisecond,
+		ConnectionTimeout: testTimeout,
+	})
+	defer client.Close()
+	states := client.SubscribeState()
+
+	if state := <-states; state.Status != StatusDisconnected || state.Server != "memory://relay" {
+		t.Errorf("first state = %+v, want disconnected from memory://relay", state)
+	}
+
+	conn, far := NewPipe()
+	dials <- conn
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	nextState(t, states, "connected", ConnectionState.Connected)
+	if state := client.State(); !state.Connected() || state.ConnectedSince.IsZero() {
+		t.Errorf("State after connecting = %+v, want connected with a start time", state)
+	}
+
+	// Losing the connection starts a reconnect, whose dial waits for the
+	// next queued connection
+	far.Close()
+	reconnecting := nextState(t, states, "reconnecting", func(state ConnectionState) bool {
+		return state.Status == StatusReconnecting
+	})
+	if reconnecting.ReconnectAttempt != 1 || reconnecting.LastError == nil || !reconnecting.ConnectedSince.IsZero() {
+		t.Errorf("state while reconnecting = %+v, want attempt 1 with the error and no start time", reconnecting)
+	}
+	if state := client.State(); state.Status != StatusReconnecting {
+		t.Errorf("State while reconnecting = %s, want reconnecting", state.Status)
+	}
+
+	conn, far = NewPipe()
+	defer far.Close()
+	dials <- conn
+	reconnected := nextState(t, states, "connected again", ConnectionState.Connected)
+	if reconnected.ReconnectAttempt != 0 || reconnected.LastError == nil {
+		t.Errorf("state after reconnecting = %+v, want attempt 0 keeping the last error", reconnected)
+	}
+	if state := client.State(); !state.Connected() {
+		t.Errorf("State after reconnecting = %s, want connected", state.Status)
+	}
+
+	client.Close()
+	nextState(t, states, "disconnected", func(state ConnectionState) bool {
+		return state.Status == StatusDisconnected
+	})
+	if _, ok := <-states; ok {
+		t.Error("the subscription stayed open after Close")
+	}
+}
+
+func TestSlowStateSubscriberGetsLatestState(t *testing.T) {
+	var tracker stateTracker
+	slow := tracker.subscribe()
+
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		for attempt := 1; attempt <= 100; attempt++ {
+			tracker.update(func(state *ConnectionState) {
+				state.Status = StatusReconnecting
+				state.ReconnectAttempt = attempt
+			})
+		}
+		tracker.update(func(state *ConnectionState) {
+			state.Status = StatusConnected
+			state.ReconnectAttempt = 0
+		})
+	}()
+	select {
+	case <-updated:
+	case <-time.After(testTimeout):
+		t.Fatal("updates blocked on a subscriber that is not reading")
+	}
+
+	if state := <-slow; !state.Connected() {
+		t.Errorf("slow subscriber got %+v, want the latest state, connected", state)
+	}
+	select {
+	case state := <-slow:
+		t.Errorf("slow subscriber got %+v after the latest state", state)
+	default:
+	}
+	if state := tracker.get(); !state.Connected() {
+		t.Errorf("get = %+v, want connected", state)
+	}
+}
@@ -0,0 +1,260 @@
+package network
+
+import (
+	"log"
+	"sync"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// Offline sync message types
+const (
+	messageTypeSyncRequest = "sync_request"
+	messageTypeSyncStatus  = "sync_status"
+)
+
+// syncBatchSize is how many queued messages a client asks for at a time,
+// and the most a relay hands over per request
+const syncBatchSize = 50
+
+// SyncRequestPayload asks the relay for up to Limit queued messages, those
+// sent by the Prefer users first
+type SyncRequestPayload struct {
+	Prefer []string `json:"prefer,omitempty"`
+	Limit  int      `json:"limit"`
+}
+
+// SyncStatusPayload follows each batch of queued messages, and answers a
+// hello that asked for selective sync before any are sent
+type SyncStatusPayload struct {
+	Delivered int `json:"delivered"`
+	Remaining int `json:"remaining"`
+}
+
+// SyncProgress reports how many of the messages queued while a client was
+// offline it has received
+type SyncProgress struct {
+	Delivered int
+	Total     int
+}
+
+// Done reports whether every queued message has been received
+func (p SyncProgress) Done() bool {
+	return p.Delivered >= p.Total
+}
+
+// SyncProgressHandler is called after each batch of queued messages
+type SyncProgressHandler func(progress SyncProgress)
+
+// offlineStore holds messages for offline recipients, partitioned by
+// recipient and then by sender, which is the chat they belong to. Each
+// partition is oldest first, and seq orders messages across partitions.
+type offlineStore struct {
+	mu     sync.Mutex
+	queues map[string]map[string][]*RoutedMessage
+	seq    uint64
+}
+
+func newOfflineStore() *offlineStore {
+	return &offlineStore{queues: make(map[string]map[string][]*RoutedMessage)}
+}
+
+// push queues a message for its recipient
+func (s *offlineStore) push(routedMsg *RoutedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	routedMsg.seq = s.seq
+
+	partitions := s.queues[routedMsg.To]
+	if partitions == nil {
+		partitions = make(map[string][]*RoutedMessage)
+		s.queues[routedMsg.To] = partitions
+	}
+	partitions[routedMsg.From] = append(partitions[routedMsg.From], routedMsg)
+}
+
+// take removes and returns up to limit of a recipient's oldest messages,
+// all of them if limit is 0. Messages from the preferred senders come
+// first, then the rest.
+func (s *offlineStore) take(recipient string, prefer []string, limit int) []*RoutedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partitions := s.queues[recipient]
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	preferred := make(map[string]bool, len(prefer))
+	for _, sender := range prefer {
+		preferred[sender] = true
+	}
+	var others []string
+	for sender := range partitions {
+		if !preferred[sender] {
+			others = append(others, sender)
+		}
+	}
+
+	var taken []*RoutedMessage
+	for _, senders := range [][]string{prefer, others} {
+		for limit == 0 || len(taken) < limit {
+			oldest := ""
+			for _, sender := range senders {
+				queue := partitions[sender]
+				if len(queue) > 0 && (oldest == "" || queue[0].seq < partitions[oldest][0].seq) {
+					oldest = sender
+				}
+			}
+			if oldest == "" {
+				break
+			}
+
+			taken = append(taken, partitions[oldest][0])
+			partitions[oldest] = partitions[oldest][1:]
+			if len(partitions[oldest]) == 0 {
+				delete(partitions, oldest)
+			}
+		}
+	}
+
+	if len(partitions) == 0 {
+		delete(s.queues, recipient)
+	}
+	return taken
+}
+
+// putBack returns messages removed by take that could not be delivered.
+// take removes the oldest messages of each partition in order, so they go
+// back in front as they are.
+func (s *offlineStore) putBack(recipient string, msgs []*RoutedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partitions := s.queues[recipient]
+	if partitions == nil {
+		partitions = make(map[string][]*RoutedMessage)
+		s.queues[recipient] = partitions
+	}
+
+	returned := make(map[string][]*RoutedMessage)
+	for _, routedMsg := range msgs {
+		returned[routedMsg.From] = append(returned[routedMsg.From], routedMsg)
+	}
+	for sender, queue := range returned {
+		partitions[sender] = append(queue, partitions[sender]...)
+	}
+}
+
+// count returns how many messages are queued for a recipient
+func (s *offlineStore) count(recipient string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, queue := range s.queues[recipient] {
+		n += len(queue)
+	}
+	return n
+}
+
+// depths returns how many messages are queued for each recipient
+func (s *offlineStore) depths() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	depths := make(map[string]int, len(s.queues))
+	for recipient, partitions := range s.queues {
+		for _, queue := range partitions {
+			depths[recipient] += len(queue)
+		}
+	}
+	return depths
+}
+
+// handleSyncRequest hands over a batch of the client's queued messages,
+// followed by a sync status
+func (c *ServerClient) handleSyncRequest(msg *Message) {
+	if c.UserID == "" {
+		c.sendError("NOT_IDENTIFIED", "client_hello required before syncing", msg.ID)
+		return
+	}
+
+	var request SyncRequestPayload
+	if err := msg.DecodePayload(&request); err != nil {
+		c.sendError("INVALID_PAYLOAD", err.Error(), msg.ID)
+		return
+	}
+	if request.Limit <= 0 || request.Limit > syncBatchSize {
+		request.Limit = syncBatchSize
+	}
+	prefer := make([]string, 0, len(request.Prefer))
+	for _, userID := range request.Prefer {
+		prefer = append(prefer, models.NormalizeUserID(userID))
+	}
+
+	delivered := c.Server.deliverOfflineMessages(c, prefer, request.Limit)
+	c.sendSyncStatus(delivered)
+}
+
+// sendSyncStatus tells the client how many queued messages it was just
+// sent and how many are left
+func (c *ServerClient) sendSyncStatus(delivered int) {
+	status := newMessage(messageTypeSyncStatus, "server", c.UserID, SyncStatusPayload{
+		Delivered: delivered,
+		Remaining: c.Server.offline.count(c.UserID),
+	})
+
+	select {
+	case c.Send <- status:
+	default:
+		log.Printf("Failed to send sync status to client %s", c.ID)
+	}
+}
+
+// handleSyncStatus reports progress and asks for the next batch until the
+// relay has nothing left
+func (c *Client) handleSyncStatus(msg *Message) {
+	var status SyncStatusPayload
+	if err := msg.DecodePayload(&status); err != nil {
+		log.Printf("Ignoring malformed sync status from %s: %v", c.serverURL, err)
+		return
+	}
+
+	c.connMutex.Lock()
+	c.syncDelivered += status.Delivered
+	progress := SyncProgress{
+		Delivered: c.syncDelivered,
+		Total:     c.syncDelivered + status.Remaining,
+	}
+	c.connMutex.Unlock()
+
+	if c.syncHandler != nil {
+		c.syncHandler(progress)
+	}
+	if progress.Done() {
+		if progress.Total > 0 {
+			log.Printf("Received %d messages queued on %s", progress.Total, c.serverURL)
+		}
+		return
+	}
+
+	if err := c.requestSync(); err != nil {
+		log.Printf("Failed to request queued messages from %s: %v", c.serverURL, err)
+	}
+}
+
+// requestSync asks the relay for the next batch of queued messages, those
+// of the priority chats first
+func (c *Client) requestSync() error {
+	var prefer []string
+	if c.syncPriority != nil {
+		prefer = c.syncPriority()
+	}
+	return c.Send(newMessage(messageTypeSyncRequest, c.userID, "server", SyncRequestPayload{
+		Prefer: prefer,
+		Limit:  syncBatchSize,
+	}))
+}
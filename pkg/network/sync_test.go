@@ -0,0 +1,133 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queuedIDs returns the IDs of msgs in order
+func queuedIDs(msgs []*RoutedMessage) string {
+	ids := make([]string, len(msgs))
+	for i, routedMsg := range msgs {
+		ids[i] = routedMsg.Message.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestOfflineStoreTakesPreferredSendersFirst(t *testing.T) {
+	store := newOfflineStore()
+	for i, from := range []string{"bob", "carol", "bob", "dave", "carol", "bob"} {
+		store.push(&RoutedMessage{
+			Message: &Message{ID: fmt.Sprintf("m%d", i+1)},
+			From:    from,
+			To:      "alice",
+		})
+	}
+	store.push(&RoutedMessage{Message: &Message{ID: "other"}, From: "bob", To: "erin"})
+
+	if got := store.count("alice"); got != 6 {
+		t.Fatalf("count = %d, want 6", got)
+	}
+
+	first := store.take("alice", []string{"carol"}, 3)
+	if got, want := queuedIDs(first), "m2,m5,m1"; got != want {
+		t.Errorf("first batch = %s, want carol's then the oldest of the rest %s", got, want)
+	}
+
+	// Messages that could not be delivered are taken again in order
+	store.putBack("alice", first)
+	if got, want := queuedIDs(store.take("alice", nil, 0)), "m1,m2,m3,m4,m5,m6"; got != want {
+		t.Errorf("everything = %s, want %s", got, want)
+	}
+	if got := store.count("alice"); got != 0 {
+		t.Errorf("count after taking everything = %d, want 0", got)
+	}
+	if depths := store.depths(); len(depths) != 1 || depths["erin"] != 1 {
+		t.Errorf("depths = %v, want only erin's message", depths)
+	}
+}
+
+func TestSelectiveSyncFetchesOpenChatsFirst(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+
+	// More than a batch from bob, then a few from carol, whose chat is open
+	var want []string
+	for _, sender := range []struct {
+		userID string
+		count  int
+	}{{"bob", syncBatchSize + 10}, {"carol", 5}} {
+		session := connectAs(t, server, sender.userID, "phone", nil)
+		for i := 0; i < sender.count; i++ {
+			msg := newMessage(MessageTypeChat, sender.userID, "alice", ChatPayload{Content: "hi"})
+			session.send(msg)
+			if got := session.expectStatus(msg.ID); got != "queued" {
+				t.Fatalf("status while alice is offline = %s, want queued", got)
+			}
+			want = append(want, msg.ID)
+		}
+	}
+
+	var mu sync.Mutex
+	var from []string
+	var progress []SyncProgress
+	done := make(chan struct{})
+	client := NewClient(ClientOptions{
+		ServerURL:    "memory://relay",
+		UserID:       "alice",
+		Transport:    &MemoryTransport{Server: server},
+		SyncPriority: func() []string { return []string{"carol"} },
+		SyncProgressHandler: func(p SyncProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress = append(progress, p)
+			if p.Done() && p.Total > 0 {
+				close(done)
+			}
+		},
+		MessageHandler: func(msg *Message) error {
+			if msg.Type == MessageTypeChat {
+				mu.Lock()
+				from = append(from, msg.From)
+				mu.Unlock()
+			}
+			return nil
+		},
+	})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("the sync did not finish")
+	}
+	waitFor(t, "every queued message", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(from) == len(want)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(from[:5], ","); got != "carol,carol,carol,carol,carol" {
+		t.Errorf("first messages from %s, want carol's before the backfill", got)
+	}
+
+	total := len(want)
+	wantProgress := []SyncProgress{{0, total}, {syncBatchSize, total}, {total, total}}
+	if len(progress) != len(wantProgress) {
+		t.Fatalf("progress = %v, want %v", progress, wantProgress)
+	}
+	for i := range wantProgress {
+		if progress[i] != wantProgress[i] {
+			t.Errorf("progress[%d] = %+v, want %+v", i, progress[i], wantProgress[i])
+		}
+	}
+	if got := server.offline.count("alice"); got != 0 {
+		t.Errorf("%d messages left queued, want 0", got)
+	}
+}
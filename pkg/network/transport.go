@@ -0,0 +1,156 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a message-oriented connection between a client and a relay
+type Conn interface {
+	// ReadMessage blocks until a complete message is available
+	ReadMessage() ([]byte, error)
+	// WriteMessage sends a complete message
+	WriteMessage(data []byte) error
+	// WritePing sends a keepalive that the peer answers at the transport level
+	WritePing() error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// Transport establishes client connections to a relay
+type Transport interface {
+	Dial(ctx context.Context, serverURL string) (Conn, error)
+}
+
+// WebSocketTransport dials relays over WebSocket
+type WebSocketTransport struct {
+	HandshakeTimeout time.Duration
+
+	// Proxy picks the HTTP CONNECT or SOCKS5 proxy to tunnel through.
+	// TLS to the relay runs inside the tunnel. Nil uses
+	// ProxyFromEnvironment.
+	Proxy ProxyFunc
+
+	// TLSConfig holds the TLS settings of wss relays, such as trusted
+	// roots or a certificate check of its own. Nil uses the defaults.
+	TLSConfig *tls.Config
+
+	// TLSServerName is the name the relay's certificate must be valid for,
+	// and is sent as SNI, when it differs from the host dialed, such as
+	// when dialing a load balancer or an IP address. Empty uses the host.
+	TLSServerName string
+}
+
+// Dial connects to a relay, accepting http(s) and ws(s) URLs
+func (t *WebSocketTransport) Dial(ctx context.Context, serverURL string) (Conn, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidServerURL, err)
+	}
+
+	// Convert HTTP(S) to WS(S)
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+		// Already correct
+	default:
+		return nil, fmt.Errorf("%w: unsupported URL scheme %q", ErrInvalidServerURL, u.Scheme)
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = t.HandshakeTimeout
+	if dialer.HandshakeTimeout == 0 {
+		dialer.HandshakeTimeout = 10 * time.Second
+	}
+	dialer.Proxy = ProxyFromEnvironment
+	if t.Proxy != nil {
+		dialer.Proxy = t.Proxy
+	}
+	if t.TLSConfig != nil || t.TLSServerName != "" {
+		tlsConfig := &tls.Config{}
+		if t.TLSConfig != nil {
+			tlsConfig = t.TLSConfig.Clone()
+		}
+		if t.TLSServerName != "" {
+			tlsConfig.ServerName = t.TLSServerName
+		}
+		dialer.TLSClientConfig = tlsConfig
+	}
+
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		if isTimeout(err) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %v", ErrHandshakeTimeout, err)
+		}
+		return nil, err
+	}
+
+	return newWebSocketConn(conn), nil
+}
+
+// webSocketConn adapts a gorilla WebSocket connection to Conn
+type webSocketConn struct {
+	conn *websocket.Conn
+}
+
+func newWebSocketConn(conn *websocket.Conn) *webSocketConn {
+	return &webSocketConn{conn: conn}
+}
+
+func (w *webSocketConn) ReadMessage() ([]byte, error) {
+	_, data, err := w.conn.ReadMessage()
+	return data, err
+}
+
+func (w *webSocketConn) WriteMessage(data []byte) error {
+	return w.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (w *webSocketConn) WritePing() error {
+	return w.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// SetPongHandler calls handler for every pong read from the peer
+func (w *webSocketConn) SetPongHandler(handler func()) {
+	w.conn.SetPongHandler(func(string) error {
+		handler()
+		return nil
+	})
+}
+
+func (w *webSocketConn) SetReadDeadline(t time.Time) error {
+	return w.conn.SetReadDeadline(t)
+}
+
+func (w *webSocketConn) SetWriteDeadline(t time.Time) error {
+	return w.conn.SetWriteDeadline(t)
+}
+
+// Close sends a close frame before closing the underlying connection
+func (w *webSocketConn) Close() error {
+	w.conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(time.Second))
+	return w.conn.Close()
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isUnexpectedClose reports whether err is an abnormal connection closure
+// worth logging, as opposed to a normal shutdown
+func isUnexpectedClose(err error) bool {
+	return websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure)
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// tlsRelay serves a relay over TLS with httptest's certificate, which is
+// valid for example.com and the loopback IPs but not for localhost, and
+// returns its port and a configuration trusting it
+func tlsRelay(t *testing.T, server *Server) (string, *tls.Config) {
+	t.Helper()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(server.handleWebSocket))
+	t.Cleanup(secure.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(secure.Certificate())
+	_, port, _ := net.SplitHostPort(secure.Listener.Addr().String())
+	return port, &tls.Config{RootCAs: roots}
+}
+
+func TestTLSServerNameIsVerifiedInsteadOfDialedHost(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	port, trusted := tlsRelay(t, server)
+	tests := []struct {
+		name       string
+		host       string
+		serverName string
+		wantErr    bool
+	}{
+		{"host the certificate is valid for", "127.0.0.1", "", false},
+		{"host the certificate is not valid for", "localhost", "", true},
+		{"configured name the certificate is valid for", "localhost", "example.com", false},
+		{"configured name the certificate is not valid for", "127.0.0.1", "relay.example.org", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &WebSocketTransport{TLSConfig: trusted, TLSServerName: tt.serverName}
+			conn, err := transport.Dial(context.Background(), "wss://"+tt.host+":"+port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial: %v, want error %v", err, tt.wantErr)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+		})
+	}
+
+	if trusted.ServerName != "" {
+		t.Errorf("the shared TLS configuration was changed to %q", trusted.ServerName)
+	}
+}
+
+func TestTLSServerNameKeepsOwnCertificateCheck(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{IdentityPath: filepath.Join(t.TempDir(), "identity.pem")})
+	port, trusted := tlsRelay(t, server)
+	var checked atomic.Int32
+	trusted.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		checked.Add(1)
+		return nil
+	}
+
+	client := NewClient(ClientOptions{
+		ServerURL:        "wss://localhost:" + port,
+		UserID:           "alice",
+		Transport:        &WebSocketTransport{TLSConfig: trusted, TLSServerName: "example.com"},
+		RelayFingerprint: server.Fingerprint(),
+	})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	waitFor(t, "the server hello", func() bool { return client.ProtocolVersion() != 0 })
+
+	if checked.Load() != 1 {
+		t.Errorf("the configured certificate check ran %d times, want once", checked.Load())
+	}
+}
+
+func TestClientOptionsSetTLSServerName(t *testing.T) {
+	client := NewClient(ClientOptions{ServerURL: "wss://10.0.0.5", UserID: "alice", TLSServerName: "relay.example.com"})
+	transport, ok := client.transport.(*WebSocketTransport)
+	if !ok || transport.TLSServerName != "relay.example.com" {
+		t.Errorf("default transport = %+v, want it to check relay.example.com", client.transport)
+	}
+}
@@ -0,0 +1,96 @@
+package network
+
+import "fmt"
+
+// Protocol versions. Each version adds message types on top of the previous one.
+const (
+	// ProtocolVersion1 covers chat messages, acks, errors and presence announcements
+	ProtocolVersion1 = 1
+	// ProtocolVersion2 adds file transfer and presence queries and subscriptions
+	ProtocolVersion2 = 2
+	// ProtocolVersion3 adds selective sync of messages queued while offline
+	ProtocolVersion3 = 3
+	// ProtocolVersion4 adds announcements from the relay operator
+	ProtocolVersion4 = 4
+	// ProtocolVersion5 adds key exchanges between users
+	ProtocolVersion5 = 5
+	// ProtocolVersion6 adds requests to resend messages lost on the way
+	ProtocolVersion6 = 6
+)
+
+// errorCodeVersionMismatch is sent by the relay before it closes a
+// connection whose hello has no protocol version in common with it
+const errorCodeVersionMismatch = "VERSION_MISMATCH"
+
+// VersionRange is an inclusive range of protocol versions
+type VersionRange struct {
+	Min int
+	Max int
+}
+
+// SupportedVersions is the range of protocol versions this package speaks
+var SupportedVersions = VersionRange{Min: ProtocolVersion1, Max: ProtocolVersion6}
+
+func (r VersionRange) String() string {
+	if r.Min == r.Max {
+		return fmt.Sprintf("v%d", r.Min)
+	}
+	return fmt.Sprintf("v%d-v%d", r.Min, r.Max)
+}
+
+// Contains reports whether version is within the range
+func (r VersionRange) Contains(version int) bool {
+	return version >= r.Min && version <= r.Max
+}
+
+// Negotiate returns the highest version supported by both ranges, or false
+// if they do not overlap
+func (r VersionRange) Negotiate(other VersionRange) (int, bool) {
+	low, high := max(r.Min, other.Min), min(r.Max, other.Max)
+	if high < low {
+		return 0, false
+	}
+	return high, true
+}
+
+// orDefault returns the range, or SupportedVersions if it is unset
+func (r VersionRange) orDefault() VersionRange {
+	if r.Min == 0 && r.Max == 0 {
+		return SupportedVersions
+	}
+	return r
+}
+
+// versionRange returns the versions offered by a client hello. Hellos from
+// clients that predate negotiation carry no range and speak version 1.
+func (p *ClientHelloPayload) versionRange() VersionRange {
+	if p.MinVersion == 0 && p.MaxVersion == 0 {
+		return VersionRange{Min: ProtocolVersion1, Max: ProtocolVersion1}
+	}
+	return VersionRange{Min: p.MinVersion, Max: p.MaxVersion}
+}
+
+// minVersionFor returns the protocol version that introduced a message type
+func minVersionFor(msgType string) int {
+	switch msgType {
+	case "file_offer", "file_request", "file_chunk", "file_complete",
+		messageTypePresenceQuery, messageTypePresenceUnsubscribe, messageTypePresenceResponse, messageTypePresenceUpdate:
+		return ProtocolVersion2
+	case messageTypeSyncRequest, messageTypeSyncStatus:
+		return ProtocolVersion3
+	case MessageTypeAnnouncement:
+		return ProtocolVersion4
+	case MessageTypeKeyRequest, MessageTypeKeyBundle, MessageTypeSessionInit:
+		return ProtocolVersion5
+	case MessageTypeResendRequest:
+		return ProtocolVersion6
+	default:
+		return ProtocolVersion1
+	}
+}
+
+// supportsMessage reports whether a peer speaking version may be sent
+// msgType. A version of 0 means it is not known yet and allows everything.
+func supportsMessage(version int, msgType string) bool {
+	return version == 0 || version >= minVersionFor(msgType)
+}
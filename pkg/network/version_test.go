@@ -0,0 +1,129 @@
+package network
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVersionRangeNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   VersionRange
+		want   int
+		wantOK bool
+	}{
+		{"same range", VersionRange{1, 6}, VersionRange{1, 6}, 6, true},
+		{"older peer", VersionRange{1, 6}, VersionRange{1, 3}, 3, true},
+		{"newer peer", VersionRange{1, 4}, VersionRange{2, 9}, 4, true},
+		{"single common version", VersionRange{1, 3}, VersionRange{3, 5}, 3, true},
+		{"peer too new", VersionRange{1, 6}, VersionRange{7, 9}, 0, false},
+		{"peer too old", VersionRange{3, 6}, VersionRange{1, 2}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.a.Negotiate(tt.b)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("%s.Negotiate(%s) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// helloOffering returns a client hello from userID offering versions
+func helloOffering(userID string, versions VersionRange) *Message {
+	return newMessage(MessageTypeClientHello, userID, "", ClientHelloPayload{
+		MinVersion: versions.Min,
+		MaxVersion: versions.Max,
+		Nonce:      newHelloNonce(),
+	})
+}
+
+func TestRelayNegotiatesHighestCommonVersion(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{Versions: VersionRange{Min: ProtocolVersion1, Max: ProtocolVersion4}})
+	tests := []struct {
+		name  string
+		hello *Message
+		want  int
+	}{
+		{"overlapping range", helloOffering("alice", VersionRange{Min: ProtocolVersion2, Max: ProtocolVersion6}), ProtocolVersion4},
+		{"older client", helloOffering("bob", VersionRange{Min: ProtocolVersion1, Max: ProtocolVersion2}), ProtocolVersion2},
+		{"hello without a range", newMessage(MessageTypeClientHello, "carol", "", ClientHelloPayload{Nonce: newHelloNonce()}), ProtocolVersion1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := dialRelay(t, server)
+			session.send(tt.hello)
+			var hello ServerHelloPayload
+			if err := session.expect(MessageTypeServerHello).DecodePayload(&hello); err != nil {
+				t.Fatalf("DecodePayload: %v", err)
+			}
+			if hello.Version != tt.want {
+				t.Errorf("relay chose v%d, want v%d", hello.Version, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayRejectsClientWithoutCommonVersion(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	session := dialRelay(t, server)
+	session.send(helloOffering("alice", VersionRange{Min: 7, Max: 9}))
+
+	session.expectError(errorCodeVersionMismatch)
+	session.expectClosed()
+}
+
+func TestClientWithoutCommonVersionGivesUp(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{Versions: VersionRange{Min: ProtocolVersion1, Max: ProtocolVersion2}})
+	client := NewClient(ClientOptions{
+		ServerURL: "memory://relay",
+		UserID:    "alice",
+		Transport: &MemoryTransport{Server: server},
+		Versions:  VersionRange{Min: ProtocolVersion3, Max: ProtocolVersion6},
+	})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	waitFor(t, "the version mismatch", func() bool { return client.State().LastError != nil })
+	if err := client.State().LastError; !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("LastError = %v, want ErrVersionMismatch", err)
+	}
+	waitFor(t, "the client to disconnect", func() bool { return !client.IsConnected() })
+	if client.ProtocolVersion() != 0 {
+		t.Errorf("ProtocolVersion = %d after a mismatch, want 0", client.ProtocolVersion())
+	}
+}
+
+func TestMessagesAreGatedByVersion(t *testing.T) {
+	server := newTestRelay(t, ServerOptions{})
+	session := dialRelay(t, server)
+	session.send(helloOffering("alice", VersionRange{Min: ProtocolVersion1, Max: ProtocolVersion1}))
+	session.expect(MessageTypeServerHello)
+
+	session.send(newMessage(messageTypePresenceQuery, "alice", "", PresenceQueryPayload{UserIDs: []string{"bob"}}))
+	session.expectError("UNSUPPORTED_MESSAGE")
+
+	tests := []struct {
+		version int
+		msgType string
+		want    bool
+	}{
+		{0, MessageTypeResendRequest, true},
+		{ProtocolVersion1, MessageTypeChat, true},
+		{ProtocolVersion1, messageTypePresenceQuery, false},
+		{ProtocolVersion2, messageTypePresenceQuery, true},
+		{ProtocolVersion3, MessageTypeAnnouncement, false},
+		{ProtocolVersion4, MessageTypeAnnouncement, true},
+		{ProtocolVersion5, MessageTypeResendRequest, false},
+		{ProtocolVersion6, MessageTypeResendRequest, true},
+	}
+	for _, tt := range tests {
+		if got := supportsMessage(tt.version, tt.msgType); got != tt.want {
+			t.Errorf("supportsMessage(%d, %s) = %v, want %v", tt.version, tt.msgType, got, tt.want)
+		}
+	}
+}
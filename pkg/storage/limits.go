@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ErrLimitReached is returned when saving a record would exceed a limit
+var ErrLimitReached = errors.New("storage limit reached")
+
+// evictBatchSize is how many messages one transaction evicts, keeping
+// transactions small when a lowered limit evicts many at once
+const evictBatchSize = 500
+
+// Limits caps what a store holds. Zero fields are unlimited.
+type Limits struct {
+	// Adding a contact beyond MaxContacts fails
+	MaxContacts int
+
+	// A chat keeps at most MaxMessagesPerChat messages. Saving another
+	// evicts its oldest messages, or fails if RejectFullChats is set.
+	MaxMessagesPerChat int
+	RejectFullChats    bool
+
+	// New messages are refused once the database has grown to MaxSize
+	// bytes, as Badger last measured it. The memory store ignores it.
+	MaxSize int64
+}
+
+// Usage is how much a store holds, against its limits
+type Usage struct {
+	Contacts    int
+	Chats       int
+	Messages    int
+	LargestChat int   // Messages in the chat that holds the most
+	Size        int64 // Bytes on disk, 0 for the memory store
+	Limits      Limits
+}
+
+// isNewKey reports whether nothing is stored under key yet
+func isNewKey(txn *badger.Txn, key []byte) (bool, error) {
+	_, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// keysWithPrefix returns the keys under prefix in order, without reading
+// their values
+func keysWithPrefix(txn *badger.Txn, prefix []byte) []string {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var keys []string
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		keys = append(keys, string(it.Item().Key()))
+	}
+	return keys
+}
+
+// MessageCount returns how many messages a chat holds. Chats are counted
+// once and the count kept up to date as messages are saved and evicted, so
+// neither the per-chat limit nor listings scan the chat every time.
+func (s *Storage) MessageCount(chatID string) (int, error) {
+	s.countsMux.Lock()
+	defer s.countsMux.Unlock()
+
+	if count, ok := s.counts[chatID]; ok {
+		return count, nil
+	}
+	var count int
+	err := s.db.View(func(txn *badger.Txn) error {
+		count = len(keysWithPrefix(txn, s.messagePrefix(chatID)))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.counts[chatID] = count
+	return count, nil
+}
+
+// adjustMessageCount adds delta to the count of a chat once messages were
+// saved or evicted. A chat not counted yet is counted when next needed.
+func (s *Storage) adjustMessageCount(chatID string, delta int) {
+	s.countsMux.Lock()
+	defer s.countsMux.Unlock()
+
+	if count, ok := s.counts[chatID]; ok {
+		s.counts[chatID] = max(count+delta, 0)
+	}
+}
+
+// forgetMessageCounts drops the counts of the given chats, or of every chat
+// if none are given, after deletes that do not keep track of them
+func (s *Storage) forgetMessageCounts(chatIDs ...string) {
+	s.countsMux.Lock()
+	defer s.countsMux.Unlock()
+
+	if len(chatIDs) == 0 {
+		clear(s.counts)
+	}
+	for _, chatID := range chatIDs {
+		delete(s.counts, chatID)
+	}
+}
+
+// lockChat locks the chat against other saves until the returned func is
+// called, so no two saves both find room for one more message, and returns
+// the func
+func (s *Storage) lockChat(chatID string) func() {
+	s.countsMux.Lock()
+	lock, ok := s.chatLocks[chatID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.chatLocks[chatID] = lock
+	}
+	s.countsMux.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// checkMessageLimits fails if a new message may not be saved to a chat.
+// The caller holds the chat's lock.
+func (s *Storage) checkMessageLimits(chatID string) error {
+	if limit := s.limits.MaxSize; limit > 0 {
+		lsm, vlog := s.db.Size()
+		if lsm+vlog >= limit {
+			return fmt.Errorf("%w: database has grown to %d MB", ErrLimitReached, (lsm+vlog)>>20)
+		}
+	}
+	if limit := s.limits.MaxMessagesPerChat; limit > 0 && s.limits.RejectFullChats {
+		count, err := s.MessageCount(chatID)
+		if err != nil {
+			return err
+		}
+		if count >= limit {
+			return fmt.Errorf("%w: chat %s holds %d messages", ErrLimitReached, chatID, limit)
+		}
+	}
+	return nil
+}
+
+// checkContactLimitLocked fails if another contact may not be added. The
+// contacts are counted the first time, and the count kept up to date after.
+// The caller holds contactsMux.
+func (s *Storage) checkContactLimitLocked(txn *badger.Txn) error {
+	limit := s.limits.MaxContacts
+	if limit <= 0 {
+		return nil
+	}
+	if s.contacts < 0 {
+		s.contacts = len(keysWithPrefix(txn, []byte("contacts/")))
+	}
+	if s.contacts >= limit {
+		return fmt.Errorf("%w: %d contacts", ErrLimitReached, limit)
+	}
+	return nil
+}
+
+// forgetContactCount drops the count of contacts after a delete that does
+// not keep track of it
+func (s *Storage) forgetContactCount() {
+	s.contactsMux.Lock()
+	defer s.contactsMux.Unlock()
+
+	s.contacts = -1
+}
+
+// evictMessages deletes the oldest messages of a chat beyond the per-chat
+// limit, with their debug info. The oldest are the first in display order,
+// read off the order index, whatever their IDs. The caller holds the chat's
+// lock.
+func (s *Storage) evictMessages(chatID string) error {
+	limit := s.limits.MaxMessagesPerChat
+	if limit <= 0 || s.limits.RejectFullChats {
+		return nil
+	}
+
+	count, err := s.MessageCount(chatID)
+	if err != nil || count <= limit {
+		return err
+	}
+	var ids []string
+	err = s.forEachInOrder(chatID, nil, false, func(msg *models.Message) bool {
+		ids = append(ids, msg.ID)
+		return len(ids) < count-limit
+	})
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	evicted := len(ids)
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), evictBatchSize)]
+		ids = ids[len(batch):]
+		err := s.db.Update(func(txn *badger.Txn) error {
+			for _, id := range batch {
+				if err := s.removeMessageOrder(txn, chatID, id); err != nil {
+					return err
+				}
+				if err := txn.Delete(s.messageKey(chatID, id)); err != nil {
+					return err
+				}
+				if err := txn.Delete(s.debugInfoKey(chatID, id)); err != nil {
+					return err
+				}
+				if err := s.unindexMessage(txn, chatID, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.adjustMessageCount(chatID, -len(batch))
+	}
+	log.Printf("Evicted the %d oldest messages of chat %s, which keeps %d", evicted, chatID, limit)
+	return nil
+}
+
+// Usage counts the contacts, chats and messages stored and measures the
+// database, without reading any record
+func (s *Storage) Usage() (*Usage, error) {
+	usage := &Usage{Limits: s.limits}
+	perChat := make(map[string]int)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		usage.Contacts = len(keysWithPrefix(txn, []byte("contacts/")))
+		for _, key := range keysWithPrefix(txn, []byte("messages/")) {
+			chatKey := strings.TrimPrefix(key, "messages/")
+			if slash := strings.LastIndexByte(chatKey, '/'); slash >= 0 {
+				perChat[chatKey[:slash]]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage.addChats(perChat)
+	lsm, vlog := s.db.Size()
+	usage.Size = lsm + vlog
+	return usage, nil
+}
+
+// addChats adds the message counts of chats to the usage
+func (u *Usage) addChats(perChat map[string]int) {
+	u.Chats = len(perChat)
+	for _, count := range perChat {
+		u.Messages += count
+		u.LargestChat = max(u.LargestChat, count)
+	}
+}
+
+// Usage counts the contacts, chats and messages held
+func (m *MemoryStore) Usage() (*Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	perChat := make(map[string]int)
+	for ref := range m.messages {
+		perChat[ref.chatID]++
+	}
+	usage := &Usage{Contacts: len(m.contacts), Limits: m.limits}
+	usage.addChats(perChat)
+	return usage, nil
+}
+
+// MessageCount returns how many messages a chat holds
+func (m *MemoryStore) MessageCount(chatID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.chatRefsLocked(chatID)), nil
+}
+
+// checkMessageLimitsLocked fails if a new message may not be saved to a
+// chat
+func (m *MemoryStore) checkMessageLimitsLocked(chatID string) error {
+	limit := m.limits.MaxMessagesPerChat
+	if limit <= 0 || !m.limits.RejectFullChats {
+		return nil
+	}
+	if len(m.chatRefsLocked(chatID)) >= limit {
+		return fmt.Errorf("%w: chat %s holds %d messages", ErrLimitReached, chatID, limit)
+	}
+	return nil
+}
+
+// evictMessagesLocked deletes the oldest messages of a chat beyond the
+// per-chat limit, with their debug info. The oldest are the first in
+// display order.
+func (m *MemoryStore) evictMessagesLocked(chatID string) error {
+	limit := m.limits.MaxMessagesPerChat
+	if limit <= 0 || m.limits.RejectFullChats {
+		return nil
+	}
+
+	messages, err := m.chatMessagesLocked(chatID, func(*models.Message) bool { return true })
+	if err != nil || len(messages) <= limit {
+		return err
+	}
+	for _, msg := range messages[:len(messages)-limit] {
+		ref := messageRef{chatID, msg.ID}
+		m.deleteMessageLocked(ref)
+		delete(m.debugInfo, ref)
+	}
+	log.Printf("Evicted the %d oldest messages of chat %s, which keeps %d", len(messages)-limit, chatID, limit)
+	return nil
+}
+
+// chatRefsLocked returns the references of the messages of a chat
+func (m *MemoryStore) chatRefsLocked(chatID string) []messageRef {
+	var refs []messageRef
+	for ref := range m.messages {
+		if ref.chatID == chatID {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// checkContactLimitLocked fails if another contact may not be added
+func (m *MemoryStore) checkContactLimitLocked() error {
+	if limit := m.limits.MaxContacts; limit > 0 && len(m.contacts) >= limit {
+		return fmt.Errorf("%w: %d contacts", ErrLimitReached, limit)
+	}
+	return nil
+}
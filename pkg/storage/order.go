@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// orderIndexBuilt is the config key set once every stored message has an
+// order key, so stores written by older versions are indexed only once
+const orderIndexBuilt = "storage/order_index"
+
+// orderKey holds no value: it places a message of a chat in display order,
+// by sequence, then display time, then ID, as models.Message.Before does,
+// so a page of history is a seek rather than a scan of the chat
+func (s *Storage) orderKey(msg *models.Message) []byte {
+	return []byte(fmt.Sprintf("order/%s/%020d/%020d/%s",
+		msg.ChatID, msg.Sequence, orderTime(msg.DisplayTime()), msg.ID))
+}
+
+func (s *Storage) orderPrefix(chatID string) []byte {
+	return []byte(fmt.Sprintf("order/%s/", chatID))
+}
+
+// orderTime maps a time to an unsigned number in the same order, flipping
+// the sign bit so times before 1970 sort first
+func orderTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()) ^ 1<<63
+}
+
+// parseOrderKey returns the sequence and message ID an order key holds
+func parseOrderKey(prefix, key []byte) (uint64, string, bool) {
+	fields := strings.SplitN(strings.TrimPrefix(string(key), string(prefix)), "/", 3)
+	if len(fields) != 3 {
+		return 0, "", false
+	}
+	seq, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return seq, fields[2], true
+}
+
+// storedMessage reads the message stored under key in txn, returning nil if
+// there is none or it does not decode
+func storedMessage(txn *badger.Txn, key []byte) (*models.Message, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg models.Message
+	var decodeErr error
+	err = item.Value(func(val []byte) error {
+		decodeErr = json.Unmarshal(val, &msg)
+		return nil
+	})
+	if err != nil || decodeErr != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// setMessageOrder records the place of a message being saved in txn,
+// replacing the order key of its stored version if that has moved. It must
+// run before the message itself is written.
+func (s *Storage) setMessageOrder(txn *badger.Txn, msg *models.Message) error {
+	key := s.orderKey(msg)
+	previous, err := storedMessage(txn, s.messageKey(msg.ChatID, msg.ID))
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		if old := s.orderKey(previous); string(old) != string(key) {
+			if err := txn.Delete(old); err != nil {
+				return err
+			}
+		}
+	}
+	return txn.Set(key, nil)
+}
+
+// removeMessageOrder removes the order key of a message being deleted in
+// txn. It must run before the message itself is deleted.
+func (s *Storage) removeMessageOrder(txn *badger.Txn, chatID, messageID string) error {
+	msg, err := storedMessage(txn, s.messageKey(chatID, messageID))
+	if err != nil || msg == nil {
+		return err
+	}
+	return txn.Delete(s.orderKey(msg))
+}
+
+// forEachInOrder calls fn with the stored messages of a chat in display
+// order, or in reverse from the latest if reverse is set, until fn returns
+// false. With from set, it starts at the first message past from in that
+// direction. Order keys whose message is gone or does not decode are
+// skipped.
+func (s *Storage) forEachInOrder(chatID string, from *models.Message, reverse bool, fn func(*models.Message) bool) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		prefix := s.orderPrefix(chatID)
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = reverse
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		seek := prefix
+		if reverse {
+			seek = append(append([]byte(nil), prefix...), 0xff)
+		}
+		var skip []byte
+		if from != nil {
+			seek = s.orderKey(from)
+			skip = seek
+		}
+
+		for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().Key()
+			if skip != nil && string(key) == string(skip) {
+				continue
+			}
+			_, messageID, ok := parseOrderKey(prefix, key)
+			if !ok {
+				continue
+			}
+			item, err := txn.Get(s.messageKey(chatID, messageID))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var msg models.Message
+			ok, err = s.decodeItem(item, &msg)
+			if err != nil {
+				return err
+			}
+			if ok && !fn(&msg) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// buildOrderIndex gives every stored message an order key, once, for stores
+// written before messages had them
+func (s *Storage) buildOrderIndex() error {
+	var built bool
+	err := s.GetConfig(orderIndexBuilt, &built)
+	if err == nil && built {
+		return nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+	err = s.forEachStoredMessage(func(msg *models.Message) error {
+		return batch.Set(s.orderKey(msg), nil)
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+	return s.SaveConfig(orderIndexBuilt, true)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// privateBits are the permission bits of the group and others. Files and
+// directories holding user data must have none of them set.
+const privateBits fs.FileMode = 0077
+
+// LoosePermissions returns path, and everything under it if recursive is
+// set, that the group or others can access. Symlinks are not followed. On
+// Windows, where permission bits do not control access, it finds nothing.
+func LoosePermissions(path string, recursive bool) ([]string, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+
+	var loose []string
+	err := filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 && info.Mode().Perm()&privateBits != 0 {
+			loose = append(loose, p)
+		}
+		if entry.IsDir() && p != path && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return loose, err
+}
+
+// TightenPermissions removes the group and other permissions of paths
+func TightenPermissions(paths []string) error {
+	for _, p := range paths {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(p, info.Mode().Perm()&^privateBits); err != nil {
+			return fmt.Errorf("failed to restrict permissions of %s: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// mode returns the permission bits of path
+func mode(t *testing.T, path string) fs.FileMode {
+	t.Helper()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestLoosePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits do not control access on Windows")
+	}
+
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested")
+	for path, perm := range map[string]fs.FileMode{dir: 0755, nested: 0700} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatalf("Chmod: %v", err)
+		}
+	}
+	files := map[string]fs.FileMode{
+		filepath.Join(dir, "private"):         0600,
+		filepath.Join(dir, "shared"):          0644,
+		filepath.Join(nested, "group"):        0640,
+		filepath.Join(nested, "also-private"): 0600,
+	}
+	for path, perm := range files {
+		if err := os.WriteFile(path, nil, perm); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatalf("Chmod: %v", err)
+		}
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+
+	tests := []struct {
+		recursive bool
+		want      string
+	}{
+		{false, ",shared"},
+		{true, ",nested/group,shared"},
+	}
+	for _, tt := range tests {
+		loose, err := LoosePermissions(dir, tt.recursive)
+		if err != nil {
+			t.Fatalf("LoosePermissions: %v", err)
+		}
+		var got []string
+		for _, path := range loose {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, strings.TrimPrefix(rel, "."))
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("LoosePermissions(recursive %v) = %q, want %q", tt.recursive, strings.Join(got, ","), tt.want)
+		}
+	}
+
+	loose, _ := LoosePermissions(dir, true)
+	if err := TightenPermissions(loose); err != nil {
+		t.Fatalf("TightenPermissions: %v", err)
+	}
+	if loose, _ := LoosePermissions(dir, true); len(loose) != 0 {
+		t.Errorf("still loose after tightening: %v", loose)
+	}
+	for path, want := range map[string]fs.FileMode{dir: 0700, filepath.Join(dir, "shared"): 0600, filepath.Join(nested, "group"): 0600} {
+		if got := mode(t, path); got != want {
+			t.Errorf("%s has mode %o, want %o", path, got, want)
+		}
+	}
+	if _, err := LoosePermissions(filepath.Join(dir, "missing"), true); !os.IsNotExist(err) {
+		t.Errorf("LoosePermissions of a missing path = %v, want not exist", err)
+	}
+}
+
+func TestDatabaseFilesAreRestricted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits do not control access on Windows")
+	}
+
+	dataDir := t.TempDir()
+	open := func() *Storage {
+		t.Helper()
+		store, err := NewStorage(StorageOptions{DataDir: dataDir, UserID: "alice", NoSyncWrites: true})
+		if err != nil {
+			t.Fatalf("NewStorage: %v", err)
+		}
+		return store
+	}
+	store := open()
+	if err := store.SaveMessage(testMessage("m1", 1, "hello")); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	dbDir := filepath.Join(dataDir, "securechat.db")
+	if loose, _ := LoosePermissions(dbDir, true); len(loose) != 0 {
+		t.Errorf("database files other users can access: %v", loose)
+	}
+
+	// Loosened while closed, they are restricted again on opening
+	entries, err := os.ReadDir(dbDir)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("ReadDir = %d entries, %v", len(entries), err)
+	}
+	loosened := filepath.Join(dbDir, entries[0].Name())
+	if err := os.Chmod(loosened, 0644); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	store = open()
+	defer store.Close()
+	if got := mode(t, loosened); got&0077 != 0 {
+		t.Errorf("%s has mode %o after opening", loosened, got)
+	}
+}
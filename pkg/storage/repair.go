@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// quarantinePrefix holds corrupt records moved aside by Repair. Nothing
+// reads them; they are kept for inspection.
+const quarantinePrefix = "quarantine/"
+
+// CorruptRecord is a stored record that could not be decoded
+type CorruptRecord struct {
+	Key string
+	Err error
+}
+
+// RepairReport is the result of checking every stored record
+type RepairReport struct {
+	Checked     int
+	Corrupt     []CorruptRecord
+	Quarantined int // Corrupt records moved aside
+}
+
+// recordDecoders decode the records under each key prefix Repair checks.
+// Search postings and order keys hold no value and are not checked.
+var recordDecoders = []struct {
+	prefix string
+	decode func(val []byte) error
+}{
+	{"messages/", decodeInto[models.Message]},
+	{"contacts/", decodeInto[models.Contact]},
+	{"sessions/", decodeInto[models.Session]},
+	{"identities/", decodeInto[models.Identity]},
+	{"conversations/", decodeInto[models.Conversation]},
+	{"deliveries/", decodeInto[models.PendingDelivery]},
+	{"debug/", decodeInto[models.MessageDebugInfo]},
+	{"outbox/", decodeInto[models.OutboxEntry]},
+	{"audit/", decodeInto[models.AuditEvent]},
+	{"searchdocs/", decodeInto[[]string]},
+	{"config/", decodeInto[json.RawMessage]},
+}
+
+func decodeInto[T any](val []byte) error {
+	var record T
+	return json.Unmarshal(val, &record)
+}
+
+// decodeItem decodes a stored record into dest. A record that does not
+// decode is logged and skipped, reporting false, so one corrupt record
+// does not hide the others; Repair moves such records aside.
+func (s *Storage) decodeItem(item *badger.Item, dest interface{}) (bool, error) {
+	var decodeErr error
+	err := item.Value(func(val []byte) error {
+		decodeErr = json.Unmarshal(val, dest)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if decodeErr != nil {
+		s.skipCorrupt(string(item.Key()), decodeErr)
+		return false, nil
+	}
+	return true, nil
+}
+
+// skipCorrupt records a corrupt record, logging it the first time
+func (s *Storage) skipCorrupt(key string, err error) {
+	s.corruptMux.Lock()
+	defer s.corruptMux.Unlock()
+
+	if _, seen := s.corrupt[key]; seen {
+		return
+	}
+	s.corrupt[key] = err
+	log.Printf("Warning: skipping corrupt record %s: %v", key, err)
+}
+
+// SkippedRecords returns how many corrupt records reads have skipped since
+// the store was opened, counting each record once
+func (s *Storage) SkippedRecords() int {
+	s.corruptMux.Lock()
+	defer s.corruptMux.Unlock()
+
+	return len(s.corrupt)
+}
+
+// Repair checks that every stored record decodes and reports those that do
+// not. With quarantine set, corrupt records are moved under quarantine/,
+// where no read finds them, so a crash leftover stops producing warnings.
+func (s *Storage) Repair(quarantine bool) (*RepairReport, error) {
+	report := &RepairReport{}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for _, records := range recordDecoders {
+			prefix := []byte(records.prefix)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				item := it.Item()
+				report.Checked++
+				err := item.Value(func(val []byte) error {
+					if err := records.decode(val); err != nil {
+						report.Corrupt = append(report.Corrupt, CorruptRecord{Key: string(item.Key()), Err: err})
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(report.Corrupt, func(i, j int) bool {
+		return report.Corrupt[i].Key < report.Corrupt[j].Key
+	})
+
+	if !quarantine {
+		return report, nil
+	}
+	for _, record := range report.Corrupt {
+		if err := s.quarantineRecord(record.Key); err != nil {
+			return report, err
+		}
+		report.Quarantined++
+	}
+	return report, nil
+}
+
+// quarantineRecord moves a record under quarantine/, keeping its value
+func (s *Storage) quarantineRecord(key string) error {
+	defer s.forgetMessageCounts()
+	defer s.forgetContactCount()
+	err := s.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(quarantinePrefix+key), val); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	s.corruptMux.Lock()
+	delete(s.corrupt, key)
+	s.corruptMux.Unlock()
+	log.Printf("Quarantined corrupt record %s", key)
+	return nil
+}
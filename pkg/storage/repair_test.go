@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// corruptKeys are overwritten with values that are not JSON, as a crash
+// mid-write or an incompatible version could leave them
+var corruptKeys = []string{
+	"contacts/mallory",
+	"messages/alice:bob/m2",
+	"sessions/alice/eve",
+}
+
+// injectCorrupt writes corruptKeys straight into the database
+func injectCorrupt(t *testing.T, store *Storage) {
+	t.Helper()
+
+	err := store.db.Update(func(txn *badger.Txn) error {
+		for _, key := range corruptKeys {
+			if err := txn.Set([]byte(key), []byte(`{"id": "trunc`)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("writing corrupt records: %v", err)
+	}
+}
+
+// corruptStore returns a store holding good messages, contacts and
+// sessions with a corrupt record among each
+func corruptStore(t *testing.T) *Storage {
+	t.Helper()
+
+	store := newTestStorage(t, -1)
+	t.Cleanup(func() { store.Close() })
+	saveMessages(t, store, testMessage("m1", 1, "first"), testMessage("m2", 2, "second"), testMessage("m3", 3, "third"))
+	for _, userID := range []string{"bob", "carol"} {
+		if err := store.SaveContact(&models.Contact{UserID: userID}); err != nil {
+			t.Fatalf("SaveContact: %v", err)
+		}
+		if err := store.SaveSession(&models.Session{LocalUserID: "alice", RemoteUserID: userID}); err != nil {
+			t.Fatalf("SaveSession: %v", err)
+		}
+	}
+	injectCorrupt(t, store)
+	return store
+}
+
+func TestCorruptRecordsAreSkipped(t *testing.T) {
+	store := corruptStore(t)
+
+	// Reading twice skips each corrupt record, counting it once
+	for i := 0; i < 2; i++ {
+		msgs, err := store.GetMessages("alice:bob", 10, 0)
+		if err != nil || messageIDs(msgs) != "m1,m3" {
+			t.Fatalf("GetMessages = %s, %v, want m1,m3", messageIDs(msgs), err)
+		}
+		contacts, err := store.GetAllContacts()
+		if err != nil || len(contacts) != 2 {
+			t.Fatalf("GetAllContacts = %d contacts, %v, want bob and carol", len(contacts), err)
+		}
+		sessions, err := store.GetAllSessions()
+		if err != nil || len(sessions) != 2 {
+			t.Fatalf("GetAllSessions = %d sessions, %v, want bob's and carol's", len(sessions), err)
+		}
+	}
+	if skipped := store.SkippedRecords(); skipped != len(corruptKeys) {
+		t.Errorf("SkippedRecords = %d, want %d", skipped, len(corruptKeys))
+	}
+
+	if _, err := store.GetMessage("alice:bob", "m2"); err == nil {
+		t.Error("GetMessage of the corrupt message succeeded")
+	}
+	if msg, err := store.GetMessage("alice:bob", "m3"); err != nil || msg.Content != "third" {
+		t.Errorf("GetMessage(m3) = %v, %v, want the good message", msg, err)
+	}
+}
+
+func TestRepairReportsAndQuarantinesCorruptRecords(t *testing.T) {
+	store := corruptStore(t)
+
+	report, err := store.Repair(false)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	var keys []string
+	for _, record := range report.Corrupt {
+		if record.Err == nil {
+			t.Errorf("corrupt record %s has no error", record.Key)
+		}
+		keys = append(keys, record.Key)
+	}
+	if !sort.StringsAreSorted(keys) || strings.Join(keys, ",") != strings.Join(corruptKeys, ",") {
+		t.Errorf("corrupt = %v, want %v in key order", keys, corruptKeys)
+	}
+	if report.Checked < 3+3+3 || report.Quarantined != 0 {
+		t.Errorf("Repair = %+v, want every record checked and none quarantined", report)
+	}
+	if again, _ := store.Repair(false); len(again.Corrupt) != len(corruptKeys) {
+		t.Error("reporting alone moved records aside")
+	}
+
+	report, err = store.Repair(true)
+	if err != nil || report.Quarantined != len(corruptKeys) {
+		t.Fatalf("Repair(quarantine) = %+v, %v, want every corrupt record quarantined", report, err)
+	}
+	if report, err := store.Repair(false); err != nil || len(report.Corrupt) != 0 {
+		t.Errorf("after quarantining, Repair = %+v, %v, want nothing corrupt", report, err)
+	}
+
+	// The records are kept under quarantine/ as they were
+	err = store.db.View(func(txn *badger.Txn) error {
+		for _, key := range corruptKeys {
+			if _, err := txn.Get([]byte(key)); err != badger.ErrKeyNotFound {
+				t.Errorf("%s is still in place: %v", key, err)
+			}
+			item, err := txn.Get([]byte(quarantinePrefix + key))
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if string(val) != `{"id": "trunc` {
+				t.Errorf("quarantined %s = %q, want the original value", key, val)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading quarantine: %v", err)
+	}
+
+	// Reads no longer skip anything
+	if msgs, _ := store.GetMessages("alice:bob", 10, 0); messageIDs(msgs) != "m1,m3" {
+		t.Errorf("messages after quarantining = %s, want m1,m3", messageIDs(msgs))
+	}
+	if contacts, _ := store.GetAllContacts(); len(contacts) != 2 {
+		t.Errorf("%d contacts after quarantining, want 2", len(contacts))
+	}
+	if skipped := store.SkippedRecords(); skipped != 0 {
+		t.Errorf("SkippedRecords = %d, want 0 once the corrupt records are gone", skipped)
+	}
+}
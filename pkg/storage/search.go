@@ -0,0 +1,460 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// SearchKeySize is the size of the key that blinds a persisted search index
+const SearchKeySize = 32
+
+// ErrSearchIndexClosed is returned by searches before OpenSearchIndex
+var ErrSearchIndexClosed = errors.New("search index is not open")
+
+// maxSearchToken is the longest word indexed; longer ones are cut short, in
+// messages and queries alike
+const maxSearchToken = 64
+
+// searchIndexConfig is the config entry holding the check value of the key
+// a persisted index was built with
+const searchIndexConfig = "search_index"
+
+// messageRef identifies a stored message
+type messageRef struct {
+	chatID    string
+	messageID string
+}
+
+// searchTokens splits text into the distinct lowercase words that searches
+// match on
+func searchTokens(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if len(field) > maxSearchToken {
+			// Cut on a character boundary, as the chat view does
+			cut := maxSearchToken
+			for cut > 0 && !utf8.RuneStart(field[cut]) {
+				cut--
+			}
+			field = field[:cut]
+		}
+		if !seen[field] {
+			seen[field] = true
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+// memoryIndex is an inverted index from words to the messages containing
+// them, kept in memory only
+type memoryIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[messageRef]struct{}
+	words    map[messageRef][]string
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{
+		postings: make(map[string]map[messageRef]struct{}),
+		words:    make(map[messageRef][]string),
+	}
+}
+
+// add indexes a message under tokens, replacing what it was indexed under
+func (m *memoryIndex) add(ref messageRef, tokens []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeLocked(ref)
+	if len(tokens) == 0 {
+		return
+	}
+	for _, token := range tokens {
+		refs, exists := m.postings[token]
+		if !exists {
+			refs = make(map[messageRef]struct{})
+			m.postings[token] = refs
+		}
+		refs[ref] = struct{}{}
+	}
+	m.words[ref] = tokens
+}
+
+// remove drops a message from the index
+func (m *memoryIndex) remove(ref messageRef) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeLocked(ref)
+}
+
+func (m *memoryIndex) removeLocked(ref messageRef) {
+	for _, token := range m.words[ref] {
+		delete(m.postings[token], ref)
+		if len(m.postings[token]) == 0 {
+			delete(m.postings, token)
+		}
+	}
+	delete(m.words, ref)
+}
+
+// lookup returns the messages indexed under token
+func (m *memoryIndex) lookup(token string) map[messageRef]struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refs := make(map[messageRef]struct{}, len(m.postings[token]))
+	for ref := range m.postings[token] {
+		refs[ref] = struct{}{}
+	}
+	return refs
+}
+
+// OpenSearchIndex starts maintaining the message search index.
+//
+// With a nil key the index is kept in memory only and built now from the
+// stored messages, and any index persisted earlier is deleted. With a key of
+// SearchKeySize bytes the index is persisted with every word replaced by its
+// HMAC under the key, and rebuilt only if it was built with another key or
+// not at all. Either way no word is written to disk in plaintext.
+func (s *Storage) OpenSearchIndex(key []byte) error {
+	if key != nil && len(key) != SearchKeySize {
+		return fmt.Errorf("search key must be %d bytes, got %d", SearchKeySize, len(key))
+	}
+
+	// Saves wait for the index to be built, so none is missed
+	s.searchMux.Lock()
+	defer s.searchMux.Unlock()
+
+	var check string
+	err := s.GetConfig(searchIndexConfig, &check)
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return fmt.Errorf("failed to read search index settings: %w", err)
+	}
+	persisted := err == nil
+
+	if key == nil {
+		if persisted {
+			if err := s.dropBlindedIndex(); err != nil {
+				return err
+			}
+		}
+		index := newMemoryIndex()
+		err := s.forEachStoredMessage(func(msg *models.Message) error {
+			if !msg.IsExpired() {
+				index.add(messageRef{msg.ChatID, msg.ID}, searchTokens(msg.Content))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+		s.searchKey, s.memIndex = nil, index
+		return nil
+	}
+
+	keyCheck := hex.EncodeToString(blind(key, "securechat search index check"))
+	if !persisted || check != keyCheck {
+		if err := s.buildBlindedIndex(key); err != nil {
+			return err
+		}
+		if err := s.SaveConfig(searchIndexConfig, keyCheck); err != nil {
+			return fmt.Errorf("failed to save search index settings: %w", err)
+		}
+	}
+	s.searchKey, s.memIndex = key, nil
+	return nil
+}
+
+// SearchMessages returns the stored messages whose text contains every word
+// of query, most recent first, and at most limit of them if it is positive.
+// Words match whole and regardless of case.
+func (s *Storage) SearchMessages(query string, limit int) ([]*models.Message, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	s.searchMux.RLock()
+	defer s.searchMux.RUnlock()
+
+	if s.searchKey == nil && s.memIndex == nil {
+		return nil, ErrSearchIndexClosed
+	}
+
+	matches, err := matchTokens(tokens, s.lookupToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var messages []*models.Message
+	err = s.db.View(func(txn *badger.Txn) error {
+		for ref := range matches {
+			item, err := txn.Get(s.messageKey(ref.chatID, ref.messageID))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			var msg models.Message
+			ok, err := s.decodeItem(item, &msg)
+			if err != nil {
+				return err
+			}
+			if ok && !msg.IsExpired() {
+				messages = append(messages, &msg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load search results: %w", err)
+	}
+
+	return newestFirst(messages, limit), nil
+}
+
+// matchTokens returns the messages indexed under every one of tokens
+func matchTokens(tokens []string, lookup func(string) (map[messageRef]struct{}, error)) (map[messageRef]struct{}, error) {
+	var matches map[messageRef]struct{}
+	for _, token := range tokens {
+		refs, err := lookup(token)
+		if err != nil {
+			return nil, err
+		}
+		if matches != nil {
+			for ref := range matches {
+				if _, ok := refs[ref]; !ok {
+					delete(matches, ref)
+				}
+			}
+		} else {
+			matches = refs
+		}
+		if len(matches) == 0 {
+			return nil, nil
+		}
+	}
+	return matches, nil
+}
+
+// newestFirst sorts search results most recent first and keeps at most
+// limit of them if it is positive
+func newestFirst(messages []*models.Message, limit int) []*models.Message {
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[j].Before(messages[i])
+	})
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages
+}
+
+// lookupToken returns the messages indexed under a word
+func (s *Storage) lookupToken(token string) (map[messageRef]struct{}, error) {
+	if s.memIndex != nil {
+		return s.memIndex.lookup(token), nil
+	}
+
+	refs := make(map[messageRef]struct{})
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := s.searchPostingPrefix(hex.EncodeToString(blind(s.searchKey, token)))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			rest := string(it.Item().Key()[len(prefix):])
+			if i := strings.LastIndex(rest, "/"); i >= 0 {
+				refs[messageRef{chatID: rest[:i], messageID: rest[i+1:]}] = struct{}{}
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// indexMessage updates the search index for a message saved in txn
+func (s *Storage) indexMessage(txn *badger.Txn, msg *models.Message) error {
+	s.searchMux.RLock()
+	defer s.searchMux.RUnlock()
+
+	ref := messageRef{msg.ChatID, msg.ID}
+	tokens := searchTokens(msg.Content)
+	switch {
+	case s.searchKey != nil:
+		return s.setBlindedEntries(txn, s.searchKey, ref, tokens)
+	case s.memIndex != nil:
+		s.memIndex.add(ref, tokens)
+	}
+	return nil
+}
+
+// unindexMessage removes a message deleted in txn from the search index
+func (s *Storage) unindexMessage(txn *badger.Txn, chatID, messageID string) error {
+	s.searchMux.RLock()
+	defer s.searchMux.RUnlock()
+
+	ref := messageRef{chatID, messageID}
+	switch {
+	case s.searchKey != nil:
+		return s.setBlindedEntries(txn, s.searchKey, ref, nil)
+	case s.memIndex != nil:
+		s.memIndex.remove(ref)
+	}
+	return nil
+}
+
+// setBlindedEntries replaces the persisted index entries of a message with
+// ones for tokens. Besides a posting per word, a message has an entry
+// listing its blinded words so they can be removed again.
+func (s *Storage) setBlindedEntries(txn *badger.Txn, key []byte, ref messageRef, tokens []string) error {
+	blinded := make([]string, len(tokens))
+	for i, token := range tokens {
+		blinded[i] = hex.EncodeToString(blind(key, token))
+	}
+	sort.Strings(blinded)
+
+	var previous []string
+	docKey := s.searchDocKey(ref.chatID, ref.messageID)
+	item, err := txn.Get(docKey)
+	switch {
+	case err == nil:
+		if err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &previous)
+		}); err != nil {
+			return err
+		}
+	case !errors.Is(err, badger.ErrKeyNotFound):
+		return err
+	}
+	if slices.Equal(previous, blinded) {
+		return nil
+	}
+
+	for _, hash := range previous {
+		if err := txn.Delete(s.searchPostingKey(hash, ref.chatID, ref.messageID)); err != nil {
+			return err
+		}
+	}
+	if len(blinded) == 0 {
+		return txn.Delete(docKey)
+	}
+	for _, hash := range blinded {
+		if err := txn.Set(s.searchPostingKey(hash, ref.chatID, ref.messageID), nil); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(blinded)
+	if err != nil {
+		return err
+	}
+	return txn.Set(docKey, data)
+}
+
+// buildBlindedIndex replaces the persisted index with one built from the
+// stored messages under key
+func (s *Storage) buildBlindedIndex(key []byte) error {
+	if err := s.dropBlindedIndex(); err != nil {
+		return err
+	}
+
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	err := s.forEachStoredMessage(func(msg *models.Message) error {
+		tokens := searchTokens(msg.Content)
+		if msg.IsExpired() || len(tokens) == 0 {
+			return nil
+		}
+		blinded := make([]string, len(tokens))
+		for i, token := range tokens {
+			blinded[i] = hex.EncodeToString(blind(key, token))
+			if err := batch.Set(s.searchPostingKey(blinded[i], msg.ChatID, msg.ID), nil); err != nil {
+				return err
+			}
+		}
+		sort.Strings(blinded)
+		data, err := json.Marshal(blinded)
+		if err != nil {
+			return err
+		}
+		return batch.Set(s.searchDocKey(msg.ChatID, msg.ID), data)
+	})
+	if err == nil {
+		err = batch.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build search index: %w", err)
+	}
+	return nil
+}
+
+// dropBlindedIndex deletes the persisted index and its settings
+func (s *Storage) dropBlindedIndex() error {
+	if err := s.db.DropPrefix([]byte("search/"), []byte("searchdocs/")); err != nil {
+		return fmt.Errorf("failed to delete search index: %w", err)
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(s.configKey(searchIndexConfig))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete search index settings: %w", err)
+	}
+	return nil
+}
+
+// forEachStoredMessage calls fn for every stored message of every chat
+func (s *Storage) forEachStoredMessage(fn func(*models.Message) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("messages/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var msg models.Message
+			ok, err := s.decodeItem(it.Item(), &msg)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := fn(&msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// blind returns the keyed hash that stands in for a word in a persisted
+// index, truncated to 128 bits
+func blind(key []byte, token string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return mac.Sum(nil)[:16]
+}
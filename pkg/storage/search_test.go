@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"words", "Hello, hello world!", []string{"hello", "world"}},
+		{"numbers", "room 101", []string{"room", "101"}},
+		{"long ascii", strings.Repeat("a", maxSearchToken+1), []string{strings.Repeat("a", maxSearchToken)}},
+		{"long multibyte", "x" + strings.Repeat("é", maxSearchToken), []string{"x" + strings.Repeat("é", (maxSearchToken-1)/2)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchTokens(tt.text)
+			for _, token := range got {
+				if !utf8.ValidString(token) {
+					t.Fatalf("token %q is not valid UTF-8", token)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchTokens(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// searchKey returns a random key for a blinded index
+func searchKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, SearchKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	return key
+}
+
+// search returns the IDs of the messages matching query
+func search(t *testing.T, store Store, query string, limit int) string {
+	t.Helper()
+
+	results, err := store.SearchMessages(query, limit)
+	if err != nil {
+		t.Fatalf("SearchMessages(%q): %v", query, err)
+	}
+	return messageIDs(results)
+}
+
+func TestSearchMessages(t *testing.T) {
+	for _, mode := range []string{"memory", "blinded"} {
+		t.Run(mode, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, store Store) {
+				if _, err := store.SearchMessages("hello", 0); !errors.Is(err, ErrSearchIndexClosed) {
+					t.Errorf("search before opening the index = %v, want ErrSearchIndexClosed", err)
+				}
+
+				// Messages stored before the index is opened are indexed too
+				saveMessages(t, store, testMessage("m1", 1, "Lunch at noon?"))
+				var key []byte
+				if mode == "blinded" {
+					key = searchKey(t)
+				}
+				if err := store.OpenSearchIndex(key); err != nil {
+					t.Fatalf("OpenSearchIndex: %v", err)
+				}
+				saveMessages(t, store,
+					testMessage("m2", 2, "noon works, see you at lunch"),
+					testMessage("m3", 3, "lunchtime was great"),
+				)
+
+				tests := []struct {
+					query string
+					limit int
+					want  string
+				}{
+					{"lunch", 0, "m2,m1"},
+					{"LUNCH noon", 0, "m2,m1"},
+					{"lunch see", 0, "m2"},
+					{"lunch", 1, "m2"},
+					{"lunchtime", 0, "m3"},
+					{"dinner", 0, ""},
+					{"?!", 0, ""},
+				}
+				for _, tt := range tests {
+					if got := search(t, store, tt.query, tt.limit); got != tt.want {
+						t.Errorf("search %q (limit %d) = %s, want %s", tt.query, tt.limit, got, tt.want)
+					}
+				}
+
+				// Edits and deletions update the index
+				saveMessages(t, store, testMessage("m2", 2, "see you at dinner"))
+				if got := search(t, store, "lunch", 0); got != "m1" {
+					t.Errorf("after editing, search lunch = %s, want m1", got)
+				}
+				if got := search(t, store, "dinner", 0); got != "m2" {
+					t.Errorf("after editing, search dinner = %s, want m2", got)
+				}
+				if err := store.DeleteMessage("alice:bob", "m1"); err != nil {
+					t.Fatalf("DeleteMessage: %v", err)
+				}
+				if got := search(t, store, "lunch", 0); got != "" {
+					t.Errorf("after deleting, search lunch = %s, want nothing", got)
+				}
+			})
+		})
+	}
+}
+
+// indexEntries returns the keys and values of the persisted search index
+func indexEntries(t *testing.T, store *Storage) [][]byte {
+	t.Helper()
+
+	var entries [][]byte
+	err := store.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if !bytes.HasPrefix(key, []byte("search")) && !bytes.Contains(key, []byte(searchIndexConfig)) {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, key, value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	return entries
+}
+
+func TestSearchIndexKeepsNoPlaintext(t *testing.T) {
+	words := []string{"launch", "codes", "tuesday"}
+	dataDir := t.TempDir()
+	reopen := func(store *Storage, key []byte) *Storage {
+		t.Helper()
+		if store != nil {
+			if err := store.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+		}
+		store, err := NewStorage(StorageOptions{DataDir: dataDir, UserID: "alice", NoSyncWrites: true, GCInterval: -1})
+		if err != nil {
+			t.Fatalf("NewStorage: %v", err)
+		}
+		if err := store.OpenSearchIndex(key); err != nil {
+			t.Fatalf("OpenSearchIndex: %v", err)
+		}
+		return store
+	}
+
+	// In memory, nothing is persisted
+	store := reopen(nil, nil)
+	saveMessages(t, store, testMessage("m1", 1, "Launch codes on Tuesday"))
+	if entries := indexEntries(t, store); len(entries) != 0 {
+		t.Errorf("the memory index persisted %d entries", len(entries)/2)
+	}
+
+	// Blinded, no word appears in what is persisted, and searches work
+	// across a restart
+	key := searchKey(t)
+	store = reopen(store, key)
+	saveMessages(t, store, testMessage("m2", 2, "tuesday it is"))
+	store = reopen(store, key)
+	entries := indexEntries(t, store)
+	if len(entries) == 0 {
+		t.Fatal("the blinded index persisted nothing")
+	}
+	for _, entry := range entries {
+		for _, word := range words {
+			if bytes.Contains(bytes.ToLower(entry), []byte(word)) {
+				t.Errorf("index entry %q holds the word %q", entry, word)
+			}
+		}
+	}
+	if got := search(t, store, "tuesday", 0); got != "m2,m1" {
+		t.Errorf("after reopening, search tuesday = %s, want m2,m1", got)
+	}
+
+	// Under another key the index is rebuilt, not read with the wrong key
+	store = reopen(store, searchKey(t))
+	if got := search(t, store, "launch codes", 0); got != "m1" {
+		t.Errorf("with a new key, search launch codes = %s, want m1", got)
+	}
+
+	// Back in memory, the persisted index is deleted
+	store = reopen(store, nil)
+	defer store.Close()
+	if entries := indexEntries(t, store); len(entries) != 0 {
+		t.Errorf("%d index entries remain after switching to memory", len(entries)/2)
+	}
+	if got := search(t, store, "tuesday", 0); got != "m2,m1" {
+		t.Errorf("in memory, search tuesday = %s, want m2,m1", got)
+	}
+}
@@ -0,0 +1,1185 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver
+)
+
+// sqliteFile is the database file of the SQLite backend in the data
+// directory
+const sqliteFile = "securechat.sqlite"
+
+// sqliteSchema creates the tables of the SQLite backend. Messages have
+// their own table so lists can be read in display order from an index;
+// every other record lives in records under the key Badger stores it
+// under, split into its prefix and the rest, so both backends report the
+// same keys.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	chat_id      TEXT    NOT NULL,
+	id           TEXT    NOT NULL,
+	sequence     INTEGER NOT NULL,
+	display_time INTEGER NOT NULL,
+	value        BLOB    NOT NULL,
+	PRIMARY KEY (chat_id, id)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS messages_order ON messages (chat_id, sequence, display_time, id);
+CREATE TABLE IF NOT EXISTS records (
+	kind  TEXT NOT NULL,
+	key   TEXT NOT NULL,
+	value BLOB NOT NULL,
+	PRIMARY KEY (kind, key)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS search_postings (
+	word       TEXT NOT NULL,
+	chat_id    TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	PRIMARY KEY (word, chat_id, message_id)
+) WITHOUT ROWID;
+`
+
+// Record kinds of the records table, named after their Badger prefixes
+const (
+	kindContacts      = "contacts"
+	kindIdentities    = "identities"
+	kindConversations = "conversations"
+	kindDeliveries    = "deliveries"
+	kindDebug         = "debug"
+	kindOutbox        = "outbox"
+	kindAudit         = "audit"
+	kindSearchDocs    = "searchdocs"
+	kindConfig        = "config"
+	kindQuarantine    = "quarantine"
+)
+
+// SQLiteStore is a Store kept in a SQLite database file in the data
+// directory. Deleted content is overwritten in the file as it is deleted,
+// so unlike Badger no garbage collection is needed to reclaim it.
+type SQLiteStore struct {
+	db      *sql.DB
+	dataDir string
+	userID  string
+	limits  Limits
+
+	// Message search index, set up by OpenSearchIndex, as for Storage
+	searchKey []byte
+	memIndex  *memoryIndex
+	searchMux sync.RWMutex
+
+	// Records that failed to decode and were skipped, by key
+	corrupt    map[string]error
+	corruptMux sync.Mutex
+}
+
+// NewSQLiteStore opens the SQLite database in the data directory, creating
+// it if needed. Only DataDir, UserID and Limits of opts are used.
+func NewSQLiteStore(opts StorageOptions) (*SQLiteStore, error) {
+	if err := os.MkdirAll(opts.DataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// secure_delete overwrites deleted content in the file. A single
+	// connection serializes transactions, which SQLite would otherwise
+	// refuse with SQLITE_BUSY.
+	path := filepath.Join(opts.DataDir, sqliteFile)
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=secure_delete(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create database tables: %w", err)
+	}
+
+	store := &SQLiteStore{
+		db:      db,
+		dataDir: opts.DataDir,
+		userID:  opts.UserID,
+		limits:  opts.Limits,
+		corrupt: make(map[string]error),
+	}
+	store.restrictFiles()
+	return store, nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	err := s.db.Close()
+	s.restrictFiles()
+	return err
+}
+
+// restrictFiles removes access for other users from the database file and
+// the journal SQLite creates next to it per the umask
+func (s *SQLiteStore) restrictFiles() {
+	matches, err := filepath.Glob(filepath.Join(s.dataDir, sqliteFile+"*"))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		loose, err := LoosePermissions(path, false)
+		if err != nil {
+			log.Printf("Warning: failed to check database permissions: %v", err)
+			continue
+		}
+		if err := TightenPermissions(loose); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// update runs fn in a transaction, committing it if fn succeeds
+func (s *SQLiteStore) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateIndexed runs fn in a transaction that changes messages. The
+// search index cannot be swapped while it runs, so the index fn updates is
+// the one kept; taking the lock first keeps OpenSearchIndex from waiting on
+// the connection while fn waits on the lock.
+func (s *SQLiteStore) updateIndexed(fn func(tx *sql.Tx) error) error {
+	s.searchMux.RLock()
+	defer s.searchMux.RUnlock()
+
+	return s.update(fn)
+}
+
+// querier is what reads need of a database or transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// putSQLiteRecord encodes value into the records table
+func putSQLiteRecord(q querier, kind, key string, value interface{}, name string) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	_, err = q.Exec(`INSERT OR REPLACE INTO records (kind, key, value) VALUES (?, ?, ?)`, kind, key, data)
+	return err
+}
+
+// getRecord decodes the record of a kind under key into dest
+func (s *SQLiteStore) getRecord(kind, key string, dest interface{}) error {
+	var data []byte
+	err := s.db.QueryRow(`SELECT value FROM records WHERE kind = ? AND key = ?`, kind, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// deleteRecord deletes the record of a kind under key, if there is one
+func deleteRecord(q querier, kind, key string) error {
+	_, err := q.Exec(`DELETE FROM records WHERE kind = ? AND key = ?`, kind, key)
+	return err
+}
+
+// allSQLiteRecords decodes every record of a kind, in key order as Badger
+// orders them. Records that do not decode are skipped.
+func allSQLiteRecords[V any](s *SQLiteStore, kind string) ([]*V, error) {
+	rows, err := s.db.Query(`SELECT key, value FROM records WHERE kind = ? ORDER BY key`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*V
+	for rows.Next() {
+		var key string
+		var data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return nil, err
+		}
+		var record V
+		if s.decode(kind+"/"+key, data, &record) {
+			records = append(records, &record)
+		}
+	}
+	return records, rows.Err()
+}
+
+// decode decodes a stored record into dest. A record that does not decode
+// is logged and skipped, reporting false, as Storage.decodeItem does.
+func (s *SQLiteStore) decode(key string, data []byte, dest interface{}) bool {
+	if err := json.Unmarshal(data, dest); err != nil {
+		s.corruptMux.Lock()
+		defer s.corruptMux.Unlock()
+
+		if _, seen := s.corrupt[key]; !seen {
+			s.corrupt[key] = err
+			log.Printf("Warning: skipping corrupt record %s: %v", key, err)
+		}
+		return false
+	}
+	return true
+}
+
+func refKey(chatID, messageID string) string {
+	return chatID + "/" + messageID
+}
+
+func (s *SQLiteStore) sessionKind() string {
+	return "sessions/" + s.userID
+}
+
+// Message storage methods
+
+// SaveMessage saves a message. The limits are checked and the oldest
+// messages evicted in the same transaction, so saves cannot race past them.
+func (s *SQLiteStore) SaveMessage(msg *models.Message) error {
+	return s.updateIndexed(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM messages WHERE chat_id = ? AND id = ?)`, msg.ChatID, msg.ID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := s.checkMessageLimits(tx, msg.ChatID); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = now
+		}
+		msg.UpdatedAt = now
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO messages (chat_id, id, sequence, display_time, value) VALUES (?, ?, ?, ?, ?)`,
+			msg.ChatID, msg.ID, int64(msg.Sequence), msg.DisplayTime().UnixNano(), data)
+		if err != nil {
+			return err
+		}
+		if err := s.indexMessage(tx, msg); err != nil {
+			return err
+		}
+		if !exists {
+			return s.evictMessages(tx, msg.ChatID)
+		}
+		return nil
+	})
+}
+
+// GetMessage retrieves a message by ID
+func (s *SQLiteStore) GetMessage(chatID, messageID string) (*models.Message, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT value FROM messages WHERE chat_id = ? AND id = ?`, chatID, messageID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg models.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// HasMessage reports whether a message is stored, without reading it
+func (s *SQLiteStore) HasMessage(chatID, messageID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM messages WHERE chat_id = ? AND id = ?)`, chatID, messageID).Scan(&exists)
+	return exists, err
+}
+
+// scanMessages decodes the messages rows holds, keyed and valued by their
+// chat ID, ID and value, calling fn with each until it returns false.
+// Messages that do not decode are skipped.
+func (s *SQLiteStore) scanMessages(rows *sql.Rows, fn func(*models.Message) bool) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatID, id string
+		var data []byte
+		if err := rows.Scan(&chatID, &id, &data); err != nil {
+			return err
+		}
+		var msg models.Message
+		if s.decode("messages/"+refKey(chatID, id), data, &msg) && !fn(&msg) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// GetMessages retrieves messages for a chat in display order
+func (s *SQLiteStore) GetMessages(chatID string, limit int, offset int) ([]*models.Message, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(`SELECT chat_id, id, value FROM messages WHERE chat_id = ?
+		ORDER BY sequence, display_time, id`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	var messages []*models.Message
+	err = s.scanMessages(rows, func(msg *models.Message) bool {
+		// Disappearing messages are hidden from the moment they expire,
+		// before the sweeper deletes them
+		if msg.IsExpired() {
+			return true
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		messages = append(messages, msg)
+		return len(messages) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetMessagesBefore retrieves up to limit messages that precede before in
+// display order, oldest first. A nil before returns the most recent messages.
+func (s *SQLiteStore) GetMessagesBefore(chatID string, before *models.Message, limit int) ([]*models.Message, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	query := `SELECT chat_id, id, value FROM messages WHERE chat_id = ?`
+	args := []interface{}{chatID}
+	if before != nil {
+		query += ` AND (sequence, display_time, id) < (?, ?, ?)`
+		args = append(args, int64(before.Sequence), before.DisplayTime().UnixNano(), before.ID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY sequence DESC, display_time DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk back from before, then put the page in display order
+	var messages []*models.Message
+	err = s.scanMessages(rows, func(msg *models.Message) bool {
+		if !msg.IsExpired() {
+			messages = append(messages, msg)
+		}
+		return len(messages) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+// MaxSequence returns the highest sequence number stored for a chat
+func (s *SQLiteStore) MaxSequence(chatID string) (uint64, error) {
+	var max int64
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(sequence), 0) FROM messages WHERE chat_id = ?`, chatID).Scan(&max)
+	return uint64(max), err
+}
+
+// MessageCount returns how many messages a chat holds
+func (s *SQLiteStore) MessageCount(chatID string) (int, error) {
+	return messageCount(s.db, chatID)
+}
+
+func messageCount(q querier, chatID string) (int, error) {
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM messages WHERE chat_id = ?`, chatID).Scan(&count)
+	return count, err
+}
+
+// DeleteMessage deletes a message
+func (s *SQLiteStore) DeleteMessage(chatID, messageID string) error {
+	return s.updateIndexed(func(tx *sql.Tx) error {
+		return s.deleteMessage(tx, chatID, messageID, false)
+	})
+}
+
+// SecureDeleteMessages deletes messages with their debug info. Their
+// content is overwritten in the database file as they are deleted.
+func (s *SQLiteStore) SecureDeleteMessages(chatID string, messageIDs []string) error {
+	return s.updateIndexed(func(tx *sql.Tx) error {
+		for _, id := range messageIDs {
+			if err := s.deleteMessage(tx, chatID, id, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteMessage deletes a message in tx, removing it from the search index,
+// and its debug info too if withDebugInfo is set
+func (s *SQLiteStore) deleteMessage(tx *sql.Tx, chatID, messageID string, withDebugInfo bool) error {
+	if _, err := tx.Exec(`DELETE FROM messages WHERE chat_id = ? AND id = ?`, chatID, messageID); err != nil {
+		return err
+	}
+	if withDebugInfo {
+		if err := deleteRecord(tx, kindDebug, refKey(chatID, messageID)); err != nil {
+			return err
+		}
+	}
+	return s.unindexMessage(tx, chatID, messageID)
+}
+
+// CleanupExpiredMessages removes disappearing messages whose time is up and
+// messages older than the retention period, as the Badger store does
+func (s *SQLiteStore) CleanupExpiredMessages(retentionDays int) error {
+	conversations, err := s.GetAllConversations()
+	if err != nil {
+		return fmt.Errorf("failed to load conversation settings: %w", err)
+	}
+
+	now := time.Now()
+	var expired []messageRef
+	rows, err := s.db.Query(`SELECT chat_id, id, value FROM messages`)
+	if err != nil {
+		return err
+	}
+	err = s.scanMessages(rows, func(msg *models.Message) bool {
+		isExpired := msg.IsExpired()
+		if days := conversations[msg.ChatID].Retention(retentionDays); days > 0 && msg.Timestamp.Before(now.AddDate(0, 0, -days)) {
+			isExpired = true
+		}
+		if isExpired {
+			expired = append(expired, messageRef{msg.ChatID, msg.ID})
+		}
+		return true
+	})
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+
+	return s.updateIndexed(func(tx *sql.Tx) error {
+		for _, ref := range expired {
+			if err := s.deleteMessage(tx, ref.chatID, ref.messageID, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Limits
+
+// databaseSize returns the size of the database file in bytes
+func databaseSize(q querier) (int64, error) {
+	var pages, pageSize int64
+	if err := q.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := q.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+// checkMessageLimits fails if a new message may not be saved to a chat
+func (s *SQLiteStore) checkMessageLimits(tx *sql.Tx, chatID string) error {
+	if limit := s.limits.MaxSize; limit > 0 {
+		size, err := databaseSize(tx)
+		if err != nil {
+			return err
+		}
+		if size >= limit {
+			return fmt.Errorf("%w: database has grown to %d MB", ErrLimitReached, size>>20)
+		}
+	}
+	if limit := s.limits.MaxMessagesPerChat; limit > 0 && s.limits.RejectFullChats {
+		count, err := messageCount(tx, chatID)
+		if err != nil {
+			return err
+		}
+		if count >= limit {
+			return fmt.Errorf("%w: chat %s holds %d messages", ErrLimitReached, chatID, limit)
+		}
+	}
+	return nil
+}
+
+// evictMessages deletes the oldest messages of a chat beyond the per-chat
+// limit in tx, with their debug info. The oldest are the first in display
+// order.
+func (s *SQLiteStore) evictMessages(tx *sql.Tx, chatID string) error {
+	limit := s.limits.MaxMessagesPerChat
+	if limit <= 0 || s.limits.RejectFullChats {
+		return nil
+	}
+
+	count, err := messageCount(tx, chatID)
+	if err != nil || count <= limit {
+		return err
+	}
+	rows, err := tx.Query(`SELECT id FROM messages WHERE chat_id = ?
+		ORDER BY sequence, display_time, id LIMIT ?`, chatID, count-limit)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := s.deleteMessage(tx, chatID, id, true); err != nil {
+			return err
+		}
+	}
+	log.Printf("Evicted the %d oldest messages of chat %s, which keeps %d", len(ids), chatID, limit)
+	return nil
+}
+
+// Usage counts the contacts, chats and messages stored and measures the
+// database
+func (s *SQLiteStore) Usage() (*Usage, error) {
+	usage := &Usage{Limits: s.limits}
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM records WHERE kind = ?`, kindContacts).Scan(&usage.Contacts)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT chat_id, COUNT(*) FROM messages GROUP BY chat_id`)
+	if err != nil {
+		return nil, err
+	}
+	perChat := make(map[string]int)
+	for rows.Next() {
+		var chatID string
+		var count int
+		if err := rows.Scan(&chatID, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		perChat[chatID] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	usage.addChats(perChat)
+
+	if usage.Size, err = databaseSize(s.db); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// Message search methods
+
+// OpenSearchIndex starts maintaining the message search index, kept in
+// memory with a nil key or persisted blinded under key, as
+// Storage.OpenSearchIndex describes
+func (s *SQLiteStore) OpenSearchIndex(key []byte) error {
+	if key != nil && len(key) != SearchKeySize {
+		return fmt.Errorf("search key must be %d bytes, got %d", SearchKeySize, len(key))
+	}
+
+	// Saves wait for the index to be built, so none is missed
+	s.searchMux.Lock()
+	defer s.searchMux.Unlock()
+
+	var check string
+	err := s.GetConfig(searchIndexConfig, &check)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to read search index settings: %w", err)
+	}
+	persisted := err == nil
+
+	if key == nil {
+		if persisted {
+			if err := s.update(s.dropBlindedIndex); err != nil {
+				return fmt.Errorf("failed to delete search index: %w", err)
+			}
+		}
+		index := newMemoryIndex()
+		err := s.forEachStoredMessage(func(msg *models.Message) error {
+			if !msg.IsExpired() {
+				index.add(messageRef{msg.ChatID, msg.ID}, searchTokens(msg.Content))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+		s.searchKey, s.memIndex = nil, index
+		return nil
+	}
+
+	keyCheck := hex.EncodeToString(blind(key, "securechat search index check"))
+	if !persisted || check != keyCheck {
+		if err := s.buildBlindedIndex(key); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+		if err := s.SaveConfig(searchIndexConfig, keyCheck); err != nil {
+			return fmt.Errorf("failed to save search index settings: %w", err)
+		}
+	}
+	s.searchKey, s.memIndex = key, nil
+	return nil
+}
+
+// SearchMessages returns the stored messages whose text contains every word
+// of query, most recent first, and at most limit of them if it is positive
+func (s *SQLiteStore) SearchMessages(query string, limit int) ([]*models.Message, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	s.searchMux.RLock()
+	defer s.searchMux.RUnlock()
+
+	if s.searchKey == nil && s.memIndex == nil {
+		return nil, ErrSearchIndexClosed
+	}
+
+	matches, err := matchTokens(tokens, s.lookupToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	var messages []*models.Message
+	for ref := range matches {
+		msg, err := s.GetMessage(ref.chatID, ref.messageID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load search results: %w", err)
+		}
+		if !msg.IsExpired() {
+			messages = append(messages, msg)
+		}
+	}
+	return newestFirst(messages, limit), nil
+}
+
+// lookupToken returns the messages indexed under a word
+func (s *SQLiteStore) lookupToken(token string) (map[messageRef]struct{}, error) {
+	if s.memIndex != nil {
+		return s.memIndex.lookup(token), nil
+	}
+
+	rows, err := s.db.Query(`SELECT chat_id, message_id FROM search_postings WHERE word = ?`,
+		hex.EncodeToString(blind(s.searchKey, token)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := make(map[messageRef]struct{})
+	for rows.Next() {
+		var ref messageRef
+		if err := rows.Scan(&ref.chatID, &ref.messageID); err != nil {
+			return nil, err
+		}
+		refs[ref] = struct{}{}
+	}
+	return refs, rows.Err()
+}
+
+// indexMessage updates the search index for a message saved in tx. Callers
+// hold s.searchMux, see updateIndexed.
+func (s *SQLiteStore) indexMessage(tx *sql.Tx, msg *models.Message) error {
+	ref := messageRef{msg.ChatID, msg.ID}
+	tokens := searchTokens(msg.Content)
+	switch {
+	case s.searchKey != nil:
+		return setBlindedPostings(tx, s.searchKey, ref, tokens)
+	case s.memIndex != nil:
+		s.memIndex.add(ref, tokens)
+	}
+	return nil
+}
+
+// unindexMessage removes a message deleted in tx from the search index.
+// Callers hold s.searchMux, see updateIndexed.
+func (s *SQLiteStore) unindexMessage(tx *sql.Tx, chatID, messageID string) error {
+	ref := messageRef{chatID, messageID}
+	switch {
+	case s.searchKey != nil:
+		return setBlindedPostings(tx, s.searchKey, ref, nil)
+	case s.memIndex != nil:
+		s.memIndex.remove(ref)
+	}
+	return nil
+}
+
+// setBlindedPostings replaces the persisted index entries of a message with
+// ones for tokens, as Storage.setBlindedEntries does
+func setBlindedPostings(tx *sql.Tx, key []byte, ref messageRef, tokens []string) error {
+	blinded := make([]string, len(tokens))
+	for i, token := range tokens {
+		blinded[i] = hex.EncodeToString(blind(key, token))
+	}
+	sort.Strings(blinded)
+
+	_, err := tx.Exec(`DELETE FROM search_postings WHERE chat_id = ? AND message_id = ?`, ref.chatID, ref.messageID)
+	if err != nil {
+		return err
+	}
+	docKey := refKey(ref.chatID, ref.messageID)
+	if len(blinded) == 0 {
+		return deleteRecord(tx, kindSearchDocs, docKey)
+	}
+	for _, word := range blinded {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO search_postings (word, chat_id, message_id) VALUES (?, ?, ?)`,
+			word, ref.chatID, ref.messageID)
+		if err != nil {
+			return err
+		}
+	}
+	return putSQLiteRecord(tx, kindSearchDocs, docKey, blinded, "search entry")
+}
+
+// buildBlindedIndex replaces the persisted index with one built from the
+// stored messages under key
+func (s *SQLiteStore) buildBlindedIndex(key []byte) error {
+	var messages []*models.Message
+	err := s.forEachStoredMessage(func(msg *models.Message) error {
+		if !msg.IsExpired() {
+			messages = append(messages, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *sql.Tx) error {
+		if err := s.dropBlindedIndex(tx); err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			tokens := searchTokens(msg.Content)
+			if len(tokens) == 0 {
+				continue
+			}
+			if err := setBlindedPostings(tx, key, messageRef{msg.ChatID, msg.ID}, tokens); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropBlindedIndex deletes the persisted index and its settings in tx
+func (s *SQLiteStore) dropBlindedIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM search_postings`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM records WHERE kind = ?`, kindSearchDocs); err != nil {
+		return err
+	}
+	return deleteRecord(tx, kindConfig, searchIndexConfig)
+}
+
+// forEachStoredMessage calls fn for every stored message of every chat
+func (s *SQLiteStore) forEachStoredMessage(fn func(*models.Message) error) error {
+	rows, err := s.db.Query(`SELECT chat_id, id, value FROM messages`)
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	err = s.scanMessages(rows, func(msg *models.Message) bool {
+		fnErr = fn(msg)
+		return fnErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// Contact storage methods
+
+// SaveContact saves a contact
+func (s *SQLiteStore) SaveContact(contact *models.Contact) error {
+	return s.update(func(tx *sql.Tx) error {
+		if limit := s.limits.MaxContacts; limit > 0 {
+			var exists bool
+			var count int
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM records WHERE kind = ? AND key = ?),
+				(SELECT COUNT(*) FROM records WHERE kind = ?)`, kindContacts, contact.UserID, kindContacts).Scan(&exists, &count)
+			if err != nil {
+				return err
+			}
+			if !exists && count >= limit {
+				return fmt.Errorf("%w: %d contacts", ErrLimitReached, limit)
+			}
+		}
+
+		now := time.Now()
+		if contact.AddedAt.IsZero() {
+			contact.AddedAt = now
+		}
+		contact.UpdatedAt = now
+
+		return putSQLiteRecord(tx, kindContacts, contact.UserID, contact, "contact")
+	})
+}
+
+// GetContact retrieves a contact by user ID
+func (s *SQLiteStore) GetContact(userID string) (*models.Contact, error) {
+	var contact models.Contact
+	if err := s.getRecord(kindContacts, userID, &contact); err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// GetAllContacts retrieves all contacts
+func (s *SQLiteStore) GetAllContacts() ([]*models.Contact, error) {
+	return allSQLiteRecords[models.Contact](s, kindContacts)
+}
+
+// DeleteContact deletes a contact
+func (s *SQLiteStore) DeleteContact(userID string) error {
+	return deleteRecord(s.db, kindContacts, userID)
+}
+
+// Session storage methods
+
+// SaveSession saves a cryptographic session
+func (s *SQLiteStore) SaveSession(session *models.Session) error {
+	now := time.Now()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	session.UpdatedAt = now
+	session.LastUsed = now
+
+	return putSQLiteRecord(s.db, s.sessionKind(), session.RemoteUserID, session, "session")
+}
+
+// GetSession retrieves a session by remote user ID
+func (s *SQLiteStore) GetSession(remoteUserID string) (*models.Session, error) {
+	var session models.Session
+	if err := s.getRecord(s.sessionKind(), remoteUserID, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetAllSessions retrieves all sessions of the local user
+func (s *SQLiteStore) GetAllSessions() ([]*models.Session, error) {
+	return allSQLiteRecords[models.Session](s, s.sessionKind())
+}
+
+// DeleteSession deletes a session
+func (s *SQLiteStore) DeleteSession(remoteUserID string) error {
+	return deleteRecord(s.db, s.sessionKind(), remoteUserID)
+}
+
+// SecureDeleteSession deletes a session's key material, which is
+// overwritten in the database file as it is deleted
+func (s *SQLiteStore) SecureDeleteSession(remoteUserID string) error {
+	return s.DeleteSession(remoteUserID)
+}
+
+// Identity storage methods
+
+// SaveIdentity saves an identity
+func (s *SQLiteStore) SaveIdentity(identity *models.Identity) error {
+	return putSQLiteRecord(s.db, kindIdentities, identity.UserID, identity, "identity")
+}
+
+// GetIdentity retrieves an identity by user ID
+func (s *SQLiteStore) GetIdentity(userID string) (*models.Identity, error) {
+	var identity models.Identity
+	if err := s.getRecord(kindIdentities, userID, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// Conversation storage methods
+
+// SaveConversation saves a conversation's settings
+func (s *SQLiteStore) SaveConversation(conversation *models.Conversation) error {
+	conversation.UpdatedAt = time.Now()
+	return putSQLiteRecord(s.db, kindConversations, conversation.ChatID, conversation, "conversation")
+}
+
+// GetConversation retrieves a conversation's settings
+func (s *SQLiteStore) GetConversation(chatID string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	if err := s.getRecord(kindConversations, chatID, &conversation); err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// GetAllConversations retrieves all conversation settings keyed by chat ID
+func (s *SQLiteStore) GetAllConversations() (map[string]*models.Conversation, error) {
+	all, err := allSQLiteRecords[models.Conversation](s, kindConversations)
+	if err != nil {
+		return nil, err
+	}
+	conversations := make(map[string]*models.Conversation, len(all))
+	for _, conversation := range all {
+		conversations[conversation.ChatID] = conversation
+	}
+	return conversations, nil
+}
+
+// Pending delivery storage methods
+
+// SavePendingDelivery records a delivery timeout for a sent message
+func (s *SQLiteStore) SavePendingDelivery(pending *models.PendingDelivery) error {
+	return putSQLiteRecord(s.db, kindDeliveries, refKey(pending.ChatID, pending.MessageID), pending, "pending delivery")
+}
+
+// DeletePendingDelivery removes the delivery timeout of a message
+func (s *SQLiteStore) DeletePendingDelivery(chatID, messageID string) error {
+	return deleteRecord(s.db, kindDeliveries, refKey(chatID, messageID))
+}
+
+// GetPendingDeliveries retrieves all recorded delivery timeouts
+func (s *SQLiteStore) GetPendingDeliveries() ([]*models.PendingDelivery, error) {
+	return allSQLiteRecords[models.PendingDelivery](s, kindDeliveries)
+}
+
+// Message debug info storage methods
+
+// SaveMessageDebugInfo records the envelope metadata of a received message
+func (s *SQLiteStore) SaveMessageDebugInfo(info *models.MessageDebugInfo) error {
+	return putSQLiteRecord(s.db, kindDebug, refKey(info.ChatID, info.MessageID), info, "message debug info")
+}
+
+// GetMessageDebugInfo retrieves the envelope metadata of a received message
+func (s *SQLiteStore) GetMessageDebugInfo(chatID, messageID string) (*models.MessageDebugInfo, error) {
+	var info models.MessageDebugInfo
+	if err := s.getRecord(kindDebug, refKey(chatID, messageID), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Outbox storage methods
+
+// SaveOutboxEntry adds a message to the outbox
+func (s *SQLiteStore) SaveOutboxEntry(entry *models.OutboxEntry) error {
+	return putSQLiteRecord(s.db, kindOutbox, refKey(entry.ChatID, entry.MessageID), entry, "outbox entry")
+}
+
+// DeleteOutboxEntry removes a message from the outbox
+func (s *SQLiteStore) DeleteOutboxEntry(chatID, messageID string) error {
+	return deleteRecord(s.db, kindOutbox, refKey(chatID, messageID))
+}
+
+// GetOutboxEntries retrieves every message in the outbox
+func (s *SQLiteStore) GetOutboxEntries() ([]*models.OutboxEntry, error) {
+	return allSQLiteRecords[models.OutboxEntry](s, kindOutbox)
+}
+
+// Audit trail storage methods
+
+// SaveAuditEvent appends an event to the audit trail. The zero-padded
+// nanosecond timestamp key sorts in time order.
+func (s *SQLiteStore) SaveAuditEvent(event *models.AuditEvent) error {
+	return putSQLiteRecord(s.db, kindAudit, fmt.Sprintf("%020d", event.Time.UnixNano()), event, "audit event")
+}
+
+// GetAuditEvents retrieves the audit trail, oldest first
+func (s *SQLiteStore) GetAuditEvents() ([]*models.AuditEvent, error) {
+	return allSQLiteRecords[models.AuditEvent](s, kindAudit)
+}
+
+// Attachment storage methods
+
+// AttachmentPath returns where the received file with the given name is kept
+func (s *SQLiteStore) AttachmentPath(filename string) string {
+	return filepath.Join(s.dataDir, "downloads", filepath.Base(filename))
+}
+
+// OpenAttachment opens the local copy of a received attachment
+func (s *SQLiteStore) OpenAttachment(attachment *models.Attachment) (io.ReadCloser, error) {
+	return openAttachment(s.AttachmentPath(attachment.Filename), attachment)
+}
+
+// Configuration storage methods
+
+// SaveConfig saves a configuration value
+func (s *SQLiteStore) SaveConfig(key string, value interface{}) error {
+	return putSQLiteRecord(s.db, kindConfig, key, value, "config value")
+}
+
+// GetConfig retrieves a configuration value
+func (s *SQLiteStore) GetConfig(key string, dest interface{}) error {
+	return s.getRecord(kindConfig, key, dest)
+}
+
+// Integrity methods
+
+// SkippedRecords returns how many corrupt records reads have skipped since
+// the store was opened, counting each record once
+func (s *SQLiteStore) SkippedRecords() int {
+	s.corruptMux.Lock()
+	defer s.corruptMux.Unlock()
+
+	return len(s.corrupt)
+}
+
+// Repair checks that every stored record decodes and reports those that do
+// not, by the key Badger would store them under. With quarantine set,
+// corrupt records are moved to the quarantine kind, where no read finds
+// them.
+func (s *SQLiteStore) Repair(quarantine bool) (*RepairReport, error) {
+	report := &RepairReport{}
+	check := func(key string, data []byte) {
+		report.Checked++
+		for _, records := range recordDecoders {
+			if strings.HasPrefix(key, records.prefix) {
+				if err := records.decode(data); err != nil {
+					report.Corrupt = append(report.Corrupt, CorruptRecord{Key: key, Err: err})
+				}
+				return
+			}
+		}
+	}
+
+	rows, err := s.db.Query(`SELECT chat_id, id, value FROM messages`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var chatID, id string
+		var data []byte
+		if err := rows.Scan(&chatID, &id, &data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		check("messages/"+refKey(chatID, id), data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`SELECT kind, key, value FROM records WHERE kind != ?`, kindQuarantine)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var kind, key string
+		var data []byte
+		if err := rows.Scan(&kind, &key, &data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		check(kind+"/"+key, data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(report.Corrupt, func(i, j int) bool {
+		return report.Corrupt[i].Key < report.Corrupt[j].Key
+	})
+
+	if !quarantine {
+		return report, nil
+	}
+	for _, record := range report.Corrupt {
+		if err := s.quarantineRecord(record.Key); err != nil {
+			return report, err
+		}
+		report.Quarantined++
+	}
+	return report, nil
+}
+
+// quarantineRecord moves a record, named by its Badger key, to the
+// quarantine kind, keeping its value
+func (s *SQLiteStore) quarantineRecord(key string) error {
+	err := s.updateIndexed(func(tx *sql.Tx) error {
+		var data []byte
+		if rest, ok := strings.CutPrefix(key, "messages/"); ok {
+			slash := strings.LastIndexByte(rest, '/')
+			if slash < 0 {
+				return fmt.Errorf("malformed message key %s", key)
+			}
+			chatID, id := rest[:slash], rest[slash+1:]
+			if err := tx.QueryRow(`SELECT value FROM messages WHERE chat_id = ? AND id = ?`, chatID, id).Scan(&data); err != nil {
+				return err
+			}
+			if err := s.deleteMessage(tx, chatID, id, false); err != nil {
+				return err
+			}
+		} else {
+			kind, rest := splitRecordKey(key)
+			if err := tx.QueryRow(`SELECT value FROM records WHERE kind = ? AND key = ?`, kind, rest).Scan(&data); err != nil {
+				return err
+			}
+			if err := deleteRecord(tx, kind, rest); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO records (kind, key, value) VALUES (?, ?, ?)`, kindQuarantine, key, data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.corruptMux.Lock()
+	delete(s.corrupt, key)
+	s.corruptMux.Unlock()
+	log.Printf("Quarantined corrupt record %s", key)
+	return nil
+}
+
+// splitRecordKey splits a Badger key into the kind and key of the records
+// table. Sessions are kinds of their own for each local user.
+func splitRecordKey(key string) (string, string) {
+	if rest, ok := strings.CutPrefix(key, "sessions/"); ok {
+		user, remote, _ := strings.Cut(rest, "/")
+		return "sessions/" + user, remote
+	}
+	kind, rest, _ := strings.Cut(key, "/")
+	return kind, rest
+}
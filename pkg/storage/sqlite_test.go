@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func newTestSQLiteStore(t *testing.T, dataDir string) *SQLiteStore {
+	t.Helper()
+
+	store, err := NewSQLiteStore(StorageOptions{DataDir: dataDir, UserID: "alice"})
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	return store
+}
+
+func TestSQLiteStoreKeepsRecordsAcrossRestarts(t *testing.T) {
+	dataDir := t.TempDir()
+	store := newTestSQLiteStore(t, dataDir)
+	saveMessages(t, store, testMessage("m2", 2, "second"), testMessage("m1", 1, "first"))
+	if err := store.SaveContact(&models.Contact{UserID: "bob"}); err != nil {
+		t.Fatalf("SaveContact: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store = newTestSQLiteStore(t, dataDir)
+	defer store.Close()
+	messages, err := store.GetMessages("alice:bob", 10, 0)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := messageIDs(messages); got != "m1,m2" {
+		t.Errorf("GetMessages after reopening = %s, want m1,m2", got)
+	}
+	if _, err := store.GetContact("bob"); err != nil {
+		t.Errorf("GetContact after reopening: %v", err)
+	}
+}
+
+func TestSQLiteStoreQuarantinesCorruptRecords(t *testing.T) {
+	store := newTestSQLiteStore(t, t.TempDir())
+	defer store.Close()
+	saveMessages(t, store, testMessage("m1", 1, "first"), testMessage("m2", 2, "second"))
+	if err := store.SaveSession(&models.Session{LocalUserID: "alice", RemoteUserID: "bob"}); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE messages SET value = ? WHERE id = 'm2'`, `{"id": "trunc`); err != nil {
+		t.Fatalf("corrupting a message: %v", err)
+	}
+	if _, err := store.db.Exec(`INSERT INTO records (kind, key, value) VALUES ('sessions/alice', 'eve', ?)`, `{"id": "trunc`); err != nil {
+		t.Fatalf("corrupting a session: %v", err)
+	}
+
+	messages, err := store.GetMessages("alice:bob", 10, 0)
+	if err != nil || messageIDs(messages) != "m1" {
+		t.Errorf("GetMessages = %s, %v, want m1 with the corrupt message skipped", messageIDs(messages), err)
+	}
+	if sessions, err := store.GetAllSessions(); err != nil || len(sessions) != 1 {
+		t.Errorf("GetAllSessions = %d sessions, %v, want 1", len(sessions), err)
+	}
+	if skipped := store.SkippedRecords(); skipped != 2 {
+		t.Errorf("SkippedRecords = %d, want 2", skipped)
+	}
+
+	report, err := store.Repair(true)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if len(report.Corrupt) != 2 || report.Corrupt[0].Key != "messages/alice:bob/m2" || report.Corrupt[1].Key != "sessions/alice/eve" {
+		t.Errorf("Repair found %+v, want the corrupt message and session by their keys", report.Corrupt)
+	}
+	if report.Quarantined != 2 {
+		t.Errorf("Quarantined = %d, want 2", report.Quarantined)
+	}
+
+	report, err = store.Repair(false)
+	if err != nil || len(report.Corrupt) != 0 {
+		t.Errorf("Repair after quarantining = %+v, %v, want nothing corrupt", report, err)
+	}
+	if has, _ := store.HasMessage("alice:bob", "m2"); has {
+		t.Error("the quarantined message is still listed")
+	}
+}
@@ -3,8 +3,13 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -16,14 +21,73 @@ type Storage struct {
 	db       *badger.DB
 	dataDir  string
 	userID   string
+	
+	// Background value-log GC
+	gcDiscardRatio float64
+	gcStop         chan struct{}
+	gcDone         chan struct{}
+	gcRuns         atomic.Int64 // Calls of RunGC
+	
+	// Message search index, set up by OpenSearchIndex. Exactly one of
+	// searchKey, for a persisted blinded index, and memIndex is set once
+	// it is open.
+	searchKey []byte
+	memIndex  *memoryIndex
+	searchMux sync.RWMutex
+	
+	// Records that failed to decode and were skipped, by key
+	corrupt    map[string]error
+	corruptMux sync.Mutex
+	
+	limits Limits
+	
+	// Messages held by each chat counted so far, see MessageCount, and the
+	// locks serializing saves to each chat so its count stays within the
+	// per-chat limit
+	counts    map[string]int
+	chatLocks map[string]*sync.Mutex
+	countsMux sync.Mutex
+	
+	// Contacts held, or -1 until first counted, guarded by contactsMux,
+	// which is held across every save and delete of a contact
+	contacts    int
+	contactsMux sync.Mutex
 }
 
 // StorageOptions contains options for storage initialization
 type StorageOptions struct {
 	DataDir string
 	UserID  string
+	
+	// Limits caps how much is stored; the zero value is unlimited
+	Limits Limits
+	
+	// Backend chooses the implementation Open returns. Defaults to Badger;
+	// the options below apply to Badger only.
+	Backend Backend
+	
+	// NoSyncWrites skips fsync on every write. Faster, but the most recent
+	// writes may be lost on a crash.
+	NoSyncWrites bool
+	
+	// MemTableSize overrides Badger's memtable size in bytes when non-zero
+	MemTableSize int64
+	
+	// GCInterval is how often value-log GC runs. Defaults to 10 minutes;
+	// a negative value disables it.
+	GCInterval time.Duration
+	
+	// GCDiscardRatio is the fraction of a value-log file that must be
+	// reclaimable before GC rewrites it. Defaults to 0.5.
+	GCDiscardRatio float64
 }
 
+// Defaults for value-log garbage collection
+const (
+	defaultGCInterval     = 10 * time.Minute
+	defaultGCDiscardRatio = 0.5
+)
+
 // NewStorage creates a new storage instance
 func NewStorage(opts StorageOptions) (*Storage, error) {
 	// Ensure data directory exists
@@ -31,11 +95,21 @@ func NewStorage(opts StorageOptions) (*Storage, error) {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
+	if opts.GCInterval == 0 {
+		opts.GCInterval = defaultGCInterval
+	}
+	if opts.GCDiscardRatio <= 0 || opts.GCDiscardRatio >= 1 {
+		opts.GCDiscardRatio = defaultGCDiscardRatio
+	}
+
 	// Open BadgerDB
 	dbPath := filepath.Join(opts.DataDir, "securechat.db")
 	dbOpts := badger.DefaultOptions(dbPath).
 		WithLogger(nil). // Disable logging for now
-		WithSyncWrites(true)
+		WithSyncWrites(!opts.NoSyncWrites)
+	if opts.MemTableSize > 0 {
+		dbOpts = dbOpts.WithMemTableSize(opts.MemTableSize)
+	}
 
 	db, err := badger.Open(dbOpts)
 	if err != nil {
@@ -43,26 +117,125 @@ func NewStorage(opts StorageOptions) (*Storage, error) {
 	}
 
 	storage := &Storage{
-		db:      db,
-		dataDir: opts.DataDir,
-		userID:  opts.UserID,
+		db:             db,
+		dataDir:        opts.DataDir,
+		userID:         opts.UserID,
+		gcDiscardRatio: opts.GCDiscardRatio,
+		corrupt:        make(map[string]error),
+		limits:         opts.Limits,
+		counts:         make(map[string]int),
+		chatLocks:      make(map[string]*sync.Mutex),
+		contacts:       -1,
+	}
+	storage.restrictFiles()
+
+	if err := storage.buildOrderIndex(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to index messages: %w", err)
+	}
+
+	if opts.GCInterval > 0 {
+		storage.gcStop = make(chan struct{})
+		storage.gcDone = make(chan struct{})
+		go storage.runGC(opts.GCInterval)
 	}
 
 	return storage, nil
 }
 
-// Close closes the storage
+// Close stops background GC and closes the storage
 func (s *Storage) Close() error {
-	return s.db.Close()
+	if s.gcStop != nil {
+		close(s.gcStop)
+		<-s.gcDone
+	}
+	err := s.db.Close()
+	s.restrictFiles()
+	return err
+}
+
+// restrictFiles removes access for other users from the database files.
+// Badger creates some of them, such as tables and its lock file, per the
+// umask.
+func (s *Storage) restrictFiles() {
+	loose, err := LoosePermissions(filepath.Join(s.dataDir, "securechat.db"), true)
+	if err != nil {
+		log.Printf("Warning: failed to check database permissions: %v", err)
+		return
+	}
+	if err := TightenPermissions(loose); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// runGC periodically collects value-log garbage until Close is called
+func (s *Storage) runGC(interval time.Duration) {
+	defer close(s.gcDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.gcStop:
+			return
+		case <-ticker.C:
+			if err := s.RunGC(s.gcDiscardRatio); err != nil {
+				log.Printf("Warning: value log GC failed: %v", err)
+			}
+		}
+	}
+}
+
+// RunGC rewrites value-log files until none has at least discardRatio of
+// reclaimable space, and logs roughly how much disk space was freed
+func (s *Storage) RunGC(discardRatio float64) error {
+	s.gcRuns.Add(1)
+	_, vlogBefore := s.db.Size()
+
+	runs := 0
+	for {
+		err := s.db.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite || err == badger.ErrRejected {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		runs++
+	}
+
+	if runs > 0 {
+		// Badger refreshes its size counters periodically, so this is an estimate
+		_, vlogAfter := s.db.Size()
+		log.Printf("Value log GC rewrote %d file(s), reclaimed about %d bytes", runs, vlogBefore-vlogAfter)
+	}
+
+	return nil
 }
 
 // Message storage methods
 
 // SaveMessage saves a message to storage
 func (s *Storage) SaveMessage(msg *models.Message) error {
-	return s.db.Update(func(txn *badger.Txn) error {
+	defer s.lockChat(msg.ChatID)()
+	
+	added := false
+	err := s.db.Update(func(txn *badger.Txn) error {
 		key := s.messageKey(msg.ChatID, msg.ID)
 		
+		// Limits apply to new messages, not to updates of stored ones
+		isNew, err := isNewKey(txn, key)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			if err := s.checkMessageLimits(msg.ChatID); err != nil {
+				return err
+			}
+		}
+		added = isNew
+		
 		// Set timestamps
 		now := time.Now()
 		if msg.CreatedAt.IsZero() {
@@ -75,8 +248,19 @@ func (s *Storage) SaveMessage(msg *models.Message) error {
 			return fmt.Errorf("failed to marshal message: %w", err)
 		}
 
-		return txn.Set(key, data)
+		if err := s.setMessageOrder(txn, msg); err != nil {
+			return err
+		}
+		if err := txn.Set(key, data); err != nil {
+			return err
+		}
+		return s.indexMessage(txn, msg)
 	})
+	if err != nil || !added {
+		return err
+	}
+	s.adjustMessageCount(msg.ChatID, 1)
+	return s.evictMessages(msg.ChatID)
 }
 
 // GetMessage retrieves a message by ID
@@ -102,63 +286,184 @@ func (s *Storage) GetMessage(chatID, messageID string) (*models.Message, error)
 	return &msg, nil
 }
 
-// GetMessages retrieves messages for a chat
+// HasMessage reports whether a message is already stored, without reading its value
+func (s *Storage) HasMessage(chatID, messageID string) (bool, error) {
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(s.messageKey(chatID, messageID))
+		return err
+	})
+
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetMessages retrieves messages for a chat in display order
 func (s *Storage) GetMessages(chatID string, limit int, offset int) ([]*models.Message, error) {
 	var messages []*models.Message
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	err := s.forEachInOrder(chatID, nil, false, func(msg *models.Message) bool {
+		// Disappearing messages are hidden from the moment they expire,
+		// before the sweeper deletes them
+		if msg.IsExpired() {
+			return true
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		messages = append(messages, msg)
+		return len(messages) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetMessagesBefore retrieves up to limit messages that precede before in
+// display order, oldest first. A nil before returns the most recent messages.
+func (s *Storage) GetMessagesBefore(chatID string, before *models.Message, limit int) ([]*models.Message, error) {
+	var messages []*models.Message
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	// Walk back from before, then put the page in display order
+	err := s.forEachInOrder(chatID, before, true, func(msg *models.Message) bool {
+		if !msg.IsExpired() {
+			messages = append(messages, msg)
+		}
+		return len(messages) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages)
+
+	return messages, nil
+}
+
+// MaxSequence returns the highest sequence number stored for a chat, read
+// from its last order key
+func (s *Storage) MaxSequence(chatID string) (uint64, error) {
+	var max uint64
 
 	err := s.db.View(func(txn *badger.Txn) error {
+		prefix := s.orderPrefix(chatID)
 		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = limit
+		opts.PrefetchValues = false
+		opts.Reverse = true
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		prefix := s.messagePrefix(chatID)
-		count := 0
-		skipped := 0
+		it.Seek(append(append([]byte(nil), prefix...), 0xff))
+		if it.ValidForPrefix(prefix) {
+			max, _, _ = parseOrderKey(prefix, it.Item().Key())
+		}
+		return nil
+	})
 
-		for it.Seek(prefix); it.ValidForPrefix(prefix) && count < limit; it.Next() {
-			if skipped < offset {
-				skipped++
-				continue
-			}
+	return max, err
+}
 
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				var msg models.Message
-				if err := json.Unmarshal(val, &msg); err != nil {
-					return err
-				}
-				messages = append(messages, &msg)
-				return nil
-			})
+// forEachMessage calls fn for every message stored for a chat
+func (s *Storage) forEachMessage(chatID string, fn func(*models.Message) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := s.messagePrefix(chatID)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var msg models.Message
+			ok, err := s.decodeItem(it.Item(), &msg)
 			if err != nil {
 				return err
 			}
-
-			count++
+			if !ok {
+				continue
+			}
+			if err := fn(&msg); err != nil {
+				return err
+			}
 		}
 
 		return nil
 	})
-
-	return messages, err
 }
 
 // DeleteMessage deletes a message
 func (s *Storage) DeleteMessage(chatID, messageID string) error {
+	defer s.forgetMessageCounts(chatID)
 	return s.db.Update(func(txn *badger.Txn) error {
 		key := s.messageKey(chatID, messageID)
-		return txn.Delete(key)
+		if err := s.removeMessageOrder(txn, chatID, messageID); err != nil {
+			return err
+		}
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+		return s.unindexMessage(txn, chatID, messageID)
+	})
+}
+
+// SecureDeleteMessages deletes messages and immediately collects value-log
+// garbage so their contents are reclaimed sooner than the periodic GC would.
+// See secureDelete for the limits of this on Badger.
+func (s *Storage) SecureDeleteMessages(chatID string, messageIDs []string) error {
+	defer s.forgetMessageCounts(chatID)
+	keys := make([][]byte, 0, 2*len(messageIDs))
+	for _, id := range messageIDs {
+		keys = append(keys, s.messageKey(chatID, id), s.debugInfoKey(chatID, id))
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for _, id := range messageIDs {
+			if err := s.removeMessageOrder(txn, chatID, id); err != nil {
+				return err
+			}
+			if err := s.unindexMessage(txn, chatID, id); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("failed to remove messages from indexes: %w", err)
+	}
+	return s.secureDelete(keys)
 }
 
 // Contact storage methods
 
 // SaveContact saves a contact to storage
 func (s *Storage) SaveContact(contact *models.Contact) error {
-	return s.db.Update(func(txn *badger.Txn) error {
+	s.contactsMux.Lock()
+	defer s.contactsMux.Unlock()
+	
+	added := false
+	err := s.db.Update(func(txn *badger.Txn) error {
 		key := s.contactKey(contact.UserID)
 		
+		isNew, err := isNewKey(txn, key)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			if err := s.checkContactLimitLocked(txn); err != nil {
+				return err
+			}
+		}
+		added = isNew
+		
 		// Set timestamps
 		now := time.Now()
 		if contact.AddedAt.IsZero() {
@@ -173,6 +478,10 @@ func (s *Storage) SaveContact(contact *models.Contact) error {
 
 		return txn.Set(key, data)
 	})
+	if err == nil && added && s.contacts >= 0 {
+		s.contacts++
+	}
+	return err
 }
 
 // GetContact retrieves a contact by user ID
@@ -210,18 +519,14 @@ func (s *Storage) GetAllContacts() ([]*models.Contact, error) {
 		prefix := []byte("contacts/")
 
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				var contact models.Contact
-				if err := json.Unmarshal(val, &contact); err != nil {
-					return err
-				}
-				contacts = append(contacts, &contact)
-				return nil
-			})
+			var contact models.Contact
+			ok, err := s.decodeItem(it.Item(), &contact)
 			if err != nil {
 				return err
 			}
+			if ok {
+				contacts = append(contacts, &contact)
+			}
 		}
 
 		return nil
@@ -232,10 +537,23 @@ func (s *Storage) GetAllContacts() ([]*models.Contact, error) {
 
 // DeleteContact deletes a contact
 func (s *Storage) DeleteContact(userID string) error {
-	return s.db.Update(func(txn *badger.Txn) error {
+	s.contactsMux.Lock()
+	defer s.contactsMux.Unlock()
+	
+	removed := false
+	err := s.db.Update(func(txn *badger.Txn) error {
 		key := s.contactKey(userID)
+		missing, err := isNewKey(txn, key)
+		if err != nil {
+			return err
+		}
+		removed = !missing
 		return txn.Delete(key)
 	})
+	if err == nil && removed && s.contacts > 0 {
+		s.contacts--
+	}
+	return err
 }
 
 // Session storage methods
@@ -285,6 +603,32 @@ func (s *Storage) GetSession(remoteUserID string) (*models.Session, error) {
 	return &session, nil
 }
 
+// GetAllSessions retrieves all sessions of the local user
+func (s *Storage) GetAllSessions() ([]*models.Session, error) {
+	var sessions []*models.Session
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := s.sessionPrefix()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var session models.Session
+			ok, err := s.decodeItem(it.Item(), &session)
+			if err != nil {
+				return err
+			}
+			if ok {
+				sessions = append(sessions, &session)
+			}
+		}
+
+		return nil
+	})
+
+	return sessions, err
+}
+
 // DeleteSession deletes a session
 func (s *Storage) DeleteSession(remoteUserID string) error {
 	return s.db.Update(func(txn *badger.Txn) error {
@@ -293,6 +637,12 @@ func (s *Storage) DeleteSession(remoteUserID string) error {
 	})
 }
 
+// SecureDeleteSession deletes a session's key material and immediately
+// collects value-log garbage. See secureDelete for its limits.
+func (s *Storage) SecureDeleteSession(remoteUserID string) error {
+	return s.secureDelete([][]byte{s.sessionKey(remoteUserID)})
+}
+
 // Identity storage methods
 
 // SaveIdentity saves an identity
@@ -332,6 +682,275 @@ func (s *Storage) GetIdentity(userID string) (*models.Identity, error) {
 	return &identity, nil
 }
 
+// Conversation storage methods
+
+// SaveConversation saves a conversation's settings
+func (s *Storage) SaveConversation(conversation *models.Conversation) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := s.conversationKey(conversation.ChatID)
+		conversation.UpdatedAt = time.Now()
+
+		data, err := json.Marshal(conversation)
+		if err != nil {
+			return fmt.Errorf("failed to marshal conversation: %w", err)
+		}
+
+		return txn.Set(key, data)
+	})
+}
+
+// GetConversation retrieves a conversation's settings
+func (s *Storage) GetConversation(chatID string) (*models.Conversation, error) {
+	var conversation models.Conversation
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(s.conversationKey(chatID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &conversation)
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &conversation, nil
+}
+
+// GetAllConversations retrieves all conversation settings keyed by chat ID
+func (s *Storage) GetAllConversations() (map[string]*models.Conversation, error) {
+	conversations := make(map[string]*models.Conversation)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("conversations/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var conversation models.Conversation
+			ok, err := s.decodeItem(it.Item(), &conversation)
+			if err != nil {
+				return err
+			}
+			if ok {
+				conversations[conversation.ChatID] = &conversation
+			}
+		}
+
+		return nil
+	})
+
+	return conversations, err
+}
+
+// Pending delivery storage methods
+
+// SavePendingDelivery records a delivery timeout for a sent message
+func (s *Storage) SavePendingDelivery(pending *models.PendingDelivery) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		data, err := json.Marshal(pending)
+		if err != nil {
+			return fmt.Errorf("failed to marshal pending delivery: %w", err)
+		}
+
+		return txn.Set(s.deliveryKey(pending.ChatID, pending.MessageID), data)
+	})
+}
+
+// DeletePendingDelivery removes the delivery timeout of a message
+func (s *Storage) DeletePendingDelivery(chatID, messageID string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(s.deliveryKey(chatID, messageID))
+	})
+}
+
+// GetPendingDeliveries retrieves all recorded delivery timeouts
+func (s *Storage) GetPendingDeliveries() ([]*models.PendingDelivery, error) {
+	var deliveries []*models.PendingDelivery
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("deliveries/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var pending models.PendingDelivery
+			ok, err := s.decodeItem(it.Item(), &pending)
+			if err != nil {
+				return err
+			}
+			if ok {
+				deliveries = append(deliveries, &pending)
+			}
+		}
+
+		return nil
+	})
+
+	return deliveries, err
+}
+
+// Message debug info storage methods
+
+// SaveMessageDebugInfo records the envelope metadata of a received message
+func (s *Storage) SaveMessageDebugInfo(info *models.MessageDebugInfo) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		data, err := json.Marshal(info)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message debug info: %w", err)
+		}
+
+		return txn.Set(s.debugInfoKey(info.ChatID, info.MessageID), data)
+	})
+}
+
+// GetMessageDebugInfo retrieves the envelope metadata of a received message
+func (s *Storage) GetMessageDebugInfo(chatID, messageID string) (*models.MessageDebugInfo, error) {
+	var info models.MessageDebugInfo
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(s.debugInfoKey(chatID, messageID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &info)
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// Outbox storage methods
+
+// SaveOutboxEntry adds a message to the outbox
+func (s *Storage) SaveOutboxEntry(entry *models.OutboxEntry) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to marshal outbox entry: %w", err)
+		}
+
+		return txn.Set(s.outboxKey(entry.ChatID, entry.MessageID), data)
+	})
+}
+
+// DeleteOutboxEntry removes a message from the outbox
+func (s *Storage) DeleteOutboxEntry(chatID, messageID string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(s.outboxKey(chatID, messageID))
+	})
+}
+
+// GetOutboxEntries retrieves every message in the outbox
+func (s *Storage) GetOutboxEntries() ([]*models.OutboxEntry, error) {
+	var entries []*models.OutboxEntry
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("outbox/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var entry models.OutboxEntry
+			ok, err := s.decodeItem(it.Item(), &entry)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entries = append(entries, &entry)
+			}
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Audit trail storage methods
+
+// SaveAuditEvent appends an event to the audit trail
+func (s *Storage) SaveAuditEvent(event *models.AuditEvent) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+
+		return txn.Set(s.auditKey(event.Time), data)
+	})
+}
+
+// GetAuditEvents retrieves the audit trail, oldest first
+func (s *Storage) GetAuditEvents() ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte("audit/")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var event models.AuditEvent
+			ok, err := s.decodeItem(it.Item(), &event)
+			if err != nil {
+				return err
+			}
+			if ok {
+				events = append(events, &event)
+			}
+		}
+
+		return nil
+	})
+
+	return events, err
+}
+
+// Attachment storage methods
+
+// AttachmentPath returns where the received file with the given name is kept
+func (s *Storage) AttachmentPath(filename string) string {
+	return filepath.Join(s.dataDir, "downloads", filepath.Base(filename))
+}
+
+// OpenAttachment opens the local copy of a received attachment. It fails if
+// the file is missing or no longer has the attachment's size, which means it
+// was replaced by a later file with the same name.
+func (s *Storage) OpenAttachment(attachment *models.Attachment) (io.ReadCloser, error) {
+	return openAttachment(s.AttachmentPath(attachment.Filename), attachment)
+}
+
+// openAttachment opens an attachment kept at path
+func openAttachment(path string, attachment *models.Attachment) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attachment: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat attachment: %w", err)
+	}
+	if info.Size() != attachment.Size {
+		file.Close()
+		return nil, fmt.Errorf("attachment %s has changed on disk", attachment.Filename)
+	}
+
+	return file, nil
+}
+
 // Configuration storage methods
 
 // SaveConfig saves a configuration value
@@ -365,39 +984,47 @@ func (s *Storage) GetConfig(key string, dest interface{}) error {
 
 // Cleanup methods
 
-// CleanupExpiredMessages removes messages older than the retention period
+// CleanupExpiredMessages removes disappearing messages whose time is up and
+// messages older than the retention period. A chat's own retention setting
+// takes precedence over retentionDays, and a retention of 0 days keeps
+// messages forever.
 func (s *Storage) CleanupExpiredMessages(retentionDays int) error {
-	if retentionDays <= 0 {
-		return nil // No cleanup if retention is disabled
+	conversations, err := s.GetAllConversations()
+	if err != nil {
+		return fmt.Errorf("failed to load conversation settings: %w", err)
 	}
 
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	now := time.Now()
+	deleted := 0
 
-	return s.db.Update(func(txn *badger.Txn) error {
+	err = s.db.Update(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
 		prefix := []byte("messages/")
 		var keysToDelete [][]byte
+		var unindexed []*models.Message
 
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				var msg models.Message
-				if err := json.Unmarshal(val, &msg); err != nil {
-					return err
-				}
-
-				if msg.Timestamp.Before(cutoff) {
-					keysToDelete = append(keysToDelete, item.KeyCopy(nil))
-				}
-
-				return nil
-			})
+			var msg models.Message
+			ok, err := s.decodeItem(item, &msg)
 			if err != nil {
 				return err
 			}
+			if !ok {
+				continue // Left for Repair rather than deleted unread
+			}
+
+			expired := msg.IsExpired()
+			if days := conversations[msg.ChatID].Retention(retentionDays); days > 0 && msg.Timestamp.Before(now.AddDate(0, 0, -days)) {
+				expired = true
+			}
+			if expired {
+				keysToDelete = append(keysToDelete, item.KeyCopy(nil), s.debugInfoKey(msg.ChatID, msg.ID), s.orderKey(&msg))
+				unindexed = append(unindexed, &msg)
+			}
 		}
 
 		// Delete expired messages
@@ -406,9 +1033,50 @@ func (s *Storage) CleanupExpiredMessages(retentionDays int) error {
 				return err
 			}
 		}
+		for _, msg := range unindexed {
+			if err := s.unindexMessage(txn, msg.ChatID, msg.ID); err != nil {
+				return err
+			}
+		}
+		deleted = len(keysToDelete)
+
+		return nil
+	})
+	if err != nil || deleted == 0 {
+		return err
+	}
+	s.forgetMessageCounts()
 
+	// Reclaim the space of a bulk delete now rather than at the next GC tick
+	return s.RunGC(s.gcDiscardRatio)
+}
+
+// secureDelete deletes keys and then runs value-log GC.
+//
+// This is best effort. Badger is an append-only LSM store: a delete writes a
+// tombstone and the old value stays in the value log, and possibly in SST
+// files awaiting compaction, until those files are rewritten. GC shortens that
+// window but only rewrites a log file once enough of it is garbage, and the
+// filesystem or SSD may keep copies of rewritten blocks regardless. Full-disk
+// encryption is the only dependable protection for data at rest.
+func (s *Storage) secureDelete(keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return s.RunGC(s.gcDiscardRatio)
 }
 
 // Key generation methods
@@ -429,10 +1097,50 @@ func (s *Storage) sessionKey(remoteUserID string) []byte {
 	return []byte(fmt.Sprintf("sessions/%s/%s", s.userID, remoteUserID))
 }
 
+func (s *Storage) sessionPrefix() []byte {
+	return []byte(fmt.Sprintf("sessions/%s/", s.userID))
+}
+
 func (s *Storage) identityKey(userID string) []byte {
 	return []byte(fmt.Sprintf("identities/%s", userID))
 }
 
+func (s *Storage) conversationKey(chatID string) []byte {
+	return []byte(fmt.Sprintf("conversations/%s", chatID))
+}
+
+func (s *Storage) deliveryKey(chatID, messageID string) []byte {
+	return []byte(fmt.Sprintf("deliveries/%s/%s", chatID, messageID))
+}
+
+func (s *Storage) debugInfoKey(chatID, messageID string) []byte {
+	return []byte(fmt.Sprintf("debug/%s/%s", chatID, messageID))
+}
+
+// searchPostingKey holds no value: the key itself records that a message
+// contains the word blinded as blinded
+func (s *Storage) searchPostingKey(blinded, chatID, messageID string) []byte {
+	return []byte(fmt.Sprintf("search/%s/%s/%s", blinded, chatID, messageID))
+}
+
+func (s *Storage) searchPostingPrefix(blinded string) []byte {
+	return []byte(fmt.Sprintf("search/%s/", blinded))
+}
+
+func (s *Storage) searchDocKey(chatID, messageID string) []byte {
+	return []byte(fmt.Sprintf("searchdocs/%s/%s", chatID, messageID))
+}
+
+func (s *Storage) outboxKey(chatID, messageID string) []byte {
+	return []byte(fmt.Sprintf("outbox/%s/%s", chatID, messageID))
+}
+
+// auditKey orders audit events by time. The zero-padded nanosecond
+// timestamp sorts lexically in time order.
+func (s *Storage) auditKey(t time.Time) []byte {
+	return []byte(fmt.Sprintf("audit/%020d", t.UnixNano()))
+}
+
 func (s *Storage) configKey(key string) []byte {
 	return []byte(fmt.Sprintf("config/%s", key))
 }
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// newTestStorage opens a Badger store in a temporary directory with the
+// given GC interval
+func newTestStorage(t *testing.T, gcInterval time.Duration) *Storage {
+	t.Helper()
+
+	store, err := NewStorage(StorageOptions{
+		DataDir:      t.TempDir(),
+		UserID:       "alice",
+		NoSyncWrites: true,
+		GCInterval:   gcInterval,
+	})
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	return store
+}
+
+// saveAndDeleteBulk saves count messages large enough to go to the value
+// log, then deletes them all
+func saveAndDeleteBulk(t *testing.T, store *Storage, count int) {
+	t.Helper()
+
+	content := strings.Repeat("x", 2<<20)
+	for i := 0; i < count; i++ {
+		saveMessages(t, store, testMessage(fmt.Sprintf("m%d", i), uint64(i+1), content))
+	}
+	for i := 0; i < count; i++ {
+		if err := store.DeleteMessage("alice:bob", fmt.Sprintf("m%d", i)); err != nil {
+			t.Fatalf("DeleteMessage: %v", err)
+		}
+	}
+}
+
+func TestRunGCAfterBulkDeletes(t *testing.T) {
+	store := newTestStorage(t, -1)
+	defer store.Close()
+	saveAndDeleteBulk(t, store, 4)
+
+	if err := store.RunGC(0.01); err != nil {
+		t.Errorf("RunGC: %v", err)
+	}
+	if count, err := store.MessageCount("alice:bob"); err != nil || count != 0 {
+		t.Errorf("MessageCount after GC = %d, %v, want 0", count, err)
+	}
+}
+
+func TestBackgroundGCStopsOnClose(t *testing.T) {
+	store := newTestStorage(t, time.Millisecond)
+	saveAndDeleteBulk(t, store, 2)
+	time.Sleep(20 * time.Millisecond) // Let a few runs start
+
+	closed := make(chan error, 1)
+	go func() { closed <- store.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close did not stop the background GC")
+	}
+}
+
+func TestSecureDeleteRunsGC(t *testing.T) {
+	store := newTestStorage(t, -1)
+	defer store.Close()
+	saveMessages(t, store, testMessage("m1", 1, "secret"))
+	if err := store.SaveSession(&models.Session{ID: "s1", LocalUserID: "alice", RemoteUserID: "bob", RootKey: []byte("root")}); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+
+	runs := store.gcRuns.Load()
+	if err := store.SecureDeleteMessages("alice:bob", []string{"m1"}); err != nil {
+		t.Fatalf("SecureDeleteMessages: %v", err)
+	}
+	if store.gcRuns.Load() != runs+1 {
+		t.Error("SecureDeleteMessages did not run GC")
+	}
+	if err := store.SecureDeleteSession("bob"); err != nil {
+		t.Fatalf("SecureDeleteSession: %v", err)
+	}
+	if store.gcRuns.Load() != runs+2 {
+		t.Error("SecureDeleteSession did not run GC")
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ErrNotFound is returned when a requested record is not stored. Every
+// backend returns it, so callers can check for it with errors.Is.
+var ErrNotFound = badger.ErrKeyNotFound
+
+// Store is the persistent state of a client. Records are stored as copies:
+// changing a saved or returned value does not change what is stored.
+// Methods returning many records skip any that are corrupt, logging them,
+// rather than failing; Repair finds and quarantines them.
+type Store interface {
+	// Messages. Lists of a chat's messages are in display order, as
+	// models.SortMessages sorts them: by sequence number, then display
+	// time, then ID. Message IDs do not follow the order messages were
+	// sent in, so no backend returns them in key order.
+	SaveMessage(msg *models.Message) error
+	GetMessage(chatID, messageID string) (*models.Message, error)
+	HasMessage(chatID, messageID string) (bool, error)
+	GetMessages(chatID string, limit int, offset int) ([]*models.Message, error)
+	GetMessagesBefore(chatID string, before *models.Message, limit int) ([]*models.Message, error)
+	MaxSequence(chatID string) (uint64, error)
+	MessageCount(chatID string) (int, error)
+	DeleteMessage(chatID, messageID string) error
+	SecureDeleteMessages(chatID string, messageIDs []string) error
+	CleanupExpiredMessages(retentionDays int) error
+
+	// Message search
+	OpenSearchIndex(key []byte) error
+	SearchMessages(query string, limit int) ([]*models.Message, error)
+
+	// Contacts
+	SaveContact(contact *models.Contact) error
+	GetContact(userID string) (*models.Contact, error)
+	GetAllContacts() ([]*models.Contact, error)
+	DeleteContact(userID string) error
+
+	// Sessions of the local user
+	SaveSession(session *models.Session) error
+	GetSession(remoteUserID string) (*models.Session, error)
+	GetAllSessions() ([]*models.Session, error)
+	DeleteSession(remoteUserID string) error
+	SecureDeleteSession(remoteUserID string) error
+
+	// Identities
+	SaveIdentity(identity *models.Identity) error
+	GetIdentity(userID string) (*models.Identity, error)
+
+	// Conversation settings
+	SaveConversation(conversation *models.Conversation) error
+	GetConversation(chatID string) (*models.Conversation, error)
+	GetAllConversations() (map[string]*models.Conversation, error)
+
+	// Delivery timeouts, debug info, outbox and audit trail
+	SavePendingDelivery(pending *models.PendingDelivery) error
+	DeletePendingDelivery(chatID, messageID string) error
+	GetPendingDeliveries() ([]*models.PendingDelivery, error)
+	SaveMessageDebugInfo(info *models.MessageDebugInfo) error
+	GetMessageDebugInfo(chatID, messageID string) (*models.MessageDebugInfo, error)
+	SaveOutboxEntry(entry *models.OutboxEntry) error
+	DeleteOutboxEntry(chatID, messageID string) error
+	GetOutboxEntries() ([]*models.OutboxEntry, error)
+	SaveAuditEvent(event *models.AuditEvent) error
+	GetAuditEvents() ([]*models.AuditEvent, error)
+
+	// Received attachments, kept as files in the data directory
+	AttachmentPath(filename string) string
+	OpenAttachment(attachment *models.Attachment) (io.ReadCloser, error)
+
+	// Configuration values
+	SaveConfig(key string, value interface{}) error
+	GetConfig(key string, dest interface{}) error
+
+	// Integrity and usage
+	SkippedRecords() int
+	Repair(quarantine bool) (*RepairReport, error)
+	Usage() (*Usage, error)
+
+	Close() error
+}
+
+// Backend names a Store implementation
+type Backend string
+
+const (
+	// BackendBadger keeps everything in a Badger database in the data
+	// directory. It is the default.
+	BackendBadger Backend = "badger"
+	// BackendMemory keeps everything in memory, so nothing but received
+	// attachments outlives the process
+	BackendMemory Backend = "memory"
+	// BackendSQLite keeps everything in a SQLite database file in the data
+	// directory
+	BackendSQLite Backend = "sqlite"
+)
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+// Open opens the store of the backend chosen in opts
+func Open(opts StorageOptions) (Store, error) {
+	switch opts.Backend {
+	case BackendBadger, "":
+		return NewStorage(opts)
+	case BackendMemory:
+		return NewMemoryStore(opts)
+	case BackendSQLite:
+		return NewSQLiteStore(opts)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", opts.Backend)
+}
@@ -0,0 +1,711 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// backends opens an empty store of each backend with the given limits,
+// closed with the test. Every test of the Store contract runs against all
+// of them.
+var backends = []struct {
+	name string
+	open func(t *testing.T, limits Limits) Store
+}{
+	{"badger", func(t *testing.T, limits Limits) Store {
+		store, err := NewStorage(StorageOptions{
+			DataDir:      t.TempDir(),
+			UserID:       "alice",
+			Limits:       limits,
+			NoSyncWrites: true,
+			GCInterval:   -1,
+		})
+		if err != nil {
+			t.Fatalf("NewStorage: %v", err)
+		}
+		return store
+	}},
+	{"sqlite", func(t *testing.T, limits Limits) Store {
+		store, err := NewSQLiteStore(StorageOptions{DataDir: t.TempDir(), UserID: "alice", Limits: limits})
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		return store
+	}},
+	{"memory", func(t *testing.T, limits Limits) Store {
+		store, err := NewMemoryStore(StorageOptions{DataDir: t.TempDir(), UserID: "alice", Limits: limits})
+		if err != nil {
+			t.Fatalf("NewMemoryStore: %v", err)
+		}
+		return store
+	}},
+}
+
+// forEachBackend runs test against a fresh store of every backend
+func forEachBackend(t *testing.T, test func(t *testing.T, store Store)) {
+	forEachLimitedBackend(t, Limits{}, test)
+}
+
+// forEachLimitedBackend runs test against a fresh store of every backend
+// with limits
+func forEachLimitedBackend(t *testing.T, limits Limits, test func(t *testing.T, store Store)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t, limits)
+			t.Cleanup(func() { store.Close() })
+			test(t, store)
+		})
+	}
+}
+
+// testMessage returns a chat message in chat "alice:bob"
+func testMessage(id string, sequence uint64, content string) *models.Message {
+	return &models.Message{
+		ID:        id,
+		Type:      models.MessageTypeChat,
+		From:      "bob",
+		To:        "alice",
+		ChatID:    "alice:bob",
+		Content:   content,
+		Timestamp: time.Date(2026, 3, 1, 12, 0, int(sequence), 0, time.UTC),
+		Sequence:  sequence,
+	}
+}
+
+// saveMessages saves msgs, failing the test on the first error
+func saveMessages(t *testing.T, store Store, msgs ...*models.Message) {
+	t.Helper()
+
+	for _, msg := range msgs {
+		if err := store.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage(%s): %v", msg.ID, err)
+		}
+	}
+}
+
+// messageIDs returns the IDs of msgs, in order
+func messageIDs(msgs []*models.Message) string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestStoreMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		msg := testMessage("m1", 1, "hello")
+		saveMessages(t, store, msg)
+
+		// Stored values are copies
+		msg.Content = "changed"
+		got, err := store.GetMessage("alice:bob", "m1")
+		if err != nil {
+			t.Fatalf("GetMessage: %v", err)
+		}
+		if got.Content != "hello" {
+			t.Errorf("Content = %q, want %q", got.Content, "hello")
+		}
+
+		if has, err := store.HasMessage("alice:bob", "m1"); err != nil || !has {
+			t.Errorf("HasMessage(m1) = %v, %v, want true", has, err)
+		}
+		if has, err := store.HasMessage("alice:bob", "m2"); err != nil || has {
+			t.Errorf("HasMessage(m2) = %v, %v, want false", has, err)
+		}
+		if _, err := store.GetMessage("alice:bob", "m2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMessage of a missing message: %v, want ErrNotFound", err)
+		}
+
+		if err := store.DeleteMessage("alice:bob", "m1"); err != nil {
+			t.Fatalf("DeleteMessage: %v", err)
+		}
+		if _, err := store.GetMessage("alice:bob", "m1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMessage after DeleteMessage: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreMessageOrder(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		// Saved out of order, with IDs that do not sort by sequence
+		saveMessages(t, store,
+			testMessage("z", 3, "third"),
+			testMessage("a", 5, "fifth"),
+			testMessage("m", 1, "first"),
+			testMessage("b", 4, "fourth"),
+			testMessage("y", 2, "second"),
+		)
+		saveMessages(t, store, &models.Message{ID: "other", ChatID: "alice:carol", Sequence: 9})
+
+		all, err := store.GetMessages("alice:bob", 10, 0)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		if got, want := messageIDs(all), "m,y,z,b,a"; got != want {
+			t.Errorf("GetMessages = %s, want %s", got, want)
+		}
+
+		page, err := store.GetMessages("alice:bob", 2, 1)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		if got, want := messageIDs(page), "y,z"; got != want {
+			t.Errorf("GetMessages(2, 1) = %s, want %s", got, want)
+		}
+
+		latest, err := store.GetMessagesBefore("alice:bob", nil, 2)
+		if err != nil {
+			t.Fatalf("GetMessagesBefore: %v", err)
+		}
+		if got, want := messageIDs(latest), "b,a"; got != want {
+			t.Errorf("GetMessagesBefore(nil, 2) = %s, want %s", got, want)
+		}
+		older, err := store.GetMessagesBefore("alice:bob", latest[0], 2)
+		if err != nil {
+			t.Fatalf("GetMessagesBefore: %v", err)
+		}
+		if got, want := messageIDs(older), "y,z"; got != want {
+			t.Errorf("GetMessagesBefore(b, 2) = %s, want %s", got, want)
+		}
+
+		if max, err := store.MaxSequence("alice:bob"); err != nil || max != 5 {
+			t.Errorf("MaxSequence = %d, %v, want 5", max, err)
+		}
+		if count, err := store.MessageCount("alice:bob"); err != nil || count != 5 {
+			t.Errorf("MessageCount = %d, %v, want 5", count, err)
+		}
+		if max, err := store.MaxSequence("alice:dave"); err != nil || max != 0 {
+			t.Errorf("MaxSequence of an empty chat = %d, %v, want 0", max, err)
+		}
+	})
+}
+
+func TestStoreMessageOrderBreaksTies(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		at := func(id string, sequence uint64, second int) *models.Message {
+			msg := testMessage(id, sequence, id)
+			msg.Timestamp = time.Date(2026, 3, 1, 12, 0, second, 0, time.UTC)
+			return msg
+		}
+		// A skewed sender's message is ordered by when it arrived
+		skewed := at("k", 2, 59)
+		skewed.ClockSkewed = true
+		skewed.ReceivedAt = time.Date(2026, 3, 1, 12, 0, 15, 0, time.UTC)
+
+		// Neither IDs nor timestamps follow the sequence numbers, and
+		// messages without sequence numbers come first by time
+		saveMessages(t, store,
+			at("a", 3, 5),
+			at("q", 2, 20),
+			at("c", 2, 10),
+			at("p", 2, 10),
+			at("d", 2, 10),
+			skewed,
+			at("z", 1, 50),
+			at("x", 0, 40),
+			at("w", 0, 30),
+		)
+		want := "w,x,z,c,d,p,k,q,a"
+
+		all, err := store.GetMessages("alice:bob", 20, 0)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		if got := messageIDs(all); got != want {
+			t.Errorf("GetMessages = %s, want %s", got, want)
+		}
+		sorted := append([]*models.Message(nil), all...)
+		models.SortMessages(sorted)
+		if got := messageIDs(sorted); got != messageIDs(all) {
+			t.Errorf("GetMessages = %s, not in the order SortMessages gives, %s", messageIDs(all), got)
+		}
+
+		// Paging back from the newest walks the same order
+		var paged []string
+		var before *models.Message
+		for {
+			page, err := store.GetMessagesBefore("alice:bob", before, 2)
+			if err != nil {
+				t.Fatalf("GetMessagesBefore: %v", err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			paged = append([]string{messageIDs(page)}, paged...)
+			before = page[0]
+		}
+		if got := strings.Join(paged, ","); got != want {
+			t.Errorf("paging with GetMessagesBefore gives %s, want %s", got, want)
+		}
+	})
+}
+
+func TestStoreSecureDeleteMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		saveMessages(t, store, testMessage("m1", 1, "one"), testMessage("m2", 2, "two"))
+		info := &models.MessageDebugInfo{ChatID: "alice:bob", MessageID: "m1", ReceivedAt: time.Now()}
+		if err := store.SaveMessageDebugInfo(info); err != nil {
+			t.Fatalf("SaveMessageDebugInfo: %v", err)
+		}
+
+		if err := store.SecureDeleteMessages("alice:bob", []string{"m1"}); err != nil {
+			t.Fatalf("SecureDeleteMessages: %v", err)
+		}
+		if has, _ := store.HasMessage("alice:bob", "m1"); has {
+			t.Error("m1 is still stored")
+		}
+		if has, _ := store.HasMessage("alice:bob", "m2"); !has {
+			t.Error("m2 was deleted too")
+		}
+		if _, err := store.GetMessageDebugInfo("alice:bob", "m1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMessageDebugInfo after deleting: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreCleanupExpiredMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		expired := testMessage("expired", 1, "gone")
+		expired.Metadata = &models.Metadata{ExpiresAt: time.Now().Add(-time.Minute)}
+		old := testMessage("old", 2, "old")
+		old.Timestamp = time.Now().AddDate(0, 0, -10)
+		kept := testMessage("kept", 3, "kept")
+		kept.Timestamp = time.Now()
+		saveMessages(t, store, expired, old, kept)
+
+		if err := store.CleanupExpiredMessages(7); err != nil {
+			t.Fatalf("CleanupExpiredMessages: %v", err)
+		}
+		for id, want := range map[string]bool{"expired": false, "old": false, "kept": true} {
+			if has, _ := store.HasMessage("alice:bob", id); has != want {
+				t.Errorf("HasMessage(%s) = %v, want %v", id, has, want)
+			}
+		}
+	})
+}
+
+func TestStoreHidesExpiredMessagesBeforeTheSweep(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		expired := testMessage("expired", 1, "gone")
+		expired.Metadata = &models.Metadata{ExpiresAt: time.Now().Add(-time.Second)}
+		expiring := testMessage("expiring", 2, "soon gone")
+		expiring.Metadata = &models.Metadata{ExpiresAt: time.Now().Add(time.Hour)}
+		saveMessages(t, store, expired, expiring, testMessage("kept", 3, "kept"))
+
+		messages, err := store.GetMessages("alice:bob", 10, 0)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		if got := messageIDs(messages); got != "expiring,kept" {
+			t.Errorf("GetMessages = %s, want expiring,kept", got)
+		}
+		page, err := store.GetMessagesBefore("alice:bob", nil, 10)
+		if err != nil {
+			t.Fatalf("GetMessagesBefore: %v", err)
+		}
+		if got := messageIDs(page); got != "expiring,kept" {
+			t.Errorf("GetMessagesBefore = %s, want expiring,kept", got)
+		}
+	})
+}
+
+func TestStoreCleanupConversationRetention(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		forever, short := 0, 3
+		saveConversation := func(chatID string, days *int) {
+			if err := store.SaveConversation(&models.Conversation{ChatID: chatID, RetentionDays: days}); err != nil {
+				t.Fatalf("SaveConversation: %v", err)
+			}
+		}
+		saveConversation("alice:bob", &forever)
+		saveConversation("alice:carol", &short)
+
+		old := time.Now().AddDate(0, 0, -5)
+		for _, chatID := range []string{"alice:bob", "alice:carol", "alice:dave"} {
+			msg := testMessage("old", 1, "old")
+			msg.ChatID = chatID
+			msg.Timestamp = old
+			saveMessages(t, store, msg)
+		}
+
+		if err := store.CleanupExpiredMessages(7); err != nil {
+			t.Fatalf("CleanupExpiredMessages: %v", err)
+		}
+		for chatID, want := range map[string]bool{"alice:bob": true, "alice:carol": false, "alice:dave": true} {
+			if has, _ := store.HasMessage(chatID, "old"); has != want {
+				t.Errorf("HasMessage in %s = %v, want %v", chatID, has, want)
+			}
+		}
+	})
+}
+
+func TestStoreSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		saveMessages(t, store, testMessage("m1", 1, "Lunch on Friday?"))
+		if err := store.OpenSearchIndex(make([]byte, SearchKeySize)); err != nil {
+			t.Fatalf("OpenSearchIndex: %v", err)
+		}
+		saveMessages(t, store, testMessage("m2", 2, "Friday works"))
+
+		found, err := store.SearchMessages("friday", 10)
+		if err != nil {
+			t.Fatalf("SearchMessages: %v", err)
+		}
+		if len(found) != 2 {
+			t.Errorf("SearchMessages(friday) found %s, want m1 and m2", messageIDs(found))
+		}
+
+		if err := store.DeleteMessage("alice:bob", "m1"); err != nil {
+			t.Fatalf("DeleteMessage: %v", err)
+		}
+		found, err = store.SearchMessages("lunch", 10)
+		if err != nil {
+			t.Fatalf("SearchMessages: %v", err)
+		}
+		if len(found) != 0 {
+			t.Errorf("SearchMessages(lunch) found deleted messages %s", messageIDs(found))
+		}
+	})
+}
+
+func TestStoreContacts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		for _, id := range []string{"bob", "carol"} {
+			if err := store.SaveContact(&models.Contact{UserID: id, DisplayName: id}); err != nil {
+				t.Fatalf("SaveContact(%s): %v", id, err)
+			}
+		}
+
+		contact, err := store.GetContact("bob")
+		if err != nil {
+			t.Fatalf("GetContact: %v", err)
+		}
+		if contact.DisplayName != "bob" {
+			t.Errorf("DisplayName = %q, want bob", contact.DisplayName)
+		}
+		if all, err := store.GetAllContacts(); err != nil || len(all) != 2 {
+			t.Errorf("GetAllContacts = %d contacts, %v, want 2", len(all), err)
+		}
+
+		if err := store.DeleteContact("bob"); err != nil {
+			t.Fatalf("DeleteContact: %v", err)
+		}
+		if _, err := store.GetContact("bob"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetContact after DeleteContact: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		session := &models.Session{ID: "s1", LocalUserID: "alice", RemoteUserID: "bob", RootKey: []byte("root")}
+		if err := store.SaveSession(session); err != nil {
+			t.Fatalf("SaveSession: %v", err)
+		}
+
+		got, err := store.GetSession("bob")
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if string(got.RootKey) != "root" {
+			t.Errorf("RootKey = %q, want root", got.RootKey)
+		}
+		if all, err := store.GetAllSessions(); err != nil || len(all) != 1 {
+			t.Errorf("GetAllSessions = %d sessions, %v, want 1", len(all), err)
+		}
+
+		if err := store.SecureDeleteSession("bob"); err != nil {
+			t.Fatalf("SecureDeleteSession: %v", err)
+		}
+		if _, err := store.GetSession("bob"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetSession after SecureDeleteSession: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreIdentity(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		if _, err := store.GetIdentity("alice"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetIdentity before saving: %v, want ErrNotFound", err)
+		}
+
+		identity := &models.Identity{
+			UserID:             "alice",
+			IdentityKey:        []byte("signing public"),
+			SigningPrivateKey:  []byte("signing private"),
+			ExchangeKey:        []byte("exchange public"),
+			ExchangePrivateKey: []byte("exchange private"),
+			PreKeyID:           7,
+		}
+		if err := store.SaveIdentity(identity); err != nil {
+			t.Fatalf("SaveIdentity: %v", err)
+		}
+		got, err := store.GetIdentity("alice")
+		if err != nil {
+			t.Fatalf("GetIdentity: %v", err)
+		}
+		if string(got.SigningPrivateKey) != "signing private" || string(got.ExchangePrivateKey) != "exchange private" || got.PreKeyID != 7 {
+			t.Errorf("GetIdentity = %+v, want the saved keys", got)
+		}
+	})
+}
+
+func TestStoreConversations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		days := 3
+		if err := store.SaveConversation(&models.Conversation{ChatID: "alice:bob", RetentionDays: &days}); err != nil {
+			t.Fatalf("SaveConversation: %v", err)
+		}
+
+		got, err := store.GetConversation("alice:bob")
+		if err != nil {
+			t.Fatalf("GetConversation: %v", err)
+		}
+		if got.RetentionDays == nil || *got.RetentionDays != 3 {
+			t.Errorf("RetentionDays = %v, want 3", got.RetentionDays)
+		}
+		all, err := store.GetAllConversations()
+		if err != nil || len(all) != 1 || all["alice:bob"] == nil {
+			t.Errorf("GetAllConversations = %v, %v, want alice:bob", all, err)
+		}
+	})
+}
+
+func TestStoreDeliveryRecords(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		for i := 1; i <= 2; i++ {
+			id := fmt.Sprintf("m%d", i)
+			if err := store.SavePendingDelivery(&models.PendingDelivery{ChatID: "alice:bob", MessageID: id, Deadline: time.Now()}); err != nil {
+				t.Fatalf("SavePendingDelivery: %v", err)
+			}
+			if err := store.SaveOutboxEntry(&models.OutboxEntry{ChatID: "alice:bob", MessageID: id}); err != nil {
+				t.Fatalf("SaveOutboxEntry: %v", err)
+			}
+		}
+		if err := store.DeletePendingDelivery("alice:bob", "m1"); err != nil {
+			t.Fatalf("DeletePendingDelivery: %v", err)
+		}
+		if err := store.DeleteOutboxEntry("alice:bob", "m2"); err != nil {
+			t.Fatalf("DeleteOutboxEntry: %v", err)
+		}
+
+		pending, err := store.GetPendingDeliveries()
+		if err != nil || len(pending) != 1 || pending[0].MessageID != "m2" {
+			t.Errorf("GetPendingDeliveries = %v, %v, want m2", pending, err)
+		}
+		outbox, err := store.GetOutboxEntries()
+		if err != nil || len(outbox) != 1 || outbox[0].MessageID != "m1" {
+			t.Errorf("GetOutboxEntries = %v, %v, want m1", outbox, err)
+		}
+
+		event := &models.AuditEvent{Time: time.Now(), UserID: "bob", Detail: "verified"}
+		if err := store.SaveAuditEvent(event); err != nil {
+			t.Fatalf("SaveAuditEvent: %v", err)
+		}
+		if events, err := store.GetAuditEvents(); err != nil || len(events) != 1 {
+			t.Errorf("GetAuditEvents = %d events, %v, want 1", len(events), err)
+		}
+	})
+}
+
+func TestStoreConfig(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		if err := store.SaveConfig("unread/alice:bob", 4); err != nil {
+			t.Fatalf("SaveConfig: %v", err)
+		}
+
+		var count int
+		if err := store.GetConfig("unread/alice:bob", &count); err != nil || count != 4 {
+			t.Errorf("GetConfig = %d, %v, want 4", count, err)
+		}
+		if err := store.GetConfig("missing", &count); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetConfig of a missing key: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreAttachments(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		path := store.AttachmentPath("../../photo.png")
+		if filepath.Base(filepath.Dir(path)) != "downloads" || filepath.Base(path) != "photo.png" {
+			t.Fatalf("AttachmentPath = %s, want photo.png in the downloads directory", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("pixels"), 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+
+		file, err := store.OpenAttachment(&models.Attachment{Filename: "photo.png", Size: 6})
+		if err != nil {
+			t.Fatalf("OpenAttachment: %v", err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil || string(data) != "pixels" {
+			t.Errorf("read %q, %v, want the attachment", data, err)
+		}
+
+		if _, err := store.OpenAttachment(&models.Attachment{Filename: "photo.png", Size: 7}); err == nil {
+			t.Error("opened an attachment whose size changed")
+		}
+		if _, err := store.OpenAttachment(&models.Attachment{Filename: "missing.png"}); err == nil {
+			t.Error("opened a missing attachment")
+		}
+	})
+}
+
+func TestStoreUsageAndIntegrity(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		for _, id := range []string{"bob", "carol"} {
+			if err := store.SaveContact(&models.Contact{UserID: id}); err != nil {
+				t.Fatalf("SaveContact(%s): %v", id, err)
+			}
+		}
+		other := testMessage("c1", 1, "hi carol")
+		other.ChatID = "alice:carol"
+		saveMessages(t, store, testMessage("m1", 1, "one"), testMessage("m2", 2, "two"), testMessage("m3", 3, "three"), other)
+
+		usage, err := store.Usage()
+		if err != nil {
+			t.Fatalf("Usage: %v", err)
+		}
+		if usage.Contacts != 2 || usage.Chats != 2 || usage.Messages != 4 || usage.LargestChat != 3 {
+			t.Errorf("Usage = %+v, want 2 contacts and 4 messages in 2 chats, the largest of 3", usage)
+		}
+
+		report, err := store.Repair(false)
+		if err != nil {
+			t.Fatalf("Repair: %v", err)
+		}
+		if report.Checked < 6 || len(report.Corrupt) != 0 || report.Quarantined != 0 {
+			t.Errorf("Repair = %+v, want every record checked and none corrupt", report)
+		}
+		if skipped := store.SkippedRecords(); skipped != 0 {
+			t.Errorf("SkippedRecords = %d, want 0", skipped)
+		}
+	})
+}
+
+func TestStoreEvictsOldestMessages(t *testing.T) {
+	forEachLimitedBackend(t, Limits{MaxMessagesPerChat: 3}, func(t *testing.T, store Store) {
+		// Saved out of order, so the oldest are not the first saved
+		saveMessages(t, store,
+			testMessage("m3", 3, "three"), testMessage("m1", 1, "one"),
+			testMessage("m4", 4, "four"), testMessage("m2", 2, "two"),
+		)
+		messages, err := store.GetMessages("alice:bob", 10, 0)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		if got := messageIDs(messages); got != "m2,m3,m4" {
+			t.Errorf("messages after reaching the limit = %s, want m2,m3,m4", got)
+		}
+
+		saveMessages(t, store, testMessage("m5", 5, "five"))
+		messages, _ = store.GetMessages("alice:bob", 10, 0)
+		if got := messageIDs(messages); got != "m3,m4,m5" {
+			t.Errorf("messages after another = %s, want m3,m4,m5", got)
+		}
+		if count, err := store.MessageCount("alice:bob"); err != nil || count != 3 {
+			t.Errorf("MessageCount = %d, %v, want 3", count, err)
+		}
+
+		// Updating a stored message evicts nothing
+		update := testMessage("m3", 3, "three, edited")
+		saveMessages(t, store, update)
+		messages, _ = store.GetMessages("alice:bob", 10, 0)
+		if got := messageIDs(messages); got != "m3,m4,m5" {
+			t.Errorf("messages after an update = %s, want m3,m4,m5", got)
+		}
+	})
+}
+
+func TestStoreRejectsFullChats(t *testing.T) {
+	forEachLimitedBackend(t, Limits{MaxMessagesPerChat: 5, RejectFullChats: true}, func(t *testing.T, store Store) {
+		// Saves racing for the last places fill the chat exactly
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 1; i <= 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- store.SaveMessage(testMessage(fmt.Sprintf("m%02d", i), uint64(i), "hi"))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		rejected := 0
+		for err := range errs {
+			switch {
+			case errors.Is(err, ErrLimitReached):
+				rejected++
+			case err != nil:
+				t.Errorf("SaveMessage: %v", err)
+			}
+		}
+		if rejected != 15 {
+			t.Errorf("%d saves rejected, want 15", rejected)
+		}
+		if count, err := store.MessageCount("alice:bob"); err != nil || count != 5 {
+			t.Errorf("MessageCount = %d, %v, want 5", count, err)
+		}
+
+		// Stored messages may still be updated, and other chats take new ones
+		stored, err := store.GetMessages("alice:bob", 1, 0)
+		if err != nil || len(stored) != 1 {
+			t.Fatalf("GetMessages = %d messages, %v", len(stored), err)
+		}
+		stored[0].Content = "edited"
+		if err := store.SaveMessage(stored[0]); err != nil {
+			t.Errorf("updating a message of a full chat: %v", err)
+		}
+		other := testMessage("c1", 1, "hi carol")
+		other.ChatID = "alice:carol"
+		if err := store.SaveMessage(other); err != nil {
+			t.Errorf("saving to another chat: %v", err)
+		}
+	})
+}
+
+func TestStoreContactLimit(t *testing.T) {
+	forEachLimitedBackend(t, Limits{MaxContacts: 2}, func(t *testing.T, store Store) {
+		for _, id := range []string{"bob", "carol"} {
+			if err := store.SaveContact(&models.Contact{UserID: id}); err != nil {
+				t.Fatalf("SaveContact(%s): %v", id, err)
+			}
+		}
+		if err := store.SaveContact(&models.Contact{UserID: "dave"}); !errors.Is(err, ErrLimitReached) {
+			t.Errorf("SaveContact beyond the limit = %v, want ErrLimitReached", err)
+		}
+		if err := store.SaveContact(&models.Contact{UserID: "bob", Nickname: "Bobby"}); err != nil {
+			t.Errorf("updating a contact at the limit: %v", err)
+		}
+
+		// Deleting one makes room again, once
+		if err := store.DeleteContact("carol"); err != nil {
+			t.Fatalf("DeleteContact: %v", err)
+		}
+		if err := store.DeleteContact("carol"); err != nil {
+			t.Fatalf("DeleteContact again: %v", err)
+		}
+		if err := store.SaveContact(&models.Contact{UserID: "dave"}); err != nil {
+			t.Errorf("SaveContact after deleting one: %v", err)
+		}
+		if err := store.SaveContact(&models.Contact{UserID: "erin"}); !errors.Is(err, ErrLimitReached) {
+			t.Errorf("SaveContact beyond the limit again = %v, want ErrLimitReached", err)
+		}
+	})
+}
@@ -0,0 +1,659 @@
+package transfer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultChunkSize is the number of file bytes carried by a single chunk message
+const DefaultChunkSize = 16 * 1024
+
+// MaxFileSize is the largest file that can be transferred
+const MaxFileSize = 1 << 30
+
+// maxFilenameLength is the longest file name a manifest may carry
+const maxFilenameLength = 255
+
+// transferIDPrefix starts every transfer ID, which is followed by 32
+// lowercase hex digits
+const transferIDPrefix = "xfer_"
+
+// Direction indicates whether a transfer is being sent or received
+type Direction int
+
+const (
+	DirectionOutgoing Direction = iota
+	DirectionIncoming
+)
+
+// Manifest describes a file offered for transfer
+type Manifest struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+	Checksum  string `json:"checksum"`
+}
+
+// Chunk is a single piece of a file
+type Chunk struct {
+	TransferID string `json:"transfer_id"`
+	Index      int    `json:"index"`
+	Data       []byte `json:"data"`
+}
+
+// ChunkRequest asks the sender for specific chunks of a transfer
+type ChunkRequest struct {
+	TransferID string `json:"transfer_id"`
+	Indices    []int  `json:"indices"`
+}
+
+// Progress reports how far a transfer has got
+type Progress struct {
+	TransferID string
+	Filename   string
+	Direction  Direction
+	Current    int64
+	Total      int64
+	Done       bool
+	Err        error
+}
+
+// outgoingState is the persisted state of a file we are sending
+type outgoingState struct {
+	Manifest Manifest `json:"manifest"`
+	Path     string   `json:"path"`
+	Peer     string   `json:"peer"`
+	Sent     []bool   `json:"-"`
+}
+
+// incomingState is the persisted state of a file we are receiving
+type incomingState struct {
+	Manifest    Manifest `json:"manifest"`
+	Destination string   `json:"destination"`
+	Peer        string   `json:"peer"`
+	Received    []bool   `json:"received"`
+}
+
+// Manager tracks file transfers and persists their state so that they can be
+// resumed after a reconnect or restart
+type Manager struct {
+	dir      string
+	mu       sync.Mutex
+	outgoing map[string]*outgoingState
+	incoming map[string]*incomingState
+	progress chan Progress
+}
+
+// NewManager creates a transfer manager storing its state under dir and
+// reloads any transfers that were in progress
+func NewManager(dir string) (*Manager, error) {
+	for _, sub := range []string{"outgoing", "incoming"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create transfer directory: %w", err)
+		}
+	}
+
+	m := &Manager{
+		dir:      dir,
+		outgoing: make(map[string]*outgoingState),
+		incoming: make(map[string]*incomingState),
+		progress: make(chan Progress, 100),
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Progress returns the channel on which progress events are published
+func (m *Manager) Progress() <-chan Progress {
+	return m.progress
+}
+
+// Offer registers a local file for sending to peer and returns its manifest
+func (m *Manager) Offer(path, peer string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum file: %w", err)
+	}
+	if size > MaxFileSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", MaxFileSize)
+	}
+
+	id, err := generateTransferID()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &outgoingState{
+		Manifest: Manifest{
+			ID:        id,
+			Filename:  filepath.Base(path),
+			Size:      size,
+			ChunkSize: DefaultChunkSize,
+			Checksum:  hex.EncodeToString(hash.Sum(nil)),
+		},
+		Path: path,
+		Peer: peer,
+	}
+	state.Sent = make([]bool, state.Manifest.ChunkCount())
+
+	if err := m.saveState("outgoing", id, state); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.outgoing[id] = state
+	m.mu.Unlock()
+
+	manifest := state.Manifest
+	return &manifest, nil
+}
+
+// ReadChunk reads a chunk of an outgoing transfer for sending to the peer
+// who asked for it, which must be the one the file was offered to
+func (m *Manager) ReadChunk(transferID, peer string, index int) (*Chunk, error) {
+	m.mu.Lock()
+	state, exists := m.outgoing[transferID]
+	m.mu.Unlock()
+	if !exists || state.Peer != peer {
+		return nil, fmt.Errorf("unknown outgoing transfer: %s", transferID)
+	}
+
+	manifest := state.Manifest
+	if index < 0 || index >= manifest.ChunkCount() {
+		return nil, fmt.Errorf("chunk %d out of range for transfer %s", index, transferID)
+	}
+
+	file, err := os.Open(state.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	data := make([]byte, manifest.chunkLength(index))
+	if _, err := file.ReadAt(data, manifest.chunkOffset(index)); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read chunk %d: %w", index, err)
+	}
+
+	m.mu.Lock()
+	state.Sent[index] = true
+	current := manifest.bytesIn(state.Sent)
+	m.mu.Unlock()
+
+	m.publish(Progress{
+		TransferID: transferID,
+		Filename:   manifest.Filename,
+		Direction:  DirectionOutgoing,
+		Current:    current,
+		Total:      manifest.Size,
+		Done:       current == manifest.Size,
+	})
+
+	return &Chunk{TransferID: transferID, Index: index, Data: data}, nil
+}
+
+// FinishOutgoing forgets an outgoing transfer once the peer has the whole file
+func (m *Manager) FinishOutgoing(transferID, peer string) error {
+	m.mu.Lock()
+	state, exists := m.outgoing[transferID]
+	if exists && state.Peer == peer {
+		delete(m.outgoing, transferID)
+	}
+	m.mu.Unlock()
+	if !exists || state.Peer != peer {
+		return fmt.Errorf("unknown outgoing transfer: %s", transferID)
+	}
+
+	return m.removeState("outgoing", transferID)
+}
+
+// Accept starts receiving a file described by manifest into destination.
+// The file is saved under another name if destination is taken by then.
+func (m *Manager) Accept(manifest Manifest, destination, peer string) error {
+	if err := manifest.Validate(); err != nil {
+		return fmt.Errorf("invalid manifest for transfer %q: %w", manifest.ID, err)
+	}
+
+	m.mu.Lock()
+	existing, exists := m.incoming[manifest.ID]
+	m.mu.Unlock()
+	if exists {
+		if existing.Peer != peer {
+			return fmt.Errorf("transfer %s is already offered by another user", manifest.ID)
+		}
+		// Already known, keep the chunks we have
+		return nil
+	}
+
+	part, err := os.OpenFile(m.partPath(manifest.ID), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create partial file: %w", err)
+	}
+	defer part.Close()
+
+	if err := part.Truncate(manifest.Size); err != nil {
+		return fmt.Errorf("failed to allocate partial file: %w", err)
+	}
+
+	state := &incomingState{
+		Manifest:    manifest,
+		Destination: destination,
+		Peer:        peer,
+		Received:    make([]bool, manifest.ChunkCount()),
+	}
+
+	if err := m.saveState("incoming", manifest.ID, state); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.incoming[manifest.ID] = state
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Missing returns the indices of chunks not yet received for a transfer
+func (m *Manager) Missing(transferID string) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, exists := m.incoming[transferID]
+	if !exists {
+		return nil, fmt.Errorf("unknown incoming transfer: %s", transferID)
+	}
+
+	var missing []int
+	for i, received := range state.Received {
+		if !received {
+			missing = append(missing, i)
+		}
+	}
+
+	return missing, nil
+}
+
+// IncomingManifest returns the manifest of an incoming transfer
+func (m *Manager) IncomingManifest(transferID string) (*Manifest, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, exists := m.incoming[transferID]
+	if !exists {
+		return nil, false
+	}
+
+	manifest := state.Manifest
+	return &manifest, true
+}
+
+// Incomplete returns the IDs and peers of incoming transfers that still need chunks
+func (m *Manager) Incomplete() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := make(map[string]string, len(m.incoming))
+	for id, state := range m.incoming {
+		pending[id] = state.Peer
+	}
+
+	return pending
+}
+
+// WriteChunk stores a chunk received from peer. When the last chunk arrives
+// the file is verified against the manifest checksum and moved to its
+// destination, whose path is returned; until then the path is empty.
+func (m *Manager) WriteChunk(chunk *Chunk, peer string) (string, error) {
+	m.mu.Lock()
+	state, exists := m.incoming[chunk.TransferID]
+	m.mu.Unlock()
+	if !exists || state.Peer != peer {
+		return "", fmt.Errorf("unknown incoming transfer: %s", chunk.TransferID)
+	}
+
+	manifest := state.Manifest
+	if chunk.Index < 0 || chunk.Index >= manifest.ChunkCount() {
+		return "", fmt.Errorf("chunk %d out of range for transfer %s", chunk.Index, chunk.TransferID)
+	}
+	if int64(len(chunk.Data)) != manifest.chunkLength(chunk.Index) {
+		return "", fmt.Errorf("chunk %d has wrong length %d", chunk.Index, len(chunk.Data))
+	}
+
+	part, err := os.OpenFile(m.partPath(manifest.ID), os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open partial file: %w", err)
+	}
+	_, err = part.WriteAt(chunk.Data, manifest.chunkOffset(chunk.Index))
+	if err == nil {
+		err = part.Sync()
+	}
+	part.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to write chunk %d: %w", chunk.Index, err)
+	}
+
+	// Record the chunk only after its data is on disk
+	m.mu.Lock()
+	state.Received[chunk.Index] = true
+	current := manifest.bytesIn(state.Received)
+	done := allTrue(state.Received)
+	err = m.saveState("incoming", manifest.ID, state)
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if !done {
+		m.publish(Progress{
+			TransferID: manifest.ID,
+			Filename:   manifest.Filename,
+			Direction:  DirectionIncoming,
+			Current:    current,
+			Total:      manifest.Size,
+		})
+		return "", nil
+	}
+
+	destination, err := m.complete(state)
+	if err != nil {
+		m.publish(Progress{
+			TransferID: manifest.ID,
+			Filename:   manifest.Filename,
+			Direction:  DirectionIncoming,
+			Current:    current,
+			Total:      manifest.Size,
+			Err:        err,
+		})
+		return "", err
+	}
+
+	m.publish(Progress{
+		TransferID: manifest.ID,
+		Filename:   manifest.Filename,
+		Direction:  DirectionIncoming,
+		Current:    current,
+		Total:      manifest.Size,
+		Done:       true,
+	})
+
+	return destination, nil
+}
+
+// complete verifies a fully received file and moves it into place, never
+// over an existing file, returning where it was put
+func (m *Manager) complete(state *incomingState) (string, error) {
+	manifest := state.Manifest
+
+	part, err := os.Open(m.partPath(manifest.ID))
+	if err != nil {
+		return "", fmt.Errorf("failed to open partial file: %w", err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, part)
+	part.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum partial file: %w", err)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.Checksum {
+		// Start over rather than keep corrupt data around
+		m.mu.Lock()
+		for i := range state.Received {
+			state.Received[i] = false
+		}
+		saveErr := m.saveState("incoming", manifest.ID, state)
+		m.mu.Unlock()
+		if saveErr != nil {
+			return "", saveErr
+		}
+		return "", fmt.Errorf("checksum mismatch for transfer %s", manifest.ID)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(state.Destination), 0700); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	destination, err := reservePath(state.Destination)
+	if err != nil {
+		return "", err
+	}
+	// Replaces only the empty file that reserved the name
+	if err := os.Rename(m.partPath(manifest.ID), destination); err != nil {
+		os.Remove(destination)
+		return "", fmt.Errorf("failed to move completed file: %w", err)
+	}
+
+	m.mu.Lock()
+	delete(m.incoming, manifest.ID)
+	m.mu.Unlock()
+
+	return destination, m.removeState("incoming", manifest.ID)
+}
+
+// reservePath creates an empty file at path, or at the first free one of
+// "name (1).ext", "name (2).ext" and so on if path is taken, and returns
+// the path created
+func reservePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for n := 1; n <= 1000; n++ {
+		file, err := os.OpenFile(candidate, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create destination file: %w", err)
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	return "", fmt.Errorf("no free file name for %s", filepath.Base(path))
+}
+
+// load restores persisted transfer state from disk
+func (m *Manager) load() error {
+	outgoing, err := filepath.Glob(filepath.Join(m.dir, "outgoing", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range outgoing {
+		var state outgoingState
+		if err := readJSON(path, &state); err != nil {
+			return err
+		}
+		if state.Manifest.Validate() != nil {
+			// Offered by an older version that sent any size of file
+			if err := m.removeState("outgoing", stateID(path)); err != nil {
+				return err
+			}
+			continue
+		}
+		state.Sent = make([]bool, state.Manifest.ChunkCount())
+		m.outgoing[state.Manifest.ID] = &state
+	}
+
+	incoming, err := filepath.Glob(filepath.Join(m.dir, "incoming", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range incoming {
+		var state incomingState
+		if err := readJSON(path, &state); err != nil {
+			return err
+		}
+		if state.Manifest.Validate() != nil || len(state.Received) != state.Manifest.ChunkCount() {
+			// Accepted by an older version that took any manifest
+			os.Remove(m.partPath(stateID(path)))
+			if err := m.removeState("incoming", stateID(path)); err != nil {
+				return err
+			}
+			continue
+		}
+		m.incoming[state.Manifest.ID] = &state
+	}
+
+	return nil
+}
+
+// saveState atomically writes transfer state to disk
+func (m *Manager) saveState(kind, id string, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transfer state: %w", err)
+	}
+
+	path := filepath.Join(m.dir, kind, id+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write transfer state: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// removeState deletes the persisted state of a finished transfer
+func (m *Manager) removeState(kind, id string) error {
+	err := os.Remove(filepath.Join(m.dir, kind, id+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove transfer state: %w", err)
+	}
+	return nil
+}
+
+// stateID returns the transfer ID a state file was saved under
+func stateID(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), ".json")
+}
+
+// partPath returns the path of the partially received file
+func (m *Manager) partPath(id string) string {
+	return filepath.Join(m.dir, "incoming", id+".part")
+}
+
+// publish sends a progress event without blocking the transfer
+func (m *Manager) publish(p Progress) {
+	select {
+	case m.progress <- p:
+	default:
+		// Nobody is listening fast enough, drop the update
+	}
+}
+
+// Validate checks a manifest received from a peer, whose ID names files
+// and whose size decides how much is allocated, so only manifests this
+// package could have created are accepted
+func (mf *Manifest) Validate() error {
+	switch {
+	case !validTransferID(mf.ID):
+		return fmt.Errorf("malformed transfer ID")
+	case mf.Filename == "" || len(mf.Filename) > maxFilenameLength ||
+		mf.Filename == "." || mf.Filename == ".." || strings.ContainsAny(mf.Filename, `/\`):
+		return fmt.Errorf("invalid file name")
+	case mf.Size < 0 || mf.Size > MaxFileSize:
+		return fmt.Errorf("size %d out of range", mf.Size)
+	case mf.ChunkSize != DefaultChunkSize:
+		return fmt.Errorf("unsupported chunk size %d", mf.ChunkSize)
+	case len(mf.Checksum) != 2*sha256.Size || !isLowerHex(mf.Checksum):
+		return fmt.Errorf("malformed checksum")
+	}
+	return nil
+}
+
+// ChunkCount returns the number of chunks the file is split into
+func (mf *Manifest) ChunkCount() int {
+	if mf.Size == 0 {
+		return 1
+	}
+	return int((mf.Size + int64(mf.ChunkSize) - 1) / int64(mf.ChunkSize))
+}
+
+// chunkOffset returns the file offset of a chunk
+func (mf *Manifest) chunkOffset(index int) int64 {
+	return int64(index) * int64(mf.ChunkSize)
+}
+
+// chunkLength returns the number of bytes in a chunk
+func (mf *Manifest) chunkLength(index int) int64 {
+	remaining := mf.Size - mf.chunkOffset(index)
+	if remaining < int64(mf.ChunkSize) {
+		return remaining
+	}
+	return int64(mf.ChunkSize)
+}
+
+// bytesIn returns the number of file bytes covered by the marked chunks
+func (mf *Manifest) bytesIn(marked []bool) int64 {
+	var total int64
+	for i, ok := range marked {
+		if ok {
+			total += mf.chunkLength(i)
+		}
+	}
+	return total
+}
+
+// allTrue reports whether every element is set
+func allTrue(values []bool) bool {
+	for _, v := range values {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
+
+// readJSON decodes a JSON file into dest
+func readJSON(path string, dest interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read transfer state: %w", err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("failed to parse transfer state %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// validTransferID reports whether id has the form generateTransferID gives
+func validTransferID(id string) bool {
+	digits, ok := strings.CutPrefix(id, transferIDPrefix)
+	return ok && len(digits) == 32 && isLowerHex(digits)
+}
+
+// isLowerHex reports whether s is made of lowercase hex digits only
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// generateTransferID generates a random transfer ID
+func generateTransferID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate transfer ID: %w", err)
+	}
+	return transferIDPrefix + hex.EncodeToString(b), nil
+}
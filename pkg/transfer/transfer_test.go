@@ -0,0 +1,217 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testFile writes size random bytes to a file named name in a temporary
+// directory and returns its path and content
+func testFile(t *testing.T, name string, size int) (string, []byte) {
+	t.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path, data
+}
+
+// newTestManager creates a manager keeping its state in dir
+func newTestManager(t *testing.T, dir string) *Manager {
+	t.Helper()
+
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m
+}
+
+// sendChunks passes the chunks with the given indices from sender to
+// receiver, returning where the file was saved once it is complete
+func sendChunks(t *testing.T, sender, receiver *Manager, id string, indices ...int) string {
+	t.Helper()
+
+	var destination string
+	for _, index := range indices {
+		chunk, err := sender.ReadChunk(id, "bob", index)
+		if err != nil {
+			t.Fatalf("ReadChunk(%d): %v", index, err)
+		}
+		destination, err = receiver.WriteChunk(chunk, "alice")
+		if err != nil {
+			t.Fatalf("WriteChunk(%d): %v", index, err)
+		}
+	}
+	return destination
+}
+
+func TestResumedTransferIsIdentical(t *testing.T) {
+	path, data := testFile(t, "photo.jpg", 3*DefaultChunkSize+100)
+	sender := newTestManager(t, t.TempDir())
+	manifest, err := sender.Offer(path, "bob")
+	if err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+	if got := manifest.ChunkCount(); got != 4 {
+		t.Fatalf("ChunkCount = %d, want 4", got)
+	}
+
+	receiverDir := t.TempDir()
+	destination := filepath.Join(t.TempDir(), "photo.jpg")
+	receiver := newTestManager(t, receiverDir)
+	if err := receiver.Accept(*manifest, destination, "alice"); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	sendChunks(t, sender, receiver, manifest.ID, 2, 0)
+
+	// The receiver restarts, and asks for what it is missing
+	receiver = newTestManager(t, receiverDir)
+	missing, err := receiver.Missing(manifest.ID)
+	if err != nil {
+		t.Fatalf("Missing: %v", err)
+	}
+	if want := []int{1, 3}; !reflect.DeepEqual(missing, want) {
+		t.Fatalf("Missing = %v, want %v", missing, want)
+	}
+
+	saved := sendChunks(t, sender, receiver, manifest.ID, missing...)
+	if saved != destination {
+		t.Errorf("saved to %s, want %s", saved, destination)
+	}
+	got, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("received file differs from the one sent")
+	}
+	if pending := receiver.Incomplete(); len(pending) != 0 {
+		t.Errorf("Incomplete = %v after the transfer finished", pending)
+	}
+}
+
+func TestCorruptTransferStartsOver(t *testing.T) {
+	path, _ := testFile(t, "notes.txt", DefaultChunkSize+10)
+	sender := newTestManager(t, t.TempDir())
+	manifest, err := sender.Offer(path, "bob")
+	if err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+
+	receiver := newTestManager(t, t.TempDir())
+	if err := receiver.Accept(*manifest, filepath.Join(t.TempDir(), "notes.txt"), "alice"); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	sendChunks(t, sender, receiver, manifest.ID, 0)
+
+	chunk, err := sender.ReadChunk(manifest.ID, "bob", 1)
+	if err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	chunk.Data[0] ^= 0xff
+	if _, err := receiver.WriteChunk(chunk, "alice"); err == nil {
+		t.Fatal("WriteChunk accepted a file that does not match its checksum")
+	}
+
+	missing, err := receiver.Missing(manifest.ID)
+	if err != nil {
+		t.Fatalf("Missing: %v", err)
+	}
+	if want := []int{0, 1}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("Missing = %v, want %v", missing, want)
+	}
+}
+
+func TestTransfersAreBoundToTheirPeer(t *testing.T) {
+	path, _ := testFile(t, "a.bin", 10)
+	sender := newTestManager(t, t.TempDir())
+	manifest, err := sender.Offer(path, "bob")
+	if err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+	if _, err := sender.ReadChunk(manifest.ID, "carol", 0); err == nil {
+		t.Error("ReadChunk served a chunk to another user")
+	}
+
+	receiver := newTestManager(t, t.TempDir())
+	if err := receiver.Accept(*manifest, filepath.Join(t.TempDir(), "a.bin"), "alice"); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if err := receiver.Accept(*manifest, filepath.Join(t.TempDir(), "a.bin"), "carol"); err == nil {
+		t.Error("Accept took the same transfer from another user")
+	}
+	chunk, err := sender.ReadChunk(manifest.ID, "bob", 0)
+	if err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	if _, err := receiver.WriteChunk(chunk, "carol"); err == nil {
+		t.Error("WriteChunk took a chunk from another user")
+	}
+}
+
+func TestCompletedTransferKeepsExistingFiles(t *testing.T) {
+	path, _ := testFile(t, "report.pdf", 10)
+	sender := newTestManager(t, t.TempDir())
+	manifest, err := sender.Offer(path, "bob")
+	if err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+
+	destination := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(destination, []byte("mine"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	receiver := newTestManager(t, t.TempDir())
+	if err := receiver.Accept(*manifest, destination, "alice"); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	saved := sendChunks(t, sender, receiver, manifest.ID, 0)
+	if want := filepath.Join(filepath.Dir(destination), "report (1).pdf"); saved != want {
+		t.Errorf("saved to %s, want %s", saved, want)
+	}
+	if got, _ := os.ReadFile(destination); string(got) != "mine" {
+		t.Errorf("existing file was overwritten with %q", got)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	valid := Manifest{
+		ID:        transferIDPrefix + "0123456789abcdef0123456789abcdef",
+		Filename:  "a.txt",
+		Size:      10,
+		ChunkSize: DefaultChunkSize,
+		Checksum:  string(bytes.Repeat([]byte{'a'}, 64)),
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate of a valid manifest: %v", err)
+	}
+
+	tests := map[string]func(mf *Manifest){
+		"id":         func(mf *Manifest) { mf.ID = "../../etc" },
+		"path":       func(mf *Manifest) { mf.Filename = "../a.txt" },
+		"dot dot":    func(mf *Manifest) { mf.Filename = ".." },
+		"size":       func(mf *Manifest) { mf.Size = MaxFileSize + 1 },
+		"chunk size": func(mf *Manifest) { mf.ChunkSize = 1 },
+		"checksum":   func(mf *Manifest) { mf.Checksum = "ABC" },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			mf := valid
+			change(&mf)
+			if err := mf.Validate(); err == nil {
+				t.Error("Validate accepted the manifest")
+			}
+		})
+	}
+}
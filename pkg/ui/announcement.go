@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// announcementDuration is how long a relay announcement stays in the status
+// bar
+const announcementDuration = 10 * time.Minute
+
+// AnnouncementMsg shows a notice about a relay, such as planned maintenance
+// announced by its operator, in the status bar
+type AnnouncementMsg struct {
+	Relay string
+	Text  string
+}
+
+// announcementExpiredMsg hides an announcement once its time is up, unless
+// a newer one replaced it
+type announcementExpiredMsg struct {
+	seq int
+}
+
+// showAnnouncement makes an announcement the one shown and returns the
+// command that hides it again
+func (a *App) showAnnouncement(msg AnnouncementMsg) tea.Cmd {
+	text := bannerText(msg.Text)
+	if msg.Relay != "" {
+		// With several relays, tell which one is restarting
+		text = bannerText(msg.Relay) + ": " + text
+	}
+	a.announcement = "⚑ " + text
+	a.announcementSeq++
+	seq := a.announcementSeq
+	return tea.Tick(announcementDuration, func(time.Time) tea.Msg {
+		return announcementExpiredMsg{seq: seq}
+	})
+}
+
+// bannerText puts text on a single line and drops control characters, which
+// could otherwise move the cursor or change the terminal's state
+func bannerText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, text)
+	return strings.Join(strings.Fields(text), " ")
+}
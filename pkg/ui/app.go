@@ -1,9 +1,14 @@
 package ui
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
 )
 
 // App represents the main TUI application
@@ -12,12 +17,44 @@ type App struct {
 	width  int
 	height int
 	
-	// Current view state
+	// Current view state, and the views left to reach it, which the back
+	// key returns through
 	currentView ViewType
+	history     []ViewType
 	views       map[ViewType]tea.Model
 	
 	// Global state
 	theme *Theme
+	keys  *KeyMap
+	
+	// Draws image previews over rendered frames; nil when the terminal
+	// cannot show images
+	painter *imagePainter
+	
+	// Applies the appear offline setting
+	setVisibility VisibilitySetter
+	
+	// Progress of fetching messages queued while offline
+	sync SyncProgressMsg
+	
+	// Connection state and quality, and the number of messages waiting to
+	// be sent
+	offline          bool
+	reconnectAttempt int
+	quality          ConnectionQualityMsg
+	queued           int
+	
+	// Announcement from a relay operator shown in place of the shortcuts,
+	// and a counter telling expiry ticks of earlier announcements apart
+	announcement    string
+	announcementSeq int
+}
+
+// SyncProgressMsg reports how many of the messages queued while offline
+// have arrived
+type SyncProgressMsg struct {
+	Delivered int
+	Total     int
 }
 
 // ViewType represents different views in the application
@@ -28,6 +65,7 @@ const (
 	ViewContacts ViewType = "contacts"
 	ViewSettings ViewType = "settings"
 	ViewHelp     ViewType = "help"
+	ViewOutbox   ViewType = "outbox"
 )
 
 // Theme contains styling information
@@ -43,22 +81,166 @@ type Theme struct {
 	Success     lipgloss.Color
 }
 
-// NewApp creates a new TUI application
-func NewApp(cfg *config.Config) *App {
+// NewApp creates a new TUI application. It fails if the configured key
+// bindings are invalid.
+func NewApp(cfg *config.Config) (*App, error) {
+	keys, err := NewKeyMap(cfg.UI.KeyBindings)
+	if err != nil {
+		return nil, err
+	}
+	
 	app := &App{
 		config:      cfg,
 		currentView: ViewChat,
 		views:       make(map[ViewType]tea.Model),
 		theme:       getTheme(cfg.UI.Theme),
+		keys:        keys,
 	}
 	
 	// Initialize views
-	app.views[ViewChat] = NewChatView(cfg, app.theme)
-	app.views[ViewContacts] = NewContactsView(cfg, app.theme)
-	app.views[ViewSettings] = NewSettingsView(cfg, app.theme)
-	app.views[ViewHelp] = NewHelpView(cfg, app.theme)
+	chat := NewChatView(cfg, app.theme, keys)
+	if images := newImageEncoder(detectImageProtocol(os.Getenv)); images != nil {
+		chat.images = images
+		app.painter = newImagePainter(os.Stdout, images)
+	}
+	app.views[ViewChat] = chat
+	app.views[ViewContacts] = NewContactsView(cfg, app.theme, keys)
+	app.views[ViewSettings] = NewSettingsView(cfg, app.theme, keys)
+	app.views[ViewHelp] = NewHelpView(cfg, app.theme, keys)
+	app.views[ViewOutbox] = NewOutboxView(cfg, app.theme, keys)
 	
-	return app
+	return app, nil
+}
+
+// SetHistoryLoader sets the function the chat view uses to page in history
+func (a *App) SetHistoryLoader(loader HistoryLoader) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetHistoryLoader(loader)
+	}
+}
+
+// SetMessageLookup sets the function the chat view uses to fetch quoted messages
+func (a *App) SetMessageLookup(lookup MessageLookup) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetMessageLookup(lookup)
+	}
+}
+
+// SetAttachmentOpener sets the function the chat view uses to read attachments
+func (a *App) SetAttachmentOpener(opener AttachmentOpener) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetAttachmentOpener(opener)
+	}
+}
+
+// SetContactLister sets the function the chat view uses to offer forward targets
+func (a *App) SetContactLister(lister ContactLister) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetContactLister(lister)
+	}
+}
+
+// SetMessageForwarder sets the function the chat view uses to forward messages
+func (a *App) SetMessageForwarder(forwarder MessageForwarder) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetMessageForwarder(forwarder)
+	}
+}
+
+// SetMessageSearcher sets the function the chat view searches messages with
+func (a *App) SetMessageSearcher(searcher MessageSearcher) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetMessageSearcher(searcher)
+	}
+}
+
+// SetContactLookup sets the function the chat view uses to find a chat's contact
+func (a *App) SetContactLookup(lookup ContactLookup) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetContactLookup(lookup)
+	}
+}
+
+// SetEncryptionLookup sets the function the chat view finds whether a chat
+// is encrypted with
+func (a *App) SetEncryptionLookup(lookup EncryptionLookup) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetEncryptionLookup(lookup)
+	}
+}
+
+// SetActiveChatSetter sets the function the chat view tells which chat is open
+func (a *App) SetActiveChatSetter(setter ActiveChatSetter) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetActiveChatSetter(setter)
+	}
+}
+
+// SetMessageSender sets the function the chat view sends messages with
+func (a *App) SetMessageSender(sender MessageSender) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetMessageSender(sender)
+	}
+}
+
+// SetKeyDecider sets the function the chat view accepts or rejects pending
+// identity keys with
+func (a *App) SetKeyDecider(decider KeyDecider) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetKeyDecider(decider)
+	}
+}
+
+// SetFileOfferDecider sets the function the chat view accepts or declines
+// offered files with
+func (a *App) SetFileOfferDecider(decider FileOfferDecider) {
+	if chat, ok := a.views[ViewChat].(*ChatView); ok {
+		chat.SetFileOfferDecider(decider)
+	}
+}
+
+// SetVisibilitySetter sets the function that makes the user appear offline,
+// used by the settings view and the quick toggle
+func (a *App) SetVisibilitySetter(setter VisibilitySetter) {
+	a.setVisibility = setter
+	if view, ok := a.views[ViewSettings].(*SettingsView); ok {
+		view.SetVisibilitySetter(setter)
+	}
+}
+
+// SetSecurityStatusLookup sets the function the settings view gets the
+// security overview from
+func (a *App) SetSecurityStatusLookup(lookup SecurityStatusLookup) {
+	if view, ok := a.views[ViewSettings].(*SettingsView); ok {
+		view.SetSecurityStatusLookup(lookup)
+	}
+}
+
+// SetOutboxLister sets the function the outbox view loads queued messages with
+func (a *App) SetOutboxLister(lister OutboxLister) {
+	if view, ok := a.views[ViewOutbox].(*OutboxView); ok {
+		view.SetOutboxLister(lister)
+	}
+}
+
+// SetQueuedMessageCanceler sets the function the outbox view cancels
+// queued messages with
+func (a *App) SetQueuedMessageCanceler(canceler QueuedMessageCanceler) {
+	if view, ok := a.views[ViewOutbox].(*OutboxView); ok {
+		view.SetQueuedMessageCanceler(canceler)
+	}
+}
+
+// SetConnected sets the connection state shown in the status bar
+func (a *App) SetConnected(connected bool) {
+	a.offline = !connected
+}
+
+// SetContacts shows stored contacts in the contacts view
+func (a *App) SetContacts(contacts []*models.Contact) {
+	if view, ok := a.views[ViewContacts].(*ContactsView); ok {
+		view.SetContacts(contacts)
+	}
 }
 
 // Init implements tea.Model
@@ -66,6 +248,8 @@ func (a *App) Init() tea.Cmd {
 	return tea.Batch(
 		tea.EnterAltScreen,
 		a.views[a.currentView].Init(),
+		// Loads the outbox for the status bar
+		a.views[ViewOutbox].Init(),
 	)
 }
 
@@ -83,35 +267,92 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			a.views[viewType], _ = view.Update(msg)
 		}
 		
+	case SyncProgressMsg:
+		a.sync = msg
+		return a, nil
+		
+	case ConnectionStateMsg:
+		a.offline = !msg.Connected
+		a.reconnectAttempt = msg.ReconnectAttempt
+		return a, nil
+		
+	case ConnectionQualityMsg:
+		a.quality = msg
+		return a, nil
+		
+	case AnnouncementMsg:
+		return a, a.showAnnouncement(msg)
+		
+	case announcementExpiredMsg:
+		if msg.seq == a.announcementSeq {
+			a.announcement = ""
+		}
+		return a, nil
+		
+	case OutboxUpdatedMsg:
+		a.queued = len(msg.Messages)
+		a.views[ViewOutbox], _ = a.views[ViewOutbox].Update(msg)
+		return a, nil
+		
+	case editorOpenedMsg:
+		// Nothing may be drawn over the editor
+		if a.painter != nil {
+			a.painter.pause()
+		}
+		return a, nil
+		
+	case editorClosedMsg:
+		if a.painter != nil {
+			a.painter.resume()
+		}
+		a.views[ViewChat], cmd = a.views[ViewChat].Update(msg)
+		return a, cmd
+		
+	case MessageUpdatedMsg, MessageReactionMsg, MessageSentMsg, EncryptionDowngradeMsg, EncryptionStateMsg, MessagesDroppedMsg, MessagesMissingMsg, FileOfferMsg, expiryTickMsg, SearchResultsMsg:
+		// The chat stays current while another view is shown
+		if a.currentView != ViewChat {
+			a.views[ViewChat], cmd = a.views[ViewChat].Update(msg)
+			return a, cmd
+		}
+		
+	case ContactUpdatedMsg, ContactAddedMsg, ContactRemovedMsg:
+		// Contact changes matter to every view, not just the visible one
+		for viewType, view := range a.views {
+			if viewType != a.currentView {
+				a.views[viewType], _ = view.Update(msg)
+			}
+		}
+		
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+q":
+		switch {
+		case a.keys.Matches(msg, ActionQuit):
+			if a.painter != nil {
+				a.painter.stop()
+			}
 			return a, tea.Quit
 			
-		case "ctrl+c":
-			return a, tea.Quit
+		case a.keys.Matches(msg, ActionInvisible):
+			a.toggleInvisible()
+			return a, nil
+			
+		case a.keys.Matches(msg, ActionShowSettings):
+			return a, a.showView(ViewSettings)
 			
-		case "ctrl+,":
-			a.currentView = ViewSettings
-			return a, a.views[a.currentView].Init()
+		case a.keys.Matches(msg, ActionShowHelp):
+			return a, a.showView(ViewHelp)
 			
-		case "ctrl+/":
-			a.currentView = ViewHelp
-			return a, a.views[a.currentView].Init()
+		case a.keys.Matches(msg, ActionShowChat):
+			return a, a.showView(ViewChat)
 			
-		case "f1":
-			a.currentView = ViewChat
-			return a, a.views[a.currentView].Init()
+		case a.keys.Matches(msg, ActionShowContacts):
+			return a, a.showView(ViewContacts)
 			
-		case "f2":
-			a.currentView = ViewContacts
-			return a, a.views[a.currentView].Init()
+		case a.keys.Matches(msg, ActionShowOutbox):
+			return a, a.showView(ViewOutbox)
 			
-		case "esc":
-			// Return to chat view from other views
-			if a.currentView != ViewChat {
-				a.currentView = ViewChat
-				return a, a.views[a.currentView].Init()
+		case a.keys.Matches(msg, ActionBack):
+			if cmd, handled := a.back(); handled {
+				return a, cmd
 			}
 		}
 	}
@@ -135,11 +376,45 @@ func (a *App) View() string {
 	statusBar := a.renderStatusBar()
 	
 	// Combine content and status bar
-	return lipgloss.JoinVertical(
+	frame := lipgloss.JoinVertical(
 		lipgloss.Left,
 		content,
 		statusBar,
 	)
+	
+	if a.painter != nil {
+		var previews map[int]*attachmentPreview
+		if chat, ok := a.views[ViewChat].(*ChatView); ok && a.currentView == ViewChat {
+			previews = chat.loadedPreviews()
+		}
+		frame = a.painter.place(frame, previews)
+	}
+	
+	return frame
+}
+
+// toggleInvisible switches between appearing offline and visible
+func (a *App) toggleInvisible() {
+	invisible := !a.config.Security.Invisible
+	a.config.Security.Invisible = invisible
+	if a.setVisibility != nil {
+		a.setVisibility(invisible)
+	}
+	
+	a.views[ViewSettings], _ = a.views[ViewSettings].Update(VisibilityChangedMsg{Invisible: invisible})
+}
+
+// renderQuality renders the round-trip time of the connection, warning
+// when it has been slow for a while. Nothing is shown before it is known.
+func (a *App) renderQuality() string {
+	if a.quality.Level == "" || a.quality.Level == "unknown" {
+		return ""
+	}
+	rtt := a.quality.RTT.Round(time.Millisecond)
+	if a.quality.Degraded {
+		return fmt.Sprintf(" | ⚠ Slow connection (%s)", rtt)
+	}
+	return fmt.Sprintf(" %s (%s)", rtt, a.quality.Level)
 }
 
 // renderStatusBar renders the bottom status bar
@@ -151,16 +426,47 @@ func (a *App) renderStatusBar() string {
 	
 	// Status indicators
 	status := "● Online"
+	switch {
+	case a.offline && a.reconnectAttempt > 0:
+		status = fmt.Sprintf("○ Reconnecting (attempt %d)", a.reconnectAttempt)
+	case a.offline:
+		status = "○ Offline"
+	case a.config.Security.Invisible:
+		status = "○ Invisible"
+	}
+	if !a.offline {
+		status += a.renderQuality()
+	}
+	if a.queued > 0 {
+		status += fmt.Sprintf(" | %d queued (%s)", a.queued, a.keys.Help(ActionShowOutbox))
+	}
+	if a.sync.Delivered < a.sync.Total {
+		status += fmt.Sprintf(" | Syncing %d/%d", a.sync.Delivered, a.sync.Total)
+	}
 	if a.currentView != ViewChat {
-		status += " | Press Esc to return to chat"
+		status += " | Press " + a.keys.Help(ActionBack) + " to return to " + string(a.previousView())
 	}
 	
 	// Keyboard shortcuts
-	shortcuts := "F1: Chat | F2: Contacts | Ctrl+,: Settings | Ctrl+/: Help | Ctrl+Q: Quit"
+	shortcuts := fmt.Sprintf("%s: Chat | %s: Contacts | %s: Settings | %s: Help | %s: Quit",
+		a.keys.Help(ActionShowChat),
+		a.keys.Help(ActionShowContacts),
+		a.keys.Help(ActionShowSettings),
+		a.keys.Help(ActionShowHelp),
+		a.keys.Help(ActionQuit),
+	)
 	
 	// Create status bar with proper width
 	leftPart := style.Render(status)
 	rightPart := style.Render(shortcuts)
+	if a.announcement != "" {
+		// Announcements are rare and matter more than the shortcuts
+		rightPart = style.
+			Background(a.theme.Warning).
+			Bold(true).
+			MaxWidth(max(a.width-lipgloss.Width(leftPart), 0)).
+			Render(a.announcement)
+	}
 	
 	padding := a.width - lipgloss.Width(leftPart) - lipgloss.Width(rightPart)
 	if padding < 0 {
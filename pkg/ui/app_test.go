@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func TestInvisibleToggle(t *testing.T) {
+	app, err := NewApp(config.Default())
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	var changes []bool
+	app.SetVisibilitySetter(func(invisible bool) { changes = append(changes, invisible) })
+	app.Update(tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	toggle := tea.KeyMsg{Type: tea.KeyCtrlO}
+	app.Update(toggle)
+	if !app.config.Security.Invisible || len(changes) != 1 || !changes[0] {
+		t.Fatalf("after the toggle: invisible %v, changes %v", app.config.Security.Invisible, changes)
+	}
+	if bar := app.renderStatusBar(); !strings.Contains(bar, "Invisible") {
+		t.Errorf("status bar %q does not show invisible", bar)
+	}
+
+	app.Update(toggle)
+	if app.config.Security.Invisible || len(changes) != 2 || changes[1] {
+		t.Errorf("after toggling back: invisible %v, changes %v", app.config.Security.Invisible, changes)
+	}
+	if bar := app.renderStatusBar(); strings.Contains(bar, "Invisible") {
+		t.Errorf("status bar %q still shows invisible", bar)
+	}
+}
+
+func TestAnnouncementShowsInStatusBar(t *testing.T) {
+	app, err := NewApp(config.Default())
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	app.Update(tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	_, first := app.Update(AnnouncementMsg{Relay: "relay.example.com", Text: "Restarting\nin 5 minutes\x1b[2J"})
+	if first == nil {
+		t.Fatal("no command hides the announcement")
+	}
+	bar := app.renderStatusBar()
+	if !strings.Contains(bar, "⚑ relay.example.com: Restarting in 5 minutes") {
+		t.Errorf("status bar %q does not show the announcement on one line", bar)
+	}
+	if strings.Contains(bar, "\x1b[2J") || strings.Contains(bar, "Quit") {
+		t.Errorf("status bar %q shows control characters or the shortcuts", bar)
+	}
+
+	// A newer announcement outlasts the expiry of the one it replaced
+	app.Update(AnnouncementMsg{Text: "Back up"})
+	app.Update(announcementExpiredMsg{seq: 1})
+	if bar := app.renderStatusBar(); !strings.Contains(bar, "⚑ Back up") {
+		t.Errorf("status bar %q lost the newer announcement", bar)
+	}
+	app.Update(announcementExpiredMsg{seq: 2})
+	if bar := app.renderStatusBar(); strings.Contains(bar, "⚑") || !strings.Contains(bar, "Quit") {
+		t.Errorf("status bar %q still shows an expired announcement", bar)
+	}
+}
+
+func TestStatusBarShowsConnectionQuality(t *testing.T) {
+	app, err := NewApp(config.Default())
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	app.Update(tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	tests := []struct {
+		name   string
+		msg    tea.Msg
+		want   string
+		absent string
+	}{
+		{"no data yet", ConnectionQualityMsg{Level: "unknown"}, "● Online", "unknown"},
+		{"fair", ConnectionQualityMsg{RTT: 212400 * time.Microsecond, Level: "fair"}, "● Online 212ms (fair)", "Slow"},
+		{"degraded", ConnectionQualityMsg{RTT: 900 * time.Millisecond, Level: "poor", Degraded: true}, "⚠ Slow connection (900ms)", "(poor)"},
+		{"offline", ConnectionStateMsg{Connected: false}, "", "Slow"},
+	}
+	for _, tt := range tests {
+		app.Update(tt.msg)
+		bar := app.renderStatusBar()
+		if !strings.Contains(bar, tt.want) || strings.Contains(bar, tt.absent) {
+			t.Errorf("%s: status bar %q, want %q without %q", tt.name, bar, tt.want, tt.absent)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/rivo/uniseg"
+)
+
+// avatarWidth is how many cells an avatar takes, padding included
+const avatarWidth = 4
+
+// avatarMinListWidth is the narrowest contacts list that has room for
+// avatars
+const avatarMinListWidth = 30
+
+// avatarPalette holds the avatar colors, all readable under white text on
+// dark and light themes alike
+var avatarPalette = []lipgloss.Color{
+	"24", "25", "28", "29", "30", "54",
+	"89", "90", "94", "124", "130", "166",
+}
+
+// avatarColor returns the color of a user's avatar. It is derived from the
+// user ID alone, so a contact keeps their color whatever they are called.
+func avatarColor(userID string) lipgloss.Color {
+	h := fnv.New32a()
+	h.Write([]byte(models.NormalizeUserID(userID)))
+	return avatarPalette[h.Sum32()%uint32(len(avatarPalette))]
+}
+
+// initials returns the first characters of the first and last words of
+// name, uppercased, or "?" if no word starts with a letter or digit. A wide
+// character fills the avatar on its own.
+func initials(name string) string {
+	var firsts []string
+	for _, word := range strings.Fields(name) {
+		first, _, _, _ := uniseg.FirstGraphemeClusterInString(word, -1)
+		r := []rune(first)[0]
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			firsts = append(firsts, strings.ToUpper(first))
+		}
+	}
+
+	switch {
+	case len(firsts) == 0:
+		return "?"
+	case len(firsts) == 1 || lipgloss.Width(firsts[0]) > 1:
+		return firsts[0]
+	default:
+		return firsts[0] + firsts[len(firsts)-1]
+	}
+}
+
+// validAvatar reports whether an avatar is one printable character that
+// fits the avatar. Avatars are checked when set, but one imported or
+// stored by an older version could otherwise break the layout.
+func validAvatar(avatar string) bool {
+	if !utf8.ValidString(avatar) || uniseg.GraphemeClusterCount(avatar) != 1 {
+		return false
+	}
+	for _, r := range avatar {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return lipgloss.Width(avatar) <= avatarWidth-2
+}
+
+// renderAvatar renders a contact's avatar: their emoji if they have one,
+// or their initials, on their color. It renders nothing in compact mode.
+func renderAvatar(contact *models.Contact, compact bool) string {
+	if compact {
+		return ""
+	}
+
+	label := contact.Avatar
+	if !validAvatar(label) {
+		name := contact.GetDisplayName()
+		if strings.TrimSpace(name) == "" {
+			name = contact.UserID
+		}
+		label = initials(name)
+	}
+	return lipgloss.NewStyle().
+		Background(avatarColor(contact.UserID)).
+		Foreground(lipgloss.Color("15")).
+		Bold(true).
+		Width(avatarWidth).
+		Align(lipgloss.Center).
+		Render(label)
+}
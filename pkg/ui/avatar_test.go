@@ -0,0 +1,102 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestAvatarIsDeterministic(t *testing.T) {
+	bob := &models.Contact{UserID: "bob", DisplayName: "Bob Mensah"}
+	first := renderAvatar(bob, false)
+	if again := renderAvatar(bob, false); again != first {
+		t.Errorf("second rendering %q differs from the first %q", again, first)
+	}
+
+	// The color follows the user ID, however it is written and whatever
+	// the contact is called
+	color := avatarColor("bob")
+	for _, userID := range []string{"bob", "Bob", " BOB "} {
+		if got := avatarColor(userID); got != color {
+			t.Errorf("avatarColor(%q) = %s, want %s like bob", userID, got, color)
+		}
+	}
+	renamed := &models.Contact{UserID: "bob", DisplayName: "Kwabena"}
+	if got := avatarColor(renamed.UserID); got != color {
+		t.Errorf("color after a rename = %s, want %s", got, color)
+	}
+
+	// Different users are spread over the palette
+	colors := make(map[lipgloss.Color]bool)
+	for i := 0; i < 100; i++ {
+		colors[avatarColor(fmt.Sprintf("user%d", i))] = true
+	}
+	if len(colors) < len(avatarPalette)/2 {
+		t.Errorf("100 users share %d colors, want most of the %d", len(colors), len(avatarPalette))
+	}
+}
+
+func TestInitials(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Ama Mensah", "AM"},
+		{"kofi", "K"},
+		{"Kwame Nkrumah Jr", "KJ"},
+		{"José Ábalo", "JÁ"},
+		{"小明 王", "小"}, // A wide character fills the avatar
+		{"@bob 2nd", "2"},
+		{"", "?"},
+		{"   ", "?"},
+		{"!!! ###", "?"},
+		{"\xff\xfe", "?"},
+		{"🦊 fox", "F"},
+	}
+	for _, tt := range tests {
+		if got := initials(tt.name); got != tt.want {
+			t.Errorf("initials(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAvatarFallsBackToInitials(t *testing.T) {
+	tests := []struct {
+		name    string
+		contact models.Contact
+		want    string
+	}{
+		{"emoji", models.Contact{UserID: "bob", DisplayName: "Bob", Avatar: "🦊"}, "🦊"},
+		{"no avatar", models.Contact{UserID: "bob", DisplayName: "Bob Mensah"}, "BM"},
+		{"no name", models.Contact{UserID: "bob"}, "B"},
+		{"blank name", models.Contact{UserID: "bob", DisplayName: "  "}, "B"},
+		{"several characters", models.Contact{UserID: "bob", DisplayName: "Bob", Avatar: "🦊🦊🦊"}, "B"},
+		{"a word", models.Contact{UserID: "bob", DisplayName: "Bob", Avatar: "fox"}, "B"},
+		{"a newline", models.Contact{UserID: "bob", DisplayName: "Bob", Avatar: "\n"}, "B"},
+		{"a control character", models.Contact{UserID: "bob", DisplayName: "Bob", Avatar: "\x1b"}, "B"},
+		{"invalid UTF-8", models.Contact{UserID: "bob", DisplayName: "Bob", Avatar: "\xff"}, "B"},
+		{"nothing usable", models.Contact{UserID: "_", DisplayName: "!!"}, "?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avatar := renderAvatar(&tt.contact, false)
+			if strings.Contains(avatar, "\n") {
+				t.Fatalf("avatar %q takes more than one line", avatar)
+			}
+			if w := lipgloss.Width(avatar); w != avatarWidth {
+				t.Errorf("avatar %q is %d cells, want %d", avatar, w, avatarWidth)
+			}
+			if got := strings.TrimSpace(avatar); got != tt.want {
+				t.Errorf("avatar shows %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if avatar := renderAvatar(&models.Contact{UserID: "bob", Avatar: "🦊"}, true); avatar != "" {
+		t.Errorf("avatar in compact mode = %q, want none", avatar)
+	}
+}
@@ -0,0 +1,46 @@
+package ui
+
+import (
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// bubbleBorderLines is how many lines the border adds to a bubble
+const bubbleBorderLines = 2
+
+// minBubbleWidth is the narrowest a bubble's text is wrapped to
+const minBubbleWidth = 10
+
+// frameMessage lays out a rendered message in the message area. In the
+// flat layout it is left as is; in the bubble layout it is boxed, with the
+// user's own messages on the right.
+func (c *ChatView) frameMessage(msg models.Message, text string) string {
+	if c.config.UI.MessageLayout != config.LayoutBubbles {
+		return text
+	}
+	return renderBubble(text, msg.IsFromUser(c.config.User.ID), c.width-2, c.theme)
+}
+
+// renderBubble boxes text and aligns the box within width: right for the
+// user's own messages, left for others. The box shrinks to fit short text
+// and is at most three quarters of width, wrapping longer lines.
+func renderBubble(text string, own bool, width int, theme *Theme) string {
+	// The border and padding take two columns on each side
+	textWidth := max(min(lipgloss.Width(text), width*3/4-4), minBubbleWidth)
+
+	border := theme.Border
+	align := lipgloss.Left
+	if own {
+		border = theme.Primary
+		align = lipgloss.Right
+	}
+	bubble := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(border).
+		Padding(0, 1).
+		Width(textWidth + 2).
+		Render(text)
+
+	return lipgloss.PlaceHorizontal(width, align, bubble)
+}
@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+// boxEdges returns the columns of the first and last border characters of
+// a rendered bubble's top line
+func boxEdges(t *testing.T, bubble string) (int, int) {
+	t.Helper()
+
+	top := []rune(strings.Split(bubble, "\n")[0])
+	left, right := -1, -1
+	for i, r := range top {
+		switch r {
+		case '╭':
+			left = i
+		case '╮':
+			right = i
+		}
+	}
+	if left < 0 || right < 0 {
+		t.Fatalf("no box in %q", string(top))
+	}
+	return left, right
+}
+
+func TestBubbleAlignmentAndSize(t *testing.T) {
+	theme := getTheme("dark")
+	tests := []struct {
+		name      string
+		text      string
+		own       bool
+		width     int
+		wantBox   int // Columns the box takes, borders included
+		wantLines int
+	}{
+		{"short own message", "hi there", true, 80, minBubbleWidth + 4, 3},
+		{"short message from a contact", "hi there", false, 80, minBubbleWidth + 4, 3},
+		{"fits the text", "a message of thirty characters", false, 80, 30 + 4, 3},
+		{"two lines", "first line\nsecond line", true, 80, 11 + 4, 4},
+		{"wraps at three quarters", strings.Repeat("word ", 30), true, 80, 60, 5},
+		{"narrow", strings.Repeat("word ", 30), false, 40, 30, 8},
+		{"wide", strings.Repeat("word ", 30), true, 120, 90, 4},
+		{"double-width characters", "你好世界你好世界", false, 80, 16 + 4, 3},
+		{"emoji", "👋 hello 🎉", true, 80, 11 + 4, 3},
+		{"double-width characters wrap at three quarters", strings.Repeat("你好", 40), false, 80, 60, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bubble := renderBubble(lipgloss.NewStyle().Render(strings.TrimSpace(tt.text)), tt.own, tt.width, theme)
+			lines := strings.Split(bubble, "\n")
+			if len(lines) != tt.wantLines {
+				t.Errorf("%d lines, want %d:\n%s", len(lines), tt.wantLines, bubble)
+			}
+			for _, line := range lines {
+				if w := lipgloss.Width(line); w != tt.width {
+					t.Fatalf("line is %d columns, want the full width %d: %q", w, tt.width, line)
+				}
+			}
+
+			left, right := boxEdges(t, bubble)
+			if box := right - left + 1; box != tt.wantBox {
+				t.Errorf("box is %d columns, want %d", box, tt.wantBox)
+			}
+			if tt.own && right != tt.width-1 {
+				t.Errorf("own message ends at column %d, want it against the right edge %d", right, tt.width-1)
+			}
+			if !tt.own && left != 0 {
+				t.Errorf("contact's message starts at column %d, want it against the left edge", left)
+			}
+		})
+	}
+}
+
+func TestChatSwitchesLayoutLive(t *testing.T) {
+	c := renderedChat(80, 2)
+	c.messages[1].From, c.messages[1].To = "bob", "alice"
+	c.rendered = newMessageRenderCache()
+
+	flat := c.View()
+	if strings.Contains(flat, "╭") {
+		t.Fatalf("the flat layout draws boxes:\n%s", flat)
+	}
+	flatFit := c.maxVisibleMessages()
+
+	c.config.UI.MessageLayout = config.LayoutBubbles
+	own, theirs := c.renderMessage(c.messages[0], ""), c.renderMessage(c.messages[1], "")
+	if left, _ := boxEdges(t, own); left == 0 {
+		t.Error("alice's own message is not on the right")
+	}
+	if left, _ := boxEdges(t, theirs); left != 0 {
+		t.Error("bob's message is not on the left")
+	}
+	if view := c.View(); strings.Count(view, "╭") != 2 {
+		t.Errorf("the view does not switch to two bubbles:\n%s", view)
+	}
+	if fit := c.maxVisibleMessages(); fit >= flatFit {
+		t.Errorf("%d bubbles fit where %d flat messages do, want fewer for their borders", fit, flatFit)
+	}
+
+	c.config.UI.MessageLayout = config.LayoutFlat
+	if view := c.View(); strings.Contains(view, "╭") {
+		t.Error("switching back still draws boxes")
+	}
+}
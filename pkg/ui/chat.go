@@ -2,7 +2,10 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -14,26 +17,242 @@ import (
 type ChatView struct {
 	config   *config.Config
 	theme    *Theme
+	keys     *KeyMap
 	width    int
 	height   int
 	
 	// Chat state
-	messages    []models.Message
-	currentChat string
-	input       string
-	cursor      int
+	messages      []models.Message
+	currentChat   string
+	contact       *models.Contact
+	contactLookup ContactLookup
+	setActiveChat ActiveChatSetter
 	
-	// UI state
+	// Whether the open chat is encrypted, looked up when it is opened and
+	// set to downgraded when a plaintext message shows up in it
+	encryption       models.EncryptionState
+	encryptionLookup EncryptionLookup
+	input         string
+	cursor        int
+	
+	// UI state. unseen counts messages that arrived below the view while
+	// the user was reading history.
 	scrollOffset int
+	unseen       int
 	typing       bool
+	location     *time.Location // Timezone of displayed times
+	
+	// Sends typed messages. They are shown at once with their final ID and
+	// updated in place as their status changes.
+	sender MessageSender
+	
+	// Set while asking whether to send to a contact whose identity key
+	// changed and has not been verified again
+	confirmSend bool
+	
+	// Accepts or rejects a key the contact presented while keys are only
+	// accepted when asked
+	keyDecider KeyDecider
+	
+	// Message picked with the selection keys, and the contact picker shown
+	// while forwarding it
+	selectedID    string
+	forwarding    *forwardPicker
+	contactLister ContactLister
+	forwarder     MessageForwarder
+	
+	// Search of the chat's messages, nil while none is open
+	search   *chatSearch
+	searcher MessageSearcher
+	
+	// Result of the last action, shown until the next key
+	notice string
+	
+	// Active file transfers keyed by transfer ID
+	transfers map[string]TransferProgressMsg
+	
+	// Files contacts offered that await an answer, oldest first
+	fileOffers       []FileOfferMsg
+	fileOfferDecider FileOfferDecider
+	
+	// History paging
+	historyLoader    HistoryLoader
+	historyLoading   bool
+	historyExhausted bool
+	
+	// Quoted parents of replies that are not in the loaded window. A nil
+	// entry means the parent no longer exists.
+	messageLookup MessageLookup
+	replyParents  map[string]*models.Message
+	replyPending  map[string]bool
+	
+	// Styled messages, reused until their width, status or quote changes
+	rendered *messageRenderCache
+	
+	// Set while a refresh of the disappearing message countdowns is pending
+	expiryTicking bool
+	
+	// Inline previews of image attachments keyed by attachment ID. images
+	// is nil when the terminal cannot draw them.
+	images           imageEncoder
+	attachmentOpener AttachmentOpener
+	previews         map[string]*attachmentPreview
+	nextPreviewID    int
+}
+
+// MessageLookup returns a stored message of a chat by ID, or nil if it no
+// longer exists
+type MessageLookup func(chatID, messageID string) (*models.Message, error)
+
+// ReplyParentsLoadedMsg delivers quoted messages fetched for replies
+type ReplyParentsLoadedMsg struct {
+	ChatID  string
+	Parents map[string]*models.Message
+	Failed  []string
+}
+
+// MessageSender sends a message composed in the chat view, keeping its ID.
+// overrideKeyChange sends it although the contact's key changed and has not
+// been verified again.
+type MessageSender func(msg *models.Message, overrideKeyChange bool) error
+
+// MessageSentMsg reports the result of sending a message from the chat view
+type MessageSentMsg struct {
+	MessageID string
+	Err       error
+}
+
+// MessageUpdatedMsg carries a message that was received, or one whose
+// delivery status changed
+type MessageUpdatedMsg struct {
+	Message models.Message
+}
+
+// MessageReactionMsg reports that a user added or removed a reaction to a
+// message. Only the reaction row of the message is re-rendered.
+type MessageReactionMsg struct {
+	MessageID string
+	From      string
+	Emoji     string
+	Removed   bool
+}
+
+// EncryptionLookup returns whether the conversation with a contact is encrypted
+type EncryptionLookup func(userID string) models.EncryptionState
+
+// EncryptionDowngradeMsg reports a plaintext message received from a
+// contact whose conversation is encrypted
+type EncryptionDowngradeMsg struct {
+	UserID  string
+	Refused bool // The message was dropped
+}
+
+// EncryptionStateMsg reports that a session with a contact started being
+// set up, was established, or could not be set up
+type EncryptionStateMsg struct {
+	UserID string
+	State  models.EncryptionState
+}
+
+// MessagesDroppedMsg reports messages to a contact that failed while
+// waiting for an encrypted session
+type MessagesDroppedMsg struct {
+	UserID string
+	Count  int
+	Reason string
+}
+
+// MessagesMissingMsg reports messages from a contact that never arrived and
+// were asked for again, or with a Count of 0 that they arrived after all
+type MessagesMissingMsg struct {
+	UserID string
+	Count  int
+}
+
+// ContactLookup returns the stored contact for a user ID
+type ContactLookup func(userID string) (*models.Contact, bool)
+
+// ActiveChatSetter is told which chat is open, so that its messages queued
+// while offline are fetched first
+type ActiveChatSetter func(chatID string)
+
+// KeyDecider accepts or rejects the identity key a contact presented
+type KeyDecider func(userID string, accept bool) error
+
+// KeyDecidedMsg reports the result of accepting or rejecting a key
+type KeyDecidedMsg struct {
+	Accepted bool
+	Err      error
+}
+
+// FileOfferDecider accepts or declines a file a contact offered
+type FileOfferDecider func(transferID string, accept bool) error
+
+// FileOfferMsg tells the chat view that a contact offered a file
+type FileOfferMsg struct {
+	TransferID string
+	From       string
+	Filename   string
+	Size       int64
+}
+
+// FileOfferDecidedMsg reports the result of accepting or declining a file
+type FileOfferDecidedMsg struct {
+	Filename string
+	Accepted bool
+	Err      error
+}
+
+// ContactUpdatedMsg tells the views that a contact's details, presence or
+// verification state changed
+type ContactUpdatedMsg struct {
+	Contact models.Contact
+}
+
+// ContactAddedMsg tells the views about a new contact
+type ContactAddedMsg struct {
+	Contact models.Contact
+}
+
+// ContactRemovedMsg tells the views that a contact was deleted
+type ContactRemovedMsg struct {
+	UserID string
+}
+
+// HistoryLoader returns up to limit messages of a chat that precede before,
+// oldest first. A nil before requests the most recent messages.
+type HistoryLoader func(chatID string, before *models.Message, limit int) ([]*models.Message, error)
+
+// HistoryLoadedMsg delivers a page of chat history to the chat view
+type HistoryLoadedMsg struct {
+	ChatID   string
+	Before   *models.Message
+	Messages []*models.Message
+	Err      error
+}
+
+// TransferProgressMsg reports file transfer progress to the chat view
+type TransferProgressMsg struct {
+	TransferID string
+	Filename   string
+	Current    int64
+	Total      int64
+	Done       bool
 }
 
 // NewChatView creates a new chat view
-func NewChatView(cfg *config.Config, theme *Theme) *ChatView {
+func NewChatView(cfg *config.Config, theme *Theme, keys *KeyMap) *ChatView {
 	return &ChatView{
-		config:   cfg,
-		theme:    theme,
-		messages: []models.Message{},
+		config:    cfg,
+		theme:     theme,
+		keys:      keys,
+		messages:     []models.Message{},
+		transfers:    make(map[string]TransferProgressMsg),
+		replyParents: make(map[string]*models.Message),
+		replyPending: make(map[string]bool),
+		rendered:     newMessageRenderCache(),
+		previews:     make(map[string]*attachmentPreview),
+		location:     displayLocation(cfg.UI),
 	}
 }
 
@@ -42,60 +261,338 @@ func (c *ChatView) Init() tea.Cmd {
 	return nil
 }
 
+// SetHistoryLoader sets the function used to page in chat history
+func (c *ChatView) SetHistoryLoader(loader HistoryLoader) {
+	c.historyLoader = loader
+}
+
+// SetContactLookup sets the function used to find the contact of a chat
+func (c *ChatView) SetContactLookup(lookup ContactLookup) {
+	c.contactLookup = lookup
+}
+
+// SetAttachmentOpener sets the function used to read attachments for previews
+func (c *ChatView) SetAttachmentOpener(opener AttachmentOpener) {
+	c.attachmentOpener = opener
+}
+
+// SetContactLister sets the function the chat view uses to offer forward targets
+func (c *ChatView) SetContactLister(lister ContactLister) {
+	c.contactLister = lister
+}
+
+// SetMessageForwarder sets the function the chat view uses to forward messages
+func (c *ChatView) SetMessageForwarder(forwarder MessageForwarder) {
+	c.forwarder = forwarder
+}
+
+// SetMessageLookup sets the function used to fetch quoted reply parents
+func (c *ChatView) SetMessageLookup(lookup MessageLookup) {
+	c.messageLookup = lookup
+}
+
+// SetEncryptionLookup sets the function used to find whether a chat is encrypted
+func (c *ChatView) SetEncryptionLookup(lookup EncryptionLookup) {
+	c.encryptionLookup = lookup
+}
+
+// SetActiveChatSetter sets the function told which chat is open
+func (c *ChatView) SetActiveChatSetter(setter ActiveChatSetter) {
+	c.setActiveChat = setter
+}
+
+// OpenChat switches the view to a chat and loads its most recent messages
+func (c *ChatView) OpenChat(chatID string) tea.Cmd {
+	c.currentChat = chatID
+	if c.setActiveChat != nil {
+		c.setActiveChat(chatID)
+	}
+	c.contact = nil
+	if c.contactLookup != nil {
+		if contact, ok := c.contactLookup(chatID); ok {
+			copied := *contact
+			c.contact = &copied
+		}
+	}
+	c.encryption = models.EncryptionNone
+	if c.encryptionLookup != nil {
+		c.encryption = c.encryptionLookup(chatID)
+	}
+	c.messages = []models.Message{}
+	c.replyParents = make(map[string]*models.Message)
+	c.replyPending = make(map[string]bool)
+	c.rendered.reset()
+	c.scrollOffset = 0
+	c.unseen = 0
+	c.selectedID = ""
+	c.forwarding = nil
+	c.search = nil
+	c.historyLoading = false
+	c.historyExhausted = false
+	
+	return c.loadHistory(nil)
+}
+
 // Update implements tea.Model
 func (c *ChatView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
+		if msg.Width != c.width {
+			c.rendered.reset()
+		}
+		following := c.atBottom()
 		c.width = msg.Width
 		c.height = msg.Height - 2 // Account for status bar
+		if following {
+			c.scrollToBottom()
+		}
+		return c, c.fetchPreviews()
+		
+	case HistoryLoadedMsg:
+		c.applyHistory(msg)
+		return c, tea.Batch(c.fetchReplyParents(), c.fetchPreviews(), c.scheduleExpiryTick(), c.continueSearch())
+		
+	case SearchResultsMsg:
+		return c, c.applySearchResults(msg)
+		
+	case expiryTickMsg:
+		c.expiryTicking = false
+		c.removeExpired(time.Now())
+		return c, c.scheduleExpiryTick()
+		
+	case AttachmentPreviewsLoadedMsg:
+		for id, preview := range msg.Previews {
+			c.previews[id] = preview
+		}
+		c.rendered.reset()
+		
+	case ReplyParentsLoadedMsg:
+		if msg.ChatID == c.currentChat {
+			for id, parent := range msg.Parents {
+				c.replyParents[id] = parent
+				delete(c.replyPending, id)
+			}
+			for _, id := range msg.Failed {
+				delete(c.replyPending, id)
+			}
+		}
+		
+	case ContactUpdatedMsg:
+		if msg.Contact.UserID == c.currentChat {
+			contact := msg.Contact
+			c.contact = &contact
+		}
+		
+	case EncryptionDowngradeMsg:
+		if msg.UserID == c.currentChat {
+			c.encryption = models.EncryptionDowngraded
+			c.notice = "⚠ Received a plaintext message in this encrypted chat; someone may be tampering with it"
+			if msg.Refused {
+				c.notice = "⚠ Dropped a plaintext message in this encrypted chat; someone may be tampering with it"
+			}
+		}
+		
+	case EncryptionStateMsg:
+		if msg.UserID == c.currentChat {
+			c.encryption = msg.State
+		}
+		
+	case MessagesDroppedMsg:
+		if msg.UserID == c.currentChat {
+			c.notice = fmt.Sprintf("⚠ %d message(s) could not be sent: %s", msg.Count, msg.Reason)
+		}
+		
+	case MessagesMissingMsg:
+		if msg.UserID == c.currentChat {
+			if msg.Count > 0 {
+				c.notice = fmt.Sprintf("⚠ Some messages may be missing: %d earlier message(s) never arrived and were asked for again", msg.Count)
+			} else {
+				c.notice = "The missing messages have arrived"
+			}
+		}
+		
+	case FileOfferMsg:
+		c.fileOffers = append(c.fileOffers, msg)
+		
+	case FileOfferDecidedMsg:
+		switch {
+		case msg.Err != nil:
+			c.notice = fmt.Sprintf("Could not answer the offer of %s: %v", msg.Filename, msg.Err)
+		case msg.Accepted:
+			c.notice = "Receiving " + msg.Filename
+		default:
+			c.notice = "Declined " + msg.Filename
+		}
+		
+	case TransferProgressMsg:
+		if msg.Done {
+			delete(c.transfers, msg.TransferID)
+		} else {
+			c.transfers[msg.TransferID] = msg
+		}
+		
+	case MessageUpdatedMsg:
+		if c.inCurrentChat(&msg.Message) {
+			// Follow new messages only while the latest are in view, so
+			// reading history is not interrupted
+			following := c.atBottom()
+			if c.updateMessage(msg.Message) {
+				switch {
+				case following:
+					c.scrollToBottom()
+				case c.belowView(msg.Message.ID):
+					c.unseen++
+				}
+			}
+			// A new or edited reply may quote a message outside the
+			// loaded window
+			return c, tea.Batch(c.fetchReplyParents(), c.scheduleExpiryTick())
+		}
+		
+	case MessageReactionMsg:
+		c.applyReaction(msg)
+		
+	case editorClosedMsg:
+		c.applyDraft(msg)
+		
+	case MessageSentMsg:
+		if msg.Err != nil {
+			c.markFailed(msg.MessageID)
+			c.notice = fmt.Sprintf("Could not send message: %v", msg.Err)
+		}
+		
+	case KeyDecidedMsg:
+		switch {
+		case msg.Err != nil:
+			c.notice = fmt.Sprintf("Could not update the identity key: %v", msg.Err)
+		case msg.Accepted:
+			c.notice = "Identity key accepted"
+		default:
+			c.notice = "Identity key rejected"
+		}
+		
+	case MessageForwardedMsg:
+		c.notice = "Forwarded to " + msg.To
+		if msg.Err != nil {
+			c.notice = fmt.Sprintf("Could not forward to %s: %v", msg.To, msg.Err)
+		}
 		
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "enter":
-			if c.input != "" {
-				// Send message
-				newMsg := models.NewMessage(
-					models.MessageTypeChat,
-					c.config.User.ID,
-					c.currentChat,
-					c.input,
-				)
-				c.messages = append(c.messages, *newMsg)
-				c.input = ""
-				c.cursor = 0
-				c.scrollToBottom()
+		c.notice = ""
+		
+		switch {
+		case c.confirmSend:
+			// Only the answer to the prompt is accepted until it is given
+			switch {
+			case c.keys.Matches(msg, ActionConfirm):
+				c.confirmSend = false
+				return c, c.sendInput(true)
+			case c.keys.Matches(msg, ActionDecline), c.keys.Matches(msg, ActionBack):
+				c.confirmSend = false
+			}
+			
+		case c.pendingKey():
+			// The key must be accepted or rejected before chatting on
+			switch {
+			case c.keys.Matches(msg, ActionConfirm):
+				return c, c.decideKey(true)
+			case c.keys.Matches(msg, ActionDecline):
+				return c, c.decideKey(false)
 			}
 			
-		case "backspace":
+		case c.pendingFileOffer() >= 0:
+			// Offered files are answered before chatting on
+			switch {
+			case c.keys.Matches(msg, ActionConfirm):
+				return c, c.decideFileOffer(true)
+			case c.keys.Matches(msg, ActionDecline):
+				return c, c.decideFileOffer(false)
+			}
+			
+		case c.forwarding != nil:
+			return c, c.updateForward(msg)
+			
+		case c.search != nil:
+			return c, c.updateSearch(msg)
+			
+		case c.keys.Matches(msg, ActionSearchMessages):
+			c.startSearch()
+			
+		case c.keys.Matches(msg, ActionSelectPrevMessage):
+			c.selectMessage(-1)
+			
+		case c.keys.Matches(msg, ActionSelectNextMessage):
+			c.selectMessage(1)
+			
+		case c.keys.Matches(msg, ActionForward):
+			c.startForward()
+			
+		case c.selectedID != "" && c.keys.Matches(msg, ActionBack):
+			c.selectedID = ""
+			
+		case c.keys.Matches(msg, ActionOpenEditor):
+			return c, c.openEditor()
+			
+		case c.keys.Matches(msg, ActionSend):
+			if _, ok := composeMessage(c.input); !ok {
+				// Nothing to send; the input is left as typed
+				if c.input != "" {
+					c.notice = "Nothing to send"
+				}
+			} else if c.unverifiedKeyChange() {
+				c.confirmSend = true
+			} else {
+				return c, c.sendInput(false)
+			}
+			
+		case c.keys.Matches(msg, ActionDeleteBackward):
+			// The cursor moves by whole characters, so multibyte text such
+			// as a draft from the editor is never split
 			if c.cursor > 0 {
-				c.input = c.input[:c.cursor-1] + c.input[c.cursor:]
-				c.cursor--
+				_, size := utf8.DecodeLastRuneInString(c.input[:c.cursor])
+				c.input = c.input[:c.cursor-size] + c.input[c.cursor:]
+				c.cursor -= size
 			}
 			
-		case "left":
+		case c.keys.Matches(msg, ActionCursorLeft):
 			if c.cursor > 0 {
-				c.cursor--
+				_, size := utf8.DecodeLastRuneInString(c.input[:c.cursor])
+				c.cursor -= size
 			}
 			
-		case "right":
+		case c.keys.Matches(msg, ActionCursorRight):
 			if c.cursor < len(c.input) {
-				c.cursor++
+				_, size := utf8.DecodeRuneInString(c.input[c.cursor:])
+				c.cursor += size
 			}
 			
-		case "up":
+		case c.keys.Matches(msg, ActionScrollUp):
 			if c.scrollOffset > 0 {
 				c.scrollOffset--
+			} else if len(c.messages) > 0 {
+				// At the top: page in older history
+				return c, c.loadHistory(&c.messages[0])
 			}
 			
-		case "down":
-			maxScroll := len(c.messages) - c.getMessageAreaHeight()
+		case c.keys.Matches(msg, ActionScrollDown):
+			maxScroll := len(c.messages) - c.maxVisibleMessages()
 			if c.scrollOffset < maxScroll {
 				c.scrollOffset++
 			}
+			if c.atBottom() {
+				c.unseen = 0
+			}
+			
+		case c.keys.Matches(msg, ActionBottom):
+			c.scrollToBottom()
 			
-		case "ctrl+l":
+		case c.keys.Matches(msg, ActionClearChat):
 			c.messages = []models.Message{}
+			c.rendered.reset()
 			c.scrollOffset = 0
+			c.unseen = 0
+			c.selectedID = ""
+			c.search = nil
 			
 		default:
 			// Handle regular character input
@@ -109,6 +606,104 @@ func (c *ChatView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return c, nil
 }
 
+// unverifiedKeyChange reports whether sending must be confirmed because the
+// contact's identity key changed and verification is required
+func (c *ChatView) unverifiedKeyChange() bool {
+	return c.config.Security.RequireVerification && c.contact != nil && c.contact.KeyChanged
+}
+
+// pendingKey reports whether the contact presented a key the user has to
+// accept or reject
+func (c *ChatView) pendingKey() bool {
+	return c.contact != nil && c.contact.PendingFingerprint != ""
+}
+
+// decideKey accepts or rejects the contact's pending key
+func (c *ChatView) decideKey(accept bool) tea.Cmd {
+	if c.keyDecider == nil {
+		return nil
+	}
+	decide := c.keyDecider
+	userID := c.contact.UserID
+	return func() tea.Msg {
+		return KeyDecidedMsg{Accepted: accept, Err: decide(userID, accept)}
+	}
+}
+
+// pendingFileOffer returns the index of the oldest file the contact offered
+// that awaits an answer, or -1 if there is none
+func (c *ChatView) pendingFileOffer() int {
+	if c.contact == nil {
+		return -1
+	}
+	for i, offer := range c.fileOffers {
+		if offer.From == c.contact.UserID {
+			return i
+		}
+	}
+	return -1
+}
+
+// decideFileOffer accepts or declines the oldest file the contact offered
+func (c *ChatView) decideFileOffer(accept bool) tea.Cmd {
+	i := c.pendingFileOffer()
+	offer := c.fileOffers[i]
+	c.fileOffers = append(c.fileOffers[:i], c.fileOffers[i+1:]...)
+	if c.fileOfferDecider == nil {
+		return nil
+	}
+	decide := c.fileOfferDecider
+	return func() tea.Msg {
+		return FileOfferDecidedMsg{Filename: offer.Filename, Accepted: accept, Err: decide(offer.TransferID, accept)}
+	}
+}
+
+// SetFileOfferDecider sets the function offered files are accepted or
+// declined with
+func (c *ChatView) SetFileOfferDecider(decider FileOfferDecider) {
+	c.fileOfferDecider = decider
+}
+
+// SetKeyDecider sets the function pending identity keys are accepted or
+// rejected with
+func (c *ChatView) SetKeyDecider(decider KeyDecider) {
+	c.keyDecider = decider
+}
+
+// SetMessageSender sets the function typed messages are sent with
+func (c *ChatView) SetMessageSender(sender MessageSender) {
+	c.sender = sender
+}
+
+// sendInput shows the typed message, clears the input and returns the
+// command sending it. The message is shown as pending until its status
+// update arrives. Input that is only whitespace is not sent.
+func (c *ChatView) sendInput(overrideKeyChange bool) tea.Cmd {
+	content, ok := composeMessage(c.input)
+	if !ok {
+		return nil
+	}
+	newMsg := models.NewMessage(
+		models.MessageTypeChat,
+		c.config.User.ID,
+		c.currentChat,
+		content,
+	)
+	newMsg.Sequence = c.nextSequence()
+	c.addMessage(*newMsg)
+	c.input = ""
+	c.cursor = 0
+	c.scrollToBottom()
+	
+	if c.sender == nil {
+		return nil
+	}
+	send := c.sender
+	return func() tea.Msg {
+		return MessageSentMsg{MessageID: newMsg.ID, Err: send(newMsg, overrideKeyChange)}
+	}
+}
+
 // View implements tea.Model
 func (c *ChatView) View() string {
 	if c.width == 0 || c.height == 0 {
@@ -137,14 +732,25 @@ func (c *ChatView) renderHeader() string {
 		Width(c.width)
 	
 	title := "SecureChat"
+	status := ""
 	if c.currentChat != "" {
 		title = fmt.Sprintf("Chat with %s", c.currentChat)
 	}
+	if c.contact != nil {
+		title = fmt.Sprintf("Chat with %s  %s  %s", c.contact.GetDisplayName(), c.renderEncryption(), c.renderVerification())
+		status = c.renderContactStatus()
+	}
 	
-	status := "● Online"
+	// The avatar goes before the title if there is room for it
+	if c.contact != nil {
+		avatar := renderAvatar(c.contact, c.config.UI.CompactMode)
+		if avatar != "" && lipgloss.Width(avatar)+1+lipgloss.Width(title)+lipgloss.Width(status)+2 <= c.width {
+			title = avatar + " " + title
+		}
+	}
 	
-	// Center title, right-align status
-	padding := c.width - len(title) - len(status) - 4 // Account for padding
+	// Left-align title, right-align status
+	padding := c.width - lipgloss.Width(title) - lipgloss.Width(status) - 2 // Account for padding
 	if padding < 0 {
 		padding = 0
 	}
@@ -158,6 +764,84 @@ func (c *ChatView) renderHeader() string {
 	return style.Render(content)
 }
 
+// renderEncryption renders whether the open chat is encrypted. A downgrade
+// is shown until a new key exchange completes.
+func (c *ChatView) renderEncryption() string {
+	var badge string
+	color := c.theme.Error
+	switch c.encryption {
+	case models.EncryptionActive:
+		badge = "🔒 encrypted"
+		color = c.theme.Success
+	case models.EncryptionPending:
+		badge = "establishing secure session…"
+		color = c.theme.Warning
+	case models.EncryptionDowngraded:
+		badge = "🔓 PLAINTEXT IN ENCRYPTED CHAT ⚠"
+	default:
+		badge = "🔓 not encrypted"
+		color = c.theme.Warning
+	}
+	
+	return lipgloss.NewStyle().
+		Background(c.theme.Primary).
+		Foreground(color).
+		Bold(true).
+		Render(badge)
+}
+
+// renderVerification renders the verification badge and fingerprint of
+// the current contact, so a changed or unverified key stays in view
+func (c *ChatView) renderVerification() string {
+	var badge string
+	color := c.theme.Error
+	switch {
+	case c.contact.KeyChanged:
+		badge = "key changed ⚠"
+	case c.contact.State().Verified:
+		badge = "✓ verified"
+		color = c.theme.Success
+	default:
+		badge = "unverified ⚠"
+	}
+	
+	if fingerprint := formatFingerprint(c.contact.Fingerprint, c.config.UI); fingerprint != "" {
+		badge += " " + fingerprint
+	}
+	
+	return lipgloss.NewStyle().
+		Background(c.theme.Primary).
+		Foreground(color).
+		Bold(true).
+		Render(badge)
+}
+
+// renderContactStatus renders the current contact's presence, and whether
+// they are blocked, muted or archived
+func (c *ChatView) renderContactStatus() string {
+	state := c.contact.State()
+	var status string
+	switch {
+	case state.Blocked:
+		return "⊘ Blocked"
+	case state.Online:
+		status = "● Online"
+	default:
+		status = "○ Offline"
+		if lastSeen := formatContactLastSeen(*c.contact, time.Now(), c.location); lastSeen != "" {
+			status = "○ " + lastSeen
+		}
+	}
+	
+	if state.Muted {
+		status += " · muted"
+	}
+	if state.Archived {
+		status += " · archived"
+	}
+	return status
+}
+
 // renderMessages renders the message area
 func (c *ChatView) renderMessages() string {
 	messageHeight := c.getMessageAreaHeight()
@@ -185,9 +869,17 @@ func (c *ChatView) renderMessages() string {
 	// Render visible messages
 	var messageLines []string
 	visibleMessages := c.getVisibleMessages()
+	showDevices := c.ownDeviceCount() > 1
 	
 	for _, msg := range visibleMessages {
-		messageLines = append(messageLines, c.formatMessage(msg))
+		device := ""
+		if showDevices && msg.IsFromUser(c.config.User.ID) {
+			device = deviceLabel(msg)
+		}
+		messageLines = append(messageLines, c.renderMessage(msg, device))
+	}
+	if c.unseen > 0 {
+		messageLines = append(messageLines, c.renderUnseen())
 	}
 	
 	content := strings.Join(messageLines, "\n")
@@ -215,23 +907,185 @@ func (c *ChatView) renderInput() string {
 	
 	content := prompt + input
 	
-	// Add help text
+	// Add help text, or the prompt awaiting an answer
 	help := lipgloss.NewStyle().
 		Foreground(c.theme.Secondary).
-		Render("(Enter to send, Ctrl+L to clear, ↑↓ to scroll)")
+		Render(fmt.Sprintf("(%s to send, %s to clear, %s/%s to scroll)",
+			c.keys.Help(ActionSend), c.keys.Help(ActionClearChat),
+			c.keys.Help(ActionScrollUp), c.keys.Help(ActionScrollDown)))
+	if c.search != nil {
+		content, help = c.renderSearch()
+	}
+	switch {
+	case c.forwarding != nil:
+		help = c.renderForwardPicker()
+	case c.notice != "":
+		help = lipgloss.NewStyle().
+			Foreground(c.theme.Secondary).
+			Render(c.notice)
+	}
+	if c.pendingKey() {
+		question := fmt.Sprintf("🔑 New identity key %s — accept it?", formatFingerprint(c.contact.PendingFingerprint, c.config.UI))
+		if c.contact.Fingerprint != "" {
+			question = fmt.Sprintf("🔑 Identity key changed from %s to %s — accept it?",
+				formatFingerprint(c.contact.Fingerprint, c.config.UI), formatFingerprint(c.contact.PendingFingerprint, c.config.UI))
+		}
+		help = lipgloss.NewStyle().
+			Foreground(c.theme.Warning).
+			Bold(true).
+			Render(fmt.Sprintf("%s [%s/%s]", question,
+				c.keys.Help(ActionConfirm), c.keys.Help(ActionDecline)))
+	}
+	if i := c.pendingFileOffer(); i >= 0 && !c.pendingKey() {
+		offer := c.fileOffers[i]
+		help = lipgloss.NewStyle().
+			Foreground(c.theme.Warning).
+			Bold(true).
+			Render(fmt.Sprintf("📎 %s offers %s (%s) — receive it? [%s/%s]",
+				c.contact.GetDisplayName(), truncateString(offer.Filename, 30), formatFileSize(offer.Size),
+				c.keys.Help(ActionConfirm), c.keys.Help(ActionDecline)))
+	}
+	if c.confirmSend {
+		help = lipgloss.NewStyle().
+			Foreground(c.theme.Warning).
+			Bold(true).
+			Render(fmt.Sprintf("⚠ Identity changed — send anyway? [%s/%s]",
+				c.keys.Help(ActionConfirm), c.keys.Help(ActionDecline)))
+	}
+	
+	lines := []string{content, help}
+	lines = append(lines, c.renderTransfers()...)
 	
 	return style.Render(
 		lipgloss.JoinVertical(
 			lipgloss.Left,
-			content,
-			help,
+			lines...,
 		),
 	)
 }
 
-// formatMessage formats a single message for display
-func (c *ChatView) formatMessage(msg models.Message) string {
-	timeStr := msg.Timestamp.Format(c.config.UI.TimestampFormat)
+// renderTransfers renders a progress line for each active file transfer
+func (c *ChatView) renderTransfers() []string {
+	ids := make([]string, 0, len(c.transfers))
+	for id := range c.transfers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	
+	var lines []string
+	style := lipgloss.NewStyle().Foreground(c.theme.Secondary)
+	for _, id := range ids {
+		t := c.transfers[id]
+		percent := 0
+		if t.Total > 0 {
+			percent = int(t.Current * 100 / t.Total)
+		}
+		lines = append(lines, style.Render(fmt.Sprintf("⇅ %s %s %d%% (%s / %s)",
+			truncateString(t.Filename, 20),
+			generateProgressBar(int(t.Current), int(t.Total), 20),
+			percent,
+			formatFileSize(t.Current),
+			formatFileSize(t.Total),
+		)))
+	}
+	
+	return lines
+}
+
+// renderMessage returns the styled message, naming device as the one it
+// was sent from unless empty. It comes from the cache when nothing it
+// depends on has changed since it was last rendered.
+func (c *ChatView) renderMessage(msg models.Message, device string) string {
+	key := messageRenderKey{
+		width:     c.width,
+		layout:    c.config.UI.MessageLayout,
+		selected:  msg.ID == c.selectedID,
+		timestamp: c.formatTime(msg.DisplayTime()),
+		countdown: c.renderCountdown(&msg, time.Now()),
+		device:    device,
+		highlight: c.searchHighlight(msg.ID),
+	}
+	if msg.Metadata != nil && msg.Metadata.ReplyTo != "" {
+		key.quote = c.quoteState(msg.Metadata.ReplyTo)
+	}
+	overlay := messageOverlayKey{
+		status:    msg.Status,
+		reactions: reactionState(msg.Reactions()),
+	}
+	
+	rendered, ok := c.rendered.get(msg.ID, key)
+	if !ok {
+		rendered = &renderedMessage{key: key}
+		rendered.head, rendered.body = c.formatMessage(msg, device)
+		rendered.status, rendered.reactions = c.formatOverlay(msg)
+		rendered.overlay = overlay
+		rendered.framed = c.frameMessage(msg, rendered.text())
+		c.rendered.put(msg.ID, rendered)
+	} else if rendered.overlay != overlay {
+		// Only the status and reactions changed, so the laid out block is
+		// kept and the lines around it stay as they are
+		rendered.status, rendered.reactions = c.formatOverlay(msg)
+		rendered.overlay = overlay
+		rendered.framed = c.frameMessage(msg, rendered.text())
+	}
+	return rendered.framed
+}
+
+// ownDeviceCount returns from how many devices the user's loaded messages
+// in the chat were sent. Messages from versions that did not record the
+// device are not counted.
+func (c *ChatView) ownDeviceCount() int {
+	devices := make(map[string]bool)
+	for _, msg := range c.messages {
+		if msg.DeviceID != "" && msg.IsFromUser(c.config.User.ID) {
+			devices[msg.DeviceID] = true
+		}
+	}
+	return len(devices)
+}
+
+// deviceLabel names the device a message was sent from
+func deviceLabel(msg models.Message) string {
+	if msg.DeviceName != "" {
+		return msg.DeviceName
+	}
+	return msg.DeviceID
+}
+
+// quoteState summarizes what renderQuote would show for a parent, without
+// styling it
+func (c *ChatView) quoteState(parentID string) string {
+	parent, known := c.findReplyParent(parentID)
+	switch {
+	case !known:
+		return "pending"
+	case parent == nil:
+		return "deleted"
+	}
+	return parent.From + "\x00" + parent.Content
+}
+
+// formatTime formats the time of a message per the UI settings
+func (c *ChatView) formatTime(t time.Time) string {
+	return formatTimestamp(t, time.Now(), c.config.UI, c.location)
+}
+
+// formatMessage formats a single message for display, without its overlay.
+// It returns the first line up to where the status goes, and the lines
+// below it.
+func (c *ChatView) formatMessage(msg models.Message, device string) (string, string) {
+	timeStr := c.formatTime(msg.DisplayTime())
+	if countdown := c.renderCountdown(&msg, time.Now()); countdown != "" {
+		timeStr += " " + countdown
+	}
+	
+	// The time shown is when the message arrived
+	skew := ""
+	if msg.ClockSkewed {
+		skew = " " + lipgloss.NewStyle().
+			Foreground(c.theme.Warning).
+			Render("⚠ sender's clock is off")
+	}
 	
 	var senderStyle lipgloss.Style
 	if msg.IsFromUser(c.config.User.ID) {
@@ -255,11 +1109,461 @@ func (c *ChatView) formatMessage(msg models.Message) string {
 		sender = msg.From // In real app, this would be display name
 	}
 	
-	return fmt.Sprintf("%s %s\n%s",
+	quote := ""
+	if msg.Metadata != nil && msg.Metadata.ReplyTo != "" {
+		quote = c.renderQuote(msg.Metadata.ReplyTo) + "\n"
+	}
+	if msg.Metadata != nil && msg.Metadata.ForwardedFrom != "" {
+		quote += c.renderForwardedFrom(msg.Metadata.ForwardedFrom) + "\n"
+	}
+	
+	selection := ""
+	if msg.ID == c.selectedID {
+		selection = lipgloss.NewStyle().
+			Foreground(c.theme.Highlight).
+			Bold(true).
+			Render("▶ ")
+	}
+	
+	content := contentStyle.Render(stripPreviewMarkers(msg.Content))
+	if c.searchHighlight(msg.ID) != "" {
+		highlight := lipgloss.NewStyle().
+			Foreground(c.theme.Background).
+			Background(c.theme.Highlight)
+		content = highlightWords(stripPreviewMarkers(msg.Content), c.search.terms, contentStyle, highlight)
+	}
+	if msg.HasAttachment() {
+		content = c.renderAttachment(msg.Metadata.Attachment)
+	}
+	
+	if device != "" {
+		timeStr += " · " + device
+	}
+	
+	head := fmt.Sprintf("%s%s %s%s",
+		selection,
 		senderStyle.Render(sender),
 		timeStyle.Render(timeStr),
-		contentStyle.Render(msg.Content),
+		skew,
 	)
+	return head, quote + content
+}
+
+// formatOverlay formats the parts of a message that change while it is
+// shown: the status of an outgoing message and the row of reactions
+func (c *ChatView) formatOverlay(msg models.Message) (string, string) {
+	status := ""
+	if msg.IsFromUser(c.config.User.ID) {
+		status = " " + c.renderStatus(msg.Status)
+	}
+	return status, c.renderReactions(msg.Reactions())
+}
+
+// renderReactions renders reactions as each emoji with how many users
+// reacted with it, in the order they were first used. Emoji the user
+// reacted with are highlighted.
+func (c *ChatView) renderReactions(reactions []models.Reaction) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	
+	var order []string
+	counts := make(map[string]int)
+	own := make(map[string]bool)
+	for _, reaction := range reactions {
+		if counts[reaction.Emoji] == 0 {
+			order = append(order, reaction.Emoji)
+		}
+		counts[reaction.Emoji]++
+		if reaction.From == c.config.User.ID {
+			own[reaction.Emoji] = true
+		}
+	}
+	
+	parts := make([]string, 0, len(order))
+	for _, emoji := range order {
+		style := lipgloss.NewStyle().Foreground(c.theme.Secondary)
+		if own[emoji] {
+			style = lipgloss.NewStyle().Foreground(c.theme.Primary)
+		}
+		parts = append(parts, style.Render(fmt.Sprintf("%s %d", emoji, counts[emoji])))
+	}
+	return "  " + strings.Join(parts, "  ")
+}
+
+// reactionState summarizes reactions for the overlay key, without styling
+// them
+func reactionState(reactions []models.Reaction) string {
+	var b strings.Builder
+	for _, reaction := range reactions {
+		b.WriteString(reaction.From)
+		b.WriteByte(0)
+		b.WriteString(reaction.Emoji)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+
+// renderAttachment renders an attachment as its name and size, followed by
+// a placeholder for its image once a preview has loaded
+func (c *ChatView) renderAttachment(attachment *models.Attachment) string {
+	line := lipgloss.NewStyle().
+		Foreground(c.theme.Foreground).
+		Render(attachmentLine(attachment))
+	
+	preview := c.previews[attachment.ID]
+	if preview == nil || preview.image == "" || preview.cols > c.previewWidth() {
+		return line
+	}
+	
+	marker := previewMarker(preview.id)
+	rows := make([]string, preview.rows)
+	rows[0] = marker + strings.Repeat(" ", max(preview.cols-lipgloss.Width(marker), 0))
+	for i := 1; i < len(rows); i++ {
+		rows[i] = strings.Repeat(" ", preview.cols)
+	}
+	
+	return line + "\n" + strings.Join(rows, "\n")
+}
+
+// previewWidth returns the widest preview the message area fits
+func (c *ChatView) previewWidth() int {
+	return c.width - 6 // Border and padding
+}
+
+// fetchPreviews returns a command decoding previews of loaded image
+// attachments that have none yet, or nil if there are none or the terminal
+// cannot draw images
+func (c *ChatView) fetchPreviews() tea.Cmd {
+	maxCols := c.previewWidth()
+	if c.images == nil || c.attachmentOpener == nil || maxCols < minPreviewCols {
+		return nil
+	}
+	
+	type request struct {
+		attachment models.Attachment
+		id         int
+	}
+	var requests []request
+	for _, msg := range c.messages {
+		if !msg.HasAttachment() {
+			continue
+		}
+		attachment := msg.Metadata.Attachment
+		if _, seen := c.previews[attachment.ID]; seen || !isPreviewableImage(attachment) || attachment.Size > maxPreviewFileSize {
+			continue
+		}
+		c.nextPreviewID++
+		c.previews[attachment.ID] = &attachmentPreview{id: c.nextPreviewID}
+		requests = append(requests, request{attachment: *attachment, id: c.nextPreviewID})
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	
+	open := c.attachmentOpener
+	encoder := c.images
+	
+	return func() tea.Msg {
+		result := AttachmentPreviewsLoadedMsg{
+			Previews: make(map[string]*attachmentPreview, len(requests)),
+		}
+		for _, req := range requests {
+			preview, err := loadPreview(open, encoder, &req.attachment, req.id, maxCols)
+			if err != nil {
+				// The attachment keeps its text line
+				preview = &attachmentPreview{id: req.id, failed: true}
+			}
+			result.Previews[req.attachment.ID] = preview
+		}
+		return result
+	}
+}
+
+// loadedPreviews returns the previews that can be drawn, by preview ID
+func (c *ChatView) loadedPreviews() map[int]*attachmentPreview {
+	loaded := make(map[int]*attachmentPreview, len(c.previews))
+	for _, preview := range c.previews {
+		if preview.image != "" {
+			loaded[preview.id] = preview
+		}
+	}
+	return loaded
+}
+
+// renderQuote renders a one-line preview of the message being replied to
+func (c *ChatView) renderQuote(parentID string) string {
+	style := lipgloss.NewStyle().
+		Foreground(c.theme.Secondary).
+		Italic(true)
+	
+	parent, known := c.findReplyParent(parentID)
+	switch {
+	case !known:
+		return style.Render("↳ …")
+	case parent == nil:
+		return style.Render("↳ Original message was deleted")
+	}
+	
+	sender := parent.From
+	if parent.IsFromUser(c.config.User.ID) {
+		sender = "You"
+	}
+	return style.Render(fmt.Sprintf("↳ %s: %s", sender, truncateString(stripPreviewMarkers(parent.Content), c.config.UI.PreviewLength)))
+}
+
+// renderForwardedFrom renders the attribution line of a forwarded message
+func (c *ChatView) renderForwardedFrom(author string) string {
+	if author == c.config.User.ID {
+		author = "You"
+	}
+	return lipgloss.NewStyle().
+		Foreground(c.theme.Secondary).
+		Italic(true).
+		Render("↪ Forwarded from " + stripPreviewMarkers(author))
+}
+
+// findReplyParent looks for a reply's parent in the loaded messages and then
+// in the fetched parents. known is false while the parent has not been fetched.
+func (c *ChatView) findReplyParent(parentID string) (parent *models.Message, known bool) {
+	for i := range c.messages {
+		if c.messages[i].ID == parentID {
+			return &c.messages[i], true
+		}
+	}
+	
+	parent, known = c.replyParents[parentID]
+	return parent, known
+}
+
+// fetchReplyParents returns a command fetching the parents of loaded replies
+// that are outside the loaded window, or nil if there are none
+func (c *ChatView) fetchReplyParents() tea.Cmd {
+	if c.messageLookup == nil {
+		return nil
+	}
+	
+	var missing []string
+	for _, msg := range c.messages {
+		if msg.Metadata == nil || msg.Metadata.ReplyTo == "" {
+			continue
+		}
+		id := msg.Metadata.ReplyTo
+		if _, known := c.findReplyParent(id); known || c.replyPending[id] {
+			continue
+		}
+		c.replyPending[id] = true
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	
+	lookup := c.messageLookup
+	chatID := c.currentChat
+	
+	return func() tea.Msg {
+		result := ReplyParentsLoadedMsg{
+			ChatID:  chatID,
+			Parents: make(map[string]*models.Message, len(missing)),
+		}
+		for _, id := range missing {
+			parent, err := lookup(chatID, id)
+			if err != nil {
+				result.Failed = append(result.Failed, id)
+				continue
+			}
+			result.Parents[id] = parent
+		}
+		return result
+	}
+}
+
+// renderStatus renders the delivery status indicator for an outgoing message
+func (c *ChatView) renderStatus(status models.MessageStatus) string {
+	style := lipgloss.NewStyle().Foreground(c.theme.Secondary)
+	
+	switch status {
+	case models.MessageStatusSent:
+		return style.Render("✓")
+	case models.MessageStatusQueued:
+		return lipgloss.NewStyle().Foreground(c.theme.Warning).Render("⧗ queued")
+	case models.MessageStatusDelivered:
+		return style.Render("✓✓")
+	case models.MessageStatusRead:
+		return lipgloss.NewStyle().Foreground(c.theme.Success).Render("✓✓")
+	case models.MessageStatusFailed:
+		return lipgloss.NewStyle().Foreground(c.theme.Error).Render("✗ failed")
+	default:
+		return style.Render("…")
+	}
+}
+
+// loadHistory returns a command fetching the page of history before the
+// given message, or nil if there is nothing to fetch
+func (c *ChatView) loadHistory(before *models.Message) tea.Cmd {
+	if c.historyLoader == nil || c.currentChat == "" || c.historyLoading || c.historyExhausted {
+		return nil
+	}
+	c.historyLoading = true
+	
+	loader := c.historyLoader
+	chatID := c.currentChat
+	limit := c.config.UI.HistoryPageSize
+	if before != nil {
+		// Copy so the command does not race with later updates to the slice
+		anchor := *before
+		before = &anchor
+	}
+	
+	return func() tea.Msg {
+		messages, err := loader(chatID, before, limit)
+		return HistoryLoadedMsg{
+			ChatID:   chatID,
+			Before:   before,
+			Messages: messages,
+			Err:      err,
+		}
+	}
+}
+
+// applyHistory adds a loaded page of history. Older pages are prepended
+// without moving the messages currently on screen.
+func (c *ChatView) applyHistory(msg HistoryLoadedMsg) {
+	if msg.ChatID != c.currentChat {
+		return
+	}
+	c.historyLoading = false
+	if msg.Err != nil {
+		return
+	}
+	if len(msg.Messages) < c.config.UI.HistoryPageSize {
+		c.historyExhausted = true
+	}
+	
+	page := make([]models.Message, 0, len(msg.Messages)+len(c.messages))
+	for _, m := range msg.Messages {
+		if !m.IsExpired() {
+			page = append(page, *m)
+		}
+	}
+	
+	if msg.Before == nil {
+		c.messages = page
+		c.scrollToBottom()
+		return
+	}
+	
+	c.messages = append(page, c.messages...)
+	c.scrollOffset += len(page)
+}
+
+// addMessage inserts a message at its place in the conversation order
+func (c *ChatView) addMessage(msg models.Message) {
+	if msg.IsExpired() {
+		return
+	}
+	
+	i := sort.Search(len(c.messages), func(i int) bool {
+		return msg.Before(&c.messages[i])
+	})
+	c.messages = append(c.messages, models.Message{})
+	copy(c.messages[i+1:], c.messages[i:])
+	c.messages[i] = msg
+	c.rendered.invalidate(msg.ID)
+}
+
+// updateMessage replaces the shown copy of a message, or adds the message if
+// it is not shown yet, reporting whether it was added. A stale update never
+// moves the status backwards.
+func (c *ChatView) updateMessage(msg models.Message) bool {
+	for i := range c.messages {
+		if c.messages[i].ID != msg.ID {
+			continue
+		}
+		shown := c.messages[i]
+		if !shown.UpdateStatus(msg.Status) {
+			msg.Status = shown.Status
+		}
+		if msg.Reactions() == nil && shown.Reactions() != nil {
+			// Reactions arrive apart from the message, so an update
+			// without them keeps the ones shown
+			msg.Metadata = withReactions(msg.Metadata, shown.Reactions())
+		}
+		
+		if msg.Sequence == shown.Sequence && msg.DisplayTime().Equal(shown.DisplayTime()) {
+			// The message keeps its place, so it is updated in place and
+			// its rendering is reused unless its content changed
+			c.messages[i] = msg
+			if msg.Content != shown.Content || msg.IsEdited() != shown.IsEdited() {
+				c.rendered.invalidate(msg.ID)
+			}
+			return false
+		}
+		
+		// The sequence number changed, so the message is placed again
+		c.messages = append(c.messages[:i], c.messages[i+1:]...)
+		c.addMessage(msg)
+		return false
+	}
+	
+	// Updates to messages in history that is not loaded are picked up when
+	// it is paged in
+	if len(c.messages) > 0 && !c.historyExhausted && msg.Before(&c.messages[0]) {
+		return false
+	}
+	c.addMessage(msg)
+	return true
+}
+
+// withReactions returns a copy of metadata with the given reactions
+func withReactions(metadata *models.Metadata, reactions []models.Reaction) *models.Metadata {
+	copied := models.Metadata{}
+	if metadata != nil {
+		copied = *metadata
+	}
+	copied.Reactions = reactions
+	return &copied
+}
+
+// applyReaction adds or removes a reaction to a shown message. Reactions
+// to messages that are not loaded are ignored.
+func (c *ChatView) applyReaction(reaction MessageReactionMsg) {
+	for i := range c.messages {
+		if c.messages[i].ID == reaction.MessageID {
+			c.messages[i].SetReaction(reaction.From, reaction.Emoji, !reaction.Removed)
+			return
+		}
+	}
+}
+
+// markFailed marks a shown message that could not be sent
+func (c *ChatView) markFailed(messageID string) {
+	for i := range c.messages {
+		if c.messages[i].ID == messageID {
+			c.messages[i].UpdateStatus(models.MessageStatusFailed)
+			return
+		}
+	}
+}
+
+// inCurrentChat reports whether a message belongs to the open chat
+func (c *ChatView) inCurrentChat(msg *models.Message) bool {
+	if c.currentChat == "" {
+		return false
+	}
+	self := c.config.User.ID
+	return (msg.From == self && msg.To == c.currentChat) ||
+		(msg.From == c.currentChat && msg.To == self)
+}
+
+// nextSequence returns the sequence number following the latest message
+func (c *ChatView) nextSequence() uint64 {
+	if len(c.messages) == 0 {
+		return 1
+	}
+	return c.messages[len(c.messages)-1].Sequence + 1
 }
 
 // getVisibleMessages returns messages that should be visible in the current scroll position
@@ -268,11 +1572,7 @@ func (c *ChatView) getVisibleMessages() []models.Message {
 		return []models.Message{}
 	}
 	
-	messageHeight := c.getMessageAreaHeight()
-	
-	// Calculate how many messages can fit
-	// Each message takes approximately 2-3 lines
-	maxMessages := messageHeight / 3
+	maxMessages := c.maxVisibleMessages()
 	
 	start := c.scrollOffset
 	end := start + maxMessages
@@ -294,14 +1594,55 @@ func (c *ChatView) getMessageAreaHeight() int {
 	return c.height - 1 - 3 - 2
 }
 
+// maxVisibleMessages returns how many messages fit in the message area.
+// Each message takes approximately 2-3 lines, and a bubble two more for
+// its border.
+func (c *ChatView) maxVisibleMessages() int {
+	linesPerMessage := 3
+	if c.config.UI.MessageLayout == config.LayoutBubbles {
+		linesPerMessage += bubbleBorderLines
+	}
+	return c.getMessageAreaHeight() / linesPerMessage
+}
+
 // scrollToBottom scrolls to show the latest messages
 func (c *ChatView) scrollToBottom() {
-	messageHeight := c.getMessageAreaHeight()
-	maxMessages := messageHeight / 3
+	maxMessages := c.maxVisibleMessages()
 	
 	if len(c.messages) > maxMessages {
 		c.scrollOffset = len(c.messages) - maxMessages
 	} else {
 		c.scrollOffset = 0
 	}
+	c.unseen = 0
+}
+
+// atBottom reports whether the latest message is in view
+func (c *ChatView) atBottom() bool {
+	return c.scrollOffset >= len(c.messages)-c.maxVisibleMessages()
+}
+
+// belowView reports whether a shown message is below the visible ones
+func (c *ChatView) belowView(messageID string) bool {
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].ID == messageID {
+			return i >= c.scrollOffset+c.maxVisibleMessages()
+		}
+	}
+	return false
+}
+
+// renderUnseen renders the count of new messages below the view, with the
+// key that jumps down to them
+func (c *ChatView) renderUnseen() string {
+	text := fmt.Sprintf("↓ %d new messages (%s)", c.unseen, c.keys.Help(ActionBottom))
+	if c.unseen == 1 {
+		text = fmt.Sprintf("↓ 1 new message (%s)", c.keys.Help(ActionBottom))
+	}
+	return lipgloss.NewStyle().
+		Foreground(c.theme.Primary).
+		Bold(true).
+		Width(c.width - 4).
+		Align(lipgloss.Center).
+		Render(text)
 }
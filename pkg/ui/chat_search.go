@@ -0,0 +1,330 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// maxSearchWord is the longest word a search matches on; longer ones are
+// cut short, as the search index does
+const maxSearchWord = 64
+
+// MessageSearcher returns the messages of a chat that contain every word of
+// query, oldest first. Chats are named by the other user's ID.
+type MessageSearcher func(chatID, query string) ([]*models.Message, error)
+
+// SearchResultsMsg delivers the matches of a search in the chat view
+type SearchResultsMsg struct {
+	ChatID  string
+	Query   string
+	Matches []*models.Message
+	Err     error
+}
+
+// chatSearch is the state of a search in the open chat. Matches are found
+// in the whole history up front, so they can be counted and stepped through
+// although most are not loaded.
+type chatSearch struct {
+	query     string
+	editing   bool // Keys edit the query rather than step through matches
+	searching bool // Waiting for the matches of query
+
+	terms     map[string]bool // Words the matches were found for
+	highlight string          // The terms sorted and joined, keying rendered matches
+	matches   []string        // IDs of the matching messages, oldest first
+	isMatch   map[string]bool // The same IDs, for lookups while rendering
+	index     int             // Match shown, counted from the oldest
+	pending   string          // Match shown once history is paged in up to it
+}
+
+// SetMessageSearcher sets the function the chat view searches messages with
+func (c *ChatView) SetMessageSearcher(searcher MessageSearcher) {
+	c.searcher = searcher
+}
+
+// startSearch opens the search prompt, or returns to editing the query of
+// the search that is open
+func (c *ChatView) startSearch() {
+	if c.searcher == nil || c.currentChat == "" {
+		return
+	}
+	if c.search == nil {
+		c.search = &chatSearch{}
+	}
+	c.search.editing = true
+}
+
+// closeSearch leaves the search, keeping the active match selected
+func (c *ChatView) closeSearch() {
+	c.search = nil
+}
+
+// updateSearch handles keys while the search is open. While the query is
+// typed, enter searches for it; then the scroll keys and enter step
+// through the matches, wrapping around at either end.
+func (c *ChatView) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	search := c.search
+	switch {
+	case c.keys.Matches(msg, ActionBack):
+		c.closeSearch()
+
+	case search.editing && c.keys.Matches(msg, ActionSend):
+		return c.runSearch()
+
+	case search.editing && c.keys.Matches(msg, ActionDeleteBackward):
+		_, size := utf8.DecodeLastRuneInString(search.query)
+		search.query = search.query[:len(search.query)-size]
+
+	case search.editing:
+		if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace {
+			search.query += msg.String()
+		}
+
+	case c.keys.Matches(msg, ActionSearchMessages):
+		search.editing = true
+
+	case c.keys.Matches(msg, ActionScrollUp), c.keys.Matches(msg, ActionSend):
+		return c.stepMatch(-1)
+
+	case c.keys.Matches(msg, ActionScrollDown):
+		return c.stepMatch(1)
+
+	case c.keys.Matches(msg, ActionForward):
+		c.startForward()
+	}
+	return nil
+}
+
+// runSearch looks up the matches of the typed query in the whole chat
+func (c *ChatView) runSearch() tea.Cmd {
+	search := c.search
+	if len(searchWords(search.query)) == 0 {
+		return nil
+	}
+	search.editing = false
+	search.searching = true
+
+	searcher := c.searcher
+	chatID, query := c.currentChat, search.query
+	return func() tea.Msg {
+		matches, err := searcher(chatID, query)
+		return SearchResultsMsg{ChatID: chatID, Query: query, Matches: matches, Err: err}
+	}
+}
+
+// applySearchResults takes in the matches of a search and shows the most
+// recent one
+func (c *ChatView) applySearchResults(msg SearchResultsMsg) tea.Cmd {
+	search := c.search
+	if search == nil || msg.ChatID != c.currentChat || msg.Query != search.query {
+		return nil
+	}
+	search.searching = false
+	if msg.Err != nil {
+		c.notice = fmt.Sprintf("Search failed: %v", msg.Err)
+		return nil
+	}
+
+	search.terms = make(map[string]bool)
+	for _, word := range searchWords(msg.Query) {
+		search.terms[word] = true
+	}
+	words := make([]string, 0, len(search.terms))
+	for word := range search.terms {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	search.highlight = strings.Join(words, "\x00")
+
+	search.matches = make([]string, len(msg.Matches))
+	search.isMatch = make(map[string]bool, len(msg.Matches))
+	for i, match := range msg.Matches {
+		search.matches[i] = match.ID
+		search.isMatch[match.ID] = true
+	}
+	if len(search.matches) == 0 {
+		return nil
+	}
+	search.index = len(search.matches) - 1
+	return c.showMatch()
+}
+
+// stepMatch moves to the match delta away, older for negative delta
+func (c *ChatView) stepMatch(delta int) tea.Cmd {
+	search := c.search
+	n := len(search.matches)
+	if n == 0 {
+		return nil
+	}
+
+	index := search.index + delta
+	switch {
+	case index < 0:
+		c.notice = "Search wrapped around to the most recent match"
+	case index >= n:
+		c.notice = "Search wrapped around to the oldest match"
+	}
+	search.index = (index%n + n) % n
+	return c.showMatch()
+}
+
+// showMatch selects the active match and scrolls it into view. A match
+// that is not loaded yet is paged in first; history loads one page at a
+// time until it is.
+func (c *ChatView) showMatch() tea.Cmd {
+	search := c.search
+	id := search.matches[search.index]
+	for i := range c.messages {
+		if c.messages[i].ID == id {
+			search.pending = ""
+			c.selectedID = id
+			c.scrollIntoView(i)
+			return nil
+		}
+	}
+
+	if c.historyExhausted || len(c.messages) == 0 {
+		search.pending = ""
+		c.notice = "This match is no longer in the chat history"
+		return nil
+	}
+	search.pending = id
+	return c.loadHistory(&c.messages[0])
+}
+
+// continueSearch shows a match being paged in once another page of
+// history has loaded
+func (c *ChatView) continueSearch() tea.Cmd {
+	if c.search == nil || c.search.pending == "" {
+		return nil
+	}
+	return c.showMatch()
+}
+
+// searchHighlight returns the words highlighted in a message, sorted and
+// joined so the key is the same on every render, or "" if it is not a match
+// of the open search
+func (c *ChatView) searchHighlight(messageID string) string {
+	if c.search == nil || len(c.search.terms) == 0 || !c.search.isMatch[messageID] {
+		return ""
+	}
+	return c.search.highlight
+}
+
+// renderSearch renders the search prompt in place of the typed message,
+// with the position of the active match among all of them
+func (c *ChatView) renderSearch() (string, string) {
+	search := c.search
+	prompt := "Search: " + search.query
+	if search.editing {
+		prompt += "│"
+	}
+
+	counter := ""
+	switch {
+	case search.searching:
+		counter = "searching…"
+	case search.terms == nil:
+	case len(search.matches) == 0:
+		counter = "no matches"
+	default:
+		counter = fmt.Sprintf("%d/%d", search.index+1, len(search.matches))
+	}
+	if counter != "" {
+		prompt += "  " + lipgloss.NewStyle().
+			Foreground(c.theme.Highlight).
+			Bold(true).
+			Render(counter)
+	}
+
+	help := fmt.Sprintf("(%s to search, %s to close)",
+		c.keys.Help(ActionSend), c.keys.Help(ActionBack))
+	if !search.editing {
+		help = fmt.Sprintf("(%s/%s older match, %s newer, %s to edit, %s to close)",
+			c.keys.Help(ActionScrollUp), c.keys.Help(ActionSend), c.keys.Help(ActionScrollDown),
+			c.keys.Help(ActionSearchMessages), c.keys.Help(ActionBack))
+	}
+	return prompt, lipgloss.NewStyle().
+		Foreground(c.theme.Secondary).
+		Render(help)
+}
+
+// highlightWords styles text with style, and the whole words of it that are
+// among terms with highlight. Words are compared as the search index
+// compares them, ignoring case.
+func highlightWords(text string, terms map[string]bool, style, highlight lipgloss.Style) string {
+	var b strings.Builder
+	plain := func(s string) {
+		if s != "" {
+			b.WriteString(style.Render(s))
+		}
+	}
+
+	for n, line := range strings.Split(text, "\n") {
+		if n > 0 {
+			b.WriteString("\n")
+		}
+		last := 0
+		for i := 0; i < len(line); {
+			r, size := utf8.DecodeRuneInString(line[i:])
+			if !isWordRune(r) {
+				i += size
+				continue
+			}
+			end := i
+			for end < len(line) {
+				r, size := utf8.DecodeRuneInString(line[end:])
+				if !isWordRune(r) {
+					break
+				}
+				end += size
+			}
+			if terms[searchWord(line[i:end])] {
+				plain(line[last:i])
+				b.WriteString(highlight.Render(line[i:end]))
+				last = end
+			}
+			i = end
+		}
+		plain(line[last:])
+	}
+	return b.String()
+}
+
+// searchWords splits a query into the words a search matches on
+func searchWords(query string) []string {
+	fields := strings.FieldsFunc(query, func(r rune) bool {
+		return !isWordRune(r)
+	})
+	words := make([]string, len(fields))
+	for i, field := range fields {
+		words[i] = searchWord(field)
+	}
+	return words
+}
+
+// searchWord normalizes a word as the search index does, cutting it short
+// on a character boundary
+func searchWord(word string) string {
+	word = strings.ToLower(word)
+	if len(word) > maxSearchWord {
+		cut := maxSearchWord
+		for cut > 0 && !utf8.RuneStart(word[cut]) {
+			cut--
+		}
+		word = word[:cut]
+	}
+	return word
+}
+
+// isWordRune reports whether r is part of a word a search matches on
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
@@ -0,0 +1,72 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestSearchWordCutsOnCharacterBoundary(t *testing.T) {
+	tests := []struct {
+		name string
+		word string
+		want string
+	}{
+		{"short", "Hello", "hello"},
+		{"ascii", strings.Repeat("a", maxSearchWord+10), strings.Repeat("a", maxSearchWord)},
+		// Two-byte runes after one ASCII byte end mid-rune at an even cut
+		{"multibyte", "x" + strings.Repeat("é", maxSearchWord), "x" + strings.Repeat("é", (maxSearchWord-1)/2)},
+		{"four byte", strings.Repeat("😀", maxSearchWord), strings.Repeat("😀", maxSearchWord/4)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchWord(tt.word)
+			if !utf8.ValidString(got) {
+				t.Fatalf("searchWord(%q) = %q, not valid UTF-8", tt.word, got)
+			}
+			if got != tt.want {
+				t.Errorf("searchWord(%q) = %q, want %q", tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+// searchedView returns a chat view showing the results of query, matching
+// the messages with the given IDs
+func searchedView(query string, ids ...string) *ChatView {
+	c := &ChatView{
+		search:           &chatSearch{query: query},
+		historyExhausted: true,
+	}
+	matches := make([]*models.Message, len(ids))
+	for i, id := range ids {
+		matches[i] = &models.Message{ID: id}
+	}
+	c.applySearchResults(SearchResultsMsg{Query: query, Matches: matches})
+	return c
+}
+
+func TestSearchHighlight(t *testing.T) {
+	c := searchedView("zebra apple mango Apple", "m1", "m3")
+
+	want := "apple\x00mango\x00zebra"
+	for i := 0; i < 10; i++ {
+		if got := c.searchHighlight("m1"); got != want {
+			t.Fatalf("searchHighlight(m1) = %q, want %q", got, want)
+		}
+	}
+	if got := c.searchHighlight("m3"); got != want {
+		t.Errorf("searchHighlight(m3) = %q, want %q", got, want)
+	}
+	if got := c.searchHighlight("m2"); got != "" {
+		t.Errorf("searchHighlight(m2) = %q for a message that does not match", got)
+	}
+
+	other := searchedView("mango zebra apple", "m1")
+	if got := other.searchHighlight("m1"); got != want {
+		t.Errorf("searchHighlight with the words reordered = %q, want %q", got, want)
+	}
+}
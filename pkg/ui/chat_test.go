@@ -0,0 +1,648 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// historyChat returns a chat view of a chat with bob holding count stored
+// messages, m1 the oldest, paged in pageSize at a time. Every page the view
+// asks for is recorded in loads by the message it was asked before.
+func historyChat(t *testing.T, count, pageSize int, loads *[]string) *ChatView {
+	t.Helper()
+
+	stored := make([]*models.Message, count)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range stored {
+		stored[i] = &models.Message{
+			ID:        fmt.Sprintf("m%d", i+1),
+			Type:      models.MessageTypeChat,
+			From:      "bob",
+			To:        "alice",
+			ChatID:    "alice:bob",
+			Content:   fmt.Sprintf("message %d", i+1),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Sequence:  uint64(i + 1),
+		}
+	}
+
+	cfg := config.Default()
+	cfg.UI.HistoryPageSize = pageSize
+	c := NewChatView(cfg, getTheme("dark"), DefaultKeyMap())
+	c.SetHistoryLoader(func(chatID string, before *models.Message, limit int) ([]*models.Message, error) {
+		end := len(stored)
+		if before != nil {
+			*loads = append(*loads, before.ID)
+			end = int(before.Sequence) - 1
+		}
+		return stored[max(end-limit, 0):end], nil
+	})
+	// Room for three messages
+	c.Update(tea.WindowSizeMsg{Width: 80, Height: 17})
+	return c
+}
+
+// runCmd runs cmd and passes its message back to the view
+func runCmd(c *ChatView, cmd tea.Cmd) {
+	if cmd != nil {
+		c.Update(cmd())
+	}
+}
+
+// visibleIDs returns the IDs of the messages in view
+func visibleIDs(c *ChatView) string {
+	var ids []string
+	for _, msg := range c.getVisibleMessages() {
+		ids = append(ids, msg.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestScrollingUpLoadsOlderHistory(t *testing.T) {
+	var loads []string
+	c := historyChat(t, 10, 4, &loads)
+	runCmd(c, c.OpenChat("bob"))
+	if got, want := visibleIDs(c), "m8,m9,m10"; got != want {
+		t.Fatalf("visible after opening = %s, want %s", got, want)
+	}
+
+	up := tea.KeyMsg{Type: tea.KeyUp}
+	if _, cmd := c.Update(up); cmd != nil {
+		t.Fatal("scrolling within the loaded messages fetched history")
+	}
+	_, cmd := c.Update(up)
+	if cmd == nil {
+		t.Fatal("scrolling up at the top did not fetch history")
+	}
+	if _, again := c.Update(up); again != nil {
+		t.Error("a second fetch started while the first was loading")
+	}
+	shown := visibleIDs(c)
+	runCmd(c, cmd)
+	if len(loads) != 1 || loads[0] != "m7" {
+		t.Fatalf("history loaded before %v, want m7", loads)
+	}
+	if got := visibleIDs(c); got != shown {
+		t.Errorf("visible after loading = %s, want %s kept in view", got, shown)
+	}
+	if got := len(c.messages); got != 8 {
+		t.Errorf("%d messages loaded, want 8", got)
+	}
+
+	// The last page is short, and nothing is fetched after it
+	for i := 0; i < 8; i++ {
+		_, cmd = c.Update(up)
+		runCmd(c, cmd)
+	}
+	if got, want := strings.Join(loads, ","), "m7,m3"; got != want {
+		t.Errorf("history loaded before %s, want %s", got, want)
+	}
+	if got, want := visibleIDs(c), "m1,m2,m3"; got != want {
+		t.Errorf("visible at the top = %s, want %s", got, want)
+	}
+	if _, cmd := c.Update(up); cmd != nil {
+		t.Error("history was fetched again after the first message was loaded")
+	}
+}
+
+func TestExpiredHistoryKeepsTheView(t *testing.T) {
+	var loads []string
+	c := historyChat(t, 10, 4, &loads)
+	runCmd(c, c.OpenChat("bob"))
+	c.scrollOffset = 0
+	shown := visibleIDs(c)
+
+	msg := c.loadHistory(&c.messages[0])().(HistoryLoadedMsg)
+	expired := *msg.Messages[1]
+	expired.Metadata = &models.Metadata{ExpiresAt: time.Now().Add(-time.Minute)}
+	msg.Messages[1] = &expired
+	c.Update(msg)
+
+	if got := visibleIDs(c); got != shown {
+		t.Errorf("visible after loading a page with an expired message = %s, want %s", got, shown)
+	}
+}
+
+func TestHeaderShowsVerification(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		contact models.Contact
+		want    string
+		notWant string
+	}{
+		{"verified", models.Contact{UserID: "bob", Fingerprint: fingerprint, Verified: true}, "✓ verified", "⚠"},
+		{"unverified", models.Contact{UserID: "bob", Fingerprint: fingerprint}, "unverified ⚠", "✓"},
+		{"key changed", models.Contact{UserID: "bob", Fingerprint: fingerprint, Verified: true, KeyChanged: true}, "key changed ⚠", "✓ verified"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+			c.SetContactLookup(func(userID string) (*models.Contact, bool) { return &tt.contact, true })
+			c.Update(tea.WindowSizeMsg{Width: 200, Height: 20})
+			c.OpenChat("bob")
+
+			header := c.renderHeader()
+			if !strings.Contains(header, tt.want) {
+				t.Errorf("header %q does not show %q", header, tt.want)
+			}
+			if strings.Contains(header, tt.notWant) {
+				t.Errorf("header %q shows %q", header, tt.notWant)
+			}
+			if want := formatFingerprint(fingerprint, c.config.UI); !strings.Contains(header, want) {
+				t.Errorf("header %q does not show the fingerprint %q", header, want)
+			}
+		})
+	}
+}
+
+func TestHeaderFollowsKeyChange(t *testing.T) {
+	contact := models.Contact{UserID: "bob", Fingerprint: strings.Repeat("ab", 32), Verified: true}
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.SetContactLookup(func(userID string) (*models.Contact, bool) { return &contact, true })
+	c.Update(tea.WindowSizeMsg{Width: 200, Height: 20})
+	c.OpenChat("bob")
+
+	changed := contact
+	changed.Fingerprint = strings.Repeat("cd", 32)
+	changed.KeyChanged = true
+	c.Update(ContactUpdatedMsg{Contact: changed})
+
+	header := c.renderHeader()
+	if !strings.Contains(header, "key changed ⚠") {
+		t.Errorf("header %q does not show the key change", header)
+	}
+	if want := formatFingerprint(changed.Fingerprint, c.config.UI); !strings.Contains(header, want) {
+		t.Errorf("header %q does not show the new fingerprint %q", header, want)
+	}
+}
+
+func TestReplyToUnloadedParentShowsQuote(t *testing.T) {
+	parents := map[string]*models.Message{
+		"old":  {ID: "old", From: "bob", ChatID: "alice:bob", Content: "the original"},
+		"gone": nil,
+	}
+	var lookups []string
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.SetMessageLookup(func(chatID, messageID string) (*models.Message, error) {
+		lookups = append(lookups, messageID)
+		return parents[messageID], nil
+	})
+	c.Update(tea.WindowSizeMsg{Width: 80, Height: 40})
+	c.OpenChat("bob")
+
+	reply := func(id, parentID string) *models.Message {
+		return &models.Message{ID: id, From: "alice", ChatID: "alice:bob", Content: "a reply", Metadata: &models.Metadata{ReplyTo: parentID}}
+	}
+	c.applyHistory(HistoryLoadedMsg{ChatID: "bob", Messages: []*models.Message{reply("r1", "old"), reply("r2", "gone"), reply("r3", "old")}})
+
+	if got := c.renderQuote("old"); !strings.Contains(got, "…") {
+		t.Errorf("quote before the parent is fetched = %q, want a placeholder", got)
+	}
+	runCmd(c, c.fetchReplyParents())
+	if got := c.renderQuote("old"); !strings.Contains(got, "bob: the original") {
+		t.Errorf("quote = %q, want the unloaded parent's content", got)
+	}
+	if got := c.renderQuote("gone"); !strings.Contains(got, "deleted") {
+		t.Errorf("quote of a deleted parent = %q, want it shown as deleted", got)
+	}
+
+	// Fetched parents are cached
+	if cmd := c.fetchReplyParents(); cmd != nil {
+		t.Error("fetchReplyParents fetched cached parents again")
+	}
+	if got := strings.Join(lookups, ","); got != "old,gone" {
+		t.Errorf("looked up %s, want old,gone once each", got)
+	}
+}
+
+func TestKeyChangeAsksBeforeSending(t *testing.T) {
+	contact := models.Contact{UserID: "bob", Verified: true, KeyChanged: true}
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.SetContactLookup(func(userID string) (*models.Contact, bool) { return &contact, true })
+	var overrides []bool
+	c.SetMessageSender(func(msg *models.Message, overrideKeyChange bool) error {
+		overrides = append(overrides, overrideKeyChange)
+		return nil
+	})
+	c.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+	c.OpenChat("bob")
+
+	typeAndSend := func(text string) tea.Cmd {
+		for _, r := range text {
+			c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+		_, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		return cmd
+	}
+	yes := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")}
+
+	// Declining keeps the message as typed
+	if cmd := typeAndSend("hello"); cmd != nil || !c.confirmSend {
+		t.Fatal("sending to a changed key did not ask first")
+	}
+	if !strings.Contains(c.View(), "Identity changed — send anyway?") {
+		t.Error("the prompt is not shown")
+	}
+	c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	if c.confirmSend || c.input != "hello" || len(overrides) != 0 {
+		t.Fatalf("after declining: prompt %v, input %q, sent %v", c.confirmSend, c.input, overrides)
+	}
+
+	// Confirming sends this message once with the override
+	c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if !c.confirmSend {
+		t.Fatal("no prompt for the second attempt")
+	}
+	_, cmd := c.Update(yes)
+	runCmd(c, cmd)
+	if len(overrides) != 1 || !overrides[0] {
+		t.Fatalf("sent with overrides %v, want one overridden send", overrides)
+	}
+
+	// The warning stays, so the next message asks again
+	if typeAndSend("again"); !c.confirmSend {
+		t.Error("the next message was sent without asking")
+	}
+
+	// A verified contact is not asked about
+	c.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	c.Update(ContactUpdatedMsg{Contact: models.Contact{UserID: "bob", Verified: true}})
+	runCmd(c, typeAndSend(""))
+	if c.confirmSend || len(overrides) != 2 || overrides[1] {
+		t.Errorf("after verifying: prompt %v, sent with overrides %v", c.confirmSend, overrides)
+	}
+}
+
+func TestPendingKeyAsksToAccept(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+	tests := []struct {
+		name     string
+		contact  models.Contact
+		key      string
+		accepted bool
+		want     string
+	}{
+		{"first key accepted", models.Contact{UserID: "bob", PendingFingerprint: fingerprint}, "y", true, "New identity key"},
+		{"changed key rejected", models.Contact{UserID: "bob", Fingerprint: strings.Repeat("cd", 32), PendingFingerprint: fingerprint}, "n", false, "Identity key changed from"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+			c.SetContactLookup(func(userID string) (*models.Contact, bool) { return &tt.contact, true })
+			var decisions []bool
+			c.SetKeyDecider(func(userID string, accept bool) error {
+				decisions = append(decisions, accept)
+				return nil
+			})
+			c.Update(tea.WindowSizeMsg{Width: 200, Height: 20})
+			c.OpenChat("bob")
+
+			if view := c.View(); !strings.Contains(view, tt.want) {
+				t.Errorf("view does not ask %q", tt.want)
+			}
+			if _, cmd := c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("h")}); cmd != nil || c.input != "" {
+				t.Error("typing went ahead before the key was decided")
+			}
+			_, cmd := c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(tt.key)})
+			runCmd(c, cmd)
+			if len(decisions) != 1 || decisions[0] != tt.accepted {
+				t.Errorf("decided %v, want %v", decisions, tt.accepted)
+			}
+		})
+	}
+}
+
+func TestLocalEchoIsReconciled(t *testing.T) {
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	var sent []*models.Message
+	var sendErr error
+	c.SetMessageSender(func(msg *models.Message, overrideKeyChange bool) error {
+		sent = append(sent, msg)
+		return sendErr
+	})
+	c.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+	c.OpenChat("bob")
+
+	send := func(text string) *models.Message {
+		t.Helper()
+
+		for _, r := range text {
+			c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+		_, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		if cmd == nil {
+			t.Fatal("sending did not start")
+		}
+		runCmd(c, cmd)
+		return sent[len(sent)-1]
+	}
+	shown := func(id string) []models.Message {
+		var copies []models.Message
+		for _, msg := range c.messages {
+			if msg.ID == id {
+				copies = append(copies, msg)
+			}
+		}
+		return copies
+	}
+
+	msg := send("hello")
+	echo := shown(msg.ID)
+	if len(echo) != 1 || echo[0].Status != models.MessageStatusPending {
+		t.Fatalf("echo of the sent message = %+v, want one pending copy with its ID", echo)
+	}
+
+	// The stored copy moves through its statuses, and a late update does
+	// not move it back
+	for _, status := range []models.MessageStatus{models.MessageStatusSent, models.MessageStatusDelivered, models.MessageStatusSent} {
+		update := *msg
+		update.Status = status
+		c.Update(MessageUpdatedMsg{Message: update})
+	}
+	if copies := shown(msg.ID); len(copies) != 1 || copies[0].Status != models.MessageStatusDelivered {
+		t.Errorf("after the status updates = %+v, want one delivered copy", copies)
+	}
+	if len(c.messages) != 1 {
+		t.Errorf("%d messages shown, want 1", len(c.messages))
+	}
+
+	// A send that fails is shown as failed
+	sendErr = errors.New("not connected")
+	failed := send("lost")
+	if copies := shown(failed.ID); len(copies) != 1 || copies[0].Status != models.MessageStatusFailed {
+		t.Errorf("failed send = %+v, want one failed copy", copies)
+	}
+	if view := c.View(); !strings.Contains(view, "✗ failed") || !strings.Contains(view, "not connected") {
+		t.Error("the failure is not shown")
+	}
+}
+
+func TestSkewedMessageShowsArrivalTime(t *testing.T) {
+	c := renderedChat(100, 2)
+	c.location = time.UTC
+	skewed := c.messages[1]
+	skewed.Timestamp = skewed.Timestamp.Add(3 * time.Hour)
+	skewed.ReceivedAt = skewed.Timestamp.Add(-3*time.Hour + time.Minute)
+	skewed.ClockSkewed = true
+	c.Update(MessageUpdatedMsg{Message: skewed})
+
+	view := c.View()
+	if strings.Count(view, "sender's clock is off") != 1 {
+		t.Errorf("the skewed message is not flagged once:\n%s", view)
+	}
+	if !strings.Contains(view, c.formatTime(skewed.ReceivedAt)) || strings.Contains(view, c.formatTime(skewed.Timestamp)) {
+		t.Errorf("the skewed message does not show when it arrived:\n%s", view)
+	}
+}
+
+func TestHeaderShowsEncryption(t *testing.T) {
+	states := map[string]models.EncryptionState{"bob": models.EncryptionActive}
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.SetContactLookup(func(userID string) (*models.Contact, bool) { return &models.Contact{UserID: userID}, true })
+	c.SetEncryptionLookup(func(userID string) models.EncryptionState { return states[userID] })
+	c.Update(tea.WindowSizeMsg{Width: 200, Height: 20})
+
+	c.OpenChat("carol")
+	if header := c.renderHeader(); !strings.Contains(header, "🔓 not encrypted") {
+		t.Errorf("header %q does not show carol's chat as not encrypted", header)
+	}
+	// A downgrade in another chat leaves this one alone
+	c.Update(EncryptionDowngradeMsg{UserID: "bob"})
+	if header := c.renderHeader(); strings.Contains(header, "PLAINTEXT") {
+		t.Errorf("header %q shows bob's downgrade in carol's chat", header)
+	}
+
+	c.OpenChat("bob")
+	if header := c.renderHeader(); !strings.Contains(header, "🔒 encrypted") {
+		t.Errorf("header %q does not show bob's chat as encrypted", header)
+	}
+	c.Update(EncryptionDowngradeMsg{UserID: "bob", Refused: true})
+	if header := c.renderHeader(); !strings.Contains(header, "🔓 PLAINTEXT IN ENCRYPTED CHAT ⚠") {
+		t.Errorf("header %q does not warn of the downgrade", header)
+	}
+	if !strings.Contains(c.View(), "Dropped a plaintext message") {
+		t.Error("the dropped message is not reported in the chat")
+	}
+
+	// A new key exchange ends the downgrade
+	c.Update(EncryptionStateMsg{UserID: "bob", State: models.EncryptionActive})
+	if header := c.renderHeader(); !strings.Contains(header, "🔒 encrypted") {
+		t.Errorf("header %q still shows the downgrade after a new session", header)
+	}
+}
+
+func TestHeaderShowsContactState(t *testing.T) {
+	contacts := map[string]*models.Contact{
+		"bob":   {UserID: "bob", Blocked: true, Muted: true, Status: models.UserStatusOnline},
+		"carol": {UserID: "carol", Muted: true, Archived: true, Status: models.UserStatusOnline},
+		"dave":  {UserID: "dave", Verified: true, KeyChanged: true},
+	}
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.SetContactLookup(func(userID string) (*models.Contact, bool) {
+		contact, ok := contacts[userID]
+		return contact, ok
+	})
+	c.Update(tea.WindowSizeMsg{Width: 200, Height: 20})
+
+	tests := []struct {
+		userID string
+		want   string
+		absent string
+	}{
+		{"bob", "⊘ Blocked", "Online"},
+		{"carol", "● Online · muted · archived", ""},
+		{"dave", "key changed ⚠", "✓ verified"},
+	}
+	for _, tt := range tests {
+		c.OpenChat(tt.userID)
+		header := c.renderHeader()
+		if !strings.Contains(header, tt.want) || (tt.absent != "" && strings.Contains(header, tt.absent)) {
+			t.Errorf("header for %s = %q, want %q without %q", tt.userID, header, tt.want, tt.absent)
+		}
+	}
+}
+
+// arriving returns the next message of c's chat, after all shown ones
+func arriving(c *ChatView, id string) MessageUpdatedMsg {
+	msg := c.messages[len(c.messages)-1]
+	msg.ID = id
+	msg.From = "bob"
+	msg.To = "alice"
+	msg.Content = "new " + id
+	msg.Timestamp = msg.Timestamp.Add(time.Second)
+	msg.Sequence++
+	return MessageUpdatedMsg{Message: msg}
+}
+
+func TestNewMessagesAreFollowedOnlyAtTheBottom(t *testing.T) {
+	c := renderedChat(100, 20)
+	c.scrollToBottom()
+	c.input, c.cursor = "half a thought", 4
+
+	// At the bottom, new messages scroll into view
+	c.Update(arriving(c, "n1"))
+	if got := visibleIDs(c); !strings.HasSuffix(got, ",n1") {
+		t.Fatalf("visible = %s, want the new message in view", got)
+	}
+
+	// Reading history, they are counted instead
+	c.Update(tea.KeyMsg{Type: tea.KeyUp})
+	c.Update(tea.KeyMsg{Type: tea.KeyUp})
+	reading := visibleIDs(c)
+	c.Update(arriving(c, "n2"))
+	c.Update(arriving(c, "n3"))
+	if got := visibleIDs(c); got != reading {
+		t.Errorf("visible = %s, want the history being read (%s) kept", got, reading)
+	}
+	if view := c.View(); !strings.Contains(view, "↓ 2 new messages (End)") {
+		t.Errorf("view does not offer the new messages:\n%s", view)
+	}
+	if c.input != "half a thought" || c.cursor != 4 {
+		t.Errorf("input = %q at %d, want the draft and cursor untouched", c.input, c.cursor)
+	}
+
+	// Jumping down shows them and clears the count
+	c.Update(tea.KeyMsg{Type: tea.KeyEnd})
+	if got := visibleIDs(c); !strings.HasSuffix(got, ",n2,n3") {
+		t.Errorf("visible after jumping down = %s, want the new messages", got)
+	}
+	if strings.Contains(c.View(), "new message") {
+		t.Error("the count is still shown at the bottom")
+	}
+
+	// Scrolling down to them by hand clears it too
+	c.Update(tea.KeyMsg{Type: tea.KeyUp})
+	c.Update(arriving(c, "n4"))
+	if !strings.Contains(c.View(), "↓ 1 new message (End)") {
+		t.Error("one new message below the view is not offered")
+	}
+	c.Update(tea.KeyMsg{Type: tea.KeyDown})
+	if !strings.Contains(c.View(), "↓ 1 new message") {
+		t.Error("the count went before the new message was in view")
+	}
+	c.Update(tea.KeyMsg{Type: tea.KeyDown})
+	if c.unseen != 0 || strings.Contains(c.View(), "new message") {
+		t.Error("the count is still shown after scrolling down to the new message")
+	}
+}
+
+func TestDroppedMessagesAreReportedInTheirChat(t *testing.T) {
+	c := renderedChat(100, 1)
+	c.Update(MessagesDroppedMsg{UserID: "carol", Count: 2, Reason: "waited too long for the encrypted session"})
+	if strings.Contains(c.View(), "could not be sent") {
+		t.Error("a drop in another chat is shown")
+	}
+
+	c.Update(MessagesDroppedMsg{UserID: c.currentChat, Count: 2, Reason: "waited too long for the encrypted session"})
+	if !strings.Contains(c.View(), "⚠ 2 message(s) could not be sent: waited too long for the encrypted session") {
+		t.Errorf("the drop is not shown:\n%s", c.View())
+	}
+}
+
+// fromDevices sends each of the view's messages from the device at the same
+// position in devices, leaving it unrecorded for an empty name
+func fromDevices(c *ChatView, devices ...string) {
+	for i, device := range devices {
+		msg := c.messages[i]
+		msg.DeviceID = device
+		if device != "" {
+			msg.DeviceName = "Alice's " + device
+		}
+		c.Update(MessageUpdatedMsg{Message: msg})
+	}
+}
+
+func TestOwnDevicesAreNamedOnlyWhenThereAreSeveral(t *testing.T) {
+	t.Run("one device", func(t *testing.T) {
+		c := renderedChat(100, 3)
+		fromDevices(c, "laptop", "laptop", "")
+		if got := c.ownDeviceCount(); got != 1 {
+			t.Errorf("ownDeviceCount = %d, want 1", got)
+		}
+		if view := c.View(); strings.Contains(view, "Alice's laptop") {
+			t.Errorf("messages from the only device name it:\n%s", view)
+		}
+	})
+
+	t.Run("several devices", func(t *testing.T) {
+		c := renderedChat(100, 3)
+		fromDevices(c, "laptop", "phone", "laptop")
+		if got := c.ownDeviceCount(); got != 2 {
+			t.Errorf("ownDeviceCount = %d, want 2", got)
+		}
+		view := c.View()
+		if strings.Count(view, "Alice's laptop") != 2 || strings.Count(view, "Alice's phone") != 1 {
+			t.Errorf("messages do not name the device each came from:\n%s", view)
+		}
+	})
+
+	t.Run("messages from others", func(t *testing.T) {
+		c := renderedChat(100, 2)
+		fromDevices(c, "laptop")
+		theirs := c.messages[1]
+		theirs.From, theirs.To = "bob", "alice"
+		theirs.DeviceID = "bobs-phone"
+		c.Update(MessageUpdatedMsg{Message: theirs})
+		if got := c.ownDeviceCount(); got != 1 {
+			t.Errorf("ownDeviceCount = %d, want 1 counting only our own devices", got)
+		}
+	})
+}
+
+func TestDeviceLabelFallsBackToID(t *testing.T) {
+	if got := deviceLabel(models.Message{DeviceID: "d1", DeviceName: "Laptop"}); got != "Laptop" {
+		t.Errorf("deviceLabel with a name = %q, want Laptop", got)
+	}
+	if got := deviceLabel(models.Message{DeviceID: "d1"}); got != "d1" {
+		t.Errorf("deviceLabel without a name = %q, want d1", got)
+	}
+}
+
+func TestHeaderAlignsWideNamesByDisplayWidth(t *testing.T) {
+	names := []string{
+		"Bob",
+		"小明",      // Two columns a character
+		"José",   // A combining accent takes no column
+		"👩‍💻 Ama", // One emoji of three code points
+		"ｆｕｌｌｗｉｄｔｈ ｎａｍｅ", // Fullwidth letters
+	}
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.Update(tea.WindowSizeMsg{Width: 100, Height: 20})
+
+	for _, name := range names {
+		c.SetContactLookup(func(userID string) (*models.Contact, bool) {
+			return &models.Contact{UserID: userID, DisplayName: name, Status: models.UserStatusOnline}, true
+		})
+		c.OpenChat("bob")
+		header := c.renderHeader()
+		if !strings.Contains(header, name) {
+			t.Fatalf("header %q does not show %q", header, name)
+		}
+		for _, line := range strings.Split(header, "\n") {
+			if w := lipgloss.Width(line); w != 100 {
+				t.Errorf("header line for %q is %d columns, want 100: %q", name, w, line)
+			}
+		}
+
+		// The status ends against the right padding whatever the name
+		plain := strings.TrimRight(header, " ")
+		if !strings.HasSuffix(plain, "● Online") {
+			t.Errorf("header for %q = %q, want it to end with the status", name, plain)
+		}
+		if w := lipgloss.Width(plain); w != 99 {
+			t.Errorf("status for %q ends at column %d, want 99", name, w)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ui
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxBlankLines is how many blank lines in a row a sent message keeps
+const maxBlankLines = 2
+
+// composeMessage tidies typed input into the content to send: trailing
+// whitespace is trimmed from every line, leading and trailing blank lines
+// are dropped and longer runs of blank lines are shortened. Indentation and
+// the newlines between lines are kept. It reports false if nothing but
+// whitespace was typed.
+func composeMessage(input string) (string, bool) {
+	lines := strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n")
+
+	kept := make([]string, 0, len(lines))
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRightFunc(line, isSpace)
+		if line == "" {
+			blank++
+			continue
+		}
+		if len(kept) > 0 {
+			for i := 0; i < min(blank, maxBlankLines); i++ {
+				kept = append(kept, "")
+			}
+		}
+		blank = 0
+		kept = append(kept, line)
+	}
+
+	if len(kept) == 0 {
+		return "", false
+	}
+	return strings.Join(kept, "\n"), true
+}
+
+// isSpace reports whether r is whitespace, including the zero-width spaces
+// that look like nothing on screen
+func isSpace(r rune) bool {
+	switch r {
+	case '\u200b', '\u2060', '\ufeff':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
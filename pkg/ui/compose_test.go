@@ -0,0 +1,80 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestComposeMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		wantOK bool
+	}{
+		{"empty", "", "", false},
+		{"spaces", "   ", "", false},
+		{"blank lines and tabs", "\n\t\n \r\n", "", false},
+		{"zero-width spaces", "\u200b\ufeff ", "", false},
+		{"plain", "hello", "hello", true},
+		{"trailing whitespace", "hello \t\n", "hello", true},
+		{"leading blank lines", "\n\n  \nhello", "hello", true},
+		{"indentation kept", "  indented\n\tcode", "  indented\n\tcode", true},
+		{"trailing spaces on each line", "one  \ntwo\t", "one\ntwo", true},
+		{"internal newlines kept", "one\ntwo\n\nthree", "one\ntwo\n\nthree", true},
+		{"blank runs shortened", "one\n\n\n\n\ntwo", "one\n\n\ntwo", true},
+		{"whitespace-only lines count as blank", "one\n \n\t\n  \n \ntwo", "one\n\n\ntwo", true},
+		{"CRLF", "one\r\ntwo\r\n", "one\ntwo", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := composeMessage(tt.input)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("composeMessage(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWhitespaceOnlyInputIsNotSent(t *testing.T) {
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	var sent []*models.Message
+	c.SetMessageSender(func(msg *models.Message, overrideKeyChange bool) error {
+		sent = append(sent, msg)
+		return nil
+	})
+	c.Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+	c.OpenChat("bob")
+
+	// Sending nothing does nothing
+	if _, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd != nil || c.notice != "" {
+		t.Errorf("sending empty input returned a command or notice %q", c.notice)
+	}
+
+	c.input = "  \n\t "
+	_, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	runCmd(c, cmd)
+	if len(sent) != 0 || len(c.messages) != 0 {
+		t.Fatalf("whitespace-only input sent %d and showed %d messages, want none", len(sent), len(c.messages))
+	}
+	if c.input != "  \n\t " || !strings.Contains(c.View(), "Nothing to send") {
+		t.Errorf("input = %q, want it left as typed with a notice", c.input)
+	}
+
+	// Real input is sent tidied
+	c.input = "\nsee you\n\n\n\nat noon  \n"
+	_, cmd = c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	runCmd(c, cmd)
+	if len(sent) != 1 || sent[0].Content != "see you\n\n\nat noon" {
+		t.Fatalf("sent %d messages, want one reading %q", len(sent), "see you\n\n\nat noon")
+	}
+	if c.input != "" {
+		t.Errorf("input = %q after sending, want it cleared", c.input)
+	}
+}
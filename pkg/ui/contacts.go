@@ -2,7 +2,9 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -14,25 +16,32 @@ import (
 type ContactsView struct {
 	config   *config.Config
 	theme    *Theme
+	keys     *KeyMap
 	width    int
 	height   int
 	
-	// Contact state
+	// Contact state. Hidden contacts, such as blocked or archived ones,
+	// are only counted.
 	contacts     []models.Contact
+	hidden       map[string]bool
 	selectedIdx  int
 	searchQuery  string
 	searchActive bool
 	
 	// UI state
 	scrollOffset int
+	location     *time.Location // Timezone of displayed times
 }
 
 // NewContactsView creates a new contacts view
-func NewContactsView(cfg *config.Config, theme *Theme) *ContactsView {
+func NewContactsView(cfg *config.Config, theme *Theme, keys *KeyMap) *ContactsView {
 	return &ContactsView{
 		config:   cfg,
 		theme:    theme,
+		keys:     keys,
 		contacts: generateSampleContacts(), // TODO: Load from storage
+		hidden:   make(map[string]bool),
+		location: displayLocation(cfg.UI),
 	}
 }
 
@@ -41,6 +50,86 @@ func (c *ContactsView) Init() tea.Cmd {
 	return nil
 }
 
+// SetContacts replaces the displayed contacts with stored ones
+func (c *ContactsView) SetContacts(contacts []*models.Contact) {
+	c.contacts = make([]models.Contact, 0, len(contacts))
+	c.hidden = make(map[string]bool)
+	for _, contact := range contacts {
+		if contact.State().Hidden() {
+			c.hidden[contact.UserID] = true
+			continue
+		}
+		c.contacts = append(c.contacts, *contact)
+	}
+	c.sortContacts()
+	
+	if c.selectedIdx >= len(c.contacts) {
+		c.selectedIdx = 0
+		c.scrollOffset = 0
+	}
+}
+
+// sortContacts orders the contacts by display name, favorites first
+func (c *ContactsView) sortContacts() {
+	sort.SliceStable(c.contacts, func(i, j int) bool {
+		if c.contacts[i].Favorite != c.contacts[j].Favorite {
+			return c.contacts[i].Favorite
+		}
+		return c.contacts[i].GetDisplayName() < c.contacts[j].GetDisplayName()
+	})
+}
+
+// applyContactChange adds, replaces or, if contact is nil, removes one
+// contact; a contact that became hidden is removed from the list. The
+// selected contact stays selected at the same row of the list when it
+// moves; if it is removed, the one after it is selected.
+func (c *ContactsView) applyContactChange(userID string, contact *models.Contact) {
+	delete(c.hidden, userID)
+	if contact != nil && contact.State().Hidden() {
+		c.hidden[userID] = true
+		contact = nil
+	}
+	
+	selectedID := ""
+	if c.selectedIdx < len(c.contacts) {
+		selectedID = c.contacts[c.selectedIdx].UserID
+	}
+	row := c.selectedIdx - c.scrollOffset
+	
+	idx := -1
+	for i := range c.contacts {
+		if c.contacts[i].UserID == userID {
+			idx = i
+			break
+		}
+	}
+	switch {
+	case contact == nil && idx < 0:
+		return
+	case contact == nil:
+		c.contacts = append(c.contacts[:idx], c.contacts[idx+1:]...)
+	case idx < 0:
+		c.contacts = append(c.contacts, *contact)
+	default:
+		c.contacts[idx] = *contact
+	}
+	c.sortContacts()
+	
+	if selectedID == userID && contact == nil {
+		// Keep the position; the next contact moved into it
+		c.selectedIdx = min(c.selectedIdx, max(len(c.contacts)-1, 0))
+	} else {
+		for i := range c.contacts {
+			if c.contacts[i].UserID == selectedID {
+				c.selectedIdx = i
+				break
+			}
+		}
+	}
+	c.scrollOffset = max(c.selectedIdx-row, 0)
+	c.adjustScroll()
+}
+
 // Update implements tea.Model
 func (c *ContactsView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
@@ -48,47 +137,56 @@ func (c *ContactsView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		c.width = msg.Width
 		c.height = msg.Height - 2 // Account for status bar
 		
+	case ContactUpdatedMsg:
+		c.applyContactChange(msg.Contact.UserID, &msg.Contact)
+		
+	case ContactAddedMsg:
+		c.applyContactChange(msg.Contact.UserID, &msg.Contact)
+		
+	case ContactRemovedMsg:
+		c.applyContactChange(msg.UserID, nil)
+		
 	case tea.KeyMsg:
 		if c.searchActive {
 			return c.handleSearchInput(msg)
 		}
 		
-		switch msg.String() {
-		case "up", "k":
+		switch {
+		case c.keys.Matches(msg, ActionUp):
 			if c.selectedIdx > 0 {
 				c.selectedIdx--
 				c.adjustScroll()
 			}
 			
-		case "down", "j":
+		case c.keys.Matches(msg, ActionDown):
 			if c.selectedIdx < len(c.contacts)-1 {
 				c.selectedIdx++
 				c.adjustScroll()
 			}
 			
-		case "enter":
+		case c.keys.Matches(msg, ActionSelect):
 			if len(c.contacts) > 0 {
 				// TODO: Open chat with selected contact
 				return c, nil
 			}
 			
-		case "/":
+		case c.keys.Matches(msg, ActionSearch):
 			c.searchActive = true
 			c.searchQuery = ""
 			
-		case "ctrl+a":
+		case c.keys.Matches(msg, ActionAddContact):
 			// TODO: Add new contact
 			return c, nil
 			
-		case "ctrl+e":
+		case c.keys.Matches(msg, ActionEditContact):
 			// TODO: Edit selected contact
 			return c, nil
 			
-		case "delete", "x":
+		case c.keys.Matches(msg, ActionRemoveContact):
 			// TODO: Remove selected contact
 			return c, nil
 			
-		case "space":
+		case c.keys.Matches(msg, ActionToggle):
 			// TODO: Toggle contact status
 			return c, nil
 		}
@@ -116,18 +214,27 @@ func (c *ContactsView) View() string {
 	)
 }
 
+// handleBack cancels a search in progress
+func (c *ContactsView) handleBack() bool {
+	if !c.searchActive {
+		return false
+	}
+	c.searchActive = false
+	c.searchQuery = ""
+	return true
+}
+
 // handleSearchInput handles keyboard input during search
 func (c *ContactsView) handleSearchInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "esc":
-		c.searchActive = false
-		c.searchQuery = ""
+	switch {
+	case c.keys.Matches(msg, ActionBack):
+		c.handleBack()
 		
-	case "enter":
+	case c.keys.Matches(msg, ActionSelect):
 		c.searchActive = false
 		// TODO: Filter contacts based on search query
 		
-	case "backspace":
+	case c.keys.Matches(msg, ActionDeleteBackward):
 		if len(c.searchQuery) > 0 {
 			c.searchQuery = c.searchQuery[:len(c.searchQuery)-1]
 		}
@@ -151,6 +258,9 @@ func (c *ContactsView) renderHeader() string {
 	
 	title := "Contacts"
 	count := fmt.Sprintf("(%d)", len(c.contacts))
+	if len(c.hidden) > 0 {
+		count = fmt.Sprintf("(%d, %d hidden)", len(c.contacts), len(c.hidden))
+	}
 	
 	// Search bar
 	searchStyle := lipgloss.NewStyle().
@@ -163,14 +273,17 @@ func (c *ContactsView) renderHeader() string {
 	if c.searchActive {
 		searchBar = searchStyle.Render(fmt.Sprintf("Search: %s│", c.searchQuery))
 	} else {
-		searchBar = searchStyle.Render("Search: [Press / to search]")
+		searchBar = searchStyle.Render("Search: [Press " + c.keys.Help(ActionSearch) + " to search]")
 	}
 	
+	left := title + " " + count
+	right := "(Esc)"
+	padding := max(c.width-lipgloss.Width(left)-lipgloss.Width(right)-2, 0) // Account for padding
 	headerContent := lipgloss.JoinHorizontal(
 		lipgloss.Left,
-		title+" "+count,
-		strings.Repeat(" ", c.width-len(title)-len(count)-len(searchBar)-4),
-		"(Esc)",
+		left,
+		strings.Repeat(" ", padding),
+		right,
 	)
 	
 	return lipgloss.JoinVertical(
@@ -200,7 +313,7 @@ func (c *ContactsView) renderContactList() string {
 			Height(listHeight - 2)
 		
 		return style.Render(
-			emptyStyle.Render("No contacts yet. Press Ctrl+A to add a contact."),
+			emptyStyle.Render("No contacts yet. Press " + c.keys.Help(ActionAddContact) + " to add a contact."),
 		)
 	}
 	
@@ -229,7 +342,13 @@ func (c *ContactsView) renderFooter() string {
 		Padding(0, 1).
 		Width(c.width)
 	
-	shortcuts := "[Enter] Open chat  [Space] Toggle status  [Ctrl+A] Add  [Ctrl+E] Edit  [Del] Remove"
+	shortcuts := fmt.Sprintf("[%s] Open chat  [%s] Toggle status  [%s] Add  [%s] Edit  [%s] Remove",
+		c.keys.Help(ActionSelect),
+		c.keys.Help(ActionToggle),
+		c.keys.Help(ActionAddContact),
+		c.keys.Help(ActionEditContact),
+		c.keys.Help(ActionRemoveContact),
+	)
 	
 	return style.Render(shortcuts)
 }
@@ -268,12 +387,22 @@ func (c *ContactsView) formatContact(contact models.Contact, isSelected bool) st
 	statusStyle := lipgloss.NewStyle().Foreground(statusColor)
 	
 	// Contact info
+	state := contact.State()
 	displayName := contact.GetDisplayName()
-	if contact.Verified {
+	if state.Favorite {
+		displayName = "★ " + displayName
+	}
+	if state.Verified {
 		displayName += " ✓"
 	}
+	if state.Muted {
+		displayName += " (muted)"
+	}
+	if state.Unknown {
+		displayName += " (not in contacts)"
+	}
 	
-	lastSeen := "Last seen: " + formatLastSeen(contact.LastSeen)
+	lastSeen := formatContactLastSeen(contact, time.Now(), c.location)
 	statusMessage := contact.StatusMessage
 	if statusMessage == "" {
 		statusMessage = "No status message"
@@ -285,6 +414,11 @@ func (c *ContactsView) formatContact(contact models.Contact, isSelected bool) st
 	line3 := lipgloss.NewStyle().Foreground(c.theme.Secondary).Italic(true).Render(fmt.Sprintf("\"%s\"", statusMessage))
 	
 	content := lipgloss.JoinVertical(lipgloss.Left, line1, line2, line3)
+	if c.width >= avatarMinListWidth {
+		if avatar := renderAvatar(&contact, c.config.UI.CompactMode); avatar != "" {
+			content = lipgloss.JoinHorizontal(lipgloss.Top, avatar, " ", content)
+		}
+	}
 	
 	return style.Render(content)
 }
@@ -316,6 +450,10 @@ func (c *ContactsView) getVisibleContacts() []models.Contact {
 func (c *ContactsView) adjustScroll() {
 	listHeight := c.height - 4
 	maxContacts := listHeight / 4
+	if maxContacts <= 0 {
+		// Not sized yet
+		return
+	}
 	
 	if c.selectedIdx < c.scrollOffset {
 		c.scrollOffset = c.selectedIdx
@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// contactList returns a contacts view of the named contacts, sized to show
+// rows of them at a time
+func contactList(rows int, names ...string) *ContactsView {
+	c := NewContactsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.Update(tea.WindowSizeMsg{Width: 80, Height: rows*4 + 6})
+	var contacts []*models.Contact
+	for _, name := range names {
+		contacts = append(contacts, &models.Contact{UserID: strings.ToLower(name), DisplayName: name})
+	}
+	c.SetContacts(contacts)
+	return c
+}
+
+// selected returns the selected contact's user ID and its row on screen
+func selected(c *ContactsView) (string, int) {
+	return c.contacts[c.selectedIdx].UserID, c.selectedIdx - c.scrollOffset
+}
+
+func TestContactsViewKeepsSelectionThroughUpdates(t *testing.T) {
+	c := contactList(3, "Bob", "Carol", "Dave", "Erin", "Frank")
+	for i := 0; i < 3; i++ {
+		c.Update(tea.KeyMsg{Type: tea.KeyDown})
+	}
+	wantID, wantRow := selected(c)
+	if wantID != "erin" {
+		t.Fatalf("selected %s, want erin", wantID)
+	}
+
+	steps := []struct {
+		name string
+		msg  tea.Msg
+	}{
+		{"added above", ContactAddedMsg{Contact: models.Contact{UserID: "aaron", DisplayName: "Aaron"}}},
+		{"presence of another", ContactUpdatedMsg{Contact: models.Contact{UserID: "carol", DisplayName: "Carol", Status: models.UserStatusOnline}}},
+		{"removed above", ContactRemovedMsg{UserID: "bob"}},
+		{"selected renamed", ContactUpdatedMsg{Contact: models.Contact{UserID: "erin", DisplayName: "Zed"}}},
+		{"unknown removed", ContactRemovedMsg{UserID: "nobody"}},
+	}
+	for _, step := range steps {
+		c.Update(step.msg)
+		id, row := selected(c)
+		if id != wantID || row != wantRow {
+			t.Errorf("%s: selected %s at row %d, want %s at row %d", step.name, id, row, wantID, wantRow)
+		}
+	}
+	if got := c.contacts[len(c.contacts)-1]; got.UserID != "erin" {
+		t.Errorf("last contact is %s, want the renamed one sorted last", got.UserID)
+	}
+	if !strings.Contains(c.View(), "Zed") {
+		t.Error("the renamed contact is not shown by its new name")
+	}
+
+	// Removing the selected contact selects the one that took its place
+	c.Update(ContactRemovedMsg{UserID: "dave"})
+	c.Update(tea.KeyMsg{Type: tea.KeyUp})
+	if id, _ := selected(c); id != "frank" {
+		t.Fatalf("selected %s, want frank", id)
+	}
+	c.Update(ContactRemovedMsg{UserID: "frank"})
+	if id, _ := selected(c); id != "erin" {
+		t.Errorf("after removing the selected contact, selected %s, want erin", id)
+	}
+
+	// A contact that becomes blocked leaves the list
+	c.Update(ContactUpdatedMsg{Contact: models.Contact{UserID: "carol", DisplayName: "Carol", Blocked: true}})
+	for _, contact := range c.contacts {
+		if contact.UserID == "carol" {
+			t.Error("a blocked contact is still listed")
+		}
+	}
+}
+
+func TestContactsViewRendersContactState(t *testing.T) {
+	c := contactList(6)
+	c.SetContacts([]*models.Contact{
+		{UserID: "bob", DisplayName: "Bob", Muted: true},
+		{UserID: "carol", DisplayName: "Carol", Blocked: true, Favorite: true},
+		{UserID: "dave", DisplayName: "Dave", Archived: true},
+		{UserID: "zed", DisplayName: "Zed", Favorite: true, Verified: true},
+		{UserID: "erin", DisplayName: "Erin", Verified: true, KeyChanged: true},
+	})
+
+	var listed []string
+	for _, contact := range c.contacts {
+		listed = append(listed, contact.UserID)
+	}
+	if got := strings.Join(listed, ","); got != "zed,bob,erin" {
+		t.Errorf("listed %s, want zed,bob,erin with the favorite first", got)
+	}
+	view := c.View()
+	for _, want := range []string{"(3, 2 hidden)", "★ Zed ✓", "Bob (muted)"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view does not show %q", want)
+		}
+	}
+	if strings.Contains(view, "Erin ✓") {
+		t.Error("a contact whose key changed is shown as verified")
+	}
+
+	// Unarchiving brings a contact back
+	c.Update(ContactUpdatedMsg{Contact: models.Contact{UserID: "dave", DisplayName: "Dave"}})
+	if view := c.View(); !strings.Contains(view, "(4, 1 hidden)") || !strings.Contains(view, "Dave") {
+		t.Error("the unarchived contact is not listed again")
+	}
+
+	// Users of ad-hoc chats are listed apart from the address book
+	c.Update(ContactAddedMsg{Contact: models.Contact{UserID: "frank", DisplayName: "frank", Unknown: true}})
+	if !strings.Contains(c.View(), "frank (not in contacts)") {
+		t.Error("an unknown contact is not marked as such")
+	}
+}
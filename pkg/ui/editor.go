@@ -0,0 +1,124 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultEditor is opened when neither the configuration nor the
+// environment names an editor
+const defaultEditor = "vi"
+
+// maxDraftSize bounds the draft read back from the editor, the largest
+// message a relay accepts
+const maxDraftSize = 64 * 1024
+
+// errDraftTooLarge is reported when the edited draft exceeds maxDraftSize
+var errDraftTooLarge = errors.New("the draft is too large to send")
+
+// editorOpenedMsg is sent just before the editor takes over the terminal
+type editorOpenedMsg struct{}
+
+// editorClosedMsg delivers the draft as the editor left it, or why it
+// could not be edited
+type editorClosedMsg struct {
+	content string
+	err     error
+}
+
+// editorCommand returns the command line of the editor drafts are opened
+// in: ui.editor if set, otherwise $VISUAL or $EDITOR. Arguments are split
+// on whitespace, so "code --wait" works.
+func editorCommand(configured string) []string {
+	for _, candidate := range []string{configured, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// openEditor opens the draft in an external editor. Bubbletea suspends the
+// interface and leaves the alternate screen while the editor runs, and
+// restores both when it exits. The draft is written to a temporary file
+// only the user can read, which is removed once read back.
+func (c *ChatView) openEditor() tea.Cmd {
+	file, err := os.CreateTemp("", "securechat-draft-*.txt")
+	if err != nil {
+		c.notice = fmt.Sprintf("Could not open the editor: %v", err)
+		return nil
+	}
+	path := file.Name()
+	_, err = file.WriteString(c.input)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		c.notice = fmt.Sprintf("Could not open the editor: %v", err)
+		return nil
+	}
+
+	args := editorCommand(c.config.UI.Editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	return tea.Sequence(
+		func() tea.Msg { return editorOpenedMsg{} },
+		tea.ExecProcess(cmd, func(err error) tea.Msg {
+			return readDraft(path, err)
+		}),
+	)
+}
+
+// readDraft reads back and removes the draft file once the editor exited
+// with runErr
+func readDraft(path string, runErr error) editorClosedMsg {
+	defer os.Remove(path)
+
+	if runErr != nil {
+		return editorClosedMsg{err: runErr}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return editorClosedMsg{err: err}
+	}
+	if len(data) > maxDraftSize {
+		return editorClosedMsg{err: errDraftTooLarge}
+	}
+	return editorClosedMsg{content: sanitizeDraft(string(data))}
+}
+
+// applyDraft replaces the input with the edited draft. If the editor
+// failed or was quit with an error, as with :cq in vim, the input is kept
+// as it was.
+func (c *ChatView) applyDraft(msg editorClosedMsg) {
+	if msg.err != nil {
+		c.notice = fmt.Sprintf("Draft unchanged: %v", msg.err)
+		return
+	}
+	c.input = msg.content
+	c.cursor = len(c.input)
+}
+
+// sanitizeDraft makes text written in an editor fit for the input: invalid
+// UTF-8, a byte order mark and control characters other than tabs and
+// newlines are dropped, so a draft cannot carry terminal escape sequences,
+// line endings are normalized and the newline editors end files with is
+// removed
+func sanitizeDraft(text string) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.TrimPrefix(text, "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, text)
+	return strings.TrimSuffix(text, "\n")
+}
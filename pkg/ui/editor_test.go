@@ -0,0 +1,92 @@
+package ui
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func TestSanitizeDraft(t *testing.T) {
+	tests := []struct {
+		name  string
+		draft string
+		want  string
+	}{
+		{"plain", "hello\n", "hello"},
+		{"several lines", "one\ntwo\n\nthree\n", "one\ntwo\n\nthree"},
+		{"only the last newline is dropped", "hello\n\n", "hello\n"},
+		{"windows line endings", "one\r\ntwo\r\n", "one\ntwo"},
+		{"byte order mark", "\ufeffhello", "hello"},
+		{"tabs are kept", "a\tb", "a\tb"},
+		{"escape sequences", "\x1b[31mred\x1b[0m\x07", "[31mred[0m"},
+		{"invalid UTF-8", "caf\xc3 ok\xff", "caf ok"},
+		{"wide characters", "你好 👋🏽\n", "你好 👋🏽"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeDraft(tt.draft); got != tt.want {
+				t.Errorf("sanitizeDraft(%q) = %q, want %q", tt.draft, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDraftRemovesTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "draft.txt")
+	if err := os.WriteFile(path, []byte("edited\r\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if msg := readDraft(path, nil); msg.err != nil || msg.content != "edited" {
+		t.Errorf("readDraft = %q, %v, want the sanitized draft", msg.content, msg.err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the draft file was left behind: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Repeat("a", maxDraftSize+1)), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if msg := readDraft(path, nil); !errors.Is(msg.err, errDraftTooLarge) {
+		t.Errorf("readDraft of a draft too large = %v, want errDraftTooLarge", msg.err)
+	}
+}
+
+func TestClosedEditorUpdatesDraft(t *testing.T) {
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.input = "first try"
+	c.cursor = 3
+
+	c.Update(editorClosedMsg{content: "written in the editor"})
+	if c.input != "written in the editor" || c.cursor != len(c.input) {
+		t.Errorf("input %q with the cursor at %d, want the edited draft with the cursor at its end", c.input, c.cursor)
+	}
+
+	// An editor quit with an error leaves the input alone
+	c.Update(editorClosedMsg{content: "discarded", err: errors.New("exit status 1")})
+	if c.input != "written in the editor" {
+		t.Errorf("input after a failed edit = %q, want it unchanged", c.input)
+	}
+	if !strings.Contains(c.notice, "exit status 1") {
+		t.Errorf("notice after a failed edit = %q, want the error", c.notice)
+	}
+}
+
+func TestEditorKeyIsNotBoundToOtherActions(t *testing.T) {
+	keys := DefaultKeyMap()
+	for _, key := range keys.Keys(ActionOpenEditor) {
+		for _, info := range actions {
+			if info.action == ActionOpenEditor {
+				continue
+			}
+			for _, other := range keys.Keys(info.action) {
+				if other == key {
+					t.Errorf("%s opens the editor and also triggers %s", key, info.action)
+				}
+			}
+		}
+	}
+}
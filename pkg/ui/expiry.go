@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// expiryTickMsg refreshes the countdowns of disappearing messages and
+// removes those that expired
+type expiryTickMsg struct{}
+
+// expiresAt returns when a message disappears, or the zero time if it is
+// kept
+func expiresAt(msg *models.Message) time.Time {
+	if msg.Metadata == nil {
+		return time.Time{}
+	}
+	return msg.Metadata.ExpiresAt
+}
+
+// formatCountdown formats the time left until a message disappears in its
+// largest unit, rounded up so that "1m" is shown until the last minute
+func formatCountdown(left time.Duration) string {
+	switch {
+	case left > time.Hour:
+		return fmt.Sprintf("%dh", (left+time.Hour-1)/time.Hour)
+	case left > time.Minute:
+		return fmt.Sprintf("%dm", (left+time.Minute-1)/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", max((left+time.Second-1)/time.Second, 1))
+	}
+}
+
+// renderCountdown returns the countdown shown next to the time of a
+// disappearing message, or "" for a message that is kept
+func (c *ChatView) renderCountdown(msg *models.Message, now time.Time) string {
+	expiry := expiresAt(msg)
+	if expiry.IsZero() {
+		return ""
+	}
+	return "⏲ " + formatCountdown(expiry.Sub(now))
+}
+
+// removeExpired drops the messages whose time is up. Storage skips them from
+// the same moment and deletes them when it sweeps.
+func (c *ChatView) removeExpired(now time.Time) {
+	kept := c.messages[:0]
+	for _, msg := range c.messages {
+		if expiry := expiresAt(&msg); !expiry.IsZero() && !now.Before(expiry) {
+			c.rendered.invalidate(msg.ID)
+			if msg.ID == c.selectedID {
+				c.selectedID = ""
+			}
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if removed := len(c.messages) - len(kept); removed > 0 {
+		c.scrollOffset = max(min(c.scrollOffset, len(kept)-1), 0)
+	}
+	c.messages = kept
+}
+
+// scheduleExpiryTick returns the command for the next refresh of the
+// countdowns, or nil if one is pending or no shown message disappears. It
+// fires once a second, or at the next expiry if that comes sooner, so
+// messages vanish on time.
+func (c *ChatView) scheduleExpiryTick() tea.Cmd {
+	if c.expiryTicking {
+		return nil
+	}
+
+	now := time.Now()
+	next := time.Second
+	expiring := false
+	for i := range c.messages {
+		if expiry := expiresAt(&c.messages[i]); !expiry.IsZero() {
+			expiring = true
+			next = min(next, max(expiry.Sub(now), 0))
+		}
+	}
+	if !expiring {
+		return nil
+	}
+
+	c.expiryTicking = true
+	return tea.Tick(next, func(time.Time) tea.Msg {
+		return expiryTickMsg{}
+	})
+}
@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestFormatCountdown(t *testing.T) {
+	tests := []struct {
+		left time.Duration
+		want string
+	}{
+		{3 * time.Hour, "3h"},
+		{time.Hour + time.Second, "2h"},
+		{time.Hour, "60m"},
+		{4 * time.Minute, "4m"},
+		{3*time.Minute + time.Second, "4m"},
+		{time.Minute, "60s"},
+		{1500 * time.Millisecond, "2s"},
+		{time.Millisecond, "1s"},
+		{0, "1s"},
+	}
+
+	for _, tt := range tests {
+		if got := formatCountdown(tt.left); got != tt.want {
+			t.Errorf("formatCountdown(%s) = %s, want %s", tt.left, got, tt.want)
+		}
+	}
+}
+
+// expiring returns metadata making a message disappear at expiry
+func expiring(expiry time.Time) *models.Metadata {
+	return &models.Metadata{ExpiresAt: expiry}
+}
+
+func TestCountdownIsShownForDisappearingMessages(t *testing.T) {
+	c := renderedChat(100, 2)
+	c.messages[1].Metadata = expiring(time.Now().Add(4*time.Minute - time.Second))
+
+	view := c.View()
+	if strings.Count(view, "⏲") != 1 || !strings.Contains(view, "⏲ 4m") {
+		t.Errorf("view does not show one countdown of 4m:\n%s", view)
+	}
+	if cmd := c.scheduleExpiryTick(); cmd == nil {
+		t.Error("no refresh scheduled for the countdown")
+	}
+	if cmd := c.scheduleExpiryTick(); cmd != nil {
+		t.Error("a second refresh was scheduled while one is pending")
+	}
+}
+
+func TestExpiredMessagesAreRemovedOnTime(t *testing.T) {
+	c := renderedChat(100, 3)
+	expiry := time.Now().Add(time.Hour)
+	c.messages[0].Metadata = expiring(expiry)
+	c.messages[2].Metadata = expiring(expiry.Add(time.Minute))
+
+	c.removeExpired(expiry.Add(-time.Nanosecond))
+	if got := visibleIDs(c); got != "m1,m2,m3" {
+		t.Fatalf("visible just before the expiry = %s, want m1,m2,m3", got)
+	}
+	c.removeExpired(expiry)
+	if got := visibleIDs(c); got != "m2,m3" {
+		t.Errorf("visible at the expiry = %s, want m2,m3", got)
+	}
+	c.removeExpired(expiry.Add(time.Minute))
+	if got := visibleIDs(c); got != "m2" {
+		t.Errorf("visible once both expired = %s, want m2", got)
+	}
+
+	// With nothing left to disappear, the refreshes stop
+	c.Update(expiryTickMsg{})
+	if cmd := c.scheduleExpiryTick(); cmd != nil {
+		t.Error("a refresh was scheduled with no disappearing message shown")
+	}
+}
+
+func TestExpiredMessagesAreNotShown(t *testing.T) {
+	c := renderedChat(100, 1)
+	msg := c.messages[0]
+	msg.ID = "late"
+	msg.Timestamp = msg.Timestamp.Add(time.Minute)
+	msg.Sequence = 2
+	msg.Metadata = expiring(time.Now().Add(-time.Second))
+	c.Update(MessageUpdatedMsg{Message: msg})
+
+	if got := visibleIDs(c); got != "m1" {
+		t.Errorf("visible = %s, want the expired message left out", got)
+	}
+}
@@ -0,0 +1,134 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// ContactLister returns the stored contacts
+type ContactLister func() []*models.Contact
+
+// MessageForwarder forwards a message of a chat to another contact. Chats
+// are named by the other user's ID.
+type MessageForwarder func(fromChatID, messageID, toUserID string) error
+
+// MessageForwardedMsg reports the result of forwarding a message
+type MessageForwardedMsg struct {
+	To  string
+	Err error
+}
+
+// forwardPicker is the contact picker shown while forwarding a message
+type forwardPicker struct {
+	messageID string
+	targets   []*models.Contact
+	index     int
+}
+
+// selectMessage moves the message selection by delta, starting from the
+// latest message, and scrolls the selection into view
+func (c *ChatView) selectMessage(delta int) {
+	if len(c.messages) == 0 {
+		return
+	}
+
+	index := len(c.messages) - 1
+	if c.selectedID != "" {
+		for i := range c.messages {
+			if c.messages[i].ID == c.selectedID {
+				index = min(max(i+delta, 0), len(c.messages)-1)
+				break
+			}
+		}
+	}
+	c.selectedID = c.messages[index].ID
+	c.scrollIntoView(index)
+}
+
+// scrollIntoView scrolls as little as needed to show the message at index
+func (c *ChatView) scrollIntoView(index int) {
+	maxMessages := c.maxVisibleMessages()
+	switch {
+	case index < c.scrollOffset:
+		c.scrollOffset = index
+	case maxMessages > 0 && index >= c.scrollOffset+maxMessages:
+		c.scrollOffset = index - maxMessages + 1
+	}
+	if c.atBottom() {
+		c.unseen = 0
+	}
+}
+
+// startForward opens the contact picker for the selected message
+func (c *ChatView) startForward() {
+	if c.forwarder == nil || c.contactLister == nil {
+		return
+	}
+	if c.selectedID == "" {
+		c.notice = fmt.Sprintf("Select a message with %s first", c.keys.Help(ActionSelectPrevMessage))
+		return
+	}
+
+	var targets []*models.Contact
+	for _, contact := range c.contactLister() {
+		if contact.UserID != c.currentChat && !contact.State().Hidden() {
+			targets = append(targets, contact)
+		}
+	}
+	if len(targets) == 0 {
+		c.notice = "No other contacts to forward to"
+		return
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].GetDisplayName() < targets[j].GetDisplayName()
+	})
+
+	c.forwarding = &forwardPicker{messageID: c.selectedID, targets: targets}
+}
+
+// updateForward handles keys while the contact picker is open
+func (c *ChatView) updateForward(msg tea.KeyMsg) tea.Cmd {
+	picker := c.forwarding
+	switch {
+	case c.keys.Matches(msg, ActionCursorLeft), c.keys.Matches(msg, ActionScrollUp):
+		picker.index = (picker.index + len(picker.targets) - 1) % len(picker.targets)
+
+	case c.keys.Matches(msg, ActionCursorRight), c.keys.Matches(msg, ActionScrollDown):
+		picker.index = (picker.index + 1) % len(picker.targets)
+
+	case c.keys.Matches(msg, ActionSend):
+		c.forwarding = nil
+		target := picker.targets[picker.index]
+		forward := c.forwarder
+		chatID := c.currentChat
+		return func() tea.Msg {
+			err := forward(chatID, picker.messageID, target.UserID)
+			return MessageForwardedMsg{To: target.GetDisplayName(), Err: err}
+		}
+
+	case c.keys.Matches(msg, ActionBack):
+		c.forwarding = nil
+	}
+	return nil
+}
+
+// renderForwardPicker renders the contact picker as the input area's help line
+func (c *ChatView) renderForwardPicker() string {
+	picker := c.forwarding
+	target := lipgloss.NewStyle().
+		Foreground(c.theme.Highlight).
+		Bold(true).
+		Render("◀ " + picker.targets[picker.index].GetDisplayName() + " ▶")
+
+	help := lipgloss.NewStyle().
+		Foreground(c.theme.Secondary).
+		Render(fmt.Sprintf("(%s/%s to choose, %s to forward, %s to cancel)",
+			c.keys.Help(ActionCursorLeft), c.keys.Help(ActionCursorRight),
+			c.keys.Help(ActionSend), c.keys.Help(ActionBack)))
+
+	return "Forward to: " + target + " " + help
+}
@@ -0,0 +1,68 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+func TestForwardPicker(t *testing.T) {
+	type forward struct{ chatID, messageID, to string }
+	var forwards []forward
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.SetContactLister(func() []*models.Contact {
+		return []*models.Contact{
+			{UserID: "dave", DisplayName: "Dave"},
+			{UserID: "bob", DisplayName: "Bob"},
+			{UserID: "carol", DisplayName: "Carol"},
+			{UserID: "erin", DisplayName: "Erin", Blocked: true},
+			{UserID: "frank", DisplayName: "Frank", Archived: true},
+		}
+	})
+	c.SetMessageForwarder(func(fromChatID, messageID, toUserID string) error {
+		forwards = append(forwards, forward{fromChatID, messageID, toUserID})
+		return nil
+	})
+	c.Update(tea.WindowSizeMsg{Width: 160, Height: 30})
+	c.OpenChat("bob")
+	c.applyHistory(HistoryLoadedMsg{ChatID: "bob", Messages: []*models.Message{
+		{ID: "m1", From: "bob", ChatID: "alice:bob", Content: "first"},
+		{ID: "m2", From: "bob", ChatID: "alice:bob", Content: "second"},
+	}})
+
+	// Nothing is forwarded without a selection
+	ctrlG := tea.KeyMsg{Type: tea.KeyCtrlG}
+	c.Update(ctrlG)
+	if c.forwarding != nil {
+		t.Fatal("the picker opened without a selected message")
+	}
+
+	// The picker offers the other contacts by name, and wraps around
+	c.Update(tea.KeyMsg{Type: tea.KeyShiftUp})
+	c.Update(tea.KeyMsg{Type: tea.KeyShiftUp})
+	c.Update(ctrlG)
+	if c.forwarding == nil {
+		t.Fatal("the picker did not open")
+	}
+	if view := c.View(); !strings.Contains(view, "Forward to:") || !strings.Contains(view, "Carol") {
+		t.Errorf("the picker does not show the first target:\n%s", view)
+	}
+	if got := len(c.forwarding.targets); got != 2 {
+		t.Errorf("the picker offers %d targets, want carol and dave without the hidden contacts", got)
+	}
+	c.Update(tea.KeyMsg{Type: tea.KeyRight})
+	c.Update(tea.KeyMsg{Type: tea.KeyRight})
+	_, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	runCmd(c, cmd)
+
+	if len(forwards) != 1 || forwards[0] != (forward{"bob", "m1", "carol"}) {
+		t.Errorf("forwards = %v, want m1 to carol", forwards)
+	}
+	if c.forwarding != nil || !strings.Contains(c.notice, "Forwarded to Carol") {
+		t.Errorf("after forwarding: picker %v, notice %q", c.forwarding, c.notice)
+	}
+}
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -12,6 +13,7 @@ import (
 type HelpView struct {
 	config *config.Config
 	theme  *Theme
+	keys   *KeyMap
 	width  int
 	height int
 	
@@ -30,13 +32,14 @@ type HelpSection struct {
 }
 
 // NewHelpView creates a new help view
-func NewHelpView(cfg *config.Config, theme *Theme) *HelpView {
+func NewHelpView(cfg *config.Config, theme *Theme, keys *KeyMap) *HelpView {
 	view := &HelpView{
 		config: cfg,
 		theme:  theme,
+		keys:   keys,
 	}
 	
-	view.sections = buildHelpSections()
+	view.sections = buildHelpSections(keys)
 	
 	return view
 }
@@ -54,38 +57,38 @@ func (h *HelpView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		h.height = msg.Height - 2 // Account for status bar
 		
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "up", "k":
+		switch {
+		case h.keys.Matches(msg, ActionUp):
 			if h.scrollOffset > 0 {
 				h.scrollOffset--
 			}
 			
-		case "down", "j":
+		case h.keys.Matches(msg, ActionDown):
 			maxScroll := h.getMaxScroll()
 			if h.scrollOffset < maxScroll {
 				h.scrollOffset++
 			}
 			
-		case "left", "h":
+		case h.keys.Matches(msg, ActionPrevSection):
 			if h.selectedSection > 0 {
 				h.selectedSection--
 				h.scrollOffset = 0
 			}
 			
-		case "right", "l":
+		case h.keys.Matches(msg, ActionNextSection):
 			if h.selectedSection < len(h.sections)-1 {
 				h.selectedSection++
 				h.scrollOffset = 0
 			}
 			
-		case "tab":
+		case h.keys.Matches(msg, ActionCycleSection):
 			h.selectedSection = (h.selectedSection + 1) % len(h.sections)
 			h.scrollOffset = 0
 			
-		case "home":
+		case h.keys.Matches(msg, ActionTop):
 			h.scrollOffset = 0
 			
-		case "end":
+		case h.keys.Matches(msg, ActionBottom):
 			h.scrollOffset = h.getMaxScroll()
 		}
 	}
@@ -177,7 +180,14 @@ func (h *HelpView) renderFooter() string {
 		Padding(0, 1).
 		Width(h.width)
 	
-	shortcuts := "[←→] Switch sections  [↑↓] Scroll  [Tab] Next section  [Esc] Back to chat"
+	shortcuts := fmt.Sprintf("[%s %s] Switch sections  [%s %s] Scroll  [%s] Next section  [%s] Back",
+		h.keys.Help(ActionPrevSection),
+		h.keys.Help(ActionNextSection),
+		h.keys.Help(ActionUp),
+		h.keys.Help(ActionDown),
+		h.keys.Help(ActionCycleSection),
+		h.keys.Help(ActionBack),
+	)
 	
 	return style.Render(shortcuts)
 }
@@ -214,7 +224,7 @@ func (h *HelpView) getMaxScroll() int {
 }
 
 // buildHelpSections creates the help content sections
-func buildHelpSections() []HelpSection {
+func buildHelpSections(keys *KeyMap) []HelpSection {
 	return []HelpSection{
 		{
 			Name: "General",
@@ -233,10 +243,10 @@ func buildHelpSections() []HelpSection {
 				"• Keyboard-driven interface with vim-like bindings",
 				"",
 				"Getting Started:",
-				"1. Add contacts using Ctrl+A in the contacts view",
-				"2. Start chatting by selecting a contact and pressing Enter",
-				"3. Use F1/F2 to switch between chat and contacts views",
-				"4. Configure settings with Ctrl+,",
+				"1. Add contacts using " + keys.Help(ActionAddContact) + " in the contacts view",
+				"2. Start chatting by selecting a contact and pressing " + keys.Help(ActionSelect),
+				"3. Use " + keys.Help(ActionShowChat) + " and " + keys.Help(ActionShowContacts) + " to switch between chat and contacts views",
+				"4. Configure settings with " + keys.Help(ActionShowSettings),
 				"",
 				"Security:",
 				"All messages are encrypted end-to-end. Even relay servers cannot",
@@ -245,48 +255,8 @@ func buildHelpSections() []HelpSection {
 			},
 		},
 		{
-			Name: "Keyboard Shortcuts",
-			Content: []string{
-				"Global Shortcuts:",
-				"",
-				"Ctrl+Q          Quit SecureChat",
-				"Ctrl+C          Quit SecureChat (alternative)",
-				"Ctrl+,          Open settings",
-				"Ctrl+/          Show this help",
-				"F1              Switch to chat view",
-				"F2              Switch to contacts view",
-				"Esc             Return to chat from other views",
-				"",
-				"Chat View:",
-				"",
-				"Enter           Send message",
-				"Shift+Enter     New line in message",
-				"Ctrl+S          Send message (alternative)",
-				"Ctrl+L          Clear chat history",
-				"Ctrl+F          Search messages",
-				"Ctrl+N          New chat",
-				"Ctrl+T          Switch between chat tabs",
-				"Ctrl+W          Close current chat",
-				"↑/↓             Navigate message history",
-				"",
-				"Contacts View:",
-				"",
-				"Enter           Open chat with selected contact",
-				"Ctrl+A          Add new contact",
-				"Ctrl+E          Edit selected contact",
-				"Delete/X        Remove selected contact",
-				"Space           Toggle contact status",
-				"/               Search contacts",
-				"↑/↓ or J/K      Navigate contact list",
-				"",
-				"Settings View:",
-				"",
-				"Tab             Next settings section",
-				"Enter           Edit selected setting",
-				"Space           Toggle boolean settings",
-				"↑/↓ or J/K      Navigate settings",
-				"←/→ or H/L      Switch settings sections",
-			},
+			Name:    "Keyboard Shortcuts",
+			Content: keyBindingHelp(keys),
 		},
 		{
 			Name: "Security",
@@ -313,8 +283,8 @@ func buildHelpSections() []HelpSection {
 				"Example: 12345 67890 12345 67890 12345 67890",
 				"",
 				"To verify a contact:",
-				"1. Go to contacts view (F2)",
-				"2. Select the contact and press Enter",
+				"1. Go to contacts view (" + keys.Help(ActionShowContacts) + ")",
+				"2. Select the contact and press " + keys.Help(ActionSelect),
 				"3. Compare the safety number with your contact",
 				"4. Mark as verified if numbers match",
 				"",
@@ -366,9 +336,11 @@ func buildHelpSections() []HelpSection {
 				"  notifications: true",
 				"  sound_enabled: false",
 				"  timestamp_format: \"15:04\"",
+				"  key_bindings:",
+				"    show_chat: [\"alt+1\"]",
 				"",
 				"security:",
-				"  auto_accept_keys: false",
+				"  auto_accept_keys: never",
 				"  message_retention_days: 30",
 				"  require_verification: true",
 				"",
@@ -417,7 +389,7 @@ func buildHelpSections() []HelpSection {
 				"• Check for app updates",
 				"",
 				"Performance Issues:",
-				"• Clear old message history (Ctrl+L)",
+				"• Clear old message history (" + keys.Help(ActionClearChat) + ")",
 				"• Reduce message retention period",
 				"• Check available disk space",
 				"• Restart SecureChat",
@@ -448,3 +420,45 @@ func buildHelpSections() []HelpSection {
 		},
 	}
 }
+
+// keyBindingHelp lists the active key bindings of every bound action,
+// grouped by the view they apply to
+func keyBindingHelp(keys *KeyMap) []string {
+	groups := []struct {
+		title string
+		view  ViewType
+	}{
+		{"Global Shortcuts:", ""},
+		{"Chat View:", ViewChat},
+		{"Contacts View:", ViewContacts},
+		{"Settings View:", ViewSettings},
+		{"Outbox View:", ViewOutbox},
+		{"Help View:", ViewHelp},
+	}
+	
+	var lines []string
+	for i, group := range groups {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, group.title, "")
+		for _, info := range actions {
+			global := len(info.views) == 0
+			if group.view == "" && !global || group.view != "" && (global || !info.appliesTo(group.view)) {
+				continue
+			}
+			if len(keys.Keys(info.action)) == 0 {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%-15s %s [%s]", keys.Help(info.action), info.description, info.action))
+		}
+	}
+	
+	lines = append(lines,
+		"",
+		"Keys can be rebound under ui.key_bindings in the configuration file,",
+		"using the action names shown in brackets.",
+	)
+	
+	return lines
+}
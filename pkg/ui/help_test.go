@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+// shortcutLine returns the line of the keyboard shortcuts help describing
+// action in the group under title, or "" if there is none
+func shortcutLine(keys *KeyMap, title string, action Action) string {
+	group := ""
+	for _, line := range keyBindingHelp(keys) {
+		if strings.HasSuffix(line, ":") {
+			group = line
+		}
+		if group == title && strings.HasSuffix(line, "["+string(action)+"]") {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestHelpListsEveryAction(t *testing.T) {
+	keys := DefaultKeyMap()
+	titles := map[ViewType]string{
+		ViewChat:     "Chat View:",
+		ViewContacts: "Contacts View:",
+		ViewSettings: "Settings View:",
+		ViewOutbox:   "Outbox View:",
+		ViewHelp:     "Help View:",
+	}
+
+	for _, info := range actions {
+		groups := []string{"Global Shortcuts:"}
+		if len(info.views) > 0 {
+			groups = groups[:0]
+			for _, view := range info.views {
+				groups = append(groups, titles[view])
+			}
+		}
+		for _, title := range groups {
+			line := shortcutLine(keys, title, info.action)
+			if line == "" {
+				t.Errorf("%s is missing from %s", info.action, title)
+				continue
+			}
+			if !strings.HasPrefix(line, keys.Help(info.action)) || !strings.Contains(line, info.description) {
+				t.Errorf("help line %q does not show the keys %s and %q", line, keys.Help(info.action), info.description)
+			}
+		}
+	}
+
+	generated := strings.Join(keyBindingHelp(keys), "\n")
+	for _, section := range buildHelpSections(keys) {
+		if section.Name == "Keyboard Shortcuts" && strings.Join(section.Content, "\n") != generated {
+			t.Error("the Keyboard Shortcuts section is not generated from the key map")
+		}
+	}
+}
+
+func TestHelpFollowsRebinding(t *testing.T) {
+	keys, err := NewKeyMap(map[string][]string{
+		string(ActionShowContacts): {"f5"},
+		string(ActionShowOutbox):   {},
+	})
+	if err != nil {
+		t.Fatalf("NewKeyMap: %v", err)
+	}
+
+	if line := shortcutLine(keys, "Global Shortcuts:", ActionShowContacts); !strings.HasPrefix(line, "F5 ") {
+		t.Errorf("help line = %q, want the remapped F5", line)
+	}
+	if line := shortcutLine(keys, "Global Shortcuts:", ActionShowOutbox); line != "" {
+		t.Errorf("unbound action still has a help line %q", line)
+	}
+}
@@ -0,0 +1,212 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Image previews are laid out as placeholders: a marker naming the preview
+// on its first row, padded with blanks to the preview's size. Bubbletea
+// measures, truncates and diffs frames as plain text, which would corrupt an
+// image escape sequence inside a line, so the painter blanks the markers out
+// of each frame and draws the images over the blanks once the frame is on
+// screen.
+const (
+	previewMarkerOpen  = '\uE000'
+	previewMarkerClose = '\uE001'
+)
+
+// previewMarker returns the placeholder marker of a preview
+func previewMarker(id int) string {
+	return fmt.Sprintf("%c%d%c", previewMarkerOpen, id, previewMarkerClose)
+}
+
+// stripPreviewMarkers removes marker characters from untrusted text, so a
+// message cannot place someone else's preview
+func stripPreviewMarkers(s string) string {
+	if !strings.ContainsAny(s, string([]rune{previewMarkerOpen, previewMarkerClose})) {
+		return s
+	}
+	return strings.NewReplacer(string(previewMarkerOpen), "", string(previewMarkerClose), "").Replace(s)
+}
+
+// paintDelay is how long the painter waits for bubbletea to flush a frame
+// before drawing over it; the renderer flushes at 60 frames per second
+const paintDelay = 40 * time.Millisecond
+
+// imagePlacement is a preview found in a frame, at a 0-based cell position
+type imagePlacement struct {
+	preview  *attachmentPreview
+	row, col int
+}
+
+// imagePainter draws image previews over the frames bubbletea renders
+type imagePainter struct {
+	out     io.Writer
+	encoder imageEncoder
+
+	mu      sync.Mutex
+	frame   []string
+	drawn   map[int]imagePlacement // by preview ID
+	dirty   map[int]bool           // previews to draw at the next flush
+	removed []int                  // previews to erase at the next flush
+	timer   *time.Timer
+	stopped bool
+	paused  bool
+}
+
+// newImagePainter creates a painter writing to out, which must be the
+// terminal bubbletea renders to
+func newImagePainter(out io.Writer, encoder imageEncoder) *imagePainter {
+	return &imagePainter{
+		out:     out,
+		encoder: encoder,
+		drawn:   make(map[int]imagePlacement),
+		dirty:   make(map[int]bool),
+	}
+}
+
+// place finds the previews in a frame and blanks their markers out. Images
+// whose cells bubbletea will repaint are drawn again shortly after, and
+// images no longer in the frame are removed.
+func (p *imagePainter) place(frame string, previews map[int]*attachmentPreview) string {
+	lines := strings.Split(frame, "\n")
+	placements := make(map[int]imagePlacement)
+
+	for row, line := range lines {
+		for {
+			open := strings.IndexRune(line, previewMarkerOpen)
+			if open < 0 {
+				break
+			}
+			end := strings.IndexRune(line[open:], previewMarkerClose)
+			if end < 0 {
+				break
+			}
+			end += open + len(string(previewMarkerClose))
+
+			marker := line[open:end]
+			var id int
+			fmt.Sscanf(marker[len(string(previewMarkerOpen)):], "%d", &id)
+			if preview, ok := previews[id]; ok {
+				placements[id] = imagePlacement{preview: preview, row: row, col: lipgloss.Width(line[:open])}
+			}
+
+			line = line[:open] + strings.Repeat(" ", lipgloss.Width(marker)) + line[end:]
+		}
+		lines[row] = line
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.drawn {
+		if _, visible := placements[id]; !visible {
+			p.removed = append(p.removed, id)
+			delete(p.drawn, id)
+			delete(p.dirty, id)
+		}
+	}
+	for id, placement := range placements {
+		if old, drawn := p.drawn[id]; drawn && old == placement && !p.repainted(lines, placement) {
+			continue
+		}
+		p.drawn[id] = placement
+		p.dirty[id] = true
+	}
+	p.frame = lines
+
+	if (len(p.dirty) > 0 || len(p.removed) > 0) && p.timer == nil && !p.stopped && !p.paused {
+		p.timer = time.AfterFunc(paintDelay, p.flush)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// repainted reports whether bubbletea will rewrite any row of a placement,
+// erasing the image drawn there
+func (p *imagePainter) repainted(lines []string, placement imagePlacement) bool {
+	for row := placement.row; row < placement.row+placement.preview.rows; row++ {
+		if row >= len(lines) || row >= len(p.frame) || lines[row] != p.frame[row] {
+			return true
+		}
+	}
+	return false
+}
+
+// flush erases removed previews and draws changed ones where the latest
+// frame placed them
+func (p *imagePainter) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.timer = nil
+	if p.stopped || p.paused {
+		return
+	}
+
+	var b strings.Builder
+	for _, id := range p.removed {
+		b.WriteString(p.encoder.Remove(id))
+	}
+	for id := range p.dirty {
+		placement := p.drawn[id]
+		fmt.Fprintf(&b, "\x1b7\x1b[%d;%dH%s\x1b8", placement.row+1, placement.col+1, placement.preview.image)
+	}
+	p.removed = nil
+	p.dirty = make(map[int]bool)
+
+	if b.Len() > 0 {
+		io.WriteString(p.out, b.String())
+	}
+}
+
+// stop cancels drawing that has not happened yet. It is called before the
+// program quits, so nothing is drawn over the restored terminal.
+func (p *imagePainter) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	p.clear()
+}
+
+// pause erases the images and draws nothing until resume. It is called
+// while another program, such as an editor, has the terminal.
+func (p *imagePainter) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused = true
+	p.clear()
+}
+
+// resume draws again, with every image in the next frame drawn anew as the
+// terminal was repainted
+func (p *imagePainter) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused = false
+	p.frame = nil
+}
+
+// clear cancels pending drawing and erases the images drawn. The caller
+// must hold p.mu.
+func (p *imagePainter) clear() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	for id := range p.drawn {
+		io.WriteString(p.out, p.encoder.Remove(id))
+	}
+	p.drawn = make(map[int]imagePlacement)
+	p.dirty = make(map[int]bool)
+	p.removed = nil
+}
@@ -0,0 +1,269 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Action is a logical command that can be bound to keys
+type Action string
+
+const (
+	// Global actions, available in every view
+	ActionQuit         Action = "quit"
+	ActionShowChat     Action = "show_chat"
+	ActionShowContacts Action = "show_contacts"
+	ActionShowSettings Action = "show_settings"
+	ActionShowHelp     Action = "show_help"
+	ActionShowOutbox   Action = "show_outbox"
+	ActionBack         Action = "back"
+	ActionInvisible    Action = "toggle_invisible"
+
+	// Text input, in the chat view and while searching or editing
+	ActionSend           Action = "send"
+	ActionDeleteBackward Action = "delete_backward"
+	ActionCursorLeft     Action = "cursor_left"
+	ActionCursorRight    Action = "cursor_right"
+	ActionOpenEditor     Action = "open_editor"
+
+	// Chat view
+	ActionScrollUp   Action = "scroll_up"
+	ActionScrollDown Action = "scroll_down"
+	ActionClearChat  Action = "clear_chat"
+	ActionConfirm    Action = "confirm"
+	ActionDecline    Action = "decline"
+
+	// Message actions in the chat view
+	ActionSelectPrevMessage Action = "select_prev_message"
+	ActionSelectNextMessage Action = "select_next_message"
+	ActionForward           Action = "forward"
+
+	// Searching the chat's messages
+	ActionSearchMessages Action = "search_messages"
+
+	// List navigation in the contacts, settings and help views
+	ActionUp           Action = "up"
+	ActionDown         Action = "down"
+	ActionPrevSection  Action = "prev_section"
+	ActionNextSection  Action = "next_section"
+	ActionCycleSection Action = "cycle_section"
+	ActionTop          Action = "top"
+	ActionBottom       Action = "bottom"
+	ActionSelect       Action = "select"
+	ActionToggle       Action = "toggle"
+
+	// Contacts view, and searching settings
+	ActionSearch        Action = "search"
+	ActionAddContact    Action = "add_contact"
+	ActionEditContact   Action = "edit_contact"
+	ActionRemoveContact Action = "remove_contact"
+
+	// Outbox view
+	ActionCancelQueued Action = "cancel_queued"
+)
+
+// actionInfo describes an action: where it applies, what it does, and the
+// keys it is bound to by default. Actions with no views are global.
+type actionInfo struct {
+	action      Action
+	views       []ViewType
+	description string
+	defaults    []string
+}
+
+// actions lists every bindable action in the order the help view shows them
+var actions = []actionInfo{
+	{ActionQuit, nil, "Quit SecureChat", []string{"ctrl+q", "ctrl+c"}},
+	{ActionShowChat, nil, "Switch to chat view", []string{"f1"}},
+	{ActionShowContacts, nil, "Switch to contacts view", []string{"f2"}},
+	{ActionShowSettings, nil, "Open settings", []string{"ctrl+,"}},
+	{ActionShowHelp, nil, "Show this help", []string{"ctrl+/"}},
+	{ActionShowOutbox, nil, "Review messages waiting to be sent", []string{"f3"}},
+	{ActionBack, nil, "Go back to the previous view, or cancel search and editing", []string{"esc"}},
+	{ActionInvisible, nil, "Appear offline, or visible again", []string{"ctrl+o"}},
+
+	{ActionSend, []ViewType{ViewChat}, "Send message", []string{"enter"}},
+	{ActionDeleteBackward, []ViewType{ViewChat, ViewContacts, ViewSettings}, "Delete the character before the cursor", []string{"backspace"}},
+	{ActionCursorLeft, []ViewType{ViewChat}, "Move the cursor left", []string{"left"}},
+	{ActionCursorRight, []ViewType{ViewChat}, "Move the cursor right", []string{"right"}},
+	{ActionOpenEditor, []ViewType{ViewChat}, "Write the message in $VISUAL or $EDITOR", []string{"ctrl+x"}},
+	{ActionScrollUp, []ViewType{ViewChat}, "Scroll up, loading older history at the top", []string{"up"}},
+	{ActionScrollDown, []ViewType{ViewChat}, "Scroll down", []string{"down"}},
+	{ActionClearChat, []ViewType{ViewChat}, "Clear chat history", []string{"ctrl+l"}},
+	{ActionConfirm, []ViewType{ViewChat}, "Answer yes to a prompt", []string{"y"}},
+	{ActionDecline, []ViewType{ViewChat}, "Answer no to a prompt", []string{"n"}},
+	{ActionSelectPrevMessage, []ViewType{ViewChat}, "Select the previous message", []string{"shift+up"}},
+	{ActionSelectNextMessage, []ViewType{ViewChat}, "Select the next message", []string{"shift+down"}},
+	{ActionForward, []ViewType{ViewChat}, "Forward the selected message to another contact", []string{"ctrl+g"}},
+	{ActionSearchMessages, []ViewType{ViewChat}, "Search the chat's messages, or edit the search", []string{"ctrl+f"}},
+
+	{ActionUp, []ViewType{ViewContacts, ViewSettings, ViewHelp, ViewOutbox}, "Move up", []string{"up", "k"}},
+	{ActionDown, []ViewType{ViewContacts, ViewSettings, ViewHelp, ViewOutbox}, "Move down", []string{"down", "j"}},
+	{ActionPrevSection, []ViewType{ViewSettings, ViewHelp}, "Previous section", []string{"left", "h"}},
+	{ActionNextSection, []ViewType{ViewSettings, ViewHelp}, "Next section", []string{"right", "l"}},
+	{ActionCycleSection, []ViewType{ViewSettings, ViewHelp}, "Cycle through sections", []string{"tab"}},
+	{ActionTop, []ViewType{ViewHelp}, "Scroll to the top", []string{"home"}},
+	{ActionBottom, []ViewType{ViewChat, ViewHelp}, "Scroll to the bottom, or to new messages", []string{"end"}},
+	{ActionSelect, []ViewType{ViewContacts, ViewSettings}, "Open the selected item, or confirm search and editing", []string{"enter"}},
+	{ActionToggle, []ViewType{ViewContacts, ViewSettings}, "Toggle the selected item", []string{" "}},
+
+	{ActionSearch, []ViewType{ViewContacts, ViewSettings}, "Search contacts or settings", []string{"/"}},
+	{ActionAddContact, []ViewType{ViewContacts}, "Add new contact", []string{"ctrl+a"}},
+	{ActionEditContact, []ViewType{ViewContacts}, "Edit selected contact", []string{"ctrl+e"}},
+	{ActionRemoveContact, []ViewType{ViewContacts}, "Remove selected contact", []string{"delete", "x"}},
+
+	{ActionCancelQueued, []ViewType{ViewOutbox}, "Cancel the selected message before it is sent", []string{"delete", "x"}},
+}
+
+// KeyMap maps actions to the keys that trigger them. Keys are written the
+// way bubbletea names them, such as "ctrl+q", "f1" or "enter".
+type KeyMap struct {
+	bindings map[Action][]string
+}
+
+// DefaultKeyMap returns the built-in key bindings
+func DefaultKeyMap() *KeyMap {
+	k := &KeyMap{bindings: make(map[Action][]string, len(actions))}
+	for _, info := range actions {
+		k.bindings[info.action] = append([]string(nil), info.defaults...)
+	}
+	return k
+}
+
+// NewKeyMap returns the default key bindings with the given actions rebound.
+// Each override replaces all default keys of its action, and an empty one
+// unbinds it. It fails if an override names an unknown action, unbinds quit,
+// or leaves two actions sharing a key in the same view.
+func NewKeyMap(overrides map[string][]string) (*KeyMap, error) {
+	k := DefaultKeyMap()
+
+	for name, keys := range overrides {
+		action := Action(name)
+		if _, known := k.bindings[action]; !known {
+			return nil, fmt.Errorf("unknown key binding action: %s", name)
+		}
+		if len(keys) == 0 && action == ActionQuit {
+			return nil, fmt.Errorf("action %s must keep at least one key", name)
+		}
+		for _, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("empty key bound to action %s", name)
+			}
+		}
+		k.bindings[action] = append([]string(nil), keys...)
+	}
+
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Validate reports keys bound to more than one action in the same view.
+// Global actions count as part of every view.
+func (k *KeyMap) Validate() error {
+	var conflicts []string
+	for _, view := range []ViewType{ViewChat, ViewContacts, ViewSettings, ViewHelp, ViewOutbox} {
+		owners := make(map[string]Action)
+		for _, info := range actions {
+			if !info.appliesTo(view) {
+				continue
+			}
+			for _, key := range k.bindings[info.action] {
+				if owner, taken := owners[key]; taken && owner != info.action {
+					conflicts = append(conflicts, fmt.Sprintf("%q is bound to both %s and %s in the %s view", key, owner, info.action, view))
+					continue
+				}
+				owners[key] = info.action
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("conflicting key bindings: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// Matches reports whether msg is one of the keys bound to action
+func (k *KeyMap) Matches(msg tea.KeyMsg, action Action) bool {
+	key := msg.String()
+	for _, bound := range k.bindings[action] {
+		if bound == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys bound to action
+func (k *KeyMap) Keys(action Action) []string {
+	return k.bindings[action]
+}
+
+// Help returns the keys bound to action formatted for display, such as
+// "Ctrl+Q/Ctrl+C"
+func (k *KeyMap) Help(action Action) string {
+	keys := k.bindings[action]
+	if len(keys) == 0 {
+		return "unbound"
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = keyDisplayName(key)
+	}
+	return strings.Join(names, "/")
+}
+
+// appliesTo reports whether the action is available in view
+func (info actionInfo) appliesTo(view ViewType) bool {
+	if len(info.views) == 0 {
+		return true
+	}
+	for _, v := range info.views {
+		if v == view {
+			return true
+		}
+	}
+	return false
+}
+
+// keyDisplayNames holds the display names of keys that are not simply
+// capitalized
+var keyDisplayNames = map[string]string{
+	" ":         "Space",
+	"up":        "↑",
+	"down":      "↓",
+	"left":      "←",
+	"right":     "→",
+	"pgup":      "PgUp",
+	"pgdown":    "PgDn",
+	"delete":    "Del",
+	"backspace": "Backspace",
+}
+
+// keyDisplayName formats a bubbletea key name for display, such as "Ctrl+Q"
+// for "ctrl+q"
+func keyDisplayName(key string) string {
+	if name, ok := keyDisplayNames[key]; ok {
+		return name
+	}
+	if len(key) == 1 {
+		return key
+	}
+
+	parts := strings.Split(key, "+")
+	for i, part := range parts {
+		if name, ok := keyDisplayNames[part]; ok {
+			parts[i] = name
+		} else if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "+")
+}
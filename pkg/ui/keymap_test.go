@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func TestRemappedKeyTriggersAction(t *testing.T) {
+	cfg := config.Default()
+	cfg.UI.KeyBindings = map[string][]string{string(ActionShowContacts): {"f5"}}
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	app.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	app.Update(tea.KeyMsg{Type: tea.KeyF2})
+	if app.currentView != ViewChat {
+		t.Errorf("the replaced default key switched to the %s view", app.currentView)
+	}
+	app.Update(tea.KeyMsg{Type: tea.KeyF5})
+	if app.currentView != ViewContacts {
+		t.Errorf("view after the remapped key = %s, want contacts", app.currentView)
+	}
+
+	help := NewHelpView(cfg, getTheme("dark"), app.keys)
+	help.Update(tea.WindowSizeMsg{Width: 120, Height: 200})
+	if view := help.View(); !strings.Contains(view, "F5") {
+		t.Errorf("help does not show the remapped key:\n%s", view)
+	}
+}
+
+func TestNewKeyMapReportsConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string][]string
+		wantErr   string
+	}{
+		{"same key twice in one view", map[string][]string{"clear_chat": {"ctrl+x"}}, `"ctrl+x" is bound to both`},
+		{"global key taken in a view", map[string][]string{"add_contact": {"f1"}}, `"f1" is bound to both show_chat and add_contact in the contacts view`},
+		{"unknown action", map[string][]string{"self_destruct": {"f9"}}, "unknown key binding action"},
+		{"quit unbound", map[string][]string{"quit": {}}, "must keep at least one key"},
+		{"empty key", map[string][]string{"forward": {""}}, "empty key"},
+		{"same key in different views", map[string][]string{"cancel_queued": {"ctrl+l"}}, ""},
+		{"action unbound", map[string][]string{"show_outbox": {}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyMap(tt.overrides)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("NewKeyMap: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("NewKeyMap: %v, want an error containing %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := config.Default()
+	cfg.UI.KeyBindings = map[string][]string{"add_contact": {"f1"}}
+	if _, err := NewApp(cfg); err == nil {
+		t.Error("NewApp accepted conflicting key bindings")
+	}
+}
+
+func TestKeyMapHelp(t *testing.T) {
+	keys := DefaultKeyMap()
+	tests := []struct {
+		action Action
+		want   string
+	}{
+		{ActionQuit, "Ctrl+Q/Ctrl+C"},
+		{ActionUp, "↑/k"},
+		{ActionToggle, "Space"},
+		{ActionShowSettings, "Ctrl+,"},
+	}
+	for _, tt := range tests {
+		if got := keys.Help(tt.action); got != tt.want {
+			t.Errorf("Help(%s) = %q, want %q", tt.action, got, tt.want)
+		}
+	}
+
+	unbound, err := NewKeyMap(map[string][]string{"show_outbox": {}})
+	if err != nil {
+		t.Fatalf("NewKeyMap: %v", err)
+	}
+	if got := unbound.Help(ActionShowOutbox); got != "unbound" {
+		t.Errorf("Help of an unbound action = %q, want unbound", got)
+	}
+}
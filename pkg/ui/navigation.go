@@ -0,0 +1,63 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// backHandler is implemented by views with nested state, such as a search
+// or an edit in progress, that the back key leaves before the view itself
+type backHandler interface {
+	// handleBack leaves the innermost nested state, reporting false if
+	// there was none
+	handleBack() bool
+}
+
+// showView switches to a view, remembering the one left so the back key
+// returns to it. The chat is the root: showing it forgets every view left
+// on the way. Showing a view already on the stack returns to it, so going
+// around in circles does not grow the stack.
+func (a *App) showView(view ViewType) tea.Cmd {
+	switch {
+	case view == ViewChat:
+		a.history = nil
+	case view == a.currentView:
+	default:
+		a.history = append(a.history, a.currentView)
+		for i, previous := range a.history {
+			if previous == view {
+				a.history = a.history[:i]
+				break
+			}
+		}
+	}
+	a.currentView = view
+	return a.views[view].Init()
+}
+
+// back leaves the nested state of the current view, or else returns to the
+// view shown before it. It reports false on the chat with nothing to go
+// back to, leaving the key to the chat.
+func (a *App) back() (tea.Cmd, bool) {
+	if handler, ok := a.views[a.currentView].(backHandler); ok && handler.handleBack() {
+		return nil, true
+	}
+	if a.currentView == ViewChat {
+		return nil, false
+	}
+
+	previous := ViewChat
+	if n := len(a.history); n > 0 {
+		previous = a.history[n-1]
+		a.history = a.history[:n-1]
+	}
+	a.currentView = previous
+	return a.views[previous].Init(), true
+}
+
+// previousView returns the view the back key returns to
+func (a *App) previousView() ViewType {
+	if n := len(a.history); n > 0 {
+		return a.history[n-1]
+	}
+	return ViewChat
+}
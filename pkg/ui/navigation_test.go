@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+func newNavigationApp(t *testing.T) *App {
+	t.Helper()
+
+	app, err := NewApp(config.Default())
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	return app
+}
+
+func TestBackReturnsToPreviousViews(t *testing.T) {
+	app := newNavigationApp(t)
+	app.showView(ViewContacts)
+	app.showView(ViewSettings)
+	app.showView(ViewHelp)
+
+	for _, want := range []ViewType{ViewSettings, ViewContacts, ViewChat} {
+		if _, handled := app.back(); !handled {
+			t.Fatalf("back from %s was not handled", app.currentView)
+		}
+		if app.currentView != want {
+			t.Fatalf("back went to %s, want %s", app.currentView, want)
+		}
+	}
+	if _, handled := app.back(); handled {
+		t.Error("back on the chat with nothing left was handled, want it left to the chat")
+	}
+}
+
+func TestViewHistoryIsBounded(t *testing.T) {
+	app := newNavigationApp(t)
+	views := []ViewType{ViewContacts, ViewSettings, ViewHelp, ViewOutbox}
+	for i := 0; i < 50; i++ {
+		app.showView(views[i%len(views)])
+	}
+	if len(app.history) >= len(views) {
+		t.Errorf("history holds %v after going around in circles, want each view at most once", app.history)
+	}
+
+	// Returning to a view on the stack drops the views above it
+	app.showView(ViewContacts)
+	app.showView(ViewSettings)
+	app.showView(ViewHelp)
+	app.showView(ViewSettings)
+	if got := app.previousView(); got != ViewContacts {
+		t.Errorf("previous view after returning to settings = %s, want contacts", got)
+	}
+
+	// The chat is the root and forgets the rest
+	app.showView(ViewChat)
+	if len(app.history) != 0 {
+		t.Errorf("history after showing the chat = %v, want empty", app.history)
+	}
+}
+
+func TestBackLeavesNestedStateFirst(t *testing.T) {
+	app := newNavigationApp(t)
+	app.showView(ViewContacts)
+	contacts := app.views[ViewContacts].(*ContactsView)
+	contacts.searchActive = true
+	contacts.searchQuery = "bo"
+
+	if _, handled := app.back(); !handled {
+		t.Fatal("back during a search was not handled")
+	}
+	if app.currentView != ViewContacts || contacts.searchActive || contacts.searchQuery != "" {
+		t.Errorf("back during a search left view %s, search %v %q; want the search cancelled on contacts",
+			app.currentView, contacts.searchActive, contacts.searchQuery)
+	}
+
+	app.back()
+	if app.currentView != ViewChat {
+		t.Errorf("second back went to %s, want chat", app.currentView)
+	}
+}
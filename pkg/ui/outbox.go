@@ -0,0 +1,267 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// OutboxLister returns the messages waiting to be sent, oldest first
+type OutboxLister func() ([]*models.Message, error)
+
+// QueuedMessageCanceler removes a message from the outbox before it is
+// sent. Chats are named by the other user's ID.
+type QueuedMessageCanceler func(otherUserID, messageID string) error
+
+// OutboxUpdatedMsg carries the messages waiting to be sent after one was
+// queued, sent or cancelled
+type OutboxUpdatedMsg struct {
+	Messages []*models.Message
+}
+
+// ConnectionStateMsg reports whether any relay is connected and, while
+// none is, whether one is being reconnected
+type ConnectionStateMsg struct {
+	Connected        bool
+	ReconnectAttempt int // Attempt under way, 0 when not reconnecting
+}
+
+// ConnectionQualityMsg reports the quality of the relay connection, graded
+// by the round-trip time of its pings
+type ConnectionQualityMsg struct {
+	RTT      time.Duration // Smoothed round-trip time, 0 while unknown
+	Level    string        // "good", "fair", "poor" or "unknown"
+	Degraded bool          // The round trips have been slow for a while
+}
+
+// queuedMessageCanceledMsg reports the result of cancelling a queued message
+type queuedMessageCanceledMsg struct {
+	err error
+}
+
+// outboxLoadFailedMsg reports that the outbox could not be read
+type outboxLoadFailedMsg struct {
+	err error
+}
+
+// OutboxView lists the messages written while offline that have not been
+// sent yet
+type OutboxView struct {
+	config *config.Config
+	theme  *Theme
+	keys   *KeyMap
+	width  int
+	height int
+
+	messages    []*models.Message
+	selectedIdx int
+	notice      string
+
+	lister   OutboxLister
+	canceler QueuedMessageCanceler
+	location *time.Location // Timezone of displayed times
+}
+
+// NewOutboxView creates a new outbox view
+func NewOutboxView(cfg *config.Config, theme *Theme, keys *KeyMap) *OutboxView {
+	return &OutboxView{
+		config:   cfg,
+		theme:    theme,
+		keys:     keys,
+		location: displayLocation(cfg.UI),
+	}
+}
+
+// SetOutboxLister sets the function the view reloads the outbox with
+func (o *OutboxView) SetOutboxLister(lister OutboxLister) {
+	o.lister = lister
+}
+
+// SetQueuedMessageCanceler sets the function the view cancels messages with
+func (o *OutboxView) SetQueuedMessageCanceler(canceler QueuedMessageCanceler) {
+	o.canceler = canceler
+}
+
+// Init implements tea.Model. The outbox is reloaded each time the view is
+// shown.
+func (o *OutboxView) Init() tea.Cmd {
+	if o.lister == nil {
+		return nil
+	}
+	lister := o.lister
+	return func() tea.Msg {
+		messages, err := lister()
+		if err != nil {
+			return outboxLoadFailedMsg{err: err}
+		}
+		return OutboxUpdatedMsg{Messages: messages}
+	}
+}
+
+// Update implements tea.Model
+func (o *OutboxView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		o.width = msg.Width
+		o.height = msg.Height - 2 // Account for status bar
+
+	case OutboxUpdatedMsg:
+		o.setMessages(msg.Messages)
+
+	case outboxLoadFailedMsg:
+		o.notice = "Error: " + msg.err.Error()
+
+	case queuedMessageCanceledMsg:
+		if msg.err != nil {
+			o.notice = "Error: " + msg.err.Error()
+		} else {
+			o.notice = "Message cancelled"
+		}
+
+	case tea.KeyMsg:
+		o.notice = ""
+		switch {
+		case o.keys.Matches(msg, ActionUp):
+			if o.selectedIdx > 0 {
+				o.selectedIdx--
+			}
+
+		case o.keys.Matches(msg, ActionDown):
+			if o.selectedIdx < len(o.messages)-1 {
+				o.selectedIdx++
+			}
+
+		case o.keys.Matches(msg, ActionCancelQueued):
+			return o, o.cancelSelected()
+		}
+	}
+
+	return o, nil
+}
+
+// setMessages replaces the listed messages, keeping the selection on the
+// same message while it is still queued
+func (o *OutboxView) setMessages(messages []*models.Message) {
+	var selectedID string
+	if o.selectedIdx < len(o.messages) {
+		selectedID = o.messages[o.selectedIdx].ID
+	}
+
+	o.messages = messages
+	o.selectedIdx = min(o.selectedIdx, max(len(messages)-1, 0))
+	for i, msg := range messages {
+		if msg.ID == selectedID {
+			o.selectedIdx = i
+			break
+		}
+	}
+}
+
+// cancelSelected cancels the selected message
+func (o *OutboxView) cancelSelected() tea.Cmd {
+	if o.canceler == nil || len(o.messages) == 0 {
+		return nil
+	}
+
+	msg := o.messages[o.selectedIdx]
+	cancel := o.canceler
+	return func() tea.Msg {
+		return queuedMessageCanceledMsg{err: cancel(msg.To, msg.ID)}
+	}
+}
+
+// View implements tea.Model
+func (o *OutboxView) View() string {
+	if o.width == 0 || o.height == 0 {
+		return "Loading outbox..."
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		o.renderHeader(),
+		o.renderList(),
+		o.renderFooter(),
+	)
+}
+
+// renderHeader renders the outbox view header
+func (o *OutboxView) renderHeader() string {
+	style := lipgloss.NewStyle().
+		Background(o.theme.Primary).
+		Foreground(o.theme.Background).
+		Padding(0, 1).
+		Width(o.width)
+
+	return style.Render(fmt.Sprintf("Outbox (%d)", len(o.messages)))
+}
+
+// renderList renders the queued messages
+func (o *OutboxView) renderList() string {
+	listHeight := o.height - 2 // Account for header and footer
+
+	style := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		BorderForeground(o.theme.Border).
+		Width(o.width).
+		Height(listHeight).
+		Padding(1)
+
+	if len(o.messages) == 0 {
+		emptyStyle := lipgloss.NewStyle().
+			Foreground(o.theme.Secondary).
+			Italic(true).
+			Align(lipgloss.Center).
+			Width(o.width - 4).
+			Height(listHeight - 2)
+
+		return style.Render(emptyStyle.Render("Nothing waiting to be sent."))
+	}
+
+	// Each message takes three lines, including the gap below it
+	visible := max((listHeight-2)/3, 1)
+	start := max(0, o.selectedIdx-visible+1)
+	end := min(len(o.messages), start+visible)
+
+	var lines []string
+	for i := start; i < end; i++ {
+		lines = append(lines, o.formatMessage(o.messages[i], i == o.selectedIdx))
+	}
+	return style.Render(strings.Join(lines, "\n\n"))
+}
+
+// formatMessage formats a queued message with its recipient, status and
+// the start of its text
+func (o *OutboxView) formatMessage(msg *models.Message, selected bool) string {
+	style := lipgloss.NewStyle().Foreground(o.theme.Foreground)
+	if selected {
+		style = style.Background(o.theme.Highlight).Padding(0, 1)
+	}
+
+	when := formatTimestamp(msg.Timestamp, time.Now(), o.config.UI, o.location)
+	header := fmt.Sprintf("To %s · %s · %s", msg.To, when, msg.Status)
+	preview := lipgloss.NewStyle().
+		Foreground(o.theme.Secondary).
+		Render(truncateString(strings.ReplaceAll(msg.Content, "\n", " "), min(o.config.UI.PreviewLength, max(o.width-8, 10))))
+
+	return style.Render(lipgloss.JoinVertical(lipgloss.Left, header, preview))
+}
+
+// renderFooter renders the notice, or the shortcuts of the view
+func (o *OutboxView) renderFooter() string {
+	style := lipgloss.NewStyle().
+		Background(o.theme.Secondary).
+		Foreground(o.theme.Background).
+		Padding(0, 1).
+		Width(o.width)
+
+	if o.notice != "" {
+		return style.Render(o.notice)
+	}
+	return style.Render(fmt.Sprintf("[%s/%s] Select  [%s] Cancel message  Queued messages are sent when a relay is reachable",
+		o.keys.Help(ActionUp), o.keys.Help(ActionDown), o.keys.Help(ActionCancelQueued)))
+}
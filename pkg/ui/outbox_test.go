@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// queuedMessage returns a message waiting in the outbox to be sent to to
+func queuedMessage(id, to, content string) *models.Message {
+	return &models.Message{ID: id, From: "alice", To: to, Content: content, Status: models.MessageStatusPending, Timestamp: time.Now()}
+}
+
+func TestOutboxListsQueuedMessages(t *testing.T) {
+	o := NewOutboxView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	o.SetOutboxLister(func() ([]*models.Message, error) {
+		return []*models.Message{queuedMessage("m1", "bob", "see you\nat noon"), queuedMessage("m2", "carol", "running late")}, nil
+	})
+	o.Update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	o.Update(o.Init()())
+
+	view := o.View()
+	for _, want := range []string{"Outbox (2)", "To bob", "see you at noon", "To carol", "running late", string(models.MessageStatusPending)} {
+		if !strings.Contains(view, want) {
+			t.Errorf("outbox view does not show %q", want)
+		}
+	}
+
+	// Draining updates the list in place
+	o.Update(OutboxUpdatedMsg{})
+	if view := o.View(); !strings.Contains(view, "Outbox (0)") || !strings.Contains(view, "Nothing waiting to be sent.") {
+		t.Error("the emptied outbox is not shown as empty")
+	}
+}
+
+func TestOutboxCancelsSelectedMessage(t *testing.T) {
+	o := NewOutboxView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	var cancelled []string
+	cancelErr := error(nil)
+	o.SetQueuedMessageCanceler(func(otherUserID, messageID string) error {
+		cancelled = append(cancelled, otherUserID+"/"+messageID)
+		return cancelErr
+	})
+	o.Update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	o.Update(OutboxUpdatedMsg{Messages: []*models.Message{
+		queuedMessage("m1", "bob", "first"),
+		queuedMessage("m2", "carol", "second"),
+		queuedMessage("m3", "bob", "third"),
+	}})
+
+	o.Update(tea.KeyMsg{Type: tea.KeyDown})
+	_, cmd := o.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if cmd == nil {
+		t.Fatal("cancelling did not start")
+	}
+	o.Update(cmd())
+	if got := strings.Join(cancelled, ","); got != "carol/m2" {
+		t.Fatalf("cancelled %s, want carol/m2", got)
+	}
+	if !strings.Contains(o.View(), "Message cancelled") {
+		t.Error("the cancellation is not confirmed")
+	}
+
+	// The selection stays on the same message as the list changes
+	o.Update(OutboxUpdatedMsg{Messages: []*models.Message{queuedMessage("m1", "bob", "first"), queuedMessage("m3", "bob", "third")}})
+	o.Update(tea.KeyMsg{Type: tea.KeyDown})
+	o.Update(OutboxUpdatedMsg{Messages: []*models.Message{queuedMessage("m3", "bob", "third")}})
+	cancelErr = errors.New("message m3 is not queued")
+	_, cmd = o.Update(tea.KeyMsg{Type: tea.KeyDelete})
+	o.Update(cmd())
+	if got := cancelled[len(cancelled)-1]; got != "bob/m3" {
+		t.Errorf("cancelled %s, want bob/m3", got)
+	}
+	if !strings.Contains(o.View(), "Error: message m3 is not queued") {
+		t.Error("a failed cancellation is not shown")
+	}
+}
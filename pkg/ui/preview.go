@@ -0,0 +1,376 @@
+package ui
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for image attachments
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// AttachmentOpener opens the local copy of a received attachment
+type AttachmentOpener func(attachment *models.Attachment) (io.ReadCloser, error)
+
+// imageProtocol is a terminal graphics protocol for drawing images inline
+type imageProtocol string
+
+const (
+	imageProtocolNone   imageProtocol = "none"
+	imageProtocolKitty  imageProtocol = "kitty"
+	imageProtocolITerm2 imageProtocol = "iterm2"
+	imageProtocolSixel  imageProtocol = "sixel"
+)
+
+// sixelTerminals are values of TERM for terminals known to draw sixel graphics
+var sixelTerminals = map[string]bool{
+	"foot":          true,
+	"foot-extra":    true,
+	"mlterm":        true,
+	"yaft-256color": true,
+	"contour":       true,
+}
+
+// detectImageProtocol picks the image protocol supported by the terminal,
+// judging by its environment. SECURECHAT_IMAGES overrides detection with
+// "kitty", "iterm2", "sixel" or "none".
+func detectImageProtocol(getenv func(string) string) imageProtocol {
+	switch forced := imageProtocol(strings.ToLower(getenv("SECURECHAT_IMAGES"))); forced {
+	case imageProtocolNone, imageProtocolKitty, imageProtocolITerm2, imageProtocolSixel:
+		return forced
+	}
+
+	// Multiplexers swallow graphics sequences unless specially configured
+	if getenv("TMUX") != "" || strings.HasPrefix(getenv("TERM"), "screen") {
+		return imageProtocolNone
+	}
+
+	term := getenv("TERM")
+	switch {
+	case term == "xterm-kitty" || getenv("KITTY_WINDOW_ID") != "":
+		return imageProtocolKitty
+	case getenv("TERM_PROGRAM") == "iTerm.app" || getenv("LC_TERMINAL") == "iTerm2",
+		getenv("TERM_PROGRAM") == "WezTerm":
+		return imageProtocolITerm2
+	case sixelTerminals[term] || strings.Contains(term, "sixel"):
+		return imageProtocolSixel
+	}
+
+	return imageProtocolNone
+}
+
+// imageEncoder encodes images as escape sequences for one protocol
+type imageEncoder interface {
+	// Encode returns a sequence drawing img over cols by rows cells at the
+	// cursor. Drawing the same id again replaces the earlier image.
+	Encode(id int, img image.Image, cols, rows int) (string, error)
+	// Remove returns a sequence erasing the image drawn with id, or "" if
+	// overwriting its cells with text is enough
+	Remove(id int) string
+}
+
+// newImageEncoder returns the encoder for a protocol, or nil if images
+// cannot be drawn
+func newImageEncoder(protocol imageProtocol) imageEncoder {
+	switch protocol {
+	case imageProtocolKitty:
+		return kittyEncoder{}
+	case imageProtocolITerm2:
+		return iterm2Encoder{}
+	case imageProtocolSixel:
+		return sixelEncoder{}
+	}
+	return nil
+}
+
+// Bounds on inline previews
+const (
+	minPreviewCols      = 8
+	maxPreviewCols      = 40
+	maxPreviewRows      = 6
+	maxPreviewFileSize  = 16 << 20
+	maxPreviewPixels    = 40_000_000
+	previewCellWidthPx  = 10
+	previewCellHeightPx = 20
+)
+
+// isPreviewableImage reports whether an attachment is an image that can be
+// decoded for a preview
+func isPreviewableImage(attachment *models.Attachment) bool {
+	switch attachment.MimeType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	case "":
+		switch strings.ToLower(filepath.Ext(attachment.Filename)) {
+		case ".png", ".jpg", ".jpeg", ".gif":
+			return true
+		}
+	}
+	return false
+}
+
+// decodePreview reads an image and scales it down to fit maxCols cells,
+// returning the scaled image and the cells it covers
+func decodePreview(r io.Reader, maxCols int) (image.Image, int, int, error) {
+	// Check the dimensions before decoding, so a small file cannot expand
+	// into an enormous bitmap
+	br := bufio.NewReaderSize(r, 64<<10)
+	header, err := br.Peek(64 << 10)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, 0, 0, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		// Without its dimensions there is no telling how large it decodes to
+		return nil, 0, 0, fmt.Errorf("cannot read image dimensions: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPreviewPixels {
+		return nil, 0, 0, fmt.Errorf("image too large to preview (%dx%d)", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(br)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	cols, rows := previewCells(img.Bounds().Dx(), img.Bounds().Dy(), maxCols)
+	return scaleImage(img, cols*previewCellWidthPx, rows*previewCellHeightPx), cols, rows, nil
+}
+
+// previewCells returns the cells an image of the given size covers when
+// scaled to fit within maxCols by maxPreviewRows, keeping its aspect ratio
+func previewCells(width, height, maxCols int) (int, int) {
+	cols := min(maxCols, maxPreviewCols)
+	if width <= 0 || height <= 0 || cols <= 0 {
+		return 1, 1
+	}
+
+	rows := (cols*previewCellWidthPx*height + width*previewCellHeightPx - 1) / (width * previewCellHeightPx)
+	if rows > maxPreviewRows {
+		rows = maxPreviewRows
+		cols = rows * previewCellHeightPx * width / (height * previewCellWidthPx)
+	}
+
+	return max(cols, 1), max(rows, 1)
+}
+
+// scaleImage resizes an image to fit within width by height pixels with
+// nearest-neighbour sampling, keeping its aspect ratio
+func scaleImage(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width && bounds.Dy() <= height {
+		return img
+	}
+
+	scale := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w, h := max(int(float64(bounds.Dx())*scale), 1), max(int(float64(bounds.Dy())*scale), 1)
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/h
+		for x := 0; x < w; x++ {
+			scaled.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/w, sy))
+		}
+	}
+	return scaled
+}
+
+// encodePNG encodes an image as base64 PNG data
+func encodePNG(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// kittyChunkSize is the largest payload the kitty protocol accepts per escape
+const kittyChunkSize = 4096
+
+// kittyEncoder draws images with the kitty graphics protocol
+type kittyEncoder struct{}
+
+func (kittyEncoder) Encode(id int, img image.Image, cols, rows int) (string, error) {
+	data, err := encodePNG(img)
+	if err != nil {
+		return "", err
+	}
+
+	// q=2 keeps the terminal from answering, since replies would arrive as
+	// key presses; C=1 leaves the cursor in place
+	var b strings.Builder
+	first := true
+	for len(data) > 0 {
+		chunk := data[:min(kittyChunkSize, len(data))]
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+
+		if first {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,q=2,C=1,i=%d,p=1,c=%d,r=%d,m=%d;%s\x1b\\", id, cols, rows, more, chunk)
+			first = false
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String(), nil
+}
+
+// Remove deletes the image, since kitty draws images above the text layer
+// and repainting the cells does not erase them
+func (kittyEncoder) Remove(id int) string {
+	return fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", id)
+}
+
+// iterm2Encoder draws images with iTerm2's inline image protocol
+type iterm2Encoder struct{}
+
+func (iterm2Encoder) Encode(id int, img image.Image, cols, rows int) (string, error) {
+	data, err := encodePNG(img)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("\x1b]1337;File=inline=1;width=%d;height=%d;preserveAspectRatio=1:%s\a",
+		cols, rows, data), nil
+}
+
+func (iterm2Encoder) Remove(id int) string {
+	return ""
+}
+
+// sixelEncoder draws images as DEC sixel graphics, quantized to a 6x6x6
+// colour cube
+type sixelEncoder struct{}
+
+func (sixelEncoder) Encode(id int, img image.Image, cols, rows int) (string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Palette index of every pixel, or -1 where it is transparent
+	pixels := make([]int, width*height)
+	used := make([]bool, 216)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			if a < 0x8000 {
+				pixels[y*width+x] = -1
+				continue
+			}
+			index := int(r*5/0xffff)*36 + int(g*5/0xffff)*6 + int(b*5/0xffff)
+			pixels[y*width+x] = index
+			used[index] = true
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	for index, inUse := range used {
+		if inUse {
+			fmt.Fprintf(&b, "#%d;2;%d;%d;%d", index, index/36*20, index/6%6*20, index%6*20)
+		}
+	}
+
+	for top := 0; top < height; top += 6 {
+		for index, inUse := range used {
+			if !inUse {
+				continue
+			}
+
+			var band strings.Builder
+			drawn := false
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && top+dy < height; dy++ {
+					if pixels[(top+dy)*width+x] == index {
+						bits |= 1 << dy
+					}
+				}
+				drawn = drawn || bits != 0
+				band.WriteByte('?' + bits)
+			}
+			if drawn {
+				fmt.Fprintf(&b, "#%d%s$", index, sixelRunLength(band.String()))
+			}
+		}
+		b.WriteByte('-')
+	}
+
+	b.WriteString("\x1b\\")
+	return b.String(), nil
+}
+
+func (sixelEncoder) Remove(id int) string {
+	return ""
+}
+
+// sixelRunLength compresses runs of repeated sixels
+func sixelRunLength(band string) string {
+	var b strings.Builder
+	for i := 0; i < len(band); {
+		j := i
+		for j < len(band) && band[j] == band[i] {
+			j++
+		}
+		if run := j - i; run > 3 {
+			fmt.Fprintf(&b, "!%d%c", run, band[i])
+		} else {
+			b.WriteString(band[i:j])
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// attachmentPreview is the inline preview of an image attachment. Its image
+// is empty while it loads, and stays empty if the attachment cannot be
+// previewed.
+type attachmentPreview struct {
+	id     int    // Numbers the placeholder marker and the terminal image
+	image  string // Escape sequence drawing the image
+	cols   int
+	rows   int
+	failed bool
+}
+
+// AttachmentPreviewsLoadedMsg delivers previews decoded for image
+// attachments, keyed by attachment ID
+type AttachmentPreviewsLoadedMsg struct {
+	Previews map[string]*attachmentPreview
+}
+
+// loadPreview decodes an attachment and encodes it for the terminal
+func loadPreview(open AttachmentOpener, encoder imageEncoder, attachment *models.Attachment, id, maxCols int) (*attachmentPreview, error) {
+	file, err := open(attachment)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, cols, rows, err := decodePreview(io.LimitReader(file, maxPreviewFileSize), maxCols)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", attachment.Filename, err)
+	}
+
+	encoded, err := encoder.Encode(id, img, cols, rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", attachment.Filename, err)
+	}
+
+	return &attachmentPreview{id: id, image: encoded, cols: cols, rows: rows}, nil
+}
+
+// attachmentLine is the text shown for an attachment without a preview
+func attachmentLine(attachment *models.Attachment) string {
+	return fmt.Sprintf("📎 %s (%s)", attachment.Filename, formatFileSize(attachment.Size))
+}
@@ -0,0 +1,210 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// testPNG returns a PNG of the given size
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withDimensions rewrites the size a PNG declares in its header, keeping
+// the header's checksum valid
+func withDimensions(data []byte, width, height uint32) []byte {
+	data = bytes.Clone(data)
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// jpegWithPadding returns a JPEG whose dimensions come after comment
+// segments of padding bytes in all
+func jpegWithPadding(t *testing.T, padding int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Comment segments go right after the start-of-image marker
+	padded := append([]byte(nil), encoded[:2]...)
+	for padding > 0 {
+		n := min(padding, 0xffff-2)
+		padded = append(padded, 0xff, 0xfe)
+		padded = binary.BigEndian.AppendUint16(padded, uint16(n+2))
+		padded = append(padded, bytes.Repeat([]byte{' '}, n)...)
+		padding -= n
+	}
+	return append(padded, encoded[2:]...)
+}
+
+func TestDecodePreview(t *testing.T) {
+	img, cols, rows, err := decodePreview(bytes.NewReader(testPNG(t, 200, 100)), 20)
+	if err != nil {
+		t.Fatalf("decodePreview: %v", err)
+	}
+	if cols != 20 || rows < 1 || rows > maxPreviewRows {
+		t.Errorf("cells = %dx%d, want 20 columns and 1 to %d rows", cols, rows, maxPreviewRows)
+	}
+	if got := img.Bounds().Dx(); got != cols*previewCellWidthPx {
+		t.Errorf("scaled width = %d, want %d", got, cols*previewCellWidthPx)
+	}
+}
+
+func TestDecodePreviewRejectsUnreadableImages(t *testing.T) {
+	valid := testPNG(t, 4, 4)
+	tests := map[string][]byte{
+		"not an image":     []byte("hello, this is not an image"),
+		"truncated header": valid[:20],
+		"too large":        withDimensions(valid, 100_000, 100_000),
+		"overflowing size": withDimensions(valid, 1<<31-1, 1<<31-1),
+		// image.Decode would read this one, with no limit on its size
+		"dimensions past the header": jpegWithPadding(t, 100<<10),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, _, err := decodePreview(bytes.NewReader(data), 20); err == nil {
+				t.Error("decodePreview succeeded")
+			}
+		})
+	}
+}
+
+func TestDetectImageProtocol(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want imageProtocol
+	}{
+		{"plain terminal", map[string]string{"TERM": "xterm-256color"}, imageProtocolNone},
+		{"kitty", map[string]string{"TERM": "xterm-kitty"}, imageProtocolKitty},
+		{"kitty window", map[string]string{"TERM": "xterm-256color", "KITTY_WINDOW_ID": "1"}, imageProtocolKitty},
+		{"iTerm2", map[string]string{"TERM_PROGRAM": "iTerm.app"}, imageProtocolITerm2},
+		{"iTerm2 over ssh", map[string]string{"LC_TERMINAL": "iTerm2"}, imageProtocolITerm2},
+		{"WezTerm", map[string]string{"TERM_PROGRAM": "WezTerm"}, imageProtocolITerm2},
+		{"foot", map[string]string{"TERM": "foot"}, imageProtocolSixel},
+		{"sixel in TERM", map[string]string{"TERM": "xterm-sixel"}, imageProtocolSixel},
+		{"inside tmux", map[string]string{"TERM": "xterm-kitty", "TMUX": "/tmp/tmux"}, imageProtocolNone},
+		{"inside screen", map[string]string{"TERM": "screen-256color", "TERM_PROGRAM": "iTerm.app"}, imageProtocolNone},
+		{"forced", map[string]string{"TERM": "xterm", "TMUX": "/tmp/tmux", "SECURECHAT_IMAGES": "Sixel"}, imageProtocolSixel},
+		{"forced off", map[string]string{"TERM": "xterm-kitty", "SECURECHAT_IMAGES": "none"}, imageProtocolNone},
+		{"unknown override", map[string]string{"TERM": "xterm-kitty", "SECURECHAT_IMAGES": "ascii"}, imageProtocolKitty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.env[key] }
+			if got := detectImageProtocol(getenv); got != tt.want {
+				t.Errorf("detectImageProtocol = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeEncoder draws images as a text sequence naming their ID and size
+type fakeEncoder struct{}
+
+func (fakeEncoder) Encode(id int, img image.Image, cols, rows int) (string, error) {
+	return fmt.Sprintf("<image %d %dx%d>", id, cols, rows), nil
+}
+
+func (fakeEncoder) Remove(id int) string { return "" }
+
+// attachmentChat returns a chat view showing one message per attachment,
+// whose contents are read from files by attachment ID
+func attachmentChat(encoder imageEncoder, files map[string][]byte, attachments ...*models.Attachment) *ChatView {
+	c := NewChatView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	c.images = encoder
+	c.SetAttachmentOpener(func(attachment *models.Attachment) (io.ReadCloser, error) {
+		data, ok := files[attachment.ID]
+		if !ok {
+			return nil, fmt.Errorf("no file for %s", attachment.ID)
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	c.Update(tea.WindowSizeMsg{Width: 80, Height: 40})
+	c.OpenChat("bob")
+
+	var messages []*models.Message
+	for _, attachment := range attachments {
+		messages = append(messages, &models.Message{
+			ID:       "msg-" + attachment.ID,
+			From:     "bob",
+			ChatID:   "alice:bob",
+			Metadata: &models.Metadata{Attachment: attachment},
+		})
+	}
+	c.applyHistory(HistoryLoadedMsg{ChatID: "bob", Messages: messages})
+	return c
+}
+
+func TestAttachmentPreviews(t *testing.T) {
+	photo := &models.Attachment{ID: "a1", Filename: "photo.png", MimeType: "image/png", Size: 1536}
+	broken := &models.Attachment{ID: "a2", Filename: "broken.png", MimeType: "image/png", Size: 10}
+	report := &models.Attachment{ID: "a3", Filename: "report.pdf", MimeType: "application/pdf", Size: 3 << 20}
+	files := map[string][]byte{"a1": testPNG(t, 200, 100), "a2": []byte("not a png")}
+
+	c := attachmentChat(fakeEncoder{}, files, photo, broken, report)
+	runCmd(c, c.fetchPreviews())
+
+	if got := c.renderAttachment(photo); !strings.Contains(got, "📎 photo.png (1.5 KB)") || !strings.Contains(got, previewMarker(c.previews["a1"].id)) {
+		t.Errorf("photo rendered as %q, want its line and a preview", got)
+	}
+	if preview := c.previews["a1"]; preview.cols > maxPreviewCols || preview.rows > maxPreviewRows {
+		t.Errorf("preview covers %dx%d cells, more than %dx%d", preview.cols, preview.rows, maxPreviewCols, maxPreviewRows)
+	}
+	if got, want := c.renderAttachment(broken), attachmentLine(broken); !strings.Contains(got, want) || strings.Contains(got, "\n") {
+		t.Errorf("undecodable image rendered as %q, want only %q", got, want)
+	}
+	if !c.previews["a2"].failed {
+		t.Error("the undecodable image's preview is not marked failed")
+	}
+	if _, fetched := c.previews["a3"]; fetched {
+		t.Error("a preview was fetched for a PDF")
+	}
+	if got := c.renderAttachment(report); !strings.Contains(got, "📎 report.pdf (3.0 MB)") {
+		t.Errorf("PDF rendered as %q, want its name and size", got)
+	}
+}
+
+func TestAttachmentsWithoutImageSupport(t *testing.T) {
+	photo := &models.Attachment{ID: "a1", Filename: "photo.png", MimeType: "image/png", Size: 512}
+	c := attachmentChat(nil, map[string][]byte{"a1": testPNG(t, 20, 20)}, photo)
+
+	if cmd := c.fetchPreviews(); cmd != nil {
+		t.Error("previews were fetched for a terminal without images")
+	}
+	if got := c.renderAttachment(photo); !strings.Contains(got, "📎 photo.png (512 B)") || strings.Contains(got, "\n") {
+		t.Errorf("attachment rendered as %q, want only its name and size", got)
+	}
+}
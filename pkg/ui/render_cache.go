@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// messageRenderKey identifies everything the layout of a rendered message
+// depends on besides its immutable content. A change to any field makes
+// the cached rendering stale.
+type messageRenderKey struct {
+	width     int
+	layout    config.MessageLayout
+	quote     string
+	selected  bool
+	timestamp string
+	countdown string
+	device    string // Shown only while own messages come from several devices
+	highlight string // Words highlighted while the message matches a search
+}
+
+// messageOverlayKey identifies the parts of a rendered message that change
+// after it is shown: the status glyph at the end of its first line and the
+// row of reactions below it. A change to them re-renders only the overlay.
+type messageOverlayKey struct {
+	status    models.MessageStatus
+	reactions string
+}
+
+// renderedMessage is the rendering of one message, kept as the block laid
+// out from its content and the overlay drawn around it
+type renderedMessage struct {
+	key  messageRenderKey
+	head string // First line up to the status glyph
+	body string // Quote and content below the first line
+
+	overlay   messageOverlayKey
+	status    string
+	reactions string // Empty when nobody reacted
+
+	framed string // text as laid out in the message area
+}
+
+// text joins the block and its overlay
+func (r *renderedMessage) text() string {
+	text := r.head + r.status + "\n" + r.body
+	if r.reactions != "" {
+		text += "\n" + r.reactions
+	}
+	return text
+}
+
+// messageRenderCache memoizes styled messages by message ID so unchanged
+// messages are not re-rendered on every update
+type messageRenderCache struct {
+	entries map[string]*renderedMessage
+}
+
+func newMessageRenderCache() *messageRenderCache {
+	return &messageRenderCache{entries: make(map[string]*renderedMessage)}
+}
+
+// get returns the cached rendering of a message if its block is still valid
+// for key. Its overlay may still be stale.
+func (r *messageRenderCache) get(messageID string, key messageRenderKey) (*renderedMessage, bool) {
+	entry, exists := r.entries[messageID]
+	if !exists || entry.key != key {
+		return nil, false
+	}
+	return entry, true
+}
+
+// put stores the rendering of a message
+func (r *messageRenderCache) put(messageID string, rendered *renderedMessage) {
+	r.entries[messageID] = rendered
+}
+
+// invalidate drops the cached rendering of a message
+func (r *messageRenderCache) invalidate(messageID string) {
+	delete(r.entries, messageID)
+}
+
+// reset drops every cached rendering
+func (r *messageRenderCache) reset() {
+	r.entries = make(map[string]*renderedMessage)
+}
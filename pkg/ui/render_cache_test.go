@@ -0,0 +1,179 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// renderedChat returns a chat view of width columns showing count messages
+// alice sent bob
+func renderedChat(width, count int) *ChatView {
+	cfg := config.Default()
+	cfg.User.ID = "alice"
+	c := NewChatView(cfg, getTheme("dark"), DefaultKeyMap())
+	c.Update(tea.WindowSizeMsg{Width: width, Height: 40})
+	c.OpenChat("bob")
+
+	messages := make([]*models.Message, count)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range messages {
+		messages[i] = &models.Message{
+			ID:        fmt.Sprintf("m%d", i+1),
+			Type:      models.MessageTypeChat,
+			From:      "alice",
+			To:        "bob",
+			ChatID:    "alice:bob",
+			Content:   strings.Repeat("a fairly long message that wraps ", 3),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Sequence:  uint64(i + 1),
+			Status:    models.MessageStatusSent,
+		}
+	}
+	c.applyHistory(HistoryLoadedMsg{ChatID: "bob", Messages: messages})
+	return c
+}
+
+func TestRenderCacheReturnsIdenticalOutput(t *testing.T) {
+	c := renderedChat(80, 1)
+	msg := c.messages[0]
+
+	first := c.renderMessage(msg, "")
+	cached := c.rendered.entries[msg.ID]
+	if got := c.renderMessage(msg, ""); got != first {
+		t.Errorf("second rendering differs:\n%s\nwant\n%s", got, first)
+	}
+	if c.rendered.entries[msg.ID] != cached {
+		t.Error("the message was rendered again instead of coming from the cache")
+	}
+
+	// A fresh view renders the same text
+	if uncached := renderedChat(80, 1).renderMessage(msg, ""); uncached != first {
+		t.Errorf("cached rendering differs from an uncached one:\n%s\nwant\n%s", first, uncached)
+	}
+}
+
+func TestRenderCacheInvalidatedOnWidthChange(t *testing.T) {
+	c := renderedChat(80, 1)
+	c.renderMessage(c.messages[0], "")
+
+	c.Update(tea.WindowSizeMsg{Width: 40, Height: 40})
+	if len(c.rendered.entries) != 0 {
+		t.Errorf("%d renderings kept after the width changed", len(c.rendered.entries))
+	}
+	if got, want := c.renderMessage(c.messages[0], ""), renderedChat(40, 1).renderMessage(c.messages[0], ""); got != want {
+		t.Errorf("rendering after resizing:\n%s\nwant\n%s", got, want)
+	}
+
+	// The same width keeps the cache
+	c.Update(tea.WindowSizeMsg{Width: 40, Height: 30})
+	if len(c.rendered.entries) == 0 {
+		t.Error("renderings dropped although the width did not change")
+	}
+}
+
+func TestRenderCacheFollowsStatusChange(t *testing.T) {
+	c := renderedChat(80, 1)
+	msg := c.messages[0]
+	sent := c.renderMessage(msg, "")
+	block := c.rendered.entries[msg.ID].body
+
+	msg.Status = models.MessageStatusFailed
+	failed := c.renderMessage(msg, "")
+	if failed == sent {
+		t.Fatal("rendering did not change with the status")
+	}
+	if !strings.Contains(failed, "failed") {
+		t.Errorf("rendering %q does not show the failure", failed)
+	}
+	if c.rendered.entries[msg.ID].body != block {
+		t.Error("the message body was laid out again for a status change")
+	}
+
+	fresh := renderedChat(80, 1)
+	fresh.messages[0].Status = models.MessageStatusFailed
+	if uncached := fresh.renderMessage(fresh.messages[0], ""); uncached != failed {
+		t.Errorf("rendering after the status change:\n%s\nwant\n%s", failed, uncached)
+	}
+}
+
+func TestReactionUpdatesOnlyItsMessage(t *testing.T) {
+	c := renderedChat(80, 3)
+	render := func() []string {
+		var texts []string
+		for _, msg := range c.messages {
+			texts = append(texts, c.renderMessage(msg, ""))
+		}
+		return texts
+	}
+	before := render()
+	blocks := make(map[string]*renderedMessage)
+	for id, entry := range c.rendered.entries {
+		blocks[id] = entry
+	}
+
+	c.Update(MessageReactionMsg{MessageID: "m2", From: "bob", Emoji: "👍"})
+	c.Update(MessageReactionMsg{MessageID: "m2", From: "alice", Emoji: "👍"})
+	after := render()
+	for _, i := range []int{0, 2} {
+		if after[i] != before[i] {
+			t.Errorf("%s changed:\n%s\nwant\n%s", c.messages[i].ID, after[i], before[i])
+		}
+	}
+	if !strings.HasPrefix(after[1], before[1]+"\n") || !strings.Contains(after[1], "👍 2") {
+		t.Errorf("m2 = %q, want its rendering followed by the reaction row", after[1])
+	}
+	for id, entry := range c.rendered.entries {
+		if entry != blocks[id] {
+			t.Errorf("%s was laid out again", id)
+		}
+	}
+
+	// An update without the reactions, such as a delivery receipt, keeps
+	// them; removing one lowers the count
+	updated := c.messages[1]
+	updated.Metadata = nil
+	updated.Status = models.MessageStatusDelivered
+	c.Update(MessageUpdatedMsg{Message: updated})
+	c.Update(MessageReactionMsg{MessageID: "m2", From: "bob", Emoji: "👍", Removed: true})
+	if got := c.renderMessage(c.messages[1], ""); !strings.Contains(got, "👍 1") {
+		t.Errorf("m2 = %q, want one reaction left", got)
+	}
+	if c.rendered.entries["m2"] != blocks["m2"] {
+		t.Error("m2 was laid out again for a status change")
+	}
+
+	// Reactions to messages that are not loaded are ignored
+	c.Update(MessageReactionMsg{MessageID: "elsewhere", From: "bob", Emoji: "👍"})
+	if got := render(); got[0] != before[0] || got[2] != before[2] {
+		t.Error("a reaction to another message changed the shown ones")
+	}
+}
+
+// BenchmarkRenderMessages compares rendering a 500-message chat from the
+// cache with laying every message out again
+func BenchmarkRenderMessages(b *testing.B) {
+	c := renderedChat(100, 500)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, msg := range c.messages {
+				c.renderMessage(msg, "")
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.rendered.reset()
+			for _, msg := range c.messages {
+				c.renderMessage(msg, "")
+			}
+		}
+	})
+}
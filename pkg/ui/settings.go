@@ -2,17 +2,20 @@ package ui
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
 )
 
 // SettingsView represents the settings interface
 type SettingsView struct {
 	config   *config.Config
 	theme    *Theme
+	keys     *KeyMap
 	width    int
 	height   int
 	
@@ -22,8 +25,32 @@ type SettingsView struct {
 	editMode        bool
 	editValue       string
 	
+	// Settings whose name or description does not contain the filter are
+	// hidden; filtering is set while it is typed
+	filter    string
+	filtering bool
+	
 	// Settings sections
 	sections []SettingsSection
+	
+	// Applies the appear offline setting
+	setVisibility VisibilitySetter
+	
+	// Security overview shown in the security section, looked up each
+	// time the view is shown
+	securityStatus SecurityStatusLookup
+	status         models.SecurityStatus
+	statusLoaded   bool
+}
+
+// VisibilitySetter makes the user appear offline, or visible again, and
+// persists the choice
+type VisibilitySetter func(invisible bool)
+
+// VisibilityChangedMsg tells the settings view that the user appeared
+// offline or visible again elsewhere, such as with the quick toggle
+type VisibilityChangedMsg struct {
+	Invisible bool
 }
 
 // SettingsSection represents a group of related settings
@@ -54,10 +81,11 @@ const (
 )
 
 // NewSettingsView creates a new settings view
-func NewSettingsView(cfg *config.Config, theme *Theme) *SettingsView {
+func NewSettingsView(cfg *config.Config, theme *Theme, keys *KeyMap) *SettingsView {
 	view := &SettingsView{
 		config: cfg,
 		theme:  theme,
+		keys:   keys,
 	}
 	
 	view.sections = view.buildSettingsSections()
@@ -65,9 +93,14 @@ func NewSettingsView(cfg *config.Config, theme *Theme) *SettingsView {
 	return view
 }
 
+// SetVisibilitySetter sets the function that applies the appear offline setting
+func (s *SettingsView) SetVisibilitySetter(setter VisibilitySetter) {
+	s.setVisibility = setter
+}
+
 // Init implements tea.Model
 func (s *SettingsView) Init() tea.Cmd {
-	return nil
+	return s.refreshSecurityStatus()
 }
 
 // Update implements tea.Model
@@ -77,38 +110,49 @@ func (s *SettingsView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		s.width = msg.Width
 		s.height = msg.Height - 2 // Account for status bar
 		
+	case VisibilityChangedMsg:
+		if item := s.findItem(settingAppearOffline); item != nil {
+			item.Value = msg.Invisible
+		}
+		
+	case SecurityStatusMsg:
+		s.status = msg.Status
+		s.statusLoaded = true
+		
+	case ContactUpdatedMsg, ContactAddedMsg, ContactRemovedMsg:
+		return s, s.refreshSecurityStatus()
+		
 	case tea.KeyMsg:
 		if s.editMode {
 			return s.handleEditInput(msg)
 		}
+		if s.filtering {
+			return s.handleFilterInput(msg)
+		}
 		
-		switch msg.String() {
-		case "up", "k":
+		switch {
+		case s.keys.Matches(msg, ActionSearch):
+			s.filtering = true
+			
+		case s.keys.Matches(msg, ActionUp):
 			s.navigateUp()
 			
-		case "down", "j":
+		case s.keys.Matches(msg, ActionDown):
 			s.navigateDown()
 			
-		case "left", "h":
-			if s.selectedSection > 0 {
-				s.selectedSection--
-				s.selectedItem = 0
-			}
+		case s.keys.Matches(msg, ActionPrevSection):
+			s.navigateSection(-1, false)
 			
-		case "right", "l":
-			if s.selectedSection < len(s.sections)-1 {
-				s.selectedSection++
-				s.selectedItem = 0
-			}
+		case s.keys.Matches(msg, ActionNextSection):
+			s.navigateSection(1, false)
 			
-		case "tab":
-			s.selectedSection = (s.selectedSection + 1) % len(s.sections)
-			s.selectedItem = 0
+		case s.keys.Matches(msg, ActionCycleSection):
+			s.navigateSection(1, true)
 			
-		case "enter":
+		case s.keys.Matches(msg, ActionSelect):
 			s.activateCurrentItem()
 			
-		case "space":
+		case s.keys.Matches(msg, ActionToggle):
 			s.toggleCurrentItem()
 		}
 	}
@@ -135,19 +179,34 @@ func (s *SettingsView) View() string {
 	)
 }
 
-// handleEditInput handles keyboard input during edit mode
-func (s *SettingsView) handleEditInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "esc":
+// handleBack cancels an edit in progress, or else clears the filter,
+// keeping the setting it jumped to selected
+func (s *SettingsView) handleBack() bool {
+	switch {
+	case s.editMode:
 		s.editMode = false
 		s.editValue = ""
+	case s.filtering || s.filter != "":
+		s.filtering = false
+		s.filter = ""
+	default:
+		return false
+	}
+	return true
+}
+
+// handleEditInput handles keyboard input during edit mode
+func (s *SettingsView) handleEditInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case s.keys.Matches(msg, ActionBack):
+		s.handleBack()
 		
-	case "enter":
+	case s.keys.Matches(msg, ActionSelect):
 		s.saveEditValue()
 		s.editMode = false
 		s.editValue = ""
 		
-	case "backspace":
+	case s.keys.Matches(msg, ActionDeleteBackward):
 		if len(s.editValue) > 0 {
 			s.editValue = s.editValue[:len(s.editValue)-1]
 		}
@@ -170,6 +229,15 @@ func (s *SettingsView) renderHeader() string {
 		Width(s.width)
 	
 	title := "Settings"
+	switch {
+	case s.filtering:
+		title += "  Search: " + s.filter + "│"
+	case s.filter != "":
+		title += "  Search: " + s.filter
+	}
+	if s.filter != "" && len(s.visibleSettings()) == 0 {
+		title += "  (no matching settings)"
+	}
 	
 	return style.Render(title)
 }
@@ -188,7 +256,22 @@ func (s *SettingsView) renderContent() string {
 	var sections []string
 	
 	for i, section := range s.sections {
-		sectionContent := s.renderSection(section, i == s.selectedSection)
+		// Sections without matching settings are left out while filtering
+		var items []SettingsItem
+		selected := -1
+		for j, item := range section.Items {
+			if s.matchesFilter(item) {
+				if i == s.selectedSection && j == s.selectedItem {
+					selected = len(items)
+				}
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		section.Items = items
+		sectionContent := s.renderSection(section, selected)
 		sections = append(sections, sectionContent)
 	}
 	
@@ -197,8 +280,10 @@ func (s *SettingsView) renderContent() string {
 	return style.Render(content)
 }
 
-// renderSection renders a single settings section
-func (s *SettingsView) renderSection(section SettingsSection, isSelected bool) string {
+// renderSection renders a single settings section, selected is the index
+// of the selected item in it or -1
+func (s *SettingsView) renderSection(section SettingsSection, selected int) string {
+	isSelected := selected >= 0
 	var titleStyle lipgloss.Style
 	if isSelected {
 		titleStyle = lipgloss.NewStyle().
@@ -213,8 +298,13 @@ func (s *SettingsView) renderSection(section SettingsSection, isSelected bool) s
 	title := titleStyle.Render(section.Name)
 	
 	var items []string
+	if section.Name == sectionSecurity && s.filter == "" {
+		if overview := s.renderSecurityStatus(); overview != "" {
+			items = append(items, overview)
+		}
+	}
 	for i, item := range section.Items {
-		itemSelected := isSelected && i == s.selectedItem
+		itemSelected := i == selected
 		itemContent := s.renderItem(item, itemSelected)
 		items = append(items, itemContent)
 	}
@@ -297,7 +387,19 @@ func (s *SettingsView) renderFooter() string {
 		Padding(0, 1).
 		Width(s.width)
 	
-	shortcuts := "[Tab] Next section  [Enter] Edit  [Space] Toggle  [Esc] Back to chat"
+	shortcuts := fmt.Sprintf("[%s] Search  [%s] Next section  [%s] Edit  [%s] Toggle  [%s] Back",
+		s.keys.Help(ActionSearch),
+		s.keys.Help(ActionCycleSection),
+		s.keys.Help(ActionSelect),
+		s.keys.Help(ActionToggle),
+		s.keys.Help(ActionBack),
+	)
+	if s.filtering {
+		shortcuts = fmt.Sprintf("Type to search settings  [%s] Done  [%s] Clear",
+			s.keys.Help(ActionSelect),
+			s.keys.Help(ActionBack),
+		)
+	}
 	
 	return style.Render(shortcuts)
 }
@@ -327,13 +429,20 @@ func (s *SettingsView) buildSettingsSections() []SettingsSection {
 			},
 		},
 		{
-			Name: "Security",
+			Name: sectionSecurity,
 			Items: []SettingsItem{
 				{
-					Name:    "Auto-accept keys",
-					Value:   s.config.Security.AutoAcceptKeys,
-					Type:    SettingsTypeBool,
-					Options: []string{"No", "Ask", "Yes"},
+					Name:        settingAppearOffline,
+					Value:       s.config.Security.Invisible,
+					Type:        SettingsTypeBool,
+					Description: "Hide your online status; messages are still sent and received",
+				},
+				{
+					Name:        settingAutoAcceptKeys,
+					Value:       keyAcceptanceLabels[s.config.Security.AutoAcceptKeys],
+					Type:        SettingsTypeSelect,
+					Options:     []string{"No", "Ask", "Yes"},
+					Description: "Whether new and changed contact keys are accepted without verifying them",
 				},
 				{
 					Name:  "Message retention",
@@ -363,10 +472,16 @@ func (s *SettingsView) buildSettingsSections() []SettingsSection {
 					Options: []string{"dark", "light", "auto"},
 				},
 				{
-					Name:  "Notifications",
+					Name:  settingNotifications,
 					Value: s.config.UI.Notifications,
 					Type:  SettingsTypeBool,
 				},
+				{
+					Name:        settingDoNotDisturb,
+					Value:       s.config.UI.DoNotDisturb,
+					Type:        SettingsTypeBool,
+					Description: "Silence notifications without turning them off",
+				},
 				{
 					Name:  "Sound alerts",
 					Value: s.config.UI.SoundEnabled,
@@ -378,6 +493,13 @@ func (s *SettingsView) buildSettingsSections() []SettingsSection {
 					Type:    SettingsTypeSelect,
 					Options: []string{"15:04", "3:04 PM", "15:04:05"},
 				},
+				{
+					Name:        settingMessageLayout,
+					Value:       string(s.config.UI.MessageLayout),
+					Type:        SettingsTypeSelect,
+					Options:     []string{string(config.LayoutFlat), string(config.LayoutBubbles)},
+					Description: "Flat lines, or boxes with your own messages on the right",
+				},
 			},
 		},
 		{
@@ -399,36 +521,95 @@ func (s *SettingsView) buildSettingsSections() []SettingsSection {
 					Type:    SettingsTypeSelect,
 					Options: []string{"10s", "30s", "60s", "120s"},
 				},
+				{
+					Name:        "Reconnect attempts",
+					Value:       s.reconnectAttempts(),
+					Type:        SettingsTypeSelect,
+					Options:     []string{"3", "5", "10", "25", "Unlimited"},
+					Description: "How often to retry a lost relay connection",
+				},
+				{
+					Name:        "Reconnect delay",
+					Value:       s.config.Network.ReconnectDelay.String(),
+					Type:        SettingsTypeSelect,
+					Options:     []string{"1s", "2s", "5s", "10s", "30s"},
+					Description: "Wait before the first retry; each retry waits longer, up to a minute",
+				},
 			},
 		},
 	}
 }
 
-// navigateUp moves selection up within current section
-func (s *SettingsView) navigateUp() {
-	if s.selectedItem > 0 {
-		s.selectedItem--
-	} else if s.selectedSection > 0 {
-		s.selectedSection--
-		s.selectedItem = len(s.sections[s.selectedSection].Items) - 1
+// reconnectAttempts formats the reconnect attempt limit for display
+func (s *SettingsView) reconnectAttempts() string {
+	if s.config.Network.ReconnectUnlimited {
+		return "Unlimited"
 	}
+	return strconv.Itoa(s.config.Network.MaxReconnectAttempts)
 }
 
-// navigateDown moves selection down within current section
+// navigateUp moves selection to the shown setting above, in the previous
+// section at the top of one
+func (s *SettingsView) navigateUp() {
+	s.navigate(-1)
+}
+
+// navigateDown moves selection to the shown setting below, in the next
+// section at the bottom of one
 func (s *SettingsView) navigateDown() {
-	if s.selectedItem < len(s.sections[s.selectedSection].Items)-1 {
-		s.selectedItem++
-	} else if s.selectedSection < len(s.sections)-1 {
-		s.selectedSection++
-		s.selectedItem = 0
+	s.navigate(1)
+}
+
+// navigate moves selection by delta among the shown settings, stopping at
+// the first and last. A selection the filter hides moves to the first.
+func (s *SettingsView) navigate(delta int) {
+	visible := s.visibleSettings()
+	if len(visible) == 0 {
+		return
+	}
+	i := s.selectedVisible(visible)
+	if i < 0 {
+		s.selectSetting(visible[0])
+		return
 	}
+	s.selectSetting(visible[max(0, min(i+delta, len(visible)-1))])
+}
+
+// navigateSection selects the first shown setting of the section delta
+// sections away among those with settings shown, wrapping around the ends
+// if wrap is set
+func (s *SettingsView) navigateSection(delta int, wrap bool) {
+	var firsts []settingPos
+	current := -1
+	for _, pos := range s.visibleSettings() {
+		if len(firsts) == 0 || firsts[len(firsts)-1].section != pos.section {
+			firsts = append(firsts, pos)
+		}
+		if pos.section == s.selectedSection {
+			current = len(firsts) - 1
+		}
+	}
+	if len(firsts) == 0 {
+		return
+	}
+
+	next := current + delta
+	switch {
+	case current < 0:
+		next = 0
+	case wrap:
+		next = (next + len(firsts)) % len(firsts)
+	case next < 0 || next >= len(firsts):
+		return
+	}
+	s.selectSetting(firsts[next])
 }
 
 // activateCurrentItem activates the currently selected item
 func (s *SettingsView) activateCurrentItem() {
 	item := &s.sections[s.selectedSection].Items[s.selectedItem]
 	
-	if item.ReadOnly {
+	if item.ReadOnly || !s.selectedMatches() {
 		return
 	}
 	
@@ -449,7 +630,7 @@ func (s *SettingsView) activateCurrentItem() {
 func (s *SettingsView) toggleCurrentItem() {
 	item := &s.sections[s.selectedSection].Items[s.selectedItem]
 	
-	if item.ReadOnly {
+	if item.ReadOnly || !s.selectedMatches() {
 		return
 	}
 	
@@ -479,8 +660,68 @@ func (s *SettingsView) saveEditValue() {
 	s.updateConfig(item)
 }
 
+// sectionSecurity is the section the security overview is shown in
+const sectionSecurity = "Security"
+
+// Names of settings that are applied as they change
+const (
+	settingAppearOffline  = "Appear offline"
+	settingAutoAcceptKeys = "Auto-accept keys"
+	settingNotifications  = "Notifications"
+	settingDoNotDisturb   = "Do not disturb"
+	settingMessageLayout  = "Message layout"
+)
+
+// keyAcceptanceLabels holds the option shown for each key acceptance mode
+var keyAcceptanceLabels = map[config.KeyAcceptance]string{
+	config.KeyAcceptNever:  "No",
+	config.KeyAcceptAsk:    "Ask",
+	config.KeyAcceptAlways: "Yes",
+}
+
+// findItem returns the setting with the given name, or nil
+func (s *SettingsView) findItem(name string) *SettingsItem {
+	for i := range s.sections {
+		for j := range s.sections[i].Items {
+			if s.sections[i].Items[j].Name == name {
+				return &s.sections[i].Items[j]
+			}
+		}
+	}
+	return nil
+}
+
 // updateConfig updates the configuration based on the changed item
 func (s *SettingsView) updateConfig(item *SettingsItem) {
+	switch item.Name {
+	case settingAppearOffline:
+		s.config.Security.Invisible = item.Value.(bool)
+		if s.setVisibility != nil {
+			s.setVisibility(s.config.Security.Invisible)
+		}
+		return
+		
+	case settingAutoAcceptKeys:
+		for mode, label := range keyAcceptanceLabels {
+			if label == item.Value.(string) {
+				s.config.Security.AutoAcceptKeys = mode
+			}
+		}
+		return
+		
+	case settingNotifications:
+		s.config.UI.Notifications = item.Value.(bool)
+		return
+		
+	case settingDoNotDisturb:
+		s.config.UI.DoNotDisturb = item.Value.(bool)
+		return
+		
+	case settingMessageLayout:
+		s.config.UI.MessageLayout = config.MessageLayout(item.Value.(string))
+		return
+	}
+	
 	// TODO: Update the actual config and save to file
 	// This is a simplified version - in a real implementation,
 	// we'd need to map settings back to config fields
@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// settingPos locates a setting by section and item index
+type settingPos struct {
+	section int
+	item    int
+}
+
+// matchesFilter reports whether the name or description of a setting
+// contains the filter, ignoring case. Every setting matches an empty one.
+func (s *SettingsView) matchesFilter(item SettingsItem) bool {
+	if s.filter == "" {
+		return true
+	}
+	filter := strings.ToLower(s.filter)
+	return strings.Contains(strings.ToLower(item.Name), filter) ||
+		strings.Contains(strings.ToLower(item.Description), filter)
+}
+
+// visibleSettings returns the settings the filter lets through, in the
+// order they are shown
+func (s *SettingsView) visibleSettings() []settingPos {
+	var visible []settingPos
+	for i, section := range s.sections {
+		for j, item := range section.Items {
+			if s.matchesFilter(item) {
+				visible = append(visible, settingPos{i, j})
+			}
+		}
+	}
+	return visible
+}
+
+// selectedVisible returns where the selected setting is among the visible
+// ones, or -1 if the filter hides it
+func (s *SettingsView) selectedVisible(visible []settingPos) int {
+	for i, pos := range visible {
+		if pos == (settingPos{s.selectedSection, s.selectedItem}) {
+			return i
+		}
+	}
+	return -1
+}
+
+// selectSetting selects the setting at pos
+func (s *SettingsView) selectSetting(pos settingPos) {
+	s.selectedSection = pos.section
+	s.selectedItem = pos.item
+}
+
+// jumpToMatch selects the first setting matching the filter, unless the
+// selected one still matches
+func (s *SettingsView) jumpToMatch() {
+	visible := s.visibleSettings()
+	if len(visible) > 0 && s.selectedVisible(visible) < 0 {
+		s.selectSetting(visible[0])
+	}
+}
+
+// handleFilterInput handles keyboard input while the filter is typed.
+// Every change jumps to the first matching setting; enter keeps the filter
+// so the matches can be edited and toggled.
+func (s *SettingsView) handleFilterInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case s.keys.Matches(msg, ActionBack):
+		s.handleBack()
+
+	case s.keys.Matches(msg, ActionSelect):
+		s.filtering = false
+
+	case s.keys.Matches(msg, ActionDeleteBackward):
+		if len(s.filter) > 0 {
+			s.filter = s.filter[:len(s.filter)-1]
+		}
+
+	default:
+		if len(msg.String()) == 1 {
+			s.filter += msg.String()
+		}
+	}
+
+	s.jumpToMatch()
+	return s, nil
+}
+
+// selectedMatches reports whether the selected setting is shown, so it may
+// be edited or toggled
+func (s *SettingsView) selectedMatches() bool {
+	return s.matchesFilter(s.sections[s.selectedSection].Items[s.selectedItem])
+}
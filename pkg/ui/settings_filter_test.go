@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+// filterSettings opens the filter of a settings view and types text into it
+func filterSettings(s *SettingsView, text string) {
+	s.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")})
+	for _, r := range text {
+		s.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+}
+
+// visibleNames returns the names of the settings the filter lets through
+func visibleNames(s *SettingsView) []string {
+	var names []string
+	for _, pos := range s.visibleSettings() {
+		names = append(names, s.sections[pos.section].Items[pos.item].Name)
+	}
+	return names
+}
+
+func TestSettingsFilterMatchesNamesAndDescriptions(t *testing.T) {
+	s := NewSettingsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	filterSettings(s, "RECONNECT")
+
+	names := visibleNames(s)
+	if len(names) != 2 || names[0] != "Reconnect attempts" || names[1] != "Reconnect delay" {
+		t.Errorf("settings matching RECONNECT = %v, want the reconnect attempts and delay", names)
+	}
+	if selected := s.sections[s.selectedSection].Items[s.selectedItem].Name; selected != "Reconnect attempts" {
+		t.Errorf("selected %q, want the first match", selected)
+	}
+
+	// The appear-offline setting only mentions messages in its description
+	s = NewSettingsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	filterSettings(s, "still sent")
+	if names := visibleNames(s); len(names) != 1 || names[0] != settingAppearOffline {
+		t.Errorf("settings matching a description = %v, want %s", names, settingAppearOffline)
+	}
+}
+
+func TestSettingsFilterWithoutMatches(t *testing.T) {
+	s := NewSettingsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	s.Update(tea.WindowSizeMsg{Width: 100, Height: 40})
+	filterSettings(s, "theme")
+	selected := settingPos{s.selectedSection, s.selectedItem}
+	for _, r := range "xyz" {
+		s.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+
+	if names := visibleNames(s); len(names) != 0 {
+		t.Errorf("settings matching nothing = %v, want none", names)
+	}
+	if (settingPos{s.selectedSection, s.selectedItem}) != selected {
+		t.Error("a filter matching nothing moved the selection")
+	}
+	if s.selectedMatches() {
+		t.Error("the hidden selected setting may still be edited")
+	}
+	for _, section := range s.sections {
+		if strings.Contains(s.renderContent(), section.Name) {
+			t.Errorf("section %s is shown without matching settings", section.Name)
+		}
+	}
+}
+
+func TestSettingsFilterIsCleared(t *testing.T) {
+	s := NewSettingsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	all := len(visibleNames(s))
+	filterSettings(s, "theme")
+	s.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if s.filtering || s.filter != "theme" {
+		t.Fatalf("enter left filtering %v with filter %q, want the filter kept", s.filtering, s.filter)
+	}
+
+	// Deleting characters widens the filter again
+	filterSettings(s, "")
+	for range "theme" {
+		s.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	if got := len(visibleNames(s)); got != all {
+		t.Errorf("%d settings shown after deleting the filter, want all %d", got, all)
+	}
+	s.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	filterSettings(s, "theme")
+	if s.filter != "theme" {
+		t.Fatalf("filter = %q, want theme", s.filter)
+	}
+	if !s.handleBack() {
+		t.Fatal("back did not clear the filter")
+	}
+	if s.filtering || s.filter != "" || len(visibleNames(s)) != all {
+		t.Errorf("after back: filtering %v, filter %q, %d shown; want every setting", s.filtering, s.filter, len(visibleNames(s)))
+	}
+	if selected := s.sections[s.selectedSection].Items[s.selectedItem].Name; selected != "Theme" {
+		t.Errorf("selected %q after clearing, want the match kept selected", selected)
+	}
+	if s.handleBack() {
+		t.Error("back with nothing to clear was handled")
+	}
+}
@@ -0,0 +1,159 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// SecurityStatusLookup returns the current security overview
+type SecurityStatusLookup func() models.SecurityStatus
+
+// SecurityStatusMsg delivers the security overview to the settings view
+type SecurityStatusMsg struct {
+	Status models.SecurityStatus
+}
+
+// securityLevel is how much a line of the security overview needs the
+// user's attention
+type securityLevel int
+
+const (
+	securityOK securityLevel = iota
+	securityWarning
+	securityAlert
+)
+
+// securityLine is one indicator of the security overview, with what to do
+// about it if anything
+type securityLine struct {
+	level  securityLevel
+	text   string
+	action string
+}
+
+// SetSecurityStatusLookup sets the function the settings view gets the
+// security overview from
+func (s *SettingsView) SetSecurityStatusLookup(lookup SecurityStatusLookup) {
+	s.securityStatus = lookup
+}
+
+// refreshSecurityStatus looks up the security overview, which is shown
+// once it arrives
+func (s *SettingsView) refreshSecurityStatus() tea.Cmd {
+	lookup := s.securityStatus
+	if lookup == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return SecurityStatusMsg{Status: lookup()}
+	}
+}
+
+// securityLines turns the security overview into indicators, worst first
+// within each topic
+func (s *SettingsView) securityLines(status models.SecurityStatus) []securityLine {
+	contacts := fmt.Sprintf("in contacts (%s)", s.keys.Help(ActionShowContacts))
+	var lines []securityLine
+
+	switch {
+	case status.Contacts == 0:
+		lines = append(lines, securityLine{securityOK, "No contacts to verify", ""})
+	case status.Unverified() > 0:
+		lines = append(lines, securityLine{securityWarning,
+			fmt.Sprintf("%d of %d contacts unverified", status.Unverified(), status.Contacts),
+			"verify " + contacts})
+	default:
+		lines = append(lines, securityLine{securityOK,
+			fmt.Sprintf("All %d contacts verified", status.Contacts), ""})
+	}
+
+	if status.KeyChanges > 0 {
+		lines = append(lines, securityLine{securityAlert,
+			fmt.Sprintf("%d contact(s) changed keys since verification", status.KeyChanges),
+			"verify again " + contacts})
+	}
+	if status.PendingKeys > 0 {
+		lines = append(lines, securityLine{securityAlert,
+			fmt.Sprintf("%d new key(s) awaiting your decision", status.PendingKeys),
+			"accept or reject in their chat"})
+	}
+	if status.KeyChanges == 0 && status.PendingKeys == 0 {
+		lines = append(lines, securityLine{securityOK, "No key change warnings", ""})
+	}
+
+	if status.Downgraded > 0 {
+		lines = append(lines, securityLine{securityAlert,
+			fmt.Sprintf("%d of %d sessions received plaintext messages", status.Downgraded, status.Sessions),
+			"check the chats marked plaintext"})
+	} else {
+		lines = append(lines, securityLine{securityOK,
+			fmt.Sprintf("%d encrypted session(s) established", status.Sessions), ""})
+	}
+
+	switch {
+	case status.Relays == 0:
+		lines = append(lines, securityLine{securityWarning, "No relays configured", "add network.relay_servers"})
+	case status.TLSRelays < status.Relays:
+		lines = append(lines, securityLine{securityWarning,
+			fmt.Sprintf("%d of %d relays reached without TLS", status.Relays-status.TLSRelays, status.Relays),
+			"use wss:// addresses in network.relay_servers"})
+	}
+	switch {
+	case status.Relays == 0:
+	case status.PinnedRelays < status.Relays:
+		lines = append(lines, securityLine{securityWarning,
+			fmt.Sprintf("%d of %d relays not pinned", status.Relays-status.PinnedRelays, status.Relays),
+			"set network.relay_fingerprints"})
+	case status.TLSRelays == status.Relays:
+		lines = append(lines, securityLine{securityOK,
+			fmt.Sprintf("All %d relays use TLS and are pinned", status.Relays), ""})
+	default:
+		lines = append(lines, securityLine{securityOK,
+			fmt.Sprintf("All %d relays are pinned", status.Relays), ""})
+	}
+
+	if status.RetentionDays == 0 {
+		lines = append(lines, securityLine{securityWarning, "Messages are kept forever", "set Message retention below"})
+	} else {
+		lines = append(lines, securityLine{securityOK,
+			fmt.Sprintf("Messages are deleted after %d days", status.RetentionDays), ""})
+	}
+
+	if status.AutoAcceptKeys == "always" {
+		lines = append(lines, securityLine{securityAlert,
+			"New and changed keys are accepted without asking", "change Auto-accept keys below"})
+	}
+	return lines
+}
+
+// renderSecurityStatus renders the security overview shown at the top of
+// the security section, each line with an indicator colored by how much it
+// needs attention and what to do about it
+func (s *SettingsView) renderSecurityStatus() string {
+	if !s.statusLoaded {
+		return ""
+	}
+
+	colors := map[securityLevel]lipgloss.Color{
+		securityOK:      s.theme.Success,
+		securityWarning: s.theme.Warning,
+		securityAlert:   s.theme.Error,
+	}
+	textStyle := lipgloss.NewStyle().Foreground(s.theme.Foreground)
+	actionStyle := lipgloss.NewStyle().Foreground(s.theme.Secondary)
+
+	var rendered []string
+	for _, line := range s.securityLines(s.status) {
+		indicator := lipgloss.NewStyle().Foreground(colors[line.level]).Render("●")
+		text := " │  " + indicator + " " + textStyle.Render(line.text)
+		if line.action != "" {
+			text += actionStyle.Render(" → " + line.action)
+		}
+		rendered = append(rendered, text)
+	}
+	return strings.Join(rendered, "\n")
+}
@@ -0,0 +1,111 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+)
+
+// securedStatus returns the overview of a user with nothing to worry about
+func securedStatus() models.SecurityStatus {
+	return models.SecurityStatus{
+		Contacts:       3,
+		Verified:       3,
+		Sessions:       3,
+		Relays:         1,
+		TLSRelays:      1,
+		PinnedRelays:   1,
+		RetentionDays:  30,
+		AutoAcceptKeys: "ask",
+	}
+}
+
+func TestSecurityOverviewStates(t *testing.T) {
+	contacts := "in contacts (" + DefaultKeyMap().Help(ActionShowContacts) + ")"
+	tests := []struct {
+		name   string
+		change func(status *models.SecurityStatus)
+		want   securityLine // The line the state shows
+		absent string       // A line the state does not show
+	}{
+		{
+			"verified",
+			func(status *models.SecurityStatus) {},
+			securityLine{securityOK, "All 3 contacts verified", ""},
+			"unverified",
+		},
+		{
+			"unverified",
+			func(status *models.SecurityStatus) { status.Verified = 1 },
+			securityLine{securityWarning, "2 of 3 contacts unverified", "verify " + contacts},
+			"All 3 contacts verified",
+		},
+		{
+			"key changed",
+			func(status *models.SecurityStatus) { status.Verified, status.KeyChanges = 2, 1 },
+			securityLine{securityAlert, "1 contact(s) changed keys since verification", "verify again " + contacts},
+			"No key change warnings",
+		},
+		{
+			"no sessions",
+			func(status *models.SecurityStatus) { status.Sessions = 0 },
+			securityLine{securityOK, "0 encrypted session(s) established", ""},
+			"received plaintext",
+		},
+		{
+			"downgraded session",
+			func(status *models.SecurityStatus) { status.Downgraded = 1 },
+			securityLine{securityAlert, "1 of 3 sessions received plaintext messages", "check the chats marked plaintext"},
+			"encrypted session(s) established",
+		},
+		{
+			"no contacts",
+			func(status *models.SecurityStatus) { status.Contacts, status.Verified = 0, 0 },
+			securityLine{securityOK, "No contacts to verify", ""},
+			"verified",
+		},
+	}
+
+	s := NewSettingsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := securedStatus()
+			tt.change(&status)
+			lines := s.securityLines(status)
+
+			found := false
+			for _, line := range lines {
+				if line == tt.want {
+					found = true
+				}
+				if strings.Contains(line.text, tt.absent) {
+					t.Errorf("overview shows %q, want no line with %q", line.text, tt.absent)
+				}
+			}
+			if !found {
+				t.Errorf("overview %+v has no line %+v", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurityOverviewIsShownOnceLoaded(t *testing.T) {
+	s := NewSettingsView(config.Default(), getTheme("dark"), DefaultKeyMap())
+	if overview := s.renderSecurityStatus(); overview != "" {
+		t.Errorf("overview before the status arrived = %q, want nothing", overview)
+	}
+
+	status := securedStatus()
+	status.KeyChanges = 1
+	s.SetSecurityStatusLookup(func() models.SecurityStatus { return status })
+	s.Update(s.Init()())
+
+	overview := s.renderSecurityStatus()
+	for _, want := range []string{"All 3 contacts verified", "1 contact(s) changed keys since verification", "→ verify again"} {
+		if !strings.Contains(overview, want) {
+			t.Errorf("overview %q does not show %q", overview, want)
+		}
+	}
+}
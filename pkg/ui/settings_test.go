@@ -0,0 +1,49 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/opensourceghana/securechat/internal/config"
+)
+
+// settingsItem returns the item named name
+func settingsItem(t *testing.T, s *SettingsView, name string) *SettingsItem {
+	t.Helper()
+
+	for i := range s.sections {
+		for j := range s.sections[i].Items {
+			if item := &s.sections[i].Items[j]; item.Name == name {
+				return item
+			}
+		}
+	}
+	t.Fatalf("no %q setting", name)
+	return nil
+}
+
+func TestAutoAcceptKeysSettingKeepsEachMode(t *testing.T) {
+	modes := map[config.KeyAcceptance]string{
+		config.KeyAcceptNever:  "No",
+		config.KeyAcceptAsk:    "Ask",
+		config.KeyAcceptAlways: "Yes",
+	}
+
+	for mode, label := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Security.AutoAcceptKeys = mode
+			s := NewSettingsView(cfg, getTheme("dark"), DefaultKeyMap())
+			item := settingsItem(t, s, settingAutoAcceptKeys)
+			if item.Value != label {
+				t.Errorf("setting shows %v, want %s", item.Value, label)
+			}
+
+			// Choosing the option sets the mode again from another one
+			cfg.Security.AutoAcceptKeys = ""
+			s.updateConfig(item)
+			if cfg.Security.AutoAcceptKeys != mode {
+				t.Errorf("choosing %s set %q, want %q", label, cfg.Security.AutoAcceptKeys, mode)
+			}
+		})
+	}
+}
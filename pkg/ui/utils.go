@@ -1,74 +1,150 @@
 package ui
 
 import (
+	"encoding/base32"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/internal/textwidth"
+	"github.com/rivo/uniseg"
 )
 
-// formatLastSeen formats a timestamp into a human-readable "last seen" string
-func formatLastSeen(lastSeen time.Time) string {
-	now := time.Now()
-	duration := now.Sub(lastSeen)
+// formatContactLastSeen describes when a contact was last online, respecting
+// a contact who hides their last-seen time. It returns "" when unknown.
+func formatContactLastSeen(contact models.Contact, now time.Time, location *time.Location) string {
+	switch {
+	case contact.IsOnline():
+		return "Online now"
+	case contact.LastSeenHidden:
+		return "Last seen recently"
+	case contact.LastSeen.IsZero():
+		return ""
+	default:
+		return "Last seen " + formatLastSeen(contact.LastSeen, now, location)
+	}
+}
+
+// formatFingerprint shows the first bytes of a fingerprint as the UI
+// config says, in groups of four characters. A fingerprint that is not hex
+// is shown as it is, and a legacy one in full as it is shorter.
+func formatFingerprint(fingerprint string, cfg config.UIConfig) string {
+	raw, err := hex.DecodeString(fingerprint)
+	if err != nil || len(raw) == 0 {
+		return fingerprint
+	}
+	raw = raw[:min(len(raw), cfg.FingerprintBytes)]
+	
+	var text string
+	switch cfg.FingerprintFormat {
+	case config.FingerprintBase32:
+		text = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	default:
+		text = hex.EncodeToString(raw)
+	}
 	
+	groups := make([]string, 0, (len(text)+3)/4)
+	for len(text) > 4 {
+		groups = append(groups, text[:4])
+		text = text[4:]
+	}
+	return strings.Join(append(groups, text), " ")
+}
+
+// formatLastSeen formats a timestamp into a human-readable "last seen"
+// string, with a date in location once it is a month old
+func formatLastSeen(lastSeen, now time.Time, location *time.Location) string {
+	if relative, ok := formatRelative(now.Sub(lastSeen)); ok {
+		return relative
+	}
+	return lastSeen.In(location).Format("Jan 2, 2006")
+}
+
+// formatTimestamp formats a message time in the configured timezone and
+// format, or relative to now while it is younger than the configured
+// relative timestamps duration
+func formatTimestamp(t, now time.Time, cfg config.UIConfig, location *time.Location) string {
+	if age := now.Sub(t); age < cfg.RelativeTimestamps {
+		if relative, ok := formatRelative(age); ok {
+			return relative
+		}
+	}
+	return t.In(location).Format(cfg.TimestampFormat)
+}
+
+// displayLocation returns the configured display timezone. An invalid
+// zone, which Config.Validate reports at startup, falls back to local time.
+func displayLocation(cfg config.UIConfig) *time.Location {
+	location, err := cfg.Location()
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// formatRelative describes a duration in the past such as "5 minutes ago".
+// It returns false for a month or more, which reads better as a date.
+func formatRelative(duration time.Duration) (string, bool) {
 	switch {
 	case duration < time.Minute:
-		return "just now"
+		return "just now", true
 	case duration < time.Hour:
 		minutes := int(duration.Minutes())
 		if minutes == 1 {
-			return "1 minute ago"
+			return "1 minute ago", true
 		}
-		return fmt.Sprintf("%d minutes ago", minutes)
+		return fmt.Sprintf("%d minutes ago", minutes), true
 	case duration < 24*time.Hour:
 		hours := int(duration.Hours())
 		if hours == 1 {
-			return "1 hour ago"
+			return "1 hour ago", true
 		}
-		return fmt.Sprintf("%d hours ago", hours)
+		return fmt.Sprintf("%d hours ago", hours), true
 	case duration < 7*24*time.Hour:
 		days := int(duration.Hours() / 24)
 		if days == 1 {
-			return "1 day ago"
+			return "1 day ago", true
 		}
-		return fmt.Sprintf("%d days ago", days)
+		return fmt.Sprintf("%d days ago", days), true
 	case duration < 30*24*time.Hour:
 		weeks := int(duration.Hours() / (24 * 7))
 		if weeks == 1 {
-			return "1 week ago"
+			return "1 week ago", true
 		}
-		return fmt.Sprintf("%d weeks ago", weeks)
+		return fmt.Sprintf("%d weeks ago", weeks), true
 	default:
-		return lastSeen.Format("Jan 2, 2006")
+		return "", false
 	}
 }
 
-// truncateString truncates a string to the specified length with ellipsis
+// truncateString truncates a string to at most maxLen terminal cells,
+// ending with an ellipsis if anything was cut, as textwidth.Truncate does
 func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	
-	if maxLen <= 3 {
-		return s[:maxLen]
-	}
-	
-	return s[:maxLen-3] + "..."
+	return textwidth.Truncate(s, maxLen)
 }
 
-// centerString centers a string within the given width
+// centerString centers a string within the given width, measured in
+// terminal cells
 func centerString(s string, width int) string {
-	if len(s) >= width {
+	if lipgloss.Width(s) >= width {
 		return s
 	}
 	
-	padding := width - len(s)
+	padding := width - lipgloss.Width(s)
 	leftPad := padding / 2
 	rightPad := padding - leftPad
 	
 	return fmt.Sprintf("%*s%s%*s", leftPad, "", s, rightPad, "")
 }
 
-// wrapText wraps text to fit within the specified width
+// wrapText wraps text to fit within the specified width, measured in
+// terminal cells. Words wider than a line are broken between characters as
+// the reader sees them; only a single character wider than the line, such
+// as a CJK character on a line one cell wide, overflows it.
 func wrapText(text string, width int) []string {
 	if width <= 0 {
 		return []string{text}
@@ -83,13 +159,15 @@ func wrapText(text string, width int) []string {
 	var currentLine string
 	
 	for _, word := range words {
-		if currentLine == "" {
-			currentLine = word
-		} else if len(currentLine)+1+len(word) <= width {
-			currentLine += " " + word
-		} else {
-			lines = append(lines, currentLine)
-			currentLine = word
+		for _, piece := range breakWord(word, width) {
+			if currentLine == "" {
+				currentLine = piece
+			} else if lipgloss.Width(currentLine)+1+lipgloss.Width(piece) <= width {
+				currentLine += " " + piece
+			} else {
+				lines = append(lines, currentLine)
+				currentLine = piece
+			}
 		}
 	}
 	
@@ -100,6 +178,31 @@ func wrapText(text string, width int) []string {
 	return lines
 }
 
+// breakWord splits a word into pieces at most width cells wide, cutting
+// only between grapheme clusters
+func breakWord(word string, width int) []string {
+	if lipgloss.Width(word) <= width {
+		return []string{word}
+	}
+	
+	var pieces []string
+	start, used := 0, 0
+	graphemes := uniseg.NewGraphemes(word)
+	for graphemes.Next() {
+		from, to := graphemes.Positions()
+		w := lipgloss.Width(graphemes.Str())
+		if used > 0 && used+w > width {
+			pieces = append(pieces, word[start:from])
+			start, used = from, 0
+		}
+		used += w
+		if to == len(word) {
+			pieces = append(pieces, word[start:])
+		}
+	}
+	return pieces
+}
+
 // splitWords splits text into words, preserving whitespace
 func splitWords(text string) []string {
 	var words []string
@@ -155,21 +258,7 @@ func formatDuration(d time.Duration) string {
 
 // isValidUserID checks if a user ID is valid
 func isValidUserID(userID string) bool {
-	if len(userID) < 3 || len(userID) > 50 {
-		return false
-	}
-	
-	// Check for valid characters (alphanumeric, underscore, hyphen)
-	for _, char := range userID {
-		if !((char >= 'a' && char <= 'z') ||
-			(char >= 'A' && char <= 'Z') ||
-			(char >= '0' && char <= '9') ||
-			char == '_' || char == '-') {
-			return false
-		}
-	}
-	
-	return true
+	return models.ValidateUserID(models.NormalizeUserID(userID)) == nil
 }
 
 // sanitizeInput sanitizes user input by removing control characters
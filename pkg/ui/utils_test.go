@@ -0,0 +1,266 @@
+package ui
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rivo/uniseg"
+
+	"github.com/opensourceghana/securechat/internal/config"
+	"github.com/opensourceghana/securechat/internal/models"
+	"github.com/opensourceghana/securechat/pkg/crypto"
+)
+
+func TestFormatContactLastSeen(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		contact models.Contact
+		want    string
+	}{
+		{"online", models.Contact{Status: models.UserStatusOnline, LastSeen: now}, "Online now"},
+		{"offline", models.Contact{Status: models.UserStatusOffline, LastSeen: now.Add(-5 * time.Minute)}, "Last seen 5 minutes ago"},
+		{"long ago", models.Contact{Status: models.UserStatusOffline, LastSeen: now.AddDate(0, -2, 0)}, "Last seen Jan 1, 2026"},
+		{"hidden", models.Contact{Status: models.UserStatusOffline, LastSeenHidden: true}, "Last seen recently"},
+		{"hidden with a stale time", models.Contact{Status: models.UserStatusOffline, LastSeen: now, LastSeenHidden: true}, "Last seen recently"},
+		{"unknown", models.Contact{Status: models.UserStatusOffline}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatContactLastSeen(tt.contact, now, time.UTC); got != tt.want {
+				t.Errorf("formatContactLastSeen = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+	tests := []struct {
+		name     string
+		age      time.Duration
+		relative time.Duration
+		want     string
+	}{
+		{"absolute in the zone", 5 * time.Minute, 0, "Mar 1 20:55"},
+		{"relative while young", 5 * time.Minute, time.Hour, "5 minutes ago"},
+		{"just before the crossover", time.Hour - time.Second, time.Hour, "59 minutes ago"},
+		{"at the crossover", time.Hour, time.Hour, "Mar 1 20:00"},
+		{"past the crossover", 3 * time.Hour, time.Hour, "Mar 1 18:00"},
+		{"too old for relative times", 40 * 24 * time.Hour, 60 * 24 * time.Hour, "Jan 20 21:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.UIConfig{TimestampFormat: "Jan 2 15:04", RelativeTimestamps: tt.relative}
+			if got := formatTimestamp(now.Add(-tt.age), now, cfg, tokyo); got != tt.want {
+				t.Errorf("formatTimestamp = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisplayLocation(t *testing.T) {
+	tests := []struct {
+		timezone string
+		want     string
+	}{
+		{"", "Local"},
+		{"local", "Local"},
+		{"UTC", "UTC"},
+		{"Asia/Tokyo", "Asia/Tokyo"},
+		{"Mars/Olympus_Mons", "Local"},
+	}
+
+	for _, tt := range tests {
+		if got := displayLocation(config.UIConfig{Timezone: tt.timezone}).String(); got != tt.want {
+			t.Errorf("displayLocation(%q) = %s, want %s", tt.timezone, got, tt.want)
+		}
+	}
+	if _, err := (config.UIConfig{Timezone: "Mars/Olympus_Mons"}).Location(); err == nil {
+		t.Error("Location accepted an unknown zone")
+	}
+}
+
+func TestFormatFingerprint(t *testing.T) {
+	full := make([]byte, 32)
+	for i := range full {
+		full[i] = byte(i)
+	}
+	fingerprint := hex.EncodeToString(full)
+
+	tests := []struct {
+		bytes       int
+		format      config.FingerprintFormat
+		fingerprint string
+		want        string
+	}{
+		{8, config.FingerprintHex, fingerprint, "0001 0203 0405 0607"},
+		{16, config.FingerprintHex, fingerprint, "0001 0203 0405 0607 0809 0a0b 0c0d 0e0f"},
+		{32, config.FingerprintHex, fingerprint, "0001 0203 0405 0607 0809 0a0b 0c0d 0e0f 1011 1213 1415 1617 1819 1a1b 1c1d 1e1f"},
+		{8, config.FingerprintBase32, fingerprint, "AAAQ EAYE AUDA O"},
+		{16, config.FingerprintBase32, fingerprint, "AAAQ EAYE AUDA OCAJ BIFQ YDIO B4"},
+		{32, config.FingerprintBase32, fingerprint, "AAAQ EAYE AUDA OCAJ BIFQ YDIO B4IB CEQT CQKR MFYY DENB WHA5 DYPQ"},
+		// A legacy fingerprint is shorter than asked for, so shown in full
+		{16, config.FingerprintHex, fingerprint[:16], "0001 0203 0405 0607"},
+		{16, config.FingerprintHex, "not-hex", "not-hex"},
+		{16, config.FingerprintHex, "", ""},
+	}
+
+	for _, tt := range tests {
+		cfg := config.UIConfig{FingerprintBytes: tt.bytes, FingerprintFormat: tt.format}
+		if got := formatFingerprint(tt.fingerprint, cfg); got != tt.want {
+			t.Errorf("%d bytes in %s: formatFingerprint(%.16s…) = %q, want %q", tt.bytes, tt.format, tt.fingerprint, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultFingerprintDisplayResistsCollisions(t *testing.T) {
+	cfg := config.Default().UI
+	if cfg.FingerprintBytes < 16 {
+		t.Fatalf("the default shows %d bytes, want at least 128 bits to compare", cfg.FingerprintBytes)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		identity, err := crypto.GenerateIdentityKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateIdentityKeyPair: %v", err)
+		}
+		shown := formatFingerprint(identity.Fingerprint, cfg)
+		if got := len(strings.ReplaceAll(shown, " ", "")); got*5 < cfg.FingerprintBytes*8 {
+			t.Fatalf("%q holds %d bits, want %d", shown, got*5, cfg.FingerprintBytes*8)
+		}
+		if seen[shown] {
+			t.Fatalf("two identities are shown as %q", shown)
+		}
+		seen[shown] = true
+	}
+}
+
+// widthSamples are texts whose display width differs from their length in
+// bytes and runes: wide CJK characters, combining marks and emoji built of
+// several code points
+var widthSamples = map[string]string{
+	"ascii":           "the quick brown fox jumps over the lazy dog",
+	"cjk":             "你好世界 こんにちは 안녕하세요 세계",
+	"combining marks": "café naïve résumé à la carte",
+	"zwj emoji":       "family 👩‍👩‍👧‍👦 and 👨🏽‍💻 at work 🏳️‍🌈",
+	"flags":           "🇬🇭🇳🇬🇰🇪 flags 🇬🇭",
+	"mixed":           "Kofi 你好 👋🏽 é test",
+}
+
+// graphemeSet returns the grapheme clusters of s
+func graphemeSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	graphemes := uniseg.NewGraphemes(s)
+	for graphemes.Next() {
+		set[graphemes.Str()] = true
+	}
+	return set
+}
+
+// checkGraphemes fails the test if out holds invalid UTF-8 or a grapheme
+// cluster that is not one of the input's, as splitting one makes
+func checkGraphemes(t *testing.T, out string, clusters map[string]bool) {
+	t.Helper()
+
+	if !utf8.ValidString(out) {
+		t.Errorf("%q is not valid UTF-8", out)
+	}
+	graphemes := uniseg.NewGraphemes(out)
+	for graphemes.Next() {
+		if g := graphemes.Str(); !clusters[g] && g != "." {
+			t.Errorf("%q holds %q, a split character", out, g)
+		}
+	}
+}
+
+func TestTruncateStringByDisplayWidth(t *testing.T) {
+	for name, text := range widthSamples {
+		clusters := graphemeSet(text)
+		for width := 0; width <= lipgloss.Width(text)+1; width++ {
+			got := truncateString(text, width)
+			if w := lipgloss.Width(got); w > width {
+				t.Errorf("%s cut to %d: %q is %d cells wide", name, width, got, w)
+			}
+			checkGraphemes(t, got, clusters)
+			if body := strings.TrimSuffix(got, "..."); !strings.HasPrefix(text, body) {
+				t.Errorf("%s cut to %d: %q does not start the text", name, width, got)
+			}
+		}
+		if got := truncateString(text, lipgloss.Width(text)); got != text {
+			t.Errorf("%s cut to its own width = %q, want it whole", name, got)
+		}
+	}
+
+	if got := truncateString("你好世界", 7); got != "你好..." {
+		t.Errorf("truncateString of wide characters = %q, want 你好...", got)
+	}
+}
+
+func TestCenterStringByDisplayWidth(t *testing.T) {
+	for name, text := range widthSamples {
+		textWidth := lipgloss.Width(text)
+		for _, width := range []int{textWidth - 1, textWidth, textWidth + 1, textWidth + 10} {
+			got := centerString(text, width)
+			if w := lipgloss.Width(got); w != max(width, textWidth) {
+				t.Errorf("%s centered in %d is %d cells wide", name, width, w)
+			}
+			if strings.TrimSpace(got) != text {
+				t.Errorf("%s centered in %d = %q, want the text unchanged", name, width, got)
+			}
+			left := len(got) - len(strings.TrimLeft(got, " "))
+			right := len(got) - len(strings.TrimRight(got, " "))
+			if right-left < 0 || right-left > 1 {
+				t.Errorf("%s centered in %d has %d spaces left and %d right", name, width, left, right)
+			}
+		}
+	}
+}
+
+func TestWrapTextByDisplayWidth(t *testing.T) {
+	for name, text := range widthSamples {
+		clusters := graphemeSet(text)
+		for width := 2; width <= 20; width++ {
+			lines := wrapText(text, width)
+			for _, line := range lines {
+				// Only a single character wider than the line may overflow it
+				if w := lipgloss.Width(line); w > width && uniseg.GraphemeClusterCount(line) > 1 {
+					t.Errorf("%s wrapped to %d: %q is %d cells wide", name, width, line, w)
+				}
+				checkGraphemes(t, strings.ReplaceAll(line, " ", ""), clusters)
+			}
+			if got, want := strings.Join(strings.Fields(strings.Join(lines, "")), ""), strings.Join(strings.Fields(text), ""); got != want {
+				t.Errorf("%s wrapped to %d lost text: %q", name, width, lines)
+			}
+		}
+	}
+
+	if got := wrapText("你好世界", 5); len(got) != 2 || got[0] != "你好" || got[1] != "世界" {
+		t.Errorf("wrapText of a wide word = %q, want it broken into 你好 and 世界", got)
+	}
+}
+
+func TestQuoteFollowsPreviewLength(t *testing.T) {
+	cfg := config.Default()
+	cfg.User.ID = "alice"
+	cfg.UI.PreviewLength = 10
+	c := NewChatView(cfg, getTheme("dark"), DefaultKeyMap())
+	c.Update(tea.WindowSizeMsg{Width: 80, Height: 40})
+	c.OpenChat("bob")
+	parent := &models.Message{ID: "p", From: "bob", ChatID: "alice:bob", Content: "你好世界 and a long tail"}
+	reply := &models.Message{ID: "r", From: "alice", ChatID: "alice:bob", Content: "yes", Metadata: &models.Metadata{ReplyTo: "p"}}
+	c.applyHistory(HistoryLoadedMsg{ChatID: "bob", Messages: []*models.Message{parent, reply}})
+
+	if got := c.renderQuote("p"); !strings.Contains(got, "bob: 你好世...") {
+		t.Errorf("quote = %q, want the parent cut to 10 cells", got)
+	}
+}